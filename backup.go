@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/config"
+	"github.com/discuitnet/discuit/internal/backup"
+	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/migrations"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/videos"
+)
+
+// backupPrefix is the prefix of the names of backup files.
+const backupPrefix = "discuit-backup-"
+
+// The names of the database dumps in backups.
+const (
+	backupMySQLDump  = "db.sql"
+	backupSQLiteDump = "db.sqlite"
+	backupConfigFile = "config.yaml"
+)
+
+func runBackup(ctx context.Context, db *sql.DB, c *config.Config, fs *flag.FlagSet, args []string) error {
+	folder := fs.String("folder", c.BackupFolder, "Folder to save the backup to")
+	keep := fs.Int("keep", c.BackupKeep, "Number of backups to keep (older ones are deleted; 0 keeps all)")
+	noMedia := fs.Bool("no-media", false, "Leave out the images and videos")
+	fs.Parse(args)
+
+	if err := os.MkdirAll(*folder, 0700); err != nil {
+		return err
+	}
+	name := backupPrefix + time.Now().UTC().Format("20060102-150405") + ".tar.gz"
+	if c.BackupPassphrase != "" {
+		name += ".enc"
+	}
+	file := filepath.Join(*folder, name)
+	partial := file + ".partial"
+	if err := writeBackup(ctx, db, c, partial, !*noMedia); err != nil {
+		os.Remove(partial)
+		return err
+	}
+
+	// Verify the backup before it replaces any older ones.
+	manifest, err := verifyBackup(partial, c.BackupPassphrase)
+	if err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("verifying the backup: %w", err)
+	}
+	if err := os.Rename(partial, file); err != nil {
+		return err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	log.Printf("Backup saved to %s (%d files, %d bytes)\n", file, len(manifest.Files), info.Size())
+
+	if *keep > 0 {
+		return rotateBackups(*folder, *keep)
+	}
+	return nil
+}
+
+// writeBackup writes a backup of the database, the config file, and, if media
+// is true, the images and videos to the file at path.
+func writeBackup(ctx context.Context, db *sql.DB, c *config.Config, path string, media bool) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := backup.NewWriter(f, c.BackupPassphrase)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "discuit-backup")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	dump, dumpName := filepath.Join(dir, "dump"), backupMySQLDump
+	if c.DBDriver == msql.DriverSQLite {
+		dumpName = backupSQLiteDump
+	}
+	if err := dumpDatabase(ctx, db, c, dump); err != nil {
+		return fmt.Errorf("dumping the database: %w", err)
+	}
+	if err := w.AddFile(dumpName, dump); err != nil {
+		return err
+	}
+	if err := w.AddFile(backupConfigFile, configPath()); err != nil {
+		return err
+	}
+	if media {
+		if err := w.AddDir("images", images.RootFolder()); err != nil {
+			return err
+		}
+		if err := w.AddDir("videos", videos.RootFolder()); err != nil {
+			return err
+		}
+	}
+
+	version, _, err := migrations.CurrentVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if err := w.Close(backup.Manifest{CreatedAt: time.Now(), DBDriver: c.DBDriver, SchemaVersion: version}); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// mysqlArgs returns the arguments with which the MySQL command line clients
+// connect to the database of c (as the server does; see mysqlDSN). The
+// password is passed in the environment (see mysqlCommand).
+func mysqlArgs(c *config.Config) []string {
+	return []string{"--protocol=tcp", "--host=127.0.0.1", "--port=3306", "--user=" + c.DBUser}
+}
+
+// mysqlCommand returns the command that runs the MySQL client name with args.
+func mysqlCommand(ctx context.Context, c *config.Config, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), "MYSQL_PWD="+c.DBPassword)
+	return cmd
+}
+
+// dumpDatabase writes a snapshot of the database to the file at path: an SQL
+// dump made with mysqldump for MySQL, and a copy of the database file for
+// SQLite.
+func dumpDatabase(ctx context.Context, db *sql.DB, c *config.Config, path string) error {
+	if c.DBDriver == msql.DriverSQLite {
+		_, err := db.ExecContext(ctx, "VACUUM INTO ?", path)
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var stderr bytes.Buffer
+	cmd := mysqlCommand(ctx, c, "mysqldump", append(mysqlArgs(c), "--single-transaction", "--quick", "--routines", "--no-tablespaces", c.DBName)...)
+	cmd.Stdout, cmd.Stderr = f, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("mysqldump: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return f.Close()
+}
+
+// verifyBackup reads the backup at path, checking every file in it against
+// the checksums in its manifest, and returns the manifest.
+func verifyBackup(path, passphrase string) (*backup.Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return backup.Read(f, passphrase, nil)
+}
+
+// rotateBackups deletes all but the last keep backups in folder.
+func rotateBackups(folder string, keep int) error {
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && !strings.HasSuffix(name, ".partial") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names) // The names start with the time of the backup.
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(folder, names[0])); err != nil {
+			return err
+		}
+		log.Printf("Deleted old backup %s\n", names[0])
+		names = names[1:]
+	}
+	return nil
+}
+
+func runRestore(ctx context.Context, db *sql.DB, c *config.Config, fs *flag.FlagSet, args []string) error {
+	file := fs.String("file", "", "Backup file to restore (required)")
+	verifyOnly := fs.Bool("verify", false, "Only verify the backup")
+	noMedia := fs.Bool("no-media", false, "Don't restore the images and videos")
+	withConfig := fs.Bool("with-config", false, "Restore the config file too (overwriting the current one)")
+	yes := fs.Bool("yes", false, "Confirm overwriting the database (and the files) with the backup")
+	fs.Parse(args)
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+
+	manifest, err := verifyBackup(*file, c.BackupPassphrase)
+	if err != nil {
+		return err
+	}
+	log.Printf("Backup of %s (%s, schema version %d, %d files) verified\n", manifest.CreatedAt.Format(time.RFC3339), manifest.DBDriver, manifest.SchemaVersion, len(manifest.Files))
+	if *verifyOnly {
+		return nil
+	}
+	if manifest.DBDriver != c.DBDriver {
+		return fmt.Errorf("the backup is of a %s database, but dbDriver is %s", manifest.DBDriver, c.DBDriver)
+	}
+	if !*yes {
+		return errors.New("restoring overwrites the database; run again with -yes to confirm")
+	}
+
+	// Files replaced at the end, once the whole backup has been read, as they
+	// can't be replaced while in use.
+	replace := make(map[string]string) // Temporary file -> file to replace.
+	defer func() {
+		for tmp := range replace {
+			os.Remove(tmp)
+		}
+	}()
+	writeTemp := func(dst string, r io.Reader) error {
+		tmp := dst + ".restoring"
+		if err := writeFile(tmp, r); err != nil {
+			return err
+		}
+		replace[tmp] = dst
+		return nil
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = backup.Read(f, c.BackupPassphrase, func(name string, r io.Reader) error {
+		switch {
+		case name == backupMySQLDump:
+			var stderr bytes.Buffer
+			cmd := mysqlCommand(ctx, c, "mysql", append(mysqlArgs(c), c.DBName)...)
+			cmd.Stdin, cmd.Stderr = r, &stderr
+			if err := cmd.Run(); err != nil {
+				return fmt.Errorf("mysql: %w: %s", err, strings.TrimSpace(stderr.String()))
+			}
+			log.Println("Database restored")
+		case name == backupSQLiteDump:
+			return writeTemp(c.DBName, r)
+		case name == backupConfigFile:
+			if *withConfig {
+				return writeTemp(configPath(), r)
+			}
+		case strings.HasPrefix(name, "images/") || strings.HasPrefix(name, "videos/"):
+			if *noMedia {
+				return nil
+			}
+			root := images.RootFolder()
+			if strings.HasPrefix(name, "videos/") {
+				root = videos.RootFolder()
+			}
+			rel := path.Clean(name[strings.Index(name, "/")+1:])
+			if rel == "." || path.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+				return fmt.Errorf("invalid file name in backup: %s", name)
+			}
+			return writeFile(filepath.Join(root, filepath.FromSlash(rel)), r)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.DBDriver == msql.DriverSQLite {
+		if err := db.Close(); err != nil {
+			return err
+		}
+		for _, suffix := range []string{"-wal", "-shm"} {
+			if err := os.Remove(c.DBName + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	for tmp, dst := range replace {
+		if err := os.Rename(tmp, dst); err != nil {
+			return err
+		}
+		delete(replace, tmp)
+		log.Printf("Restored %s\n", dst)
+	}
+	log.Println("Restore complete (run discuit -migrate if the backup is of an older version)")
+	return nil
+}
+
+// writeFile writes the contents of r to the file at path, creating its folder
+// if needed.
+func writeFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/config"
+	"github.com/discuitnet/discuit/core"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/tracing"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/server"
+)
+
+// A command is a subcommand of the binary (as in discuit ban-user -user
+// name), for managing the site from scripts, cron jobs, and runbooks.
+type command struct {
+	help string
+	run  func(ctx context.Context, db *sql.DB, c *config.Config, fs *flag.FlagSet, args []string) error
+}
+
+var commands = map[string]command{
+	"create-admin":     {"Create a user that's an admin (a password is generated if -password is not set)", runCreateAdmin},
+	"reset-password":   {"Set the password of a user (a password is generated if -password is not set) and log them out", runResetPassword},
+	"ban-user":         {"Ban a user from the site and log them out (or, with -unban, unban them)", runBanUser},
+	"delete-community": {"Delete a community", runDeleteCommunity},
+	"recount-stats":    {"Recount the numbers of members, posts, and comments, and fix those that are off", runRecountStats},
+	"check-comments":   {"Check the trees of comments (their ancestors, depths, and numbers of replies) and report the anomalies (or, with -repair, repair them)", runCheckComments},
+	"prune-sessions":   {"Remove expired sessions from the lists of the sessions of users", runPruneSessions},
+	"maintenance":      {"Put the site in read-only mode (-on) or take it out (-off), or show its maintenance mode", runMaintenance},
+	"backup":           {"Back up the database, the config file, and the images and videos (see backupFolder)", runBackup},
+	"restore":          {"Restore a backup made with the backup command (it's verified first)", runRestore},
+	"anonymize":        {"Copy the database with usernames, emails, IP addresses, and other private data scrambled (for development and reproducing bugs)", runAnonymize},
+	"seed":             {"Fill the database with made-up users, communities, posts, comments, and votes (for development, demos, and load testing)", runSeed},
+	"loadtest":         {"Send a mix of feed and thread reads, votes, and comments to a running site, and report the latencies of each route", runLoadtest},
+}
+
+// runCommand runs the command name with args, if there's one, and reports
+// whether there was.
+func runCommand(db *sql.DB, c *config.Config, name string, args []string) bool {
+	cmd, ok := commands[name]
+	if !ok {
+		return false
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s: %s\n", name, cmd.help)
+		fs.PrintDefaults()
+	}
+	if err := cmd.run(context.Background(), db, c, fs, args); err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+	return true
+}
+
+// printCommands prints the list of commands (for the usage message).
+func printCommands() {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(flag.CommandLine.Output(), "\nCommands (run discuit <command> -h for their flags):")
+	for _, name := range names {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %-18s %s\n", name, commands[name].help)
+	}
+}
+
+// randomPassword returns a new random password.
+func randomPassword() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func runCreateAdmin(ctx context.Context, db *sql.DB, c *config.Config, fs *flag.FlagSet, args []string) error {
+	username := fs.String("user", "", "Username (required)")
+	email := fs.String("email", "", "Email address")
+	password := fs.String("password", "", "Password")
+	fs.Parse(args)
+	if *username == "" {
+		return errors.New("-user is required")
+	}
+
+	generated := *password == ""
+	if generated {
+		var err error
+		if *password, err = randomPassword(); err != nil {
+			return err
+		}
+	}
+	user, err := core.RegisterUser(ctx, db, *username, *email, *password)
+	if err != nil {
+		return err
+	}
+	if err := user.MakeAdmin(ctx, true); err != nil {
+		return err
+	}
+	log.Printf("Admin %s created\n", user.Username)
+	if generated {
+		fmt.Printf("Password: %s\n", *password)
+	}
+	return nil
+}
+
+func runResetPassword(ctx context.Context, db *sql.DB, c *config.Config, fs *flag.FlagSet, args []string) error {
+	username := fs.String("user", "", "Username (required)")
+	password := fs.String("password", "", "New password")
+	fs.Parse(args)
+	if *username == "" {
+		return errors.New("-user is required")
+	}
+
+	user, err := core.GetUserByUsername(ctx, db, *username, nil)
+	if err != nil {
+		return err
+	}
+	generated := *password == ""
+	if generated {
+		if *password, err = randomPassword(); err != nil {
+			return err
+		}
+	}
+	if err := user.SetPassword(ctx, *password); err != nil {
+		return err
+	}
+	if err := server.LogoutAllSessions(c, user); err != nil {
+		return fmt.Errorf("password changed, but logging out the sessions of %s failed: %w", user.Username, err)
+	}
+	log.Printf("Password of %s changed, and their sessions logged out\n", user.Username)
+	if generated {
+		fmt.Printf("Password: %s\n", *password)
+	}
+	return nil
+}
+
+func runBanUser(ctx context.Context, db *sql.DB, c *config.Config, fs *flag.FlagSet, args []string) error {
+	username := fs.String("user", "", "Username (required)")
+	unban := fs.Bool("unban", false, "Unban the user instead")
+	fs.Parse(args)
+	if *username == "" {
+		return errors.New("-user is required")
+	}
+
+	user, err := core.GetUserByUsername(ctx, db, *username, nil)
+	if err != nil {
+		return err
+	}
+	if *unban {
+		if err := user.Unban(ctx); err != nil {
+			return err
+		}
+		log.Printf("User %s unbanned\n", user.Username)
+		return nil
+	}
+	if user.Admin {
+		return fmt.Errorf("%s is an admin (run discuit -remove-admin %s first)", user.Username, user.Username)
+	}
+	if err := server.LogoutAllSessions(c, user); err != nil {
+		return fmt.Errorf("logging out the sessions of %s: %w", user.Username, err)
+	}
+	if err := user.Ban(ctx); err != nil {
+		return err
+	}
+	log.Printf("User %s banned\n", user.Username)
+	return nil
+}
+
+func runDeleteCommunity(ctx context.Context, db *sql.DB, c *config.Config, fs *flag.FlagSet, args []string) error {
+	name := fs.String("community", "", "Community name (required)")
+	adminName := fs.String("admin", "", "Username of the admin the deletion is recorded as by (required)")
+	fs.Parse(args)
+	if *name == "" || *adminName == "" {
+		return errors.New("-community and -admin are required")
+	}
+
+	admin, err := core.GetUserByUsername(ctx, db, *adminName, nil)
+	if err != nil {
+		return err
+	}
+	if !admin.Admin {
+		return fmt.Errorf("%s is not an admin", admin.Username)
+	}
+	comm, err := core.GetCommunityByName(ctx, db, *name, nil)
+	if err != nil {
+		return err
+	}
+	if err := comm.Delete(ctx, admin.ID); err != nil {
+		return err
+	}
+	log.Printf("Community %s deleted\n", comm.Name)
+	return nil
+}
+
+func runRecountStats(ctx context.Context, db *sql.DB, c *config.Config, fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	fixed, err := core.RecountStats(ctx, db)
+	for counter, n := range fixed {
+		log.Printf("Fixed %d %s\n", n, counter)
+	}
+	return err
+}
+
+func runCheckComments(ctx context.Context, db *sql.DB, c *config.Config, fs *flag.FlagSet, args []string) error {
+	postID := fs.String("post", "", "Check only the post with this public ID")
+	repair := fs.Bool("repair", false, "Repair the anomalies found")
+	fs.Parse(args)
+
+	var posts []uid.ID
+	if *postID != "" {
+		post, err := core.GetPost(ctx, db, nil, *postID, nil, true)
+		if err != nil {
+			return err
+		}
+		posts = append(posts, post.ID)
+	}
+	report, err := core.CheckCommentTrees(ctx, db, posts, *repair)
+	if report != nil {
+		for _, a := range report.Anomalies {
+			note := ""
+			if !a.Repairable {
+				note = " (can't be repaired)"
+			}
+			fmt.Printf("post %s, comment %s: %s is %s, want %s%s\n", a.PostID, a.CommentID, a.Field, a.Got, a.Want, note)
+		}
+		log.Printf("Checked %d comments of %d posts: %d anomalies found, %d repaired, %d orphaned rows of comment_replies\n",
+			report.CommentsChecked, report.PostsChecked, len(report.Anomalies), report.Repaired, report.OrphanedReplies)
+	}
+	return err
+}
+
+func runPruneSessions(ctx context.Context, db *sql.DB, c *config.Config, fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	n, err := server.PruneSessions(c)
+	if err != nil {
+		return err
+	}
+	log.Printf("Removed %d expired sessions\n", n)
+	return nil
+}
+
+func runMaintenance(ctx context.Context, db *sql.DB, c *config.Config, fs *flag.FlagSet, args []string) error {
+	on := fs.Bool("on", false, "Put the site in read-only mode")
+	off := fs.Bool("off", false, "Take the site out of read-only mode (and clear the message)")
+	message := fs.String("message", "", "Banner message shown to users (it may be set without -on, to announce maintenance)")
+	duration := fs.Duration("for", 0, "How long the maintenance is expected to last (for the Retry-After of refused requests)")
+	fs.Parse(args)
+	if *on && *off {
+		return errors.New("-on and -off can't be used together")
+	}
+
+	m, err := core.GetMaintenance(ctx, db)
+	if err != nil {
+		return err
+	}
+	if *on || *off || *message != "" {
+		if *on || *off {
+			m.ReadOnly = *on
+		}
+		m.Message, m.EndsAt = *message, nil
+		if *duration > 0 {
+			endsAt := time.Now().Add(*duration)
+			m.EndsAt = &endsAt
+		}
+		if err := core.SaveMaintenance(ctx, db, m); err != nil {
+			return err
+		}
+		log.Println("Maintenance mode changed (it takes a few seconds to reach the running servers)")
+	}
+
+	fmt.Printf("Read-only: %v\n", m.ReadOnly)
+	if m.Message != "" {
+		fmt.Printf("Message: %s\n", m.Message)
+	}
+	if m.EndsAt != nil {
+		fmt.Printf("Expected to end at: %s\n", m.EndsAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func runAnonymize(ctx context.Context, db *sql.DB, c *config.Config, fs *flag.FlagSet, args []string) error {
+	out := fs.String("out", "", "The copy to make: a database file for SQLite, or the name of a new database for MySQL (required unless -dry-run)")
+	password := fs.String("password", "password", "The password all users of the copy are given")
+	dryRun := fs.Bool("dry-run", false, "Only show what would be anonymized (nothing is copied)")
+	fs.Parse(args)
+
+	if *dryRun {
+		steps, err := core.AnonymizeDryRun(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, step := range steps {
+			fmt.Printf("%s (%d rows)\n", step.Description, step.Rows)
+		}
+		return nil
+	}
+	if *out == "" {
+		return errors.New("-out is required")
+	}
+	if *out == c.DBName {
+		return errors.New("-out is the database of the site")
+	}
+
+	var dsn string
+	if c.DBDriver == msql.DriverSQLite {
+		if _, err := os.Stat(*out); err == nil {
+			return fmt.Errorf("%s already exists", *out)
+		}
+		if err := dumpDatabase(ctx, db, c, *out); err != nil {
+			return err
+		}
+		dsn = sqliteDSN(*out)
+	} else {
+		if !validDBName.MatchString(*out) {
+			return fmt.Errorf("invalid database name %q", *out)
+		}
+		if err := copyMySQLDatabase(ctx, db, c, *out); err != nil {
+			return err
+		}
+		dsn = mysqlDSN(c.DBUser, c.DBPassword, *out)
+	}
+	log.Printf("Database copied to %s\n", *out)
+
+	copyDB, err := tracing.OpenDB(c.DBDriver, dsn)
+	if err != nil {
+		return err
+	}
+	defer copyDB.Close()
+	steps, err := core.Anonymize(ctx, copyDB, *password)
+	if err != nil {
+		return fmt.Errorf("anonymizing %s (delete it, for it's only partly anonymized): %w", *out, err)
+	}
+	for _, step := range steps {
+		log.Printf("%s (%d rows)\n", step.Description, step.Rows)
+	}
+	log.Printf("%s is anonymized (users are named user1, user2, and so on, and their password is %s)\n", *out, *password)
+	return nil
+}
+
+var validDBName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// copyMySQLDatabase copies the database of c to a new database named name.
+func copyMySQLDatabase(ctx context.Context, db *sql.DB, c *config.Config, name string) error {
+	dir, err := os.MkdirTemp("", "discuit-anonymize")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	dump := filepath.Join(dir, "dump.sql")
+	if err := dumpDatabase(ctx, db, c, dump); err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, "CREATE DATABASE "+name+" DEFAULT CHARACTER SET utf8mb4"); err != nil {
+		return err
+	}
+	f, err := os.Open(dump)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var stderr bytes.Buffer
+	cmd := mysqlCommand(ctx, c, "mysql", append(mysqlArgs(c), name)...)
+	cmd.Stdin, cmd.Stderr = f, &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("mysql: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func runSeed(ctx context.Context, db *sql.DB, c *config.Config, fs *flag.FlagSet, args []string) error {
+	var opts core.SeedOptions
+	fs.IntVar(&opts.Users, "users", 50, "Number of users")
+	fs.IntVar(&opts.Communities, "communities", 10, "Number of communities")
+	fs.IntVar(&opts.Posts, "posts", 200, "Number of posts")
+	fs.IntVar(&opts.Comments, "comments", 1000, "Number of comments")
+	fs.IntVar(&opts.Votes, "votes", 2000, "Number of votes (duplicates are skipped, so a few less may be made)")
+	fs.IntVar(&opts.Days, "days", 30, "The content is spread over the last this many days")
+	fs.Int64Var(&opts.Seed, "seed", time.Now().UnixNano(), "Seed of the random choices")
+	fs.StringVar(&opts.Password, "password", "password", "The password of the users")
+	force := fs.Bool("force", false, "Seed even if isDevelopment is not set")
+	fs.Parse(args)
+
+	if !c.IsDevelopment && !*force {
+		return errors.New("isDevelopment is not set; run again with -force to seed anyway")
+	}
+	start := time.Now()
+	stats, err := core.Seed(ctx, db, opts)
+	if err != nil {
+		return err
+	}
+	log.Printf("Seeded %d users, %d communities, %d posts, %d comments, and %d votes in %v\n",
+		stats.Users, stats.Communities, stats.Posts, stats.Comments, stats.Votes, time.Since(start).Round(time.Millisecond))
+	log.Printf("The communities were created by the admin %s (the password of all users is %s)\n", stats.Admin, opts.Password)
+	return nil
+}
@@ -1,14 +1,17 @@
 package config
 
 import (
-	"errors"
+	"fmt"
 	"os"
 
 	"github.com/discuitnet/discuit/core"
 	"gopkg.in/yaml.v2"
 )
 
-// Config holds all site-wide configuration.
+// Config holds all site-wide configuration. It's read from a yaml file, any
+// key of which may be overridden with an environment variable (see envName).
+// Secrets (the fields tagged secret) may be kept out of the file, by setting
+// them to file:/path/to/file or env:VARIABLE_NAME (see resolveSecrets).
 type Config struct {
 	IsDevelopment bool `yaml:"isDevelopment"`
 
@@ -17,93 +20,566 @@ type Config struct {
 	SiteName        string `yaml:"siteName"`
 	SiteDescription string `yaml:"siteDescription"` // Used for meta tags.
 
+	// The database driver: either "mysql" (the default) or "sqlite3". With
+	// sqlite3, DBName is the path of the database file, and DBUser and
+	// DBPassword are ignored.
+	DBDriver string `yaml:"dbDriver"`
+
 	// Primary DB credentials.
 	DBUser     string `yaml:"dbUser"`
-	DBPassword string `yaml:"dbPassword"`
+	DBPassword string `yaml:"dbPassword" secret:"true"`
 	DBName     string `yaml:"dbName"`
 
+	// The time, in seconds, that the database queries of an API request may
+	// take in all: DBReadTimeout for GET requests, and DBWriteTimeout for the
+	// rest. Requests that run out of time fail with a 503 error, and are
+	// counted in the analytics (as db_timeout events, by route). Zero means
+	// no limit. Streams and uploads are not limited.
+	DBReadTimeout  int `yaml:"dbReadTimeout"`
+	DBWriteTimeout int `yaml:"dbWriteTimeout"`
+
 	SessionCookieName string `yaml:"sessionCookieName"`
 
 	RedisAddress string `yaml:"redisAddress"`
 
-	HMACSecret string `yaml:"hmacSecret"`
+	// The Redis database (by index) that holds the sessions, rate limits,
+	// and the like of the site. Sites sharing a Redis server (see Sites) must
+	// each use a different one.
+	RedisDB int `yaml:"redisDB"`
+
+	// Other sites served by the process, by hostname: requests to a host in
+	// Sites are served by the site of the config file it maps to, and all
+	// other requests by the site of this config. Each site has a database
+	// (and a Redis database) of its own. The settings of the process (addr,
+	// the TLS certificate, logging, tracing, the storage of images and
+	// videos, and the image proxy) are those of this config; those in the
+	// configs of the other sites are ignored.
+	Sites map[string]string `yaml:"sites"`
+
+	HMACSecret string `yaml:"hmacSecret" secret:"true"`
 
 	CSRFOff bool `yaml:"csrfOff"`
 
+	// Requests that change state must come from the site itself or from one
+	// of CSRFTrustedOrigins (like https://example.com), as told by their
+	// Origin or Referer headers.
+	CSRFTrustedOrigins []string `yaml:"csrfTrustedOrigins"`
+
+	// The cookies set are marked secure always, never, or, with auto, only
+	// for HTTPS requests (including those the reverse proxy received over
+	// HTTPS, as told by the X-Forwarded-Proto header). CookieSameSite is the
+	// SameSite attribute of the cookies: lax, strict, or none.
+	CookieSecure   string `yaml:"cookieSecure"`
+	CookieSameSite string `yaml:"cookieSameSite"`
+
+	// Logged in sessions expire after being idle for SessionIdleTimeout
+	// hours, and SessionMaxAge hours after the login (zero means never).
+	// Sensitive actions (like changing the email address) require the user
+	// to have entered their password within the last ReauthWindow minutes
+	// (zero disables the requirement).
+	SessionIdleTimeout int `yaml:"sessionIdleTimeout"`
+	SessionMaxAge      int `yaml:"sessionMaxAge"`
+	ReauthWindow       int `yaml:"reauthWindow"`
+
+	// If true, no analytics events are recorded. Otherwise, only hourly counts
+	// of coarse events (page views, post views, votes, and signups), and of
+	// anonymized search queries, are.
+	AnalyticsOff bool `yaml:"analyticsOff"`
+
+	// If WarehouseExport is set (to either disk or s3), the public content and
+	// the aggregate metrics of each day are exported nightly, as gzipped CSV
+	// files, to WarehouseExportPath: a folder on disk, or a prefix of the keys
+	// in the S3 bucket (see S3Bucket).
+	WarehouseExport     string `yaml:"warehouseExport"`
+	WarehouseExportPath string `yaml:"warehouseExportPath"`
+
+	// Backups (made with discuit backup) are saved to BackupFolder, and only
+	// the last BackupKeep of them are kept (0 keeps all). They're encrypted
+	// with BackupPassphrase, if it's set (which is also needed to restore
+	// them).
+	BackupFolder     string `yaml:"backupFolder"`
+	BackupKeep       int    `yaml:"backupKeep"`
+	BackupPassphrase string `yaml:"backupPassphrase" secret:"true"`
+
 	NoLogToFile bool `yaml:"noLogToFile"`
 
+	// Application logs (as opposed to HTTP access logs) are written to stderr
+	// in LogFormat (either "text" or "json"). LogLevel is the minimum level
+	// (debug, info, warn, or error) of the logs written, which may be
+	// overridden per module (core, notifications, server, images, etc) with
+	// LogLevels. Levels can also be changed at runtime through the admin API.
+	LogFormat string            `yaml:"logFormat"`
+	LogLevel  string            `yaml:"logLevel"`
+	LogLevels map[string]string `yaml:"logLevels"`
+
+	// OpenTelemetry traces are exported (using OTLP over HTTP) to the
+	// collector at OTLPEndpoint (host:port), if it's not empty.
+	// TraceSampleRatio is the fraction of traces sampled.
+	OTLPEndpoint     string  `yaml:"otlpEndpoint"`
+	OTLPInsecure     bool    `yaml:"otlpInsecure"`
+	TraceSampleRatio float64 `yaml:"traceSampleRatio"`
+
 	PaginationLimit    int           `yaml:"paginationLimit"`
 	PaginationLimitMax int           `yaml:"paginationLimitMax"`
 	DefaultFeedSort    core.FeedSort `yaml:"defaultFeedSort"`
 
 	// Captcha verification is skipped if empty.
-	CaptchaSecret string `yaml:"captchaSecret"`
+	CaptchaSecret string `yaml:"captchaSecret" secret:"true"`
 
 	CertFile string `yaml:"certFile"`
 	KeyFile  string `yaml:"keyFile"`
 
+	// On shutdown, the time, in seconds, to wait for in-flight requests and
+	// background tasks to finish.
+	ShutdownTimeout int `yaml:"shutdownTimeout"`
+
+	// For deploys that don't drop requests. With ReusePort, the server
+	// listens on Addr with SO_REUSEPORT, so that a new process can listen on
+	// it before the old one stops (the server may instead be given its
+	// sockets by systemd, see inheritedListeners). On shutdown, the server
+	// first drains for DrainDelay seconds: it keeps serving, but /healthz
+	// reports it's draining and connections aren't kept alive, so that
+	// clients and load balancers move to the new process.
+	ReusePort  bool `yaml:"reusePort"`
+	DrainDelay int  `yaml:"drainDelay"`
+
 	DisableRateLimits bool `yaml:"disableRateLimits"`
 	MaxImageSize      int  `yaml:"maxImageSize"`
 
+	// The maximum size, in bytes, of the body of API requests, other than
+	// those of uploads (which are limited by MaxImageSize and MaxVideoSize).
+	// Larger requests are refused with a 413 error.
+	MaxRequestBodySize int `yaml:"maxRequestBodySize"`
+
 	// If API requests have a URL query parameter of the form 'adminKey=value',
 	// where value is AdminApiKey, rate limits are disabled.
-	AdminApiKey string `yaml:"adminAPIKey"`
+	AdminApiKey string `yaml:"adminAPIKey" secret:"true"`
+
+	// The date (YYYY-MM-DD) after which the unversioned API routes (those not
+	// under /api/v1) may be removed. If set, it's sent to API token clients in
+	// the Sunset header of responses from those routes.
+	LegacyAPISunset string `yaml:"legacyApiSunset"`
+
+	// Usernames of the users, besides admins, who may connect to the firehose
+	// (the stream of all new posts and comments at /api/firehose).
+	FirehoseUsers []string `yaml:"firehoseUsers"`
+
+	// If true, communities are published as ActivityPub actors, which users
+	// of Mastodon, Lemmy, and so on can follow. FederationDomain is then
+	// required.
+	Federation bool `yaml:"federation"`
+
+	// The domain name (for example, discuit.net) under which the site is
+	// reachable over https by other servers.
+	FederationDomain string `yaml:"federationDomain"`
 
 	DisableImagePosts bool `yaml:"disableImagePosts"`
 
+	// Video posts need ffmpeg (or VideoTranscodeCommand) to be installed, and
+	// so they're disabled by default. They may also be disabled per community.
+	EnableVideoPosts      bool     `yaml:"enableVideoPosts"`
+	VideosFolderPath      string   `yaml:"videosFolderPath"`      // Where videos are saved on disk.
+	MaxVideoSize          int      `yaml:"maxVideoSize"`          // In bytes.
+	MaxVideoDuration      int      `yaml:"maxVideoDuration"`      // In seconds.
+	MaxVideoHeight        int      `yaml:"maxVideoHeight"`        // Taller videos are scaled down.
+	VideoHLS              bool     `yaml:"videoHls"`              // Create HLS streams in addition to MP4 files.
+	FFmpegPath            string   `yaml:"ffmpegPath"`            // Defaults to ffmpeg.
+	FFprobePath           string   `yaml:"ffprobePath"`           // Defaults to ffprobe.
+	VideoTranscodeCommand []string `yaml:"videoTranscodeCommand"` // If set, run in place of ffmpeg (with the input file and output folder appended).
+
+	// Storage quotas, in megabytes, of the images and videos uploaded by
+	// each user and of those posted to each community. Zero means no quota.
+	UserStorageQuota      int `yaml:"userStorageQuota"`
+	CommunityStorageQuota int `yaml:"communityStorageQuota"`
+
+	// Supporters (users who pay, or for whom another user pays, through a
+	// payment provider) get the badge SupporterBadge (if not empty) and
+	// SupporterExtraStorage more megabytes of storage quota, for
+	// SupporterDays days (unless the payment says otherwise; zero means
+	// forever). Stripe is the payment provider if StripeWebhookSecret (the
+	// signing secret of the webhook at /webhooks/payments/stripe) is set.
+	SupporterBadge        string `yaml:"supporterBadge"`
+	SupporterExtraStorage int    `yaml:"supporterExtraStorage"`
+	SupporterDays         int    `yaml:"supporterDays"`
+	StripeWebhookSecret   string `yaml:"stripeWebhookSecret" secret:"true"`
+
+	// Days after which the images and videos of deleted posts (and the
+	// profile pictures of deleted users) are deleted. If zero, they're kept.
+	DeletedContentMediaTTL int `yaml:"deletedContentMediaTtl"`
+
+	// Days the bodies of deleted posts and comments are kept (in an archive,
+	// for admins) before they're purged for good. Zero keeps them. The
+	// retention of the content of users in a jurisdiction (the country code in
+	// the JurisdictionHeader of their signup request, set by the reverse
+	// proxy) may be overridden with DeletedContentRetentionByJurisdiction.
+	DeletedContentRetention               int            `yaml:"deletedContentRetention"`
+	DeletedContentRetentionByJurisdiction map[string]int `yaml:"deletedContentRetentionByJurisdiction"`
+	JurisdictionHeader                    string         `yaml:"jurisdictionHeader"`
+
 	DisableForumCreation   bool `yaml:"disableForumCreation"`   // If true, only admins can create communities.
 	ForumCreationReqPoints int  `yaml:"forumCreationReqPoints"` // Minimum points required for non-admins to create community, Required non-empty config field.
 	MaxForumsPerUser       int  `yaml:"maxForumsPerUser"`       // Max forums one user can moderate, Required non-empty config field.
 
+	// The maximum number of categories (set by the admins) a community may
+	// be listed under.
+	MaxCommunityCategories int `yaml:"maxCommunityCategories"`
+
 	// The location where images are saved on disk.
 	ImagesFolderPath string `yaml:"imagesFolderPath"`
+
+	// If true, the URLs of images point to /img/, where images are served in
+	// the most efficient format the browser accepts (AVIF or WebP).
+	NegotiateImageFormats bool `yaml:"negotiateImageFormats"`
+
+	// The store in which new images are saved: either disk (the default) or
+	// s3.
+	ImagesStore string `yaml:"imagesStore"`
+
+	// The S3-compatible bucket of the s3 image store.
+	S3Endpoint        string `yaml:"s3Endpoint"`
+	S3Region          string `yaml:"s3Region"`
+	S3Bucket          string `yaml:"s3Bucket"`
+	S3AccessKey       string `yaml:"s3AccessKey" secret:"true"`
+	S3SecretKey       string `yaml:"s3SecretKey" secret:"true"`
+	S3PathStyle       bool   `yaml:"s3PathStyle"`       // Address the bucket in the URL path (as with MinIO).
+	S3Prefix          string `yaml:"s3Prefix"`          // Prepended to the keys of all objects.
+	S3SignedURLExpiry int    `yaml:"s3SignedUrlExpiry"` // In seconds. If non-zero, images are served with redirects to signed URLs.
+
+	// If true, the external images in the Markdown of posts and comments are
+	// served through the image proxy of the site (at /images/proxy, see the
+	// proxiedImages field of posts and comments), which hides the IP
+	// addresses of readers from the hosts of the images. Images larger than
+	// ImageProxyMaxSize (in bytes) are not served, and those fetched are
+	// cached for ImageProxyCacheTTL hours. The proxy is a setting of the
+	// process (see Sites): it is on for all sites or for none.
+	ImageProxy         bool  `yaml:"imageProxy"`
+	ImageProxyMaxSize  int64 `yaml:"imageProxyMaxSize"`
+	ImageProxyCacheTTL int   `yaml:"imageProxyCacheTtl"`
+
+	// Hours after which images that are not used anywhere are deleted. If
+	// zero, they're kept.
+	OrphanedImagesTTL int `yaml:"orphanedImagesTtl"`
+
+	// Uploaded images are scanned before they're saved. Images whose SHA-256
+	// hashes are in the file ImageScanHashesFile (one per line) are either
+	// rejected or quarantined (pending review by an admin), as per
+	// ImageScanHashesVerdict. If ImageScanURL is set, images are also POSTed
+	// to it, and the response is expected to be a JSON object of the form
+	// {"verdict": "allow|quarantine|reject", "reason": "..."}.
+	ImageScanHashesFile    string `yaml:"imageScanHashesFile"`
+	ImageScanHashesVerdict string `yaml:"imageScanHashesVerdict"`
+	ImageScanURL           string `yaml:"imageScanUrl"`
+
+	// Seconds for which the responses of public API endpoints to anonymous
+	// requests (with no session cookie) may be cached, by browsers and CDNs.
+	// If zero, they're not cached (but are still revalidated with ETags).
+	// CDNCacheMaxAge, if non-zero, is the max-age for CDNs (s-maxage).
+	PublicCacheMaxAge int `yaml:"publicCacheMaxAge"`
+	CDNCacheMaxAge    int `yaml:"cdnCacheMaxAge"`
+
+	// If set, the surrogate keys of edited or deleted content are POSTed to
+	// this URL, as {"keys": [...]}, to purge them from the CDN. The token,
+	// if set, is sent as a bearer token.
+	CDNPurgeURL   string `yaml:"cdnPurgeUrl"`
+	CDNPurgeToken string `yaml:"cdnPurgeToken" secret:"true"`
+
+	// If set, comments are translated on demand (and the translations cached)
+	// by POSTing them to this URL, as {"text": "...", "target": "fr"}; the
+	// response is expected to be {"text": "..."}, or a 422 if the language is
+	// not supported. The token, if set, is sent as a bearer token.
+	TranslateURL   string `yaml:"translateUrl"`
+	TranslateToken string `yaml:"translateToken" secret:"true"`
+
+	// A comment is part of a flood if its body is near-identical (its simhash
+	// differs in at most CommentFloodMaxDistance bits) to those of
+	// CommentFloodUserLimit comments the same user posted, or to those of
+	// comments CommentFloodAccountLimit other users posted, within the last
+	// CommentFloodWindow minutes. Such comments are either refused (throttle)
+	// or held for review by an admin (hold), as per CommentFloodAction. If
+	// CommentFloodWindow is zero, comments are not checked.
+	CommentFloodWindow       int    `yaml:"commentFloodWindow"`
+	CommentFloodMaxDistance  int    `yaml:"commentFloodMaxDistance"`
+	CommentFloodUserLimit    int    `yaml:"commentFloodUserLimit"`
+	CommentFloodAccountLimit int    `yaml:"commentFloodAccountLimit"`
+	CommentFloodAction       string `yaml:"commentFloodAction"`
+
+	// An uploaded post or comment image is flagged, to be surfaced in the
+	// admin and mod queues, if it's a near-duplicate (its perceptual hash
+	// differs in at most DuplicateImageMaxDistance bits) of images that
+	// DuplicateImageAccountLimit other users uploaded within the last
+	// DuplicateImageWindow minutes. If DuplicateImageWindow is zero, images
+	// are not checked.
+	DuplicateImageWindow       int `yaml:"duplicateImageWindow"`
+	DuplicateImageMaxDistance  int `yaml:"duplicateImageMaxDistance"`
+	DuplicateImageAccountLimit int `yaml:"duplicateImageAccountLimit"`
+
+	// The removals of posts and comments, bans, and locks by mods can be
+	// undone within ModUndoWindow seconds, before their side effects (like
+	// notifications and the resolving of reports) are carried out. If it's
+	// zero, mod actions can't be undone.
+	ModUndoWindow int `yaml:"modUndoWindow"`
+
+	// Accounts younger than NewAccountDays days, or with fewer than
+	// NewAccountPoints points, are new accounts, which may create at most
+	// NewAccountMaxPostsPerHour posts and NewAccountMaxCommentsPerHour
+	// comments an hour (zero means no limit), may not create link posts if
+	// NewAccountNoLinkPosts is true, and may not post or comment in the
+	// communities named in NewAccountRestrictedCommunities. If both
+	// NewAccountDays and NewAccountPoints are zero, no account is new.
+	NewAccountDays                  int      `yaml:"newAccountDays"`
+	NewAccountPoints                int      `yaml:"newAccountPoints"`
+	NewAccountMaxPostsPerHour       int      `yaml:"newAccountMaxPostsPerHour"`
+	NewAccountMaxCommentsPerHour    int      `yaml:"newAccountMaxCommentsPerHour"`
+	NewAccountNoLinkPosts           bool     `yaml:"newAccountNoLinkPosts"`
+	NewAccountRestrictedCommunities []string `yaml:"newAccountRestrictedCommunities"`
+
+	// After LoginDelayAfter failed logins to an account within the last
+	// LoginThrottleWindow minutes, each further attempt is delayed (by a
+	// second, doubling each time, up to LoginMaxDelay seconds). After
+	// LoginAccountLockout failed logins to an account, or LoginIPLockout
+	// failed logins from an IP address, logins to the account (except from
+	// the devices it was logged in from before) or from the address are
+	// refused for LoginLockoutMinutes minutes. Zero limits are disabled; if
+	// LoginThrottleWindow is zero, logins are not throttled at all.
+	LoginThrottleWindow int `yaml:"loginThrottleWindow"`
+	LoginDelayAfter     int `yaml:"loginDelayAfter"`
+	LoginMaxDelay       int `yaml:"loginMaxDelay"`
+	LoginAccountLockout int `yaml:"loginAccountLockout"`
+	LoginIPLockout      int `yaml:"loginIpLockout"`
+	LoginLockoutMinutes int `yaml:"loginLockoutMinutes"`
+
+	// If Passkeys is true, users may register passkeys (WebAuthn credentials,
+	// at most MaxPasskeysPerUser each) and log in with them instead of with
+	// their password. PasskeysRPID is the domain of the site, and
+	// PasskeysOrigins the origins passkeys are used from (by default,
+	// https:// followed by PasskeysRPID). If PasskeysUserVerification is
+	// true, authenticators must verify the user (with a PIN or biometrics)
+	// and not only their presence. If PasskeysRequiredForAdmins is true,
+	// admins who have a passkey may only log in with it.
+	Passkeys                  bool     `yaml:"passkeys"`
+	PasskeysRPID              string   `yaml:"passkeysRPID"`
+	PasskeysOrigins           []string `yaml:"passkeysOrigins"`
+	PasskeysUserVerification  bool     `yaml:"passkeysUserVerification"`
+	PasskeysRequiredForAdmins bool     `yaml:"passkeysRequiredForAdmins"`
+	MaxPasskeysPerUser        int      `yaml:"maxPasskeysPerUser"`
+
+	// Users may log in with Google, GitHub, or the OpenID Connect provider
+	// at OIDCIssuer (shown to users as OIDCName), if the OAuth client of
+	// the site at the provider is set. Providers redirect users back to
+	// ExternalLoginURL (the URL of the site, ex: https://discuit.net)
+	// followed by /api/_login/external/{provider}/callback, where provider is
+	// google, github, or oidc. Logging in with an account at a provider that
+	// isn't linked to a user links it to the user with the same (verified
+	// and confirmed) email address, if there's one, or else creates a new
+	// user, if ExternalLoginSignups is open (the default). If it's closed,
+	// no users are created. If ExternalLoginSignupDomains is not empty, only
+	// users with a verified email address at one of those domains (domain
+	// patterns, as in link domain rules) are created.
+	GoogleClientID             string   `yaml:"googleClientID"`
+	GoogleClientSecret         string   `yaml:"googleClientSecret" secret:"true"`
+	GitHubClientID             string   `yaml:"githubClientID"`
+	GitHubClientSecret         string   `yaml:"githubClientSecret" secret:"true"`
+	OIDCIssuer                 string   `yaml:"oidcIssuer"`
+	OIDCName                   string   `yaml:"oidcName"`
+	OIDCClientID               string   `yaml:"oidcClientID"`
+	OIDCClientSecret           string   `yaml:"oidcClientSecret" secret:"true"`
+	ExternalLoginURL           string   `yaml:"externalLoginURL"`
+	ExternalLoginSignups       string   `yaml:"externalLoginSignups"`
+	ExternalLoginSignupDomains []string `yaml:"externalLoginSignupDomains"`
+
+	// If LDAPURL (ldap:// or ldaps://) is set, the users under LDAPBaseDN
+	// that match LDAPUserFilter are synced from the directory every
+	// DirectorySyncInterval minutes: users are created for new ones (or
+	// linked to the user with the same confirmed email address), and, if
+	// DirectoryDeactivateRemoved is true, those removed from the directory
+	// are deactivated (banned, and logged out) until they're back. Users are
+	// made members of the communities of their groups in
+	// DirectoryGroupCommunities (by which the names of communities are keyed
+	// by group DNs or common names), and are removed from them when they
+	// leave the groups. If OIDCGroupsClaim is set, the groups in that claim
+	// of the OpenID Connect provider are synced too, on each login.
+	LDAPURL                    string            `yaml:"ldapURL"`
+	LDAPBindDN                 string            `yaml:"ldapBindDN"`
+	LDAPBindPassword           string            `yaml:"ldapBindPassword" secret:"true"`
+	LDAPBaseDN                 string            `yaml:"ldapBaseDN"`
+	LDAPUserFilter             string            `yaml:"ldapUserFilter"`
+	LDAPUsernameAttribute      string            `yaml:"ldapUsernameAttribute"`
+	LDAPEmailAttribute         string            `yaml:"ldapEmailAttribute"`
+	LDAPGroupAttribute         string            `yaml:"ldapGroupAttribute"`
+	DirectorySyncInterval      int               `yaml:"directorySyncInterval"`
+	DirectoryDeactivateRemoved bool              `yaml:"directoryDeactivateRemoved"`
+	DirectoryGroupCommunities  map[string]string `yaml:"directoryGroupCommunities"`
+	OIDCGroupsClaim            string            `yaml:"oidcGroupsClaim"`
+
+	// Emails are sent through the SMTP server at SMTPAddress (host:port), if
+	// it's not empty, from EmailFrom. If NewDeviceLoginEmail is true, users
+	// are emailed when their account is logged in to from a new device.
+	SMTPAddress         string `yaml:"smtpAddress"`
+	SMTPUsername        string `yaml:"smtpUsername"`
+	SMTPPassword        string `yaml:"smtpPassword" secret:"true"`
+	EmailFrom           string `yaml:"emailFrom"`
+	NewDeviceLoginEmail bool   `yaml:"newDeviceLoginEmail"`
+
+	// The text the server produces (like error messages and emails) is
+	// translated to the locale of the user with the translation files in the
+	// folder TranslationsFolder (see package i18n), if it's not empty.
+	TranslationsFolder string `yaml:"translationsFolder"`
+
+	// The domain patterns (as in site-wide link domain rules) of URL
+	// shorteners. Links to them are resolved before they are checked against
+	// the link domain rules.
+	LinkShorteners []string `yaml:"linkShorteners"`
+
+	// Signups in which any of the (hidden) form fields SignupHoneypotFields
+	// are filled are refused.
+	SignupHoneypotFields []string `yaml:"signupHoneypotFields"`
+
+	// Signups with email addresses at DisposableEmailDomains (domain
+	// patterns) are either refused (reject) or quarantined (quarantine), as
+	// per DisposableEmailAction.
+	DisposableEmailDomains []string `yaml:"disposableEmailDomains"`
+	DisposableEmailAction  string   `yaml:"disposableEmailAction"`
+
+	// New accounts are quarantined if, within the last SignupVelocityWindow
+	// minutes, SignupIPLimit accounts signed up from the same IP address, or
+	// SignupNetworkLimit accounts from the same network. The network is the
+	// autonomous system number in the request header SignupASNHeader (if
+	// the reverse proxy sets one) or the /24 (or /48) address prefix. If
+	// SignupVelocityWindow is zero, signups are not checked.
+	SignupVelocityWindow int    `yaml:"signupVelocityWindow"`
+	SignupIPLimit        int    `yaml:"signupIPLimit"`
+	SignupNetworkLimit   int    `yaml:"signupNetworkLimit"`
+	SignupASNHeader      string `yaml:"signupASNHeader"`
+
+	// The posts and comments of quarantined accounts are held for review by
+	// an admin until QuarantineReleaseAfter of them are approved.
+	QuarantineReleaseAfter int `yaml:"quarantineReleaseAfter"`
+
+	// Set by Parse.
+	EnvOverrides []string `yaml:"-"` // The environment variables that overrode the config file.
+	Warnings     []string `yaml:"-"` // Problems that are not fatal, like unknown keys.
 }
 
-// Parse parses the yaml file at path and returns a Config.
+// Parse parses the yaml file at path and returns a Config. The values of the
+// file are overridden by those of the environment variables of the config keys
+// (see envName), and secrets may refer to files or to environment variables
+// (see resolveSecrets). The config is then validated, and all the problems
+// found are reported at once (in a *ValidationError).
 func Parse(path string) (*Config, error) {
-	c := &Config{
+	return parse(path, true)
+}
+
+// ParseSite parses the config file at path of one of the Sites of a config.
+// Unlike Parse, it ignores the environment variables of the config keys (which
+// are for the config of the process).
+func ParseSite(path string) (*Config, error) {
+	return parse(path, false)
+}
+
+func parse(path string, env bool) (*Config, error) {
+	c := defaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	unknown, err := unknownKeys(data, c)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, key := range unknown {
+		c.Warnings = append(c.Warnings, fmt.Sprintf("unknown key %s in %s", key, path))
+	}
+	if env {
+		if c.EnvOverrides, err = applyEnv(c); err != nil {
+			return nil, err
+		}
+	}
+	if err := resolveSecrets(c); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// defaultConfig returns a Config with the default values.
+func defaultConfig() *Config {
+	return &Config{
 		// Default values.
 		Addr:               ":8080",
+		DBDriver:           "mysql",
 		DBUser:             "root",
+		DBReadTimeout:      10,
+		DBWriteTimeout:     30,
 		SessionCookieName:  "SID",
 		RedisAddress:       ":6379",
 		PaginationLimit:    10,
 		PaginationLimitMax: 50,
 		DefaultFeedSort:    core.FeedSortHot,
 		MaxImageSize:       10 << 20,
+		ImageProxyMaxSize:  5 << 20,
+		ImageProxyCacheTTL: 24,
+		SupporterBadge:     "supporter",
+		SupporterDays:      30,
+		MaxRequestBodySize: 1 << 20,
+		MaxVideoSize:       100 << 20,
+		MaxVideoDuration:   180,
+		MaxVideoHeight:     720,
+		VideoHLS:           true,
+		FFmpegPath:         "ffmpeg",
+		FFprobePath:        "ffprobe",
+		LogFormat:          "text",
+		LogLevel:           "info",
+		TraceSampleRatio:   1,
+		ShutdownTimeout:    30,
+		ReauthWindow:       10,
+		BackupFolder:       "backups",
+		BackupKeep:         7,
 
-		// Required fields:
-		ForumCreationReqPoints: -1,
-		MaxForumsPerUser:       -1,
-	}
+		CommentFloodWindow:       60,
+		CommentFloodMaxDistance:  8,
+		CommentFloodUserLimit:    3,
+		CommentFloodAccountLimit: 5,
 
-	unmarshal := func() error {
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return err
-		}
-		if err = yaml.Unmarshal(data, &c); err != nil {
-			return err
-		}
-		return nil
-	}
-	if err := unmarshal(); err != nil {
-		return nil, err
-	}
+		DuplicateImageWindow:       60,
+		DuplicateImageMaxDistance:  6,
+		DuplicateImageAccountLimit: 2,
 
-	if c.Addr == "" {
-		c.Addr = ":80"
-		if c.CertFile != "" {
-			c.Addr = ":443"
-		}
-	}
+		ModUndoWindow: 10,
 
-	if c.ForumCreationReqPoints == -1 {
-		return nil, errors.New("c.ForumCreationReqPoints cannot be (-1)")
-	}
+		SignupHoneypotFields:   []string{"website"},
+		DisposableEmailDomains: []string{"mailinator.com", "guerrillamail.com", "sharklasers.com", "10minutemail.com", "temp-mail.org", "yopmail.com", "trashmail.com", "getnada.com", "dispostable.com", "maildrop.cc", "throwawaymail.com"},
+		SignupVelocityWindow:   60,
+		SignupIPLimit:          3,
+		SignupNetworkLimit:     10,
+		QuarantineReleaseAfter: 3,
+
+		LoginThrottleWindow: 60,
+		LoginDelayAfter:     3,
+		LoginMaxDelay:       8,
+		LoginAccountLockout: 10,
+		LoginIPLockout:      50,
+		LoginLockoutMinutes: 15,
+
+		MaxPasskeysPerUser: 10,
+
+		LDAPUserFilter:             "(objectClass=person)",
+		LDAPUsernameAttribute:      "uid",
+		LDAPEmailAttribute:         "mail",
+		LDAPGroupAttribute:         "memberOf",
+		DirectorySyncInterval:      60,
+		DirectoryDeactivateRemoved: true,
+
+		MaxCommunityCategories: 3,
 
-	if c.MaxForumsPerUser == -1 {
-		return nil, errors.New("c.MaxForumsPerUser cannot be (-1)")
+		LinkShorteners: []string{"bit.ly", "t.co", "tinyurl.com", "goo.gl", "ow.ly", "is.gd", "buff.ly", "rebrand.ly", "cutt.ly", "shorturl.at", "t.ly"},
+
+		// Required fields:
+		ForumCreationReqPoints: -1,
+		MaxForumsPerUser:       -1,
 	}
-	return c, nil
 }
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is the prefix of the names of the environment variables that
+// override the values of the config file.
+const EnvPrefix = "DISCUIT_"
+
+// envName returns the name of the environment variable of the config key
+// (the yaml name of a field): the key in upper snake case, prefixed with
+// EnvPrefix (ex: dbPassword becomes DISCUIT_DB_PASSWORD, and adminAPIKey
+// becomes DISCUIT_ADMIN_API_KEY).
+func envName(key string) string {
+	runes := []rune(key)
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// configFields calls fn for every field of c that has a yaml key.
+func configFields(c *Config, fn func(key string, field reflect.StructField, v reflect.Value) error) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		if err := fn(key, t.Field(i), v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyEnv sets the fields of c whose environment variables (see envName) are
+// set, and returns the names of those variables. Lists are comma separated,
+// and maps are comma separated key=value pairs.
+func applyEnv(c *Config) (applied []string, err error) {
+	err = configFields(c, func(key string, _ reflect.StructField, v reflect.Value) error {
+		name := envName(key)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil
+		}
+		if err := setFromString(v, value); err != nil {
+			return fmt.Errorf("environment variable %s (%s): %w", name, key, err)
+		}
+		applied = append(applied, name)
+		return nil
+	})
+	return applied, err
+}
+
+// setFromString sets v to the value represented by s.
+func setFromString(v reflect.Value, s string) error {
+	split := func(s string) []string {
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", s)
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		v.Set(reflect.ValueOf(split(s)))
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		m := reflect.MakeMap(v.Type())
+		for _, pair := range split(s) {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("%q is not of the form key=value", pair)
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if elem.Kind() != reflect.String && elem.Kind() != reflect.Int {
+				return fmt.Errorf("unsupported type %s", v.Type())
+			}
+			if err := setFromString(elem, strings.TrimSpace(value)); err != nil {
+				return fmt.Errorf("%s: %w", strings.TrimSpace(key), err)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(key)), elem)
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// resolveSecrets replaces the values of the secret fields of c (those tagged
+// secret:"true") that refer to a file (file:/path/to/file) or to an
+// environment variable (env:NAME) with the contents of the file (with
+// surrounding whitespace trimmed) or the value of the variable.
+func resolveSecrets(c *Config) error {
+	return configFields(c, func(key string, field reflect.StructField, v reflect.Value) error {
+		if field.Tag.Get("secret") != "true" || v.Kind() != reflect.String {
+			return nil
+		}
+		value := v.String()
+		switch {
+		case strings.HasPrefix(value, "file:"):
+			data, err := os.ReadFile(strings.TrimPrefix(value, "file:"))
+			if err != nil {
+				return fmt.Errorf("%s: reading secret: %w", key, err)
+			}
+			v.SetString(strings.TrimSpace(string(data)))
+		case strings.HasPrefix(value, "env:"):
+			name := strings.TrimPrefix(value, "env:")
+			secret, ok := os.LookupEnv(name)
+			if !ok {
+				return fmt.Errorf("%s: environment variable %s is not set", key, name)
+			}
+			v.SetString(secret)
+		}
+		return nil
+	})
+}
+
+// unknownKeys returns the top-level keys of the yaml document data that are
+// not config keys (nor keys read by the web client's build).
+func unknownKeys(data []byte, c *Config) ([]string, error) {
+	known := make(map[string]bool)
+	for _, key := range uiKeys {
+		known[key] = true
+	}
+	configFields(c, func(key string, _ reflect.StructField, _ reflect.Value) error {
+		known[key] = true
+		return nil
+	})
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var unknown []string
+	for _, item := range doc {
+		if key, ok := item.Key.(string); ok && !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	return unknown, nil
+}
+
+// uiKeys are the keys of the config file read only by the build of the web
+// client (see ui/webpack.common.js).
+var uiKeys = []string{
+	"captchaSiteKey",
+	"emailContact",
+	"facebookURL",
+	"twitterURL",
+	"instagramURL",
+	"discordURL",
+	"githubURL",
+	"substackURL",
+}
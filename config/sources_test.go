@@ -0,0 +1,92 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"addr":             "DISCUIT_ADDR",
+		"dbPassword":       "DISCUIT_DB_PASSWORD",
+		"adminAPIKey":      "DISCUIT_ADMIN_API_KEY",
+		"s3AccessKey":      "DISCUIT_S3_ACCESS_KEY",
+		"cdnPurgeToken":    "DISCUIT_CDN_PURGE_TOKEN",
+		"traceSampleRatio": "DISCUIT_TRACE_SAMPLE_RATIO",
+	}
+	for key, want := range tests {
+		if got := envName(key); got != want {
+			t.Errorf("envName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("DISCUIT_PAGINATION_LIMIT", "25")
+	t.Setenv("DISCUIT_LOG_LEVELS", "core=debug, server=warn")
+	t.Setenv("DISCUIT_DELETED_CONTENT_RETENTION_BY_JURISDICTION", "DE=7")
+	c := defaultConfig()
+	applied, err := applyEnv(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 3 {
+		t.Errorf("applied = %v, want 3 variables", applied)
+	}
+	if c.PaginationLimit != 25 {
+		t.Errorf("PaginationLimit = %d, want 25", c.PaginationLimit)
+	}
+	if c.LogLevels["core"] != "debug" || c.LogLevels["server"] != "warn" {
+		t.Errorf("LogLevels = %v", c.LogLevels)
+	}
+	if c.DeletedContentRetentionByJurisdiction["DE"] != 7 {
+		t.Errorf("DeletedContentRetentionByJurisdiction = %v", c.DeletedContentRetentionByJurisdiction)
+	}
+
+	t.Setenv("DISCUIT_PAGINATION_LIMIT", "many")
+	if _, err := applyEnv(defaultConfig()); err == nil {
+		t.Error("applyEnv accepted a non-integer paginationLimit")
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_DB_PASSWORD", "from-env")
+	c := &Config{HMACSecret: "file:" + path, DBPassword: "env:TEST_DB_PASSWORD", SiteName: "env:NOT_A_SECRET"}
+	if err := resolveSecrets(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.HMACSecret != "from-file" || c.DBPassword != "from-env" || c.SiteName != "env:NOT_A_SECRET" {
+		t.Errorf("got hmacSecret %q, dbPassword %q, siteName %q", c.HMACSecret, c.DBPassword, c.SiteName)
+	}
+
+	c = &Config{DBPassword: "env:TEST_UNSET_VARIABLE"}
+	if err := resolveSecrets(c); err == nil {
+		t.Error("resolveSecrets accepted a reference to an unset variable")
+	}
+}
+
+func TestParseSite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "site.yaml")
+	data := "dbName: site\nhmacSecret: secret\nredisDB: 2\nforumCreationReqPoints: 0\nmaxForumsPerUser: 1\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DISCUIT_DB_NAME", "process")
+	c, err := ParseSite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DBName != "site" || c.RedisDB != 2 {
+		t.Errorf("DBName, RedisDB = %q, %d, want site, 2 (the environment is for the process's config)", c.DBName, c.RedisDB)
+	}
+	if c, err = Parse(path); err != nil {
+		t.Fatal(err)
+	} else if c.DBName != "process" {
+		t.Errorf("Parse: DBName = %q, want process", c.DBName)
+	}
+}
@@ -0,0 +1,239 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/logging"
+)
+
+// hmacSecretPlaceholder is the hmacSecret of config.default.yaml.
+const hmacSecretPlaceholder = "<insert-your-secret-here>"
+
+// ValidationError is the list of the problems of a config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return "invalid config: " + e.Problems[0]
+	}
+	return fmt.Sprintf("invalid config (%d problems):\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// validate sets the defaults of the fields that depend on other fields, and
+// returns a *ValidationError listing every problem of c, if it has any.
+func (c *Config) validate() error {
+	var problems []string
+	addf := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	// oneOf sets *v to def if it's empty, and reports a problem if it's not
+	// one of valid.
+	oneOf := func(key string, v *string, def string, valid ...string) {
+		if *v == "" {
+			*v = def
+		}
+		for _, s := range valid {
+			if *v == s {
+				return
+			}
+		}
+		addf("invalid %s %q (it must be one of %s)", key, *v, strings.Join(valid, ", "))
+	}
+
+	if c.Addr == "" {
+		c.Addr = ":80"
+		if c.CertFile != "" {
+			c.Addr = ":443"
+		}
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		addf("certFile and keyFile must be set together")
+	}
+
+	oneOf("dbDriver", &c.DBDriver, "mysql", "mysql", "sqlite3")
+	if c.DBName == "" {
+		addf("dbName is required")
+	}
+
+	if c.DrainDelay < 0 {
+		addf("drainDelay must not be negative")
+	}
+	if c.RedisDB < 0 {
+		addf("redisDB must not be negative")
+	}
+	for host, path := range c.Sites {
+		if host == "" || strings.ContainsAny(host, "/:") {
+			addf("invalid host %q in sites (it must be a hostname, without a port)", host)
+		}
+		if path == "" {
+			addf("the config file of the site %s is missing in sites", host)
+		}
+	}
+
+	if c.HMACSecret == "" {
+		addf("hmacSecret is required")
+	} else if c.HMACSecret == hmacSecretPlaceholder && !c.IsDevelopment {
+		addf("hmacSecret must be changed from its default value (outside development)")
+	}
+
+	oneOf("logFormat", &c.LogFormat, "text", "text", "json")
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		addf("invalid logLevel: %v", err)
+	}
+	for module, level := range c.LogLevels {
+		if _, err := logging.ParseLevel(level); err != nil {
+			addf("invalid log level of module %s in logLevels: %v", module, err)
+		}
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		addf("traceSampleRatio must be between 0 and 1")
+	}
+
+	if c.PaginationLimit < 1 {
+		addf("paginationLimit must be at least 1")
+	}
+	if c.PaginationLimitMax < c.PaginationLimit {
+		addf("paginationLimitMax must be at least paginationLimit")
+	}
+
+	if c.LegacyAPISunset != "" {
+		if _, err := time.Parse(time.DateOnly, c.LegacyAPISunset); err != nil {
+			addf("invalid legacyApiSunset %q (it must be of the form YYYY-MM-DD)", c.LegacyAPISunset)
+		}
+	}
+
+	if c.Federation && c.FederationDomain == "" {
+		addf("federationDomain is required when federation is enabled")
+	}
+	if c.SMTPAddress != "" && c.EmailFrom == "" {
+		addf("emailFrom is required when smtpAddress is set")
+	}
+
+	oneOf("imagesStore", &c.ImagesStore, "disk", "disk", "s3")
+	if c.ImagesStore == "s3" && (c.S3Endpoint == "" || c.S3Bucket == "") {
+		addf("s3Endpoint and s3Bucket are required when imagesStore is s3")
+	}
+	if c.ModUndoWindow < 0 || c.ModUndoWindow > 300 {
+		addf("modUndoWindow must be between 0 and 300 seconds")
+	}
+	if c.SupporterExtraStorage < 0 || c.SupporterDays < 0 {
+		addf("supporterExtraStorage and supporterDays must not be negative")
+	}
+	if c.ImageProxy && (c.ImageProxyMaxSize < 1 || c.ImageProxyCacheTTL < 0) {
+		addf("imageProxyMaxSize must be positive, and imageProxyCacheTtl must not be negative, when imageProxy is enabled")
+	}
+
+	if c.WarehouseExport != "" {
+		oneOf("warehouseExport", &c.WarehouseExport, "", "disk", "s3")
+		if c.WarehouseExport == "disk" && c.WarehouseExportPath == "" {
+			addf("warehouseExportPath is required when warehouseExport is disk")
+		}
+		if c.WarehouseExport == "s3" && (c.S3Endpoint == "" || c.S3Bucket == "") {
+			addf("s3Endpoint and s3Bucket are required when warehouseExport is s3")
+		}
+	}
+
+	if c.BackupKeep < 0 {
+		addf("backupKeep must not be negative")
+	}
+
+	oneOf("imageScanHashesVerdict", &c.ImageScanHashesVerdict, "reject", "reject", "quarantine")
+
+	oneOf("cookieSecure", &c.CookieSecure, "always", "always", "never", "auto")
+	oneOf("cookieSameSite", &c.CookieSameSite, "lax", "lax", "strict", "none")
+	if c.CookieSameSite == "none" && c.CookieSecure != "always" {
+		addf("cookieSameSite none requires cookieSecure always")
+	}
+
+	oneOf("commentFloodAction", &c.CommentFloodAction, "throttle", "throttle", "hold")
+	oneOf("disposableEmailAction", &c.DisposableEmailAction, "reject", "reject", "quarantine")
+
+	if c.DeletedContentRetention < 0 {
+		addf("deletedContentRetention must not be negative")
+	}
+	for j, days := range c.DeletedContentRetentionByJurisdiction {
+		if days < 0 {
+			addf("deletedContentRetentionByJurisdiction: %s must not be negative", j)
+		}
+	}
+	if c.Passkeys {
+		if c.PasskeysRPID == "" {
+			addf("passkeysRPID is required when passkeys are enabled")
+		} else if len(c.PasskeysOrigins) == 0 {
+			c.PasskeysOrigins = []string{"https://" + c.PasskeysRPID}
+		}
+		if c.MaxPasskeysPerUser < 1 {
+			addf("maxPasskeysPerUser must be at least 1 when passkeys are enabled")
+		}
+	}
+	external := false
+	for _, p := range []struct{ name, id, secret string }{
+		{"google", c.GoogleClientID, c.GoogleClientSecret},
+		{"github", c.GitHubClientID, c.GitHubClientSecret},
+		{"oidc", c.OIDCClientID, c.OIDCClientSecret},
+	} {
+		if p.id == "" {
+			continue
+		}
+		external = true
+		if p.secret == "" {
+			addf("%sClientSecret is required when %sClientID is set", p.name, p.name)
+		}
+	}
+	if c.OIDCClientID != "" {
+		if c.OIDCIssuer == "" {
+			addf("oidcIssuer is required when oidcClientID is set")
+		} else if !strings.HasPrefix(c.OIDCIssuer, "https://") && !c.IsDevelopment {
+			addf("oidcIssuer must be an https URL")
+		}
+		if c.OIDCName == "" {
+			c.OIDCName = "SSO"
+		}
+	}
+	if external && c.ExternalLoginURL == "" {
+		addf("externalLoginURL is required when an external identity provider is set")
+	}
+	c.ExternalLoginURL = strings.TrimSuffix(c.ExternalLoginURL, "/")
+	oneOf("externalLoginSignups", &c.ExternalLoginSignups, "open", "open", "closed")
+	if c.LDAPURL != "" {
+		if !strings.HasPrefix(c.LDAPURL, "ldap://") && !strings.HasPrefix(c.LDAPURL, "ldaps://") {
+			addf("ldapURL must be an ldap:// or ldaps:// URL")
+		}
+		if c.LDAPBaseDN == "" {
+			addf("ldapBaseDN is required when ldapURL is set")
+		}
+		if c.LDAPUsernameAttribute == "" {
+			addf("ldapUsernameAttribute is required when ldapURL is set")
+		}
+		if c.DirectorySyncInterval < 1 {
+			addf("directorySyncInterval must be at least 1")
+		}
+	}
+	if c.OIDCGroupsClaim != "" && c.OIDCClientID == "" {
+		addf("oidcGroupsClaim requires oidcClientID")
+	}
+	if c.DBReadTimeout < 0 || c.DBWriteTimeout < 0 {
+		addf("dbReadTimeout and dbWriteTimeout must not be negative")
+	}
+	if c.MaxRequestBodySize < 1 {
+		addf("maxRequestBodySize must be at least 1")
+	}
+	if c.MaxCommunityCategories < 0 {
+		addf("maxCommunityCategories must not be negative")
+	}
+	if c.ForumCreationReqPoints == -1 {
+		addf("forumCreationReqPoints is required")
+	}
+	if c.MaxForumsPerUser == -1 {
+		addf("maxForumsPerUser is required")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// The events counted in the abuse trends.
+const (
+	abuseEventReport  = "report"
+	abuseEventRemoval = "removal"
+)
+
+// addAbuseEvent counts an event, on a post or a comment (t), of community in
+// the abuse trends.
+func addAbuseEvent(ctx context.Context, db *sql.DB, community uid.ID, event string, t ReportType, reason int) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO abuse_rollups (day, community_id, event, target_type, reason_id, total) VALUES (?, ?, ?, ?, ?, 1) `+
+		msql.OnConflictUpdate("community_id", "day", "event", "target_type", "reason_id")+"total = total + 1",
+		time.Now().UTC().Truncate(24*time.Hour), community, event, t, reason)
+	return err
+}
+
+// recordRemoval counts the removal, by a mod or an admin, of target (a post or
+// a comment of community) in the abuse trends, under the reason target was most
+// reported for. It's to be called before the reports of target are deleted.
+func recordRemoval(ctx context.Context, db *sql.DB, community uid.ID, t ReportType, target uid.ID) error {
+	reason := 0
+	err := db.QueryRowContext(ctx, `
+		SELECT reason_id FROM reports
+		WHERE target_id = ? AND report_type = ?
+		GROUP BY reason_id
+		ORDER BY COUNT(*) DESC, reason_id
+		LIMIT 1`, target, t).Scan(&reason)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	return addAbuseEvent(ctx, db, community, abuseEventRemoval, t, reason)
+}
+
+// AbuseReasonCount is the number of reports, or removals, for a reason.
+type AbuseReasonCount struct {
+	ReasonID int    `json:"reasonId"` // 0 for removals of posts and comments that were not reported.
+	Reason   string `json:"reason"`
+	Posts    int    `json:"posts"`
+	Comments int    `json:"comments"`
+	Total    int    `json:"total"`
+}
+
+// AbuseTrendsDay is the number of reports and removals in a day, by reason ID.
+type AbuseTrendsDay struct {
+	Day      time.Time   `json:"day"`
+	Reports  map[int]int `json:"reports"`
+	Removals map[int]int `json:"removals"`
+}
+
+// AbuseCommunityCount is the number of reports and removals in a community.
+type AbuseCommunityCount struct {
+	CommunityID   uid.ID `json:"communityId"`
+	CommunityName string `json:"communityName"`
+	Reports       int    `json:"reports"`
+	Removals      int    `json:"removals"`
+}
+
+// AbuseTrends are the reports and the removals of a period, by the reasons
+// they were for, most first.
+type AbuseTrends struct {
+	Since    time.Time           `json:"since"`
+	Reports  []*AbuseReasonCount `json:"reports"`
+	Removals []*AbuseReasonCount `json:"removals"`
+	Days     []*AbuseTrendsDay   `json:"days"` // Days without any are omitted.
+
+	// The communities with the most reports and removals (only site-wide).
+	Communities []*AbuseCommunityCount `json:"communities,omitempty"`
+}
+
+// GetAbuseTrends returns the abuse trends of community, or, if community is
+// nil, of the whole site, since since.
+func GetAbuseTrends(ctx context.Context, db *sql.DB, community *uid.ID, since time.Time) (*AbuseTrends, error) {
+	since = since.UTC().Truncate(24 * time.Hour)
+	reasons, err := GetReportReasons(ctx, db)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	titles := map[int]string{0: "Not reported"}
+	for _, r := range reasons {
+		titles[r.ID] = r.Title
+	}
+
+	query := "SELECT day, event, target_type, reason_id, SUM(total) FROM abuse_rollups WHERE day >= ? GROUP BY day, event, target_type, reason_id ORDER BY day"
+	args := []any{since}
+	if community != nil {
+		query = "SELECT day, event, target_type, reason_id, SUM(total) FROM abuse_rollups WHERE community_id = ? AND day >= ? GROUP BY day, event, target_type, reason_id ORDER BY day"
+		args = []any{*community, since}
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trends := &AbuseTrends{Since: since, Reports: []*AbuseReasonCount{}, Removals: []*AbuseReasonCount{}, Days: []*AbuseTrendsDay{}}
+	counts := map[string]map[int]*AbuseReasonCount{
+		abuseEventReport:  make(map[int]*AbuseReasonCount),
+		abuseEventRemoval: make(map[int]*AbuseReasonCount),
+	}
+	for rows.Next() {
+		var (
+			day    time.Time
+			event  string
+			t      ReportType
+			reason int
+			total  int
+		)
+		if err := rows.Scan(&day, &event, &t, &reason, &total); err != nil {
+			return nil, err
+		}
+		byReason, ok := counts[event]
+		if !ok {
+			continue
+		}
+		c := byReason[reason]
+		if c == nil {
+			c = &AbuseReasonCount{ReasonID: reason, Reason: titles[reason]}
+			byReason[reason] = c
+		}
+		if t == ReportTypePost {
+			c.Posts += total
+		} else {
+			c.Comments += total
+		}
+		c.Total += total
+
+		day = day.UTC()
+		n := len(trends.Days)
+		if n == 0 || !trends.Days[n-1].Day.Equal(day) {
+			trends.Days = append(trends.Days, &AbuseTrendsDay{Day: day, Reports: make(map[int]int), Removals: make(map[int]int)})
+			n++
+		}
+		if event == abuseEventReport {
+			trends.Days[n-1].Reports[reason] += total
+		} else {
+			trends.Days[n-1].Removals[reason] += total
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sorted := func(byReason map[int]*AbuseReasonCount) []*AbuseReasonCount {
+		list := []*AbuseReasonCount{}
+		for _, c := range byReason {
+			list = append(list, c)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Total != list[j].Total {
+				return list[i].Total > list[j].Total
+			}
+			return list[i].ReasonID < list[j].ReasonID
+		})
+		return list
+	}
+	trends.Reports = sorted(counts[abuseEventReport])
+	trends.Removals = sorted(counts[abuseEventRemoval])
+
+	if community == nil {
+		if trends.Communities, err = getAbuseCommunities(ctx, db, since, 20); err != nil {
+			return nil, err
+		}
+	}
+	return trends, nil
+}
+
+// getAbuseCommunities returns the limit communities with the most reports and
+// removals since since.
+func getAbuseCommunities(ctx context.Context, db *sql.DB, since time.Time, limit int) ([]*AbuseCommunityCount, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT abuse_rollups.community_id, communities.name,
+			SUM(CASE WHEN abuse_rollups.event = ? THEN abuse_rollups.total ELSE 0 END) AS reports,
+			SUM(CASE WHEN abuse_rollups.event = ? THEN abuse_rollups.total ELSE 0 END) AS removals
+		FROM abuse_rollups
+		INNER JOIN communities ON communities.id = abuse_rollups.community_id
+		WHERE abuse_rollups.day >= ?
+		GROUP BY abuse_rollups.community_id, communities.name
+		ORDER BY SUM(abuse_rollups.total) DESC
+		LIMIT ?`, abuseEventReport, abuseEventRemoval, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []*AbuseCommunityCount{}
+	for rows.Next() {
+		c := &AbuseCommunityCount{}
+		if err := rows.Scan(&c.CommunityID, &c.CommunityName, &c.Reports, &c.Removals); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
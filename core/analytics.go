@@ -4,9 +4,11 @@ import (
 	"context"
 	"crypto/md5"
 	"database/sql"
+	"sync"
 	"time"
 
 	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
 )
 
 // AnalyticsEven represents a record in the analytics table.
@@ -39,3 +41,300 @@ func CreateAnalyticsEvent(ctx context.Context, db *sql.DB, name string, uniqueKe
 	}
 	return nil
 }
+
+// The coarse analytics events counted in the hourly rollups.
+const (
+	AnalyticsPageView = "page_view" // Dimension: the kind of page (see AnalyticsPages).
+	AnalyticsPostView = "post_view" // Dimension: the name of the community.
+	AnalyticsVote     = "vote"      // Dimension: post or comment.
+	AnalyticsSignup   = "signup"
+
+	// Requests whose database queries ran out of time. Dimension: the method
+	// and the path template of the route.
+	AnalyticsDBTimeout = "db_timeout"
+)
+
+// AnalyticsPages are the kinds of pages whose views are counted.
+var AnalyticsPages = []string{"home", "all", "community", "post", "user", "search", "other"}
+
+type analyticsKey struct {
+	event, dimension string
+	hour             time.Time
+}
+
+// PostStat is a per-post statistic counted by AnalyticsCounter.
+type PostStat int
+
+// The per-post statistics, shown to the authors of the posts.
+const (
+	PostStatViews = PostStat(iota)
+	PostStatUpvotes
+	PostStatDownvotes
+	PostStatComments
+	numPostStats
+)
+
+type postStatsKey struct {
+	post uid.ID
+	hour time.Time
+}
+
+type searchKey struct {
+	query string
+	hour  time.Time
+}
+
+// searchCounts are the number of searches of a query, and of those that found
+// nothing.
+type searchCounts struct {
+	searches, zeroResults int64
+}
+
+// AnalyticsCounter counts analytics events in memory, to be added to the
+// hourly rollups in the database by Flush. Only how many times each event
+// happened, per hour and per dimension (or per post), is recorded: never who
+// caused it (no user, session, or IP address), so that no browsing trail can
+// be rebuilt.
+//
+// A nil *AnalyticsCounter is valid and counts nothing (analytics are off).
+type AnalyticsCounter struct {
+	mu       sync.Mutex
+	counts   map[analyticsKey]int64
+	posts    map[postStatsKey]*[numPostStats]int64
+	searches map[searchKey]*searchCounts
+}
+
+// NewAnalyticsCounter returns an empty counter.
+func NewAnalyticsCounter() *AnalyticsCounter {
+	return &AnalyticsCounter{
+		counts:   make(map[analyticsKey]int64),
+		posts:    make(map[postStatsKey]*[numPostStats]int64),
+		searches: make(map[searchKey]*searchCounts),
+	}
+}
+
+// Add counts an event with dimension (which may be empty).
+func (c *AnalyticsCounter) Add(event, dimension string) {
+	if c == nil {
+		return
+	}
+	if len(dimension) > 128 {
+		dimension = dimension[:128]
+	}
+	key := analyticsKey{event: event, dimension: dimension, hour: time.Now().UTC().Truncate(time.Hour)}
+	c.mu.Lock()
+	c.counts[key]++
+	c.mu.Unlock()
+}
+
+// AddPost adds n (which may be negative, as when a vote is undone) to the
+// statistic stat of post.
+func (c *AnalyticsCounter) AddPost(post uid.ID, stat PostStat, n int) {
+	if c == nil {
+		return
+	}
+	key := postStatsKey{post: post, hour: time.Now().UTC().Truncate(time.Hour)}
+	c.mu.Lock()
+	stats := c.posts[key]
+	if stats == nil {
+		stats = new([numPostStats]int64)
+		c.posts[key] = stats
+	}
+	stats[stat] += int64(n)
+	c.mu.Unlock()
+}
+
+// AddSearch counts a search for query that found results results. The query
+// is normalized and anonymized (see normalizeSearchQuery), and empty queries
+// are not counted.
+func (c *AnalyticsCounter) AddSearch(query string, results int) {
+	if c == nil {
+		return
+	}
+	if query = normalizeSearchQuery(query); query == "" {
+		return
+	}
+	key := searchKey{query: query, hour: time.Now().UTC().Truncate(time.Hour)}
+	c.mu.Lock()
+	counts := c.searches[key]
+	if counts == nil {
+		counts = &searchCounts{}
+		c.searches[key] = counts
+	}
+	counts.searches++
+	if results == 0 {
+		counts.zeroResults++
+	}
+	c.mu.Unlock()
+}
+
+// Flush adds the events counted since the last flush to the rollups. Counts
+// that could not be written are kept for the next flush.
+func (c *AnalyticsCounter) Flush(ctx context.Context, db *sql.DB) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	counts, posts, searches := c.counts, c.posts, c.searches
+	c.counts = make(map[analyticsKey]int64)
+	c.posts = make(map[postStatsKey]*[numPostStats]int64)
+	c.searches = make(map[searchKey]*searchCounts)
+	c.mu.Unlock()
+
+	// Puts back the counts not yet written.
+	restore := func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for key, n := range counts {
+			c.counts[key] += n
+		}
+		for key, stats := range posts {
+			if c.posts[key] == nil {
+				c.posts[key] = new([numPostStats]int64)
+			}
+			for i, n := range stats {
+				c.posts[key][i] += n
+			}
+		}
+		for key, n := range searches {
+			if c.searches[key] == nil {
+				c.searches[key] = &searchCounts{}
+			}
+			c.searches[key].searches += n.searches
+			c.searches[key].zeroResults += n.zeroResults
+		}
+	}
+
+	query := "INSERT INTO analytics_rollups (event, dimension, hour, total) VALUES (?, ?, ?, ?) " +
+		msql.OnConflictUpdate("event", "hour", "dimension") + "total = total + " + msql.Inserted("total")
+	for key, n := range counts {
+		if _, err := db.ExecContext(ctx, query, key.event, key.dimension, key.hour, n); err != nil {
+			restore()
+			return err
+		}
+		delete(counts, key)
+	}
+
+	query = "INSERT INTO post_stats (post_id, hour, views, upvotes, downvotes, comments) VALUES (?, ?, ?, ?, ?, ?) " +
+		msql.OnConflictUpdate("post_id", "hour") +
+		"views = views + " + msql.Inserted("views") +
+		", upvotes = upvotes + " + msql.Inserted("upvotes") +
+		", downvotes = downvotes + " + msql.Inserted("downvotes") +
+		", comments = comments + " + msql.Inserted("comments")
+	for key, stats := range posts {
+		if _, err := db.ExecContext(ctx, query, key.post, key.hour, stats[PostStatViews], stats[PostStatUpvotes], stats[PostStatDownvotes], stats[PostStatComments]); err != nil {
+			restore()
+			return err
+		}
+		delete(posts, key)
+	}
+
+	query = "INSERT INTO search_queries (query, hour, searches, zero_results) VALUES (?, ?, ?, ?) " +
+		msql.OnConflictUpdate("query", "hour") +
+		"searches = searches + " + msql.Inserted("searches") +
+		", zero_results = zero_results + " + msql.Inserted("zero_results")
+	for key, n := range searches {
+		if _, err := db.ExecContext(ctx, query, key.query, key.hour, n.searches, n.zeroResults); err != nil {
+			restore()
+			return err
+		}
+		delete(searches, key)
+	}
+	return nil
+}
+
+// AnalyticsPoint is the number of events in the hour or the day starting at
+// Time.
+type AnalyticsPoint struct {
+	Time  time.Time `json:"time"`
+	Count int64     `json:"count"`
+}
+
+// AnalyticsDimension is the number of events with a dimension.
+type AnalyticsDimension struct {
+	Dimension string `json:"dimension"`
+	Count     int64  `json:"count"`
+}
+
+// GetAnalyticsSeries returns the number of events, of all dimensions or, if
+// dimension is not nil, of that dimension, in each hour (or, if daily is true,
+// each day, in UTC) from from to to. Periods without events are omitted.
+func GetAnalyticsSeries(ctx context.Context, db *sql.DB, event string, dimension *string, from, to time.Time, daily bool) ([]*AnalyticsPoint, error) {
+	query := "SELECT hour, SUM(total) FROM analytics_rollups WHERE event = ? AND hour >= ? AND hour < ?"
+	args := []any{event, from.UTC().Truncate(time.Hour), to.UTC()}
+	if dimension != nil {
+		query += " AND dimension = ?"
+		args = append(args, *dimension)
+	}
+	query += " GROUP BY hour ORDER BY hour"
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []*AnalyticsPoint{}
+	for rows.Next() {
+		p := &AnalyticsPoint{}
+		if err := rows.Scan(&p.Time, &p.Count); err != nil {
+			return nil, err
+		}
+		p.Time = p.Time.UTC()
+		if daily {
+			p.Time = time.Date(p.Time.Year(), p.Time.Month(), p.Time.Day(), 0, 0, 0, 0, time.UTC)
+			if n := len(points); n > 0 && points[n-1].Time.Equal(p.Time) {
+				points[n-1].Count += p.Count
+				continue
+			}
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// GetAnalyticsDimensions returns the limit dimensions of event with the most
+// events from from to to, most first.
+func GetAnalyticsDimensions(ctx context.Context, db *sql.DB, event string, from, to time.Time, limit int) ([]*AnalyticsDimension, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT dimension, SUM(total)
+		FROM analytics_rollups
+		WHERE event = ? AND hour >= ? AND hour < ?
+		GROUP BY dimension
+		ORDER BY SUM(total) DESC, dimension
+		LIMIT ?`, event, from.UTC().Truncate(time.Hour), to.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*AnalyticsDimension{}
+	for rows.Next() {
+		d := &AnalyticsDimension{}
+		if err := rows.Scan(&d.Dimension, &d.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, d)
+	}
+	return items, rows.Err()
+}
+
+// GetAnalyticsTotals returns the number of events of each kind from from to
+// to.
+func GetAnalyticsTotals(ctx context.Context, db *sql.DB, from, to time.Time) (map[string]int64, error) {
+	rows, err := db.QueryContext(ctx, "SELECT event, SUM(total) FROM analytics_rollups WHERE hour >= ? AND hour < ? GROUP BY event", from.UTC().Truncate(time.Hour), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[string]int64)
+	for rows.Next() {
+		var event string
+		var n int64
+		if err := rows.Scan(&event, &n); err != nil {
+			return nil, err
+		}
+		totals[event] = n
+	}
+	return totals, rows.Err()
+}
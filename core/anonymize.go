@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// anonymizeRule is a change Anonymize makes to the rows of table that match
+// where: the rows are updated with set, or deleted if set is empty.
+type anonymizeRule struct {
+	table, where, set string
+	description       string
+}
+
+// anonymizeRules are the changes, besides the renaming of users, that
+// Anonymize makes. Private data that's not needed to run the site is deleted,
+// and the rest is replaced with placeholders. Keys (like those of Web Push and
+// of the communities on the fediverse) are created anew when needed.
+var anonymizeRules = []anonymizeRule{
+	{"users", "TRUE", "about_me = NULL, last_seen_ip = NULL, notifications_new_count = 0", "Clear the about sections and the IP addresses of users"},
+	{"comments", "user_deleted = FALSE", "username = (SELECT users.username FROM users WHERE users.id = comments.user_id)", "Update the usernames of comments"},
+	{"posts", "deleted = TRUE AND deleted_content = FALSE", "body = ''", "Clear the bodies of deleted posts"},
+	{"signups", "TRUE", "ip = '192.0.2.1', network = '192.0.2.0/24'", "Replace the IP addresses of signups"},
+	{"notifications", "TRUE", "", "Delete the notifications"},
+	{"auth_events", "TRUE", "", "Delete the log of logins"},
+	{"login_devices", "TRUE", "", "Delete the devices users logged in with"},
+	{"web_push_subscriptions", "TRUE", "", "Delete the Web Push subscriptions"},
+	{"api_tokens", "TRUE", "", "Delete the API tokens"},
+	{"passkeys", "TRUE", "", "Delete the passkeys"},
+	{"external_identities", "TRUE", "", "Delete the linked external accounts"},
+	{"oauth_codes", "TRUE", "", "Delete the OAuth codes"},
+	{"oauth_clients", "TRUE", "", "Delete the OAuth clients"},
+	{"activitypub_keys", "TRUE", "", "Delete the keys of communities"},
+	{"analytics", "TRUE", "", "Delete the analytics events"},
+	{"application_data", "`key` = '" + vapidKeysDBKey + "'", "", "Delete the Web Push keys"},
+}
+
+// AnonymizeStep is a change made, or to be made, by Anonymize.
+type AnonymizeStep struct {
+	Description string
+	Rows        int64
+}
+
+// AnonymizeDryRun returns the changes Anonymize would make to db, without
+// making them.
+func AnonymizeDryRun(ctx context.Context, db *sql.DB) ([]*AnonymizeStep, error) {
+	steps := []*AnonymizeStep{{Description: "Rename users and replace their emails and passwords"}}
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&steps[0].Rows); err != nil {
+		return nil, err
+	}
+	for _, rule := range anonymizeRules {
+		step := &AnonymizeStep{Description: rule.description}
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+rule.table+" WHERE "+rule.where).Scan(&step.Rows); err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// Anonymize scrambles the private data in db (which is to be a copy of the
+// database of the site, never the database itself): users are renamed (in the
+// order they signed up, to user1, user2, and so on), their emails replaced (to
+// user1@example.com, and so on), and their passwords set to password, and the
+// rest of the private data is cleared or deleted (see anonymizeRules). Public
+// content is kept.
+func Anonymize(ctx context.Context, db *sql.DB, password string) ([]*AnonymizeStep, error) {
+	hash, err := HashPassword([]byte(password))
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []*AnonymizeStep
+	err = msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, "SELECT id FROM users ORDER BY created_at, id")
+		if err != nil {
+			return err
+		}
+		var users []uid.ID
+		for rows.Next() {
+			var id uid.ID
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			users = append(users, id)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		// Users are first given names that no user could have, so that no
+		// new name clashes with an old one not yet changed.
+		for i, id := range users {
+			if _, err := tx.ExecContext(ctx, "UPDATE users SET username = ?, username_lc = ? WHERE id = ?", "~"+strconv.Itoa(i), "~"+strconv.Itoa(i), id); err != nil {
+				return err
+			}
+		}
+		for i, id := range users {
+			name := "user" + strconv.Itoa(i+1)
+			_, err := tx.ExecContext(ctx, "UPDATE users SET username = ?, username_lc = ?, email = CASE WHEN email IS NULL THEN NULL ELSE ? END, password = ? WHERE id = ?",
+				name, name, name+"@example.com", hash, id)
+			if err != nil {
+				return err
+			}
+		}
+		steps = append(steps, &AnonymizeStep{Description: "Rename users and replace their emails and passwords", Rows: int64(len(users))})
+
+		for _, rule := range anonymizeRules {
+			query := "DELETE FROM " + rule.table + " WHERE " + rule.where
+			if rule.set != "" {
+				query = "UPDATE " + rule.table + " SET " + rule.set + " WHERE " + rule.where
+			}
+			res, err := tx.ExecContext(ctx, query)
+			if err != nil {
+				return err
+			}
+			step := &AnonymizeStep{Description: rule.description}
+			if step.Rows, err = res.RowsAffected(); err != nil {
+				return err
+			}
+			steps = append(steps, step)
+		}
+		return nil
+	})
+	return steps, err
+}
@@ -0,0 +1,579 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Scope is a permission granted to an API token.
+type Scope string
+
+// The scopes an API token may have.
+const (
+	ScopeRead  = Scope("read")  // Read anything the user can see.
+	ScopeWrite = Scope("write") // Create, edit, and delete posts, comments, and so on.
+	ScopeVote  = Scope("vote")  // Vote on posts and comments.
+	ScopeMod   = Scope("mod")   // Perform moderator actions.
+)
+
+var allScopes = []Scope{ScopeRead, ScopeWrite, ScopeVote, ScopeMod}
+
+// Valid reports whether s is a known scope.
+func (s Scope) Valid() bool {
+	return slices.Contains(allScopes, s)
+}
+
+// Scopes is a set of scopes. In the database, and in OAuth2 requests, it's
+// represented as a space separated list.
+type Scopes []Scope
+
+// ParseScopes parses a space (or comma) separated list of scopes.
+func ParseScopes(s string) (Scopes, error) {
+	var scopes Scopes
+	for _, text := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' }) {
+		scope := Scope(text)
+		if !scope.Valid() {
+			return nil, httperr.NewBadRequest("invalid_scope", "Invalid scope "+text+".")
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, httperr.NewBadRequest("invalid_scope", "No scopes.")
+	}
+	return scopes, nil
+}
+
+// Has reports whether scope is in s.
+func (s Scopes) Has(scope Scope) bool {
+	return slices.Contains(s, scope)
+}
+
+func (s Scopes) String() string {
+	strs := make([]string, len(s))
+	for i, scope := range s {
+		strs[i] = string(scope)
+	}
+	return strings.Join(strs, " ")
+}
+
+// Scan implements sql.Scanner interface.
+func (s *Scopes) Scan(src any) (err error) {
+	var text string
+	switch v := src.(type) {
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	}
+	*s, err = ParseScopes(text)
+	return
+}
+
+const apiTokenPrefix = "dct_" // so that leaked tokens are easy to spot
+
+// newSecret returns a random string (with prefix prefixed) and its hash.
+func newSecret(prefix string) (secret, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret = prefix + base64.RawURLEncoding.EncodeToString(b)
+	return secret, hashSecret(secret), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+var errAPITokenNotFound = httperr.NewNotFound("api_token_not_found", "API token not found.")
+
+// APIToken is a token with which a user (or a third-party client acting on
+// behalf of the user) can access the API without a session cookie. A token is
+// either a personal access token, created by the user, or an OAuth2 access
+// token, issued to a client.
+type APIToken struct {
+	ID         int           `json:"id"`
+	UserID     uid.ID        `json:"userId"`
+	ClientID   uid.NullID    `json:"clientId"` // Null for personal access tokens.
+	Name       string        `json:"name"`
+	Scopes     Scopes        `json:"scopes"`
+	LastUsedAt msql.NullTime `json:"lastUsedAt"`
+	ExpiresAt  msql.NullTime `json:"expiresAt"`
+	CreatedAt  time.Time     `json:"createdAt"`
+
+	// The token itself. It's only set when the token is created (only its
+	// hash is stored).
+	Token string `json:"token,omitempty"`
+
+	// The refresh token of a token issued to an OAuth2 client, with which the
+	// client renews it (see OAuthClient.RefreshOAuthToken). Like Token, it's
+	// only set when the token is created.
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+// Expired reports whether the token has expired.
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt.Valid && time.Now().After(t.ExpiresAt.Time)
+}
+
+// createAPIToken creates an API token, with the columns cols in addition to
+// the usual ones.
+func createAPIToken(ctx context.Context, db *sql.DB, user uid.ID, client *uid.ID, name string, scopes Scopes, expiresAt *time.Time, cols ...msql.ColumnValue) (*APIToken, error) {
+	token, hash, err := newSecret(apiTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+	cols = append([]msql.ColumnValue{
+		{Name: "user_id", Value: user},
+		{Name: "name", Value: name},
+		{Name: "token_hash", Value: hash},
+		{Name: "scopes", Value: scopes.String()},
+	}, cols...)
+	if client != nil {
+		cols = append(cols, msql.ColumnValue{Name: "client_id", Value: *client})
+	}
+	if expiresAt != nil {
+		cols = append(cols, msql.ColumnValue{Name: "expires_at", Value: *expiresAt})
+	}
+	query, args := msql.BuildInsertQuery("api_tokens", cols)
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	t, err := GetAPIToken(ctx, db, user, int(id))
+	if err != nil {
+		return nil, err
+	}
+	t.Token = token
+	return t, nil
+}
+
+// CreatePersonalAccessToken creates an API token for user. If expiresIn is
+// not zero, the token expires after that duration.
+func CreatePersonalAccessToken(ctx context.Context, db *sql.DB, user uid.ID, name string, scopes Scopes, expiresIn time.Duration) (*APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 255 {
+		return nil, httperr.NewBadRequest("invalid_token_name", "Token name must be 1 to 255 characters long.")
+	}
+	var expiresAt *time.Time
+	if expiresIn > 0 {
+		t := time.Now().Add(expiresIn)
+		expiresAt = &t
+	}
+	return createAPIToken(ctx, db, user, nil, name, scopes, expiresAt)
+}
+
+const selectAPITokenColumns = "SELECT id, user_id, client_id, name, scopes, last_used_at, expires_at, created_at FROM api_tokens "
+
+func scanAPITokens(rows *sql.Rows) ([]*APIToken, error) {
+	defer rows.Close()
+	tokens := []*APIToken{}
+	for rows.Next() {
+		t := &APIToken{}
+		if err := rows.Scan(&t.ID, &t.UserID, &t.ClientID, &t.Name, &t.Scopes, &t.LastUsedAt, &t.ExpiresAt, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// GetAPITokens returns the API tokens of user (both personal access tokens
+// and those issued to OAuth2 clients).
+func GetAPITokens(ctx context.Context, db *sql.DB, user uid.ID) ([]*APIToken, error) {
+	rows, err := db.QueryContext(ctx, selectAPITokenColumns+"WHERE user_id = ? ORDER BY id", user)
+	if err != nil {
+		return nil, err
+	}
+	return scanAPITokens(rows)
+}
+
+// GetAPIToken returns the API token of user with the ID id.
+func GetAPIToken(ctx context.Context, db *sql.DB, user uid.ID, id int) (*APIToken, error) {
+	rows, err := db.QueryContext(ctx, selectAPITokenColumns+"WHERE id = ? AND user_id = ?", id, user)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := scanAPITokens(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errAPITokenNotFound
+	}
+	return tokens[0], nil
+}
+
+// DeleteAPIToken revokes the API token of user with the ID id.
+func DeleteAPIToken(ctx context.Context, db *sql.DB, user uid.ID, id int) error {
+	res, err := db.ExecContext(ctx, "DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, user)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errAPITokenNotFound
+	}
+	return nil
+}
+
+var errInvalidAPIToken = &httperr.Error{HTTPStatus: http.StatusUnauthorized, Code: "invalid_token", Message: "Invalid or expired API token."}
+
+// AuthenticateAPIToken returns the API token whose value is token. It returns
+// an error if there's no such token, if it has expired, or if its user is
+// banned or deleted.
+func AuthenticateAPIToken(ctx context.Context, db *sql.DB, token string) (*APIToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, errInvalidAPIToken
+	}
+	rows, err := db.QueryContext(ctx, selectAPITokenColumns+"WHERE token_hash = ?", hashSecret(token))
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := scanAPITokens(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 || tokens[0].Expired() {
+		return nil, errInvalidAPIToken
+	}
+	t := tokens[0]
+
+	user, err := GetUser(ctx, db, t.UserID, nil)
+	if err != nil {
+		if err == errUserNotFound {
+			return nil, errInvalidAPIToken
+		}
+		return nil, err
+	}
+	if user.Banned || user.DeletedAt.Valid {
+		return nil, errInvalidAPIToken
+	}
+
+	// Updating the last used time at most once a minute is good enough.
+	if !t.LastUsedAt.Valid || time.Since(t.LastUsedAt.Time) > time.Minute {
+		now := time.Now()
+		if _, err := db.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = ? WHERE id = ?", now, t.ID); err != nil {
+			return nil, err
+		}
+		t.LastUsedAt = msql.NewNullTime(now)
+	}
+	return t, nil
+}
+
+var (
+	errOAuthClientNotFound = httperr.NewNotFound("oauth_client_not_found", "OAuth client not found.")
+	errInvalidRedirectURI  = httperr.NewBadRequest("invalid_redirect_uri", "Invalid redirect URI.")
+)
+
+// OAuthClient is a third-party application registered to obtain API tokens
+// of users using the OAuth2 authorization code flow.
+type OAuthClient struct {
+	ID           uid.ID    `json:"id"` // The OAuth2 client_id.
+	UserID       uid.ID    `json:"userId"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirectUris"`
+	CreatedAt    time.Time `json:"createdAt"`
+
+	// The client secret. It's only set when the client is created (only its
+	// hash is stored).
+	Secret string `json:"secret,omitempty"`
+
+	secretHash string
+}
+
+// CreateOAuthClient registers an OAuth2 client owned by user.
+func CreateOAuthClient(ctx context.Context, db *sql.DB, user uid.ID, name string, redirectURIs []string) (*OAuthClient, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 255 {
+		return nil, httperr.NewBadRequest("invalid_client_name", "Client name must be 1 to 255 characters long.")
+	}
+	if len(redirectURIs) == 0 {
+		return nil, errInvalidRedirectURI
+	}
+	for _, uri := range redirectURIs {
+		if !validRedirectURI(uri) {
+			return nil, errInvalidRedirectURI
+		}
+	}
+
+	secret, hash, err := newSecret("")
+	if err != nil {
+		return nil, err
+	}
+	c := &OAuthClient{
+		ID:           uid.New(),
+		UserID:       user,
+		Name:         name,
+		RedirectURIs: redirectURIs,
+		CreatedAt:    time.Now(),
+		Secret:       secret,
+		secretHash:   hash,
+	}
+	query, args := msql.BuildInsertQuery("oauth_clients", []msql.ColumnValue{
+		{Name: "id", Value: c.ID},
+		{Name: "user_id", Value: c.UserID},
+		{Name: "name", Value: c.Name},
+		{Name: "secret_hash", Value: c.secretHash},
+		{Name: "redirect_uris", Value: strings.Join(c.RedirectURIs, "\n")},
+		{Name: "created_at", Value: c.CreatedAt},
+	})
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// validRedirectURI reports whether uri may be registered as a redirect URI of
+// an OAuth2 client: an https URL, or an http URL of the loopback interface (on
+// which native apps receive the redirect, see RFC 8252), without a fragment.
+func validRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" || u.User != nil || strings.ContainsAny(uri, "# \n") {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		if host := u.Hostname(); host != "localhost" {
+			ip := net.ParseIP(host)
+			return ip != nil && ip.IsLoopback()
+		}
+		return true
+	}
+	return false
+}
+
+func getOAuthClients(ctx context.Context, db *sql.DB, where string, args ...any) ([]*OAuthClient, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, user_id, name, secret_hash, redirect_uris, created_at FROM oauth_clients "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*OAuthClient{}
+	for rows.Next() {
+		c := &OAuthClient{}
+		var uris string
+		if err := rows.Scan(&c.ID, &c.UserID, &c.Name, &c.secretHash, &uris, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		c.RedirectURIs = strings.Split(uris, "\n")
+		clients = append(clients, c)
+	}
+	return clients, rows.Err()
+}
+
+// GetOAuthClient returns the OAuth2 client with the ID id.
+func GetOAuthClient(ctx context.Context, db *sql.DB, id uid.ID) (*OAuthClient, error) {
+	clients, err := getOAuthClients(ctx, db, "WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(clients) == 0 {
+		return nil, errOAuthClientNotFound
+	}
+	return clients[0], nil
+}
+
+// GetOAuthClients returns the OAuth2 clients registered by user.
+func GetOAuthClients(ctx context.Context, db *sql.DB, user uid.ID) ([]*OAuthClient, error) {
+	return getOAuthClients(ctx, db, "WHERE user_id = ? ORDER BY created_at", user)
+}
+
+// Delete deletes the client and revokes all the tokens issued to it.
+func (c *OAuthClient) Delete(ctx context.Context, db *sql.DB) error {
+	return msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM oauth_codes WHERE client_id = ?", c.ID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM api_tokens WHERE client_id = ?", c.ID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM oauth_clients WHERE id = ?", c.ID)
+		return err
+	})
+}
+
+// ValidSecret reports whether secret is the client's secret.
+func (c *OAuthClient) ValidSecret(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(c.secretHash)) == 1
+}
+
+// ValidRedirectURI reports whether uri is one of the client's registered
+// redirect URIs.
+func (c *OAuthClient) ValidRedirectURI(uri string) bool {
+	return slices.Contains(c.RedirectURIs, uri)
+}
+
+// oauthCodeLifetime is how long an authorization code can be exchanged for a
+// token.
+const oauthCodeLifetime = 10 * time.Minute
+
+// CreateOAuthCode issues an authorization code with which the client c can
+// obtain an API token of user with the scopes scopes. The code is valid for a
+// few minutes and can only be used once. If codeChallenge is not empty, the
+// client must present the matching PKCE verifier (S256 method only).
+func (c *OAuthClient) CreateOAuthCode(ctx context.Context, db *sql.DB, user uid.ID, scopes Scopes, redirectURI, codeChallenge string) (string, error) {
+	if !c.ValidRedirectURI(redirectURI) {
+		return "", errInvalidRedirectURI
+	}
+	code, hash, err := newSecret("")
+	if err != nil {
+		return "", err
+	}
+	query, args := msql.BuildInsertQuery("oauth_codes", []msql.ColumnValue{
+		{Name: "code_hash", Value: hash},
+		{Name: "client_id", Value: c.ID},
+		{Name: "user_id", Value: user},
+		{Name: "scopes", Value: scopes.String()},
+		{Name: "redirect_uri", Value: redirectURI},
+		{Name: "code_challenge", Value: msql.NilIfEmptyString(codeChallenge)},
+		{Name: "expires_at", Value: time.Now().Add(oauthCodeLifetime)},
+	})
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+var errInvalidGrant = httperr.NewBadRequest("invalid_grant", "Invalid, expired, or already used authorization code.")
+
+// ExchangeOAuthCode exchanges an authorization code issued to c (see
+// CreateOAuthCode) for an API token, which expires after oauthTokenLifetime
+// and comes with a refresh token.
+func (c *OAuthClient) ExchangeOAuthCode(ctx context.Context, db *sql.DB, code, redirectURI, codeVerifier string) (*APIToken, error) {
+	var (
+		user          uid.ID
+		scopes        Scopes
+		codeRedirect  string
+		codeChallenge msql.NullString
+		expiresAt     time.Time
+	)
+	hash := hashSecret(code)
+	row := db.QueryRowContext(ctx, "SELECT user_id, scopes, redirect_uri, code_challenge, expires_at FROM oauth_codes WHERE code_hash = ? AND client_id = ?", hash, c.ID)
+	if err := row.Scan(&user, &scopes, &codeRedirect, &codeChallenge, &expiresAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errInvalidGrant
+		}
+		return nil, err
+	}
+
+	// Codes are single use.
+	if res, err := db.ExecContext(ctx, "DELETE FROM oauth_codes WHERE code_hash = ?", hash); err != nil {
+		return nil, err
+	} else if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, errInvalidGrant // used concurrently
+	}
+
+	if time.Now().After(expiresAt) || redirectURI != codeRedirect {
+		return nil, errInvalidGrant
+	}
+	if codeChallenge.Valid {
+		sum := sha256.Sum256([]byte(codeVerifier))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != codeChallenge.String {
+			return nil, errInvalidGrant
+		}
+	}
+	return c.issueToken(ctx, db, user, scopes)
+}
+
+const oauthRefreshTokenPrefix = "dcr_"
+
+// oauthTokenLifetime is how long an API token issued to an OAuth2 client is
+// valid, after which the client is to renew it with its refresh token.
+const oauthTokenLifetime = time.Hour
+
+// oauthRefreshTokenLifetime is how long the refresh token of an API token
+// issued to an OAuth2 client can be used. Since each refresh comes with a new
+// refresh token, it's how long a client can go unused before the user has to
+// authorize it again.
+const oauthRefreshTokenLifetime = 90 * 24 * time.Hour
+
+// issueToken creates an API token of user, with scopes, for c.
+func (c *OAuthClient) issueToken(ctx context.Context, db *sql.DB, user uid.ID, scopes Scopes) (*APIToken, error) {
+	refresh, refreshHash, err := newSecret(oauthRefreshTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(oauthTokenLifetime)
+	t, err := createAPIToken(ctx, db, user, &c.ID, c.Name, scopes, &expiresAt,
+		msql.ColumnValue{Name: "refresh_token_hash", Value: refreshHash},
+		msql.ColumnValue{Name: "refresh_expires_at", Value: now.Add(oauthRefreshTokenLifetime)})
+	if err != nil {
+		return nil, err
+	}
+	t.RefreshToken = refresh
+	return t, nil
+}
+
+var errInvalidRefreshToken = httperr.NewBadRequest("invalid_grant", "Invalid, expired, or already used refresh token.")
+
+// RefreshOAuthToken renews an API token issued to c with its refresh token:
+// the token is replaced with a new one, with the same scopes and a new refresh
+// token.
+func (c *OAuthClient) RefreshOAuthToken(ctx context.Context, db *sql.DB, refreshToken string) (*APIToken, error) {
+	if !strings.HasPrefix(refreshToken, oauthRefreshTokenPrefix) {
+		return nil, errInvalidRefreshToken
+	}
+	var (
+		id        int
+		user      uid.ID
+		scopes    Scopes
+		expiresAt msql.NullTime
+	)
+	row := db.QueryRowContext(ctx, "SELECT id, user_id, scopes, refresh_expires_at FROM api_tokens WHERE refresh_token_hash = ? AND client_id = ?", hashSecret(refreshToken), c.ID)
+	if err := row.Scan(&id, &user, &scopes, &expiresAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	// Refresh tokens are single use.
+	if res, err := db.ExecContext(ctx, "DELETE FROM api_tokens WHERE id = ?", id); err != nil {
+		return nil, err
+	} else if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, errInvalidRefreshToken // used concurrently
+	}
+
+	if !expiresAt.Valid || time.Now().After(expiresAt.Time) {
+		return nil, errInvalidRefreshToken
+	}
+	return c.issueToken(ctx, db, user, scopes)
+}
+
+// PurgeExpiredOAuthCodes deletes the authorization codes that have expired.
+func PurgeExpiredOAuthCodes(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM oauth_codes WHERE expires_at < ?", time.Now())
+	return err
+}
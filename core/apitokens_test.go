@@ -0,0 +1,93 @@
+package core
+
+import (
+	"context"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes("read vote,read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := scopes.String(); got != "read vote" {
+		t.Errorf("scopes are %q (want %q)", got, "read vote")
+	}
+	if !scopes.Has(ScopeVote) || scopes.Has(ScopeWrite) {
+		t.Errorf("unexpected scopes %v", scopes)
+	}
+	for _, s := range []string{"", "  ", "read admin"} {
+		if _, err := ParseScopes(s); err == nil {
+			t.Errorf("ParseScopes(%q) returned no error", s)
+		}
+	}
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	tests := []struct {
+		uri   string
+		valid bool
+	}{
+		{"https://example.com/callback", true},
+		{"https://example.com/callback?app=1", true},
+		{"http://localhost:8080/callback", true},
+		{"http://127.0.0.1/callback", true},
+		{"http://[::1]:3000/callback", true},
+		{"http://example.com/callback", false},
+		{"http://localhost.example.com/callback", false},
+		{"javascript:alert(1)", false},
+		{"data:text/html,hi", false},
+		{"com.example.app:/callback", false},
+		{"https:///callback", false},
+		{"https://user@example.com/callback", false},
+		{"https://example.com/callback#", false},
+		{"https://example.com/call back", false},
+		{"/callback", false},
+	}
+	for _, test := range tests {
+		if got := validRedirectURI(test.uri); got != test.valid {
+			t.Errorf("validRedirectURI(%q) = %v (expected %v)", test.uri, got, test.valid)
+		}
+	}
+}
+
+func TestRefreshOAuthToken(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteTestDB(t)
+	c := &OAuthClient{ID: uid.New(), Name: "client"}
+	user := uid.New()
+
+	first, err := c.issueToken(ctx, db, user, Scopes{ScopeRead, ScopeVote})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.ExpiresAt.Valid || first.RefreshToken == "" {
+		t.Fatalf("token issued without an expiry or a refresh token")
+	}
+
+	second, err := c.RefreshOAuthToken(ctx, db, first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Token == first.Token || second.RefreshToken == first.RefreshToken {
+		t.Error("refresh did not issue new tokens")
+	}
+	if second.Scopes.String() != first.Scopes.String() {
+		t.Errorf("refreshed token has the scopes %q (expected %q)", second.Scopes, first.Scopes)
+	}
+	if _, err := GetAPIToken(ctx, db, user, first.ID); err != errAPITokenNotFound {
+		t.Errorf("refreshed token not revoked (err: %v)", err)
+	}
+
+	// Refresh tokens are single use, and only for the client they were
+	// issued to.
+	if _, err := c.RefreshOAuthToken(ctx, db, first.RefreshToken); err != errInvalidRefreshToken {
+		t.Errorf("second use of a refresh token returned %v (expected errInvalidRefreshToken)", err)
+	}
+	other := &OAuthClient{ID: uid.New(), Name: "other"}
+	if _, err := other.RefreshOAuthToken(ctx, db, second.RefreshToken); err != errInvalidRefreshToken {
+		t.Errorf("refresh by another client returned %v (expected errInvalidRefreshToken)", err)
+	}
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// IsApprovedSubmitter reports whether user is an approved submitter of
+// community. The posts and comments of approved submitters skip the automatic
+// holds (quarantine and comment flood holds) and the new-account restrictions
+// in the community.
+func IsApprovedSubmitter(ctx context.Context, db *sql.DB, community, user uid.ID) (bool, error) {
+	var id uid.ID
+	err := db.QueryRowContext(ctx, "SELECT user_id FROM community_approved_submitters WHERE community_id = ? AND user_id = ?", community, user).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// AddApprovedSubmitter makes user an approved submitter of c. Only mods and
+// admins can do so.
+func (c *Community) AddApprovedSubmitter(ctx context.Context, mod, user uid.ID) error {
+	if is, err := c.UserModOrAdmin(ctx, mod); err != nil {
+		return err
+	} else if !is {
+		return errNotMod
+	}
+	_, err := c.db.ExecContext(ctx, "INSERT INTO community_approved_submitters (community_id, user_id, added_by, created_at) VALUES (?, ?, ?, ?)", c.ID, user, mod, time.Now())
+	if msql.IsErrDuplicateErr(err) {
+		return errAlreadyApprovedSubmitter
+	}
+	return err
+}
+
+// RemoveApprovedSubmitter removes user from the approved submitters of c. Only
+// mods and admins can do so.
+func (c *Community) RemoveApprovedSubmitter(ctx context.Context, mod, user uid.ID) error {
+	if is, err := c.UserModOrAdmin(ctx, mod); err != nil {
+		return err
+	} else if !is {
+		return errNotMod
+	}
+	res, err := c.db.ExecContext(ctx, "DELETE FROM community_approved_submitters WHERE community_id = ? AND user_id = ?", c.ID, user)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errNotApprovedSubmitter
+	}
+	return nil
+}
+
+// GetApprovedSubmitters returns the approved submitters of c.
+func (c *Community) GetApprovedSubmitters(ctx context.Context) ([]*User, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT user_id FROM community_approved_submitters WHERE community_id = ?", c.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uid.ID
+	for rows.Next() {
+		var id uid.ID
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return GetUsersIDs(ctx, c.db, ids, nil)
+}
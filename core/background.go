@@ -0,0 +1,39 @@
+package core
+
+import (
+	"context"
+	"sync"
+)
+
+// backgroundTasks tracks the goroutines started by RunInBackground.
+var backgroundTasks sync.WaitGroup
+
+// RunInBackground runs f in a new goroutine. Use it, instead of the go
+// statement, for work that outlives the request that started it (such as
+// creating notifications, or sending emails), so that the work isn't lost on
+// shutdown (see WaitForBackgroundTasks).
+func RunInBackground(f func()) {
+	backgroundTasks.Add(1)
+	go func() {
+		defer backgroundTasks.Done()
+		f()
+	}()
+}
+
+// WaitForBackgroundTasks waits for all the tasks started in the background
+// (notifications being created, for instance) to finish, or for ctx to be
+// done, whichever happens first. It should be called on shutdown, once no new
+// requests are being served.
+func WaitForBackgroundTasks(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		backgroundTasks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
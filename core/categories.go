@@ -0,0 +1,236 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/internal/utils"
+)
+
+var errCategoryNotFound = httperr.NewNotFound("category/not-found", "Category not found.")
+
+// A Category is one of the site-curated categories (like Tech, Gaming, and
+// Science) that communities pick, so that they can be discovered by topic.
+type Category struct {
+	ID          int       `json:"id"`
+	Slug        string    `json:"slug"` // Unique, lower case, used in URLs.
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Position    int       `json:"position"` // Categories are listed in ascending order of it.
+	CreatedAt   time.Time `json:"createdAt"`
+
+	// The number of (undeleted) communities in the category.
+	NumCommunities int `json:"noCommunities"`
+}
+
+// validate normalizes the fields of c and returns an httperr.Error if any of
+// them are invalid.
+func (c *Category) validate() error {
+	c.Slug = strings.ToLower(strings.TrimSpace(c.Slug))
+	c.Name = strings.TrimSpace(c.Name)
+	c.Description = utils.TruncateUnicodeString(strings.TrimSpace(c.Description), 255)
+	if c.Slug == "" || len(c.Slug) > 32 {
+		return httperr.NewBadRequest("category/invalid-slug", "Category slug must be between 1 and 32 characters long.")
+	}
+	for _, r := range c.Slug {
+		if !((r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-') {
+			return httperr.NewBadRequest("category/invalid-slug", "Category slug may only contain letters, digits, and hyphens.")
+		}
+	}
+	if c.Name == "" || utf8.RuneCountInString(c.Name) > 64 {
+		return httperr.NewBadRequest("category/invalid-name", "Category name must be between 1 and 64 characters long.")
+	}
+	return nil
+}
+
+const selectCategoriesQuery = `SELECT categories.id, categories.slug, categories.name, categories.description, categories.position, categories.created_at,
+	(SELECT COUNT(*) FROM community_categories
+		INNER JOIN communities ON communities.id = community_categories.community_id
+		WHERE community_categories.category_id = categories.id AND communities.deleted_at IS NULL)
+	FROM categories `
+
+func scanCategories(rows *sql.Rows, err error) ([]*Category, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []*Category{}
+	for rows.Next() {
+		c := &Category{}
+		if err := rows.Scan(&c.ID, &c.Slug, &c.Name, &c.Description, &c.Position, &c.CreatedAt, &c.NumCommunities); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	return categories, rows.Err()
+}
+
+// GetCategories returns all categories, in the order they're to be listed in.
+func GetCategories(ctx context.Context, db *sql.DB) ([]*Category, error) {
+	return scanCategories(db.QueryContext(ctx, selectCategoriesQuery+"ORDER BY categories.position, categories.name"))
+}
+
+// GetCategory returns the category with slug, or a not-found httperr.Error.
+func GetCategory(ctx context.Context, db *sql.DB, slug string) (*Category, error) {
+	categories, err := scanCategories(db.QueryContext(ctx, selectCategoriesQuery+"WHERE categories.slug = ?", strings.ToLower(slug)))
+	if err != nil {
+		return nil, err
+	}
+	if len(categories) == 0 {
+		return nil, errCategoryNotFound
+	}
+	return categories[0], nil
+}
+
+// CreateCategory adds the category c (of which ID and CreatedAt are ignored).
+func CreateCategory(ctx context.Context, db *sql.DB, c *Category) (*Category, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	_, err := db.ExecContext(ctx, "INSERT INTO categories (slug, name, description, position, created_at) VALUES (?, ?, ?, ?, ?)",
+		c.Slug, c.Name, c.Description, c.Position, time.Now())
+	if err != nil {
+		if msql.IsErrDuplicateErr(err) {
+			return nil, httperr.NewBadRequest("category/exists", "A category with that slug already exists.")
+		}
+		return nil, err
+	}
+	return GetCategory(ctx, db, c.Slug)
+}
+
+// UpdateCategory changes the slug, name, description, and position of the
+// category with the ID c.ID to those of c.
+func UpdateCategory(ctx context.Context, db *sql.DB, c *Category) (*Category, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	res, err := db.ExecContext(ctx, "UPDATE categories SET slug = ?, name = ?, description = ?, position = ? WHERE id = ?",
+		c.Slug, c.Name, c.Description, c.Position, c.ID)
+	if err != nil {
+		if msql.IsErrDuplicateErr(err) {
+			return nil, httperr.NewBadRequest("category/exists", "A category with that slug already exists.")
+		}
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, errCategoryNotFound
+	}
+	return GetCategory(ctx, db, c.Slug)
+}
+
+// DeleteCategory deletes the category with the ID id, taking the communities
+// in it out of it.
+func DeleteCategory(ctx context.Context, db *sql.DB, id int) error {
+	return msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM community_categories WHERE category_id = ?", id); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, "DELETE FROM categories WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return errCategoryNotFound
+		}
+		return nil
+	})
+}
+
+// SetCategories sets the categories of c to those with slugs, of which there
+// may be at most max. Only the mods of c (and admins) may set them.
+func (c *Community) SetCategories(ctx context.Context, mod uid.ID, slugs []string, max int) error {
+	if is, err := c.UserModOrAdmin(ctx, mod); err != nil {
+		return err
+	} else if !is {
+		return errNotMod
+	}
+
+	var ids []int
+	var set []string
+	for _, slug := range slugs {
+		slug = strings.ToLower(strings.TrimSpace(slug))
+		if slices.Contains(set, slug) {
+			continue
+		}
+		category, err := GetCategory(ctx, c.db, slug)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, category.ID)
+		set = append(set, category.Slug)
+	}
+	if len(ids) > max {
+		return httperr.NewBadRequest("category/too-many", "Too many categories.")
+	}
+
+	err := msql.Transact(ctx, c.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM community_categories WHERE community_id = ?", c.ID); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO community_categories (community_id, category_id) VALUES (?, ?)", c.ID, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return populateCommunitiesCategories(ctx, c.db, []*Community{c})
+}
+
+// populateCommunitiesCategories sets the Categories field of comms.
+func populateCommunitiesCategories(ctx context.Context, db *sql.DB, comms []*Community) error {
+	if len(comms) == 0 {
+		return nil
+	}
+	byID := make(map[uid.ID]*Community, len(comms))
+	args := make([]any, len(comms))
+	for i, c := range comms {
+		c.Categories = []string{}
+		byID[c.ID] = c
+		args[i] = c.ID
+	}
+
+	where := "WHERE community_categories.community_id IN " + msql.InClauseQuestionMarks(len(args))
+	if len(args) > 500 {
+		// Cheaper (and within the limits on the number of query parameters)
+		// to fetch all of them.
+		where, args = "", nil
+	}
+	query := `SELECT community_categories.community_id, categories.slug FROM community_categories
+		INNER JOIN categories ON categories.id = community_categories.category_id ` + where + `
+		ORDER BY categories.position, categories.name`
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id   uid.ID
+			slug string
+		)
+		if err := rows.Scan(&id, &slug); err != nil {
+			return err
+		}
+		if c := byID[id]; c != nil {
+			c.Categories = append(c.Categories, slug)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,29 @@
+package core
+
+import "testing"
+
+func TestCategoryValidate(t *testing.T) {
+	tests := []struct {
+		slug, name string
+		expect     string // The normalized slug.
+		valid      bool
+	}{
+		{"tech", "Tech", "tech", true},
+		{" Video-Games ", "Video games", "video-games", true},
+		{"", "Empty", "", false},
+		{"sci fi", "Sci-fi", "", false},
+		{"café", "Café", "", false},
+		{"science", "  ", "", false},
+	}
+	for _, test := range tests {
+		c := &Category{Slug: test.slug, Name: test.name}
+		err := c.validate()
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("validate (slug %q, name %q): valid = %v, expected %v (error: %v)", test.slug, test.name, valid, test.valid, err)
+			continue
+		}
+		if test.valid && c.Slug != test.expect {
+			t.Errorf("validate (slug %q): slug = %q, expected %q", test.slug, c.Slug, test.expect)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+const (
+	// cohortActivationDays is the number of days within which the users of a
+	// cohort must post or comment to count as activated.
+	cohortActivationDays = 7
+
+	// CohortRecomputeDays is the number of the most recent cohorts recomputed
+	// nightly: cohorts older than this are final.
+	CohortRecomputeDays = 31
+)
+
+// Cohort is the users who signed up (or joined a community) on a day, and how
+// they fared. The counts whose window has not yet closed are nil.
+type Cohort struct {
+	Cohort     time.Time `json:"cohort"` // The day (UTC).
+	Users      int       `json:"users"`
+	Activated  *int      `json:"activated"`  // Posted or commented within 7 days.
+	Retained7  *int      `json:"retained7"`  // Active 7 or more days later.
+	Retained30 *int      `json:"retained30"` // Active 30 or more days later.
+	ComputedAt time.Time `json:"computedAt"`
+}
+
+// cohortDay returns the start of the day (UTC) of t.
+func cohortDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// ComputeCohorts computes the site-wide and community cohorts of the days from
+// from to to (both inclusive), replacing those computed before. Site-wide, a
+// user counts as retained if they were seen (if they visited the site) the
+// given number of days after the day of their signup; in a community, if they
+// posted or commented in it.
+func ComputeCohorts(ctx context.Context, db *sql.DB, from, to time.Time) error {
+	now := time.Now()
+	for day := cohortDay(from); !day.After(cohortDay(to)); day = day.AddDate(0, 0, 1) {
+		if !day.Before(now) {
+			break
+		}
+		if err := computeSignupCohort(ctx, db, day, now); err != nil {
+			return err
+		}
+		if err := computeCommunityCohorts(ctx, db, day, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cohortWindows returns the end of the activation window of the cohort of
+// day, and the starts of its retention windows (each a day long), as
+// arguments to the queries computing the cohort. Windows that have not yet
+// closed at now are nil, which makes their counts nil.
+func cohortWindows(day, now time.Time) (activation, retained7, retained30 any) {
+	if end := day.AddDate(0, 0, cohortActivationDays+1); !end.After(now) {
+		activation = end
+	}
+	if start := day.AddDate(0, 0, 7); !start.AddDate(0, 0, 1).After(now) {
+		retained7 = start
+	}
+	if start := day.AddDate(0, 0, 30); !start.AddDate(0, 0, 1).After(now) {
+		retained30 = start
+	}
+	return
+}
+
+func computeSignupCohort(ctx context.Context, db *sql.DB, day, now time.Time) error {
+	activation, retained7, retained30 := cohortWindows(day, now)
+	var (
+		users                              int
+		activated, retained7n, retained30n *int
+	)
+	row := db.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+			SUM(CASE WHEN ? IS NULL THEN NULL
+				WHEN EXISTS (SELECT 1 FROM posts WHERE posts.user_id = users.id AND posts.created_at < ?)
+					OR EXISTS (SELECT 1 FROM comments WHERE comments.user_id = users.id AND comments.created_at < ?) THEN 1
+				ELSE 0 END),
+			SUM(CASE WHEN ? IS NULL THEN NULL WHEN users.last_seen >= ? THEN 1 ELSE 0 END),
+			SUM(CASE WHEN ? IS NULL THEN NULL WHEN users.last_seen >= ? THEN 1 ELSE 0 END)
+		FROM users
+		WHERE users.created_at >= ? AND users.created_at < ? AND users.deleted_at IS NULL`,
+		activation, activation, activation,
+		retained7, retained7,
+		retained30, retained30,
+		day, day.AddDate(0, 0, 1))
+	if err := row.Scan(&users, &activated, &retained7n, &retained30n); err != nil {
+		return err
+	}
+	if users == 0 {
+		_, err := db.ExecContext(ctx, "DELETE FROM signup_cohorts WHERE cohort = ?", day)
+		return err
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO signup_cohorts (cohort, users, activated, retained_7, retained_30, computed_at)
+		VALUES (?, ?, ?, ?, ?, ?) `+msql.UpsertClause([]string{"cohort"}, "users", "activated", "retained_7", "retained_30", "computed_at"),
+		day, users, activated, retained7n, retained30n, now)
+	return err
+}
+
+func computeCommunityCohorts(ctx context.Context, db *sql.DB, day, now time.Time) error {
+	activation, retained7, retained30 := cohortWindows(day, now)
+	// The query counts posts and comments, by the members, in the community.
+	active := func(cmp string) string {
+		return `EXISTS (SELECT 1 FROM posts WHERE posts.community_id = community_members.community_id AND posts.user_id = community_members.user_id AND posts.created_at ` + cmp + ` ?)
+			OR EXISTS (SELECT 1 FROM comments WHERE comments.community_id = community_members.community_id AND comments.user_id = community_members.user_id AND comments.created_at ` + cmp + ` ?)`
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT community_members.community_id, COUNT(*),
+			SUM(CASE WHEN ? IS NULL THEN NULL WHEN `+active("<")+` THEN 1 ELSE 0 END),
+			SUM(CASE WHEN ? IS NULL THEN NULL WHEN `+active(">=")+` THEN 1 ELSE 0 END),
+			SUM(CASE WHEN ? IS NULL THEN NULL WHEN `+active(">=")+` THEN 1 ELSE 0 END)
+		FROM community_members
+		WHERE community_members.created_at >= ? AND community_members.created_at < ?
+		GROUP BY community_members.community_id`,
+		activation, activation, activation,
+		retained7, retained7, retained7,
+		retained30, retained30, retained30,
+		day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var cohorts []*Cohort
+	var communities []uid.ID
+	for rows.Next() {
+		c := &Cohort{Cohort: day, ComputedAt: now}
+		var community uid.ID
+		if err := rows.Scan(&community, &c.Users, &c.Activated, &c.Retained7, &c.Retained30); err != nil {
+			return err
+		}
+		cohorts = append(cohorts, c)
+		communities = append(communities, community)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM community_cohorts WHERE cohort = ?", day); err != nil {
+			return err
+		}
+		for i, c := range cohorts {
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO community_cohorts (community_id, cohort, users, activated, retained_7, retained_30, computed_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				communities[i], c.Cohort, c.Users, c.Activated, c.Retained7, c.Retained30, c.ComputedAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetCohorts returns the site-wide cohorts of the days since since, or, if
+// community is not nil, the cohorts of the community, newest first.
+func GetCohorts(ctx context.Context, db *sql.DB, community *uid.ID, since time.Time) ([]*Cohort, error) {
+	query := "SELECT cohort, users, activated, retained_7, retained_30, computed_at FROM signup_cohorts WHERE cohort >= ? ORDER BY cohort DESC"
+	args := []any{cohortDay(since)}
+	if community != nil {
+		query = "SELECT cohort, users, activated, retained_7, retained_30, computed_at FROM community_cohorts WHERE community_id = ? AND cohort >= ? ORDER BY cohort DESC"
+		args = []any{*community, cohortDay(since)}
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cohorts := []*Cohort{}
+	for rows.Next() {
+		c := &Cohort{}
+		if err := rows.Scan(&c.Cohort, &c.Users, &c.Activated, &c.Retained7, &c.Retained30, &c.ComputedAt); err != nil {
+			return nil, err
+		}
+		c.Cohort = c.Cohort.UTC()
+		cohorts = append(cohorts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return cohorts, nil
+}
@@ -1,14 +1,17 @@
 package core
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"sync"
 	"time"
 
+	"github.com/discuitnet/discuit/internal/highlight"
 	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/images"
 	msql "github.com/discuitnet/discuit/internal/sql"
 	"github.com/discuitnet/discuit/internal/uid"
 	"github.com/discuitnet/discuit/internal/utils"
@@ -39,11 +42,15 @@ type Comment struct {
 	NumRepliesDirect int           `json:"noRepliesDirect"`
 	Ancestors        []uid.ID      `json:"ancestors"` // From root to parent.
 	Body             string        `json:"body"`
+	Image            *images.Image `json:"image"` // An attached image (or GIF), if any.
 	Upvotes          int           `json:"upvotes"`
 	Downvotes        int           `json:"downvotes"`
 	Points           int           `json:"-"`
+	Controversy      int           `json:"controversy"` // See Controversy.
+	Controversial    bool          `json:"controversial"`
 	CreatedAt        time.Time     `json:"createdAt"`
 	EditedAt         msql.NullTime `json:"editedAt"`
+	Version          int           `json:"version"` // See Post.Version.
 	DeletedAt        msql.NullTime `json:"deletedAt"`
 	DeletedBy        uid.NullID    `json:"-"`
 	DeletedAs        UserGroup     `json:"deletedAs,omitempty"`
@@ -53,6 +60,27 @@ type Comment struct {
 	// Reports whether the author of this comment is muted by the viewer.
 	IsAuthorMuted bool `json:"isAuthorMuted,omitempty"`
 
+	// The URLs at which the external images of the body are served by the
+	// image proxy, by URL (see proxiedImages).
+	ProxiedImages map[string]string `json:"proxiedImages,omitempty"`
+
+	// The fenced code blocks of the body, with their languages and
+	// highlighted HTML.
+	CodeBlocks []highlight.Block `json:"codeBlocks,omitempty"`
+
+	// The ID of the chain of self-replies the comment is in, if it's in one
+	// (see markSelfReplyChains), for showing the chain collapsed as a single
+	// thread of updates.
+	ChainID uid.NullID `json:"chainId"`
+
+	// Set if replies of the comment are left out of the comments it was
+	// fetched with (see continueThreads).
+	Continuation *CommentContinuation `json:"continuation,omitempty"`
+
+	// IsNew reports whether the comment was added since the viewer's previous
+	// visit to the post (see MarkNewComments).
+	IsNew bool `json:"isNew,omitempty"`
+
 	ViewerVoted   msql.NullBool `json:"userVoted"`
 	ViewerVotedUp msql.NullBool `json:"userVotedUp"`
 
@@ -81,8 +109,10 @@ func buildSelectCommentsQuery(loggedIn bool, where string) string {
 		"comments.upvotes",
 		"comments.downvotes",
 		"comments.points",
+		"comments.controversy",
 		"comments.created_at",
 		"comments.edited_at",
+		"comments.version",
 		"comments.deleted_at",
 		"comments.deleted_as",
 	}
@@ -95,6 +125,36 @@ func buildSelectCommentsQuery(loggedIn bool, where string) string {
 	return msql.BuildSelectQuery("comments", cols, joins, where)
 }
 
+// commentsStmtCacheSize is the maximum number of prepared statements kept per
+// database for comment queries. Only queries with fixed where clauses go
+// through the cache (not the IN clauses of getCommentsList, which vary with
+// the number of IDs), so there are only a few of them.
+const commentsStmtCacheSize = 64
+
+// commentsQuerier is what comments are selected with: either a database or a
+// prepared statement cache.
+type commentsQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+var (
+	commentsStmtCachesMu sync.Mutex
+	commentsStmtCaches   = make(map[*sql.DB]*msql.StmtCache)
+)
+
+// commentsStmtCache returns the prepared statement cache used for selecting
+// comments from db.
+func commentsStmtCache(db *sql.DB) *msql.StmtCache {
+	commentsStmtCachesMu.Lock()
+	defer commentsStmtCachesMu.Unlock()
+	c, ok := commentsStmtCaches[db]
+	if !ok {
+		c = msql.NewStmtCache(db, commentsStmtCacheSize)
+		commentsStmtCaches[db] = c
+	}
+	return c
+}
+
 // Get comment returns a comment. If viewer is nil, viewer related fields of the
 // comment (like Comment.ViewerVoted) will be nil.
 func GetComment(ctx context.Context, db *sql.DB, id uid.ID, viewer *uid.ID) (*Comment, error) {
@@ -104,9 +164,9 @@ func GetComment(ctx context.Context, db *sql.DB, id uid.ID, viewer *uid.ID) (*Co
 		err   error
 	)
 	if viewer == nil {
-		rows, err = db.QueryContext(ctx, query, id)
+		rows, err = commentsStmtCache(db).QueryContext(ctx, query, id)
 	} else {
-		rows, err = db.QueryContext(ctx, query, viewer, id)
+		rows, err = commentsStmtCache(db).QueryContext(ctx, query, viewer, id)
 	}
 	if err != nil {
 		return nil, err
@@ -150,8 +210,10 @@ func scanComments(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *uid.I
 			&c.Upvotes,
 			&c.Downvotes,
 			&c.Points,
+			&c.Controversy,
 			&c.CreatedAt,
 			&c.EditedAt,
+			&c.Version,
 			&c.DeletedAt,
 			&c.DeletedAs,
 		}
@@ -168,6 +230,9 @@ func scanComments(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *uid.I
 				return nil, err
 			}
 		}
+		c.ProxiedImages = proxiedImages(c.Body)
+		c.CodeBlocks = highlight.Blocks(c.Body)
+		c.Controversial = Controversial(c.Upvotes, c.Downvotes)
 		comments = append(comments, c)
 	}
 
@@ -196,6 +261,9 @@ func scanComments(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *uid.I
 	if err := populateCommentAuthors(ctx, db, comments); err != nil {
 		return nil, fmt.Errorf("failed to populate comments authors: %w", err)
 	}
+	if err := populateCommentsImages(ctx, db, comments); err != nil {
+		return nil, fmt.Errorf("failed to populate comments images: %w", err)
+	}
 
 	for _, c := range comments {
 		c.stripDeletedInfo()
@@ -206,7 +274,21 @@ func scanComments(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *uid.I
 
 // addComment adds a record to the comments table. It does not check if the post
 // is deleted or locked.
-func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, parentID *uid.ID, commentBody string) (*Comment, error) {
+// addCommentOpts are the optional options of addComment, for imported
+// comments.
+type addCommentOpts struct {
+	id                 uid.ID    // if zero, a new ID is generated
+	createdAt          time.Time // if zero, the current time
+	upvotes, downvotes int
+	noNotifications    bool
+	allowDeleted       bool    // allow replies to deleted comments
+	image              *uid.ID // an image saved with SaveCommentImage
+}
+
+func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, parentID *uid.ID, commentBody string, opts *addCommentOpts) (*Comment, error) {
+	if opts == nil {
+		opts = &addCommentOpts{}
+	}
 	commentBody = utils.TruncateUnicodeString(commentBody, maxCommentBodyLength)
 	var (
 		parent    *Comment
@@ -219,7 +301,7 @@ func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, paren
 		if err != nil {
 			return nil, err
 		}
-		if parent.Deleted() {
+		if parent.Deleted() && !opts.allowDeleted {
 			return nil, httperr.NewBadRequest("comment-reply-to-deleted", "Cannot reply to a deleted comment.")
 		}
 		if parent.Depth == maxCommentDepth {
@@ -229,7 +311,24 @@ func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, paren
 		ancestors = append(ancestors, parent.ID)
 	}
 
-	id := uid.New()
+	runHooks := opts.createdAt.IsZero() // Not for imported or generated comments.
+	if runHooks {
+		newComment := &NewComment{
+			Author:   author.ID,
+			Post:     post,
+			ParentID: parentID,
+			Body:     commentBody,
+		}
+		if err := runBeforeCommentHooks(ctx, db, newComment); err != nil {
+			return nil, err
+		}
+		commentBody = utils.TruncateUnicodeString(newComment.Body, maxCommentBodyLength)
+	}
+
+	id := opts.id
+	if id.Zero() {
+		id = uid.New()
+	}
 	f := func(tx *sql.Tx) error {
 		depth, newParentID := 0, uid.NullID{}
 		if parent != nil {
@@ -243,6 +342,9 @@ func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, paren
 			}
 		}
 		now := time.Now()
+		if !opts.createdAt.IsZero() {
+			now = opts.createdAt
+		}
 
 		query := `	INSERT INTO comments (
 						id, 
@@ -257,8 +359,12 @@ func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, paren
 						ancestors,
 						body,
 						created_at,
-						community_name) 
-					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+						community_name,
+						upvotes,
+						downvotes,
+						points,
+						controversy) 
+					VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 		args := []any{
 			id,
 			post.ID,
@@ -273,12 +379,24 @@ func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, paren
 			commentBody,
 			now,
 			post.CommunityName,
+			opts.upvotes,
+			opts.downvotes,
+			opts.upvotes - opts.downvotes,
+			Controversy(opts.upvotes, opts.downvotes),
 		}
 		if _, err = tx.ExecContext(ctx, query, args...); err != nil {
 			return err
 		}
 
-		if _, err = tx.ExecContext(ctx, "UPDATE posts SET no_comments = no_comments + 1, last_activity_at = ? WHERE id = ?", now, post.ID); err != nil {
+		query = "UPDATE posts SET no_comments = no_comments + 1, last_activity_at = ? WHERE id = ?"
+		if !opts.createdAt.IsZero() {
+			// Imported comments aren't necessarily added in chronological order.
+			query = "UPDATE posts SET no_comments = no_comments + 1, last_activity_at = CASE WHEN last_activity_at < ? THEN ? ELSE last_activity_at END WHERE id = ?"
+			args = []any{now, now, post.ID}
+		} else {
+			args = []any{now, post.ID}
+		}
+		if _, err = tx.ExecContext(ctx, query, args...); err != nil {
 			return err
 		}
 
@@ -296,6 +414,18 @@ func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, paren
 			}
 		}
 
+		if opts.image != nil {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO comment_images (comment_id, image_id) VALUES (?, ?)", id, *opts.image); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "DELETE FROM temp_images_2 WHERE image_id = ?", *opts.image); err != nil {
+				return err
+			}
+			if err := setMediaUploadCommunityTx(ctx, tx, *opts.image, post.CommunityID); err != nil {
+				return err
+			}
+		}
+
 		// For the user profile.
 		if _, err := tx.ExecContext(ctx, "INSERT INTO posts_comments (target_id, user_id, target_type) VALUES (?, ?, ?)", id, author.ID, postsCommentsTypeComments); err != nil {
 			return err
@@ -311,6 +441,39 @@ func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, paren
 			return err
 		}
 
+		if opts.createdAt.IsZero() { // Imported comments are of no use in detecting floods.
+			if err := addCommentFingerprintTx(ctx, tx, id, author.ID, commentBody, now); err != nil {
+				return err
+			}
+		}
+
+		// Send notifications.
+		if !opts.noNotifications {
+			if parent != nil && !parent.AuthorID.EqualsTo(author.ID) {
+				if err := enqueueNotificationTx(ctx, tx, &outboxNotification{
+					Type:    NotificationTypeCommentReply,
+					User:    parent.AuthorID,
+					Post:    uid.NullID{Valid: true, ID: post.ID},
+					Comment: uid.NullID{Valid: true, ID: id},
+					Parent:  uid.NullID{Valid: true, ID: parent.ID},
+					Author:  author.Username,
+				}); err != nil {
+					return err
+				}
+			}
+			if !post.AuthorID.EqualsTo(author.ID) && (parent == nil || !(parent.AuthorID.EqualsTo(post.AuthorID))) {
+				if err := enqueueNotificationTx(ctx, tx, &outboxNotification{
+					Type:    NotificationTypeNewComment,
+					User:    post.AuthorID,
+					Post:    uid.NullID{Valid: true, ID: post.ID},
+					Comment: uid.NullID{Valid: true, ID: id},
+					Author:  author.Username,
+				}); err != nil {
+					return err
+				}
+			}
+		}
+
 		return nil
 	}
 
@@ -318,31 +481,89 @@ func addComment(ctx context.Context, db *sql.DB, post *Post, author *User, paren
 		return nil, err
 	}
 
-	// Send notifications.
-	if parent != nil && !parent.AuthorID.EqualsTo(author.ID) {
-		go func() {
-			if err := CreateCommentReplyNotification(context.Background(), db, parent.AuthorID, parent.ID, id, author.Username, post); err != nil {
-				log.Printf("Create reply notification failed: %v\n", err)
-			}
-		}()
+	comment, err := GetComment(ctx, db, id, nil)
+	if err != nil {
+		return nil, err
+	}
 
+	if post.NumComments+1 >= commentSnapshotThreshold {
+		if err := addCommentToSnapshots(ctx, db, comment); err != nil {
+			logger.ErrorContext(ctx, "Failed to add comment to snapshots", "err", err, "comment", comment.ID)
+		}
 	}
-	if !post.AuthorID.EqualsTo(author.ID) && (parent == nil || !(parent.AuthorID.EqualsTo(post.AuthorID))) {
-		go func() {
-			if err := CreateNewCommentNotification(context.Background(), db, post, id, author.Username); err != nil {
-				log.Printf("Create new_comment notification failed: %v\n", err)
-			}
-		}()
+	if runHooks {
+		runAfterCommentHooks(ctx, db, comment)
+		onboardingStepDone(ctx, db, comment.AuthorID, OnboardingStepFirstComment)
 	}
+	return comment, nil
+}
 
-	return GetComment(ctx, db, id, nil)
+// SaveCommentImage saves an image to be attached to a comment (which is to be
+// done within 12 hours, after which the image is deleted). GIFs are saved as
+// they are, so as to keep their animations.
+func SaveCommentImage(ctx context.Context, db *sql.DB, authorID uid.ID, image []byte) (*images.ImageRecord, error) {
+	opts := &images.ImageOptions{
+		Width:  2000,
+		Height: 2000,
+		Format: images.ImageFormatJPEG,
+		Fit:    images.ImageFitContain,
+	}
+	if bytes.HasPrefix(image, []byte("GIF8")) {
+		opts = &images.ImageOptions{Unprocessed: true}
+	}
+	return saveTempImage(ctx, db, authorID, image, opts)
+}
+
+// populateCommentsImages sets the Image field of those comments that have an
+// image attached.
+func populateCommentsImages(ctx context.Context, db *sql.DB, comments []*Comment) error {
+	var args []any
+	for _, c := range comments {
+		if !c.Deleted() {
+			args = append(args, c.ID)
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	cols := append(images.ImageRecordColumns(), "comment_images.comment_id")
+	query := msql.BuildSelectQuery("comment_images", cols, []string{
+		"INNER JOIN images ON images.id = comment_images.image_id",
+	}, "WHERE comment_images.comment_id IN "+msql.InClauseQuestionMarks(len(args)))
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, commentID := &images.ImageRecord{}, uid.ID{}
+		if err = rows.Scan(append(record.ScanDestinations(), &commentID)...); err != nil {
+			return err
+		}
+		for _, c := range comments {
+			if c.ID == commentID {
+				img := record.Image()
+				if record.Format != images.ImageFormatGIF {
+					// Copies of GIFs would not be animated.
+					img.AppendCopy("small", 360, 360, images.ImageFitContain, "")
+					img.AppendCopy("large", 1080, 1080, images.ImageFitContain, "")
+				}
+				c.Image = img
+				break
+			}
+		}
+	}
+	return rows.Err()
 }
 
 func (c *Comment) Deleted() bool {
 	return c.DeletedAt.Valid
 }
 
-// Save updates comment's body.
+// Save updates comment's body. It returns ErrEditConflict if the comment was
+// edited (its version changed) since it was loaded.
 func (c *Comment) Save(ctx context.Context, user uid.ID) error {
 	if c.Deleted() {
 		return errCommentDeleted
@@ -354,22 +575,122 @@ func (c *Comment) Save(ctx context.Context, user uid.ID) error {
 	c.Body = utils.TruncateUnicodeString(c.Body, maxCommentBodyLength)
 
 	now := time.Now()
-	query := "UPDATE comments SET body = ?, edited_at = ? WHERE id = ? AND deleted_at IS NULL"
-	_, err := c.db.ExecContext(ctx, query, c.Body, now, c.ID)
-	if err == nil {
-		c.EditedAt.Valid = true
-		c.EditedAt.Time = now
+	query := "UPDATE comments SET body = ?, edited_at = ?, version = version + 1 WHERE id = ? AND version = ? AND deleted_at IS NULL"
+	res, err := c.db.ExecContext(ctx, query, c.Body, now, c.ID, c.Version)
+	if err != nil {
+		return err
 	}
-	return err
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrEditConflict // Or deleted since.
+	}
+	c.EditedAt.Valid = true
+	c.EditedAt.Time = now
+	c.Version++
+	c.ProxiedImages = proxiedImages(c.Body)
+	c.CodeBlocks = highlight.Blocks(c.Body)
+	invalidateCommentSnapshots(ctx, c.db, c.PostID)
+	return nil
 }
 
 // Delete returns an error if user, who's deleting the comment, has no
 // permissions in his capacity as g to delete this comment.
 func (c *Comment) Delete(ctx context.Context, user uid.ID, g UserGroup) error {
+	_, err := c.delete(ctx, user, g, 0)
+	return err
+}
+
+// DeleteUndoable is Delete by a mod or an admin (g), except that the
+// resolving of the reports of the comment is held back for window, during
+// which the removal can be undone (see PendingModAction). Since its image
+// can't be restored, a comment with an image is deleted with Delete (and the
+// returned PendingModAction is nil).
+func (c *Comment) DeleteUndoable(ctx context.Context, user uid.ID, g UserGroup, window time.Duration) (*PendingModAction, error) {
+	if g != UserGroupMods && g != UserGroupAdmins {
+		return nil, errInvalidUserGroup
+	}
+	if c.Image != nil {
+		window = 0
+	}
+	return c.delete(ctx, user, g, window)
+}
+
+// delete is Delete, with the side effects held back for window, if it's
+// non-zero (see DeleteUndoable).
+func (c *Comment) delete(ctx context.Context, user uid.ID, g UserGroup, window time.Duration) (*PendingModAction, error) {
 	if c.Deleted() {
-		return errCommentDeleted
+		return nil, errCommentDeleted
+	}
+	if err := c.checkDeleter(ctx, user, g); err != nil {
+		return nil, err
+	}
+
+	var pending *PendingModAction
+	now := time.Now()
+	err := msql.Transact(ctx, c.db, func(tx *sql.Tx) error {
+		if err := archiveDeletedContentTx(ctx, tx, ReportTypeComment, c.ID, c.AuthorID, "", c.Body, now); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `UPDATE comments SET body = "", deleted_at = ?, deleted_by = ?, deleted_as = ? WHERE id = ? AND deleted_at IS NULL`, now, user, g, c.ID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return errCommentDeleted // By a concurrent request.
+		}
+		if c.Image != nil {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM comment_images WHERE comment_id = ?", c.ID); err != nil {
+				return err
+			}
+			if err := images.DeleteImageTx(ctx, tx, c.db, *c.Image.ID); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM posts_comments WHERE target_id = ? AND user_id = ?", c.ID, c.AuthorID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM comment_translations WHERE comment_id = ?", c.ID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET no_comments = no_comments - 1 WHERE id = ? AND no_comments > 0", c.AuthorID); err != nil {
+			return err
+		}
+		if window > 0 {
+			pending = &PendingModAction{
+				CommunityID: c.CommunityID,
+				ModID:       user,
+				ModGroup:    g,
+				Action:      ModActionRemoveComment,
+				TargetID:    c.ID,
+			}
+			return queueModActionTx(ctx, tx, pending, window)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	c.DeletedAt = msql.NewNullTime(now)
+	c.DeletedBy = uid.NullID{Valid: true, ID: user}
+	c.DeletedAs = g
+	c.stripDeletedInfo()
+	if pending == nil {
+		if g != UserGroupNormal {
+			afterCommentRemoval(ctx, c.db, c.CommunityID, c.ID, user)
+		}
+		RemoveAllReportsOfComment(ctx, c.db, c.ID)
+	}
+	invalidateCommentSnapshots(ctx, c.db, c.PostID)
+	return pending, err
+}
+
+// checkDeleter returns an error if user has no permissions in his capacity as
+// g to delete this comment.
+func (c *Comment) checkDeleter(ctx context.Context, user uid.ID, g UserGroup) error {
 	switch g {
 	case UserGroupNormal:
 		if !c.AuthorID.EqualsTo(user) {
@@ -394,30 +715,143 @@ func (c *Comment) Delete(ctx context.Context, user uid.ID, g UserGroup) error {
 	default:
 		return errInvalidUserGroup
 	}
+	return nil
+}
 
+// afterCommentRemoval records the removal of comment by mod (or an admin)
+// and upholds its reports. Failures are only logged.
+func afterCommentRemoval(ctx context.Context, db *sql.DB, community, comment, mod uid.ID) {
+	if err := recordRemoval(ctx, db, community, ReportTypeComment, comment); err != nil {
+		logger.ErrorContext(ctx, "Failed to record removal", "err", err, "comment", comment)
+	}
+	if err := upholdReports(ctx, db, ReportTypeComment, comment, mod); err != nil {
+		logger.ErrorContext(ctx, "Failed to uphold reports", "err", err, "comment", comment)
+	}
+}
+
+// DeleteSubtree deletes, as a mod or an admin (g), the comment and all the
+// replies under it (a chain of spam replies, say), those not already deleted,
+// in a single transaction. It returns the number of comments deleted.
+func (c *Comment) DeleteSubtree(ctx context.Context, user uid.ID, g UserGroup) (int, error) {
+	if g != UserGroupMods && g != UserGroupAdmins {
+		return 0, errInvalidUserGroup
+	}
+	if err := c.checkDeleter(ctx, user, g); err != nil {
+		return 0, err
+	}
+
+	type subtreeComment struct {
+		id, author uid.ID
+		body       string
+		image      uid.NullID
+	}
+	var deleted []*subtreeComment
 	now := time.Now()
 	err := msql.Transact(ctx, c.db, func(tx *sql.Tx) error {
-		if _, err := tx.ExecContext(ctx, `UPDATE comments SET body = "", deleted_at = ?, deleted_by = ?, deleted_as = ? WHERE id = ?`, now, user, g, c.ID); err != nil {
+		deleted = nil
+		rows, err := tx.QueryContext(ctx, `
+			SELECT comments.id, comments.parent_id, comments.user_id, comments.body, comments.deleted_at IS NOT NULL, comment_images.image_id
+			FROM comments
+			LEFT JOIN comment_images ON comment_images.comment_id = comments.id
+			WHERE comments.post_id = ?`, c.PostID)
+		if err != nil {
 			return err
 		}
-		if _, err := tx.ExecContext(ctx, "DELETE FROM posts_comments WHERE target_id = ? AND user_id = ?", c.ID, c.AuthorID); err != nil {
+		children := make(map[uid.ID][]*subtreeComment)
+		var root *subtreeComment
+		isDeleted := make(map[uid.ID]bool)
+		for rows.Next() {
+			var (
+				sc     subtreeComment
+				parent uid.NullID
+				body   sql.NullString
+				del    bool
+			)
+			if err := rows.Scan(&sc.id, &parent, &sc.author, &body, &del, &sc.image); err != nil {
+				rows.Close()
+				return err
+			}
+			sc.body = body.String
+			isDeleted[sc.id] = del
+			if sc.id == c.ID {
+				root = &sc
+			} else if parent.Valid {
+				children[parent.ID] = append(children[parent.ID], &sc)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
 			return err
 		}
-		if _, err := tx.ExecContext(ctx, "UPDATE users SET no_comments = no_comments - 1 WHERE id = ?", c.AuthorID); err != nil {
+		if root == nil {
+			return errCommentNotFound
+		}
+
+		// Deleted comments may have replies that are not.
+		for queue := []*subtreeComment{root}; len(queue) > 0; queue = queue[1:] {
+			sc := queue[0]
+			if !isDeleted[sc.id] {
+				deleted = append(deleted, sc)
+			}
+			queue = append(queue, children[sc.id]...)
+		}
+		if len(deleted) == 0 {
+			return errCommentDeleted
+		}
+
+		ids := make([]any, len(deleted))
+		noComments := make(map[uid.ID]int) // By author.
+		for i, sc := range deleted {
+			ids[i] = sc.id
+			noComments[sc.author]++
+			if err := archiveDeletedContentTx(ctx, tx, ReportTypeComment, sc.id, sc.author, "", sc.body, now); err != nil {
+				return err
+			}
+		}
+		in := msql.InClauseQuestionMarks(len(ids))
+		args := append([]any{now, user, g}, ids...)
+		if _, err := tx.ExecContext(ctx, `UPDATE comments SET body = "", deleted_at = ?, deleted_by = ?, deleted_as = ? WHERE deleted_at IS NULL AND id IN `+in, args...); err != nil {
 			return err
 		}
+		for _, query := range []string{
+			"DELETE FROM comment_images WHERE comment_id IN ",
+			"DELETE FROM posts_comments WHERE target_id IN ",
+			"DELETE FROM comment_translations WHERE comment_id IN ",
+		} {
+			if _, err := tx.ExecContext(ctx, query+in, ids...); err != nil {
+				return err
+			}
+		}
+		for _, sc := range deleted {
+			if sc.image.Valid {
+				if err := images.DeleteImageTx(ctx, tx, c.db, sc.image.ID); err != nil {
+					return err
+				}
+			}
+		}
+		for author, n := range noComments {
+			if _, err := tx.ExecContext(ctx, "UPDATE users SET no_comments = CASE WHEN no_comments > ? THEN no_comments - ? ELSE 0 END WHERE id = ?", n, n, author); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	c.DeletedAt = msql.NewNullTime(now)
-	c.DeletedBy = uid.NullID{Valid: true, ID: user}
-	c.DeletedAs = g
-	c.stripDeletedInfo()
-	RemoveAllReportsOfComment(ctx, c.db, c.ID)
-	return err
+	for _, sc := range deleted {
+		afterCommentRemoval(ctx, c.db, c.CommunityID, sc.id, user)
+		RemoveAllReportsOfComment(ctx, c.db, sc.id)
+	}
+	if !c.Deleted() {
+		c.DeletedAt = msql.NewNullTime(now)
+		c.DeletedBy = uid.NullID{Valid: true, ID: user}
+		c.DeletedAs = g
+		c.stripDeletedInfo()
+	}
+	invalidateCommentSnapshots(ctx, c.db, c.PostID)
+	return len(deleted), nil
 }
 
 func (c *Comment) stripDeletedInfo() {
@@ -428,24 +862,34 @@ func (c *Comment) stripDeletedInfo() {
 	c.AuthorUsername = "Hidden"
 	c.PostedAs = UserGroupNaN
 	c.Body = "[Deleted comment]"
+	c.Image = nil
+	c.ProxiedImages = nil
+	c.CodeBlocks = nil
 	c.ViewerVoted.Valid = false
 	c.ViewerVotedUp.Valid = false
 	c.Author = nil
 }
 
+// setVotes sets the upvotes and downvotes of c, and the scores that depend on
+// them.
+func (c *Comment) setVotes(upvotes, downvotes int) {
+	c.Upvotes, c.Downvotes = upvotes, downvotes
+	c.Controversy = Controversy(upvotes, downvotes)
+	c.Controversial = Controversial(upvotes, downvotes)
+}
+
 // Vote votes on comment (if the comment is not deleted or the post locked).
 func (c *Comment) Vote(ctx context.Context, user uid.ID, up bool) error {
 	if c.Deleted() {
 		return errCommentDeleted
 	}
 
-	if is, err := IsPostLocked(ctx, c.db, c.PostID); err != nil {
+	if err := checkPostNotLocked(ctx, c.db, c.PostID); err != nil {
 		return err
-	} else if is {
-		return errPostLocked
 	}
 
 	point := 1
+	newUpvotes, newDownvotes := c.Upvotes, c.Downvotes
 	err := msql.Transact(ctx, c.db, func(tx *sql.Tx) error {
 		if _, err := tx.ExecContext(ctx, "INSERT INTO comment_votes (comment_id, user_id, up) VALUES (?, ?, ?)", c.ID, user, up); err != nil {
 			if msql.IsErrDuplicateErr(err) {
@@ -453,28 +897,35 @@ func (c *Comment) Vote(ctx context.Context, user uid.ID, up bool) error {
 			}
 			return err
 		}
-		query := "UPDATE comments SET points = points + ?"
+		query := "UPDATE comments SET points = points + ?, controversy = ?"
 		if up {
 			query += ", upvotes = upvotes + 1"
+			newUpvotes++
 		} else {
 			point = -1
 			query += ", downvotes = downvotes + 1"
+			newDownvotes++
 		}
 		query += " WHERE id = ?"
-		if _, err := tx.ExecContext(ctx, query, point, c.ID); err != nil {
+		if _, err := tx.ExecContext(ctx, query, point, Controversy(newUpvotes, newDownvotes), c.ID); err != nil {
 			return err
 		}
+		// Notify the author (only of upvotes).
+		if up && !c.AuthorID.EqualsTo(user) {
+			return enqueueNotificationTx(ctx, tx, &outboxNotification{
+				Type:      NotificationTypeUpvote,
+				User:      c.AuthorID,
+				Comment:   uid.NullID{Valid: true, ID: c.ID},
+				Community: c.CommunityName,
+			})
+		}
 		return nil
 	})
 	if err != nil {
 		return err
 	}
 
-	if up {
-		c.Upvotes++
-	} else {
-		c.Downvotes++
-	}
+	c.setVotes(newUpvotes, newDownvotes)
 	c.Points += point
 	c.ViewerVoted = msql.NewNullBool(true)
 	c.ViewerVotedUp.Valid = true
@@ -482,16 +933,7 @@ func (c *Comment) Vote(ctx context.Context, user uid.ID, up bool) error {
 
 	// Attempt to update user's points.
 	if up && !c.AuthorID.EqualsTo(user) {
-		incrementUserPoints(ctx, c.db, c.AuthorID, 1)
-	}
-
-	// Attempt to create a notification (only for upvotes).
-	if !c.AuthorID.EqualsTo(user) && up {
-		go func() {
-			if err := CreateNewVotesNotification(context.Background(), c.db, c.AuthorID, c.CommunityName, false, c.ID); err != nil {
-				log.Printf("Failed creating new_votes notification: %v\n", err)
-			}
-		}()
+		addUserPoints(ctx, c.db, c.AuthorID, c.CommunityID, false, 1)
 	}
 
 	return nil
@@ -504,10 +946,8 @@ func (c *Comment) DeleteVote(ctx context.Context, user uid.ID) error {
 	}
 
 	// Cannot vote if the post is locked.
-	if is, err := IsPostLocked(ctx, c.db, c.PostID); err != nil {
+	if err := checkPostNotLocked(ctx, c.db, c.PostID); err != nil {
 		return err
-	} else if is {
-		return errPostLocked
 	}
 
 	id, up := 0, false
@@ -517,19 +957,22 @@ func (c *Comment) DeleteVote(ctx context.Context, user uid.ID) error {
 	}
 
 	point := 1
+	newUpvotes, newDownvotes := c.Upvotes, c.Downvotes
 	err := msql.Transact(ctx, c.db, func(tx *sql.Tx) error {
 		if _, err := tx.ExecContext(ctx, "DELETE FROM comment_votes WHERE id = ?", id); err != nil {
 			return err
 		}
-		query := "UPDATE comments SET points = points + ?"
+		query := "UPDATE comments SET points = points + ?, controversy = ?"
 		if up {
 			point = -1
 			query += ", upvotes = upvotes - 1"
+			newUpvotes--
 		} else {
 			query += ", downvotes = downvotes - 1"
+			newDownvotes--
 		}
 		query += " WHERE id = ?"
-		if _, err := tx.ExecContext(ctx, query, point, c.ID); err != nil {
+		if _, err := tx.ExecContext(ctx, query, point, Controversy(newUpvotes, newDownvotes), c.ID); err != nil {
 			return err
 		}
 		return nil
@@ -538,18 +981,14 @@ func (c *Comment) DeleteVote(ctx context.Context, user uid.ID) error {
 		return err
 	}
 
-	if up {
-		c.Upvotes--
-	} else {
-		c.Downvotes--
-	}
+	c.setVotes(newUpvotes, newDownvotes)
 	c.Points += point
 	c.ViewerVoted.Valid = false
 	c.ViewerVotedUp.Valid = false
 
 	// Attempt to update user's points.
 	if up && !c.AuthorID.EqualsTo(user) {
-		incrementUserPoints(ctx, c.db, c.AuthorID, -1)
+		addUserPoints(ctx, c.db, c.AuthorID, c.CommunityID, false, -1)
 	}
 
 	return nil
@@ -562,10 +1001,8 @@ func (c *Comment) ChangeVote(ctx context.Context, user uid.ID, up bool) error {
 	}
 
 	// Cannot vote if the post is locked.
-	if is, err := IsPostLocked(ctx, c.db, c.PostID); err != nil {
+	if err := checkPostNotLocked(ctx, c.db, c.PostID); err != nil {
 		return err
-	} else if is {
-		return errPostLocked
 	}
 
 	id, dbUp := 0, false
@@ -579,19 +1016,24 @@ func (c *Comment) ChangeVote(ctx context.Context, user uid.ID, up bool) error {
 	}
 
 	points := 2
+	newUpvotes, newDownvotes := c.Upvotes, c.Downvotes
 	err := msql.Transact(ctx, c.db, func(tx *sql.Tx) error {
 		if _, err := tx.ExecContext(ctx, "UPDATE comment_votes SET up = ? WHERE id = ?", up, id); err != nil {
 			return err
 		}
-		query := "UPDATE comments SET points = points + ?"
+		query := "UPDATE comments SET points = points + ?, controversy = ?"
 		if dbUp {
 			points = -2
 			query += ", upvotes = upvotes - 1, downvotes = downvotes + 1"
+			newUpvotes--
+			newDownvotes++
 		} else {
 			query += ", upvotes = upvotes + 1, downvotes = downvotes - 1"
+			newUpvotes++
+			newDownvotes--
 		}
 		query += " WHERE id = ?"
-		if _, err := tx.ExecContext(ctx, query, points, c.ID); err != nil {
+		if _, err := tx.ExecContext(ctx, query, points, Controversy(newUpvotes, newDownvotes), c.ID); err != nil {
 			return err
 		}
 		return nil
@@ -600,13 +1042,7 @@ func (c *Comment) ChangeVote(ctx context.Context, user uid.ID, up bool) error {
 		return err
 	}
 
-	if dbUp {
-		c.Upvotes--
-		c.Downvotes++
-	} else {
-		c.Upvotes++
-		c.Downvotes--
-	}
+	c.setVotes(newUpvotes, newDownvotes)
 	c.Points += points
 	c.ViewerVotedUp = msql.NewNullBool(up)
 
@@ -616,7 +1052,7 @@ func (c *Comment) ChangeVote(ctx context.Context, user uid.ID, up bool) error {
 		if dbUp {
 			points = -1
 		}
-		incrementUserPoints(ctx, c.db, c.AuthorID, points)
+		addUserPoints(ctx, c.db, c.AuthorID, c.CommunityID, false, points)
 	}
 
 	return nil
@@ -658,6 +1094,28 @@ func (c *Comment) ChangeUserGroup(ctx context.Context, author uid.ID, g UserGrou
 	_, err := c.db.ExecContext(ctx, "UPDATE comments SET user_group = ? WHERE id = ? AND deleted_at IS NULL", g, c.ID)
 	if err == nil {
 		c.PostedAs = g
+		invalidateCommentSnapshots(ctx, c.db, c.PostID)
+	}
+	return err
+}
+
+// Distinguish changes the capacity in which the comment was added to g, on
+// behalf of its author, by a mod or an admin (see checkDistinguish).
+func (c *Comment) Distinguish(ctx context.Context, by uid.ID, g UserGroup) error {
+	if c.Deleted() {
+		return errCommentDeleted
+	}
+	if c.PostedAs == g {
+		return nil
+	}
+	if err := checkDistinguish(ctx, c.db, c.CommunityID, by, c.AuthorID, c.PostedAs, g); err != nil {
+		return err
+	}
+
+	_, err := c.db.ExecContext(ctx, "UPDATE comments SET user_group = ? WHERE id = ? AND deleted_at IS NULL", g, c.ID)
+	if err == nil {
+		c.PostedAs = g
+		invalidateCommentSnapshots(ctx, c.db, c.PostID)
 	}
 	return err
 }
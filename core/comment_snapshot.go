@@ -0,0 +1,229 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Posts with at least this many comments have their first page of comments,
+// in each sort order, served to logged out users from a snapshot (a
+// serialized comment tree stored in the comment_tree_snapshots table),
+// instead of assembling the tree on each request.
+const commentSnapshotThreshold = 1000
+
+// commentSnapshotMaxAge is the age after which a snapshot is rebuilt. New
+// comments are added to snapshots as they're posted, but vote counts (and
+// therefore the ordering of comments) are only refreshed on rebuilds.
+const commentSnapshotMaxAge = time.Minute * 5
+
+// commentSnapshotSaveAttempts is the number of times the adding of a comment to
+// a snapshot is tried, when other comments are being added to it at the same
+// time, before the snapshot is deleted instead.
+const commentSnapshotSaveAttempts = 3
+
+// commentTreeSnapshot is a row of the comment_tree_snapshots table.
+type commentTreeSnapshot struct {
+	PostID      uid.ID
+	Sort        CommentSort
+	Comments    []*Comment
+	Next        msql.NullString // The value of Post.CommentsNext.
+	NumComments int
+	CreatedAt   time.Time
+
+	// Incremented on every save, so that concurrent saves (see update) don't
+	// overwrite one another.
+	Version int
+}
+
+// getCommentSnapshot returns the snapshot of post with the sort order sort. If
+// there's none, it returns nil and a nil error.
+func getCommentSnapshot(ctx context.Context, db *sql.DB, post uid.ID, sort CommentSort) (*commentTreeSnapshot, error) {
+	s := &commentTreeSnapshot{PostID: post, Sort: sort}
+	var data []byte
+	row := db.QueryRowContext(ctx, "SELECT comments, next_cursor, no_comments, created_at, version FROM comment_tree_snapshots WHERE post_id = ? AND sort = ?", post, sort)
+	if err := row.Scan(&data, &s.Next, &s.NumComments, &s.CreatedAt, &s.Version); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.Comments); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// save inserts s into the database, replacing any existing snapshot of the same
+// post and sort order.
+func (s *commentTreeSnapshot) save(ctx context.Context, db *sql.DB) error {
+	data, err := json.Marshal(s.Comments)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `INSERT INTO comment_tree_snapshots (post_id, sort, comments, next_cursor, no_comments, created_at)
+		VALUES (?, ?, ?, ?, ?, ?) `+msql.UpsertClause([]string{"post_id", "sort"}, "comments", "next_cursor", "no_comments", "created_at")+`, version = comment_tree_snapshots.version + 1`,
+		s.PostID, s.Sort, data, s.Next, s.NumComments, s.CreatedAt)
+	return err
+}
+
+// update saves the changes made to s, which was read at s.Version, unless the
+// snapshot was saved since, in which case it reports false.
+func (s *commentTreeSnapshot) update(ctx context.Context, db *sql.DB) (bool, error) {
+	data, err := json.Marshal(s.Comments)
+	if err != nil {
+		return false, err
+	}
+	res, err := db.ExecContext(ctx, `UPDATE comment_tree_snapshots SET comments = ?, no_comments = ?, version = version + 1
+		WHERE post_id = ? AND sort = ? AND version = ?`, data, s.NumComments, s.PostID, s.Sort, s.Version)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// stale reports whether s is to be rebuilt.
+func (s *commentTreeSnapshot) stale() bool {
+	return time.Since(s.CreatedAt) > commentSnapshotMaxAge
+}
+
+// deleteCommentSnapshots removes all the snapshots of post, so that they are
+// rebuilt on the next request.
+func deleteCommentSnapshots(ctx context.Context, db *sql.DB, post uid.ID) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM comment_tree_snapshots WHERE post_id = ?", post)
+	return err
+}
+
+// invalidateCommentSnapshots is like deleteCommentSnapshots except that errors
+// are only logged.
+func invalidateCommentSnapshots(ctx context.Context, db *sql.DB, post uid.ID) {
+	if err := deleteCommentSnapshots(ctx, db, post); err != nil {
+		logger.ErrorContext(ctx, "Failed to delete comment snapshots", "err", err, "post", post)
+	}
+}
+
+// commentSortScore returns the score of c that comments are sorted by, in
+// sort, before their IDs (see Post.fetchComments).
+func commentSortScore(sort CommentSort, c *Comment) int {
+	switch sort {
+	case CommentSortNew:
+		return 0
+	case CommentSortControversial:
+		return c.Controversy
+	}
+	return c.Upvotes
+}
+
+// add adds the new comment c, which has no votes and the largest ID of the
+// comments of its post, to s, if it belongs to the page of comments of s. It
+// returns the ancestors of c that are to be added along with it (since the
+// comments of a page come with their ancestors) but are not in s, and reports
+// whether c was added.
+func (s *commentTreeSnapshot) add(c *Comment) ([]uid.ID, bool) {
+	s.NumComments++
+	if c.Depth >= commentsContinueDepth {
+		return nil, false
+	}
+
+	// Comments are sorted by score and then by ID, both in descending order,
+	// and c has a score of zero (or else is the newest comment), so it goes
+	// right before the first comment without a positive score.
+	if s.Next.Valid {
+		score, _, err := NextPointsIDCursor(s.Next.String)
+		if err != nil || (s.Sort != CommentSortNew && score > 0) {
+			return nil, false // It belongs to a later page.
+		}
+	}
+	i := 0
+	for i < len(s.Comments) && commentSortScore(s.Sort, s.Comments[i]) > 0 {
+		i++
+	}
+	s.Comments = append(s.Comments, nil)
+	copy(s.Comments[i+1:], s.Comments[i:])
+	s.Comments[i] = c
+
+	in := make(map[uid.ID]bool, len(s.Comments))
+	for _, comment := range s.Comments {
+		in[comment.ID] = true
+	}
+	var missing []uid.ID
+	for _, a := range c.Ancestors {
+		if !in[a] {
+			missing = append(missing, a)
+		}
+	}
+	return missing, true
+}
+
+// addCommentToSnapshots adds a newly created comment to the snapshots of its
+// post (in the pages of which it belongs). A snapshot that can't be updated,
+// because other comments are being added to it at the same time, is deleted
+// instead.
+func addCommentToSnapshots(ctx context.Context, db *sql.DB, c *Comment) error {
+	for _, sort := range []CommentSort{CommentSortTop, CommentSortNew, CommentSortControversial} {
+		updated := false
+		for attempt := 0; attempt < commentSnapshotSaveAttempts && !updated; attempt++ {
+			s, err := getCommentSnapshot(ctx, db, c.PostID, sort)
+			if err != nil {
+				return err
+			}
+			if s == nil {
+				break
+			}
+			missing, added := s.add(c)
+			if added && len(missing) > 0 {
+				ancestors, err := getCommentsList(ctx, db, nil, missing)
+				if err != nil {
+					return err
+				}
+				s.Comments = append(s.Comments, ancestors...)
+			}
+			if updated, err = s.update(ctx, db); err != nil {
+				return err
+			}
+			if !updated && attempt == commentSnapshotSaveAttempts-1 {
+				if _, err := db.ExecContext(ctx, "DELETE FROM comment_tree_snapshots WHERE post_id = ? AND sort = ?", c.PostID, sort); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// loadCommentsFromSnapshot sets p.Comments and p.CommentsNext from the
+// snapshot with the sort order sort, creating one if none exists or if the
+// existing one is stale.
+func (p *Post) loadCommentsFromSnapshot(ctx context.Context, sort CommentSort) error {
+	s, err := getCommentSnapshot(ctx, p.db, p.ID, sort)
+	if err != nil {
+		return err
+	}
+	if s != nil && !s.stale() {
+		p.Comments = s.Comments
+		p.CommentsNext = s.Next
+		return nil
+	}
+
+	if _, err = p.fetchComments(ctx, nil, sort, nil); err != nil {
+		return err
+	}
+
+	s = &commentTreeSnapshot{
+		PostID:      p.ID,
+		Sort:        sort,
+		Comments:    p.Comments,
+		Next:        p.CommentsNext,
+		NumComments: p.NumComments,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.save(ctx, p.db); err != nil {
+		logger.ErrorContext(ctx, "Failed to save comment snapshot", "err", err, "post", p.ID)
+	}
+	return nil
+}
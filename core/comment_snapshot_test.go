@@ -0,0 +1,74 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestCommentTreeSnapshotAdd(t *testing.T) {
+	comment := func(upvotes int, parent *Comment) *Comment {
+		c := &Comment{ID: uid.New(), Upvotes: upvotes, Controversy: upvotes}
+		if parent != nil {
+			c.Ancestors = append(append([]uid.ID{}, parent.Ancestors...), parent.ID)
+			c.Depth = parent.Depth + 1
+		}
+		return c
+	}
+	cursor := func(score int) msql.NullString {
+		return msql.NewNullString(strconv.Itoa(score) + "." + uid.New().String())
+	}
+
+	voted, unvoted := comment(5, nil), comment(0, nil)
+	offPage := comment(3, nil) // Of a later page.
+	tests := []struct {
+		name      string
+		sort      CommentSort
+		next      msql.NullString
+		parent    *Comment
+		wantAdded bool
+		wantIndex int
+	}{
+		{"top, whole tree", CommentSortTop, msql.NullString{}, nil, true, 1},
+		{"top, last page of votes", CommentSortTop, cursor(0), nil, true, 1},
+		{"top, later page", CommentSortTop, cursor(2), nil, false, 0},
+		{"controversial, later page", CommentSortControversial, cursor(1), nil, false, 0},
+		{"new, paged", CommentSortNew, cursor(7), nil, true, 0},
+		{"top, reply to an off-page comment", CommentSortTop, msql.NullString{}, offPage, true, 1},
+	}
+	for _, test := range tests {
+		s := &commentTreeSnapshot{Sort: test.sort, Comments: []*Comment{voted, unvoted}, Next: test.next, NumComments: 2}
+		c := comment(0, test.parent)
+		missing, added := s.add(c)
+		if added != test.wantAdded {
+			t.Errorf("%s: got added %v, want %v", test.name, added, test.wantAdded)
+			continue
+		}
+		if s.NumComments != 3 {
+			t.Errorf("%s: got %d comments, want 3", test.name, s.NumComments)
+		}
+		if !added {
+			if len(s.Comments) != 2 {
+				t.Errorf("%s: snapshot changed", test.name)
+			}
+			continue
+		}
+		if s.Comments[test.wantIndex] != c {
+			t.Errorf("%s: comment not at index %d", test.name, test.wantIndex)
+		}
+		if test.parent != nil && (len(missing) != 1 || missing[0] != test.parent.ID) {
+			t.Errorf("%s: got missing ancestors %v, want the parent", test.name, missing)
+		}
+		if test.parent == nil && len(missing) != 0 {
+			t.Errorf("%s: got missing ancestors %v, want none", test.name, missing)
+		}
+	}
+
+	deep := &Comment{ID: uid.New(), Depth: commentsContinueDepth}
+	s := &commentTreeSnapshot{Sort: CommentSortNew}
+	if _, added := s.add(deep); added {
+		t.Error("comment too deep for pages added")
+	}
+}
@@ -0,0 +1,19 @@
+package core
+
+import (
+	"testing"
+)
+
+func BenchmarkBuildSelectCommentsQuery(b *testing.B) {
+	where := "WHERE comments.post_id = ? AND (comments.upvotes, comments.id) <= (?, ?) ORDER BY upvotes DESC, comments.id DESC LIMIT ?"
+	b.Run("loggedOut", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			buildSelectCommentsQuery(false, where)
+		}
+	})
+	b.Run("loggedIn", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			buildSelectCommentsQuery(true, where)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"context"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// markSelfReplyChains sets the ChainID of the comments (of a post) that are in
+// a chain of self-replies, as in the updates of a thread: comments that are
+// replies to a comment by the same author, and the comments they reply to.
+// The ID of a chain is that of its first comment. Comments whose parents are
+// not in comments are taken to be the first of their chains.
+func markSelfReplyChains(comments []*Comment) {
+	byID := make(map[uid.ID]*Comment, len(comments))
+	for _, c := range comments {
+		byID[c.ID] = c
+		c.ChainID = uid.NullID{}
+	}
+
+	// selfParent returns the parent of c if it's by the author of c.
+	selfParent := func(c *Comment) *Comment {
+		if c.Deleted() || !c.ParentID.Valid {
+			return nil
+		}
+		p := byID[c.ParentID.ID]
+		if p == nil || p.Deleted() || !p.AuthorID.EqualsTo(c.AuthorID) {
+			return nil
+		}
+		return p
+	}
+
+	for _, c := range comments {
+		first := selfParent(c)
+		if first == nil {
+			continue
+		}
+		// The number of steps is bounded in case of a cycle in a broken tree
+		// (see CheckCommentTrees).
+		for i, p := 0, selfParent(first); p != nil && i < len(comments); i, p = i+1, selfParent(p) {
+			first = p
+		}
+		c.ChainID = uid.NullID{ID: first.ID, Valid: true}
+		first.ChainID = c.ChainID
+	}
+}
+
+// markReplySelfReplyChains is markSelfReplyChains for replies, the replies of
+// comment parent, which, along with its ancestors, is not in replies.
+func (p *Post) markReplySelfReplyChains(ctx context.Context, parent uid.ID, replies []*Comment) error {
+	c, err := GetComment(ctx, p.db, parent, nil)
+	if err != nil {
+		return err
+	}
+	self := false
+	for _, reply := range replies {
+		self = self || reply.AuthorID.EqualsTo(c.AuthorID)
+	}
+	if !self || c.Deleted() {
+		markSelfReplyChains(replies)
+		return nil
+	}
+
+	// The chain may start at an ancestor of parent.
+	chain := []*Comment{c}
+	if len(c.Ancestors) > 0 {
+		ancestors, err := getCommentsList(ctx, p.db, nil, c.Ancestors)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, ancestors...)
+	}
+	markSelfReplyChains(append(chain, replies...))
+	return nil
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestMarkSelfReplyChains(t *testing.T) {
+	alice, bob := uid.New(), uid.New()
+	comment := func(author uid.ID, parent *Comment) *Comment {
+		c := &Comment{ID: uid.New(), AuthorID: author}
+		if parent != nil {
+			c.ParentID = uid.NullID{Valid: true, ID: parent.ID}
+		}
+		return c
+	}
+
+	root := comment(alice, nil)
+	update1 := comment(alice, root)
+	update2 := comment(alice, update1)
+	reply := comment(bob, update1)
+	answer := comment(alice, reply) // Not a self-reply.
+	lone := comment(bob, nil)
+	deleted := comment(bob, lone)
+	deleted.DeletedAt = msql.NewNullTime(time.Now())
+	afterDeleted := comment(bob, deleted)
+	orphan := comment(alice, &Comment{ID: uid.New()})
+
+	comments := []*Comment{update2, answer, reply, root, update1, lone, deleted, afterDeleted, orphan}
+	markSelfReplyChains(comments)
+
+	for _, c := range []*Comment{root, update1, update2} {
+		if !c.ChainID.Valid || c.ChainID.ID != root.ID {
+			t.Errorf("comment %v: got chain %v, want %v", c.ID, c.ChainID, root.ID)
+		}
+	}
+	for _, c := range []*Comment{reply, answer, lone, deleted, afterDeleted, orphan} {
+		if c.ChainID.Valid {
+			t.Errorf("comment %v: got chain %v, want none", c.ID, c.ChainID.ID)
+		}
+	}
+
+	// Chains are recomputed.
+	update1.ParentID.Valid = false
+	markSelfReplyChains(comments)
+	if root.ChainID.Valid {
+		t.Error("root: chain not cleared")
+	}
+	if !update2.ChainID.Valid || update2.ChainID.ID != update1.ID {
+		t.Errorf("update2: got chain %v, want %v", update2.ChainID, update1.ID)
+	}
+}
@@ -0,0 +1,368 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/discuitnet/discuit/internal/simhash"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// commentFingerprintMinLength is the minimum length, in runes, of the
+// (normalized) body of a comment for it to be fingerprinted. Short comments
+// (like "Thanks!") are too often alike to tell floods apart.
+const commentFingerprintMinLength = 24
+
+// commentFingerprintsTTL is how long the fingerprints of comments are kept.
+const commentFingerprintsTTL = 7 * 24 * time.Hour
+
+// commentFingerprint returns the simhash of the comment body, and false if
+// body is too short to be fingerprinted.
+func commentFingerprint(body string) (uint64, bool) {
+	if utf8.RuneCountInString(simhash.Normalize(body)) < commentFingerprintMinLength {
+		return 0, false
+	}
+	return simhash.Hash(body), true
+}
+
+func addCommentFingerprintTx(ctx context.Context, tx *sql.Tx, comment, user uid.ID, body string, createdAt time.Time) error {
+	hash, ok := commentFingerprint(body)
+	if !ok {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO comment_fingerprints (comment_id, user_id, simhash, created_at) VALUES (?, ?, ?, ?)", comment, user, int64(hash), createdAt)
+	return err
+}
+
+// CommentFloodPolicy determines when near-identical comments are considered a
+// flood.
+type CommentFloodPolicy struct {
+	Window time.Duration // How far back to look for near-identical comments.
+
+	// The maximum number of bits in which the simhashes of two comments may
+	// differ for them to be considered near-identical.
+	MaxDistance int
+
+	// The maximum number of near-identical comments a user may post, and the
+	// maximum number of users that may post near-identical comments, within
+	// Window. Zero means no limit.
+	UserLimit    int
+	AccountLimit int
+}
+
+// CommentFloodCheck is the result of checking a comment against the recent
+// comments (including held ones).
+type CommentFloodCheck struct {
+	Simhash    uint64
+	SameUser   int    // Near-identical comments by the same user.
+	OtherUsers int    // Other users who posted near-identical comments.
+	Reason     string // Why the comment is a flood; empty if it's not.
+}
+
+// Flood reports whether the checked comment is part of a flood.
+func (c *CommentFloodCheck) Flood() bool {
+	return c.Reason != ""
+}
+
+// CheckCommentFlood checks whether body, a new comment of user, is part of a
+// flood of near-identical comments as per policy.
+func CheckCommentFlood(ctx context.Context, db *sql.DB, user uid.ID, body string, policy *CommentFloodPolicy) (*CommentFloodCheck, error) {
+	check := &CommentFloodCheck{}
+	hash, ok := commentFingerprint(body)
+	if !ok {
+		return check, nil
+	}
+	check.Simhash = hash
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT user_id, simhash FROM comment_fingerprints WHERE created_at > ?
+		UNION ALL
+		SELECT user_id, simhash FROM held_comments WHERE created_at > ?
+		LIMIT 20000`, time.Now().Add(-policy.Window), time.Now().Add(-policy.Window))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	others := make(map[uid.ID]bool)
+	for rows.Next() {
+		var userID uid.ID
+		var h int64
+		if err := rows.Scan(&userID, &h); err != nil {
+			return nil, err
+		}
+		if simhash.Distance(hash, uint64(h)) > policy.MaxDistance {
+			continue
+		}
+		if userID == user {
+			check.SameUser++
+		} else {
+			others[userID] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	check.OtherUsers = len(others)
+
+	if policy.UserLimit > 0 && check.SameUser >= policy.UserLimit {
+		check.Reason = fmt.Sprintf("Posted %d near-identical comments in the last %d minutes.", check.SameUser+1, int(policy.Window.Minutes()))
+	} else if policy.AccountLimit > 0 && check.OtherUsers >= policy.AccountLimit {
+		check.Reason = fmt.Sprintf("Near-identical comments posted by %d accounts in the last %d minutes.", check.OtherUsers+1, int(policy.Window.Minutes()))
+	}
+	return check, nil
+}
+
+// PruneCommentFingerprints deletes the fingerprints of comments that are too
+// old to be of use in detecting floods.
+func PruneCommentFingerprints(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM comment_fingerprints WHERE created_at < ?", time.Now().Add(-commentFingerprintsTTL))
+	return err
+}
+
+// HeldComment is a comment withheld, as part of a flood, until an admin
+// reviews it.
+type HeldComment struct {
+	ID        uid.ID     `json:"id"`
+	PostID    uid.ID     `json:"postId"`
+	ParentID  uid.NullID `json:"parentId"`
+	UserID    uid.ID     `json:"userId"`
+	Username  string     `json:"username"`
+	UserGroup UserGroup  `json:"userGroup"`
+	Body      string     `json:"body"`
+	ImageID   uid.NullID `json:"imageId"`
+	Reason    string     `json:"reason"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// HoldComment withholds the comment of user (posted as g) on post, which
+// check found to be a flood, until an admin reviews it.
+func HoldComment(ctx context.Context, db *sql.DB, post *Post, user uid.ID, g UserGroup, parentComment *uid.ID, body string, image *uid.ID, check *CommentFloodCheck) (*HeldComment, error) {
+	held := &HeldComment{
+		ID:        uid.New(),
+		PostID:    post.ID,
+		UserID:    user,
+		UserGroup: g,
+		Body:      strings.TrimSpace(body),
+		Reason:    check.Reason,
+		CreatedAt: time.Now(),
+	}
+	if parentComment != nil {
+		held.ParentID = uid.NullID{ID: *parentComment, Valid: true}
+	}
+	if image != nil {
+		held.ImageID = uid.NullID{ID: *image, Valid: true}
+	}
+	query, args := msql.BuildInsertQuery("held_comments", []msql.ColumnValue{
+		{Name: "id", Value: held.ID},
+		{Name: "post_id", Value: held.PostID},
+		{Name: "parent_id", Value: held.ParentID},
+		{Name: "user_id", Value: held.UserID},
+		{Name: "user_group", Value: held.UserGroup},
+		{Name: "body", Value: held.Body},
+		{Name: "image_id", Value: held.ImageID},
+		{Name: "simhash", Value: int64(check.Simhash)},
+		{Name: "reason", Value: held.Reason},
+		{Name: "created_at", Value: held.CreatedAt},
+	})
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+	return held, nil
+}
+
+var heldCommentColumns = []string{
+	"held_comments.id",
+	"held_comments.post_id",
+	"held_comments.parent_id",
+	"held_comments.user_id",
+	"users.username",
+	"held_comments.user_group",
+	"held_comments.body",
+	"held_comments.image_id",
+	"held_comments.reason",
+	"held_comments.created_at",
+}
+
+func scanHeldComments(rows *sql.Rows) ([]*HeldComment, error) {
+	defer rows.Close()
+	items := []*HeldComment{}
+	for rows.Next() {
+		c := &HeldComment{}
+		if err := rows.Scan(&c.ID, &c.PostID, &c.ParentID, &c.UserID, &c.Username, &c.UserGroup, &c.Body, &c.ImageID, &c.Reason, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, c)
+	}
+	return items, rows.Err()
+}
+
+// GetHeldComments returns the comments pending review, newest first.
+func GetHeldComments(ctx context.Context, db *sql.DB) ([]*HeldComment, error) {
+	query := msql.BuildSelectQuery("held_comments", heldCommentColumns, []string{"INNER JOIN users ON users.id = held_comments.user_id"},
+		"ORDER BY held_comments.created_at DESC LIMIT 500")
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return scanHeldComments(rows)
+}
+
+func getHeldComment(ctx context.Context, db *sql.DB, id uid.ID) (*HeldComment, error) {
+	query := msql.BuildSelectQuery("held_comments", heldCommentColumns, []string{"INNER JOIN users ON users.id = held_comments.user_id"},
+		"WHERE held_comments.id = ?")
+	rows, err := db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	items, err := scanHeldComments(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errHeldCommentNotFound
+	}
+	return items[0], nil
+}
+
+// ApproveHeldComment posts the held comment with id, as it was submitted, and
+// returns it.
+func ApproveHeldComment(ctx context.Context, db *sql.DB, id uid.ID) (*Comment, error) {
+	held, err := getHeldComment(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+	post, err := GetPost(ctx, db, &held.PostID, "", nil, false)
+	if err != nil {
+		return nil, err
+	}
+	var parent, image *uid.ID
+	if held.ParentID.Valid {
+		parent = &held.ParentID.ID
+	}
+	if held.ImageID.Valid {
+		image = &held.ImageID.ID
+	}
+	comment, err := post.AddComment(ctx, held.UserID, held.UserGroup, parent, held.Body, image)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM held_comments WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// RejectHeldComment discards the held comment with id.
+func RejectHeldComment(ctx context.Context, db *sql.DB, id uid.ID) error {
+	res, err := db.ExecContext(ctx, "DELETE FROM held_comments WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errHeldCommentNotFound
+	}
+	return nil
+}
+
+// CommentCluster is a group of near-identical comments.
+type CommentCluster struct {
+	Sample     string    `json:"sample"` // The body of the latest comment.
+	Comments   int       `json:"comments"`
+	Held       int       `json:"held"` // Of Comments, those that are held.
+	Users      int       `json:"users"`
+	Usernames  []string  `json:"usernames"`  // Up to 20.
+	CommentIDs []uid.ID  `json:"commentIds"` // Of the comments that are not held (up to 50).
+	FirstAt    time.Time `json:"firstAt"`
+	LastAt     time.Time `json:"lastAt"`
+
+	simhash uint64
+	users   map[uid.ID]bool
+}
+
+// GetCommentClusters returns the clusters of at least minSize near-identical
+// comments (those whose simhashes differ in at most maxDistance bits), posted
+// or held since since, largest first.
+func GetCommentClusters(ctx context.Context, db *sql.DB, since time.Time, maxDistance, minSize int) ([]*CommentCluster, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT comment_fingerprints.comment_id, users.username, comment_fingerprints.user_id, comment_fingerprints.simhash, comments.body, comment_fingerprints.created_at, FALSE
+		FROM comment_fingerprints
+		INNER JOIN comments ON comments.id = comment_fingerprints.comment_id
+		INNER JOIN users ON users.id = comment_fingerprints.user_id
+		WHERE comment_fingerprints.created_at > ?
+		UNION ALL
+		SELECT held_comments.id, users.username, held_comments.user_id, held_comments.simhash, held_comments.body, held_comments.created_at, TRUE
+		FROM held_comments
+		INNER JOIN users ON users.id = held_comments.user_id
+		WHERE held_comments.created_at > ?
+		ORDER BY 6 DESC
+		LIMIT 10000`, since, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clusters []*CommentCluster
+	for rows.Next() {
+		var (
+			id, userID uid.ID
+			username   string
+			hash       int64
+			body       string
+			createdAt  time.Time
+			held       bool
+		)
+		if err := rows.Scan(&id, &username, &userID, &hash, &body, &createdAt, &held); err != nil {
+			return nil, err
+		}
+
+		var cluster *CommentCluster
+		for _, c := range clusters {
+			if simhash.Distance(c.simhash, uint64(hash)) <= maxDistance {
+				cluster = c
+				break
+			}
+		}
+		if cluster == nil {
+			// Rows are newest first.
+			cluster = &CommentCluster{Sample: body, LastAt: createdAt, simhash: uint64(hash), users: make(map[uid.ID]bool)}
+			clusters = append(clusters, cluster)
+		}
+		cluster.Comments++
+		cluster.FirstAt = createdAt
+		if held {
+			cluster.Held++
+		} else if len(cluster.CommentIDs) < 50 {
+			cluster.CommentIDs = append(cluster.CommentIDs, id)
+		}
+		if !cluster.users[userID] {
+			cluster.users[userID] = true
+			if len(cluster.Usernames) < 20 {
+				cluster.Usernames = append(cluster.Usernames, username)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	items := []*CommentCluster{}
+	for _, c := range clusters {
+		if c.Comments >= minSize {
+			c.Users = len(c.users)
+			items = append(items, c)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Comments > items[j].Comments
+	})
+	return items, nil
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"context"
+	"strconv"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// commentsContinueDepth is the number of levels of comments in a page of the
+// comments of a post (or in a comment thread, below its comment). The replies
+// of comments at the last level are left out, behind a continuation.
+const commentsContinueDepth = 8
+
+// A CommentContinuation stands in for the replies of a comment that are left
+// out of a page of comments (or of a comment thread), either because they're
+// too deep or because there are too many of them. They are fetched with the
+// thread of the comment (see Post.GetCommentThread).
+type CommentContinuation struct {
+	Count int `json:"count"` // The number of comments left out.
+}
+
+// continueThreads returns comments without those at depth maxDepth or below,
+// and sets the Continuation of the comments (of a post) whose replies are left
+// out. If complete is true, comments are taken to be all the comments of a
+// subtree (down to maxDepth) but for those that did not fit, and so every
+// comment with replies not in comments gets a continuation; otherwise only
+// those at the last level do.
+func continueThreads(comments []*Comment, maxDepth int, complete bool) []*Comment {
+	shown := make([]*Comment, 0, len(comments))
+	for _, c := range comments {
+		if c.Depth < maxDepth {
+			shown = append(shown, c)
+		}
+	}
+
+	var included map[uid.ID]int // The number of replies of each comment in shown.
+	if complete {
+		included = make(map[uid.ID]int)
+		for _, c := range shown {
+			for _, a := range c.Ancestors {
+				included[a]++
+			}
+		}
+	}
+
+	for _, c := range shown {
+		c.Continuation = nil
+		left := 0
+		if c.Depth == maxDepth-1 {
+			left = c.NumReplies
+		} else if complete {
+			left = c.NumReplies - included[c.ID]
+		}
+		if left > 0 {
+			c.Continuation = &CommentContinuation{Count: left}
+		}
+	}
+	return shown
+}
+
+// CommentThread is a comment with its replies, down to commentsContinueDepth
+// levels below it, and the comments it replies to.
+type CommentThread struct {
+	Ancestors []*Comment `json:"ancestors"` // From root to parent.
+	Comment   *Comment   `json:"comment"`
+
+	// A page of at most commentsFetchLimit replies, the shallower first (and
+	// then the older first). Those below the last level are behind
+	// continuations.
+	Replies []*Comment `json:"replies"`
+
+	// The cursor of the next page of replies, if any. The replies of a page
+	// reply to the comment or to replies in the same or in earlier pages.
+	Next msql.NullString `json:"next"`
+}
+
+// GetCommentThread returns the thread of comment (of p), which is how the
+// replies behind a continuation are fetched, with the page of replies at
+// cursor (or the first page, if cursor is nil). The Score of cursor is the
+// depth of the next reply. Chains of self-replies are marked (see
+// markSelfReplyChains).
+func (p *Post) GetCommentThread(ctx context.Context, viewer *uid.ID, comment uid.ID, cursor *CommentsCursor) (*CommentThread, error) {
+	c, err := GetComment(ctx, p.db, comment, viewer)
+	if err != nil {
+		return nil, err
+	}
+	if c.PostID != p.ID {
+		return nil, errCommentNotFound
+	}
+	thread := &CommentThread{Ancestors: []*Comment{}, Comment: c, Replies: []*Comment{}}
+
+	if len(c.Ancestors) > 0 {
+		ancestors, err := getCommentsList(ctx, p.db, viewer, c.Ancestors)
+		if err != nil {
+			return nil, err
+		}
+		byID := make(map[uid.ID]*Comment, len(ancestors))
+		for _, a := range ancestors {
+			byID[a.ID] = a
+		}
+		for _, id := range c.Ancestors {
+			if a := byID[id]; a != nil {
+				thread.Ancestors = append(thread.Ancestors, a)
+			}
+		}
+	}
+
+	maxDepth := c.Depth + commentsContinueDepth
+	where, args := "WHERE comment_replies.parent_id = ? AND comments.depth < ? ", []any{c.ID, maxDepth}
+	if cursor != nil {
+		where += "AND (comments.depth, comments.id) >= (?, ?) "
+		args = append(args, cursor.Score, cursor.NextID)
+	}
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT comment_replies.reply_id, comments.depth
+		FROM comment_replies
+		INNER JOIN comments ON comments.id = comment_replies.reply_id
+		`+where+`
+		ORDER BY comments.depth, comments.id
+		LIMIT ?`, append(args, commentsFetchLimit+1)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var (
+		ids    []uid.ID
+		depths []int
+	)
+	for rows.Next() {
+		var (
+			id    uid.ID
+			depth int
+		)
+		if err := rows.Scan(&id, &depth); err != nil {
+			return nil, err
+		}
+		ids, depths = append(ids, id), append(depths, depth)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) > commentsFetchLimit {
+		thread.Next = msql.NewNullString(strconv.Itoa(depths[commentsFetchLimit]) + "." + ids[commentsFetchLimit].String())
+		ids = ids[:commentsFetchLimit]
+	}
+
+	var replies []*Comment
+	if len(ids) > 0 {
+		if replies, err = getCommentsList(ctx, p.db, viewer, ids); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only the last page has all the replies (down to maxDepth) of the
+	// comments in it, and so only there do comments get continuations for
+	// replies left out above the last level. The comment itself is only
+	// accounted for in the first page.
+	if cursor == nil {
+		replies = continueThreads(append([]*Comment{c}, replies...), maxDepth, !thread.Next.Valid)[1:]
+	} else {
+		replies = continueThreads(replies, maxDepth, !thread.Next.Valid)
+	}
+	markSelfReplyChains(append(append(append([]*Comment{}, thread.Ancestors...), c), replies...))
+	thread.Replies = append(thread.Replies, replies...)
+	return thread, nil
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestContinueThreads(t *testing.T) {
+	comment := func(parent *Comment, replies int) *Comment {
+		c := &Comment{ID: uid.New(), NumReplies: replies}
+		if parent != nil {
+			c.ParentID = uid.NullID{Valid: true, ID: parent.ID}
+			c.Ancestors = append(append([]uid.ID{}, parent.Ancestors...), parent.ID)
+			c.Depth = parent.Depth + 1
+		}
+		return c
+	}
+
+	root := comment(nil, 3)
+	reply := comment(root, 2)
+	deep := comment(reply, 1)
+	deeper := comment(deep, 0)
+	other := comment(nil, 2) // One of whose replies didn't fit.
+	otherReply := comment(other, 0)
+
+	shown := continueThreads([]*Comment{root, reply, deep, deeper, other, otherReply}, 2, false)
+	if len(shown) != 4 {
+		t.Fatalf("got %d comments, want 4", len(shown))
+	}
+	for _, c := range shown {
+		if c == deep || c == deeper {
+			t.Errorf("comment at depth %d not left out", c.Depth)
+		}
+	}
+	if reply.Continuation == nil || reply.Continuation.Count != 2 {
+		t.Errorf("got continuation %+v of the last level, want a count of 2", reply.Continuation)
+	}
+	for _, c := range []*Comment{root, other, otherReply} {
+		if c.Continuation != nil {
+			t.Errorf("comment at depth %d: got continuation %+v, want none", c.Depth, c.Continuation)
+		}
+	}
+
+	continueThreads([]*Comment{root, reply, deep, deeper, other, otherReply}, 4, true)
+	if root.Continuation != nil || reply.Continuation != nil || deep.Continuation != nil || deeper.Continuation != nil {
+		t.Error("got a continuation on a comment whose replies are all included")
+	}
+	if other.Continuation == nil || other.Continuation.Count != 1 {
+		t.Errorf("got continuation %+v of a comment with a reply left out, want a count of 1", other.Continuation)
+	}
+}
+
+func TestGetCommentThreadPages(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteTestDB(t)
+	user, err := RegisterUser(ctx, db, "user", "", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE users SET is_admin = TRUE"); err != nil { // To create the community.
+		t.Fatal(err)
+	}
+	comm, err := CreateCommunity(ctx, db, user.ID, 0, 100, "general", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	post, err := CreateTextPost(ctx, db, user.ID, comm.ID, "Post", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	reply := func(parent *uid.ID) *Comment {
+		t.Helper()
+		n++
+		c, err := post.AddComment(ctx, user.ID, UserGroupNormal, parent, fmt.Sprintf("Comment %d", n), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// A thread of one more reply than fits in a page, the last of which is a
+	// reply to a reply.
+	root := reply(nil)
+	var first *Comment
+	for i := 0; i < commentsFetchLimit; i++ {
+		if c := reply(&root.ID); first == nil {
+			first = c
+		}
+	}
+	last := reply(&first.ID)
+
+	thread, err := post.GetCommentThread(ctx, nil, root.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thread.Replies) != commentsFetchLimit || !thread.Next.Valid {
+		t.Fatalf("got %d replies and next %v on the first page, want %d and a cursor", len(thread.Replies), thread.Next, commentsFetchLimit)
+	}
+	for _, c := range thread.Replies {
+		if c.ID == last.ID {
+			t.Fatal("the deeper reply is on the first page")
+		}
+		if c.Continuation != nil {
+			t.Errorf("got continuation %+v on the first page, want none", c.Continuation)
+		}
+	}
+
+	score, id, err := NextPointsIDCursor(thread.Next.String)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread, err = post.GetCommentThread(ctx, nil, root.ID, &CommentsCursor{Score: score, NextID: *id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thread.Replies) != 1 || thread.Replies[0].ID != last.ID || thread.Next.Valid {
+		t.Fatalf("got %d replies and next %v on the second page, want only the deeper reply", len(thread.Replies), thread.Next)
+	}
+	if thread.Comment.ID != root.ID || thread.Comment.Continuation != nil {
+		t.Errorf("got comment %v with continuation %+v on the second page, want the root without one", thread.Comment.ID, thread.Comment.Continuation)
+	}
+}
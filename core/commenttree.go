@@ -0,0 +1,366 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// A CommentTreeAnomaly is a column of a comment (or its rows in
+// comment_replies) that's inconsistent with the tree of the comments of its
+// post, as are those left behind by partially failed transactions.
+type CommentTreeAnomaly struct {
+	PostID    uid.ID `json:"postId"`
+	CommentID uid.ID `json:"commentId"`
+
+	// One of parent_id, ancestors, depth, no_replies, no_replies_direct, or
+	// comment_replies.
+	Field string `json:"field"`
+	Got   string `json:"got"`
+	Want  string `json:"want"`
+
+	// Anomalies of parent_id (a parent that's not a comment of the post, or
+	// a cycle) can't be repaired, and neither can the others of the post.
+	Repairable bool `json:"repairable"`
+}
+
+// CommentTreeReport is the result of CheckCommentTrees.
+type CommentTreeReport struct {
+	PostsChecked    int                   `json:"postsChecked"`
+	CommentsChecked int                   `json:"commentsChecked"`
+	Anomalies       []*CommentTreeAnomaly `json:"anomalies"`
+	Repaired        int                   `json:"repaired"`
+
+	// Rows of comment_replies whose reply is not a comment (found and, if
+	// repairing, deleted).
+	OrphanedReplies int `json:"orphanedReplies"`
+}
+
+// treeComment is the part of a comment that makes up its place in the tree.
+type treeComment struct {
+	id              uid.ID
+	parent          uid.NullID
+	ancestors       []byte // JSON
+	depth           int
+	noReplies       int
+	noRepliesDirect int
+}
+
+// commentTreeFix is what repairs the anomalies of the tree of a post.
+type commentTreeFix struct {
+	comments []*treeComment // With their correct columns.
+	replies  []uid.ID       // The comments whose rows in comment_replies are to be recreated.
+
+	// The correct ancestors of the comments, by comment.
+	ancestors map[uid.ID][]uid.ID
+}
+
+// checkCommentTree checks the tree of comments (all the comments of post)
+// against replies (the rows of comment_replies of those comments: the number
+// of rows of each parent, by reply). It returns the anomalies found, and the
+// fix for them (which is nil if there are none, or if they can't be
+// repaired).
+func checkCommentTree(post uid.ID, comments []*treeComment, replies map[uid.ID]map[uid.ID]int) ([]*CommentTreeAnomaly, *commentTreeFix) {
+	byID := make(map[uid.ID]*treeComment, len(comments))
+	for _, c := range comments {
+		byID[c.id] = c
+	}
+
+	var anomalies []*CommentTreeAnomaly
+	add := func(c uid.ID, field string, got, want any) {
+		anomalies = append(anomalies, &CommentTreeAnomaly{
+			PostID:     post,
+			CommentID:  c,
+			Field:      field,
+			Got:        fmt.Sprint(got),
+			Want:       fmt.Sprint(want),
+			Repairable: true,
+		})
+	}
+
+	// The ancestors of each comment, from the root to the parent, as per the
+	// parent_id columns.
+	ancestors := make(map[uid.ID][]uid.ID, len(comments))
+	broken := false
+	var resolve func(c *treeComment, seen map[uid.ID]bool) ([]uid.ID, bool)
+	resolve = func(c *treeComment, seen map[uid.ID]bool) ([]uid.ID, bool) {
+		if a, ok := ancestors[c.id]; ok {
+			return a, true
+		}
+		if !c.parent.Valid {
+			ancestors[c.id] = nil
+			return nil, true
+		}
+		parent, ok := byID[c.parent.ID]
+		if !ok || seen[c.id] {
+			return nil, false
+		}
+		seen[c.id] = true
+		a, ok := resolve(parent, seen)
+		if !ok {
+			return nil, false
+		}
+		a = append(append([]uid.ID{}, a...), parent.id)
+		ancestors[c.id] = a
+		return a, true
+	}
+	for _, c := range comments {
+		if _, ok := resolve(c, make(map[uid.ID]bool)); ok {
+			continue
+		}
+		broken = true
+		// Only the comments whose parent is missing, and those in a cycle,
+		// are reported (and not the replies to them).
+		if _, ok := byID[c.parent.ID]; !ok {
+			anomalies = append(anomalies, &CommentTreeAnomaly{PostID: post, CommentID: c.id, Field: "parent_id", Got: c.parent.ID.String(), Want: "a comment of the post"})
+			continue
+		}
+		for p, i := byID[c.parent.ID], 0; p != nil && i < len(comments); i++ {
+			if p.id == c.id {
+				anomalies = append(anomalies, &CommentTreeAnomaly{PostID: post, CommentID: c.id, Field: "parent_id", Got: c.parent.ID.String(), Want: "not a reply to itself"})
+				break
+			}
+			if !p.parent.Valid {
+				break
+			}
+			p = byID[p.parent.ID]
+		}
+	}
+
+	direct := make(map[uid.ID]int)
+	all := make(map[uid.ID]int)
+	for _, c := range comments {
+		if c.parent.Valid {
+			direct[c.parent.ID]++
+		}
+		for _, a := range ancestors[c.id] {
+			all[a]++
+		}
+	}
+
+	fix := &commentTreeFix{ancestors: ancestors}
+	for _, c := range comments {
+		a, ok := ancestors[c.id]
+		if !ok {
+			continue // Its parent_id is broken.
+		}
+		want := &treeComment{id: c.id, parent: c.parent, depth: len(a), noReplies: all[c.id], noRepliesDirect: direct[c.id]}
+		if a != nil {
+			want.ancestors, _ = json.Marshal(a)
+		}
+		changed := false
+		if !sameAncestors(c.ancestors, a) {
+			add(c.id, "ancestors", string(c.ancestors), string(want.ancestors))
+			changed = true
+		}
+		if c.depth != want.depth {
+			add(c.id, "depth", c.depth, want.depth)
+			changed = true
+		}
+		if c.noReplies != want.noReplies {
+			add(c.id, "no_replies", c.noReplies, want.noReplies)
+			changed = true
+		}
+		if c.noRepliesDirect != want.noRepliesDirect {
+			add(c.id, "no_replies_direct", c.noRepliesDirect, want.noRepliesDirect)
+			changed = true
+		}
+		if changed {
+			fix.comments = append(fix.comments, want)
+		}
+
+		// Each ancestor of the comment has a row (and only one).
+		got, wanted := 0, make(map[uid.ID]bool, len(a))
+		for _, p := range a {
+			wanted[p] = true
+		}
+		ok = true
+		for p, n := range replies[c.id] {
+			got += n
+			if !wanted[p] || n != 1 {
+				ok = false
+			}
+		}
+		if !ok || got != len(a) {
+			add(c.id, "comment_replies", strconv.Itoa(got)+" rows", strconv.Itoa(len(a))+" rows")
+			fix.replies = append(fix.replies, c.id)
+		}
+	}
+
+	if broken {
+		for _, a := range anomalies {
+			a.Repairable = false
+		}
+		return anomalies, nil
+	}
+	if len(anomalies) == 0 {
+		return nil, nil
+	}
+	return anomalies, fix
+}
+
+// sameAncestors reports whether the JSON column got holds want.
+func sameAncestors(got []byte, want []uid.ID) bool {
+	if got == nil || bytes.Equal(got, []byte("null")) {
+		return want == nil
+	}
+	var a []uid.ID
+	if err := json.Unmarshal(got, &a); err != nil || len(a) != len(want) || want == nil {
+		return false
+	}
+	for i := range a {
+		if a[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// loadCommentTree returns the comments of post, and their rows of
+// comment_replies (see checkCommentTree).
+func loadCommentTree(ctx context.Context, tx *sql.Tx, post uid.ID) ([]*treeComment, map[uid.ID]map[uid.ID]int, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT id, parent_id, ancestors, depth, no_replies, no_replies_direct FROM comments WHERE post_id = ?", post)
+	if err != nil {
+		return nil, nil, err
+	}
+	var comments []*treeComment
+	for rows.Next() {
+		c := &treeComment{}
+		if err := rows.Scan(&c.id, &c.parent, &c.ancestors, &c.depth, &c.noReplies, &c.noRepliesDirect); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		comments = append(comments, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `SELECT comment_replies.parent_id, comment_replies.reply_id FROM comment_replies
+		INNER JOIN comments ON comments.id = comment_replies.reply_id WHERE comments.post_id = ?`, post)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	replies := make(map[uid.ID]map[uid.ID]int)
+	for rows.Next() {
+		var parent, reply uid.ID
+		if err := rows.Scan(&parent, &reply); err != nil {
+			return nil, nil, err
+		}
+		if replies[reply] == nil {
+			replies[reply] = make(map[uid.ID]int)
+		}
+		replies[reply][parent]++
+	}
+	return comments, replies, rows.Err()
+}
+
+// applyCommentTreeFix repairs a tree of comments with fix.
+func applyCommentTreeFix(ctx context.Context, tx *sql.Tx, fix *commentTreeFix) error {
+	for _, c := range fix.comments {
+		var ancestors any
+		if c.ancestors != nil {
+			ancestors = c.ancestors
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE comments SET ancestors = ?, depth = ?, no_replies = ?, no_replies_direct = ? WHERE id = ?",
+			ancestors, c.depth, c.noReplies, c.noRepliesDirect, c.id); err != nil {
+			return err
+		}
+	}
+	for _, c := range fix.replies {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM comment_replies WHERE reply_id = ?", c); err != nil {
+			return err
+		}
+		for _, p := range fix.ancestors[c] {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO comment_replies (parent_id, reply_id) VALUES (?, ?)", p, c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CheckCommentTrees checks the trees of the comments of posts (of all posts,
+// if posts is empty): the ancestors, depth, and numbers of replies of each
+// comment, and its rows of comment_replies. If repair is true, the anomalies
+// found are repaired (those that can be, see CommentTreeAnomaly), post by
+// post. Comments added while a post is repaired wait for it.
+func CheckCommentTrees(ctx context.Context, db *sql.DB, posts []uid.ID, repair bool) (*CommentTreeReport, error) {
+	report := &CommentTreeReport{Anomalies: []*CommentTreeAnomaly{}}
+	checkPost := func(post uid.ID) error {
+		return msql.Transact(ctx, db, func(tx *sql.Tx) error {
+			if repair {
+				// Locks the post, which AddComment updates (and so waits for)
+				// before it updates the tree.
+				if _, err := tx.ExecContext(ctx, "UPDATE posts SET no_comments = no_comments WHERE id = ?", post); err != nil {
+					return err
+				}
+			}
+			comments, replies, err := loadCommentTree(ctx, tx, post)
+			if err != nil {
+				return err
+			}
+			report.PostsChecked++
+			report.CommentsChecked += len(comments)
+			anomalies, fix := checkCommentTree(post, comments, replies)
+			report.Anomalies = append(report.Anomalies, anomalies...)
+			if repair && fix != nil {
+				if err := applyCommentTreeFix(ctx, tx, fix); err != nil {
+					return err
+				}
+				report.Repaired += len(anomalies)
+			}
+			return nil
+		})
+	}
+
+	if len(posts) > 0 {
+		for _, post := range posts {
+			if err := checkPost(post); err != nil {
+				return report, err
+			}
+		}
+		return report, nil
+	}
+
+	var last uid.ID
+	for {
+		rows, err := db.QueryContext(ctx, `SELECT id FROM posts WHERE id > ?
+			AND EXISTS (SELECT 1 FROM comments WHERE comments.post_id = posts.id) ORDER BY id LIMIT 500`, last)
+		if err != nil {
+			return report, err
+		}
+		ids, err := scanIDs(rows)
+		if err != nil {
+			return report, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		for _, post := range ids {
+			if err := checkPost(post); err != nil {
+				return report, err
+			}
+		}
+		last = ids[len(ids)-1]
+	}
+
+	orphaned := "FROM comment_replies WHERE NOT EXISTS (SELECT 1 FROM comments WHERE comments.id = comment_replies.reply_id)"
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) "+orphaned).Scan(&report.OrphanedReplies); err != nil {
+		return report, err
+	}
+	if repair && report.OrphanedReplies > 0 {
+		if _, err := db.ExecContext(ctx, "DELETE "+orphaned); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
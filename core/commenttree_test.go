@@ -0,0 +1,64 @@
+package core
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestCheckCommentTree(t *testing.T) {
+	post := uid.New()
+	root, reply, nested := uid.New(), uid.New(), uid.New()
+	ancestorsJSON := func(ids ...uid.ID) []byte {
+		b, _ := json.Marshal(ids)
+		return b
+	}
+	tree := func() []*treeComment {
+		return []*treeComment{
+			{id: root, noReplies: 2, noRepliesDirect: 1},
+			{id: reply, parent: uid.NullID{Valid: true, ID: root}, ancestors: ancestorsJSON(root), depth: 1, noReplies: 1, noRepliesDirect: 1},
+			{id: nested, parent: uid.NullID{Valid: true, ID: reply}, ancestors: ancestorsJSON(root, reply), depth: 2},
+		}
+	}
+	replies := func() map[uid.ID]map[uid.ID]int {
+		return map[uid.ID]map[uid.ID]int{
+			reply:  {root: 1},
+			nested: {root: 1, reply: 1},
+		}
+	}
+
+	if anomalies, fix := checkCommentTree(post, tree(), replies()); len(anomalies) != 0 || fix != nil {
+		t.Fatalf("consistent tree: got %d anomalies", len(anomalies))
+	}
+
+	// Drifted counts and a missing row of comment_replies.
+	comments, rs := tree(), replies()
+	comments[0].noReplies = 5
+	comments[2].depth = 1
+	delete(rs[nested], root)
+	anomalies, fix := checkCommentTree(post, comments, rs)
+	fields := make(map[string]bool)
+	for _, a := range anomalies {
+		fields[a.Field] = a.Repairable
+	}
+	if len(anomalies) != 3 || !fields["no_replies"] || !fields["depth"] || !fields["comment_replies"] {
+		t.Fatalf("drifted tree: got %d anomalies (%v)", len(anomalies), fields)
+	}
+	if fix == nil || len(fix.comments) != 2 || len(fix.replies) != 1 || len(fix.ancestors[nested]) != 2 {
+		t.Fatalf("drifted tree: got fix %+v", fix)
+	}
+	for _, c := range fix.comments {
+		if c.id == root && c.noReplies != 2 || c.id == nested && c.depth != 2 {
+			t.Errorf("drifted tree: got fixed comment %+v", c)
+		}
+	}
+
+	// A reply whose parent is not a comment of the post can't be repaired.
+	comments = tree()
+	comments[1].parent.ID = uid.New()
+	anomalies, fix = checkCommentTree(post, comments, replies())
+	if fix != nil || len(anomalies) == 0 || anomalies[0].Field != "parent_id" || anomalies[0].Repairable {
+		t.Fatalf("broken tree: got %d anomalies and fix %v", len(anomalies), fix)
+	}
+}
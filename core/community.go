@@ -5,7 +5,6 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"slices"
 	"sort"
@@ -37,6 +36,32 @@ type Community struct {
 	DeletedAt     msql.NullTime   `json:"deletedAt"`
 	DeletedBy     uid.NullID      `json:"-"`
 
+	// DisableVideoPosts is true if video posts are not allowed in the
+	// community.
+	DisableVideoPosts bool `json:"disableVideoPosts"`
+
+	// DisableImagePosts is true if image posts are not allowed in the
+	// community, and DisableGIFs is true if GIFs are allowed neither in image
+	// posts nor attached to comments.
+	DisableImagePosts bool `json:"disableImagePosts"`
+	DisableGIFs       bool `json:"disableGifs"`
+
+	// AllowCommentImages is true if comments in the community may have an
+	// image (or GIF) attached.
+	AllowCommentImages bool `json:"allowCommentImages"`
+
+	// The slugs of the categories the community is in (see Category).
+	Categories []string `json:"categories"`
+
+	// TimeZone is the time zone (like Europe/Paris) of the community, set by
+	// the mods. If empty, UTC is used.
+	TimeZone string `json:"timeZone"`
+
+	// DefaultCommentSort is the order in which the comments of the posts of
+	// the community are listed unless another is asked for (see
+	// Post.GetComments).
+	DefaultCommentSort CommentSort `json:"defaultCommentSort"`
+
 	// IsDefault is nil until Default is called.
 	IsDefault *bool `json:"isDefault,omitempty"`
 
@@ -44,9 +69,12 @@ type Community struct {
 	ViewerMod     msql.NullBool `json:"userMod"`
 	MutedByViewer bool          `json:"isMuted"`
 
-	Mods           []*User                  `json:"mods"`
-	Rules          []*CommunityRule         `json:"rules"`
-	ReportsDetails *CommunityReportsDetails `json:"ReportsDetails"`
+	Mods               []*User                  `json:"mods"`
+	Rules              []*CommunityRule         `json:"rules"`
+	Blocks             []*CommunityBlock        `json:"blocks"`             // Of the sidebar and the about page (see FetchBlocks).
+	RelatedCommunities []*RelatedCommunity      `json:"relatedCommunities"` // Declared by the mods (see FetchRelated).
+	LinkDomainRules    []*LinkDomainRule        `json:"linkDomainRules"`    // Of the community (see FetchLinkDomainRules).
+	ReportsDetails     *CommunityReportsDetails `json:"ReportsDetails"`
 }
 
 func buildSelectCommunityQuery(where string) string {
@@ -60,6 +88,12 @@ func buildSelectCommunityQuery(where string) string {
 		"communities.no_members",
 		"communities.created_at",
 		"communities.deleted_at",
+		"communities.disable_video_posts",
+		"communities.disable_image_posts",
+		"communities.disable_gifs",
+		"communities.allow_comment_images",
+		"communities.time_zone",
+		"communities.default_comment_sort",
 	}
 	cols = append(cols, images.ImageColumns("pro_pic")...)
 	cols = append(cols, images.ImageColumns("banner")...)
@@ -140,6 +174,30 @@ func GetCommunitiesByIDs(ctx context.Context, db *sql.DB, ids []uid.ID, viewer *
 	return comms, err
 }
 
+// getCommunitiesByNames returns the communities with the given names (case
+// insensitive). Names that match no community are ignored.
+func getCommunitiesByNames(ctx context.Context, db *sql.DB, names []string, viewer *uid.ID) ([]*Community, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	args := make([]any, len(names))
+	for i := range names {
+		args[i] = strings.ToLower(names[i])
+	}
+
+	return getCommunities(ctx, db, viewer, fmt.Sprintf("WHERE communities.name_lc IN %s", msql.InClauseQuestionMarks(len(names))), args...)
+}
+
+// The sizes at which profile pictures (of both users and communities) and
+// banner images are saved. Uploads are scaled and cropped to these, so that
+// the originals are never much larger than their largest copies.
+const (
+	proPicSize        = 512
+	bannerImageWidth  = 1440
+	bannerImageHeight = 480
+)
+
 func setCommunityProPicCopies(image *images.Image) {
 	image.AppendCopy("tiny", 50, 50, images.ImageFitCover, "")
 	image.AppendCopy("small", 120, 120, images.ImageFitCover, "")
@@ -167,6 +225,12 @@ func scanCommunities(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *ui
 			&c.NumMembers,
 			&c.CreatedAt,
 			&c.DeletedAt,
+			&c.DisableVideoPosts,
+			&c.DisableImagePosts,
+			&c.DisableGIFs,
+			&c.AllowCommentImages,
+			&c.TimeZone,
+			&c.DefaultCommentSort,
 		}
 
 		proPic, bannerImage := &images.Image{}, &images.Image{}
@@ -194,6 +258,10 @@ func scanCommunities(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *ui
 		return nil, err
 	}
 
+	if err := populateCommunitiesCategories(ctx, db, comms); err != nil {
+		return nil, err
+	}
+
 	if viewer != nil {
 		mutes, err := GetMutedCommunities(ctx, db, *viewer, false)
 		if err != nil {
@@ -226,7 +294,7 @@ var communityCreationAdminOnly = true
 func CreateCommunity(ctx context.Context, db *sql.DB, creator uid.ID, reqPoints, maxPerUser int, name, about string) (*Community, error) {
 	about = utils.TruncateUnicodeString(about, maxCommunityAboutLength)
 	if err := IsUsernameValid(name); err != nil {
-		return nil, httperr.NewBadRequest("invalid-community-name", fmt.Sprintf("Community name invalid. It %s.", err.Error()))
+		return nil, httperr.NewInvalidField("name", "invalid-community-name", fmt.Sprintf("Community name invalid. It %s.", err.Error()))
 	}
 
 	user, err := GetUser(ctx, db, creator, nil)
@@ -277,7 +345,7 @@ func CreateCommunity(ctx context.Context, db *sql.DB, creator uid.ID, reqPoints,
 	// Attempt to make user a mod of community.
 	if err := comm.Join(ctx, creator); err == nil {
 		comm.ViewerJoined = msql.NewNullBool(true)
-		if err = makeUserMod(ctx, db, comm, creator, true); err == nil {
+		if err = makeUserMod(ctx, db, comm, creator, true, ""); err == nil {
 			comm.ViewerMod = msql.NewNullBool(true)
 		}
 	}
@@ -304,6 +372,11 @@ const (
 	CommunitiesSortSize    = CommunitiesSort("size")
 	CommunitiesSortNameAsc = CommunitiesSort("name_asc")
 	CommunitiesSortNameDsc = CommunitiesSort("name_dsc")
+
+	// Most active first, by the number of posts and comments in the last
+	// week.
+	CommunitiesSortActivity = CommunitiesSort("activity")
+
 	CommunitiesSortDefault = CommunitiesSortNameAsc
 )
 
@@ -314,6 +387,7 @@ func (s CommunitiesSort) Valid() bool {
 		CommunitiesSortSize,
 		CommunitiesSortNameAsc,
 		CommunitiesSortNameDsc,
+		CommunitiesSortActivity,
 	}
 	return slices.Contains(valid, s)
 }
@@ -324,8 +398,9 @@ const (
 	CommunitiesSetSubscribed = "subscribed"
 )
 
-// GetCommunities returns a maximum of n communities.
-func GetCommunities(ctx context.Context, db *sql.DB, sort CommunitiesSort, set string, n int, viewer *uid.ID) ([]*Community, error) {
+// GetCommunities returns a maximum of n communities, of those in the category
+// with the slug category if it's not empty.
+func GetCommunities(ctx context.Context, db *sql.DB, sort CommunitiesSort, set, category string, n int, viewer *uid.ID) ([]*Community, error) {
 	if !slices.Contains([]string{CommunitiesSetAll, CommunitiesSetDefault, CommunitiesSetSubscribed}, set) {
 		return nil, httperr.NewBadRequest("invalid-set", "Invalid community set options.")
 	}
@@ -338,6 +413,14 @@ func GetCommunities(ctx context.Context, db *sql.DB, sort CommunitiesSort, set s
 		where += " AND communities.id IN (SELECT community_id FROM community_members WHERE user_id = ?) "
 		args = append(args, *viewer)
 	}
+	if category != "" {
+		c, err := GetCategory(ctx, db, category)
+		if err != nil {
+			return nil, err
+		}
+		where += "AND communities.id IN (SELECT community_id FROM community_categories WHERE category_id = ?) "
+		args = append(args, c.ID)
+	}
 
 	order_by := ""
 	switch sort {
@@ -351,6 +434,11 @@ func GetCommunities(ctx context.Context, db *sql.DB, sort CommunitiesSort, set s
 		order_by = "ORDER BY communities.name_lc "
 	case CommunitiesSortNameDsc:
 		order_by = "ORDER BY communities.name_lc DESC "
+	case CommunitiesSortActivity:
+		order_by = `ORDER BY (SELECT COUNT(*) FROM posts_week WHERE posts_week.community_id = communities.id) +
+			(SELECT COUNT(*) FROM comments WHERE comments.community_id = communities.id AND comments.created_at > ? AND comments.deleted_at IS NULL) DESC,
+			communities.no_members DESC `
+		args = append(args, time.Now().Add(-7*24*time.Hour))
 	default:
 		return nil, httperr.NewBadRequest("invalid-sort", "Invalid community sort option.")
 	}
@@ -420,10 +508,23 @@ func (c *Community) Update(ctx context.Context, mod uid.ID) error {
 	}
 
 	c.About.String = utils.TruncateUnicodeString(c.About.String, maxCommunityAboutLength)
-	_, err := c.db.ExecContext(ctx, "UPDATE communities SET nsfw = ?, about = ? WHERE id = ?", c.NSFW, c.About, c.ID)
+	if err := checkTimeZone("timeZone", c.TimeZone); err != nil {
+		return err
+	}
+	if !c.DefaultCommentSort.Valid() {
+		return httperr.NewInvalidField("defaultCommentSort", "invalid-comment-sort", "Default comment sort must be either top, new, or controversial.")
+	}
+	_, err := c.db.ExecContext(ctx, "UPDATE communities SET nsfw = ?, about = ?, disable_video_posts = ?, disable_image_posts = ?, disable_gifs = ?, allow_comment_images = ?, time_zone = ?, default_comment_sort = ? WHERE id = ?",
+		c.NSFW, c.About, c.DisableVideoPosts, c.DisableImagePosts, c.DisableGIFs, c.AllowCommentImages, c.TimeZone, c.DefaultCommentSort, c.ID)
 	return err
 }
 
+// Location returns the location of the community's time zone (see
+// TimeZone).
+func (c *Community) Location() *time.Location {
+	return timeZoneLocation(c.TimeZone)
+}
+
 // Default reports whether c is a default community, and, if there's no error,
 // it sets c.IsDefault to a non-nil value.
 func (c *Community) Default(ctx context.Context) (bool, error) {
@@ -455,6 +556,44 @@ func (c *Community) SetDefault(ctx context.Context, set bool) error {
 	return err
 }
 
+// Delete deletes c, by admin: c is marked deleted, and its posts are removed
+// from the feeds, its members from it, and it from the default communities.
+// The posts and comments of c are kept (deleting them is left to the mods).
+func (c *Community) Delete(ctx context.Context, admin uid.ID) error {
+	if c.DeletedAt.Valid {
+		return &httperr.Error{
+			HTTPStatus: http.StatusConflict,
+			Code:       "already-deleted",
+			Message:    "Community is already deleted.",
+		}
+	}
+	now := time.Now()
+	err := msql.Transact(ctx, c.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE communities SET deleted_at = ?, deleted_by = ?, no_members = 0 WHERE id = ?", now, admin, c.ID); err != nil {
+			return err
+		}
+		for _, table := range postsTables {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE community_id = ?", c.ID); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM community_members WHERE community_id = ?", c.ID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM default_communities WHERE community_id = ?", c.ID); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.DeletedAt = msql.NewNullTime(now)
+	c.DeletedBy = uid.NullID{Valid: true, ID: admin}
+	c.NumMembers = 0
+	return nil
+}
+
 func (c *Community) Join(ctx context.Context, user uid.ID) error {
 	err := msql.Transact(ctx, c.db, func(tx *sql.Tx) error {
 		if _, err := tx.ExecContext(ctx, "INSERT INTO community_members (community_id, user_id) VALUES (?, ?)", c.ID, user); err != nil {
@@ -473,18 +612,34 @@ func (c *Community) Join(ctx context.Context, user uid.ID) error {
 	}
 
 	c.NumMembers++
+
+	var n int
+	if err := c.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM community_members WHERE user_id = ?", user).Scan(&n); err != nil {
+		return err
+	}
+	if n >= onboardingCommunities {
+		onboardingStepDone(ctx, c.db, user, OnboardingStepJoinCommunities)
+	}
 	return nil
 }
 
 func (c *Community) Leave(ctx context.Context, user uid.ID) error {
+	var left int64
 	err := msql.Transact(ctx, c.db, func(tx *sql.Tx) error {
-		if _, err := tx.ExecContext(ctx, "DELETE FROM community_members WHERE community_id = ? AND user_id = ?", c.ID, user); err != nil {
+		res, err := tx.ExecContext(ctx, "DELETE FROM community_members WHERE community_id = ? AND user_id = ?", c.ID, user)
+		if err != nil {
+			return err
+		}
+		if left, err = res.RowsAffected(); err != nil {
 			return err
 		}
 		if _, err := tx.ExecContext(ctx, "DELETE FROM community_mods WHERE community_id = ? AND user_id = ?", c.ID, user); err != nil {
 			return err
 		}
-		if _, err := tx.ExecContext(ctx, "UPDATE communities SET no_members = no_members - 1 WHERE id = ?", c.ID); err != nil {
+		if left == 0 {
+			return nil // Not a member.
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE communities SET no_members = no_members - 1 WHERE id = ? AND no_members > 0", c.ID); err != nil {
 			return err
 		}
 		return nil
@@ -493,28 +648,36 @@ func (c *Community) Leave(ctx context.Context, user uid.ID) error {
 		return err
 	}
 
-	c.NumMembers--
+	if left > 0 && c.NumMembers > 0 {
+		c.NumMembers--
+	}
 	return nil
 }
 
-func (c *Community) UpdateProPic(ctx context.Context, image []byte) error {
+// UpdateProPic replaces the profile picture of the community with image,
+// cropped to crop (if it's non-nil) and then scaled down to a standard size.
+func (c *Community) UpdateProPic(ctx context.Context, image []byte, crop *images.CropRect) error {
 	var newImageID uid.ID
 	err := msql.Transact(ctx, c.db, func(tx *sql.Tx) error {
 		if err := c.DeleteProPicTx(ctx, tx); err != nil {
 			return err
 		}
-		imageID, err := images.SaveImageTx(ctx, tx, "disk", image, &images.ImageOptions{
-			Width:  2000,
-			Height: 2000,
+		imageID, err := images.SaveImageTx(ctx, tx, images.DefaultStore, image, &images.ImageOptions{
+			Width:  proPicSize,
+			Height: proPicSize,
 			Format: images.ImageFormatJPEG,
-			Fit:    images.ImageFitContain,
+			Fit:    images.ImageFitCover,
+			Crop:   crop,
 		})
 		if err != nil {
-			return fmt.Errorf("fail to save community profile picture: %w", err)
+			return saveImageError("fail to save community profile picture", err)
 		}
 		if _, err := tx.ExecContext(ctx, "UPDATE communities SET pro_pic_2 = ? WHERE id = ?", imageID, c.ID); err != nil {
 			return err
 		}
+		if err := addImageUploadTx(ctx, tx, imageID, nil, &c.ID); err != nil {
+			return err
+		}
 		newImageID = imageID
 		return nil
 	})
@@ -563,24 +726,30 @@ func (c *Community) DeleteProPicTx(ctx context.Context, tx *sql.Tx) error {
 	return nil
 }
 
-func (c *Community) UpdateBannerImage(ctx context.Context, image []byte) error {
+// UpdateBannerImage replaces the banner image of the community with image,
+// cropped to crop (if it's non-nil) and then scaled down to a standard size.
+func (c *Community) UpdateBannerImage(ctx context.Context, image []byte, crop *images.CropRect) error {
 	var newImageID uid.ID
 	err := msql.Transact(ctx, c.db, func(tx *sql.Tx) error {
 		if err := c.DeleteBannerImageTx(ctx, tx); err != nil {
 			return err
 		}
-		imageID, err := images.SaveImageTx(ctx, tx, "disk", image, &images.ImageOptions{
-			Width:  2000,
-			Height: 2000,
+		imageID, err := images.SaveImageTx(ctx, tx, images.DefaultStore, image, &images.ImageOptions{
+			Width:  bannerImageWidth,
+			Height: bannerImageHeight,
 			Format: images.ImageFormatJPEG,
-			Fit:    images.ImageFitContain,
+			Fit:    images.ImageFitCover,
+			Crop:   crop,
 		})
 		if err != nil {
-			return fmt.Errorf("fail to save banner image: %w", err)
+			return saveImageError("fail to save banner image", err)
 		}
 		if _, err := tx.ExecContext(ctx, "UPDATE communities SET banner_image_2 = ? WHERE id = ?", imageID, c.ID); err != nil {
 			return err
 		}
+		if err := addImageUploadTx(ctx, tx, imageID, nil, &c.ID); err != nil {
+			return err
+		}
 		newImageID = imageID
 		return nil
 	})
@@ -980,22 +1149,11 @@ func MakeUserMod(ctx context.Context, db *sql.DB, c *Community, viewer uid.ID, u
 		}
 	}
 
-	err = makeUserMod(ctx, db, c, user, isMod)
+	err = makeUserMod(ctx, db, c, user, isMod, actionUser.Username)
 	if err == nil {
 		if err := c.FixModPositions(ctx); err != nil {
-			log.Println("Fixing mod positions failed: ", err)
-		}
-		// send notification
-		if isMod {
-			if addedBy, err := GetUser(ctx, db, viewer, nil); err == nil {
-				go func() {
-					if err := CreateNewModAddNotification(context.Background(), db, user, c.Name, addedBy.Username); err != nil {
-						log.Println("Failed to create mod_add notification: ", err)
-					}
-				}()
-			}
+			logger.ErrorContext(ctx, "Failed to fix mod positions", "err", err, "community", c.ID)
 		}
-
 	}
 	return err
 }
@@ -1003,7 +1161,7 @@ func MakeUserMod(ctx context.Context, db *sql.DB, c *Community, viewer uid.ID, u
 // MakeUserModCLI adds or removes user as a mod of c. Do not use this function
 // in an API.
 func MakeUserModCLI(db *sql.DB, c *Community, user uid.ID, isMod bool) error {
-	return makeUserMod(context.Background(), db, c, user, isMod)
+	return makeUserMod(context.Background(), db, c, user, isMod, "")
 }
 
 // makeUserMod makes user a moderator of c, or, if isMod is false, user is
@@ -1011,7 +1169,10 @@ func MakeUserModCLI(db *sql.DB, c *Community, user uid.ID, isMod bool) error {
 //
 // It's okay to call this function if user is already a mod of c. It doesn't
 // change anything.
-func makeUserMod(ctx context.Context, db *sql.DB, c *Community, user uid.ID, isMod bool) error {
+//
+// If addedBy (a username) is not empty, and user is made a mod, user is
+// notified of it.
+func makeUserMod(ctx context.Context, db *sql.DB, c *Community, user uid.ID, isMod bool, addedBy string) error {
 	// First add user as member of c.
 	if err := c.Join(ctx, user); err != nil {
 		if e, ok := err.(*httperr.Error); ok {
@@ -1051,6 +1212,15 @@ func makeUserMod(ctx context.Context, db *sql.DB, c *Community, user uid.ID, isM
 		if _, err := tx.ExecContext(ctx, "UPDATE community_members SET is_mod = ? WHERE community_id = ? AND user_id = ?", isMod, c.ID, user); err != nil {
 			return err
 		}
+
+		if isMod && addedBy != "" {
+			return enqueueNotificationTx(ctx, tx, &outboxNotification{
+				Type:      NotificationTypeModAdd,
+				User:      user,
+				Community: c.Name,
+				Author:    addedBy,
+			})
+		}
 		return nil
 	})
 }
@@ -1208,15 +1378,15 @@ type CommunityReportsDetails struct {
 }
 
 func FetchReportsDetails(ctx context.Context, db *sql.DB, community uid.ID) (d CommunityReportsDetails, err error) {
-	row := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM reports WHERE community_id = ?", community)
+	row := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM reports WHERE community_id = ? AND to_admins = FALSE", community)
 	if err = row.Scan(&d.NumReports); err != nil {
 		return
 	}
-	row = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM reports WHERE community_id = ? AND report_type = ?", community, ReportTypePost)
+	row = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM reports WHERE community_id = ? AND to_admins = FALSE AND report_type = ?", community, ReportTypePost)
 	if err = row.Scan(&d.NumPostReports); err != nil {
 		return
 	}
-	row = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM reports WHERE community_id = ? AND report_type = ?", community, ReportTypeComment)
+	row = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM reports WHERE community_id = ? AND to_admins = FALSE AND report_type = ?", community, ReportTypeComment)
 	if err = row.Scan(&d.NumCommentReports); err != nil {
 		return
 	}
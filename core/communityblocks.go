@@ -0,0 +1,366 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/images"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/internal/utils"
+)
+
+// CommunityBlockType is the type of a block of a community's sidebar (and
+// about page).
+type CommunityBlockType string
+
+const (
+	CommunityBlockRules    = CommunityBlockType("rules")    // The rules of the community (see CommunityRule).
+	CommunityBlockLinks    = CommunityBlockType("links")    // A list of links.
+	CommunityBlockRelated  = CommunityBlockType("related")  // A list of related communities.
+	CommunityBlockMarkdown = CommunityBlockType("markdown") // A markdown body.
+)
+
+// Valid reports whether t is one of the block types.
+func (t CommunityBlockType) Valid() bool {
+	return slices.Contains([]CommunityBlockType{CommunityBlockRules, CommunityBlockLinks, CommunityBlockRelated, CommunityBlockMarkdown}, t)
+}
+
+const (
+	maxCommunityBlocks          = 20
+	maxCommunityBlockTitle      = 128   // in runes
+	maxCommunityBlockBodyLength = 10000 // in runes
+	maxCommunityBlockItems      = 20    // Links, or related communities.
+)
+
+var errCommunityBlockNotFound = httperr.NewNotFound("community-block/not-found", "Community block not found.")
+
+// A CommunityBlock is a block of the sidebar (and the about page) of a
+// community. Which of Body, Links, and Related is set depends on Type.
+type CommunityBlock struct {
+	db *sql.DB
+
+	ID          int                `json:"id"` // Zero for the default blocks.
+	CommunityID uid.ID             `json:"communityId"`
+	Type        CommunityBlockType `json:"type"`
+	Title       string             `json:"title"`
+
+	Body    string              `json:"body,omitempty"`
+	Links   []*CommunityLink    `json:"links,omitempty"`
+	Related []*RelatedCommunity `json:"related,omitempty"`
+	ZIndex  int                 `json:"zIndex"`
+
+	CreatedBy uid.ID        `json:"createdBy"`
+	CreatedAt time.Time     `json:"createdAt"`
+	EditedAt  msql.NullTime `json:"editedAt"`
+}
+
+// A CommunityLink is a link in a CommunityBlockLinks block.
+type CommunityLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// A RelatedCommunity is a community in a CommunityBlockRelated block. Only
+// the name is saved; the rest is filled in when the block is fetched (and
+// communities since deleted are left out).
+type RelatedCommunity struct {
+	Name       string        `json:"name"`
+	ID         uid.ID        `json:"id"`
+	NumMembers int           `json:"noMembers"`
+	ProPic     *images.Image `json:"proPic"`
+}
+
+var selectCommunityBlockCols = []string{
+	"id",
+	"community_id",
+	"block_type",
+	"title",
+	"body",
+	"links",
+	"related",
+	"z_index",
+	"created_by",
+	"created_at",
+	"edited_at",
+}
+
+func scanCommunityBlocks(ctx context.Context, db *sql.DB, rows *sql.Rows) ([]*CommunityBlock, error) {
+	defer rows.Close()
+
+	blocks := []*CommunityBlock{}
+	for rows.Next() {
+		b := &CommunityBlock{db: db}
+		var (
+			body           msql.NullString
+			links, related []byte
+		)
+		if err := rows.Scan(&b.ID, &b.CommunityID, &b.Type, &b.Title, &body, &links, &related, &b.ZIndex, &b.CreatedBy, &b.CreatedAt, &b.EditedAt); err != nil {
+			return nil, err
+		}
+		b.Body = body.String
+		if links != nil {
+			if err := json.Unmarshal(links, &b.Links); err != nil {
+				return nil, err
+			}
+		}
+		if related != nil {
+			if err := json.Unmarshal(related, &b.Related); err != nil {
+				return nil, err
+			}
+		}
+		blocks = append(blocks, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for _, b := range blocks {
+		if err := b.populateRelated(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+// populateRelated fills in the communities of b.Related (if b is a
+// CommunityBlockRelated block), leaving out those that no longer exist.
+func (b *CommunityBlock) populateRelated(ctx context.Context) error {
+	if b.Type != CommunityBlockRelated || len(b.Related) == 0 {
+		return nil
+	}
+	related := make([]*RelatedCommunity, 0, len(b.Related))
+	for _, rc := range b.Related {
+		comm, err := GetCommunityByName(ctx, b.db, rc.Name, nil)
+		if err != nil {
+			if httperr.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if comm.DeletedAt.Valid {
+			continue
+		}
+		related = append(related, &RelatedCommunity{Name: comm.Name, ID: comm.ID, NumMembers: comm.NumMembers, ProPic: comm.ProPic})
+	}
+	b.Related = related
+	return nil
+}
+
+// FetchBlocks populates c.Blocks. Communities that have no blocks get the
+// default ones: the about text (if any) and the rules.
+func (c *Community) FetchBlocks(ctx context.Context) error {
+	query := msql.BuildSelectQuery("community_blocks", selectCommunityBlockCols, nil, "WHERE community_id = ? ORDER BY z_index, id")
+	rows, err := c.db.QueryContext(ctx, query, c.ID)
+	if err != nil {
+		return err
+	}
+	blocks, err := scanCommunityBlocks(ctx, c.db, rows)
+	if err != nil {
+		return err
+	}
+	if len(blocks) == 0 {
+		if c.About.Valid && c.About.String != "" {
+			blocks = append(blocks, &CommunityBlock{CommunityID: c.ID, Type: CommunityBlockMarkdown, Body: c.About.String, CreatedBy: c.AuthorID, CreatedAt: c.CreatedAt})
+		}
+		blocks = append(blocks, &CommunityBlock{CommunityID: c.ID, Type: CommunityBlockRules, ZIndex: len(blocks), CreatedBy: c.AuthorID, CreatedAt: c.CreatedAt})
+	}
+	c.Blocks = blocks
+	return nil
+}
+
+// GetCommunityBlock returns the block with the ID id of community.
+func GetCommunityBlock(ctx context.Context, db *sql.DB, community uid.ID, id int) (*CommunityBlock, error) {
+	query := msql.BuildSelectQuery("community_blocks", selectCommunityBlockCols, nil, "WHERE id = ? AND community_id = ?")
+	rows, err := db.QueryContext(ctx, query, id, community)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := scanCommunityBlocks(ctx, db, rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, errCommunityBlockNotFound
+	}
+	return blocks[0], nil
+}
+
+// validate normalizes b and returns an httperr.Error if it's invalid. The
+// fields that don't apply to the type of b are cleared.
+func (b *CommunityBlock) validate(ctx context.Context) error {
+	if !b.Type.Valid() {
+		return httperr.NewBadRequest("community-block/invalid-type", "Invalid block type.")
+	}
+	b.Title = utils.TruncateUnicodeString(strings.TrimSpace(b.Title), maxCommunityBlockTitle)
+
+	if b.Type != CommunityBlockMarkdown {
+		b.Body = ""
+	}
+	if b.Type != CommunityBlockLinks {
+		b.Links = nil
+	}
+	if b.Type != CommunityBlockRelated {
+		b.Related = nil
+	}
+
+	switch b.Type {
+	case CommunityBlockMarkdown:
+		b.Body = utils.TruncateUnicodeString(strings.TrimSpace(b.Body), maxCommunityBlockBodyLength)
+		if b.Body == "" {
+			return httperr.NewBadRequest("community-block/empty", "Block body is empty.")
+		}
+	case CommunityBlockLinks:
+		if len(b.Links) == 0 || len(b.Links) > maxCommunityBlockItems {
+			return httperr.NewBadRequest("community-block/invalid-links", "A links block must have between 1 and 20 links.")
+		}
+		for _, l := range b.Links {
+			if l == nil {
+				return httperr.NewBadRequest("community-block/invalid-url", "Invalid link URL.")
+			}
+			l.Title = utils.TruncateUnicodeString(strings.TrimSpace(l.Title), maxCommunityBlockTitle)
+			l.URL = strings.TrimSpace(l.URL)
+			u, err := url.Parse(l.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return httperr.NewBadRequest("community-block/invalid-url", "Invalid link URL.")
+			}
+			if l.Title == "" {
+				l.Title = u.Host
+			}
+		}
+	case CommunityBlockRelated:
+		if len(b.Related) == 0 || len(b.Related) > maxCommunityBlockItems {
+			return httperr.NewBadRequest("community-block/invalid-related", "A related communities block must have between 1 and 20 communities.")
+		}
+		var names []string
+		for _, rc := range b.Related {
+			if rc == nil {
+				continue
+			}
+			comm, err := GetCommunityByName(ctx, b.db, strings.TrimSpace(rc.Name), nil)
+			if err != nil {
+				return err
+			}
+			if comm.DeletedAt.Valid || comm.ID == b.CommunityID || slices.Contains(names, comm.Name) {
+				continue
+			}
+			names = append(names, comm.Name)
+		}
+		if len(names) == 0 {
+			return httperr.NewBadRequest("community-block/invalid-related", "A related communities block must have between 1 and 20 communities.")
+		}
+		b.Related = nil
+		for _, name := range names {
+			b.Related = append(b.Related, &RelatedCommunity{Name: name})
+		}
+	}
+	return nil
+}
+
+// columns returns the values of the body, links, and related columns of b.
+func (b *CommunityBlock) columns() (body, links, related any, err error) {
+	body = msql.NilIfEmptyString(b.Body)
+	if len(b.Links) > 0 {
+		data, err := json.Marshal(b.Links)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		links = string(data)
+	}
+	if len(b.Related) > 0 {
+		names := make([]struct {
+			Name string `json:"name"`
+		}, len(b.Related))
+		for i, rc := range b.Related {
+			names[i].Name = rc.Name
+		}
+		data, err := json.Marshal(names)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		related = string(data)
+	}
+	return
+}
+
+// AddBlock adds the block b (of which ID and the like are ignored) to c, after
+// the existing blocks.
+func (c *Community) AddBlock(ctx context.Context, b *CommunityBlock, mod uid.ID) (*CommunityBlock, error) {
+	if is, err := c.UserModOrAdmin(ctx, mod); err != nil {
+		return nil, err
+	} else if !is {
+		return nil, errNotMod
+	}
+
+	b.db, b.CommunityID = c.db, c.ID
+	if err := b.validate(ctx); err != nil {
+		return nil, err
+	}
+
+	var n, zIndex int
+	row := c.db.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(MAX(z_index), 0) FROM community_blocks WHERE community_id = ?", c.ID)
+	if err := row.Scan(&n, &zIndex); err != nil {
+		return nil, err
+	}
+	if n >= maxCommunityBlocks {
+		return nil, httperr.NewBadRequest("community-block/too-many", "Too many blocks.")
+	}
+
+	body, links, related, err := b.columns()
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.db.ExecContext(ctx, `INSERT INTO community_blocks (community_id, block_type, title, body, links, related, z_index, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, c.ID, b.Type, b.Title, body, links, related, zIndex+1, mod, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return GetCommunityBlock(ctx, c.db, c.ID, int(id))
+}
+
+// Update saves the type, title, contents, and ZIndex of b.
+func (b *CommunityBlock) Update(ctx context.Context, mod uid.ID) error {
+	if is, err := UserModOrAdmin(ctx, b.db, b.CommunityID, mod); err != nil {
+		return err
+	} else if !is {
+		return errNotMod
+	}
+	if err := b.validate(ctx); err != nil {
+		return err
+	}
+
+	body, links, related, err := b.columns()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = b.db.ExecContext(ctx, "UPDATE community_blocks SET block_type = ?, title = ?, body = ?, links = ?, related = ?, z_index = ?, edited_at = ? WHERE id = ?",
+		b.Type, b.Title, body, links, related, b.ZIndex, now, b.ID)
+	if err != nil {
+		return err
+	}
+	b.EditedAt = msql.NewNullTime(now)
+	return b.populateRelated(ctx)
+}
+
+// Delete deletes b.
+func (b *CommunityBlock) Delete(ctx context.Context, mod uid.ID) error {
+	if is, err := UserModOrAdmin(ctx, b.db, b.CommunityID, mod); err != nil {
+		return err
+	} else if !is {
+		return errNotMod
+	}
+	_, err := b.db.ExecContext(ctx, "DELETE FROM community_blocks WHERE id = ?", b.ID)
+	return err
+}
@@ -0,0 +1,284 @@
+package core
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// maxCommunityListImportRows is the most rows a CSV file imported into a
+// community list may have.
+const maxCommunityListImportRows = 5000
+
+// CommunityList is a list of the users of a community that mods can export
+// as CSV (and, except for the members, import).
+type CommunityList string
+
+// These are all the valid CommunityLists.
+const (
+	CommunityListMembers            = CommunityList("members")
+	CommunityListBanned             = CommunityList("banned")
+	CommunityListApprovedSubmitters = CommunityList("approved_submitters")
+)
+
+var errInvalidCommunityList = httperr.NewBadRequest("invalid-community-list", "List must be either members, banned, or approved_submitters.")
+
+// Valid reports whether l is a valid CommunityList.
+func (l CommunityList) Valid() bool {
+	switch l {
+	case CommunityListMembers, CommunityListBanned, CommunityListApprovedSubmitters:
+		return true
+	}
+	return false
+}
+
+// exportCommunityLists are the columns of the CSV exports of the community
+// lists, and the queries (given the ID of the community) of their rows.
+var exportCommunityLists = map[CommunityList]struct {
+	columns []string
+	query   string
+}{
+	CommunityListMembers: {
+		columns: []string{"username", "is_mod", "joined_at"},
+		query: `SELECT users.username, community_members.is_mod, community_members.created_at, NULL, NULL
+			FROM community_members
+			INNER JOIN users ON users.id = community_members.user_id
+			WHERE community_members.community_id = ? AND users.deleted_at IS NULL
+			ORDER BY community_members.created_at`,
+	},
+	CommunityListBanned: {
+		columns: []string{"username", "banned_at", "expires", "banned_by"},
+		query: `SELECT users.username, FALSE, community_banned.created_at, community_banned.expires, mods.username
+			FROM community_banned
+			INNER JOIN users ON users.id = community_banned.user_id
+			INNER JOIN users AS mods ON mods.id = community_banned.banned_by
+			WHERE community_banned.community_id = ? AND users.deleted_at IS NULL
+			ORDER BY community_banned.created_at`,
+	},
+	CommunityListApprovedSubmitters: {
+		columns: []string{"username", "added_at", "added_by"},
+		query: `SELECT users.username, FALSE, community_approved_submitters.created_at, NULL, mods.username
+			FROM community_approved_submitters
+			INNER JOIN users ON users.id = community_approved_submitters.user_id
+			INNER JOIN users AS mods ON mods.id = community_approved_submitters.added_by
+			WHERE community_approved_submitters.community_id = ? AND users.deleted_at IS NULL
+			ORDER BY community_approved_submitters.created_at`,
+	},
+}
+
+// ExportList writes list of c to w as CSV, with a header row. Times are in
+// RFC 3339 format (in UTC).
+func (c *Community) ExportList(ctx context.Context, list CommunityList, w io.Writer) error {
+	export, ok := exportCommunityLists[list]
+	if !ok {
+		return errInvalidCommunityList
+	}
+	rows, err := c.db.QueryContext(ctx, export.query, c.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(export.columns); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var (
+			username  string
+			isMod     bool
+			createdAt time.Time
+			expires   msql.NullTime
+			by        msql.NullString
+		)
+		if err := rows.Scan(&username, &isMod, &createdAt, &expires, &by); err != nil {
+			return err
+		}
+		var record []string
+		switch list {
+		case CommunityListMembers:
+			record = []string{username, strconv.FormatBool(isMod), csvTime(createdAt)}
+		case CommunityListBanned:
+			record = []string{username, csvTime(createdAt), csvNullTime(expires), by.String}
+		case CommunityListApprovedSubmitters:
+			record = []string{username, csvTime(createdAt), by.String}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// CommunityListImport is the result of importing a CSV file into a community
+// list.
+type CommunityListImport struct {
+	Added   int `json:"added"`   // The number of users added (or who would be, on a dry run).
+	Skipped int `json:"skipped"` // The number of users in the list already.
+
+	// The rows that could not be imported.
+	Errors []*CommunityListImportError `json:"errors"`
+}
+
+// CommunityListImportError is a row of an imported CSV file that could not be
+// imported.
+type CommunityListImportError struct {
+	Line     int    `json:"line"` // In the file, counting from 1 (the header).
+	Username string `json:"username"`
+	Message  string `json:"message"`
+}
+
+// ImportList adds the users in the CSV file read from r, on behalf of mod, to
+// list of c, which is either the banned users or the approved submitters. The
+// file has a header row, with a username column, and, for bans, an optional
+// expires column (of times in RFC 3339 format; empty for permanent bans), as
+// in the files of ExportList. Rows that are not valid are reported, and the
+// rest are imported anyway, unless dryRun is true, in which case nothing is.
+func (c *Community) ImportList(ctx context.Context, mod uid.ID, list CommunityList, r io.Reader, dryRun bool) (*CommunityListImport, error) {
+	if list != CommunityListBanned && list != CommunityListApprovedSubmitters {
+		return nil, httperr.NewBadRequest("invalid-community-list", "List must be either banned or approved_submitters.")
+	}
+	if is, err := c.UserModOrAdmin(ctx, mod); err != nil {
+		return nil, err
+	} else if !is {
+		return nil, errNotMod
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, httperr.NewBadRequest("invalid-csv", "The file is not a valid CSV file with a header row.")
+	}
+	usernameCol, expiresCol := -1, -1
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "username":
+			usernameCol = i
+		case "expires":
+			expiresCol = i
+		}
+	}
+	if usernameCol == -1 {
+		return nil, httperr.NewBadRequest("invalid-csv", "The file has no username column.")
+	}
+
+	result := &CommunityListImport{Errors: []*CommunityListImportError{}}
+	for n := 1; ; n++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, httperr.NewBadRequest("invalid-csv", fmt.Sprintf("The file is not a valid CSV file (line %d).", parseErr.Line))
+			}
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if n > maxCommunityListImportRows {
+			return nil, httperr.NewBadRequest("invalid-csv", fmt.Sprintf("The file has more than %d rows.", maxCommunityListImportRows))
+		}
+
+		var username, expiresText string
+		if usernameCol < len(record) {
+			username = strings.TrimSpace(strings.TrimPrefix(record[usernameCol], "@"))
+		}
+		if expiresCol != -1 && expiresCol < len(record) {
+			expiresText = strings.TrimSpace(record[expiresCol])
+		}
+		if username == "" {
+			continue // Blank lines, say.
+		}
+		added, err := c.importListRow(ctx, mod, list, username, expiresText, dryRun)
+		if err != nil {
+			var httpErr *httperr.Error
+			if !errors.As(err, &httpErr) {
+				return nil, err
+			}
+			result.Errors = append(result.Errors, &CommunityListImportError{Line: line, Username: username, Message: httpErr.Message})
+			continue
+		}
+		if added {
+			result.Added++
+		} else {
+			result.Skipped++
+		}
+	}
+	return result, nil
+}
+
+// importListRow adds the user username to list of c, on behalf of mod, unless
+// dryRun is true. It reports whether the user was added (or would be), as
+// opposed to being in the list already. The errors of invalid rows are
+// httperr.Errors.
+func (c *Community) importListRow(ctx context.Context, mod uid.ID, list CommunityList, username, expiresText string, dryRun bool) (bool, error) {
+	user, err := GetUserByUsername(ctx, c.db, username, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if list == CommunityListApprovedSubmitters {
+		if is, err := IsApprovedSubmitter(ctx, c.db, c.ID, user.ID); err != nil || is {
+			return false, err
+		}
+		if dryRun {
+			return true, nil
+		}
+		if err := c.AddApprovedSubmitter(ctx, mod, user.ID); err != nil {
+			if errors.Is(err, errAlreadyApprovedSubmitter) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+
+	var expires *time.Time
+	if expiresText != "" {
+		t, err := time.Parse(time.RFC3339, expiresText)
+		if err != nil {
+			return false, httperr.NewBadRequest("invalid-expires", "Expires must be an RFC 3339 time.")
+		}
+		if t.Before(time.Now()) {
+			return false, httperr.NewBadRequest("invalid-expires", "Expires is in the past.")
+		}
+		expires = &t
+	}
+	if user.Admin {
+		return false, httperr.NewForbidden("not_admin_nor_mod", "Admins cannot be banned.")
+	}
+	if isMod, err := c.UserMod(ctx, user.ID); err != nil {
+		return false, err
+	} else if isMod {
+		return false, httperr.NewForbidden("not_admin_nor_mod", "Mods cannot be banned.")
+	}
+	if banned, err := c.UserBanned(ctx, user.ID); err != nil || banned {
+		return false, err
+	}
+	if dryRun {
+		return true, nil
+	}
+	if err := c.BanUser(ctx, mod, user.ID, expires); err != nil {
+		if msql.IsErrDuplicateErr(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
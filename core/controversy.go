@@ -0,0 +1,31 @@
+package core
+
+// The least number of votes each way, and the least balance (see
+// Controversy), of a controversial post or comment.
+const (
+	controversialMinVotes = 3
+	controversialBalance  = 0.5
+)
+
+// Controversy is the controversy score of a post or comment with upvotes
+// upvotes and downvotes downvotes: the number of votes times how evenly they
+// are split (the balance, which is the fewer of upvotes and downvotes over
+// the more), times 1000. It's zero if all the votes are one way.
+//
+// The migration that added the controversy columns computes it in SQL, with
+// the same integer arithmetic, which it's to be kept in step with.
+func Controversy(upvotes, downvotes int) int {
+	if upvotes <= 0 || downvotes <= 0 {
+		return 0
+	}
+	less, more := min(upvotes, downvotes), max(upvotes, downvotes)
+	return (upvotes + downvotes) * 1000 * less / more
+}
+
+// Controversial reports whether a post or comment with upvotes upvotes and
+// downvotes downvotes is controversial: whether it has enough votes both ways,
+// and they are split evenly enough.
+func Controversial(upvotes, downvotes int) bool {
+	less, more := min(upvotes, downvotes), max(upvotes, downvotes)
+	return less >= controversialMinVotes && float64(less) >= float64(more)*controversialBalance
+}
@@ -0,0 +1,35 @@
+package core
+
+import "testing"
+
+func TestControversy(t *testing.T) {
+	tests := []struct {
+		upvotes, downvotes int
+		want               int
+		controversial      bool
+	}{
+		{0, 0, 0, false},
+		{10, 0, 0, false},
+		{0, 10, 0, false},
+		{1, 1, 2000, false},
+		{5, 5, 10000, true},
+		{10, 5, 7500, true},
+		{5, 10, 7500, true},
+		{30, 3, 3300, false},
+		{2, 2, 4000, false},
+	}
+	for _, test := range tests {
+		if got := Controversy(test.upvotes, test.downvotes); got != test.want {
+			t.Errorf("Controversy(%d, %d) = %d, want %d", test.upvotes, test.downvotes, got, test.want)
+		}
+		if got := Controversial(test.upvotes, test.downvotes); got != test.controversial {
+			t.Errorf("Controversial(%d, %d) = %v, want %v", test.upvotes, test.downvotes, got, test.controversial)
+		}
+	}
+	if Controversy(100, 60) <= Controversy(10, 6) {
+		t.Error("more votes, evenly split as much, must be more controversial")
+	}
+	if Controversy(60, 50) <= Controversy(100, 10) {
+		t.Error("fewer, but more evenly split, votes must be more controversial")
+	}
+}
@@ -0,0 +1,386 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/ldap"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// DirectorySourceLDAP is the source (the provider of the external identities)
+// of the users synced from an LDAP directory.
+const DirectorySourceLDAP = "ldap"
+
+// A DirectoryUser is a user of a directory (or of an identity provider).
+type DirectoryUser struct {
+	ID       string // The ID of the user in the directory (ex: their DN), which never changes.
+	Username string
+	Email    string
+
+	// The groups of the user: their DNs and, for groups that are DNs, their
+	// common names as well.
+	Groups []string
+}
+
+// An LDAPDirectory is an LDAP directory users are synced from.
+type LDAPDirectory struct {
+	URL          string
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	UserFilter   string // Matches the users.
+
+	// The attributes of the users with their usernames, email addresses, and
+	// groups (ex: uid, mail, and memberOf).
+	UsernameAttribute string
+	EmailAttribute    string
+	GroupAttribute    string
+}
+
+// FetchUsers returns the users of d.
+func (d *LDAPDirectory) FetchUsers(ctx context.Context) ([]*DirectoryUser, error) {
+	conn, err := ldap.Dial(ctx, d.URL, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := conn.Bind(d.BindDN, d.BindPassword); err != nil {
+		return nil, err
+	}
+	entries, err := conn.Search(d.BaseDN, d.UserFilter, []string{d.UsernameAttribute, d.EmailAttribute, d.GroupAttribute})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*DirectoryUser, 0, len(entries))
+	for _, e := range entries {
+		u := &DirectoryUser{
+			ID:       strings.ToLower(e.DN),
+			Username: e.Get(d.UsernameAttribute),
+			Email:    e.Get(d.EmailAttribute),
+		}
+		for _, g := range e.GetAll(d.GroupAttribute) {
+			u.Groups = append(u.Groups, g)
+			if rdn, _, _ := strings.Cut(g, ","); strings.HasPrefix(strings.ToLower(rdn), "cn=") {
+				u.Groups = append(u.Groups, rdn[3:])
+			}
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+// A DirectorySync syncs the users of a directory to the site.
+type DirectorySync struct {
+	Source string // Ex: DirectorySourceLDAP.
+
+	// The names of the communities the members of the groups (the keys,
+	// matched case insensitively) are made members of.
+	GroupCommunities map[string]string
+
+	// If Deactivate is true, the users that are removed from the directory are
+	// deactivated (banned) until they're back.
+	Deactivate bool
+}
+
+// A DirectorySyncRun is a run of (*DirectorySync).Sync.
+type DirectorySyncRun struct {
+	ID         int       `json:"id"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+
+	UsersCreated       int `json:"usersCreated"`
+	UsersDeactivated   int `json:"usersDeactivated"`
+	UsersReactivated   int `json:"usersReactivated"`
+	MembershipsAdded   int `json:"membershipsAdded"`
+	MembershipsRemoved int `json:"membershipsRemoved"`
+
+	Error msql.NullString `json:"error"`
+}
+
+func (r *DirectorySyncRun) changed() bool {
+	return r.UsersCreated+r.UsersDeactivated+r.UsersReactivated+r.MembershipsAdded+r.MembershipsRemoved > 0
+}
+
+// Sync syncs users (all the users of the directory) to the site: the users
+// who are new to the site are created (or, if there's already a user with
+// their confirmed email address, linked to that user), and the community
+// memberships of all of them are synced with their groups. Each run that
+// changes anything (or fails) is recorded, and runs older than 90 days are
+// pruned. It returns the users that were deactivated, who are to be logged
+// out.
+func (s *DirectorySync) Sync(ctx context.Context, db *sql.DB, users []*DirectoryUser) (*DirectorySyncRun, []*User, error) {
+	run := &DirectorySyncRun{StartedAt: time.Now()}
+	deactivated, err := s.sync(ctx, db, users, run)
+	run.FinishedAt = time.Now()
+	if err != nil {
+		run.Error = msql.NewNullString(err.Error())
+	}
+
+	if run.changed() || err != nil {
+		res, rerr := db.ExecContext(ctx, `INSERT INTO directory_sync_runs (started_at, finished_at, users_created, users_deactivated,
+			users_reactivated, memberships_added, memberships_removed, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			run.StartedAt, run.FinishedAt, run.UsersCreated, run.UsersDeactivated, run.UsersReactivated, run.MembershipsAdded, run.MembershipsRemoved, run.Error)
+		if rerr != nil {
+			return run, deactivated, rerr
+		}
+		id, rerr := res.LastInsertId()
+		if rerr != nil {
+			return run, deactivated, rerr
+		}
+		run.ID = int(id)
+	}
+	if _, rerr := db.ExecContext(ctx, "DELETE FROM directory_sync_runs WHERE started_at < ?", time.Now().AddDate(0, 0, -90)); rerr != nil {
+		return run, deactivated, rerr
+	}
+	return run, deactivated, err
+}
+
+func (s *DirectorySync) sync(ctx context.Context, db *sql.DB, users []*DirectoryUser, run *DirectorySyncRun) ([]*User, error) {
+	if len(users) == 0 {
+		// Most likely a misconfigured filter, rather than everyone leaving.
+		return nil, errors.New("the directory has no users")
+	}
+
+	inDirectory := make(map[string]bool, len(users))
+	for _, du := range users {
+		inDirectory[du.ID] = true
+		user, err := s.syncUser(ctx, db, du, run)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			logger.WarnContext(ctx, "Failed to sync directory user", "source", s.Source, "id", du.ID, "err", err)
+			continue
+		}
+		added, removed, err := SyncUserGroups(ctx, db, user, s.Source, du.Groups, s.GroupCommunities)
+		run.MembershipsAdded += added
+		run.MembershipsRemoved += removed
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !s.Deactivate {
+		return nil, nil
+	}
+	rows, err := db.QueryContext(ctx, "SELECT id, user_id, subject FROM external_identities WHERE provider = ? AND deactivated_at IS NULL", s.Source)
+	if err != nil {
+		return nil, err
+	}
+	type identity struct {
+		id      int
+		user    uid.ID
+		subject string
+	}
+	var removed []identity
+	for rows.Next() {
+		var i identity
+		if err := rows.Scan(&i.id, &i.user, &i.subject); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !inDirectory[i.subject] {
+			removed = append(removed, i)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var deactivated []*User
+	for _, i := range removed {
+		user, err := GetUser(ctx, db, i.user, nil)
+		if err != nil {
+			if httperr.IsNotFound(err) {
+				continue // Deleted.
+			}
+			return nil, err
+		}
+		if user.Banned {
+			// Banned by an admin, who is the one to unban them.
+			continue
+		}
+		if err := user.Ban(ctx); err != nil {
+			return nil, err
+		}
+		if _, err := db.ExecContext(ctx, "UPDATE external_identities SET deactivated_at = ? WHERE id = ?", time.Now(), i.id); err != nil {
+			return nil, err
+		}
+		run.UsersDeactivated++
+		deactivated = append(deactivated, user)
+	}
+	return deactivated, nil
+}
+
+// syncUser returns the user of du, creating (or linking) it if it's new, and
+// reactivating it if it was deactivated.
+func (s *DirectorySync) syncUser(ctx context.Context, db *sql.DB, du *DirectoryUser, run *DirectorySyncRun) (uid.ID, error) {
+	var (
+		identityID  int
+		user        uid.ID
+		deactivated msql.NullTime
+	)
+	err := db.QueryRowContext(ctx, "SELECT id, user_id, deactivated_at FROM external_identities WHERE provider = ? AND subject = ?",
+		s.Source, du.ID).Scan(&identityID, &user, &deactivated)
+	if err == nil {
+		if deactivated.Valid {
+			if _, err := db.ExecContext(ctx, "UPDATE users SET banned_at = NULL WHERE id = ?", user); err != nil {
+				return user, err
+			}
+			if _, err := db.ExecContext(ctx, "UPDATE external_identities SET deactivated_at = NULL WHERE id = ?", identityID); err != nil {
+				return user, err
+			}
+			run.UsersReactivated++
+		}
+		return user, nil
+	}
+	if err != sql.ErrNoRows {
+		return user, err
+	}
+
+	if du.Email != "" {
+		existing, err := GetUserByEmail(ctx, db, du.Email, nil)
+		if err == nil {
+			if !existing.EmailConfirmedAt.Valid {
+				return user, errors.New("a user with the (unconfirmed) email address exists")
+			}
+			_, err := LinkExternalIdentity(ctx, db, existing.ID, s.Source, du.ID, du.Email)
+			return existing.ID, err
+		}
+		if !httperr.IsNotFound(err) {
+			return user, err
+		}
+	}
+	created, err := RegisterExternalUser(ctx, db, s.Source, du.ID, du.Username, du.Email, du.Email != "")
+	if err != nil {
+		return user, err
+	}
+	run.UsersCreated++
+	return created.ID, nil
+}
+
+// SyncUserGroups makes user a member of the communities of groups (as per
+// groupCommunities, by which the names of communities are keyed by groups),
+// and removes them from the communities they were made members of by a
+// previous sync (from source) whose groups they're no longer in. It returns
+// the number of memberships added and removed.
+func SyncUserGroups(ctx context.Context, db *sql.DB, user uid.ID, source string, groups []string, groupCommunities map[string]string) (added, removed int, err error) {
+	if len(groupCommunities) == 0 {
+		return 0, 0, nil
+	}
+	byGroup := make(map[string]string, len(groupCommunities))
+	for g, c := range groupCommunities {
+		byGroup[strings.ToLower(g)] = strings.ToLower(c)
+	}
+	wanted := make(map[string]bool)
+	for _, g := range groups {
+		if c, ok := byGroup[strings.ToLower(g)]; ok {
+			wanted[c] = true
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT communities.name_lc FROM directory_memberships
+		INNER JOIN communities ON communities.id = directory_memberships.community_id
+		WHERE directory_memberships.user_id = ? AND directory_memberships.source = ?`, user, source)
+	if err != nil {
+		return 0, 0, err
+	}
+	managed := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		managed[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	isMember := func(c *Community) (bool, error) {
+		var n int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM community_members WHERE community_id = ? AND user_id = ?", c.ID, user).Scan(&n)
+		return n > 0, err
+	}
+
+	for name := range wanted {
+		if managed[name] {
+			continue
+		}
+		c, err := GetCommunityByName(ctx, db, name, nil)
+		if err != nil {
+			if httperr.IsNotFound(err) {
+				logger.WarnContext(ctx, "Community of a directory group not found", "community", name)
+				continue
+			}
+			return added, removed, err
+		}
+		// Memberships the user made themselves are left alone.
+		if member, err := isMember(c); err != nil {
+			return added, removed, err
+		} else if member {
+			continue
+		}
+		if err := c.Join(ctx, user); err != nil {
+			return added, removed, err
+		}
+		if _, err := db.ExecContext(ctx, "INSERT INTO directory_memberships (user_id, community_id, source) VALUES (?, ?, ?)", user, c.ID, source); err != nil {
+			return added, removed, err
+		}
+		added++
+	}
+
+	for name := range managed {
+		if wanted[name] {
+			continue
+		}
+		c, err := GetCommunityByName(ctx, db, name, nil)
+		if err != nil {
+			return added, removed, err
+		}
+		if member, err := isMember(c); err != nil {
+			return added, removed, err
+		} else if member {
+			if err := c.Leave(ctx, user); err != nil {
+				return added, removed, err
+			}
+		}
+		if _, err := db.ExecContext(ctx, "DELETE FROM directory_memberships WHERE user_id = ? AND community_id = ?", user, c.ID); err != nil {
+			return added, removed, err
+		}
+		removed++
+	}
+	return added, removed, nil
+}
+
+// GetDirectorySyncRuns returns the last n runs of the directory sync (that
+// changed anything or failed), the latest first.
+func GetDirectorySyncRuns(ctx context.Context, db *sql.DB, n int) ([]*DirectorySyncRun, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, started_at, finished_at, users_created, users_deactivated, users_reactivated,
+		memberships_added, memberships_removed, error FROM directory_sync_runs ORDER BY started_at DESC LIMIT ?`, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []*DirectorySyncRun{}
+	for rows.Next() {
+		r := &DirectorySyncRun{}
+		if err := rows.Scan(&r.ID, &r.StartedAt, &r.FinishedAt, &r.UsersCreated, &r.UsersDeactivated, &r.UsersReactivated,
+			&r.MembershipsAdded, &r.MembershipsRemoved, &r.Error); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
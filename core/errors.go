@@ -10,6 +10,18 @@ var (
 	// ErrWrongPassword is returned by MatchLoginCredentials if username and password
 	// do not match.
 	ErrWrongPassword = &httperr.Error{HTTPStatus: http.StatusUnauthorized, Code: "wrong-password", Message: "Username and password do not match."}
+
+	// ErrCommentFlood is the error for comments refused as part of a flood of
+	// near-identical comments (see CheckCommentFlood).
+	ErrCommentFlood = &httperr.Error{HTTPStatus: http.StatusTooManyRequests, Code: "comment/flood", Message: "You are posting the same comment too often. Try again later."}
+
+	// ErrEditConflict is returned on saving a post or a comment that was
+	// edited since it was loaded (see Post.Version).
+	ErrEditConflict = &httperr.Error{HTTPStatus: http.StatusConflict, Code: "edit_conflict", Message: "It was edited elsewhere since you loaded it. Reload and try again."}
+
+	// ErrAccountNotLinked is the error for switching to, or unlinking, an
+	// account that is not linked to the user (see AccountsLinked).
+	ErrAccountNotLinked = httperr.NewNotFound("account-not-linked", "Account is not linked.")
 )
 
 var (
@@ -17,19 +29,40 @@ var (
 	errNotMod    = httperr.NewForbidden("not_mod", "You are not a moderator.")
 	errNotAdmin  = httperr.NewForbidden("not_admin", "You are not an admin.")
 
+	errSitemapNotFound = httperr.NewNotFound("sitemap-not-found", "Sitemap not found.")
+
 	errImageNotFound = httperr.NewNotFound("image-not-found", "Image not found.")
+	errImageRejected = httperr.NewBadRequest("image/rejected", "Image is not allowed.")
+	errInvalidCrop   = httperr.NewBadRequest("image/invalid-crop", "Crop rectangle is not within the image.")
 
 	errCommunityNotFound = httperr.NewNotFound("community/not-found", "Community not found.")
 
-	errUserNotFound            = httperr.NewNotFound("user_not_found", "User not found.")
-	errUserBannedFromCommunity = httperr.NewForbidden("banned-from-community", "User is banned from the community.")
+	errUserNotFound             = httperr.NewNotFound("user_not_found", "User not found.")
+	errProfileLoggedInOnly      = &httperr.Error{HTTPStatus: http.StatusUnauthorized, Code: "profile/logged-in-only", Message: "Log in to see this profile."}
+	errUserBannedFromCommunity  = httperr.NewForbidden("banned-from-community", "User is banned from the community.")
+	errAlreadyApprovedSubmitter = &httperr.Error{HTTPStatus: http.StatusConflict, Code: "already-approved-submitter", Message: "User is already an approved submitter."}
+	errNotApprovedSubmitter     = httperr.NewNotFound("not-approved-submitter", "User is not an approved submitter.")
+
+	errAccountLinkedAlready  = &httperr.Error{HTTPStatus: http.StatusConflict, Code: "account-linked-already", Message: "Account is linked already."}
+	errTooManyLinkedAccounts = httperr.NewBadRequest("too-many-linked-accounts", "Too many linked accounts.")
 
-	errCommentDeleted  = httperr.NewForbidden("comment_deleted", "Comment(s) deleted.")
-	errCommentNotFound = httperr.NewNotFound("comment_not_found", "Comment(s) not found.")
+	errCommentDeleted          = httperr.NewForbidden("comment_deleted", "Comment(s) deleted.")
+	errCommentImagesNotAllowed = httperr.NewForbidden("comment/images-not-allowed", "Images are not allowed in the comments of this community.")
+	errCommentNotFound         = httperr.NewNotFound("comment_not_found", "Comment(s) not found.")
+	errHeldCommentNotFound     = httperr.NewNotFound("held-comment/not-found", "Held comment not found.")
 
 	errPostNotFound        = httperr.NewNotFound("post/not-found", "Post(s) not found.")
+	errHeldPostNotFound    = httperr.NewNotFound("held-post/not-found", "Held post not found.")
+	errPostDeleted         = httperr.NewForbidden("post-deleted", "Post is deleted.")
+	errPostAlreadyDeleted  = &httperr.Error{HTTPStatus: http.StatusConflict, Code: "already-deleted", Message: "Post is already deleted."}
 	errPostLocked          = httperr.NewForbidden("post-locked", "Post is locked.")
 	errPostTypeUnsupported = httperr.NewBadRequest("post-type/unsupported", "Unsupported post type.")
+	errImagePostsDisabled  = httperr.NewForbidden("image-posts/disabled", "Image posts are not allowed in this community.")
+	errGIFsNotAllowed      = httperr.NewForbidden("image/gifs-not-allowed", "GIFs are not allowed in this community.")
+
+	errModActionNotPending  = httperr.NewNotFound("mod-action-not-pending", "Mod action is not pending (it may have been executed already).")
+	errModActionNotUndoable = &httperr.Error{HTTPStatus: http.StatusConflict, Code: "mod-action-not-undoable", Message: "Mod action can no longer be undone."}
 
 	errInvalidUserGroup = httperr.NewBadRequest("user/invalid-group", "Invalid user-group.")
+	errAuthorNotInGroup = httperr.NewBadRequest("user/author-not-in-group", "The author is not of the user-group.")
 )
@@ -0,0 +1,250 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// maxExperimentVariants is the maximum number of variants of an experiment.
+const maxExperimentVariants = 10
+
+// Experiment is a feature flag, or an A/B test, shown to a percentage of the
+// logged in users. Users are bucketed by a hash of the name of the experiment
+// and their ID, and so a user is always in the same bucket of an experiment,
+// and raising the rollout of an experiment keeps the users already in it in
+// the same variants.
+type Experiment struct {
+	Name        string          `json:"name"`
+	Description msql.NullString `json:"description"`
+	Variants    []string        `json:"variants"` // The users in the experiment are split evenly among these.
+	Rollout     int             `json:"rollout"`  // The percentage (0 to 100) of users in the experiment.
+	Enabled     bool            `json:"enabled"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+// validExperimentName reports whether s is a valid name of an experiment or
+// of a variant.
+func validExperimentName(s string) bool {
+	if s == "" || len(s) > 64 {
+		return false
+	}
+	for _, r := range s {
+		if !((r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+var errExperimentNotFound = httperr.NewNotFound("experiment/not-found", "Experiment not found.")
+
+// Variant returns the variant of e that user is in, or an empty string if user
+// is not in the experiment (or if it's not enabled).
+func (e *Experiment) Variant(user uid.ID) string {
+	if !e.Enabled || len(e.Variants) == 0 {
+		return ""
+	}
+	// The rollout bucket and the variant are taken from separate bits of the
+	// hash, so that which users are in the experiment is independent of which
+	// variants they're in.
+	sum := sha256.Sum256([]byte(e.Name + "\x00" + user.String()))
+	if binary.BigEndian.Uint64(sum[0:8])%10000 >= uint64(e.Rollout)*100 {
+		return ""
+	}
+	return e.Variants[binary.BigEndian.Uint64(sum[8:16])%uint64(len(e.Variants))]
+}
+
+// GetExperiments returns all experiments, enabled or not.
+func GetExperiments(ctx context.Context, db *sql.DB) ([]*Experiment, error) {
+	return getExperiments(ctx, db, "SELECT name, description, variants, rollout, enabled, created_at, updated_at FROM experiments ORDER BY name")
+}
+
+// GetExperiment returns the experiment named name.
+func GetExperiment(ctx context.Context, db *sql.DB, name string) (*Experiment, error) {
+	exps, err := getExperiments(ctx, db, "SELECT name, description, variants, rollout, enabled, created_at, updated_at FROM experiments WHERE name = ?", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(exps) == 0 {
+		return nil, errExperimentNotFound
+	}
+	return exps[0], nil
+}
+
+func getExperiments(ctx context.Context, db *sql.DB, query string, args ...any) ([]*Experiment, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exps := []*Experiment{}
+	for rows.Next() {
+		e := &Experiment{}
+		var variants string
+		if err := rows.Scan(&e.Name, &e.Description, &variants, &e.Rollout, &e.Enabled, &e.CreatedAt, &e.UpdatedAt); err != nil {
+			return nil, err
+		}
+		e.Variants = strings.Split(variants, ",")
+		exps = append(exps, e)
+	}
+	return exps, rows.Err()
+}
+
+// SaveExperiment creates the experiment e, or, if an experiment of the same
+// name exists, updates it. If e has no variants, it's given a single one (a
+// feature flag), named "on".
+func SaveExperiment(ctx context.Context, db *sql.DB, e *Experiment) error {
+	e.Name = strings.ToLower(strings.TrimSpace(e.Name))
+	if !validExperimentName(e.Name) {
+		return httperr.NewBadRequest("experiment/invalid-name", "Experiment names must be 1 to 64 lowercase letters, digits, underscores, or hyphens.")
+	}
+	if len(e.Variants) == 0 {
+		e.Variants = []string{"on"}
+	}
+	if len(e.Variants) > maxExperimentVariants {
+		return httperr.NewBadRequest("experiment/too-many-variants", "An experiment may have at most 10 variants.")
+	}
+	seen := make(map[string]bool)
+	for _, v := range e.Variants {
+		if !validExperimentName(v) || seen[v] {
+			return httperr.NewBadRequest("experiment/invalid-variant", "Variant names must be unique, and of 1 to 64 lowercase letters, digits, underscores, or hyphens.")
+		}
+		seen[v] = true
+	}
+	if e.Rollout < 0 || e.Rollout > 100 {
+		return httperr.NewBadRequest("experiment/invalid-rollout", "Rollout must be between 0 and 100.")
+	}
+
+	now := time.Now()
+	e.UpdatedAt = now
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = now
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO experiments (name, description, variants, rollout, enabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?) `+msql.UpsertClause([]string{"name"}, "description", "variants", "rollout", "enabled", "updated_at"),
+		e.Name, e.Description, strings.Join(e.Variants, ","), e.Rollout, e.Enabled, e.CreatedAt, e.UpdatedAt)
+	return err
+}
+
+// DeleteExperiment deletes the experiment named name, along with its
+// exposures.
+func DeleteExperiment(ctx context.Context, db *sql.DB, name string) error {
+	res, err := db.ExecContext(ctx, "DELETE FROM experiments WHERE name = ?", name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errExperimentNotFound
+	}
+	return nil
+}
+
+// AssignExperiments returns the variants, by the names of the experiments, of
+// the enabled experiments that user is in, and records the exposures of user
+// to the experiments they had not been exposed to before.
+func AssignExperiments(ctx context.Context, db *sql.DB, user uid.ID) (map[string]string, error) {
+	exps, err := getExperiments(ctx, db, "SELECT name, description, variants, rollout, enabled, created_at, updated_at FROM experiments WHERE enabled = TRUE AND rollout > 0")
+	if err != nil {
+		return nil, err
+	}
+	assigned := make(map[string]string)
+	for _, e := range exps {
+		if v := e.Variant(user); v != "" {
+			assigned[e.Name] = v
+		}
+	}
+	if len(assigned) == 0 {
+		return assigned, nil
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT experiment FROM experiment_exposures WHERE user_id = ?", user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	exposed := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		exposed[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for name, variant := range assigned {
+		if exposed[name] {
+			continue
+		}
+		_, err := db.ExecContext(ctx, "INSERT INTO experiment_exposures (experiment, user_id, variant, created_at) VALUES (?, ?, ?, ?)", name, user, variant, now)
+		if err != nil && !msql.IsErrDuplicateErr(err) {
+			return nil, err
+		}
+	}
+	return assigned, nil
+}
+
+// ExperimentExposures are the number of users first exposed to each variant
+// of an experiment, in total and in each day.
+type ExperimentExposures struct {
+	Experiment *Experiment               `json:"experiment"`
+	Variants   map[string]int            `json:"variants"`
+	Days       []*ExperimentExposuresDay `json:"days"`
+}
+
+type ExperimentExposuresDay struct {
+	Day      time.Time      `json:"day"`
+	Variants map[string]int `json:"variants"`
+}
+
+// GetExperimentExposures returns the exposures of the experiment named name
+// since since. Variants that were removed from the experiment are included.
+func GetExperimentExposures(ctx context.Context, db *sql.DB, name string, since time.Time) (*ExperimentExposures, error) {
+	e, err := GetExperiment(ctx, db, name)
+	if err != nil {
+		return nil, err
+	}
+	res := &ExperimentExposures{Experiment: e, Variants: make(map[string]int), Days: []*ExperimentExposuresDay{}}
+	for _, v := range e.Variants {
+		res.Variants[v] = 0
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT variant, created_at FROM experiment_exposures
+		WHERE experiment = ? AND created_at >= ?
+		ORDER BY created_at`, name, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var variant string
+		var at time.Time
+		if err := rows.Scan(&variant, &at); err != nil {
+			return nil, err
+		}
+		res.Variants[variant]++
+		day := at.UTC().Truncate(24 * time.Hour)
+		n := len(res.Days)
+		if n == 0 || !res.Days[n-1].Day.Equal(day) {
+			res.Days = append(res.Days, &ExperimentExposuresDay{Day: day, Variants: make(map[string]int)})
+			n++
+		}
+		res.Days[n-1].Variants[variant]++
+	}
+	return res, rows.Err()
+}
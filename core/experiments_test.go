@@ -0,0 +1,44 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestExperimentVariant(t *testing.T) {
+	e := &Experiment{Name: "new_editor", Variants: []string{"control", "treatment"}, Rollout: 30, Enabled: true}
+	wider := *e
+	wider.Rollout = 60
+
+	const users = 10000
+	in, counts := 0, make(map[string]int)
+	for i := 0; i < users; i++ {
+		user := uid.New()
+		v := e.Variant(user)
+		if v != e.Variant(user) {
+			t.Fatalf("Variant of user %v is not stable", user)
+		}
+		if v == "" {
+			continue
+		}
+		in++
+		counts[v]++
+		if w := wider.Variant(user); w != v {
+			t.Fatalf("Raising the rollout moved user %v from %q to %q", user, v, w)
+		}
+	}
+	if in < users*25/100 || in > users*35/100 {
+		t.Errorf("%d of %d users are in an experiment with a rollout of 30%%", in, users)
+	}
+	for _, v := range e.Variants {
+		if counts[v] < in*40/100 {
+			t.Errorf("Only %d of %d users are in variant %q", counts[v], in, v)
+		}
+	}
+
+	e.Enabled = false
+	if v := e.Variant(uid.New()); v != "" {
+		t.Errorf("Disabled experiment returned variant %q", v)
+	}
+}
@@ -0,0 +1,642 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// An instance export is a newline delimited JSON file, of which every line is
+// a record of the form {"type": ..., "data": ...}. The first record is an
+// ExportHeader (of type "header"). Then come the users (ExportUser), the
+// communities (ExportCommunity), the community memberships (ExportMember), the
+// posts (ExportPost), and the comments (ExportComment), in that order, so that
+// every record comes after the records it refers to.
+//
+// IDs in an export are the IDs of the exporting instance. On import, every
+// record gets a new ID, and references are remapped.
+const (
+	exportFormat        = "discuit-export"
+	exportFormatVersion = 1
+)
+
+// The types of the records of an export.
+const (
+	exportTypeHeader    = "header"
+	exportTypeUser      = "user"
+	exportTypeCommunity = "community"
+	exportTypeMember    = "member"
+	exportTypePost      = "post"
+	exportTypeComment   = "comment"
+)
+
+type ExportHeader struct {
+	Format  string `json:"format"` // Always "discuit-export".
+	Version int    `json:"version"`
+
+	// Instance identifies the exporting instance. Importing two exports with
+	// the same Instance is idempotent.
+	Instance   string    `json:"instance"`
+	ExportedAt time.Time `json:"exportedAt"`
+}
+
+// ExportUser is a user, without the password and the settings.
+type ExportUser struct {
+	ID        uid.ID          `json:"id"`
+	Username  string          `json:"username"`
+	Email     msql.NullString `json:"email"`
+	About     msql.NullString `json:"about"`
+	Points    int             `json:"points"`
+	CreatedAt time.Time       `json:"createdAt"`
+	DeletedAt msql.NullTime   `json:"deletedAt"`
+}
+
+type ExportCommunity struct {
+	ID        uid.ID                `json:"id"`
+	Name      string                `json:"name"`
+	NSFW      bool                  `json:"nsfw"`
+	About     msql.NullString       `json:"about"`
+	CreatedBy uid.ID                `json:"createdBy"`
+	CreatedAt time.Time             `json:"createdAt"`
+	Mods      []uid.ID              `json:"mods"` // In order of position.
+	Rules     []ExportCommunityRule `json:"rules"`
+}
+
+type ExportCommunityRule struct {
+	Rule        string          `json:"rule"`
+	Description msql.NullString `json:"description"`
+	CreatedBy   uid.ID          `json:"createdBy"`
+}
+
+type ExportMember struct {
+	CommunityID uid.ID `json:"communityId"`
+	UserID      uid.ID `json:"userId"`
+}
+
+// ExportPost is a post. Images (of image posts and link thumbnails) aren't
+// exported, and image posts are imported as text posts.
+type ExportPost struct {
+	ID          uid.ID          `json:"id"`
+	Type        PostType        `json:"type"`
+	CommunityID uid.ID          `json:"communityId"`
+	UserID      uid.ID          `json:"userId"`
+	Title       string          `json:"title"`
+	Body        msql.NullString `json:"body"`
+	URL         string          `json:"url,omitempty"` // Of link posts.
+	Upvotes     int             `json:"upvotes"`
+	Downvotes   int             `json:"downvotes"`
+	Locked      bool            `json:"locked"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+type ExportComment struct {
+	ID        uid.ID          `json:"id"`
+	PostID    uid.ID          `json:"postId"`
+	ParentID  uid.NullID      `json:"parentId"`
+	UserID    uid.ID          `json:"userId"`
+	Body      msql.NullString `json:"body"` // Empty if deleted.
+	Upvotes   int             `json:"upvotes"`
+	Downvotes int             `json:"downvotes"`
+	CreatedAt time.Time       `json:"createdAt"`
+	DeletedAt msql.NullTime   `json:"deletedAt"`
+}
+
+type exportRecord struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+// ExportInstance writes an export of the whole site, which is identified by
+// instance, to w. Deleted communities and posts are left out, and so are
+// deleted comments (but not the comments that replied to them), which are
+// exported without their bodies.
+func ExportInstance(ctx context.Context, db *sql.DB, w io.Writer, instance string) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	write := func(typ string, data any) error {
+		return enc.Encode(exportRecord{Type: typ, Data: data})
+	}
+
+	if err := write(exportTypeHeader, &ExportHeader{
+		Format:     exportFormat,
+		Version:    exportFormatVersion,
+		Instance:   instance,
+		ExportedAt: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	if err := exportRows(ctx, db, "SELECT id, username, email, about_me, points, created_at, deleted_at FROM users ORDER BY created_at", func(rows *sql.Rows) error {
+		u := &ExportUser{}
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.About, &u.Points, &u.CreatedAt, &u.DeletedAt); err != nil {
+			return err
+		}
+		return write(exportTypeUser, u)
+	}); err != nil {
+		return err
+	}
+
+	var comms []*ExportCommunity
+	if err := exportRows(ctx, db, "SELECT id, name, nsfw, about, user_id, created_at FROM communities WHERE deleted_at IS NULL ORDER BY created_at", func(rows *sql.Rows) error {
+		c := &ExportCommunity{Mods: []uid.ID{}, Rules: []ExportCommunityRule{}}
+		comms = append(comms, c)
+		return rows.Scan(&c.ID, &c.Name, &c.NSFW, &c.About, &c.CreatedBy, &c.CreatedAt)
+	}); err != nil {
+		return err
+	}
+	for _, c := range comms {
+		if err := exportRows(ctx, db, "SELECT user_id FROM community_mods WHERE community_id = ? ORDER BY position", func(rows *sql.Rows) error {
+			var id uid.ID
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			c.Mods = append(c.Mods, id)
+			return nil
+		}, c.ID); err != nil {
+			return err
+		}
+		if err := exportRows(ctx, db, "SELECT rule, description, created_by FROM community_rules WHERE community_id = ? ORDER BY z_index, id", func(rows *sql.Rows) error {
+			var r ExportCommunityRule
+			if err := rows.Scan(&r.Rule, &r.Description, &r.CreatedBy); err != nil {
+				return err
+			}
+			c.Rules = append(c.Rules, r)
+			return nil
+		}, c.ID); err != nil {
+			return err
+		}
+		if err := write(exportTypeCommunity, c); err != nil {
+			return err
+		}
+	}
+
+	if err := exportRows(ctx, db, `
+		SELECT community_members.community_id, community_members.user_id FROM community_members
+		INNER JOIN communities ON communities.id = community_members.community_id
+		WHERE communities.deleted_at IS NULL ORDER BY community_members.id`, func(rows *sql.Rows) error {
+		m := &ExportMember{}
+		if err := rows.Scan(&m.CommunityID, &m.UserID); err != nil {
+			return err
+		}
+		return write(exportTypeMember, m)
+	}); err != nil {
+		return err
+	}
+
+	if err := exportRows(ctx, db, `
+		SELECT posts.id, posts.type, posts.community_id, posts.user_id, posts.title, posts.body, posts.link_info,
+			posts.upvotes, posts.downvotes, posts.locked, posts.created_at
+		FROM posts
+		INNER JOIN communities ON communities.id = posts.community_id
+		WHERE posts.deleted = false AND communities.deleted_at IS NULL ORDER BY posts.created_at`, func(rows *sql.Rows) error {
+		p := &ExportPost{}
+		var linkInfo []byte
+		if err := rows.Scan(&p.ID, &p.Type, &p.CommunityID, &p.UserID, &p.Title, &p.Body, &linkInfo,
+			&p.Upvotes, &p.Downvotes, &p.Locked, &p.CreatedAt); err != nil {
+			return err
+		}
+		if p.Type == PostTypeLink && linkInfo != nil {
+			var link postLink
+			if err := json.Unmarshal(linkInfo, &link); err != nil {
+				return err
+			}
+			p.URL = link.URL
+		}
+		return write(exportTypePost, p)
+	}); err != nil {
+		return err
+	}
+
+	// Ordered by depth, so that parents come before their replies.
+	if err := exportRows(ctx, db, `
+		SELECT comments.id, comments.post_id, comments.parent_id, comments.user_id, comments.body,
+			comments.upvotes, comments.downvotes, comments.created_at, comments.deleted_at
+		FROM comments
+		INNER JOIN posts ON posts.id = comments.post_id
+		INNER JOIN communities ON communities.id = posts.community_id
+		WHERE posts.deleted = false AND communities.deleted_at IS NULL ORDER BY comments.depth, comments.created_at`, func(rows *sql.Rows) error {
+		c := &ExportComment{}
+		if err := rows.Scan(&c.ID, &c.PostID, &c.ParentID, &c.UserID, &c.Body,
+			&c.Upvotes, &c.Downvotes, &c.CreatedAt, &c.DeletedAt); err != nil {
+			return err
+		}
+		if c.DeletedAt.Valid {
+			c.Body = msql.NullString{}
+		}
+		return write(exportTypeComment, c)
+	}); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// exportRows calls fn for every row of query.
+func exportRows(ctx context.Context, db *sql.DB, query string, fn func(*sql.Rows) error, args ...any) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// InstanceImportStats are the statistics of an import of an instance export.
+type InstanceImportStats struct {
+	Users       int `json:"users"`
+	Communities int `json:"communities"` // Includes existing communities that were merged into.
+	Members     int `json:"members"`     // Includes memberships that already existed.
+	Posts       int `json:"posts"`
+	Comments    int `json:"comments"`
+	Skipped     int `json:"skipped"` // Records that were already imported.
+	Failed      int `json:"failed"`  // Records that couldn't be imported (see the logs).
+}
+
+type instanceImport struct {
+	importMap
+	stats InstanceImportStats
+	comms map[uid.ID]*Community // by ID on this site
+}
+
+// ImportInstance imports an export written by ExportInstance, giving every
+// record a new ID. Importing is idempotent, and so an interrupted import can be
+// resumed by running it again.
+//
+// Imported users can't log in until their passwords are set (and admins have
+// to be made admins again). Users whose usernames are taken are given new
+// usernames. Communities whose names are taken are merged into the existing
+// communities.
+func ImportInstance(ctx context.Context, db *sql.DB, r io.Reader) (*InstanceImportStats, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 16<<20)
+
+	var imp *instanceImport
+	for line := 1; scanner.Scan(); line++ {
+		if err := ctx.Err(); err != nil {
+			return statsOf(imp), err
+		}
+		var rec struct {
+			Type string          `json:"type"`
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return statsOf(imp), fmt.Errorf("line %d: %w", line, err)
+		}
+		if imp == nil {
+			header := &ExportHeader{}
+			if rec.Type != exportTypeHeader {
+				return nil, errors.New("export has no header")
+			}
+			if err := json.Unmarshal(rec.Data, header); err != nil {
+				return nil, err
+			}
+			if header.Format != exportFormat || header.Version > exportFormatVersion {
+				return nil, fmt.Errorf("unsupported export format %s (version %d)", header.Format, header.Version)
+			}
+			if header.Instance == "" {
+				return nil, errors.New("export has no instance name")
+			}
+			imp = &instanceImport{
+				importMap: importMap{db: db, source: "export/" + header.Instance},
+				comms:     make(map[uid.ID]*Community),
+			}
+			continue
+		}
+		if err := imp.importRecord(ctx, rec.Type, rec.Data); err != nil {
+			return &imp.stats, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return statsOf(imp), err
+	}
+	if imp == nil {
+		return nil, errors.New("export is empty")
+	}
+	return &imp.stats, nil
+}
+
+func statsOf(imp *instanceImport) *InstanceImportStats {
+	if imp == nil {
+		return nil
+	}
+	return &imp.stats
+}
+
+func (imp *instanceImport) importRecord(ctx context.Context, typ string, data json.RawMessage) error {
+	var (
+		v  any
+		fn func(context.Context) error
+	)
+	switch typ {
+	case exportTypeUser:
+		u := &ExportUser{}
+		v, fn = u, func(ctx context.Context) error { return imp.importUser(ctx, u) }
+	case exportTypeCommunity:
+		c := &ExportCommunity{}
+		v, fn = c, func(ctx context.Context) error { return imp.importCommunity(ctx, c) }
+	case exportTypeMember:
+		m := &ExportMember{}
+		v, fn = m, func(ctx context.Context) error { return imp.importMember(ctx, m) }
+	case exportTypePost:
+		p := &ExportPost{}
+		v, fn = p, func(ctx context.Context) error { return imp.importPost(ctx, p) }
+	case exportTypeComment:
+		c := &ExportComment{}
+		v, fn = c, func(ctx context.Context) error { return imp.importComment(ctx, c) }
+	default:
+		return nil // from a newer version
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return err
+	}
+	err := fn(ctx)
+	if err != nil && !httperr.IsInternalServerError(err) {
+		imp.stats.Failed++
+		logger.WarnContext(ctx, "Failed to import record", "type", typ, "err", err)
+		return nil
+	}
+	return err
+}
+
+// errImportRefNotFound is returned when a record refers to one that wasn't
+// imported.
+var errImportRefNotFound = httperr.NewNotFound("import-ref-not-found", "Referenced record not imported.")
+
+// mapped returns the ID on this site of the record of kind with the ID id on
+// the exporting instance.
+func (imp *instanceImport) mapped(ctx context.Context, kind string, id uid.ID) (uid.ID, error) {
+	target, found, err := imp.lookup(ctx, kind, id.String())
+	if err != nil {
+		return target, err
+	}
+	if !found {
+		return target, errImportRefNotFound
+	}
+	return target, nil
+}
+
+func (imp *instanceImport) importUser(ctx context.Context, u *ExportUser) error {
+	id, exists, err := imp.reserve(ctx, importKindUser, u.ID.String(), "users")
+	if err != nil {
+		return err
+	}
+	if exists {
+		imp.stats.Skipped++
+		return nil
+	}
+
+	var n int
+	if err := imp.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE username_lc = ?", strings.ToLower(u.Username)).Scan(&n); err != nil {
+		return err
+	}
+	username := u.Username
+	if n > 0 {
+		username = placeholderUsername(u.Username, "user")
+	}
+	email := u.Email
+	if email.Valid {
+		if err := imp.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE email = ?", email.String).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			email = msql.NullString{} // so as not to have two users with the same email
+		}
+	}
+
+	if err := msql.Transact(ctx, imp.db, func(tx *sql.Tx) error {
+		if err := insertPlaceholderUser(ctx, tx, id, username); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "UPDATE users SET email = ?, about_me = ?, points = ?, created_at = ?, deleted_at = ? WHERE id = ?",
+			email, u.About, u.Points, u.CreatedAt, u.DeletedAt, id)
+		return err
+	}); err != nil {
+		return err
+	}
+	imp.stats.Users++
+	return nil
+}
+
+func (imp *instanceImport) community(ctx context.Context, id uid.ID) (*Community, error) {
+	if c := imp.comms[id]; c != nil {
+		return c, nil
+	}
+	c, err := GetCommunityByID(ctx, imp.db, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	imp.comms[id] = c
+	return c, nil
+}
+
+func (imp *instanceImport) importCommunity(ctx context.Context, c *ExportCommunity) error {
+	if _, found, err := imp.lookup(ctx, importKindCommunity, c.ID.String()); err != nil {
+		return err
+	} else if found {
+		imp.stats.Skipped++
+		return nil
+	}
+	creator, err := imp.mapped(ctx, importKindUser, c.CreatedBy)
+	if err != nil {
+		return err
+	}
+
+	existing, err := GetCommunityByName(ctx, imp.db, c.Name, nil)
+	if err != nil && err != errCommunityNotFound {
+		return err
+	}
+	var comm *Community
+	if existing != nil {
+		// Merge into the existing community (without touching its mods and
+		// rules).
+		comm = existing
+	} else {
+		id := uid.New()
+		if err := msql.Transact(ctx, imp.db, func(tx *sql.Tx) error {
+			query, args := msql.BuildInsertQuery("communities", []msql.ColumnValue{
+				{Name: "id", Value: id},
+				{Name: "name", Value: c.Name},
+				{Name: "name_lc", Value: strings.ToLower(c.Name)},
+				{Name: "user_id", Value: creator},
+				{Name: "nsfw", Value: c.NSFW},
+				{Name: "about", Value: c.About},
+				{Name: "created_at", Value: c.CreatedAt},
+			})
+			if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+				return err
+			}
+			for i, rule := range c.Rules {
+				by, err := imp.mapped(ctx, importKindUser, rule.CreatedBy)
+				if err != nil {
+					by = creator
+				}
+				if _, err := tx.ExecContext(ctx, "INSERT INTO community_rules (rule, description, community_id, created_by, z_index) VALUES (?, ?, ?, ?, ?)",
+					rule.Rule, rule.Description, id, by, i); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if comm, err = GetCommunityByID(ctx, imp.db, id, nil); err != nil {
+			return err
+		}
+		for _, mod := range c.Mods {
+			user, err := imp.mapped(ctx, importKindUser, mod)
+			if err != nil {
+				continue
+			}
+			if err := makeUserMod(ctx, imp.db, comm, user, true, ""); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := imp.record(ctx, importKindCommunity, c.ID.String(), comm.ID); err != nil {
+		return err
+	}
+	imp.comms[comm.ID] = comm
+	imp.stats.Communities++
+	return nil
+}
+
+func (imp *instanceImport) importMember(ctx context.Context, m *ExportMember) error {
+	commID, err := imp.mapped(ctx, importKindCommunity, m.CommunityID)
+	if err != nil {
+		return err
+	}
+	user, err := imp.mapped(ctx, importKindUser, m.UserID)
+	if err != nil {
+		return err
+	}
+	comm, err := imp.community(ctx, commID)
+	if err != nil {
+		return err
+	}
+	if err := comm.Join(ctx, user); err != nil {
+		return err
+	}
+	imp.stats.Members++
+	return nil
+}
+
+func (imp *instanceImport) importPost(ctx context.Context, p *ExportPost) error {
+	id, exists, err := imp.reserve(ctx, importKindPost, p.ID.String(), "posts")
+	if err != nil {
+		return err
+	}
+	if exists {
+		imp.stats.Skipped++
+		return nil
+	}
+	community, err := imp.mapped(ctx, importKindCommunity, p.CommunityID)
+	if err != nil {
+		return err
+	}
+	author, err := imp.mapped(ctx, importKindUser, p.UserID)
+	if err != nil {
+		return err
+	}
+
+	opts := &createPostOpts{
+		postType:  PostTypeText,
+		author:    author,
+		community: community,
+		title:     p.Title,
+		body:      p.Body.String,
+		id:        id,
+		createdAt: p.CreatedAt,
+		upvotes:   p.Upvotes,
+		downvotes: p.Downvotes,
+	}
+	if p.Type == PostTypeLink && p.URL != "" {
+		if link, err := parsePostLink(p.URL); err == nil {
+			opts.postType = PostTypeLink
+			opts.link = *link
+		}
+	}
+	if _, err := createPost(ctx, imp.db, opts); err != nil {
+		return err
+	}
+	if p.Locked {
+		if _, err := imp.db.ExecContext(ctx, "UPDATE posts SET locked = ?, locked_at = ?, locked_by_group = ? WHERE id = ?",
+			true, time.Now(), UserGroupAdmins, id); err != nil {
+			return err
+		}
+	}
+	imp.stats.Posts++
+	return nil
+}
+
+func (imp *instanceImport) importComment(ctx context.Context, c *ExportComment) error {
+	id, exists, err := imp.reserve(ctx, importKindComment, c.ID.String(), "comments")
+	if err != nil {
+		return err
+	}
+	if exists {
+		imp.stats.Skipped++
+		return nil
+	}
+	postID, err := imp.mapped(ctx, importKindPost, c.PostID)
+	if err != nil {
+		return err
+	}
+	author, err := imp.mapped(ctx, importKindUser, c.UserID)
+	if err != nil {
+		return err
+	}
+	var parent *uid.ID
+	if c.ParentID.Valid {
+		id, err := imp.mapped(ctx, importKindComment, c.ParentID.ID)
+		if err != nil {
+			return err
+		}
+		parent = &id
+	}
+
+	post, err := GetPost(ctx, imp.db, &postID, "", nil, true)
+	if err != nil {
+		return err
+	}
+	user, err := GetUser(ctx, imp.db, author, nil)
+	if err != nil {
+		return err
+	}
+	comment, err := addComment(ctx, imp.db, post, user, parent, c.Body.String, &addCommentOpts{
+		id:              id,
+		createdAt:       c.CreatedAt,
+		upvotes:         c.Upvotes,
+		downvotes:       c.Downvotes,
+		noNotifications: true,
+		allowDeleted:    true,
+	})
+	if err != nil {
+		return err
+	}
+	if c.DeletedAt.Valid {
+		if err := comment.Delete(ctx, author, UserGroupNormal); err != nil {
+			return err
+		}
+		if _, err := imp.db.ExecContext(ctx, "UPDATE comments SET deleted_at = ? WHERE id = ?", c.DeletedAt, id); err != nil {
+			return err
+		}
+	}
+	imp.stats.Comments++
+	return nil
+}
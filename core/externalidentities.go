@@ -0,0 +1,218 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+var (
+	errExternalIdentityNotFound = httperr.NewNotFound("identity/not-found", "Linked account not found.")
+	errLastLoginMethod          = httperr.NewBadRequest("identity/last-login-method", "You would have no way left to log in.")
+)
+
+// An ExternalIdentity is an account at an external identity provider (such as
+// Google or GitHub), linked to a user, with which they log in.
+type ExternalIdentity struct {
+	ID         int             `json:"id"`
+	UserID     uid.ID          `json:"userId"`
+	Provider   string          `json:"provider"` // The name of the provider (ex: google).
+	Subject    string          `json:"-"`        // The ID of the account at the provider.
+	Email      msql.NullString `json:"email"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	LastUsedAt msql.NullTime   `json:"lastUsedAt"`
+}
+
+const selectExternalIdentityColumns = "SELECT id, user_id, provider, subject, email, created_at, last_used_at FROM external_identities "
+
+func scanExternalIdentities(rows *sql.Rows) ([]*ExternalIdentity, error) {
+	defer rows.Close()
+	identities := []*ExternalIdentity{}
+	for rows.Next() {
+		i := &ExternalIdentity{}
+		if err := rows.Scan(&i.ID, &i.UserID, &i.Provider, &i.Subject, &i.Email, &i.CreatedAt, &i.LastUsedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	return identities, rows.Err()
+}
+
+// GetExternalIdentities returns the external identities linked to user.
+func GetExternalIdentities(ctx context.Context, db *sql.DB, user uid.ID) ([]*ExternalIdentity, error) {
+	rows, err := db.QueryContext(ctx, selectExternalIdentityColumns+"WHERE user_id = ? ORDER BY id", user)
+	if err != nil {
+		return nil, err
+	}
+	return scanExternalIdentities(rows)
+}
+
+// GetExternalIdentity returns the identity, linked to any user, of the account
+// subject at provider.
+func GetExternalIdentity(ctx context.Context, db *sql.DB, provider, subject string) (*ExternalIdentity, error) {
+	rows, err := db.QueryContext(ctx, selectExternalIdentityColumns+"WHERE provider = ? AND subject = ?", provider, subject)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := scanExternalIdentities(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(identities) == 0 {
+		return nil, errExternalIdentityNotFound
+	}
+	return identities[0], nil
+}
+
+// LinkExternalIdentity links the account subject at provider (whose email
+// address is email) to user.
+func LinkExternalIdentity(ctx context.Context, db *sql.DB, user uid.ID, provider, subject, email string) (*ExternalIdentity, error) {
+	now := time.Now()
+	_, err := db.ExecContext(ctx, "INSERT INTO external_identities (user_id, provider, subject, email, created_at, last_used_at) VALUES (?, ?, ?, ?, ?, ?)",
+		user, provider, subject, msql.NilIfEmptyString(email), now, now)
+	if err != nil {
+		if msql.IsErrDuplicateErr(err) {
+			return nil, httperr.NewBadRequest("identity/already-linked", "The account is already linked to a user.")
+		}
+		return nil, err
+	}
+	return GetExternalIdentity(ctx, db, provider, subject)
+}
+
+// RecordUse records that i was just used to log in, and that the email
+// address of the account is now email.
+func (i *ExternalIdentity) RecordUse(ctx context.Context, db *sql.DB, email string) error {
+	now := time.Now()
+	_, err := db.ExecContext(ctx, "UPDATE external_identities SET email = ?, last_used_at = ? WHERE id = ?", msql.NilIfEmptyString(email), now, i.ID)
+	if err == nil {
+		i.Email = msql.NewNullString(msql.NilIfEmptyString(email))
+		i.LastUsedAt = msql.NewNullTime(now)
+	}
+	return err
+}
+
+// otherLoginMethods returns the number of the external identities (other than
+// the one with the ID except) and passkeys of user.
+func otherLoginMethods(ctx context.Context, db *sql.DB, user uid.ID, except int) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT
+		(SELECT COUNT(*) FROM external_identities WHERE user_id = ? AND id <> ?) +
+		(SELECT COUNT(*) FROM passkeys WHERE user_id = ?)`, user, except, user).Scan(&n)
+	return n, err
+}
+
+// UnlinkExternalIdentity unlinks the external identity with the ID id from
+// user, unless it's the only way left for them to log in.
+func UnlinkExternalIdentity(ctx context.Context, db *sql.DB, user uid.ID, id int) error {
+	var passwordDisabled bool
+	if err := db.QueryRowContext(ctx, "SELECT password_login_disabled FROM users WHERE id = ?", user).Scan(&passwordDisabled); err != nil {
+		return err
+	}
+	if passwordDisabled {
+		if n, err := otherLoginMethods(ctx, db, user, id); err != nil {
+			return err
+		} else if n == 0 {
+			return errLastLoginMethod
+		}
+	}
+
+	res, err := db.ExecContext(ctx, "DELETE FROM external_identities WHERE id = ? AND user_id = ?", id, user)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errExternalIdentityNotFound
+	}
+	return nil
+}
+
+// SetPasswordLogin enables or disables logging in to the account of u with
+// their password. Enabling it sets the password to password; disabling it
+// requires u to have a linked external identity or a passkey.
+func (u *User) SetPasswordLogin(ctx context.Context, enabled bool, password string) error {
+	if enabled {
+		if err := u.SetPassword(ctx, password); err != nil {
+			return err
+		}
+	} else {
+		if n, err := otherLoginMethods(ctx, u.db, u.ID, 0); err != nil {
+			return err
+		} else if n == 0 {
+			return errLastLoginMethod
+		}
+	}
+	if _, err := u.db.ExecContext(ctx, "UPDATE users SET password_login_disabled = ? WHERE id = ?", !enabled, u.ID); err != nil {
+		return err
+	}
+	u.PasswordLoginDisabled = !enabled
+	return nil
+}
+
+// availableUsername returns preferred, stripped of disallowed characters, if
+// it's a valid username that's not taken, or else a username derived from it
+// (or from fallback) that's unlikely to be taken.
+func availableUsername(ctx context.Context, db *sql.DB, preferred, fallback string) (string, error) {
+	var b strings.Builder
+	for _, r := range preferred {
+		if r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' {
+			b.WriteRune(r)
+		}
+	}
+	if name := b.String(); IsUsernameValid(name) == nil {
+		exists, _, err := usernameExists(ctx, db, name)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return name, nil
+		}
+	}
+	return placeholderUsername(preferred, fallback), nil
+}
+
+// RegisterExternalUser creates a new user for the account subject at
+// provider, and links the account to the user. The username of the user is
+// preferredUsername, if it's available. If emailVerified is true, the email
+// address of the user is email, and it's confirmed. The user has a random
+// password, and password login disabled.
+func RegisterExternalUser(ctx context.Context, db *sql.DB, provider, subject, preferredUsername, email string, emailVerified bool) (*User, error) {
+	username, err := availableUsername(ctx, db, preferredUsername, "user")
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	if !emailVerified {
+		email = ""
+	}
+	user, err := RegisterUser(ctx, db, username, email, base64.RawURLEncoding.EncodeToString(b))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := LinkExternalIdentity(ctx, db, user.ID, provider, subject, email); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var confirmedAt any
+	if email != "" {
+		confirmedAt = now
+		user.EmailConfirmedAt = msql.NewNullTime(now)
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE users SET password_login_disabled = TRUE, email_confirmed_at = ? WHERE id = ?", confirmedAt, user.ID); err != nil {
+		return nil, err
+	}
+	user.PasswordLoginDisabled = true
+	return user, nil
+}
@@ -0,0 +1,228 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/activitypub"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+const (
+	// The number of times the delivery of an activity is attempted before
+	// it's given up on.
+	maxDeliveryAttempts = 8
+
+	// The number of deliveries attempted per call of DeliverActivities.
+	deliveriesBatchSize = 50
+)
+
+// ActivityPubKey is the key pair of the ActivityPub actor of a community, with
+// which the requests to other servers are signed.
+type ActivityPubKey struct {
+	CommunityID uid.ID
+	PublicKey   string // PEM encoded.
+	PrivateKey  string // PEM encoded.
+}
+
+// GetActivityPubKey returns the key pair of the actor of community, creating
+// one if it doesn't exist yet.
+func GetActivityPubKey(ctx context.Context, db *sql.DB, community uid.ID) (*ActivityPubKey, error) {
+	key := &ActivityPubKey{CommunityID: community}
+	row := db.QueryRowContext(ctx, "SELECT public_key, private_key FROM activitypub_keys WHERE community_id = ?", community)
+	err := row.Scan(&key.PublicKey, &key.PrivateKey)
+	if err == nil {
+		return key, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	private, public, err := activitypub.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	// If another request created a key in the meantime, that key is kept.
+	query := "INSERT INTO activitypub_keys (community_id, public_key, private_key) VALUES (?, ?, ?) " + msql.UpsertClause([]string{"community_id"}, "community_id")
+	if _, err := db.ExecContext(ctx, query, community, public, private); err != nil {
+		return nil, err
+	}
+	row = db.QueryRowContext(ctx, "SELECT public_key, private_key FROM activitypub_keys WHERE community_id = ?", community)
+	if err := row.Scan(&key.PublicKey, &key.PrivateKey); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// AddActivityPubFollower adds the remote actor actor as a follower of
+// community. Activities of the community are delivered to the shared inbox of
+// the actor's server, if it has one, or else to the actor's inbox.
+func AddActivityPubFollower(ctx context.Context, db *sql.DB, community uid.ID, actor *activitypub.Actor) error {
+	var sharedInbox any
+	if actor.Endpoints != nil && actor.Endpoints.SharedInbox != "" {
+		sharedInbox = actor.Endpoints.SharedInbox
+	}
+	query := "INSERT INTO activitypub_followers (community_id, actor_id, inbox, shared_inbox) VALUES (?, ?, ?, ?) " +
+		msql.UpsertClause([]string{"community_id", "actor_id"}, "inbox", "shared_inbox")
+	_, err := db.ExecContext(ctx, query, community, actor.ID, actor.Inbox, sharedInbox)
+	return err
+}
+
+// RemoveActivityPubFollower removes the remote actor actorID from the
+// followers of community.
+func RemoveActivityPubFollower(ctx context.Context, db *sql.DB, community uid.ID, actorID string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM activitypub_followers WHERE community_id = ? AND actor_id = ?", community, actorID)
+	return err
+}
+
+// CountActivityPubFollowers returns the number of remote followers of
+// community.
+func CountActivityPubFollowers(ctx context.Context, db *sql.DB, community uid.ID) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM activitypub_followers WHERE community_id = ?", community).Scan(&n)
+	return n, err
+}
+
+// activityPubInboxes returns the inboxes to which the activities of community
+// are delivered, one per follower or, for servers with a shared inbox, one
+// per server.
+func activityPubInboxes(ctx context.Context, db *sql.DB, community uid.ID) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT DISTINCT COALESCE(shared_inbox, inbox) FROM activitypub_followers WHERE community_id = ?", community)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var inboxes []string
+	for rows.Next() {
+		var inbox string
+		if err := rows.Scan(&inbox); err != nil {
+			return nil, err
+		}
+		inboxes = append(inboxes, inbox)
+	}
+	return inboxes, rows.Err()
+}
+
+// EnqueueActivity queues activity, an activity of the actor of community, for
+// delivery to all of the community's remote followers. It returns the number
+// of deliveries queued.
+func EnqueueActivity(ctx context.Context, db *sql.DB, community uid.ID, activity any) (int, error) {
+	inboxes, err := activityPubInboxes(ctx, db, community)
+	if err != nil || len(inboxes) == 0 {
+		return 0, err
+	}
+	data, err := json.Marshal(activity)
+	if err != nil {
+		return 0, err
+	}
+	for _, inbox := range inboxes {
+		if err := enqueueDelivery(ctx, db, community, inbox, data); err != nil {
+			return 0, err
+		}
+	}
+	return len(inboxes), nil
+}
+
+// EnqueueActivityTo queues activity, an activity of the actor of community, for
+// delivery to inbox.
+func EnqueueActivityTo(ctx context.Context, db *sql.DB, community uid.ID, inbox string, activity any) error {
+	data, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	return enqueueDelivery(ctx, db, community, inbox, data)
+}
+
+func enqueueDelivery(ctx context.Context, db *sql.DB, community uid.ID, inbox string, activity []byte) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO activitypub_deliveries (community_id, inbox, activity, next_attempt_at) VALUES (?, ?, ?, ?)",
+		community, inbox, string(activity), time.Now())
+	return err
+}
+
+// deliveryBackoff returns how long to wait before the next attempt at a
+// delivery that has failed attempts times: 1m, 4m, 16m, ... up to a day.
+func deliveryBackoff(attempts int) time.Duration {
+	d := time.Minute << (2 * (attempts - 1))
+	if d <= 0 || d > 24*time.Hour {
+		d = 24 * time.Hour
+	}
+	return d
+}
+
+type activityDelivery struct {
+	id            int
+	communityID   uid.ID
+	communityName string
+	inbox         string
+	activity      string
+	attempts      int
+}
+
+// DeliverActivities attempts the queued deliveries (see EnqueueActivity) that
+// are due. Failed deliveries are retried, with exponential backoff, unless the
+// failure is permanent or they have been attempted too many times. It returns
+// the number of activities delivered.
+func DeliverActivities(ctx context.Context, db *sql.DB, site activitypub.Site) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT d.id, d.community_id, c.name, d.inbox, d.activity, d.attempts
+		FROM activitypub_deliveries AS d
+		INNER JOIN communities AS c ON c.id = d.community_id
+		WHERE d.next_attempt_at <= ?
+		ORDER BY d.next_attempt_at
+		LIMIT ?`, time.Now(), deliveriesBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var deliveries []*activityDelivery
+	for rows.Next() {
+		d := &activityDelivery{}
+		if err := rows.Scan(&d.id, &d.communityID, &d.communityName, &d.inbox, &d.activity, &d.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, d := range deliveries {
+		if ctx.Err() != nil {
+			break
+		}
+		key, err := GetActivityPubKey(ctx, db, d.communityID)
+		if err != nil {
+			return n, err
+		}
+		private, err := activitypub.ParsePrivateKey(key.PrivateKey)
+		if err != nil {
+			return n, err
+		}
+
+		err = activitypub.Deliver(ctx, d.inbox, []byte(d.activity), site.CommunityKeyID(d.communityName), private)
+		var statusErr *activitypub.StatusError
+		permanent := errors.As(err, &statusErr) && statusErr.Permanent()
+		if err == nil || permanent || d.attempts+1 >= maxDeliveryAttempts {
+			if err != nil {
+				logger.WarnContext(ctx, "Giving up on ActivityPub delivery", "inbox", d.inbox, "attempts", d.attempts+1, "err", err)
+			} else {
+				n++
+			}
+			if _, err := db.ExecContext(ctx, "DELETE FROM activitypub_deliveries WHERE id = ?", d.id); err != nil {
+				return n, err
+			}
+			continue
+		}
+		if _, err := db.ExecContext(ctx, "UPDATE activitypub_deliveries SET attempts = attempts + 1, next_attempt_at = ? WHERE id = ?",
+			time.Now().Add(deliveryBackoff(d.attempts+1)), d.id); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
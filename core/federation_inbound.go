@@ -0,0 +1,318 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/activitypub"
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/internal/utils"
+)
+
+// RemoteActor is an actor on another (ActivityPub) server that's mapped to a
+// shadow user on this one.
+type RemoteActor struct {
+	ActorID string `json:"actorId"`
+	Handle  string `json:"handle"` // For example, alice@mastodon.social.
+	Domain  string `json:"domain"`
+	URL     string `json:"url,omitempty"` // The URL of the actor's profile page.
+}
+
+// ActorDomain returns the (lower case) domain name of the server of the actor
+// actorID.
+func ActorDomain(actorID string) string {
+	u, err := url.Parse(actorID)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// placeholderUsername returns a username, for a user that's a placeholder for
+// someone who's not a user of this site (such as a remote actor, or an author
+// of imported content) and whose name elsewhere is preferred, that's unlikely
+// to be taken. If preferred has no valid characters, fallback is used.
+func placeholderUsername(preferred, fallback string) string {
+	var b strings.Builder
+	for _, r := range preferred {
+		if r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' {
+			b.WriteRune(r)
+		}
+		if b.Len() == maxUsernameLength-8 {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		b.WriteString(fallback)
+	}
+	return b.String() + "_" + utils.GenerateStringID(6)
+}
+
+// GetRemoteUser returns the shadow user of the remote actor actor, creating it
+// if it doesn't exist yet. The stored details of the actor are updated.
+func GetRemoteUser(ctx context.Context, db *sql.DB, actor *activitypub.Actor) (*User, error) {
+	domain := ActorDomain(actor.ID)
+	if domain == "" {
+		return nil, httperr.NewBadRequest("invalid_actor", "Invalid actor ID.")
+	}
+	preferred := actor.PreferredUsername
+	if preferred == "" {
+		preferred = actor.Name
+	}
+	handle := preferred + "@" + domain
+	var profileURL any
+	if actor.URL != "" {
+		profileURL = actor.URL
+	}
+
+	var user uid.ID
+	err := db.QueryRowContext(ctx, "SELECT user_id FROM activitypub_actors WHERE actor_id = ?", actor.ID).Scan(&user)
+	if err == nil {
+		if _, err := db.ExecContext(ctx, "UPDATE activitypub_actors SET handle = ?, url = ?, inbox = ?, updated_at = ? WHERE user_id = ?",
+			handle, profileURL, actor.Inbox, time.Now(), user); err != nil {
+			return nil, err
+		}
+		return GetUser(ctx, db, user, nil)
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	user = uid.New()
+	err = msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if err := insertPlaceholderUser(ctx, tx, user, placeholderUsername(preferred, "remote")); err != nil {
+			return err
+		}
+		query, args := msql.BuildInsertQuery("activitypub_actors", []msql.ColumnValue{
+			{Name: "user_id", Value: user},
+			{Name: "actor_id", Value: actor.ID},
+			{Name: "handle", Value: handle},
+			{Name: "domain", Value: domain},
+			{Name: "url", Value: profileURL},
+			{Name: "inbox", Value: actor.Inbox},
+		})
+		_, err := tx.ExecContext(ctx, query, args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetUser(ctx, db, user, nil)
+}
+
+// insertPlaceholderUser creates a user, that no one can log in as, with the
+// ID id and the username username.
+func insertPlaceholderUser(ctx context.Context, tx *sql.Tx, id uid.ID, username string) error {
+	query, args := msql.BuildInsertQuery("users", []msql.ColumnValue{
+		{Name: "id", Value: id},
+		{Name: "username", Value: username},
+		{Name: "username_lc", Value: strings.ToLower(username)},
+		{Name: "password", Value: ""}, // matches no password
+	})
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetCommentByObjectID returns the comment that was created from the remote
+// object (a reply) objectID.
+func GetCommentByObjectID(ctx context.Context, db *sql.DB, objectID string) (*Comment, error) {
+	var id uid.ID
+	if err := db.QueryRowContext(ctx, "SELECT comment_id FROM activitypub_objects WHERE object_id = ?", objectID).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errCommentNotFound
+		}
+		return nil, err
+	}
+	return GetComment(ctx, db, id, nil)
+}
+
+// AddRemoteComment adds a comment to post, by the shadow user of a remote
+// actor, from the remote object (a reply) objectID. If the object was already
+// added, the existing comment is returned.
+func AddRemoteComment(ctx context.Context, db *sql.DB, post *Post, user uid.ID, parent *uid.ID, objectID, body string) (*Comment, error) {
+	if c, err := GetCommentByObjectID(ctx, db, objectID); err == nil {
+		return c, nil
+	} else if err != errCommentNotFound {
+		return nil, err
+	}
+	c, err := post.AddComment(ctx, user, UserGroupNormal, parent, body, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO activitypub_objects (object_id, comment_id) VALUES (?, ?)", objectID, c.ID); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// AddActivityPubAnnounce records that the remote actor actorID shared
+// (boosted) post.
+func AddActivityPubAnnounce(ctx context.Context, db *sql.DB, post uid.ID, actorID string) error {
+	query := "INSERT INTO activitypub_announces (post_id, actor_id) VALUES (?, ?) " + msql.UpsertClause([]string{"post_id", "actor_id"}, "actor_id")
+	_, err := db.ExecContext(ctx, query, post, actorID)
+	return err
+}
+
+// RemoveActivityPubAnnounce undoes AddActivityPubAnnounce.
+func RemoveActivityPubAnnounce(ctx context.Context, db *sql.DB, post uid.ID, actorID string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM activitypub_announces WHERE post_id = ? AND actor_id = ?", post, actorID)
+	return err
+}
+
+// CountActivityPubAnnounces returns the number of remote actors who shared
+// post.
+func CountActivityPubAnnounces(ctx context.Context, db *sql.DB, post uid.ID) (int, error) {
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM activitypub_announces WHERE post_id = ?", post).Scan(&n)
+	return n, err
+}
+
+// ActivityPubBlock blocks either a remote server (by domain) or a remote actor
+// from interacting with a community, or, if CommunityID is null, with the
+// whole site.
+type ActivityPubBlock struct {
+	ID          int             `json:"id"`
+	CommunityID uid.NullID      `json:"communityId"`
+	Domain      msql.NullString `json:"domain"`
+	ActorID     msql.NullString `json:"actorId"`
+	CreatedBy   uid.ID          `json:"createdBy"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+// GetActivityPubBlocks returns the blocks of community, or, if community is
+// nil, the site-wide blocks.
+func GetActivityPubBlocks(ctx context.Context, db *sql.DB, community *uid.ID) ([]*ActivityPubBlock, error) {
+	query := "SELECT id, community_id, domain, actor_id, created_by, created_at FROM activitypub_blocks WHERE community_id IS NULL ORDER BY id"
+	var args []any
+	if community != nil {
+		query = "SELECT id, community_id, domain, actor_id, created_by, created_at FROM activitypub_blocks WHERE community_id = ? ORDER BY id"
+		args = append(args, *community)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocks := []*ActivityPubBlock{}
+	for rows.Next() {
+		b := &ActivityPubBlock{}
+		if err := rows.Scan(&b.ID, &b.CommunityID, &b.Domain, &b.ActorID, &b.CreatedBy, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, rows.Err()
+}
+
+// AddActivityPubBlock blocks either the server domain or the actor actorID
+// (exactly one of which must be non-empty) from community, or, if community is
+// nil, from the site. Remote followers of the community who are blocked are
+// removed.
+func AddActivityPubBlock(ctx context.Context, db *sql.DB, community *uid.ID, domain, actorID string, by uid.ID) (*ActivityPubBlock, error) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	actorID = strings.TrimSpace(actorID)
+	if (domain == "") == (actorID == "") {
+		return nil, httperr.NewBadRequest("invalid_block", "Either a domain or an actor ID (but not both) is required.")
+	}
+	if actorID != "" && ActorDomain(actorID) == "" {
+		return nil, httperr.NewBadRequest("invalid_actor", "Invalid actor ID.")
+	}
+
+	b := &ActivityPubBlock{
+		Domain:    msql.NullString{NullString: sql.NullString{String: domain, Valid: domain != ""}},
+		ActorID:   msql.NullString{NullString: sql.NullString{String: actorID, Valid: actorID != ""}},
+		CreatedBy: by,
+		CreatedAt: time.Now(),
+	}
+	if community != nil {
+		b.CommunityID = uid.NullID{ID: *community, Valid: true}
+	}
+	res, err := db.ExecContext(ctx, "INSERT INTO activitypub_blocks (community_id, domain, actor_id, created_by, created_at) VALUES (?, ?, ?, ?, ?)",
+		b.CommunityID, b.Domain, b.ActorID, b.CreatedBy, b.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	b.ID = int(id)
+
+	if err := removeBlockedFollowers(ctx, db, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// removeBlockedFollowers removes the remote followers blocked by b.
+func removeBlockedFollowers(ctx context.Context, db *sql.DB, b *ActivityPubBlock) error {
+	query := "SELECT id, actor_id FROM activitypub_followers"
+	var args []any
+	if b.CommunityID.Valid {
+		query += " WHERE community_id = ?"
+		args = append(args, b.CommunityID)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	var ids []any
+	for rows.Next() {
+		var (
+			id    int
+			actor string
+		)
+		if err := rows.Scan(&id, &actor); err != nil {
+			rows.Close()
+			return err
+		}
+		if actor == b.ActorID.String || ActorDomain(actor) == b.Domain.String {
+			ids = append(ids, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err = db.ExecContext(ctx, "DELETE FROM activitypub_followers WHERE id IN "+msql.InClauseQuestionMarks(len(ids)), ids...)
+	return err
+}
+
+// DeleteActivityPubBlock removes the block with the ID id of community (or, if
+// community is nil, the site-wide block).
+func DeleteActivityPubBlock(ctx context.Context, db *sql.DB, community *uid.ID, id int) error {
+	query, args := "DELETE FROM activitypub_blocks WHERE id = ? AND community_id IS NULL", []any{id}
+	if community != nil {
+		query, args = "DELETE FROM activitypub_blocks WHERE id = ? AND community_id = ?", []any{id, *community}
+	}
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return httperr.NewNotFound("block_not_found", "Block not found.")
+	}
+	return nil
+}
+
+// IsActivityPubBlocked reports whether the remote actor actorID is blocked,
+// either by itself or by its server, from interacting with community (or with
+// the site).
+func IsActivityPubBlocked(ctx context.Context, db *sql.DB, community uid.ID, actorID string) (bool, error) {
+	var n int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM activitypub_blocks
+		WHERE (community_id IS NULL OR community_id = ?) AND (domain = ? OR actor_id = ?)`,
+		community, ActorDomain(actorID), actorID).Scan(&n)
+	return n > 0, err
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/discuitnet/discuit/internal/httperr"
 	msql "github.com/discuitnet/discuit/internal/sql"
 	"github.com/discuitnet/discuit/internal/uid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // FeedSort represents how the items in a feed are to be sorted.
@@ -26,6 +27,7 @@ const (
 	FeedSortTopMonth
 	FeedSortTopYear
 	FeedSortTopAll
+	FeedSortControversial
 )
 
 // Valid reports whether f is a valid FeedSort.
@@ -53,6 +55,8 @@ func (s FeedSort) MarshalText() ([]byte, error) {
 		return []byte("hot"), nil
 	case FeedSortActivity:
 		return []byte("activity"), nil
+	case FeedSortControversial:
+		return []byte("controversial"), nil
 	}
 	return nil, fmt.Errorf("cannot marshal unsupported FeedSort (%v)", int(s))
 }
@@ -81,6 +85,8 @@ func (s *FeedSort) UnmarshalText(text []byte) error {
 		*s = FeedSortHot
 	case "activity":
 		*s = FeedSortActivity
+	case "controversial":
+		*s = FeedSortControversial
 	default:
 		return fmt.Errorf("cannot unmarshal unsupported FeedSort: %v", t)
 	}
@@ -149,6 +155,8 @@ func newFeedResultSet(posts []*Post, limit int, sort FeedSort) *FeedResultSet {
 			nextnext = strconv.Itoa(posts[limit].Hotness) + "." + posts[limit].ID.String()
 		case FeedSortActivity:
 			nextnext = posts[limit].LastActivityAt.UnixNano()
+		case FeedSortControversial:
+			nextnext = strconv.Itoa(posts[limit].Controversy) + "." + posts[limit].ID.String()
 		default:
 			// Shouldn't happen, ever.
 			panic("invalid feed sort")
@@ -226,6 +234,9 @@ func (o *FeedOptions) nextInt64() (i int64, err error) {
 }
 
 func GetFeed(ctx context.Context, db *sql.DB, opts *FeedOptions) (_ *FeedResultSet, err error) {
+	ctx, span := startSpan(ctx, "core.GetFeed", attribute.Int("sort", int(opts.Sort)), attribute.Bool("homefeed", opts.Homefeed))
+	defer func() { endSpan(span, err) }()
+
 	if !opts.Sort.Valid() {
 		return nil, ErrInvalidFeedSort
 	}
@@ -236,12 +247,15 @@ func GetFeed(ctx context.Context, db *sql.DB, opts *FeedOptions) (_ *FeedResultS
 		set, err = getPostsHot(ctx, db, opts)
 	} else if opts.Sort == FeedSortActivity {
 		set, err = getPostsActivity(ctx, db, opts)
+	} else if opts.Sort == FeedSortControversial {
+		set, err = getPostsControversial(ctx, db, opts)
 	} else {
 		set, err = getPostsTop(ctx, db, opts)
 	}
 	if err != nil {
 		return nil, err
 	}
+	set.Posts = rankFeed(ctx, db, opts, set.Posts)
 	if opts.DefaultSort {
 		// Merge pinned posts.
 		return mergePinnedPosts(ctx, db, opts.Viewer, opts.Community, opts.Next, set)
@@ -360,6 +374,10 @@ func mergePinnedPosts(ctx context.Context, db *sql.DB, viewer, community *uid.ID
 	return rs, nil
 }
 
+// whereMuted adds to where the conditions that leave out the posts (in
+// postsTable, either posts or one of postsTables) of the users muted by
+// viewer, those in the communities muted by viewer, if muteCommunities, and
+// those hidden by viewer.
 func whereMuted(where, postsTable string, args []any, viewer uid.ID, muteCommunities bool) (string, []any) {
 	if !(where == "" || strings.TrimSpace(strings.ToUpper(where)) == "WHERE") {
 		where += "AND "
@@ -368,7 +386,13 @@ func whereMuted(where, postsTable string, args []any, viewer uid.ID, muteCommuni
 		where += "community_id NOT IN (SELECT community_id FROM muted_communities WHERE user_id = ?) AND "
 		args = append(args, viewer)
 	}
-	where += postsTable + ".user_id NOT IN (SELECT muted_user_id FROM muted_users WHERE user_id = ?)"
+	where += postsTable + ".user_id NOT IN (SELECT muted_user_id FROM muted_users WHERE user_id = ?) "
+	args = append(args, viewer)
+	postID := postsTable + ".post_id"
+	if postsTable == "posts" {
+		postID = "posts.id"
+	}
+	where += "AND " + postID + " NOT IN (SELECT post_id FROM hidden_posts WHERE user_id = ?)"
 	args = append(args, viewer)
 	return where, args
 }
@@ -425,6 +449,56 @@ func getPostsHot(ctx context.Context, db *sql.DB, opts *FeedOptions) (*FeedResul
 	return newFeedResultSet(posts, opts.Limit, FeedSortHot), nil
 }
 
+// getPostsControversial returns site wide all time most controversial posts,
+// if opts.Community is nil, or those in opts.Community, if not.
+func getPostsControversial(ctx context.Context, db *sql.DB, opts *FeedOptions) (*FeedResultSet, error) {
+	loggedIn := opts.Viewer != nil
+	var args []any
+	if loggedIn {
+		args = append(args, *opts.Viewer)
+	}
+
+	where := "WHERE posts.deleted = FALSE "
+	if opts.Homefeed {
+		where += "AND " + whereSelectUserComms
+		args = append(args, *opts.Viewer)
+	} else {
+		if opts.Community != nil {
+			where += "AND community_id = ? "
+			args = append(args, *opts.Community)
+		}
+	}
+	if loggedIn {
+		where, args = whereMuted(where, "posts", args, *opts.Viewer, opts.Community == nil && !opts.Homefeed)
+	}
+	if opts.Next != "" {
+		nextControversy, nextID, err := opts.nextPointsID()
+		if err != nil {
+			return nil, err
+		}
+		where += "AND (posts.controversy, posts.id) <= (?, ?) "
+		args = append(args, nextControversy)
+		args = append(args, nextID)
+	}
+	where += "ORDER BY posts.controversy DESC, posts.id DESC LIMIT ?"
+	args = append(args, opts.Limit+1)
+	query := buildSelectPostQuery(loggedIn, where)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	posts, err := scanPosts(ctx, db, rows, opts.Viewer)
+	if err != nil {
+		if err == errPostNotFound {
+			return &FeedResultSet{}, nil
+		}
+		return nil, err
+	}
+	return newFeedResultSet(posts, opts.Limit, FeedSortControversial), nil
+}
+
 // getPostsTopAll returns site wide all time top posts, if opts.Community is
 // nil, or all time top posts in opts.Community, if not.
 func getPostsTopAll(ctx context.Context, db *sql.DB, opts *FeedOptions) (*FeedResultSet, error) {
@@ -611,6 +685,34 @@ func getPostsList(ctx context.Context, db *sql.DB, viewer *uid.ID, ids ...uid.ID
 	return scanPosts(ctx, db, rows, viewer)
 }
 
+// getPostTitles returns a map of post IDs to post titles.
+func getPostTitles(ctx context.Context, db *sql.DB, ids []uid.ID) (map[uid.ID]string, error) {
+	titles := make(map[uid.ID]string)
+	if len(ids) == 0 {
+		return titles, nil
+	}
+
+	args := make([]any, len(ids))
+	for i := range ids {
+		args[i] = ids[i]
+	}
+	rows, err := db.QueryContext(ctx, "SELECT id, title FROM posts WHERE id IN "+msql.InClauseQuestionMarks(len(ids)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uid.ID
+		var title string
+		if err = rows.Scan(&id, &title); err != nil {
+			return nil, err
+		}
+		titles[id] = title
+	}
+	return titles, rows.Err()
+}
+
 // GetPostsDeleted returns a slice of deleted posts (sorted by ID) and the
 // number of deleted posts in community.
 func GetPostsDeleted(ctx context.Context, db *sql.DB, community uid.ID, limit, page int) (int, []*Post, error) {
@@ -669,7 +771,10 @@ type UserFeedResultSet struct {
 	Next  *uid.ID        `json:"next"`
 }
 
-func GetUserFeed(ctx context.Context, db *sql.DB, viewer *uid.ID, userID uid.ID, limit int, next *uid.ID) (*UserFeedResultSet, error) {
+func GetUserFeed(ctx context.Context, db *sql.DB, viewer *uid.ID, userID uid.ID, limit int, next *uid.ID) (_ *UserFeedResultSet, err error) {
+	ctx, span := startSpan(ctx, "core.GetUserFeed", attribute.String("user", userID.String()))
+	defer func() { endSpan(span, err) }()
+
 	var args []any
 	query := "SELECT target_id, target_type FROM posts_comments WHERE user_id = ? "
 	args = append(args, userID)
@@ -707,27 +812,64 @@ func GetUserFeed(ctx context.Context, db *sql.DB, viewer *uid.ID, userID uid.ID,
 	if len(ids) < limit+1 {
 		max = len(ids)
 	}
+
+	var postIDs, commentIDs []uid.ID
+	for i := 0; i < max; i++ {
+		if types[i] == postsCommentsTypePosts {
+			postIDs = append(postIDs, ids[i])
+		} else if types[i] == postsCommentsTypeComments {
+			commentIDs = append(commentIDs, ids[i])
+		}
+	}
+
+	posts := make(map[uid.ID]*Post)
+	if len(postIDs) > 0 {
+		list, err := getPostsList(ctx, db, viewer, postIDs...)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range list {
+			posts[p.ID] = p
+		}
+	}
+
+	comments := make(map[uid.ID]*Comment)
+	if len(commentIDs) > 0 {
+		list, err := getCommentsList(ctx, db, viewer, commentIDs)
+		if err != nil {
+			return nil, err
+		}
+		var commentPostIDs []uid.ID
+		for _, c := range list {
+			comments[c.ID] = c
+			commentPostIDs = append(commentPostIDs, c.PostID)
+		}
+		titles, err := getPostTitles(ctx, db, uniqueIDs(commentPostIDs))
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range list {
+			c.PostTitle = titles[c.PostID]
+		}
+	}
+
 	set := &UserFeedResultSet{}
 	for i := 0; i < max; i++ {
-		// TODO: Optimize fetching posts and comments.
 		item := UserFeedItem{}
 		if types[i] == postsCommentsTypePosts {
 			item.Type = "post"
-			if item.Item, err = GetPost(ctx, db, &ids[i], "", viewer, true); err != nil {
-				return nil, err
+			post, ok := posts[ids[i]]
+			if !ok {
+				return nil, errPostNotFound
 			}
+			item.Item = post
 		} else if types[i] == postsCommentsTypeComments {
 			item.Type = "comment"
-			var c *Comment
-			if c, err = GetComment(ctx, db, ids[i], viewer); err != nil {
-				return nil, err
-			}
-			if p, err := GetPost(ctx, db, &c.PostID, "", nil, true); err == nil {
-				c.PostTitle = p.Title
-			} else {
-				return nil, err
+			comment, ok := comments[ids[i]]
+			if !ok {
+				return nil, errCommentNotFound
 			}
-			item.Item = c
+			item.Item = comment
 		}
 		set.Items = append(set.Items, item)
 	}
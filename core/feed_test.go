@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/mattn/go-sqlite3"
+)
+
+// countingSQLiteDriver is the SQLite driver, keeping count of the queries run
+// (whether prepared or not).
+type countingSQLiteDriver struct {
+	sqlite3.SQLiteDriver
+	queries atomic.Int64
+}
+
+func (d *countingSQLiteDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingSQLiteConn{SQLiteConn: conn.(*sqlite3.SQLiteConn), d: d}, nil
+}
+
+type countingSQLiteConn struct {
+	*sqlite3.SQLiteConn
+	d *countingSQLiteDriver
+}
+
+func (c *countingSQLiteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.d.queries.Add(1)
+	return c.SQLiteConn.QueryContext(ctx, query, args)
+}
+
+func (c *countingSQLiteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.SQLiteConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &countingSQLiteStmt{Stmt: stmt, d: c.d}, nil
+}
+
+type countingSQLiteStmt struct {
+	driver.Stmt
+	d *countingSQLiteDriver
+}
+
+func (s *countingSQLiteStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	s.d.queries.Add(1)
+	return s.Stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+}
+
+func (s *countingSQLiteStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.Stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+}
+
+func TestGetUserFeedQueryCount(t *testing.T) {
+	ctx := context.Background()
+	d := &countingSQLiteDriver{}
+	sql.Register("sqlite3_counting_"+t.Name(), d)
+	db := openSQLiteTestDB(t, "sqlite3_counting_"+t.Name())
+
+	user, err := RegisterUser(ctx, db, "author", "", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	viewer, err := RegisterUser(ctx, db, "viewer", "", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE users SET is_admin = TRUE"); err != nil { // To create communities.
+		t.Fatal(err)
+	}
+
+	// queries returns the number of queries GetUserFeed runs for a feed of
+	// the posts and comments added so far.
+	queries := func(items int) int64 {
+		t.Helper()
+		start := d.queries.Load()
+		feed, err := GetUserFeed(ctx, db, &viewer.ID, user.ID, 100, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(feed.Items) != items {
+			t.Fatalf("feed has %d items (expected %d)", len(feed.Items), items)
+		}
+		return d.queries.Load() - start
+	}
+
+	// addPosts adds n posts, each in a community of its own and with a
+	// comment, to the feed.
+	posts := 0
+	addPosts := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			posts++
+			comm, err := CreateCommunity(ctx, db, user.ID, 0, 100, fmt.Sprintf("community%d", posts), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			post, err := CreateTextPost(ctx, db, user.ID, comm.ID, fmt.Sprintf("Post %d", posts), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var parent *uid.ID
+			if c, err := post.AddComment(ctx, viewer.ID, UserGroupNormal, nil, "A comment", nil); err != nil {
+				t.Fatal(err)
+			} else {
+				parent = &c.ID
+			}
+			if _, err := post.AddComment(ctx, user.ID, UserGroupNormal, parent, fmt.Sprintf("Reply %d", posts), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	addPosts(2)
+	few := queries(4)
+	addPosts(20)
+	many := queries(44)
+
+	if many != few {
+		t.Errorf("GetUserFeed ran %d queries for a feed of 4 items but %d for one of 44 (expected the same number)", few, many)
+	}
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// HiddenPostsResultSet is a page of the posts a user hid.
+type HiddenPostsResultSet struct {
+	Posts []*Post `json:"posts"`
+	Next  *uid.ID `json:"next"` // The pagination cursor.
+}
+
+// HidePost hides post from the feeds of user. Hiding a post that's hidden
+// already does nothing.
+func HidePost(ctx context.Context, db *sql.DB, user, post uid.ID) error {
+	if _, err := GetPost(ctx, db, &post, "", nil, true); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, "INSERT INTO hidden_posts (user_id, post_id, created_at) VALUES (?, ?, ?)", user, post, time.Now())
+	if err != nil && !msql.IsErrDuplicateErr(err) {
+		return err
+	}
+	return nil
+}
+
+// UnhidePost shows post, if it was hidden, in the feeds of user again.
+func UnhidePost(ctx context.Context, db *sql.DB, user, post uid.ID) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM hidden_posts WHERE user_id = ? AND post_id = ?", user, post)
+	return err
+}
+
+// GetHiddenPosts returns the posts user hid, the latest posts first, limit at
+// a time, starting at next, if it's not nil.
+func GetHiddenPosts(ctx context.Context, db *sql.DB, user uid.ID, limit int, next *uid.ID) (*HiddenPostsResultSet, error) {
+	query, args := "SELECT post_id FROM hidden_posts WHERE user_id = ? ", []any{user}
+	if next != nil {
+		query += "AND post_id <= ? "
+		args = append(args, *next)
+	}
+	query += "ORDER BY post_id DESC LIMIT ?"
+	args = append(args, limit+1)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &HiddenPostsResultSet{Posts: []*Post{}}
+	if len(ids) > limit {
+		set.Next = &ids[limit]
+		ids = ids[:limit]
+	}
+	if len(ids) == 0 {
+		return set, nil
+	}
+	posts, err := getPostsList(ctx, db, &user, ids...)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uid.ID]*Post, len(posts))
+	for _, post := range posts {
+		byID[post.ID] = post
+	}
+	for _, id := range ids {
+		if post, ok := byID[id]; ok {
+			set.Posts = append(set.Posts, post)
+		}
+	}
+	return set, nil
+}
+
+// autoHidePost hides post from the feeds of user if user has the option (the
+// users column) col on, as is done on voting on, or reporting, a post.
+func autoHidePost(ctx context.Context, db *sql.DB, user, post uid.ID, col string) {
+	var on bool
+	if err := db.QueryRowContext(ctx, "SELECT "+col+" FROM users WHERE id = ?", user).Scan(&on); err != nil {
+		logger.ErrorContext(ctx, "Failed to get the auto-hide option of user", "err", err, "option", col)
+		return
+	}
+	if !on {
+		return
+	}
+	if err := HidePost(ctx, db, user, post); err != nil {
+		logger.ErrorContext(ctx, "Failed to auto-hide post", "err", err, "post", post)
+	}
+}
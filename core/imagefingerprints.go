@@ -0,0 +1,283 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+const (
+	// imageFingerprintsTTL is how long the fingerprints of uploaded images are
+	// kept, and flaggedImageFingerprintsTTL how long they are kept if flagged
+	// (so that they remain in the mod queues).
+	imageFingerprintsTTL        = 7 * 24 * time.Hour
+	flaggedImageFingerprintsTTL = 30 * 24 * time.Hour
+)
+
+// addImageFingerprintTx records that user uploaded image (which must have a
+// perceptual hash).
+func addImageFingerprintTx(ctx context.Context, tx *sql.Tx, image, user uid.ID) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO image_fingerprints (image_id, user_id, phash, created_at)
+		SELECT id, ?, phash, ? FROM images WHERE id = ? AND phash IS NOT NULL`, user, time.Now(), image)
+	return err
+}
+
+// ImageDuplicatePolicy determines when an uploaded image is flagged as one of
+// many near-duplicates uploaded by different accounts.
+type ImageDuplicatePolicy struct {
+	Window time.Duration // How far back to look for near-duplicate images.
+
+	// The maximum number of bits in which the perceptual hashes of two images
+	// may differ for them to be considered near-duplicates.
+	MaxDistance int
+
+	// The number of other accounts that, having uploaded near-duplicates within
+	// Window, get an upload flagged. Zero means uploads are never flagged.
+	AccountLimit int
+}
+
+// ImageDuplicateCheck is the result of checking an uploaded image against the
+// recent uploads of other accounts.
+type ImageDuplicateCheck struct {
+	OtherUsers int    // Other users who uploaded near-duplicates.
+	Exact      int    // Of their uploads, those with identical hashes.
+	Reason     string // Why the image was flagged; empty if it wasn't.
+}
+
+// Flagged reports whether the checked image was flagged.
+func (c *ImageDuplicateCheck) Flagged() bool {
+	return c.Reason != ""
+}
+
+// CheckDuplicateImage checks whether image, just uploaded by user, is a
+// near-duplicate of the images other accounts uploaded recently, as per policy.
+// If it is, the image and its near-duplicates are flagged, to be surfaced in
+// the admin and mod queues.
+func CheckDuplicateImage(ctx context.Context, db *sql.DB, image, user uid.ID, policy *ImageDuplicatePolicy) (*ImageDuplicateCheck, error) {
+	check := &ImageDuplicateCheck{}
+	var hash int64
+	if err := db.QueryRowContext(ctx, "SELECT phash FROM image_fingerprints WHERE image_id = ?", image).Scan(&hash); err != nil {
+		if err == sql.ErrNoRows {
+			return check, nil
+		}
+		return nil, err
+	}
+
+	since := time.Now().Add(-policy.Window)
+	rows, err := db.QueryContext(ctx, "SELECT image_id, user_id, phash FROM image_fingerprints WHERE created_at > ? AND user_id <> ? LIMIT 20000", since, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	others := make(map[uid.ID]bool)
+	var matches []uid.ID
+	for rows.Next() {
+		var imageID, userID uid.ID
+		var h int64
+		if err := rows.Scan(&imageID, &userID, &h); err != nil {
+			return nil, err
+		}
+		d := images.HashDistance(uint64(hash), uint64(h))
+		if d > policy.MaxDistance {
+			continue
+		}
+		if d == 0 {
+			check.Exact++
+		}
+		others[userID] = true
+		matches = append(matches, imageID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	check.OtherUsers = len(others)
+
+	if policy.AccountLimit == 0 || check.OtherUsers < policy.AccountLimit {
+		return check, nil
+	}
+	check.Reason = fmt.Sprintf("Near-duplicates uploaded by %d accounts in the last %d minutes.", check.OtherUsers+1, int(policy.Window.Minutes()))
+	matches = append(matches, image)
+	for _, id := range matches {
+		if _, err := db.ExecContext(ctx, "UPDATE image_fingerprints SET reason = ? WHERE image_id = ? AND reason IS NULL", check.Reason, id); err != nil {
+			return nil, err
+		}
+	}
+	return check, nil
+}
+
+// PruneImageFingerprints deletes the fingerprints of images that are too old
+// to be of use in detecting duplicates.
+func PruneImageFingerprints(ctx context.Context, db *sql.DB) error {
+	now := time.Now()
+	_, err := db.ExecContext(ctx, `
+		DELETE FROM image_fingerprints
+		WHERE (reason IS NULL AND created_at < ?) OR created_at < ?`, now.Add(-imageFingerprintsTTL), now.Add(-flaggedImageFingerprintsTTL))
+	return err
+}
+
+// ImageCluster is a group of near-duplicate images uploaded recently.
+type ImageCluster struct {
+	Uploads   int           `json:"uploads"`
+	Flagged   int           `json:"flagged"` // Of Uploads, those that are flagged.
+	Users     int           `json:"users"`
+	Usernames []string      `json:"usernames"` // Up to 20.
+	ImageIDs  []uid.ID      `json:"imageIds"`  // Up to 50.
+	Sample    *images.Image `json:"sample"`    // The most recent upload.
+	FirstAt   time.Time     `json:"firstAt"`
+	LastAt    time.Time     `json:"lastAt"`
+
+	phash uint64
+	users map[uid.ID]bool
+}
+
+// GetImageClusters returns the clusters of near-duplicate images (those whose
+// perceptual hashes differ in at most maxDistance bits), uploaded since since
+// by at least minUsers different users, largest first.
+func GetImageClusters(ctx context.Context, db *sql.DB, since time.Time, maxDistance, minUsers int) ([]*ImageCluster, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT image_fingerprints.image_id, image_fingerprints.user_id, users.username, image_fingerprints.phash, image_fingerprints.reason, image_fingerprints.created_at
+		FROM image_fingerprints
+		INNER JOIN users ON users.id = image_fingerprints.user_id
+		WHERE image_fingerprints.created_at > ?
+		ORDER BY image_fingerprints.created_at DESC
+		LIMIT 10000`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clusters []*ImageCluster
+	for rows.Next() {
+		var (
+			imageID, userID uid.ID
+			username        string
+			hash            int64
+			reason          sql.NullString
+			createdAt       time.Time
+		)
+		if err := rows.Scan(&imageID, &userID, &username, &hash, &reason, &createdAt); err != nil {
+			return nil, err
+		}
+
+		var cluster *ImageCluster
+		for _, c := range clusters {
+			if images.HashDistance(c.phash, uint64(hash)) <= maxDistance {
+				cluster = c
+				break
+			}
+		}
+		if cluster == nil {
+			// Rows are newest first.
+			cluster = &ImageCluster{LastAt: createdAt, phash: uint64(hash), users: make(map[uid.ID]bool)}
+			clusters = append(clusters, cluster)
+		}
+		cluster.Uploads++
+		cluster.FirstAt = createdAt
+		if reason.Valid {
+			cluster.Flagged++
+		}
+		if len(cluster.ImageIDs) < 50 {
+			cluster.ImageIDs = append(cluster.ImageIDs, imageID)
+		}
+		if !cluster.users[userID] {
+			cluster.users[userID] = true
+			if len(cluster.Usernames) < 20 {
+				cluster.Usernames = append(cluster.Usernames, username)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	items := []*ImageCluster{}
+	for _, c := range clusters {
+		if c.Users = len(c.users); c.Users >= minUsers {
+			items = append(items, c)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Uploads > items[j].Uploads
+	})
+	for _, c := range items {
+		record, err := images.GetImageRecord(ctx, db, c.ImageIDs[0])
+		if err != nil {
+			if err == images.ErrImageNotFound {
+				continue
+			}
+			return nil, err
+		}
+		c.Sample = record.Image()
+	}
+	return items, nil
+}
+
+// FlaggedImage is a post or a comment, in a community, with an image that was
+// flagged as one of many near-duplicates.
+type FlaggedImage struct {
+	ImageID   uid.ID        `json:"imageId"`
+	Image     *images.Image `json:"image"`
+	PostID    string        `json:"postId"`    // The public ID of the post.
+	CommentID uid.NullID    `json:"commentId"` // Null if the image is that of the post.
+	UserID    uid.ID        `json:"userId"`
+	Username  string        `json:"username"`
+	Reason    string        `json:"reason"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+// GetFlaggedImages returns the posts and comments of community, that are not
+// deleted, with flagged images, newest first.
+func GetFlaggedImages(ctx context.Context, db *sql.DB, community uid.ID) ([]*FlaggedImage, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT image_fingerprints.image_id, posts.public_id, NULL, posts.user_id, users.username, image_fingerprints.reason, posts.created_at
+		FROM image_fingerprints
+		INNER JOIN post_images ON post_images.image_id = image_fingerprints.image_id
+		INNER JOIN posts ON posts.id = post_images.post_id
+		INNER JOIN users ON users.id = posts.user_id
+		WHERE image_fingerprints.reason IS NOT NULL AND posts.community_id = ? AND posts.deleted = FALSE
+		UNION ALL
+		SELECT image_fingerprints.image_id, comments.post_public_id, comments.id, comments.user_id, users.username, image_fingerprints.reason, comments.created_at
+		FROM image_fingerprints
+		INNER JOIN comment_images ON comment_images.image_id = image_fingerprints.image_id
+		INNER JOIN comments ON comments.id = comment_images.comment_id
+		INNER JOIN posts ON posts.id = comments.post_id
+		INNER JOIN users ON users.id = comments.user_id
+		WHERE image_fingerprints.reason IS NOT NULL AND posts.community_id = ? AND comments.deleted_at IS NULL
+		ORDER BY 7 DESC
+		LIMIT 500`, community, community)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*FlaggedImage{}
+	for rows.Next() {
+		f := &FlaggedImage{}
+		if err := rows.Scan(&f.ImageID, &f.PostID, &f.CommentID, &f.UserID, &f.Username, &f.Reason, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, f := range items {
+		record, err := images.GetImageRecord(ctx, db, f.ImageID)
+		if err != nil {
+			if err == images.ErrImageNotFound {
+				continue
+			}
+			return nil, err
+		}
+		f.Image = record.Image()
+	}
+	return items, nil
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"regexp"
+
+	"github.com/discuitnet/discuit/internal/images"
+)
+
+// markdownImage matches the images (like ![alt](https://example.com/a.png
+// "title")) of Markdown text. The first submatch is the URL of the image.
+var markdownImage = regexp.MustCompile(`!\[[^\]]*\]\(\s*<?([^\s)>]+)`)
+
+// proxiedImages returns, by URL, the URLs at which the external images of the
+// Markdown text md are served by the image proxy (see images.ProxyURL), or nil
+// if there are none (or if the proxy is off).
+func proxiedImages(md string) map[string]string {
+	if !images.ProxyEnabled {
+		return nil
+	}
+	var m map[string]string
+	for _, match := range markdownImage.FindAllStringSubmatch(md, -1) {
+		src := match[1]
+		if proxied := images.ProxyURL(src); proxied != src {
+			if m == nil {
+				m = make(map[string]string)
+			}
+			m[src] = proxied
+		}
+	}
+	return m
+}
@@ -0,0 +1,363 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/importer"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// ImportOptions are the options of ImportCommunityDump.
+type ImportOptions struct {
+	Community uid.ID          // The community the posts are added to.
+	Format    importer.Format // The format of the dump files.
+	Paths     []string        // The dump files.
+
+	// Source identifies the dump (for example, "reddit/r/golang"). Items that
+	// were already imported from the same source are skipped, and so
+	// importing a dump is idempotent, and an interrupted import can be
+	// resumed by running it again.
+	Source string
+}
+
+// ImportStats are the statistics of an import.
+type ImportStats struct {
+	Users    int `json:"users"`    // Placeholder users created.
+	Posts    int `json:"posts"`    // Posts added.
+	Comments int `json:"comments"` // Comments added.
+	Skipped  int `json:"skipped"`  // Items that were already imported.
+	Failed   int `json:"failed"`   // Items that couldn't be added (see the logs).
+
+	// Comments whose post, or parent comment, is not in the dump (or could
+	// not be added).
+	Orphaned int `json:"orphaned"`
+}
+
+// The kinds of items recorded in the imports table.
+const (
+	importKindUser      = "user"
+	importKindCommunity = "community"
+	importKindPost      = "post"
+	importKindComment   = "comment"
+)
+
+// maxImportCacheSize is the maximum number of users and posts that are kept
+// in memory during an import.
+const maxImportCacheSize = 1000
+
+// importMap maps the IDs of imported items to the IDs of the rows they were
+// imported as, using the imports table.
+type importMap struct {
+	db     *sql.DB
+	source string
+}
+
+type communityImport struct {
+	importMap
+	opts *ImportOptions
+
+	stats ImportStats
+	users map[string]*User // by author ID
+	posts map[uid.ID]*Post
+
+	// Comments waiting for their parent comment to be added, by the (source)
+	// ID of the parent.
+	waiting map[string][]*importer.Item
+}
+
+// ImportCommunityDump adds the posts and comments of a community exported from
+// another site (see package importer) to a community. Threads are preserved,
+// and so are the scores and the dates of the posts and comments. Authors are
+// mapped to placeholder users (that no one can log in as), one per author.
+//
+// Every post and comment is recorded in the imports table, before it's added,
+// so that it's never added twice.
+func ImportCommunityDump(ctx context.Context, db *sql.DB, opts *ImportOptions) (*ImportStats, error) {
+	if !opts.Format.Valid() {
+		return nil, errors.New("unknown dump format " + string(opts.Format))
+	}
+	if opts.Source == "" {
+		return nil, errors.New("import source is empty")
+	}
+	if _, err := GetCommunityByID(ctx, db, opts.Community, nil); err != nil {
+		return nil, err
+	}
+
+	imp := &communityImport{
+		importMap: importMap{db: db, source: opts.Source},
+		opts:      opts,
+		users:     make(map[string]*User),
+		posts:     make(map[uid.ID]*Post),
+		waiting:   make(map[string][]*importer.Item),
+	}
+
+	// Posts first, so that comments, which may come before their posts in the
+	// dump, always have their posts.
+	if err := imp.readDump(ctx, importer.KindPost, imp.importPost); err != nil {
+		return &imp.stats, err
+	}
+	if err := imp.readDump(ctx, importer.KindComment, imp.importComment); err != nil {
+		return &imp.stats, err
+	}
+	for _, items := range imp.waiting {
+		imp.stats.Orphaned += len(items)
+	}
+	return &imp.stats, nil
+}
+
+// readDump calls fn with every item of kind in the dump files.
+func (imp *communityImport) readDump(ctx context.Context, kind importer.Kind, fn func(context.Context, *importer.Item) error) error {
+	for _, path := range imp.opts.Paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		r := importer.NewReader(f, imp.opts.Format)
+		for {
+			item, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err == nil {
+				err = ctx.Err()
+			}
+			if err == nil && item.Kind == kind {
+				err = fn(ctx, item)
+			}
+			if err != nil {
+				f.Close()
+				return err
+			}
+		}
+		f.Close()
+		logger.InfoContext(ctx, "Imported dump file", "file", path, "kind", kind, "stats", imp.stats)
+	}
+	return nil
+}
+
+// lookup returns the ID of the row the item of kind with the ID sourceID was
+// imported as, if it was.
+func (m *importMap) lookup(ctx context.Context, kind, sourceID string) (uid.ID, bool, error) {
+	var id uid.ID
+	err := m.db.QueryRowContext(ctx, "SELECT target_id FROM imports WHERE source = ? AND kind = ? AND source_id = ?",
+		m.source, kind, sourceID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return id, false, nil
+	}
+	return id, err == nil, err
+}
+
+// reserve returns the ID of the row that the item of kind with the ID
+// sourceID is to be added as, and whether the row already exists.
+func (m *importMap) reserve(ctx context.Context, kind, sourceID, table string) (uid.ID, bool, error) {
+	id, found, err := m.lookup(ctx, kind, sourceID)
+	if err != nil {
+		return id, false, err
+	}
+	if found {
+		// The row may not exist if the import was interrupted, or if adding
+		// it failed.
+		var n int
+		if err := m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+" WHERE id = ?", id).Scan(&n); err != nil {
+			return id, false, err
+		}
+		return id, n > 0, nil
+	}
+	id = uid.New()
+	return id, false, m.record(ctx, kind, sourceID, id)
+}
+
+// record records that the item of kind with the ID sourceID was imported as
+// the row with the ID target.
+func (m *importMap) record(ctx context.Context, kind, sourceID string, target uid.ID) error {
+	query := "INSERT INTO imports (source, kind, source_id, target_id) VALUES (?, ?, ?, ?) " +
+		msql.UpsertClause([]string{"source", "kind", "source_id"}, "target_id")
+	_, err := m.db.ExecContext(ctx, query, m.source, kind, sourceID, target)
+	return err
+}
+
+// user returns the placeholder user of the author of item, creating it if it
+// doesn't exist.
+func (imp *communityImport) user(ctx context.Context, item *importer.Item) (*User, error) {
+	authorID, name := item.AuthorID, item.Author
+	if authorID == "" {
+		authorID, name = "[deleted]", "deleted"
+	}
+	if u := imp.users[authorID]; u != nil {
+		return u, nil
+	}
+
+	id, exists, err := imp.reserve(ctx, importKindUser, authorID, "users")
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := msql.Transact(ctx, imp.db, func(tx *sql.Tx) error {
+			return insertPlaceholderUser(ctx, tx, id, placeholderUsername(name, "imported"))
+		}); err != nil {
+			return nil, err
+		}
+		imp.stats.Users++
+	}
+	u, err := GetUser(ctx, imp.db, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(imp.users) >= maxImportCacheSize {
+		clear(imp.users)
+	}
+	imp.users[authorID] = u
+	return u, nil
+}
+
+// failed logs that item couldn't be imported.
+func (imp *communityImport) failed(ctx context.Context, item *importer.Item, err error) {
+	imp.stats.Failed++
+	logger.WarnContext(ctx, "Failed to import item", "kind", item.Kind, "id", item.ID, "err", err)
+}
+
+func (imp *communityImport) importPost(ctx context.Context, item *importer.Item) error {
+	id, exists, err := imp.reserve(ctx, importKindPost, item.ID, "posts")
+	if err != nil {
+		return err
+	}
+	if exists {
+		imp.stats.Skipped++
+		return nil
+	}
+	author, err := imp.user(ctx, item)
+	if err != nil {
+		return err
+	}
+
+	opts := &createPostOpts{
+		postType:  PostTypeText,
+		author:    author.ID,
+		community: imp.opts.Community,
+		title:     strings.TrimSpace(item.Title),
+		body:      strings.TrimSpace(item.Body),
+		id:        id,
+		createdAt: item.CreatedAt,
+		upvotes:   item.Upvotes,
+		downvotes: item.Downvotes,
+	}
+	if item.URL != "" {
+		// Media (images and videos hosted elsewhere) become link posts too,
+		// and thumbnails aren't fetched.
+		if link, err := parsePostLink(item.URL); err == nil {
+			opts.postType = PostTypeLink
+			opts.link = *link
+		}
+	}
+	if _, err := createPost(ctx, imp.db, opts); err != nil {
+		if !httperr.IsInternalServerError(err) {
+			imp.failed(ctx, item, err)
+			return nil
+		}
+		return err
+	}
+	imp.stats.Posts++
+	return nil
+}
+
+func (imp *communityImport) importComment(ctx context.Context, item *importer.Item) error {
+	postID, found, err := imp.lookup(ctx, importKindPost, item.PostID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		imp.stats.Orphaned++
+		return nil
+	}
+	var parentID *uid.ID
+	if item.ParentID != "" {
+		id, found, err := imp.lookup(ctx, importKindComment, item.ParentID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			imp.waiting[item.ParentID] = append(imp.waiting[item.ParentID], item)
+			return nil
+		}
+		parentID = &id
+	}
+
+	added, err := imp.addComment(ctx, item, postID, parentID)
+	if err != nil {
+		return err
+	}
+
+	// Add the replies to the comment that came before it.
+	replies := imp.waiting[item.ID]
+	delete(imp.waiting, item.ID)
+	for _, reply := range replies {
+		if !added {
+			imp.stats.Orphaned++
+			continue
+		}
+		if err := imp.importComment(ctx, reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addComment adds item, a comment of the post postID, and reports whether the
+// comment exists (whether it was added now or before).
+func (imp *communityImport) addComment(ctx context.Context, item *importer.Item, postID uid.ID, parentID *uid.ID) (bool, error) {
+	id, exists, err := imp.reserve(ctx, importKindComment, item.ID, "comments")
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		imp.stats.Skipped++
+		return true, nil
+	}
+	body := strings.TrimSpace(item.Body)
+	if body == "" {
+		imp.failed(ctx, item, errors.New("empty comment body"))
+		return false, nil
+	}
+
+	post := imp.posts[postID]
+	if post == nil {
+		if post, err = GetPost(ctx, imp.db, &postID, "", nil, true); err != nil {
+			if !httperr.IsInternalServerError(err) {
+				imp.failed(ctx, item, err)
+				return false, nil
+			}
+			return false, err
+		}
+		if len(imp.posts) >= maxImportCacheSize {
+			clear(imp.posts)
+		}
+		imp.posts[postID] = post
+	}
+	author, err := imp.user(ctx, item)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := addComment(ctx, imp.db, post, author, parentID, body, &addCommentOpts{
+		id:              id,
+		createdAt:       item.CreatedAt,
+		upvotes:         item.Upvotes,
+		downvotes:       item.Downvotes,
+		noNotifications: true,
+	}); err != nil {
+		if !httperr.IsInternalServerError(err) {
+			imp.failed(ctx, item, err)
+			return false, nil
+		}
+		return false, err
+	}
+	imp.stats.Comments++
+	return true, nil
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// InstanceStats is the public usage statistics of the site. Users of remote
+// (federated) servers, and their comments, are not counted.
+type InstanceStats struct {
+	Users          int
+	ActiveMonth    int // Users seen in the last 30 days.
+	ActiveHalfyear int // Users seen in the last 180 days.
+	Posts          int // Not deleted.
+	Comments       int // Not deleted.
+}
+
+// GetInstanceStats returns the public usage statistics of the site.
+func GetInstanceStats(ctx context.Context, db *sql.DB) (*InstanceStats, error) {
+	now := time.Now()
+	stats := &InstanceStats{}
+	row := db.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+			COALESCE(SUM(CASE WHEN last_seen >= ? THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN last_seen >= ? THEN 1 ELSE 0 END), 0)
+		FROM users
+		WHERE deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM activitypub_actors WHERE activitypub_actors.user_id = users.id)`,
+		now.AddDate(0, 0, -30), now.AddDate(0, 0, -180))
+	if err := row.Scan(&stats.Users, &stats.ActiveMonth, &stats.ActiveHalfyear); err != nil {
+		return nil, err
+	}
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM posts WHERE deleted = FALSE").Scan(&stats.Posts); err != nil {
+		return nil, err
+	}
+	if err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM comments
+		WHERE deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM activitypub_objects WHERE activitypub_objects.comment_id = comments.id)`).Scan(&stats.Comments); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
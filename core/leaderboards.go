@@ -0,0 +1,280 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// Kinds of leaderboards.
+const (
+	LeaderboardPosts    = "posts"
+	LeaderboardComments = "comments"
+	LeaderboardUsers    = "users" // By the points of their posts and comments.
+)
+
+// leaderboardSize is the number of entries kept in each leaderboard.
+const leaderboardSize = 100
+
+// leaderboardPeriod is a period of a leaderboard: the content created in the
+// last window (all content if it's zero) is ranked, at most every refresh.
+type leaderboardPeriod struct {
+	name    string
+	window  time.Duration
+	refresh time.Duration
+}
+
+var leaderboardPeriods = []leaderboardPeriod{
+	{"day", 24 * time.Hour, time.Hour},
+	{"week", 7 * 24 * time.Hour, time.Hour},
+	{"month", 30 * 24 * time.Hour, 3 * time.Hour},
+	{"year", 365 * 24 * time.Hour, 12 * time.Hour},
+	{"all", 0, 24 * time.Hour},
+}
+
+var errInvalidLeaderboard = httperr.NewBadRequest("invalid-leaderboard", "Invalid leaderboard kind or period.")
+
+// ValidLeaderboard reports whether kind and period name a leaderboard.
+func ValidLeaderboard(kind, period string) bool {
+	switch kind {
+	case LeaderboardPosts, LeaderboardComments, LeaderboardUsers:
+	default:
+		return false
+	}
+	for _, p := range leaderboardPeriods {
+		if p.name == period {
+			return true
+		}
+	}
+	return false
+}
+
+// leaderboardQuery returns the query ranking the items of the leaderboard of
+// kind, site-wide if byCommunity is false, or else in each community. The
+// query selects the community (or NULL), the item, and its score, of the top
+// items, with ties broken by the ID of the items (newer first). Its arguments
+// are the start of the period, once for each table queried, and the number of
+// items to rank.
+func leaderboardQuery(kind string, byCommunity bool) string {
+	var items string
+	switch kind {
+	case LeaderboardPosts:
+		items = "SELECT community_id, id AS item, points AS score FROM posts WHERE deleted = FALSE AND created_at >= ?"
+	case LeaderboardComments:
+		items = "SELECT community_id, id AS item, points AS score FROM comments WHERE deleted_at IS NULL AND created_at >= ?"
+	case LeaderboardUsers:
+		items = `
+			SELECT content.community_id, content.user_id AS item, SUM(content.points) AS score
+			FROM (
+				SELECT community_id, user_id, points FROM posts WHERE deleted = FALSE AND created_at >= ?
+				UNION ALL
+				SELECT community_id, user_id, points FROM comments WHERE deleted_at IS NULL AND created_at >= ?
+			) AS content
+			INNER JOIN users ON users.id = content.user_id
+			WHERE users.deleted_at IS NULL
+			GROUP BY content.community_id, content.user_id`
+		if !byCommunity {
+			items = "SELECT NULL AS community_id, item, SUM(score) AS score FROM (" + items + ") AS per_community GROUP BY item"
+		}
+	}
+	if !byCommunity {
+		return "SELECT NULL, item, score FROM (" + items + ") AS items ORDER BY score DESC, item DESC LIMIT ?"
+	}
+	return `
+		SELECT community_id, item, score FROM (
+			SELECT community_id, item, score, ROW_NUMBER() OVER (PARTITION BY community_id ORDER BY score DESC, item DESC) AS position
+			FROM (` + items + `) AS items
+		) AS ranked
+		WHERE position <= ?`
+}
+
+// ComputeLeaderboards recomputes the leaderboards, site-wide and of each
+// community, of the periods that are due. Call this function periodically.
+func ComputeLeaderboards(ctx context.Context, db *sql.DB) error {
+	now := time.Now()
+	for _, period := range leaderboardPeriods {
+		var last time.Time
+		err := db.QueryRowContext(ctx, "SELECT computed_at FROM leaderboards WHERE period = ? ORDER BY computed_at DESC LIMIT 1", period.name).Scan(&last)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if now.Sub(last) < period.refresh {
+			continue
+		}
+		since := time.Time{}
+		if period.window > 0 {
+			since = now.Add(-period.window)
+		}
+		for _, kind := range []string{LeaderboardPosts, LeaderboardComments, LeaderboardUsers} {
+			if err := computeLeaderboard(ctx, db, kind, period.name, since, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func computeLeaderboard(ctx context.Context, db *sql.DB, kind, period string, since, now time.Time) error {
+	type entry struct {
+		community uid.NullID
+		item      uid.ID
+		score     int
+	}
+	var entries []entry
+	for _, byCommunity := range []bool{false, true} {
+		args := []any{since}
+		if kind == LeaderboardUsers {
+			args = append(args, since)
+		}
+		args = append(args, leaderboardSize)
+		rows, err := db.QueryContext(ctx, leaderboardQuery(kind, byCommunity), args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var e entry
+			if err := rows.Scan(&e.community, &e.item, &e.score); err != nil {
+				rows.Close()
+				return err
+			}
+			entries = append(entries, e)
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	return msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM leaderboards WHERE kind = ? AND period = ?", kind, period); err != nil {
+			return err
+		}
+		// Entries are in the order of their ranks in each leaderboard.
+		ranks := make(map[uid.NullID]int)
+		for _, e := range entries {
+			ranks[e.community]++
+			if _, err := tx.ExecContext(ctx, `
+				INSERT INTO leaderboards (kind, period, community_id, position, item_id, score, computed_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				kind, period, e.community, ranks[e.community], e.item, e.score, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Leaderboard is the top posts, comments, or users of a period.
+type Leaderboard struct {
+	Kind       string              `json:"kind"`
+	Period     string              `json:"period"`
+	Entries    []*LeaderboardEntry `json:"entries"`
+	ComputedAt *time.Time          `json:"computedAt"` // Nil if it was never computed.
+}
+
+// LeaderboardEntry is an item of a leaderboard. Only one of Post, Comment, and
+// User is set, depending on the kind of the leaderboard.
+type LeaderboardEntry struct {
+	Rank    int      `json:"rank"`
+	Score   int      `json:"score"`
+	Post    *Post    `json:"post,omitempty"`
+	Comment *Comment `json:"comment,omitempty"`
+	User    *User    `json:"user,omitempty"`
+}
+
+// GetLeaderboard returns the top limit items of the leaderboard of kind and
+// period, site-wide if community is nil. Items that were deleted since the
+// leaderboard was computed are left out.
+func GetLeaderboard(ctx context.Context, db *sql.DB, kind, period string, community *uid.ID, limit int, viewer *uid.ID) (*Leaderboard, error) {
+	if !ValidLeaderboard(kind, period) {
+		return nil, errInvalidLeaderboard
+	}
+	query := "SELECT position, item_id, score, computed_at FROM leaderboards WHERE kind = ? AND period = ? AND community_id IS NULL ORDER BY position LIMIT ?"
+	args := []any{kind, period, limit}
+	if community != nil {
+		query = "SELECT position, item_id, score, computed_at FROM leaderboards WHERE kind = ? AND period = ? AND community_id = ? ORDER BY position LIMIT ?"
+		args = []any{kind, period, *community, limit}
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lb := &Leaderboard{Kind: kind, Period: period, Entries: []*LeaderboardEntry{}}
+	var ids []uid.ID
+	var entries []*LeaderboardEntry
+	for rows.Next() {
+		e := &LeaderboardEntry{}
+		var id uid.ID
+		var computedAt time.Time
+		if err := rows.Scan(&e.Rank, &id, &e.Score, &computedAt); err != nil {
+			return nil, err
+		}
+		lb.ComputedAt = &computedAt
+		ids = append(ids, id)
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return lb, nil
+	}
+
+	switch kind {
+	case LeaderboardPosts:
+		posts, err := getPostsList(ctx, db, viewer, ids...)
+		if err != nil && err != errPostNotFound {
+			return nil, err
+		}
+		m := make(map[uid.ID]*Post, len(posts))
+		for _, p := range posts {
+			if !p.Deleted {
+				m[p.ID] = p
+			}
+		}
+		for i, e := range entries {
+			if e.Post = m[ids[i]]; e.Post != nil {
+				lb.Entries = append(lb.Entries, e)
+			}
+		}
+	case LeaderboardComments:
+		comments, err := getCommentsList(ctx, db, viewer, ids)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[uid.ID]*Comment, len(comments))
+		for _, c := range comments {
+			if !c.Deleted() {
+				m[c.ID] = c
+			}
+		}
+		for i, e := range entries {
+			if e.Comment = m[ids[i]]; e.Comment != nil {
+				lb.Entries = append(lb.Entries, e)
+			}
+		}
+	case LeaderboardUsers:
+		users, err := GetUsersIDs(ctx, db, ids, viewer)
+		if err != nil && err != errUserNotFound {
+			return nil, err
+		}
+		m := make(map[uid.ID]*User, len(users))
+		for _, u := range users {
+			m[u.ID] = u
+		}
+		for i, e := range entries {
+			if e.User = m[ids[i]]; e.User != nil {
+				lb.Entries = append(lb.Entries, e)
+			}
+		}
+	}
+	return lb, nil
+}
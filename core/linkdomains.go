@@ -0,0 +1,341 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/httputil"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// maxShortenerRedirects is the number of redirects followed when resolving a
+// shortened link.
+const maxShortenerRedirects = 5
+
+// LinkDomainRule blocks, or, if Allow is true, allows, links to the domains
+// that match Pattern, either in a community or, if CommunityID is null, on the
+// whole site.
+//
+// A pattern without wildcards matches the domain itself and all its
+// subdomains (example.com matches both example.com and www.example.com). In a
+// pattern with wildcards, a * matches any number of characters (*.example.com
+// matches www.example.com but not example.com, and a lone * matches every
+// domain). An allow rule takes precedence over the block rules of its scope,
+// so that, for instance, a community may allow only a few domains by blocking
+// * and allowing them.
+type LinkDomainRule struct {
+	ID          int        `json:"id"`
+	CommunityID uid.NullID `json:"communityId"`
+	Pattern     string     `json:"pattern"`
+	Allow       bool       `json:"allow"`
+	CreatedBy   uid.ID     `json:"createdBy"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// normalizeLinkDomainPattern returns pattern in lower case, with the scheme
+// and the path removed (if pattern is a URL), or an error if it's not a valid
+// pattern.
+func normalizeLinkDomainPattern(pattern string) (string, error) {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if strings.Contains(pattern, "://") {
+		if u, err := url.Parse(pattern); err == nil {
+			pattern = u.Hostname()
+		}
+	}
+	pattern = strings.TrimSuffix(pattern, ".")
+	if pattern == "" || len(pattern) > 255 {
+		return "", httperr.NewBadRequest("link-domain/invalid-pattern", "Invalid domain pattern.")
+	}
+	for _, r := range pattern {
+		if !((r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '-' || r == '*') {
+			return "", httperr.NewBadRequest("link-domain/invalid-pattern", "Invalid domain pattern.")
+		}
+	}
+	return pattern, nil
+}
+
+// MatchLinkDomain reports whether the domain matches the (normalized) pattern
+// (see LinkDomainRule).
+func MatchLinkDomain(pattern, domain string) bool {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if !strings.Contains(pattern, "*") {
+		return domain == pattern || strings.HasSuffix(domain, "."+pattern)
+	}
+	// A normalized pattern has no special characters other than *, which
+	// path.Match matches against any sequence of characters (dots included).
+	matched, _ := path.Match(pattern, domain)
+	return matched
+}
+
+// GetLinkDomainRules returns the link domain rules of community, or, if
+// community is nil, the site-wide rules.
+func GetLinkDomainRules(ctx context.Context, db *sql.DB, community *uid.ID) ([]*LinkDomainRule, error) {
+	query := "SELECT id, community_id, pattern, allow, created_by, created_at FROM link_domain_rules WHERE community_id IS NULL ORDER BY pattern"
+	var args []any
+	if community != nil {
+		query = "SELECT id, community_id, pattern, allow, created_by, created_at FROM link_domain_rules WHERE community_id = ? ORDER BY pattern"
+		args = append(args, *community)
+	}
+	return scanLinkDomainRules(db.QueryContext(ctx, query, args...))
+}
+
+// FetchLinkDomainRules populates c.LinkDomainRules (so that the post composer
+// can tell which links are allowed in c).
+func (c *Community) FetchLinkDomainRules(ctx context.Context) error {
+	rules, err := GetLinkDomainRules(ctx, c.db, &c.ID)
+	if err != nil {
+		return err
+	}
+	c.LinkDomainRules = rules
+	return nil
+}
+
+func scanLinkDomainRules(rows *sql.Rows, err error) ([]*LinkDomainRule, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []*LinkDomainRule{}
+	for rows.Next() {
+		r := &LinkDomainRule{}
+		if err := rows.Scan(&r.ID, &r.CommunityID, &r.Pattern, &r.Allow, &r.CreatedBy, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// AddLinkDomainRule adds a rule that blocks, or, if allow is true, allows,
+// links to the domains matching pattern in community, or, if community is
+// nil, on the whole site. If a rule with the same pattern already exists in
+// the same scope, it's replaced.
+func AddLinkDomainRule(ctx context.Context, db *sql.DB, community *uid.ID, pattern string, allow bool, by uid.ID) (*LinkDomainRule, error) {
+	pattern, err := normalizeLinkDomainPattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &LinkDomainRule{
+		Pattern:   pattern,
+		Allow:     allow,
+		CreatedBy: by,
+		CreatedAt: time.Now(),
+	}
+	if community != nil {
+		r.CommunityID = uid.NullID{ID: *community, Valid: true}
+	}
+
+	deleteQuery, deleteArgs := "DELETE FROM link_domain_rules WHERE community_id IS NULL AND pattern = ?", []any{pattern}
+	if community != nil {
+		deleteQuery, deleteArgs = "DELETE FROM link_domain_rules WHERE community_id = ? AND pattern = ?", []any{*community, pattern}
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, deleteQuery, deleteArgs...); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	res, err := tx.ExecContext(ctx, "INSERT INTO link_domain_rules (community_id, pattern, allow, created_by, created_at) VALUES (?, ?, ?, ?, ?)",
+		r.CommunityID, r.Pattern, r.Allow, r.CreatedBy, r.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	r.ID = int(id)
+	return r, tx.Commit()
+}
+
+// DeleteLinkDomainRule removes the link domain rule with the ID id of
+// community (or, if community is nil, the site-wide rule).
+func DeleteLinkDomainRule(ctx context.Context, db *sql.DB, community *uid.ID, id int) error {
+	query, args := "DELETE FROM link_domain_rules WHERE id = ? AND community_id IS NULL", []any{id}
+	if community != nil {
+		query, args = "DELETE FROM link_domain_rules WHERE id = ? AND community_id = ?", []any{id, *community}
+	}
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return httperr.NewNotFound("link-domain/rule-not-found", "Rule not found.")
+	}
+	return nil
+}
+
+// blockingLinkDomainRule returns the block rule, out of rules (which are all
+// of the same scope), that blocks domain, or nil if none does or if an allow
+// rule allows it.
+func blockingLinkDomainRule(rules []*LinkDomainRule, domain string) *LinkDomainRule {
+	var block *LinkDomainRule
+	for _, r := range rules {
+		if MatchLinkDomain(r.Pattern, domain) {
+			if r.Allow {
+				return nil
+			}
+			if block == nil {
+				block = r
+			}
+		}
+	}
+	return block
+}
+
+// isShortener reports whether domain matches one of shorteners (which are
+// domain patterns).
+func isShortener(shorteners []string, domain string) bool {
+	for _, pattern := range shorteners {
+		if MatchLinkDomain(pattern, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveShortLink returns the domains of the links that link, if it's to one
+// of shorteners, redirects to, up until the first link that's not to one of
+// shorteners (which is not requested). The domains found are returned even if
+// following the redirects fails.
+func resolveShortLink(ctx context.Context, link *url.URL, shorteners []string) ([]string, error) {
+	var domains []string
+	for i := 0; isShortener(shorteners, link.Hostname()); i++ {
+		if i == maxShortenerRedirects {
+			return domains, errors.New("too many redirects")
+		}
+		next, err := httputil.Redirect(ctx, link.String())
+		if err != nil {
+			return domains, err
+		}
+		if next == "" {
+			break
+		}
+		if link, err = link.Parse(next); err != nil {
+			return domains, err
+		}
+		domains = append(domains, strings.ToLower(link.Hostname()))
+	}
+	return domains, nil
+}
+
+// CheckLinkDomains returns an error if link cannot be posted in community
+// because of the site-wide or the community's link domain rules. If link is to
+// one of shorteners (which are domain patterns), the domains of the links it
+// redirects to are checked as well.
+func CheckLinkDomains(ctx context.Context, db *sql.DB, community uid.ID, link string, shorteners []string) error {
+	pl, err := parsePostLink(link)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(pl.URL)
+	if err != nil {
+		return err
+	}
+	domains := []string{strings.ToLower(pl.Hostname)}
+	resolved, resolveErr := resolveShortLink(ctx, u, shorteners)
+	domains = append(domains, resolved...)
+
+	rules, err := scanLinkDomainRules(db.QueryContext(ctx,
+		"SELECT id, community_id, pattern, allow, created_by, created_at FROM link_domain_rules WHERE community_id IS NULL OR community_id = ?", community))
+	if err != nil {
+		return err
+	}
+	var site, comm []*LinkDomainRule
+	for _, r := range rules {
+		if r.CommunityID.Valid {
+			comm = append(comm, r)
+		} else {
+			site = append(site, r)
+		}
+	}
+	for _, domain := range domains {
+		if blockingLinkDomainRule(site, domain) != nil {
+			return &httperr.Error{
+				HTTPStatus: http.StatusForbidden,
+				Code:       "link/domain-blocked",
+				Message:    fmt.Sprintf("Links to %s are not allowed on this site.", domain),
+			}
+		}
+		if blockingLinkDomainRule(comm, domain) != nil {
+			return &httperr.Error{
+				HTTPStatus: http.StatusForbidden,
+				Code:       "link/domain-blocked-in-community",
+				Message:    fmt.Sprintf("Links to %s are not allowed in this community.", domain),
+			}
+		}
+	}
+
+	if resolveErr != nil {
+		// Where the link leads cannot be checked.
+		logger.WarnContext(ctx, "Failed to resolve shortened link", "url", pl.URL, "err", resolveErr)
+		return httperr.NewBadRequest("link/unresolvable", "The shortened link could not be followed. Please post the full link instead.")
+	}
+	return nil
+}
+
+// LinkDomainStats is how often links to a domain were posted.
+type LinkDomainStats struct {
+	Domain       string `json:"domain"`
+	Posts        int    `json:"posts"`
+	Users        int    `json:"users"`        // The number of distinct users who posted them.
+	DeletedPosts int    `json:"deletedPosts"` // The number of them that were deleted.
+
+	// The ID of the site-wide rule that blocks the domain, if any.
+	BlockedBy *int `json:"blockedBy"`
+}
+
+// GetTopLinkDomains returns the limit domains most linked to, in link posts,
+// since the time since.
+func GetTopLinkDomains(ctx context.Context, db *sql.DB, since time.Time, limit int) ([]*LinkDomainStats, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT link_domain, COUNT(*), COUNT(DISTINCT user_id), SUM(CASE WHEN deleted THEN 1 ELSE 0 END)
+		FROM posts
+		WHERE link_domain IS NOT NULL AND created_at > ?
+		GROUP BY link_domain
+		ORDER BY COUNT(*) DESC, link_domain
+		LIMIT ?`, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []*LinkDomainStats{}
+	for rows.Next() {
+		s := &LinkDomainStats{}
+		if err := rows.Scan(&s.Domain, &s.Posts, &s.Users, &s.DeletedPosts); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rules, err := GetLinkDomainRules(ctx, db, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range stats {
+		if r := blockingLinkDomainRule(rules, s.Domain); r != nil {
+			s.BlockedBy = &r.ID
+		}
+	}
+	return stats, nil
+}
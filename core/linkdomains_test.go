@@ -0,0 +1,45 @@
+package core
+
+import "testing"
+
+func TestMatchLinkDomain(t *testing.T) {
+	tests := []struct {
+		pattern, domain string
+		expect          bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "www.example.com", true},
+		{"example.com", "EXAMPLE.com.", true},
+		{"example.com", "badexample.com", false},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "a.b.example.com", true},
+		{"*", "anything.org", true},
+		{"spam*.net", "spammy.net", true},
+		{"spam*.net", "www.spammy.net", false},
+	}
+	for _, test := range tests {
+		if got := MatchLinkDomain(test.pattern, test.domain); got != test.expect {
+			t.Errorf("MatchLinkDomain(%q, %q) = %v, expected %v", test.pattern, test.domain, got, test.expect)
+		}
+	}
+}
+
+func TestNormalizeLinkDomainPattern(t *testing.T) {
+	tests := []struct {
+		pattern, expect string
+		valid           bool
+	}{
+		{" Example.COM ", "example.com", true},
+		{"https://www.example.com/path?q=1", "www.example.com", true},
+		{"*.example.com", "*.example.com", true},
+		{"", "", false},
+		{"exa mple.com", "", false},
+		{"[a-z].com", "", false},
+	}
+	for _, test := range tests {
+		got, err := normalizeLinkDomainPattern(test.pattern)
+		if (err == nil) != test.valid || got != test.expect {
+			t.Errorf("normalizeLinkDomainPattern(%q) = %q, %v", test.pattern, got, err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// maxLinkedAccounts is the most accounts an account may be linked to.
+const maxLinkedAccounts = 10
+
+// A LinkedAccount is an account linked to another, which sessions of the
+// other can be switched to (and back) without logging in again.
+type LinkedAccount struct {
+	UserID   uid.ID    `json:"userId"`
+	Username string    `json:"username"`
+	LinkedAt time.Time `json:"linkedAt"`
+}
+
+// GetLinkedAccounts returns the accounts linked to user, in the order they
+// were linked.
+func GetLinkedAccounts(ctx context.Context, db *sql.DB, user uid.ID) ([]*LinkedAccount, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT users.id, users.username, linked_accounts.created_at
+		FROM linked_accounts
+		INNER JOIN users ON users.id = linked_accounts.linked_user_id
+		WHERE linked_accounts.user_id = ? AND users.deleted_at IS NULL
+		ORDER BY linked_accounts.created_at`, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []*LinkedAccount{}
+	for rows.Next() {
+		a := &LinkedAccount{}
+		if err := rows.Scan(&a.UserID, &a.Username, &a.LinkedAt); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// AccountsLinked reports whether the accounts user and other are linked. (If
+// they're not, ErrAccountNotLinked is the error to return.)
+func AccountsLinked(ctx context.Context, db *sql.DB, user, other uid.ID) (bool, error) {
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM linked_accounts WHERE user_id = ? AND linked_user_id = ?", user, other).Scan(&n)
+	return n > 0, err
+}
+
+// LinkAccounts links the accounts of user and other, the ownership of both of
+// which the caller is to have checked, on a request from ip. The link is
+// recorded as an auth event of both.
+func LinkAccounts(ctx context.Context, db *sql.DB, user, other *User, ip string) error {
+	if user.ID == other.ID {
+		return httperr.NewBadRequest("account-link-self", "An account cannot be linked to itself.")
+	}
+	if other.Banned {
+		return httperr.NewForbidden("account_suspended", "User account suspended.")
+	}
+
+	now := time.Now()
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		// The rows of both users are locked (in the order of their IDs, so
+		// that links made at the same time don't deadlock) so that concurrent
+		// links of either can't, between them, exceed maxLinkedAccounts.
+		rows, err := tx.QueryContext(ctx, "SELECT id FROM users WHERE id IN (?, ?) ORDER BY id"+msql.ForUpdate(), user.ID, other.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := scanIDs(rows); err != nil {
+			return err
+		}
+		for _, u := range []*User{user, other} {
+			var n int
+			if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM linked_accounts WHERE user_id = ?", u.ID).Scan(&n); err != nil {
+				return err
+			}
+			if n >= maxLinkedAccounts {
+				return errTooManyLinkedAccounts
+			}
+		}
+
+		for _, pair := range [][2]uid.ID{{user.ID, other.ID}, {other.ID, user.ID}} {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO linked_accounts (user_id, linked_user_id, created_at) VALUES (?, ?, ?)", pair[0], pair[1], now); err != nil {
+				if msql.IsErrDuplicateErr(err) {
+					return errAccountLinkedAlready
+				}
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := addAuthEvent(ctx, db, AuthEventAccountLinked, &user.ID, user.Username, ip, "to "+other.Username); err != nil {
+		return err
+	}
+	return addAuthEvent(ctx, db, AuthEventAccountLinked, &other.ID, other.Username, ip, "to "+user.Username)
+}
+
+// UnlinkAccounts unlinks the accounts user and other, on a request from ip.
+// Like the link, it's recorded as an auth event of both.
+func UnlinkAccounts(ctx context.Context, db *sql.DB, user, other *User, ip string) error {
+	res, err := db.ExecContext(ctx, "DELETE FROM linked_accounts WHERE (user_id = ? AND linked_user_id = ?) OR (user_id = ? AND linked_user_id = ?)", user.ID, other.ID, other.ID, user.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrAccountNotLinked
+	}
+
+	if err := addAuthEvent(ctx, db, AuthEventAccountUnlinked, &user.ID, user.Username, ip, "from "+other.Username); err != nil {
+		return err
+	}
+	return addAuthEvent(ctx, db, AuthEventAccountUnlinked, &other.ID, other.Username, ip, "from "+user.Username)
+}
+
+// RecordAccountSwitch records, as an auth event of to, that a session was
+// switched from the account from to its linked account to, on a request from
+// ip. It's what tells apart, in the log, what was done with either account.
+func RecordAccountSwitch(ctx context.Context, db *sql.DB, from, to *User, ip string) error {
+	return addAuthEvent(ctx, db, AuthEventAccountSwitched, &to.ID, to.Username, ip, "from "+from.Username)
+}
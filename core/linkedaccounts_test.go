@@ -0,0 +1,52 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestLinkAccounts(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteTestDB(t)
+	var users []*User
+	for i := 0; i <= maxLinkedAccounts+1; i++ {
+		u, err := RegisterUser(ctx, db, fmt.Sprintf("user%d", i), "", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, u)
+	}
+
+	user := users[0]
+	for _, other := range users[1 : maxLinkedAccounts+1] {
+		if err := LinkAccounts(ctx, db, user, other, "127.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := LinkAccounts(ctx, db, user, users[maxLinkedAccounts+1], "127.0.0.1"); err != errTooManyLinkedAccounts {
+		t.Errorf("linking one account too many returned %v (expected errTooManyLinkedAccounts)", err)
+	}
+
+	other := users[1]
+	if err := UnlinkAccounts(ctx, db, user, other, "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if linked, err := AccountsLinked(ctx, db, user.ID, other.ID); err != nil {
+		t.Fatal(err)
+	} else if linked {
+		t.Error("accounts linked after being unlinked")
+	}
+	if err := UnlinkAccounts(ctx, db, user, other, "127.0.0.1"); err != ErrAccountNotLinked {
+		t.Errorf("unlinking accounts not linked returned %v (expected ErrAccountNotLinked)", err)
+	}
+	for _, u := range []*User{user, other} {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM auth_events WHERE kind = ? AND user_id = ?", AuthEventAccountUnlinked, u.ID).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("%d unlink auth events of %s (expected 1)", n, u.Username)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package core
+
+import "github.com/discuitnet/discuit/internal/logging"
+
+var (
+	logger      = logging.Logger("core")
+	notifLogger = logging.Logger("notifications")
+)
@@ -0,0 +1,348 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// The kinds of auth events.
+const (
+	AuthEventLoginFailed = "login_failed"
+	AuthEventLockedOut   = "locked_out"
+	AuthEventNewDevice   = "new_device"
+
+	// An account was linked to another (or unlinked), or a session was
+	// switched to it from another (see LinkAccounts).
+	AuthEventAccountLinked   = "account_linked"
+	AuthEventAccountUnlinked = "account_unlinked"
+	AuthEventAccountSwitched = "account_switched"
+)
+
+// authEventsTTL is how long auth events are kept.
+const authEventsTTL = 30 * 24 * time.Hour
+
+// LoginThrottlePolicy determines how repeated failed logins, to an account or
+// from an IP address, are slowed down and locked out.
+type LoginThrottlePolicy struct {
+	Window time.Duration // How far back failed logins are counted.
+
+	// After DelayAfter failed logins to an account, each further attempt is
+	// delayed, by a second the first time and twice as long each time after
+	// that, up to MaxDelay. Zero means attempts are never delayed.
+	DelayAfter int
+	MaxDelay   time.Duration
+
+	// After AccountLockout failed logins to an account, or IPLockout failed
+	// logins from an IP address (to any account), logins to the account or
+	// from the address are refused until Lockout has passed since the last
+	// failed login. Zero means no lockout.
+	AccountLockout int
+	IPLockout      int
+	Lockout        time.Duration
+}
+
+// LoginThrottle is how a login attempt is to be throttled.
+type LoginThrottle struct {
+	Delay       time.Duration // How long to wait before checking the password.
+	LockedUntil time.Time     // Zero if logins are not locked out.
+}
+
+// Err returns an error if the login attempt is to be refused.
+func (t *LoginThrottle) Err() error {
+	if t.LockedUntil.IsZero() {
+		return nil
+	}
+	minutes := int(time.Until(t.LockedUntil).Minutes()) + 1
+	return httperr.NewTooManyRequests("login/locked-out", fmt.Sprintf("Too many failed login attempts. Try again in %d minutes.", minutes), time.Until(t.LockedUntil))
+}
+
+// normalizeLoginUsername returns the username as typed in a login attempt in
+// the form in which failed logins are recorded.
+func normalizeLoginUsername(username string) string {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if len(username) > 64 {
+		username = username[:64]
+	}
+	return username
+}
+
+// loginFailures returns the number of failed logins to username since the
+// time since and since the last successful login to the account (if any), and
+// the time of the last one. If username is empty, the failed logins from ip
+// (to any account) are counted instead.
+func loginFailures(ctx context.Context, db *sql.DB, username, ip string, since time.Time) (n int, last time.Time, err error) {
+	col, val := "username", username
+	if username == "" {
+		col, val = "ip", ip
+	} else {
+		var seen time.Time
+		err = db.QueryRowContext(ctx, `
+			SELECT login_devices.last_seen_at
+			FROM login_devices
+			INNER JOIN users ON users.id = login_devices.user_id
+			WHERE users.username_lc = ?
+			ORDER BY login_devices.last_seen_at DESC
+			LIMIT 1`, username).Scan(&seen)
+		if err != nil && err != sql.ErrNoRows {
+			return
+		}
+		if seen.After(since) {
+			since = seen
+		}
+	}
+	query := "SELECT created_at FROM auth_events WHERE kind = ? AND " + col + " = ? AND created_at > ? ORDER BY created_at DESC"
+	rows, err := db.QueryContext(ctx, query, AuthEventLoginFailed, val, since)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t time.Time
+		if err = rows.Scan(&t); err != nil {
+			return
+		}
+		if n == 0 {
+			last = t
+		}
+		n++
+	}
+	err = rows.Err()
+	return
+}
+
+// CheckLoginThrottle returns how an attempt to log in to username from ip, on
+// device, is to be throttled as per policy. Attempts from the devices the
+// account was logged in from before are exempt from the account's lockout and
+// delays (so that others cannot lock out its owner), but not from the lockout
+// of ip.
+func CheckLoginThrottle(ctx context.Context, db *sql.DB, username, ip, device string, policy *LoginThrottlePolicy) (*LoginThrottle, error) {
+	throttle := &LoginThrottle{}
+	since := time.Now().Add(-policy.Window)
+	username = normalizeLoginUsername(username)
+
+	var known int
+	if err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM login_devices
+		INNER JOIN users ON users.id = login_devices.user_id
+		WHERE users.username_lc = ? AND login_devices.device = ?`, username, device).Scan(&known); err != nil {
+		return nil, err
+	}
+
+	n, last, err := loginFailures(ctx, db, username, ip, since)
+	if err != nil {
+		return nil, err
+	}
+	if known > 0 {
+		n = 0
+	}
+	if policy.AccountLockout > 0 && n >= policy.AccountLockout {
+		if until := last.Add(policy.Lockout); until.After(time.Now()) {
+			throttle.LockedUntil = until
+		}
+	}
+	if policy.DelayAfter > 0 && n >= policy.DelayAfter {
+		throttle.Delay = policy.MaxDelay
+		if shift := n - policy.DelayAfter; shift < 16 {
+			throttle.Delay = min(time.Second<<shift, policy.MaxDelay)
+		}
+	}
+
+	if policy.IPLockout > 0 {
+		n, last, err := loginFailures(ctx, db, "", ip, since)
+		if err != nil {
+			return nil, err
+		}
+		if n >= policy.IPLockout {
+			if until := last.Add(policy.Lockout); until.After(throttle.LockedUntil) && until.After(time.Now()) {
+				throttle.LockedUntil = until
+			}
+		}
+	}
+	return throttle, nil
+}
+
+func addAuthEvent(ctx context.Context, db *sql.DB, kind string, user *uid.ID, username, ip, detail string) error {
+	var userID uid.NullID
+	if user != nil {
+		userID = uid.NullID{ID: *user, Valid: true}
+	}
+	var nullDetail sql.NullString
+	if detail != "" {
+		if len(detail) > 512 {
+			detail = detail[:512]
+		}
+		nullDetail = sql.NullString{String: detail, Valid: true}
+	}
+	_, err := db.ExecContext(ctx, "INSERT INTO auth_events (kind, user_id, username, ip, detail, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		kind, userID, normalizeLoginUsername(username), ip, nullDetail, time.Now())
+	return err
+}
+
+// RecordLoginFailure records a failed attempt to log in to username from ip.
+// If the failure locks out the account or the address, as per policy, a
+// lockout is recorded as well and locked is true.
+func RecordLoginFailure(ctx context.Context, db *sql.DB, username, ip string, policy *LoginThrottlePolicy) (locked bool, err error) {
+	var user *uid.ID
+	if u, err := GetUserByUsername(ctx, db, username, nil); err == nil {
+		user = &u.ID
+	}
+	if err := addAuthEvent(ctx, db, AuthEventLoginFailed, user, username, ip, ""); err != nil {
+		return false, err
+	}
+
+	since := time.Now().Add(-policy.Window)
+	n, _, err := loginFailures(ctx, db, normalizeLoginUsername(username), ip, since)
+	if err != nil {
+		return false, err
+	}
+	if policy.AccountLockout > 0 && n == policy.AccountLockout {
+		return true, addAuthEvent(ctx, db, AuthEventLockedOut, user, username, ip, fmt.Sprintf("%d failed logins to the account", n))
+	}
+	if policy.IPLockout > 0 {
+		n, _, err := loginFailures(ctx, db, "", ip, since)
+		if err != nil {
+			return false, err
+		}
+		if n == policy.IPLockout {
+			return true, addAuthEvent(ctx, db, AuthEventLockedOut, user, username, ip, fmt.Sprintf("%d failed logins from the IP address", n))
+		}
+	}
+	return false, nil
+}
+
+// RecordLogin records that user logged in from device (an opaque identifier
+// of the user agent and the network) at the IP address ip. It returns true if
+// it's the first login from device, and user had logged in from other devices
+// before (in which case the login is recorded as an auth event).
+func RecordLogin(ctx context.Context, db *sql.DB, user *User, device, ip, userAgent string) (newDevice bool, err error) {
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	now := time.Now()
+	res, err := db.ExecContext(ctx, "UPDATE login_devices SET ip = ?, last_seen_at = ? WHERE user_id = ? AND device = ?", ip, now, user.ID, device)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return false, err
+	} else if n > 0 {
+		return false, nil
+	}
+
+	var others int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM login_devices WHERE user_id = ?", user.ID).Scan(&others); err != nil {
+		return false, err
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO login_devices (user_id, device, ip, user_agent, created_at, last_seen_at) VALUES (?, ?, ?, ?, ?, ?)",
+		user.ID, device, ip, userAgent, now, now); err != nil {
+		return false, err
+	}
+	if others == 0 {
+		return false, nil
+	}
+	return true, addAuthEvent(ctx, db, AuthEventNewDevice, &user.ID, user.Username, ip, userAgent)
+}
+
+// AuthEvent is a failed login, a lockout, or a login from a new device.
+type AuthEvent struct {
+	ID        int        `json:"id"`
+	Kind      string     `json:"kind"`
+	UserID    uid.NullID `json:"userId"`
+	Username  string     `json:"username"` // As typed, in lower case.
+	IP        string     `json:"ip"`
+	Detail    string     `json:"detail"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// LoginFailureCount is the number of failed logins to an account or from an
+// IP address.
+type LoginFailureCount struct {
+	Key      string `json:"key"` // The username or the IP address.
+	Failures int    `json:"failures"`
+
+	// The number of distinct IP addresses the failed logins to an account
+	// came from, or of distinct usernames tried from an IP address.
+	Distinct int `json:"distinct"`
+}
+
+// AuthAnomalies are the signs of credential stuffing and of account takeovers
+// in a period of time.
+type AuthAnomalies struct {
+	FailuresByUsername []*LoginFailureCount `json:"failuresByUsername"`
+	FailuresByIP       []*LoginFailureCount `json:"failuresByIp"`
+	Events             []*AuthEvent         `json:"events"` // Lockouts and logins from new devices, newest first.
+}
+
+func getLoginFailureCounts(ctx context.Context, db *sql.DB, col, other string, since time.Time, limit int) ([]*LoginFailureCount, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+col+`, COUNT(*), COUNT(DISTINCT `+other+`)
+		FROM auth_events
+		WHERE kind = ? AND created_at > ?
+		GROUP BY `+col+`
+		ORDER BY COUNT(*) DESC, `+col+`
+		LIMIT ?`, AuthEventLoginFailed, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*LoginFailureCount{}
+	for rows.Next() {
+		c := &LoginFailureCount{}
+		if err := rows.Scan(&c.Key, &c.Failures, &c.Distinct); err != nil {
+			return nil, err
+		}
+		items = append(items, c)
+	}
+	return items, rows.Err()
+}
+
+// GetAuthAnomalies returns the limit accounts and IP addresses with the most
+// failed logins since the time since, and the last limit lockouts and logins
+// from new devices.
+func GetAuthAnomalies(ctx context.Context, db *sql.DB, since time.Time, limit int) (*AuthAnomalies, error) {
+	a := &AuthAnomalies{}
+	var err error
+	if a.FailuresByUsername, err = getLoginFailureCounts(ctx, db, "username", "ip", since, limit); err != nil {
+		return nil, err
+	}
+	if a.FailuresByIP, err = getLoginFailureCounts(ctx, db, "ip", "username", since, limit); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, kind, user_id, username, ip, detail, created_at
+		FROM auth_events
+		WHERE kind <> ? AND created_at > ?
+		ORDER BY created_at DESC
+		LIMIT ?`, AuthEventLoginFailed, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	a.Events = []*AuthEvent{}
+	for rows.Next() {
+		e := &AuthEvent{}
+		var detail sql.NullString
+		if err := rows.Scan(&e.ID, &e.Kind, &e.UserID, &e.Username, &e.IP, &detail, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Detail = detail.String
+		a.Events = append(a.Events, e)
+	}
+	return a, rows.Err()
+}
+
+// PruneAuthEvents deletes the auth events that are too old to be of interest.
+func PruneAuthEvents(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM auth_events WHERE created_at < ?", time.Now().Add(-authEventsTTL))
+	return err
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/utils"
+)
+
+const maintenanceDBKey = "maintenance" // for the key column of the application_data table
+
+// maxMaintenanceMessageLength is the maximum length of the banner message of
+// maintenance mode.
+const maxMaintenanceMessageLength = 500
+
+// Maintenance is the maintenance mode of the site, set by the admins (or with
+// the maintenance command) during migrations, backups, and the like.
+type Maintenance struct {
+	// If true, the API accepts no changes (no posts, comments, votes, and so
+	// on) and only serves what's already there.
+	ReadOnly bool `json:"readOnly"`
+
+	// A banner message shown to users. It may be set without ReadOnly, to
+	// announce upcoming maintenance.
+	Message string `json:"message"`
+
+	// When the maintenance is expected to end (null if unknown).
+	EndsAt *time.Time `json:"endsAt"`
+
+	// When maintenance mode was last changed.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Active reports whether m is either read-only or has a message to show.
+func (m *Maintenance) Active() bool {
+	return m.ReadOnly || m.Message != ""
+}
+
+// GetMaintenance returns the maintenance mode of the site (which is off if it
+// was never set).
+func GetMaintenance(ctx context.Context, db *sql.DB) (*Maintenance, error) {
+	rawJSON := ""
+	row := db.QueryRowContext(ctx, "SELECT `value` FROM application_data WHERE `key` = ?", maintenanceDBKey)
+	if err := row.Scan(&rawJSON); err != nil {
+		if err == sql.ErrNoRows {
+			return &Maintenance{}, nil
+		}
+		return nil, err
+	}
+	m := &Maintenance{}
+	if err := json.Unmarshal([]byte(rawJSON), m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SaveMaintenance sets the maintenance mode of the site to m.
+func SaveMaintenance(ctx context.Context, db *sql.DB, m *Maintenance) error {
+	m.Message = utils.TruncateUnicodeString(m.Message, maxMaintenanceMessageLength)
+	m.UpdatedAt = time.Now()
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	query := "INSERT INTO application_data (`key`, `value`) VALUES (?, ?) " + msql.UpsertClause([]string{"`key`"}, "`value`")
+	_, err = db.ExecContext(ctx, query, maintenanceDBKey, string(data))
+	return err
+}
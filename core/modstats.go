@@ -0,0 +1,296 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// The actions of mods counted in the mod stats.
+const (
+	ModActionRemovePost              = "remove_post"
+	ModActionRemoveComment           = "remove_comment"
+	ModActionRemoveSubtree           = "remove_comment_subtree"
+	ModActionLockPost                = "lock_post"
+	ModActionUnlockPost              = "unlock_post"
+	ModActionPinPost                 = "pin_post"
+	ModActionBanUser                 = "ban_user"
+	ModActionUnbanUser               = "unban_user"
+	ModActionUpholdReport            = "uphold_report"
+	ModActionDismissReport           = "dismiss_report"
+	ModActionDistinguish             = "distinguish"
+	ModActionUndistinguish           = "undistinguish"
+	ModActionAddApprovedSubmitter    = "add_approved_submitter"
+	ModActionRemoveApprovedSubmitter = "remove_approved_submitter"
+)
+
+// modStatsTTL is how long the records of the mod stats are kept.
+const modStatsTTL = 90 * 24 * time.Hour
+
+// RecordModAction records that mod took action in community.
+func RecordModAction(ctx context.Context, db *sql.DB, community, mod uid.ID, action string) error {
+	return RecordBulkModAction(ctx, db, community, mod, action, 1)
+}
+
+// RecordBulkModAction records that mod took action, on count things at once,
+// in community. It's a single action in the mod stats.
+func RecordBulkModAction(ctx context.Context, db *sql.DB, community, mod uid.ID, action string, count int) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO mod_actions (community_id, user_id, action, count, created_at) VALUES (?, ?, ?, ?, ?)", community, mod, action, count, time.Now())
+	return err
+}
+
+// RecordModActionOn is RecordModAction for an action on target (a post, a
+// comment, or a user), which is recorded so that the record can be deleted if
+// the action is undone (see UndoModAction).
+func RecordModActionOn(ctx context.Context, db *sql.DB, community, mod uid.ID, action string, target uid.ID) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO mod_actions (community_id, user_id, action, target_id, created_at) VALUES (?, ?, ?, ?, ?)", community, mod, action, target, time.Now())
+	return err
+}
+
+// reportResolution is a report, about to be deleted, of a community.
+type reportResolution struct {
+	community  uid.ID
+	reportedAt time.Time
+}
+
+// addReportResolutions records that mod resolved reports.
+func addReportResolutions(ctx context.Context, db *sql.DB, mod uid.ID, upheld bool, reports []reportResolution) error {
+	now := time.Now()
+	for _, r := range reports {
+		if _, err := db.ExecContext(ctx, `
+			INSERT INTO report_resolutions (community_id, user_id, upheld, reported_at, resolved_at)
+			VALUES (?, ?, ?, ?, ?)`, r.community, mod, upheld, r.reportedAt, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SnapshotModQueues records the number of open reports of each community.
+// Call this function hourly.
+func SnapshotModQueues(ctx context.Context, db *sql.DB) error {
+	hour := time.Now().UTC().Truncate(time.Hour)
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO mod_queue_snapshots (community_id, hour, open_reports)
+		SELECT community_id, ?, COUNT(*) FROM reports WHERE to_admins = FALSE GROUP BY community_id `+
+		msql.UpsertClause([]string{"community_id", "hour"}, "open_reports"), hour)
+	return err
+}
+
+// PruneModStats deletes the records of the mod stats older than modStatsTTL.
+func PruneModStats(ctx context.Context, db *sql.DB) error {
+	t := time.Now().Add(-modStatsTTL)
+	for _, query := range []string{
+		"DELETE FROM mod_actions WHERE created_at < ?",
+		"DELETE FROM report_resolutions WHERE resolved_at < ?",
+		"DELETE FROM mod_queue_snapshots WHERE hour < ?",
+	} {
+		if _, err := db.ExecContext(ctx, query, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ModActivity is the workload of a mod (or an admin) of a community.
+type ModActivity struct {
+	UserID       uid.ID         `json:"userId"`
+	Username     string         `json:"username"`
+	Mod          bool           `json:"isMod"` // False for admins who are not mods.
+	Actions      map[string]int `json:"actions"`
+	TotalActions int            `json:"totalActions"`
+
+	ReportsResolved int `json:"reportsResolved"`
+
+	// The median time, in seconds, the reports the mod resolved had been open.
+	MedianTimeToResolution *int64 `json:"medianTimeToResolution"`
+
+	LastActionAt *time.Time `json:"lastActionAt"` // Within the period.
+}
+
+// ModQueuePoint is the largest number of open reports of a community in a day.
+type ModQueuePoint struct {
+	Day         time.Time `json:"day"`
+	OpenReports int       `json:"openReports"`
+}
+
+// ModStats is the workload of the mods of a community in a period.
+type ModStats struct {
+	Since time.Time      `json:"since"`
+	Mods  []*ModActivity `json:"mods"` // Every mod, even those who took no action.
+
+	Actions map[string]int `json:"actions"` // Of all mods.
+
+	ReportsResolved        int    `json:"reportsResolved"`
+	ReportsUpheld          int    `json:"reportsUpheld"`
+	MedianTimeToResolution *int64 `json:"medianTimeToResolution"` // In seconds.
+
+	OpenReports      int        `json:"openReports"`
+	OldestOpenReport *time.Time `json:"oldestOpenReport"`
+
+	// The open reports in each day, as of the hourly snapshots. Days without
+	// snapshots had no open reports (or were before snapshots were taken).
+	Backlog []*ModQueuePoint `json:"backlog"`
+}
+
+// medianDuration returns the median of ds, in seconds, or nil if ds is empty.
+// It sorts ds.
+func medianDuration(ds []time.Duration) *int64 {
+	if len(ds) == 0 {
+		return nil
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	m := ds[len(ds)/2]
+	if len(ds)%2 == 0 {
+		m = (ds[len(ds)/2-1] + ds[len(ds)/2]) / 2
+	}
+	s := int64(m / time.Second)
+	return &s
+}
+
+// GetModStats returns the workload of the mods of community since since.
+func GetModStats(ctx context.Context, db *sql.DB, community uid.ID, since time.Time) (*ModStats, error) {
+	stats := &ModStats{Since: since, Mods: []*ModActivity{}, Actions: make(map[string]int), Backlog: []*ModQueuePoint{}}
+
+	mods, err := GetCommunityMods(ctx, db, community)
+	if err != nil {
+		return nil, err
+	}
+	byUser := make(map[uid.ID]*ModActivity)
+	activity := func(user uid.ID) *ModActivity {
+		a := byUser[user]
+		if a == nil {
+			a = &ModActivity{UserID: user, Actions: make(map[string]int)}
+			byUser[user] = a
+			stats.Mods = append(stats.Mods, a)
+		}
+		return a
+	}
+	for _, mod := range mods {
+		a := activity(mod.ID)
+		a.Username, a.Mod = mod.Username, true
+	}
+
+	// Actions.
+	rows, err := db.QueryContext(ctx, `
+		SELECT mod_actions.user_id, users.username, mod_actions.action, COUNT(*)
+		FROM mod_actions
+		INNER JOIN users ON users.id = mod_actions.user_id
+		WHERE mod_actions.community_id = ? AND mod_actions.created_at >= ?
+		GROUP BY mod_actions.user_id, users.username, mod_actions.action`, community, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			user     uid.ID
+			username string
+			action   string
+			count    int
+		)
+		if err := rows.Scan(&user, &username, &action, &count); err != nil {
+			return nil, err
+		}
+		a := activity(user)
+		a.Username = username
+		a.Actions[action] += count
+		a.TotalActions += count
+		stats.Actions[action] += count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, a := range stats.Mods {
+		if a.TotalActions == 0 {
+			continue
+		}
+		var last time.Time
+		if err := db.QueryRowContext(ctx, `
+			SELECT created_at FROM mod_actions
+			WHERE community_id = ? AND user_id = ? AND created_at >= ?
+			ORDER BY created_at DESC LIMIT 1`, community, a.UserID, since).Scan(&last); err != nil {
+			return nil, err
+		}
+		a.LastActionAt = &last
+	}
+
+	// Report resolutions.
+	rows, err = db.QueryContext(ctx, `
+		SELECT user_id, upheld, reported_at, resolved_at
+		FROM report_resolutions
+		WHERE community_id = ? AND resolved_at >= ?
+		LIMIT 100000`, community, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []time.Duration
+	perMod := make(map[uid.ID][]time.Duration)
+	for rows.Next() {
+		var (
+			user                   uid.ID
+			upheld                 bool
+			reportedAt, resolvedAt time.Time
+		)
+		if err := rows.Scan(&user, &upheld, &reportedAt, &resolvedAt); err != nil {
+			return nil, err
+		}
+		d := resolvedAt.Sub(reportedAt)
+		all = append(all, d)
+		perMod[user] = append(perMod[user], d)
+		stats.ReportsResolved++
+		if upheld {
+			stats.ReportsUpheld++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	stats.MedianTimeToResolution = medianDuration(all)
+	for user, ds := range perMod {
+		if a := byUser[user]; a != nil {
+			a.ReportsResolved = len(ds)
+			a.MedianTimeToResolution = medianDuration(ds)
+		}
+	}
+
+	// Backlog.
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM reports WHERE community_id = ? AND to_admins = FALSE", community).Scan(&stats.OpenReports); err != nil {
+		return nil, err
+	}
+	if stats.OpenReports > 0 {
+		var oldest time.Time
+		if err := db.QueryRowContext(ctx, "SELECT created_at FROM reports WHERE community_id = ? AND to_admins = FALSE ORDER BY created_at LIMIT 1", community).Scan(&oldest); err != nil {
+			return nil, err
+		}
+		stats.OldestOpenReport = &oldest
+	}
+	rows, err = db.QueryContext(ctx, "SELECT hour, open_reports FROM mod_queue_snapshots WHERE community_id = ? AND hour >= ? ORDER BY hour", community, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hour time.Time
+		var open int
+		if err := rows.Scan(&hour, &open); err != nil {
+			return nil, err
+		}
+		day := hour.UTC().Truncate(24 * time.Hour)
+		if n := len(stats.Backlog); n > 0 && stats.Backlog[n-1].Day.Equal(day) {
+			stats.Backlog[n-1].OpenReports = max(stats.Backlog[n-1].OpenReports, open)
+			continue
+		}
+		stats.Backlog = append(stats.Backlog, &ModQueuePoint{Day: day, OpenReports: open})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,312 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// maxPendingModActionsBatch is the maximum number of pending mod actions
+// executed by a call to ExecutePendingModActions.
+const maxPendingModActionsBatch = 100
+
+// A PendingModAction is a destructive action of a mod (or an admin): the
+// removal of a post or a comment, a ban, or a lock. It takes effect right
+// away, but its side effects (the notification of the author, and the
+// resolving of reports) are held back until ExecuteAt, and until then it can
+// be undone (see UndoModAction), which leaves no trace of it. After that, it
+// takes the reverse action (an unban, say) to reverse it.
+type PendingModAction struct {
+	ID          int       `json:"id"`
+	CommunityID uid.ID    `json:"communityId"`
+	ModID       uid.ID    `json:"modId"`
+	ModGroup    UserGroup `json:"modGroup"` // In what capacity the action was taken.
+	Action      string    `json:"action"`   // One of ModActionRemovePost, ModActionRemoveComment, ModActionBanUser, and ModActionLockPost.
+	TargetID    uid.ID    `json:"targetId"` // The post, the comment, or the user banned.
+	ExecuteAt   time.Time `json:"executeAt"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+var selectPendingModActionCols = []string{
+	"id",
+	"community_id",
+	"mod_id",
+	"mod_group",
+	"action",
+	"target_id",
+	"execute_at",
+	"created_at",
+}
+
+func scanPendingModActions(rows *sql.Rows) ([]*PendingModAction, error) {
+	defer rows.Close()
+	var as []*PendingModAction
+	for rows.Next() {
+		a := &PendingModAction{}
+		if err := rows.Scan(&a.ID, &a.CommunityID, &a.ModID, &a.ModGroup, &a.Action, &a.TargetID, &a.ExecuteAt, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		as = append(as, a)
+	}
+	return as, rows.Err()
+}
+
+// queueModActionTx queues a, to be executed after window, setting its ID,
+// ExecuteAt, and CreatedAt.
+func queueModActionTx(ctx context.Context, tx *sql.Tx, a *PendingModAction, window time.Duration) error {
+	a.CreatedAt = time.Now()
+	a.ExecuteAt = a.CreatedAt.Add(window)
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO pending_mod_actions (community_id, mod_id, mod_group, action, target_id, execute_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, a.CommunityID, a.ModID, a.ModGroup, a.Action, a.TargetID, a.ExecuteAt, a.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	a.ID = int(id)
+	return err
+}
+
+// QueueModAction makes action (either ModActionBanUser or ModActionLockPost),
+// which mod took, in their capacity as g, on target in community, undoable
+// for window. The removal of posts and comments are made undoable with
+// Post.DeleteUndoable and Comment.DeleteUndoable.
+func QueueModAction(ctx context.Context, db *sql.DB, community, mod uid.ID, g UserGroup, action string, target uid.ID, window time.Duration) (*PendingModAction, error) {
+	if action != ModActionBanUser && action != ModActionLockPost {
+		return nil, fmt.Errorf("mod action %s cannot be queued", action)
+	}
+	a := &PendingModAction{
+		CommunityID: community,
+		ModID:       mod,
+		ModGroup:    g,
+		Action:      action,
+		TargetID:    target,
+	}
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		return queueModActionTx(ctx, tx, a, window)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// GetPendingModActions returns the mod actions of community that can still be
+// undone, the latest first.
+func GetPendingModActions(ctx context.Context, db *sql.DB, community uid.ID) ([]*PendingModAction, error) {
+	query := msql.BuildSelectQuery("pending_mod_actions", selectPendingModActionCols, nil, "WHERE community_id = ? AND execute_at > ? ORDER BY id DESC")
+	rows, err := db.QueryContext(ctx, query, community, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return scanPendingModActions(rows)
+}
+
+// takePendingModAction deletes the pending mod action a from the queue, in
+// tx, reporting whether it was still there (and not taken by another
+// process).
+func takePendingModAction(ctx context.Context, tx *sql.Tx, a *PendingModAction) (bool, error) {
+	res, err := tx.ExecContext(ctx, "DELETE FROM pending_mod_actions WHERE id = ?", a.ID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UndoModAction undoes the pending mod action id of community, if it can still
+// be undone, and returns it. The action is removed from the mod stats.
+func UndoModAction(ctx context.Context, db *sql.DB, community uid.ID, id int) (*PendingModAction, error) {
+	query := msql.BuildSelectQuery("pending_mod_actions", selectPendingModActionCols, nil, "WHERE id = ? AND community_id = ? AND execute_at > ?")
+	rows, err := db.QueryContext(ctx, query, id, community, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	as, err := scanPendingModActions(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(as) == 0 {
+		return nil, errModActionNotPending
+	}
+	a := as[0]
+
+	var post uid.ID // Of the comment removed.
+	err = msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if taken, err := takePendingModAction(ctx, tx, a); err != nil {
+			return err
+		} else if !taken {
+			return errModActionNotPending // Executed, or undone, concurrently.
+		}
+		switch a.Action {
+		case ModActionRemovePost:
+			err = undoPostRemovalTx(ctx, tx, a.TargetID)
+		case ModActionRemoveComment:
+			post, err = undoCommentRemovalTx(ctx, tx, a.TargetID)
+		case ModActionBanUser:
+			_, err = tx.ExecContext(ctx, "DELETE FROM community_banned WHERE community_id = ? AND user_id = ?", a.CommunityID, a.TargetID)
+		case ModActionLockPost:
+			_, err = tx.ExecContext(ctx, "UPDATE posts SET "+unlockPostSet+" WHERE id = ?", false, UserGroupNaN, a.TargetID)
+		default:
+			err = fmt.Errorf("unknown pending mod action %s", a.Action)
+		}
+		if err != nil {
+			return err
+		}
+		return deleteModActionRecordTx(ctx, tx, a)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Action == ModActionRemoveComment {
+		invalidateCommentSnapshots(ctx, db, post)
+	}
+	return a, nil
+}
+
+// undoPostRemovalTx restores post, which was removed (without its content).
+func undoPostRemovalTx(ctx context.Context, tx *sql.Tx, post uid.ID) error {
+	var (
+		community, author uid.ID
+		points            int
+		createdAt         time.Time
+	)
+	err := tx.QueryRowContext(ctx, "SELECT community_id, user_id, points, created_at FROM posts WHERE id = ? AND deleted = TRUE AND deleted_content = FALSE", post).
+		Scan(&community, &author, &points, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errModActionNotUndoable // Its content was deleted since.
+		}
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE posts SET deleted = FALSE, deleted_at = NULL, deleted_by = NULL, deleted_as = ? WHERE id = ?", UserGroupNaN, post); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET no_posts = no_posts + 1 WHERE id = ?", author); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM deleted_content_archive WHERE target_type = ? AND target_id = ?", ReportTypePost, post); err != nil {
+		return err
+	}
+	for i, table := range postsTables {
+		if createdAt.Before(time.Now().Add(postsTablesValidity[i])) {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (community_id, post_id, user_id, points, created_at) VALUES (?, ?, ?, ?, ?)", table),
+			community, post, author, points, createdAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// undoCommentRemovalTx restores comment, which was removed, and returns the ID
+// of its post.
+func undoCommentRemovalTx(ctx context.Context, tx *sql.Tx, comment uid.ID) (uid.ID, error) {
+	var post, author uid.ID
+	if err := tx.QueryRowContext(ctx, "SELECT post_id, user_id FROM comments WHERE id = ? AND deleted_at IS NOT NULL", comment).Scan(&post, &author); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return post, errModActionNotUndoable
+		}
+		return post, err
+	}
+
+	var body string
+	if err := tx.QueryRowContext(ctx, "SELECT body FROM deleted_content_archive WHERE target_type = ? AND target_id = ? ORDER BY id DESC LIMIT 1", ReportTypeComment, comment).Scan(&body); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return post, errModActionNotUndoable // The body was purged.
+		}
+		return post, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE comments SET body = ?, deleted_at = NULL, deleted_by = NULL, deleted_as = ? WHERE id = ?", body, UserGroupNaN, comment); err != nil {
+		return post, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO posts_comments (target_id, user_id, target_type) VALUES (?, ?, ?)", comment, author, postsCommentsTypeComments); err != nil {
+		return post, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET no_comments = no_comments + 1 WHERE id = ?", author); err != nil {
+		return post, err
+	}
+	_, err := tx.ExecContext(ctx, "DELETE FROM deleted_content_archive WHERE target_type = ? AND target_id = ?", ReportTypeComment, comment)
+	return post, err
+}
+
+// deleteModActionRecordTx deletes the record of a in the mod stats (see
+// RecordModActionOn), if there's one.
+func deleteModActionRecordTx(ctx context.Context, tx *sql.Tx, a *PendingModAction) error {
+	var id int
+	err := tx.QueryRowContext(ctx, "SELECT id FROM mod_actions WHERE community_id = ? AND user_id = ? AND action = ? AND target_id = ? AND created_at >= ? ORDER BY id DESC LIMIT 1",
+		a.CommunityID, a.ModID, a.Action, a.TargetID, a.CreatedAt).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM mod_actions WHERE id = ?", id)
+	return err
+}
+
+// ExecutePendingModActions carries out the side effects of the pending mod
+// actions whose undo windows have passed. It returns the number of actions
+// executed.
+func ExecutePendingModActions(ctx context.Context, db *sql.DB) (int, error) {
+	query := msql.BuildSelectQuery("pending_mod_actions", selectPendingModActionCols, nil, "WHERE execute_at <= ? ORDER BY id LIMIT ?")
+	rows, err := db.QueryContext(ctx, query, time.Now(), maxPendingModActionsBatch)
+	if err != nil {
+		return 0, err
+	}
+	as, err := scanPendingModActions(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, a := range as {
+		var taken bool
+		err := msql.Transact(ctx, db, func(tx *sql.Tx) (err error) {
+			if taken, err = takePendingModAction(ctx, tx, a); err != nil || !taken {
+				return err
+			}
+			if a.Action != ModActionRemovePost {
+				return nil
+			}
+			var author uid.ID
+			if err := tx.QueryRowContext(ctx, "SELECT user_id FROM posts WHERE id = ?", a.TargetID).Scan(&author); err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return nil
+				}
+				return err
+			}
+			return enqueueNotificationTx(ctx, tx, &outboxNotification{
+				Type:      NotificationTypeDeletePost,
+				User:      author,
+				Post:      uid.NullID{Valid: true, ID: a.TargetID},
+				DeletedAs: a.ModGroup,
+			})
+		})
+		if err != nil {
+			return n, err
+		}
+		if !taken {
+			continue
+		}
+		switch a.Action {
+		case ModActionRemovePost:
+			afterPostRemoval(ctx, db, a.CommunityID, a.TargetID, a.ModID, true)
+		case ModActionRemoveComment:
+			afterCommentRemoval(ctx, db, a.CommunityID, a.TargetID, a.ModID)
+			RemoveAllReportsOfComment(ctx, db, a.TargetID)
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestDeleteModActionRecordTx(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLiteTestDB(t)
+	community, mod := uid.New(), uid.New()
+	commentA, commentB := uid.New(), uid.New()
+
+	// The mod removes comment A and then comment B, both within the undo
+	// window, and undoes the removal of B.
+	queuedAt := time.Now().Add(-time.Second)
+	for _, target := range []uid.ID{commentA, commentB} {
+		if err := RecordModActionOn(ctx, db, community, mod, ModActionRemoveComment, target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	undone := &PendingModAction{CommunityID: community, ModID: mod, Action: ModActionRemoveComment, TargetID: commentB, CreatedAt: queuedAt}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteModActionRecordTx(ctx, tx, undone); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query("SELECT target_id FROM mod_actions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, err := scanIDs(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 || targets[0] != commentA {
+		t.Errorf("got the records of %v, want only that of comment A (%v)", targets, commentA)
+	}
+}
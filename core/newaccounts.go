@@ -0,0 +1,154 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+)
+
+// NewAccountPolicy restricts what accounts that are younger than MinAge, or
+// that have fewer than MinPoints points, may post. Admins, and the mods and
+// approved submitters of a community (within that community), are not
+// restricted.
+type NewAccountPolicy struct {
+	MinAge    time.Duration
+	MinPoints int
+
+	MaxPostsPerHour    int  // Zero means no limit.
+	MaxCommentsPerHour int  // Zero means no limit.
+	NoLinkPosts        bool // If true, new accounts cannot create link posts.
+
+	// The names of the communities in which new accounts cannot post or
+	// comment.
+	RestrictedCommunities []string
+}
+
+// NewAccountRestrictions are the restrictions that apply to a new account (so
+// that clients can explain them).
+type NewAccountRestrictions struct {
+	// The account is restricted until both of these hold.
+	OldEnoughAt time.Time `json:"oldEnoughAt"`
+	MinPoints   int       `json:"minPoints"`
+
+	MaxPostsPerHour       int      `json:"maxPostsPerHour"`    // Zero means no limit.
+	MaxCommentsPerHour    int      `json:"maxCommentsPerHour"` // Zero means no limit.
+	NoLinkPosts           bool     `json:"noLinkPosts"`
+	RestrictedCommunities []string `json:"restrictedCommunities"`
+}
+
+// IsNew reports whether u is a new account, as per p.
+func (p *NewAccountPolicy) IsNew(u *User) bool {
+	if p == nil || u.Admin {
+		return false
+	}
+	return (p.MinAge > 0 && time.Since(u.CreatedAt) < p.MinAge) || (p.MinPoints > 0 && u.Points < p.MinPoints)
+}
+
+// Restrictions returns the restrictions that apply to u, or nil if u is not a
+// new account.
+func (p *NewAccountPolicy) Restrictions(u *User) *NewAccountRestrictions {
+	if !p.IsNew(u) {
+		return nil
+	}
+	communities := p.RestrictedCommunities
+	if communities == nil {
+		communities = []string{}
+	}
+	return &NewAccountRestrictions{
+		OldEnoughAt:           u.CreatedAt.Add(p.MinAge),
+		MinPoints:             p.MinPoints,
+		MaxPostsPerHour:       p.MaxPostsPerHour,
+		MaxCommentsPerHour:    p.MaxCommentsPerHour,
+		NoLinkPosts:           p.NoLinkPosts,
+		RestrictedCommunities: communities,
+	}
+}
+
+// newAccountError returns the error of a new account being restricted, with
+// message explaining why, and code, prefixed with new-account/.
+func (p *NewAccountPolicy) newAccountError(status int, code, message string) error {
+	var until []string
+	if p.MinAge > 0 {
+		until = append(until, fmt.Sprintf("is %d days old", int(p.MinAge.Hours()/24)))
+	}
+	if p.MinPoints > 0 {
+		until = append(until, fmt.Sprintf("has %d points", p.MinPoints))
+	}
+	return &httperr.Error{
+		HTTPStatus: status,
+		Code:       "new-account/" + code,
+		Message:    message + " This restriction is lifted once your account " + strings.Join(until, " and ") + ".",
+	}
+}
+
+// checkCommunity returns an error if new accounts cannot post in community.
+func (p *NewAccountPolicy) checkCommunity(community *Community) error {
+	for _, name := range p.RestrictedCommunities {
+		if strings.EqualFold(name, community.Name) {
+			return p.newAccountError(http.StatusForbidden, "community-restricted", fmt.Sprintf("New accounts cannot post in %s.", community.Name))
+		}
+	}
+	return nil
+}
+
+// exempt reports whether the new account u is exempt from the restrictions in
+// community (which it is, if it's a mod or an approved submitter of it).
+func (p *NewAccountPolicy) exempt(ctx context.Context, db *sql.DB, u *User, community *Community) (bool, error) {
+	if !p.IsNew(u) {
+		return true, nil
+	}
+	if is, err := UserMod(ctx, db, community.ID, u.ID); err != nil || is {
+		return is, err
+	}
+	return IsApprovedSubmitter(ctx, db, community.ID, u.ID)
+}
+
+// CheckPost returns an error if u cannot create a post of type postType in
+// community because it's a new account.
+func (p *NewAccountPolicy) CheckPost(ctx context.Context, db *sql.DB, u *User, community *Community, postType PostType) error {
+	if exempt, err := p.exempt(ctx, db, u, community); err != nil || exempt {
+		return err
+	}
+	if err := p.checkCommunity(community); err != nil {
+		return err
+	}
+	if p.NoLinkPosts && postType == PostTypeLink {
+		return p.newAccountError(http.StatusForbidden, "no-link-posts", "New accounts cannot create link posts.")
+	}
+	if p.MaxPostsPerHour > 0 {
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM posts WHERE user_id = ? AND created_at > ?", u.ID, time.Now().Add(-time.Hour)).Scan(&n); err != nil {
+			return err
+		}
+		if n >= p.MaxPostsPerHour {
+			return p.newAccountError(http.StatusTooManyRequests, "post-limit", fmt.Sprintf("New accounts can create at most %d posts an hour.", p.MaxPostsPerHour))
+		}
+	}
+	return nil
+}
+
+// CheckComment returns an error if u cannot comment in community because it's
+// a new account.
+func (p *NewAccountPolicy) CheckComment(ctx context.Context, db *sql.DB, u *User, community *Community) error {
+	if exempt, err := p.exempt(ctx, db, u, community); err != nil || exempt {
+		return err
+	}
+	if err := p.checkCommunity(community); err != nil {
+		return err
+	}
+	if p.MaxCommentsPerHour > 0 {
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM comments WHERE user_id = ? AND created_at > ?", u.ID, time.Now().Add(-time.Hour)).Scan(&n); err != nil {
+			return err
+		}
+		if n >= p.MaxCommentsPerHour {
+			return p.newAccountError(http.StatusTooManyRequests, "comment-limit", fmt.Sprintf("New accounts can post at most %d comments an hour.", p.MaxCommentsPerHour))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewAccountPolicyIsNew(t *testing.T) {
+	policy := &NewAccountPolicy{MinAge: 7 * 24 * time.Hour, MinPoints: 10}
+	cases := []struct {
+		name string
+		p    *NewAccountPolicy
+		u    *User
+		want bool
+	}{
+		{"young", policy, &User{CreatedAt: time.Now().Add(-time.Hour), Points: 100}, true},
+		{"few points", policy, &User{CreatedAt: time.Now().Add(-30 * 24 * time.Hour), Points: 3}, true},
+		{"old", policy, &User{CreatedAt: time.Now().Add(-30 * 24 * time.Hour), Points: 10}, false},
+		{"admin", policy, &User{CreatedAt: time.Now(), Admin: true}, false},
+		{"no policy", nil, &User{CreatedAt: time.Now()}, false},
+	}
+	for _, c := range cases {
+		if got := c.p.IsNew(c.u); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -6,33 +6,55 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log"
 	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/SherClockHolmes/webpush-go"
 	msql "github.com/discuitnet/discuit/internal/sql"
 	"github.com/discuitnet/discuit/internal/uid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// pushConfig is how the web push notifications of a site are sent.
+type pushConfig struct {
+	keys  VAPIDKeys // The site's own (see GetApplicationVAPIDKeys).
+	email string    // Of the webmaster.
+}
+
 var (
-	pushMutex         sync.RWMutex // guards the following
-	pushNotifsEnabled = false
-	webmasterEmail    = ""
-	vapidKeys         = &VAPIDKeys{}
+	pushMutex sync.RWMutex // guards the following
+
+	// The push configs of the sites of the process with push notifications
+	// enabled, by their databases (each site has a database of its own, which
+	// is what the notifications of a site are sent with).
+	pushConfigs = make(map[*sql.DB]*pushConfig)
 )
 
-// EnablePushNotifications enables sending web push notifications. The email
-// address is the email of the webmaster.
-func EnablePushNotifications(keys *VAPIDKeys, email string) {
+// EnablePushNotifications enables sending web push notifications, signed with
+// keys, for the site with the database db. The email address is the email of
+// the webmaster.
+func EnablePushNotifications(db *sql.DB, keys *VAPIDKeys, email string) {
 	pushMutex.Lock()
 	defer pushMutex.Unlock()
+	pushConfigs[db] = &pushConfig{keys: *keys, email: email}
+}
 
-	pushNotifsEnabled = true
-	vapidKeys = keys
-	webmasterEmail = email
+// DisablePushNotifications undoes EnablePushNotifications.
+func DisablePushNotifications(db *sql.DB) {
+	pushMutex.Lock()
+	defer pushMutex.Unlock()
+	delete(pushConfigs, db)
+}
+
+// getPushConfig returns the push config of the site with the database db, or
+// nil if push notifications are not enabled for it.
+func getPushConfig(db *sql.DB) *pushConfig {
+	pushMutex.RLock()
+	defer pushMutex.RUnlock()
+	return pushConfigs[db]
 }
 
 const MaxNotificationsPerUser = 200
@@ -216,6 +238,10 @@ func removeExcessNotifications(ctx context.Context, db *sql.DB, user uid.ID) (n
 
 // CreateNotification adds a new notification to user's notifications stack.
 func CreateNotification(ctx context.Context, db *sql.DB, user uid.ID, Type NotificationType, notif notification) error {
+	if !allowNotification(ctx, db, user, Type) {
+		return nil
+	}
+
 	data, err := json.Marshal(notif)
 	if err != nil {
 		return err
@@ -232,16 +258,16 @@ func CreateNotification(ctx context.Context, db *sql.DB, user uid.ID, Type Notif
 	}
 
 	if _, err := removeExcessNotifications(ctx, db, user); err != nil { // attempt
-		log.Println("Failed removing excess notifications: ", err)
+		notifLogger.ErrorContext(ctx, "Failed to remove excess notifications", "err", err, "user", user)
 	}
 	if err := updateNewNotificationsCount(ctx, db, user); err != nil { // attempt
-		log.Println("Failed incrementing users.notifications_new_count: ", err)
+		notifLogger.ErrorContext(ctx, "Failed to update users.notifications_new_count", "err", err, "user", user)
 	}
 
 	sendPushNotif := func() {
 		notif, err := GetNotification(ctx, db, strconv.Itoa(int(lastID)))
 		if err != nil {
-			log.Println("Error getting notification (CreateNotification)", err)
+			notifLogger.ErrorContext(ctx, "Failed to get notification", "err", err, "notification", lastID)
 			return
 		}
 		notif.SendPushNotification(ctx)
@@ -325,6 +351,128 @@ func GetNotifications(ctx context.Context, db *sql.DB, user uid.ID, limit int, c
 	return notifs, "", err
 }
 
+// PopulateNotifications fetches, in batches, the posts, comments, communities,
+// and users that notifs refer to, so that marshaling notifs to JSON does not
+// result in a database query per notification. Items that cannot be found are
+// left to be fetched (and to fail) at marshal time.
+func PopulateNotifications(ctx context.Context, db *sql.DB, notifs []*Notification) (err error) {
+	ctx, span := startSpan(ctx, "core.PopulateNotifications", attribute.Int("count", len(notifs)))
+	defer func() { endSpan(span, err) }()
+
+	var (
+		postIDs, commentIDs, userIDs []uid.ID
+		communityNames               []string
+	)
+	for _, notif := range notifs {
+		switch n := notif.Notif.(type) {
+		case *NotificationNewComment:
+			postIDs = append(postIDs, n.PostID)
+		case *NotificationCommentReply:
+			postIDs = append(postIDs, n.PostID)
+		case *NotificationNewVotes:
+			if n.TargetType == "post" {
+				postIDs = append(postIDs, n.TargetID)
+			} else {
+				commentIDs = append(commentIDs, n.TargetID)
+			}
+		case *NotificationPostDeleted:
+			if n.TargetType == "post" {
+				postIDs = append(postIDs, n.TargetID)
+			} else {
+				commentIDs = append(commentIDs, n.TargetID)
+			}
+		case *NotificationModAdd:
+			communityNames = append(communityNames, n.CommunityName)
+		case *NotificationNewBadge:
+			userIDs = append(userIDs, n.UserID)
+		}
+	}
+
+	comments := make(map[uid.ID]*Comment)
+	if len(commentIDs) > 0 {
+		list, err := getCommentsList(ctx, db, nil, uniqueIDs(commentIDs))
+		if err != nil {
+			return err
+		}
+		for _, c := range list {
+			comments[c.ID] = c
+			postIDs = append(postIDs, c.PostID) // for new_votes notifications
+		}
+	}
+
+	posts := make(map[uid.ID]*Post)
+	if len(postIDs) > 0 {
+		list, err := getPostsList(ctx, db, nil, uniqueIDs(postIDs)...)
+		if err != nil && err != errPostNotFound {
+			return err
+		}
+		for _, p := range list {
+			posts[p.ID] = p
+		}
+	}
+
+	communities := make(map[string]*Community)
+	if len(communityNames) > 0 {
+		list, err := getCommunitiesByNames(ctx, db, communityNames, nil)
+		if err != nil {
+			return err
+		}
+		for _, c := range list {
+			communities[c.NameLowerCase] = c
+		}
+	}
+
+	users := make(map[uid.ID]*User)
+	if len(userIDs) > 0 {
+		list, err := GetUsersIDs(ctx, db, uniqueIDs(userIDs), nil)
+		if err != nil && err != errUserNotFound {
+			return err
+		}
+		for _, u := range list {
+			users[u.ID] = u
+		}
+	}
+
+	for _, notif := range notifs {
+		switch n := notif.Notif.(type) {
+		case *NotificationNewComment:
+			n.post = posts[n.PostID]
+		case *NotificationCommentReply:
+			n.post = posts[n.PostID]
+		case *NotificationNewVotes:
+			if n.TargetType == "post" {
+				n.post = posts[n.TargetID]
+			} else if n.comment = comments[n.TargetID]; n.comment != nil {
+				n.post = posts[n.comment.PostID]
+			}
+		case *NotificationPostDeleted:
+			if n.TargetType == "post" {
+				n.post = posts[n.TargetID]
+			} else {
+				n.comment = comments[n.TargetID]
+			}
+		case *NotificationModAdd:
+			n.community = communities[strings.ToLower(n.CommunityName)]
+		case *NotificationNewBadge:
+			n.user = users[n.UserID]
+		}
+	}
+	return nil
+}
+
+// uniqueIDs returns ids with the duplicates removed.
+func uniqueIDs(ids []uid.ID) []uid.ID {
+	found := make(map[uid.ID]bool)
+	var unique []uid.ID
+	for _, id := range ids {
+		if !found[id] {
+			unique = append(unique, id)
+			found[id] = true
+		}
+	}
+	return unique
+}
+
 // NotificationsCount returns the number of notifications of user.
 func NotificationsCount(ctx context.Context, db *sql.DB, user uid.ID) (n int, err error) {
 	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM notifications WHERE user_id = ?", user).Scan(&n)
@@ -367,20 +515,38 @@ func (n *Notification) Update(ctx context.Context) error {
 	}
 
 	if err := updateNewNotificationsCount(ctx, n.db, n.UserID); err != nil {
-		log.Println("Failed incrementing users.notifications_new_count: ", err)
+		notifLogger.ErrorContext(ctx, "Failed to update users.notifications_new_count", "err", err, "user", n.UserID)
 	}
 
 	n.SendPushNotification(ctx)
 	return nil
 }
 
-// SendPushNotification sends the notification to all matching sessions. Call
-// EnablePushNotifications before any calls to this method.
+// SendPushNotification sends the notification to all matching sessions, or,
+// if the user is in quiet hours, defers it until they end. Call
+// EnablePushNotifications (with the database of n) before any calls to this
+// method.
 func (n *Notification) SendPushNotification(ctx context.Context) error {
 	if n.Type == NotificationTypeUpvote { // no push notifications for upvotes, for the moment
 		return nil
 	}
 
+	if getPushConfig(n.db) == nil {
+		return nil
+	}
+
+	if until, err := userQuietHoursEnd(ctx, n.db, n.UserID); err != nil {
+		return err
+	} else if !until.IsZero() {
+		return n.deferPushNotification(ctx, until)
+	}
+	return n.sendPushNotification(ctx)
+}
+
+// sendPushNotification sends the notification to all matching sessions right
+// away.
+func (n *Notification) sendPushNotification(ctx context.Context) error {
+
 	topic := strconv.Itoa(n.ID)
 	copy := *n // shallow copy of n
 	copy.Notif = nil
@@ -390,20 +556,15 @@ func (n *Notification) SendPushNotification(ctx context.Context) error {
 		return err
 	}
 
-	pushMutex.RLock()
-	enabled := pushNotifsEnabled
-	email := webmasterEmail
-	keys := *vapidKeys
-	pushMutex.RUnlock()
-
-	if !enabled {
+	conf := getPushConfig(n.db)
+	if conf == nil {
 		return nil
 	}
 
 	return SendPushNotification(ctx, n.db, n.UserID, data, &webpush.Options{
-		Subscriber:      email,
-		VAPIDPublicKey:  keys.Public,
-		VAPIDPrivateKey: keys.Private,
+		Subscriber:      conf.email,
+		VAPIDPublicKey:  conf.keys.Public,
+		VAPIDPrivateKey: conf.keys.Private,
 		TTL:             30,
 		Topic:           topic, // For collapsing comments
 	})
@@ -430,6 +591,8 @@ type NotificationNewComment struct {
 	// comments that this notification refers to (all the comments after this
 	// time and before comment seen time)
 	FirstCreatedAt time.Time `json:"firstCreatedAt"`
+
+	post *Post // Set by PopulateNotifications.
 }
 
 func (n NotificationNewComment) marshalJSONForAPI(ctx context.Context, db *sql.DB) ([]byte, error) {
@@ -438,15 +601,17 @@ func (n NotificationNewComment) marshalJSONForAPI(ctx context.Context, db *sql.D
 		T
 		Post *Post `json:"post"`
 	}{
-		T: (T)(n),
+		T:    (T)(n),
+		Post: n.post,
 	}
 
-	var err error
-	post, err := GetPost(ctx, db, &n.PostID, "", nil, true)
-	if err != nil {
-		return nil, err
+	if out.Post == nil {
+		post, err := GetPost(ctx, db, &n.PostID, "", nil, true)
+		if err != nil {
+			return nil, err
+		}
+		out.Post = post
 	}
-	out.Post = post
 	return json.Marshal(out)
 }
 
@@ -502,6 +667,8 @@ type NotificationCommentReply struct {
 	// comments that this notification refers to (all the comments after this
 	// time and before comment seen time)
 	FirstCreatedAt time.Time `json:"firstCreatedAt"`
+
+	post *Post // Set by PopulateNotifications.
 }
 
 func (n NotificationCommentReply) marshalJSONForAPI(ctx context.Context, db *sql.DB) ([]byte, error) {
@@ -510,15 +677,17 @@ func (n NotificationCommentReply) marshalJSONForAPI(ctx context.Context, db *sql
 		T
 		Post *Post `json:"post"`
 	}{
-		T: (T)(n),
+		T:    (T)(n),
+		Post: n.post,
 	}
 
-	var err error
-	post, err := GetPost(ctx, db, &n.PostID, "", nil, true)
-	if err != nil {
-		return nil, err
+	if out.Post == nil {
+		post, err := GetPost(ctx, db, &n.PostID, "", nil, true)
+		if err != nil {
+			return nil, err
+		}
+		out.Post = post
 	}
-	out.Post = post
 	return json.Marshal(out)
 }
 
@@ -592,6 +761,10 @@ type NotificationNewVotes struct {
 	TargetType string `json:"targetType"` // post or comment
 	TargetID   uid.ID `json:"targetId"`
 	NoVotes    int    `json:"noVotes"`
+
+	// Set by PopulateNotifications.
+	post    *Post
+	comment *Comment
 }
 
 func (n NotificationNewVotes) marshalJSONForAPI(ctx context.Context, db *sql.DB) ([]byte, error) {
@@ -601,27 +774,35 @@ func (n NotificationNewVotes) marshalJSONForAPI(ctx context.Context, db *sql.DB)
 		Post    *Post    `json:"post,omitempty"`
 		Comment *Comment `json:"comment,omitempty"`
 	}{
-		T: (T)(n),
+		T:       (T)(n),
+		Post:    n.post,
+		Comment: n.comment,
 	}
 
 	if n.TargetType == "post" {
-		post, err := GetPost(ctx, db, &n.TargetID, "", nil, true)
-		if err != nil {
-			return nil, err
+		if out.Post == nil {
+			post, err := GetPost(ctx, db, &n.TargetID, "", nil, true)
+			if err != nil {
+				return nil, err
+			}
+			out.Post = post
 		}
-		out.Post = post
 	} else {
-		comment, err := GetComment(ctx, db, n.TargetID, nil)
-		if err != nil {
-			return nil, err
+		if out.Comment == nil {
+			comment, err := GetComment(ctx, db, n.TargetID, nil)
+			if err != nil {
+				return nil, err
+			}
+			out.Comment = comment
 		}
-		out.Comment = comment
 
-		post, err := GetPost(ctx, db, &comment.PostID, "", nil, true)
-		if err != nil {
-			return nil, err
+		if out.Post == nil {
+			post, err := GetPost(ctx, db, &out.Comment.PostID, "", nil, true)
+			if err != nil {
+				return nil, err
+			}
+			out.Post = post
 		}
-		out.Post = post
 	}
 	return json.Marshal(out)
 }
@@ -667,6 +848,10 @@ type NotificationPostDeleted struct {
 	TargetType string    `json:"targetType"` // post or comment
 	TargetID   uid.ID    `json:"targetId"`
 	DeletedAs  UserGroup `json:"deletedAs"`
+
+	// Set by PopulateNotifications.
+	post    *Post
+	comment *Comment
 }
 
 func (n NotificationPostDeleted) marshalJSONForAPI(ctx context.Context, db *sql.DB) ([]byte, error) {
@@ -680,17 +865,23 @@ func (n NotificationPostDeleted) marshalJSONForAPI(ctx context.Context, db *sql.
 	}
 
 	if n.TargetType == "post" {
-		post, err := GetPost(ctx, db, &n.TargetID, "", nil, true)
-		if err != nil {
-			return nil, err
+		out.Post = n.post
+		if out.Post == nil {
+			post, err := GetPost(ctx, db, &n.TargetID, "", nil, true)
+			if err != nil {
+				return nil, err
+			}
+			out.Post = post
 		}
-		out.Post = post
 	} else {
-		comment, err := GetComment(ctx, db, n.TargetID, nil)
-		if err != nil {
-			return nil, err
+		out.Comment = n.comment
+		if out.Comment == nil {
+			comment, err := GetComment(ctx, db, n.TargetID, nil)
+			if err != nil {
+				return nil, err
+			}
+			out.Comment = comment
 		}
-		out.Comment = comment
 	}
 	return json.Marshal(out)
 }
@@ -715,6 +906,8 @@ func CreatePostDeletedNotification(ctx context.Context, db *sql.DB, user uid.ID,
 type NotificationModAdd struct {
 	CommunityName string `json:"communityName"`
 	AddedBy       string `json:"addedBy"`
+
+	community *Community // Set by PopulateNotifications.
 }
 
 func (n NotificationModAdd) marshalJSONForAPI(ctx context.Context, db *sql.DB) ([]byte, error) {
@@ -723,14 +916,17 @@ func (n NotificationModAdd) marshalJSONForAPI(ctx context.Context, db *sql.DB) (
 		T
 		Community *Community `json:"community"`
 	}{
-		T: (T)(n),
+		T:         (T)(n),
+		Community: n.community,
 	}
 
-	c, err := GetCommunityByName(ctx, db, n.CommunityName, nil)
-	if err != nil {
-		return nil, err
+	if out.Community == nil {
+		c, err := GetCommunityByName(ctx, db, n.CommunityName, nil)
+		if err != nil {
+			return nil, err
+		}
+		out.Community = c
 	}
-	out.Community = c
 	return json.Marshal(out)
 }
 
@@ -816,8 +1012,8 @@ func SaveWebPushSubscription(ctx context.Context, db *sql.DB, sessionID string,
 		return err
 	}
 	_, err = db.ExecContext(ctx, `INSERT INTO web_push_subscriptions (session_id, user_id, push_subscription) 
-		VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE push_subscription = ?, updated_at = CURRENT_TIMESTAMP()`,
-		sessionID, user, rawJSON, rawJSON)
+		VALUES (?, ?, ?) `+msql.OnConflictUpdate("session_id")+`push_subscription = ?, updated_at = ?`,
+		sessionID, user, rawJSON, rawJSON, time.Now())
 
 	return err
 }
@@ -893,12 +1089,17 @@ func SendPushNotification(ctx context.Context, db *sql.DB, user uid.ID, payload
 type NotificationNewBadge struct {
 	UserID    uid.ID `json:"userId"`
 	BadgeType string `json:"badgeType"`
+
+	user *User // Set by PopulateNotifications.
 }
 
 func (n NotificationNewBadge) marshalJSONForAPI(ctx context.Context, db *sql.DB) ([]byte, error) {
-	user, err := GetUser(ctx, db, n.UserID, nil)
-	if err != nil {
-		return nil, err
+	user := n.user
+	if user == nil {
+		var err error
+		if user, err = GetUser(ctx, db, n.UserID, nil); err != nil {
+			return nil, err
+		}
 	}
 	out := struct {
 		BadgeType string `json:"badgeType"`
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// countingDriver is a database/sql driver that returns no rows for every query
+// while keeping count of the queries run.
+type countingDriver struct {
+	mu      sync.Mutex
+	queries int
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) { return &countingConn{d: d}, nil }
+
+func (d *countingDriver) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.queries
+}
+
+type countingConn struct{ d *countingDriver }
+
+func (c *countingConn) Prepare(query string) (driver.Stmt, error) { return &countingStmt{d: c.d}, nil }
+func (c *countingConn) Close() error                              { return nil }
+func (c *countingConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+
+type countingStmt struct{ d *countingDriver }
+
+func (s *countingStmt) Close() error  { return nil }
+func (s *countingStmt) NumInput() int { return -1 }
+func (s *countingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+func (s *countingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	s.d.queries++
+	s.d.mu.Unlock()
+	return emptyRows{}, nil
+}
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string              { return nil }
+func (emptyRows) Close() error                   { return nil }
+func (emptyRows) Next(dest []driver.Value) error { return io.EOF }
+
+func newCountingDB(t *testing.T) (*sql.DB, *countingDriver) {
+	d := &countingDriver{}
+	name := "counting_" + t.Name()
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, d
+}
+
+func TestPopulateNotificationsQueryCount(t *testing.T) {
+	db, d := newCountingDB(t)
+	defer db.Close()
+
+	var notifs []*Notification
+	for i := 0; i < 10; i++ {
+		notifs = append(notifs,
+			&Notification{db: db, Type: NotificationTypeNewComment, Notif: &NotificationNewComment{PostID: uid.New()}},
+			&Notification{db: db, Type: NotificationTypeCommentReply, Notif: &NotificationCommentReply{PostID: uid.New()}},
+			&Notification{db: db, Type: NotificationTypeUpvote, Notif: &NotificationNewVotes{TargetType: "comment", TargetID: uid.New()}},
+			&Notification{db: db, Type: NotificationTypeModAdd, Notif: &NotificationModAdd{CommunityName: "general"}},
+			&Notification{db: db, Type: NotificationTypeNewBadge, Notif: &NotificationNewBadge{UserID: uid.New()}},
+		)
+	}
+
+	if err := PopulateNotifications(context.Background(), db, notifs); err != nil {
+		t.Fatal(err)
+	}
+
+	// One query each for comments, posts, communities, and users, regardless
+	// of the number of notifications.
+	if got, max := d.count(), 4; got > max {
+		t.Errorf("PopulateNotifications ran %d queries for %d notifications (expected at most %d)", got, len(notifs), max)
+	}
+}
+
+func TestPushConfigPerSite(t *testing.T) {
+	dbA, _ := newCountingDB(t)
+	defer dbA.Close()
+	dbB, err := sql.Open("counting_"+t.Name(), "") // Another site.
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbB.Close()
+
+	EnablePushNotifications(dbA, &VAPIDKeys{Public: "a", Private: "a"}, "a@example.com")
+	defer DisablePushNotifications(dbA)
+	EnablePushNotifications(dbB, &VAPIDKeys{Public: "b", Private: "b"}, "b@example.com")
+	defer DisablePushNotifications(dbB)
+
+	if conf := getPushConfig(dbA); conf == nil || conf.keys.Private != "a" || conf.email != "a@example.com" {
+		t.Errorf("got push config %+v of the first site, want its own", conf)
+	}
+	if conf := getPushConfig(dbB); conf == nil || conf.keys.Private != "b" {
+		t.Errorf("got push config %+v of the second site, want its own", conf)
+	}
+
+	DisablePushNotifications(dbA)
+	if getPushConfig(dbA) != nil || getPushConfig(dbB) == nil {
+		t.Error("disabling the push notifications of one site affected the other")
+	}
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// The steps of onboarding, in the order they're listed in.
+const (
+	OnboardingStepVerifyEmail     = "verify_email"
+	OnboardingStepJoinCommunities = "join_communities"
+	OnboardingStepFirstPost       = "first_post"
+	OnboardingStepFirstComment    = "first_comment"
+)
+
+var onboardingSteps = []string{
+	OnboardingStepVerifyEmail,
+	OnboardingStepJoinCommunities,
+	OnboardingStepFirstPost,
+	OnboardingStepFirstComment,
+}
+
+// The number of communities a user is to join to complete the
+// OnboardingStepJoinCommunities step.
+const onboardingCommunities = 3
+
+// OnboardingBadge is the type of the badge given to users upon completing all
+// of the onboarding steps.
+const OnboardingBadge = "onboarded"
+
+// An OnboardingStep is one of the things a new user is walked through.
+type OnboardingStep struct {
+	Step        string        `json:"step"`
+	Done        bool          `json:"done"`
+	CompletedAt msql.NullTime `json:"completedAt"`
+
+	// Only for steps that are done a number of times (like joining
+	// communities).
+	Progress int `json:"progress,omitempty"`
+	Goal     int `json:"goal,omitempty"`
+}
+
+// Onboarding is the onboarding checklist of a user. Once a step is done it
+// stays done (even if, say, the user leaves the communities they joined).
+type Onboarding struct {
+	Steps       []*OnboardingStep `json:"steps"`
+	Completed   bool              `json:"completed"`
+	CompletedAt msql.NullTime     `json:"completedAt"`
+}
+
+// GetOnboarding returns the onboarding checklist of user, first marking the
+// steps the user has done (but that weren't recorded as done, like those done
+// before onboarding was tracked) as done.
+func GetOnboarding(ctx context.Context, db *sql.DB, user uid.ID) (*Onboarding, error) {
+	var (
+		emailConfirmedAt msql.NullTime
+		noPosts          int
+		noComments       int
+		noCommunities    int
+	)
+	row := db.QueryRowContext(ctx, `SELECT email_confirmed_at, no_posts, no_comments,
+		(SELECT COUNT(*) FROM community_members WHERE community_members.user_id = users.id)
+		FROM users WHERE id = ?`, user)
+	if err := row.Scan(&emailConfirmedAt, &noPosts, &noComments, &noCommunities); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errUserNotFound
+		}
+		return nil, err
+	}
+
+	completed, err := getCompletedOnboardingSteps(ctx, db, user)
+	if err != nil {
+		return nil, err
+	}
+	done := map[string]bool{
+		OnboardingStepVerifyEmail:     emailConfirmedAt.Valid,
+		OnboardingStepJoinCommunities: noCommunities >= onboardingCommunities,
+		OnboardingStepFirstPost:       noPosts > 0,
+		OnboardingStepFirstComment:    noComments > 0,
+	}
+	for _, step := range onboardingSteps {
+		if _, ok := completed[step]; !ok && done[step] {
+			if err := completeOnboardingStep(ctx, db, user, step); err != nil {
+				return nil, err
+			}
+			completed[step] = time.Now()
+		}
+	}
+
+	o := &Onboarding{}
+	for _, step := range onboardingSteps {
+		s := &OnboardingStep{Step: step}
+		if t, ok := completed[step]; ok {
+			s.Done = true
+			s.CompletedAt = msql.NewNullTime(t)
+		}
+		if step == OnboardingStepJoinCommunities {
+			s.Progress, s.Goal = min(noCommunities, onboardingCommunities), onboardingCommunities
+			if s.Done {
+				s.Progress = s.Goal
+			}
+		}
+		o.Steps = append(o.Steps, s)
+	}
+	if err := db.QueryRowContext(ctx, "SELECT onboarded_at FROM users WHERE id = ?", user).Scan(&o.CompletedAt); err != nil {
+		return nil, err
+	}
+	o.Completed = o.CompletedAt.Valid
+	return o, nil
+}
+
+// getCompletedOnboardingSteps returns the steps user has completed, mapped to
+// when they were completed.
+func getCompletedOnboardingSteps(ctx context.Context, db *sql.DB, user uid.ID) (map[string]time.Time, error) {
+	rows, err := db.QueryContext(ctx, "SELECT step, completed_at FROM onboarding_steps WHERE user_id = ?", user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steps := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			step string
+			at   time.Time
+		)
+		if err := rows.Scan(&step, &at); err != nil {
+			return nil, err
+		}
+		steps[step] = at
+	}
+	return steps, rows.Err()
+}
+
+// completeOnboardingStep marks step as done for user. If that was the last of
+// the steps left, the user's onboarding is marked as completed and they're
+// given the OnboardingBadge.
+func completeOnboardingStep(ctx context.Context, db *sql.DB, user uid.ID, step string) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO onboarding_steps (user_id, step, completed_at) VALUES (?, ?, ?)", user, step, time.Now())
+	if err != nil {
+		if msql.IsErrDuplicateErr(err) {
+			return nil
+		}
+		return err
+	}
+
+	var n int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM onboarding_steps WHERE user_id = ?", user).Scan(&n); err != nil {
+		return err
+	}
+	if n < len(onboardingSteps) {
+		return nil
+	}
+	res, err := db.ExecContext(ctx, "UPDATE users SET onboarded_at = ? WHERE id = ? AND onboarded_at IS NULL", time.Now(), user)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return nil // Already completed.
+	}
+
+	u, err := GetUser(ctx, db, user, nil)
+	if err != nil {
+		return err
+	}
+	return u.AddBadge(ctx, OnboardingBadge)
+}
+
+// onboardingStepDone is completeOnboardingStep for where the step is done as
+// a side effect of something else (like creating a post), so errors are only
+// logged.
+func onboardingStepDone(ctx context.Context, db *sql.DB, user uid.ID, step string) {
+	if err := completeOnboardingStep(ctx, db, user, step); err != nil {
+		logger.ErrorContext(ctx, "Failed to complete onboarding step", "err", err, "user", user, "step", step)
+	}
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+const (
+	// maxOutboxAttempts is the number of times the creation of a notification
+	// is attempted before it's given up on.
+	maxOutboxAttempts = 10
+
+	outboxBatchSize = 100
+
+	// outboxLease is how long a notification being created is hidden from the
+	// other workers (of other processes), which retry it after that (if the
+	// process died, say).
+	outboxLease = 5 * time.Minute
+)
+
+// An outboxNotification is a notification to be created, queued in the
+// notification_outbox table in the same transaction as the change it's about
+// (a new comment, a vote, and so on), so that it's not lost if the process
+// dies, or if creating it fails, before it's created (see
+// DeliverNotifications). It may be created more than once.
+type outboxNotification struct {
+	Type NotificationType `json:"type"`
+	User uid.ID           `json:"user"` // Who's notified.
+
+	// The fields of the notification of Type that are set.
+	Post      uid.NullID `json:"post"`
+	Comment   uid.NullID `json:"comment"`
+	Parent    uid.NullID `json:"parent"`
+	Author    string     `json:"author,omitempty"`
+	Community string     `json:"community,omitempty"`
+	IsPost    bool       `json:"isPost,omitempty"`
+	DeletedAs UserGroup  `json:"deletedAs,omitempty"`
+}
+
+// enqueueNotificationTx queues n (see outboxNotification).
+func enqueueNotificationTx(ctx context.Context, tx *sql.Tx, n *outboxNotification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO notification_outbox (notification, next_attempt_at) VALUES (?, ?)", string(data), time.Now())
+	return err
+}
+
+// create creates the notification n.
+func (n *outboxNotification) create(ctx context.Context, db *sql.DB) error {
+	switch n.Type {
+	case NotificationTypeNewComment, NotificationTypeCommentReply:
+		post, err := GetPost(ctx, db, &n.Post.ID, "", nil, true)
+		if err != nil {
+			return err
+		}
+		if n.Type == NotificationTypeNewComment {
+			return CreateNewCommentNotification(ctx, db, post, n.Comment.ID, n.Author)
+		}
+		return CreateCommentReplyNotification(ctx, db, n.User, n.Parent.ID, n.Comment.ID, n.Author, post)
+	case NotificationTypeUpvote:
+		target := n.Comment.ID
+		if n.IsPost {
+			target = n.Post.ID
+		}
+		return CreateNewVotesNotification(ctx, db, n.User, n.Community, n.IsPost, target)
+	case NotificationTypeDeletePost:
+		return CreatePostDeletedNotification(ctx, db, n.User, n.DeletedAs, true, n.Post.ID)
+	case NotificationTypeModAdd:
+		return CreateNewModAddNotification(ctx, db, n.User, n.Community, n.Author)
+	}
+	return fmt.Errorf("unknown notification type %s in outbox", n.Type)
+}
+
+// DeliverNotifications creates the queued notifications (see
+// outboxNotification) that are due. Those that fail are retried, with
+// exponential backoff, up to maxOutboxAttempts times. It returns the number
+// of notifications created.
+func DeliverNotifications(ctx context.Context, db *sql.DB) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, notification, attempts FROM notification_outbox
+		WHERE next_attempt_at <= ?
+		ORDER BY next_attempt_at
+		LIMIT ?`, time.Now(), outboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	type queued struct {
+		id           int
+		notification string
+		attempts     int
+	}
+	var items []queued
+	for rows.Next() {
+		var q queued
+		if err := rows.Scan(&q.id, &q.notification, &q.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		items = append(items, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, q := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		// Lease the notification, unless another worker has.
+		now := time.Now()
+		res, err := db.ExecContext(ctx, "UPDATE notification_outbox SET next_attempt_at = ? WHERE id = ? AND next_attempt_at <= ?",
+			now.Add(outboxLease), q.id, now)
+		if err != nil {
+			return n, err
+		}
+		if leased, err := res.RowsAffected(); err != nil {
+			return n, err
+		} else if leased == 0 {
+			continue
+		}
+
+		notif := &outboxNotification{}
+		if err = json.Unmarshal([]byte(q.notification), notif); err == nil {
+			err = notif.create(ctx, db)
+		}
+		if err == nil || q.attempts+1 >= maxOutboxAttempts {
+			if err != nil {
+				notifLogger.ErrorContext(ctx, "Giving up on creating a notification", "notification", q.notification, "attempts", q.attempts+1, "err", err)
+			} else {
+				n++
+			}
+			if _, err := db.ExecContext(ctx, "DELETE FROM notification_outbox WHERE id = ?", q.id); err != nil {
+				return n, err
+			}
+			continue
+		}
+		notifLogger.WarnContext(ctx, "Failed to create a notification (it will be retried)", "notification", q.notification, "attempts", q.attempts+1, "err", err)
+		if _, err := db.ExecContext(ctx, "UPDATE notification_outbox SET attempts = attempts + 1, next_attempt_at = ? WHERE id = ?",
+			time.Now().Add(deliveryBackoff(q.attempts+1)), q.id); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
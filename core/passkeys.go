@@ -0,0 +1,184 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/internal/utils"
+	"github.com/discuitnet/discuit/internal/webauthn"
+)
+
+var errPasskeyNotFound = httperr.NewNotFound("passkey/not-found", "Passkey not found.")
+
+// A Passkey is a WebAuthn credential with which a user logs in, instead of
+// with their password.
+type Passkey struct {
+	ID     int    `json:"id"`
+	UserID uid.ID `json:"userId"`
+	Name   string `json:"name"` // Given by the user.
+
+	CredentialID []byte `json:"-"`
+	PublicKey    []byte `json:"-"` // COSE encoded.
+	SignCount    uint32 `json:"-"`
+	AAGUID       []byte `json:"-"`
+
+	// Whether the passkey may be, and is, synced between the devices of the
+	// user.
+	BackupEligible bool `json:"backupEligible"`
+	BackedUp       bool `json:"backedUp"`
+
+	CreatedAt  time.Time     `json:"createdAt"`
+	LastUsedAt msql.NullTime `json:"lastUsedAt"`
+}
+
+// Credential returns p as a webauthn.Credential.
+func (p *Passkey) Credential() *webauthn.Credential {
+	return &webauthn.Credential{
+		ID:             p.CredentialID,
+		PublicKey:      p.PublicKey,
+		SignCount:      p.SignCount,
+		AAGUID:         p.AAGUID,
+		BackupEligible: p.BackupEligible,
+		BackedUp:       p.BackedUp,
+	}
+}
+
+const selectPasskeyColumns = "SELECT id, user_id, name, credential_id, public_key, sign_count, aaguid, backup_eligible, backed_up, created_at, last_used_at FROM passkeys "
+
+func scanPasskeys(rows *sql.Rows) ([]*Passkey, error) {
+	defer rows.Close()
+	passkeys := []*Passkey{}
+	for rows.Next() {
+		p := &Passkey{}
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Name, &p.CredentialID, &p.PublicKey, &p.SignCount, &p.AAGUID,
+			&p.BackupEligible, &p.BackedUp, &p.CreatedAt, &p.LastUsedAt); err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, p)
+	}
+	return passkeys, rows.Err()
+}
+
+func getPasskey(ctx context.Context, db *sql.DB, where string, args ...any) (*Passkey, error) {
+	rows, err := db.QueryContext(ctx, selectPasskeyColumns+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	passkeys, err := scanPasskeys(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(passkeys) == 0 {
+		return nil, errPasskeyNotFound
+	}
+	return passkeys[0], nil
+}
+
+// GetPasskeys returns the passkeys of user.
+func GetPasskeys(ctx context.Context, db *sql.DB, user uid.ID) ([]*Passkey, error) {
+	rows, err := db.QueryContext(ctx, selectPasskeyColumns+"WHERE user_id = ? ORDER BY id", user)
+	if err != nil {
+		return nil, err
+	}
+	return scanPasskeys(rows)
+}
+
+// GetPasskey returns the passkey of user with the ID id.
+func GetPasskey(ctx context.Context, db *sql.DB, user uid.ID, id int) (*Passkey, error) {
+	return getPasskey(ctx, db, "WHERE id = ? AND user_id = ?", id, user)
+}
+
+// GetPasskeyByCredentialID returns the passkey (of any user) with the
+// WebAuthn credential ID id.
+func GetPasskeyByCredentialID(ctx context.Context, db *sql.DB, id []byte) (*Passkey, error) {
+	return getPasskey(ctx, db, "WHERE credential_id = ?", id)
+}
+
+// UserHasPasskeys reports whether user has at least one passkey.
+func UserHasPasskeys(ctx context.Context, db *sql.DB, user uid.ID) (bool, error) {
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM passkeys WHERE user_id = ?", user).Scan(&n)
+	return n > 0, err
+}
+
+func normalizePasskeyName(name string) (string, error) {
+	name = utils.TruncateUnicodeString(strings.TrimSpace(name), 64)
+	if name == "" {
+		return "", httperr.NewBadRequest("passkey/invalid-name", "Passkey name cannot be empty.")
+	}
+	return name, nil
+}
+
+// AddPasskey saves cred, a credential registered by user, as a passkey of
+// user named name. Users may have at most max passkeys.
+func AddPasskey(ctx context.Context, db *sql.DB, user uid.ID, name string, cred *webauthn.Credential, max int) (*Passkey, error) {
+	name, err := normalizePasskeyName(name)
+	if err != nil {
+		return nil, err
+	}
+	var n int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM passkeys WHERE user_id = ?", user).Scan(&n); err != nil {
+		return nil, err
+	}
+	if n >= max {
+		return nil, httperr.NewBadRequest("passkey/too-many", "You have too many passkeys.")
+	}
+
+	res, err := db.ExecContext(ctx, `INSERT INTO passkeys (user_id, credential_id, public_key, sign_count, aaguid, name, backup_eligible, backed_up, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		user, cred.ID, cred.PublicKey, cred.SignCount, cred.AAGUID, name, cred.BackupEligible, cred.BackedUp, time.Now())
+	if err != nil {
+		if msql.IsErrDuplicateErr(err) {
+			return nil, httperr.NewBadRequest("passkey/exists", "The passkey is already registered.")
+		}
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return GetPasskey(ctx, db, user, int(id))
+}
+
+// Rename renames p.
+func (p *Passkey) Rename(ctx context.Context, db *sql.DB, name string) error {
+	name, err := normalizePasskeyName(name)
+	if err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE passkeys SET name = ? WHERE id = ?", name, p.ID); err != nil {
+		return err
+	}
+	p.Name = name
+	return nil
+}
+
+// RecordUse records that p was just used to log in, with the signature counter
+// signCount.
+func (p *Passkey) RecordUse(ctx context.Context, db *sql.DB, signCount uint32, backedUp bool) error {
+	now := time.Now()
+	_, err := db.ExecContext(ctx, "UPDATE passkeys SET sign_count = ?, backed_up = ?, last_used_at = ? WHERE id = ?", signCount, backedUp, now, p.ID)
+	if err == nil {
+		p.SignCount, p.BackedUp, p.LastUsedAt = signCount, backedUp, msql.NewNullTime(now)
+	}
+	return err
+}
+
+// DeletePasskey deletes the passkey of user with the ID id.
+func DeletePasskey(ctx context.Context, db *sql.DB, user uid.ID, id int) error {
+	res, err := db.ExecContext(ctx, "DELETE FROM passkeys WHERE id = ? AND user_id = ?", id, user)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errPasskeyNotFound
+	}
+	return nil
+}
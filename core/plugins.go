@@ -0,0 +1,180 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// A Plugin adds custom behavior to the site (an automod, karma rules, and the
+// like) without changes to core. Plugins are compiled in: a plugin package
+// calls RegisterPlugin (usually in an init function) and is imported by the
+// main package (see plugins.go there).
+//
+// A plugin implements any of the hook interfaces below (BeforePostHook,
+// AfterPostHook, and so on); hooks are called in the order the plugins were
+// registered. Hooks are not called for imported or generated (seeded) posts
+// and comments.
+type Plugin interface {
+	Name() string
+}
+
+// NewPost is a post about to be created.
+type NewPost struct {
+	Author    uid.ID
+	Community uid.ID
+	Type      PostType
+	Title     string
+	Body      string // Of text posts.
+	Link      string // The URL of link posts.
+}
+
+// NewComment is a comment about to be created.
+type NewComment struct {
+	Author   uid.ID
+	Post     *Post
+	ParentID *uid.ID // Nil for top-level comments.
+	Body     string
+}
+
+// A BeforePostHook is called before a post is created. It may change the title
+// and body of the post. If it returns an error, the post is not created and
+// the error is returned to the user (so it should be an *httperr.Error).
+type BeforePostHook interface {
+	BeforeCreatePost(ctx context.Context, db *sql.DB, post *NewPost) error
+}
+
+// An AfterPostHook is called after a post is created.
+type AfterPostHook interface {
+	PostCreated(ctx context.Context, db *sql.DB, post *Post) error
+}
+
+// A BeforeCommentHook is called before a comment is created. It may change
+// the body of the comment. If it returns an error, the comment is not created
+// and the error is returned to the user (so it should be an *httperr.Error).
+type BeforeCommentHook interface {
+	BeforeCreateComment(ctx context.Context, db *sql.DB, comment *NewComment) error
+}
+
+// An AfterCommentHook is called after a comment is created.
+type AfterCommentHook interface {
+	CommentCreated(ctx context.Context, db *sql.DB, comment *Comment) error
+}
+
+// A UserRegisteredHook is called after a user signs up.
+type UserRegisteredHook interface {
+	UserRegistered(ctx context.Context, db *sql.DB, user *User) error
+}
+
+// A NotificationHook is called before a notification of type t is sent to
+// user. If it returns false, the notification is dropped.
+type NotificationHook interface {
+	AllowNotification(ctx context.Context, db *sql.DB, user uid.ID, t NotificationType) bool
+}
+
+// A FeedRanker reorders (or filters) a page of posts of a feed, before pinned
+// posts are added to it. It's given the posts in the order of the feed's sort.
+type FeedRanker interface {
+	RankFeed(ctx context.Context, db *sql.DB, opts *FeedOptions, posts []*Post) []*Post
+}
+
+var plugins []Plugin
+
+// RegisterPlugin adds p to the plugins whose hooks are called. It's not safe
+// to call once the server has started.
+func RegisterPlugin(p Plugin) {
+	plugins = append(plugins, p)
+}
+
+// Plugins returns the names of the registered plugins.
+func Plugins() []string {
+	names := make([]string, len(plugins))
+	for i, p := range plugins {
+		names[i] = p.Name()
+	}
+	return names
+}
+
+// runBeforePostHooks calls the BeforePostHook of each plugin, stopping at the
+// first that rejects post.
+func runBeforePostHooks(ctx context.Context, db *sql.DB, post *NewPost) error {
+	for _, p := range plugins {
+		if h, ok := p.(BeforePostHook); ok {
+			if err := h.BeforeCreatePost(ctx, db, post); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runAfterPostHooks calls the AfterPostHook of each plugin. Errors are only
+// logged.
+func runAfterPostHooks(ctx context.Context, db *sql.DB, post *Post) {
+	for _, p := range plugins {
+		if h, ok := p.(AfterPostHook); ok {
+			if err := h.PostCreated(ctx, db, post); err != nil {
+				logger.ErrorContext(ctx, "Plugin hook failed", "plugin", p.Name(), "hook", "PostCreated", "post", post.ID, "err", err)
+			}
+		}
+	}
+}
+
+// runBeforeCommentHooks calls the BeforeCommentHook of each plugin, stopping
+// at the first that rejects comment.
+func runBeforeCommentHooks(ctx context.Context, db *sql.DB, comment *NewComment) error {
+	for _, p := range plugins {
+		if h, ok := p.(BeforeCommentHook); ok {
+			if err := h.BeforeCreateComment(ctx, db, comment); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// runAfterCommentHooks calls the AfterCommentHook of each plugin. Errors are
+// only logged.
+func runAfterCommentHooks(ctx context.Context, db *sql.DB, comment *Comment) {
+	for _, p := range plugins {
+		if h, ok := p.(AfterCommentHook); ok {
+			if err := h.CommentCreated(ctx, db, comment); err != nil {
+				logger.ErrorContext(ctx, "Plugin hook failed", "plugin", p.Name(), "hook", "CommentCreated", "comment", comment.ID, "err", err)
+			}
+		}
+	}
+}
+
+// runUserRegisteredHooks calls the UserRegisteredHook of each plugin. Errors
+// are only logged.
+func runUserRegisteredHooks(ctx context.Context, db *sql.DB, user *User) {
+	for _, p := range plugins {
+		if h, ok := p.(UserRegisteredHook); ok {
+			if err := h.UserRegistered(ctx, db, user); err != nil {
+				logger.ErrorContext(ctx, "Plugin hook failed", "plugin", p.Name(), "hook", "UserRegistered", "user", user.ID, "err", err)
+			}
+		}
+	}
+}
+
+// allowNotification reports whether all plugins allow a notification of type t
+// to be sent to user.
+func allowNotification(ctx context.Context, db *sql.DB, user uid.ID, t NotificationType) bool {
+	for _, p := range plugins {
+		if h, ok := p.(NotificationHook); ok && !h.AllowNotification(ctx, db, user, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// rankFeed passes posts through the FeedRanker of each plugin in turn.
+func rankFeed(ctx context.Context, db *sql.DB, opts *FeedOptions, posts []*Post) []*Post {
+	for _, p := range plugins {
+		if r, ok := p.(FeedRanker); ok {
+			posts = r.RankFeed(ctx, db, opts, posts)
+		}
+	}
+	return posts
+}
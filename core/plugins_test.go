@@ -0,0 +1,65 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testPlugin struct {
+	name   string
+	reject bool
+	calls  *[]string
+}
+
+func (p *testPlugin) Name() string { return p.name }
+
+func (p *testPlugin) BeforeCreatePost(ctx context.Context, db *sql.DB, post *NewPost) error {
+	*p.calls = append(*p.calls, p.name)
+	if p.reject {
+		return errors.New("rejected")
+	}
+	post.Title += " [" + p.name + "]"
+	return nil
+}
+
+func (p *testPlugin) RankFeed(ctx context.Context, db *sql.DB, opts *FeedOptions, posts []*Post) []*Post {
+	return posts[1:]
+}
+
+func TestPluginHooks(t *testing.T) {
+	defer func(registered []Plugin) { plugins = registered }(plugins)
+	plugins = nil
+
+	var calls []string
+	RegisterPlugin(&testPlugin{name: "a", calls: &calls})
+	RegisterPlugin(&testPlugin{name: "b", calls: &calls})
+	if names := Plugins(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("Plugins() = %v, expected [a b]", names)
+	}
+
+	post := &NewPost{Title: "Hello"}
+	if err := runBeforePostHooks(context.Background(), nil, post); err != nil {
+		t.Fatal(err)
+	}
+	if post.Title != "Hello [a] [b]" {
+		t.Errorf("title after hooks is %q, expected %q", post.Title, "Hello [a] [b]")
+	}
+
+	if got := rankFeed(context.Background(), nil, nil, []*Post{{}, {}, {}}); len(got) != 1 {
+		t.Errorf("rankFeed returned %d posts, expected 1", len(got))
+	}
+
+	// A rejection stops the hooks of the plugins that come after.
+	plugins, calls = nil, nil
+	RegisterPlugin(&testPlugin{name: "a", reject: true, calls: &calls})
+	RegisterPlugin(&testPlugin{name: "b", calls: &calls})
+	if err := runBeforePostHooks(context.Background(), nil, &NewPost{}); err == nil {
+		t.Error("post not rejected")
+	}
+	if !reflect.DeepEqual(calls, []string{"a"}) {
+		t.Errorf("hooks called: %v, expected [a]", calls)
+	}
+}
@@ -4,22 +4,26 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/discuitnet/discuit/internal/highlight"
 	"github.com/discuitnet/discuit/internal/httperr"
 	"github.com/discuitnet/discuit/internal/httputil"
 	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/oembed"
 	msql "github.com/discuitnet/discuit/internal/sql"
 	"github.com/discuitnet/discuit/internal/uid"
 	"github.com/discuitnet/discuit/internal/utils"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/exp/slices"
 )
 
@@ -41,6 +45,7 @@ const (
 	PostTypeText = PostType(iota)
 	PostTypeImage
 	PostTypeLink
+	PostTypeVideo
 )
 
 // Valid reports whether t is a valid PostType.
@@ -59,6 +64,8 @@ func (t PostType) MarshalText() ([]byte, error) {
 		s = "image"
 	case PostTypeLink:
 		s = "link"
+	case PostTypeVideo:
+		s = "video"
 	default:
 		return nil, errPostTypeUnsupported
 	}
@@ -74,6 +81,8 @@ func (p *PostType) UnmarshalText(text []byte) error {
 		*p = PostTypeImage
 	case "link":
 		*p = PostTypeLink
+	case "video":
+		*p = PostTypeVideo
 	default:
 		return errPostTypeUnsupported
 	}
@@ -118,6 +127,13 @@ type Post struct {
 	Link      *PostLink     `json:"link,omitempty"` // what's sent to the client
 	LinkImage *images.Image `json:"-"`
 
+	Video *Video `json:"video,omitempty"`
+
+	// The license under which the content of the post may be reused, and its
+	// terms, if it's a custom license (see SetLicense).
+	License     PostLicense     `json:"license"`
+	LicenseText msql.NullString `json:"licenseText"`
+
 	Locked   bool       `json:"locked"`
 	LockedBy uid.NullID `json:"lockedBy"`
 
@@ -126,10 +142,20 @@ type Post struct {
 
 	LockedAt msql.NullTime `json:"lockedAt"`
 
+	// Why the post was locked, if a reason was given, and when it's to be
+	// unlocked, if ever (see UnlockExpiredPosts).
+	LockReason  msql.NullString `json:"lockReason"`
+	LockedUntil msql.NullTime   `json:"lockedUntil"`
+
 	Upvotes   int `json:"upvotes"`
 	Downvotes int `json:"downvotes"`
 	Points    int `json:"-"` // Upvotes - Downvotes
 
+	// The controversy score of the post (see Controversy), and whether it's
+	// controversial.
+	Controversy   int  `json:"controversy"`
+	Controversial bool `json:"controversial"`
+
 	Hotness        int           `json:"hotness"`
 	CreatedAt      time.Time     `json:"createdAt"`
 	EditedAt       msql.NullTime `json:"editedAt"`
@@ -148,10 +174,27 @@ type Post struct {
 	DeletedContentBy uid.NullID    `json:"-"`
 	DeletedContentAs UserGroup     `json:"deletedContentAs,omitempty"`
 
+	// Version is the number of times the post has been edited. Save fails
+	// with ErrEditConflict if the post's version is no longer this.
+	Version int `json:"version"`
+
+	// The URLs at which the external images of the body are served by the
+	// image proxy, by URL (see proxiedImages).
+	ProxiedImages map[string]string `json:"proxiedImages,omitempty"`
+
+	// The fenced code blocks of the body, with their languages and
+	// highlighted HTML.
+	CodeBlocks []highlight.Block `json:"codeBlocks,omitempty"`
+
 	NumComments  int             `json:"noComments"`
 	Comments     []*Comment      `json:"comments"`
 	CommentsNext msql.NullString `json:"commentsNext"` // pagination cursor
 
+	// The sort of Comments (see GetComments), and the default comment sort of
+	// the community of the post.
+	CommentsSort       CommentSort `json:"commentsSort,omitempty"`
+	defaultCommentSort CommentSort
+
 	// Whether the logged in user have voted on this post.
 	ViewerVoted msql.NullBool `json:"userVoted"`
 
@@ -182,12 +225,15 @@ var selectPostCols = []string{
 	"posts.locked_at",
 	"posts.locked_by",
 	"posts.locked_by_group",
+	"posts.lock_reason",
+	"posts.locked_until",
 	"posts.is_pinned",
 	"posts.is_pinned_site",
 	"posts.upvotes",
 	"posts.downvotes",
 	"posts.points",
 	"posts.hotness",
+	"posts.controversy",
 	"posts.created_at",
 	"posts.edited_at",
 	"posts.last_activity_at",
@@ -201,6 +247,10 @@ var selectPostCols = []string{
 	"posts.deleted_content_at",
 	"posts.deleted_content_by",
 	"posts.deleted_content_as",
+	"posts.version",
+	"posts.license",
+	"posts.license_text",
+	"communities.default_comment_sort",
 }
 
 var selectPostJoins = []string{
@@ -290,12 +340,15 @@ func scanPosts(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *uid.ID)
 			&post.LockedAt,
 			&post.LockedBy,
 			&post.LockedAs,
+			&post.LockReason,
+			&post.LockedUntil,
 			&post.Pinned,
 			&post.PinnedSite,
 			&post.Upvotes,
 			&post.Downvotes,
 			&post.Points,
 			&post.Hotness,
+			&post.Controversy,
 			&post.CreatedAt,
 			&post.EditedAt,
 			&post.LastActivityAt,
@@ -309,6 +362,10 @@ func scanPosts(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *uid.ID)
 			&post.DeletedContentAt,
 			&post.DeletedContentBy,
 			&post.DeletedContentAs,
+			&post.Version,
+			&post.License,
+			&post.LicenseText,
+			&post.defaultCommentSort,
 		}
 
 		linkImage := &images.Image{}
@@ -355,6 +412,9 @@ func scanPosts(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *uid.ID)
 			post.Link = nil
 			post.Image = nil
 		}
+		post.ProxiedImages = proxiedImages(post.Body.String)
+		post.CodeBlocks = highlight.Blocks(post.Body.String)
+		post.Controversial = Controversial(post.Upvotes, post.Downvotes)
 		posts = append(posts, post)
 	}
 
@@ -393,6 +453,10 @@ func scanPosts(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *uid.ID)
 		return nil, err
 	}
 
+	if err := populatePostsVideos(ctx, db, posts); err != nil {
+		return nil, fmt.Errorf("failed to populate post videos: %w", err)
+	}
+
 	if err := populatePostAuthors(ctx, db, posts); err != nil {
 		return nil, fmt.Errorf("failed to populate post authors: %w", err)
 	}
@@ -498,7 +562,7 @@ func populatePostsImages(ctx context.Context, db *sql.DB, posts []*Post) error {
 // validatePost always returns an httperr.Error on error.
 func validatePost(title, body string) error {
 	if len(title) < 3 {
-		return httperr.NewBadRequest("post/title-too-short", "Title too short.")
+		return httperr.NewInvalidField("title", "post/title-too-short", "Title too short.")
 	}
 	return nil
 }
@@ -520,6 +584,12 @@ type createPostOpts struct {
 	link      postLink
 	linkImage []byte // for link posts (thumbnail image)
 	image     uid.ID // for image posts
+	video     uid.ID // for video posts
+
+	// Optional, for imported posts:
+	id                 uid.ID    // if zero, a new ID is generated
+	createdAt          time.Time // if zero, the current time
+	upvotes, downvotes int
 }
 
 func createPost(ctx context.Context, db *sql.DB, opts *createPostOpts) (*Post, error) {
@@ -534,13 +604,38 @@ func createPost(ctx context.Context, db *sql.DB, opts *createPostOpts) (*Post, e
 		return nil, errUserBannedFromCommunity
 	}
 
+	runHooks := opts.createdAt.IsZero() // Not for imported or generated posts.
+	if runHooks {
+		newPost := &NewPost{
+			Author:    opts.author,
+			Community: opts.community,
+			Type:      opts.postType,
+			Title:     opts.title,
+			Body:      opts.body,
+			Link:      opts.link.URL,
+		}
+		if err := runBeforePostHooks(ctx, db, newPost); err != nil {
+			return nil, err
+		}
+		opts.title, opts.body = newPost.Title, newPost.Body
+		if err := validatePost(opts.title, opts.body); err != nil {
+			return nil, err
+		}
+	}
+
 	// Truncate title and body if max lengths are exceeded.
 	var post Post
 	post.Title = opts.title
 	post.Body.Valid, post.Body.String = opts.body != "", opts.body
 	post.truncateTitleAndBody()
 	post.CreatedAt = time.Now()
-	post.ID = uid.New()
+	if !opts.createdAt.IsZero() {
+		post.CreatedAt = opts.createdAt
+	}
+	post.ID = opts.id
+	if post.ID.Zero() {
+		post.ID = uid.New()
+	}
 	post.PublicID = utils.GenerateStringID(publicPostIDLength)
 
 	cols := []msql.ColumnValue{
@@ -552,7 +647,17 @@ func createPost(ctx context.Context, db *sql.DB, opts *createPostOpts) (*Post, e
 		{Name: "title", Value: post.Title},
 		{Name: "body", Value: post.Body},
 		{Name: "created_at", Value: post.CreatedAt},
-		{Name: "hotness", Value: PostHotness(0, 0, post.CreatedAt)},
+		{Name: "hotness", Value: PostHotness(opts.upvotes, opts.downvotes, post.CreatedAt)},
+		{Name: "controversy", Value: Controversy(opts.upvotes, opts.downvotes)},
+	}
+	if !opts.createdAt.IsZero() {
+		cols = append(cols, msql.ColumnValue{Name: "last_activity_at", Value: post.CreatedAt})
+	}
+	if opts.upvotes != 0 || opts.downvotes != 0 {
+		cols = append(cols,
+			msql.ColumnValue{Name: "upvotes", Value: opts.upvotes},
+			msql.ColumnValue{Name: "downvotes", Value: opts.downvotes},
+			msql.ColumnValue{Name: "points", Value: opts.upvotes - opts.downvotes})
 	}
 
 	if opts.postType == PostTypeLink {
@@ -560,7 +665,9 @@ func createPost(ctx context.Context, db *sql.DB, opts *createPostOpts) (*Post, e
 		if err != nil {
 			return nil, err
 		}
-		cols = append(cols, msql.ColumnValue{Name: "link_info", Value: data})
+		cols = append(cols,
+			msql.ColumnValue{Name: "link_info", Value: data},
+			msql.ColumnValue{Name: "link_domain", Value: strings.ToLower(opts.link.Hostname)})
 	}
 
 	tx, err := db.BeginTx(ctx, nil)
@@ -570,17 +677,19 @@ func createPost(ctx context.Context, db *sql.DB, opts *createPostOpts) (*Post, e
 
 	if opts.postType == PostTypeLink && opts.linkImage != nil {
 		// Save link post thumbnail.
-		imageID, err := images.SaveImageTx(ctx, tx, "disk", opts.linkImage, &images.ImageOptions{
+		imageID, err := images.SaveImageTx(ctx, tx, images.DefaultStore, opts.linkImage, &images.ImageOptions{
 			Width:  1280,
 			Height: 720,
 			Format: images.ImageFormatJPEG,
 			Fit:    images.ImageFitCover,
 		})
-		if err != nil {
+		if err != nil && !errors.Is(err, images.ErrImageRejected) {
 			tx.Rollback()
 			return nil, err
 		}
-		cols = append(cols, msql.ColumnValue{Name: "link_image", Value: imageID})
+		if err == nil { // A rejected thumbnail is left out.
+			cols = append(cols, msql.ColumnValue{Name: "link_image", Value: imageID})
+		}
 	}
 
 	query, args := msql.BuildInsertQuery("posts", cols)
@@ -600,9 +709,30 @@ func createPost(ctx context.Context, db *sql.DB, opts *createPostOpts) (*Post, e
 			tx.Rollback()
 			return nil, err
 		}
+		if err = setMediaUploadCommunityTx(ctx, tx, opts.image, opts.community); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
 	}
 
-	for _, table := range postsTables {
+	if opts.postType == PostTypeVideo {
+		if _, err = tx.ExecContext(ctx, "INSERT INTO post_videos (post_id, video_id) VALUES (?, ?)", post.ID, opts.video); err != nil {
+			tx.Rollback()
+			if msql.IsErrDuplicateErr(err) {
+				return nil, httperr.NewBadRequest("video/already-posted", "Video is already posted.")
+			}
+			return nil, err
+		}
+		if err = setMediaUploadCommunityTx(ctx, tx, opts.video, opts.community); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	for i, table := range postsTables {
+		if post.CreatedAt.Before(time.Now().Add(postsTablesValidity[i])) {
+			continue // only for imported posts
+		}
 		if _, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (community_id, post_id, user_id, created_at) VALUES (?, ?, ?, ?)", table),
 			opts.community, post.ID, opts.author, post.CreatedAt); err != nil {
 			tx.Rollback()
@@ -626,7 +756,15 @@ func createPost(ctx context.Context, db *sql.DB, opts *createPostOpts) (*Post, e
 		return nil, err
 	}
 
-	return GetPost(ctx, db, &post.ID, "", nil, false)
+	created, err := GetPost(ctx, db, &post.ID, "", nil, false)
+	if err != nil {
+		return nil, err
+	}
+	if runHooks {
+		runAfterPostHooks(ctx, db, created)
+		onboardingStepDone(ctx, db, opts.author, OnboardingStepFirstPost)
+	}
+	return created, nil
 }
 
 func CreateTextPost(ctx context.Context, db *sql.DB, author, community uid.ID, title string, body string) (*Post, error) {
@@ -644,13 +782,25 @@ func CreateImagePost(ctx context.Context, db *sql.DB, author, community uid.ID,
 	// This is not a big deal as image ids are hard to guess.
 
 	// Check if the image exists.
-	if _, err := images.GetImageRecord(ctx, db, imageID); err != nil {
+	record, err := images.GetImageRecord(ctx, db, imageID)
+	if err != nil {
 		if err == images.ErrImageNotFound {
 			return nil, errImageNotFound
 		}
 		return nil, err
 	}
 
+	comm, err := GetCommunityByID(ctx, db, community, nil)
+	if err != nil {
+		return nil, err
+	}
+	if comm.DisableImagePosts {
+		return nil, errImagePostsDisabled
+	}
+	if comm.DisableGIFs && record.Format == images.ImageFormatGIF {
+		return nil, errGIFsNotAllowed
+	}
+
 	return createPost(ctx, db, &createPostOpts{
 		postType:  PostTypeImage,
 		author:    author,
@@ -708,7 +858,31 @@ func getLinkPostImage(u *url.URL) []byte {
 }
 
 func CreateLinkPost(ctx context.Context, db *sql.DB, author, community uid.ID, title string, link string) (*Post, error) {
-	errInvalidURL := httperr.NewBadRequest("invalid-url", "Invalid URL.")
+	pl, err := parsePostLink(link)
+	if err != nil {
+		return nil, err
+	}
+	u, _ := url.Parse(pl.URL)
+	if oembed.Supported(pl.URL) {
+		if pl.Embed, err = oembed.Fetch(ctx, pl.URL); err != nil {
+			logger.WarnContext(ctx, "Failed to fetch oEmbed metadata", "url", pl.URL, "err", err)
+		}
+	}
+
+	return createPost(ctx, db, &createPostOpts{
+		postType:  PostTypeLink,
+		author:    author,
+		community: community,
+		title:     title,
+		linkImage: getLinkPostImage(u),
+		link:      *pl,
+	})
+}
+
+// parsePostLink returns the postLink of the URL link (which, if too long, is
+// truncated).
+func parsePostLink(link string) (*postLink, error) {
+	errInvalidURL := httperr.NewInvalidField("url", "invalid-url", "Invalid URL.")
 	if len(link) > maxPostLinkLength {
 		link = link[:maxPostLinkLength]
 	}
@@ -723,19 +897,11 @@ func CreateLinkPost(ctx context.Context, db *sql.DB, author, community uid.ID, t
 	if u.Hostname() == "" {
 		return nil, errInvalidURL
 	}
-
-	return createPost(ctx, db, &createPostOpts{
-		postType:  PostTypeLink,
-		author:    author,
-		community: community,
-		title:     title,
-		linkImage: getLinkPostImage(u),
-		link: postLink{
-			Version:  1,
-			URL:      u.String(),
-			Hostname: u.Hostname(),
-		},
-	})
+	return &postLink{
+		Version:  1,
+		URL:      u.String(),
+		Hostname: u.Hostname(),
+	}, nil
 }
 
 func (p *Post) truncateTitleAndBody() {
@@ -743,7 +909,8 @@ func (p *Post) truncateTitleAndBody() {
 	p.Body.String = utils.TruncateUnicodeString(p.Body.String, maxPostBodyLength)
 }
 
-// Save updates the post's updatable fields.
+// Save updates the post's updatable fields. It returns ErrEditConflict if the
+// post was edited (its version changed) since it was loaded.
 func (p *Post) Save(ctx context.Context, user uid.ID) error {
 	if !p.AuthorID.EqualsTo(user) {
 		return errNotAuthor
@@ -763,59 +930,96 @@ func (p *Post) Save(ctx context.Context, user uid.ID) error {
 		query += ", body = ?"
 		args = append(args, p.Body)
 	}
-	query += ", edited_at = ? WHERE id = ?"
-	args = append(args, now, p.ID)
+	query += ", edited_at = ?, version = version + 1 WHERE id = ? AND version = ?"
+	args = append(args, now, p.ID, p.Version)
 
-	_, err := p.db.ExecContext(ctx, query, args...)
-	if err == nil {
-		p.EditedAt.Valid = true
-		p.EditedAt.Time = now
+	res, err := p.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
 	}
-	return err
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrEditConflict
+	}
+	p.EditedAt.Valid = true
+	p.EditedAt.Time = now
+	p.ProxiedImages = proxiedImages(p.Body.String)
+	p.CodeBlocks = highlight.Blocks(p.Body.String)
+	p.Version++
+	return nil
 }
 
 // Delete deletes p on behalf of user, who's deleting the post in his capacity
 // as g. In case the post is deleted by an admin or a mod, a notification is
 // sent to the original poster.
 func (p *Post) Delete(ctx context.Context, user uid.ID, g UserGroup, deleteContent bool) error {
+	_, err := p.delete(ctx, user, g, deleteContent, 0)
+	return err
+}
+
+// DeleteUndoable is Delete by a mod or an admin (g), without deleting the
+// content, except that the notification of the original poster, and the
+// resolving of the reports of the post, are held back for window, during
+// which the removal can be undone (see PendingModAction).
+func (p *Post) DeleteUndoable(ctx context.Context, user uid.ID, g UserGroup, window time.Duration) (*PendingModAction, error) {
+	if g != UserGroupMods && g != UserGroupAdmins {
+		return nil, errInvalidUserGroup
+	}
+	return p.delete(ctx, user, g, false, window)
+}
+
+// delete is Delete, with the side effects held back for window, if it's
+// non-zero (see DeleteUndoable).
+func (p *Post) delete(ctx context.Context, user uid.ID, g UserGroup, deleteContent bool, window time.Duration) (*PendingModAction, error) {
 	if p.Deleted && !(deleteContent && !p.DeletedContent) {
-		return &httperr.Error{
-			HTTPStatus: http.StatusConflict,
-			Code:       "already-deleted",
-			Message:    "Post is already deleted.",
-		}
+		return nil, errPostAlreadyDeleted
 	}
 
 	switch g {
 	case UserGroupNormal:
 		if !p.AuthorID.EqualsTo(user) {
-			return errNotAuthor
+			return nil, errNotAuthor
 		}
 	case UserGroupMods:
 		is, err := UserMod(ctx, p.db, p.CommunityID, user)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if !is {
-			return errNotMod
+			return nil, errNotMod
 		}
 	case UserGroupAdmins:
 		user, err := GetUser(ctx, p.db, user, nil)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if !user.Admin {
-			return errNotAdmin
+			return nil, errNotAdmin
 		}
 	default:
-		return errInvalidUserGroup
+		return nil, errInvalidUserGroup
 	}
 
+	var pending *PendingModAction
+	wasDeleted := p.Deleted
 	now := time.Now()
 	err := msql.Transact(ctx, p.db, func(tx *sql.Tx) (err error) {
 		if !deleteContent || (deleteContent && !p.Deleted) {
-			q := "UPDATE posts SET deleted = ?, deleted_at = ?, deleted_by = ?, deleted_as = ? WHERE id = ?"
-			if _, err := tx.ExecContext(ctx, q, true, now, user, g, p.ID); err != nil {
+			q := "UPDATE posts SET deleted = ?, deleted_at = ?, deleted_by = ?, deleted_as = ? WHERE id = ? AND deleted = FALSE"
+			res, err := tx.ExecContext(ctx, q, true, now, user, g, p.ID)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return err
+			} else if n == 0 {
+				return errPostAlreadyDeleted // By a concurrent request.
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE users SET no_posts = no_posts - 1 WHERE id = ? AND no_posts > 0", p.AuthorID); err != nil {
+				return err
+			}
+			if err := archiveDeletedContentTx(ctx, tx, ReportTypePost, p.ID, p.AuthorID, p.Title, p.Body.String, now); err != nil {
 				return err
 			}
 		}
@@ -833,17 +1037,8 @@ func (p *Post) Delete(ctx context.Context, user uid.ID, g UserGroup, deleteConte
 			if _, err := tx.ExecContext(ctx, q, now, user, g, p.ID); err != nil {
 				return err
 			}
-			if p.Type == PostTypeImage {
-				if _, err := tx.ExecContext(ctx, "DELETE FROM post_images WHERE post_id = ?", p.ID); err != nil {
-					return err
-				}
-				if err := images.DeleteImageTx(ctx, tx, p.db, *p.Image.ID); err != nil {
-					return err
-				}
-			} else if p.Type == PostTypeLink && p.LinkImage != nil {
-				if err := images.DeleteImageTx(ctx, tx, p.db, *p.LinkImage.ID); err != nil {
-					return err
-				}
+			if err := p.deleteMediaTx(ctx, tx); err != nil {
+				return err
 			}
 		}
 
@@ -852,10 +1047,29 @@ func (p *Post) Delete(ctx context.Context, user uid.ID, g UserGroup, deleteConte
 				return err
 			}
 		}
+
+		if window > 0 {
+			pending = &PendingModAction{
+				CommunityID: p.CommunityID,
+				ModID:       user,
+				ModGroup:    g,
+				Action:      ModActionRemovePost,
+				TargetID:    p.ID,
+			}
+			return queueModActionTx(ctx, tx, pending, window)
+		}
+		if g == UserGroupAdmins || g == UserGroupMods {
+			return enqueueNotificationTx(ctx, tx, &outboxNotification{
+				Type:      NotificationTypeDeletePost,
+				User:      p.AuthorID,
+				Post:      uid.NullID{Valid: true, ID: p.ID},
+				DeletedAs: g,
+			})
+		}
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	p.Deleted = true
@@ -863,24 +1077,69 @@ func (p *Post) Delete(ctx context.Context, user uid.ID, g UserGroup, deleteConte
 	p.DeletedBy.Valid, p.DeletedBy.ID = true, user
 	p.DeletedAs = g
 
-	if g != UserGroupNormal {
-		RemoveAllReportsOfPost(ctx, p.db, p.ID)
+	if g != UserGroupNormal && pending == nil {
+		afterPostRemoval(ctx, p.db, p.CommunityID, p.ID, user, !wasDeleted)
 	}
 
-	if g == UserGroupAdmins || g == UserGroupMods {
-		go func() {
-			if err := CreatePostDeletedNotification(context.Background(), p.db, p.AuthorID, g, true, p.ID); err != nil {
-				log.Printf("Failed to create deleted_post notification on post %v\n", p.PublicID)
-			}
-		}()
+	return pending, err
+}
+
+// afterPostRemoval records the removal of post by mod in the abuse trends
+// (unless removed is false, if the post was already deleted by its author),
+// and resolves the reports of the post as upheld. Errors are only logged.
+func afterPostRemoval(ctx context.Context, db *sql.DB, community, post, mod uid.ID, removed bool) {
+	if removed {
+		if err := recordRemoval(ctx, db, community, ReportTypePost, post); err != nil {
+			logger.ErrorContext(ctx, "Failed to record removal", "err", err, "post", post)
+		}
+	}
+	if err := upholdReports(ctx, db, ReportTypePost, post, mod); err != nil {
+		logger.ErrorContext(ctx, "Failed to uphold reports", "err", err, "post", post)
 	}
+	RemoveAllReportsOfPost(ctx, db, post)
+}
 
-	return err
+// deleteMediaTx deletes the image, the video, or the link thumbnail of the
+// post. It does not set posts.link_image to NULL.
+func (p *Post) deleteMediaTx(ctx context.Context, tx *sql.Tx) error {
+	if p.Type == PostTypeImage && p.Image != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM post_images WHERE post_id = ?", p.ID); err != nil {
+			return err
+		}
+		if err := images.DeleteImageTx(ctx, tx, p.db, *p.Image.ID); err != nil {
+			return err
+		}
+	} else if p.Type == PostTypeLink && p.LinkImage != nil {
+		if err := images.DeleteImageTx(ctx, tx, p.db, *p.LinkImage.ID); err != nil {
+			return err
+		}
+	} else if p.Type == PostTypeVideo && p.Video != nil {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM post_videos WHERE post_id = ?", p.ID); err != nil {
+			return err
+		}
+		if err := deleteVideoTx(ctx, tx, p.db, p.Video); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// maxLockReasonLength is the maximum length, in characters, of the reason a
+// post is locked.
+const maxLockReasonLength = 255
+
 // Lock locks the post on behalf of user who's locking the post in his or her
-// capacity as g.
-func (p *Post) Lock(ctx context.Context, user uid.ID, g UserGroup) error {
+// capacity as g. The reason, if not empty, is shown to users. If until is
+// non-nil, the post is unlocked at that time (see UnlockExpiredPosts).
+func (p *Post) Lock(ctx context.Context, user uid.ID, g UserGroup, reason string, until *time.Time) error {
+	reason = strings.TrimSpace(reason)
+	if utf8.RuneCountInString(reason) > maxLockReasonLength {
+		return httperr.NewBadRequest("lock-reason-too-long", fmt.Sprintf("Lock reason cannot exceed %d characters.", maxLockReasonLength))
+	}
+	if until != nil && !until.After(time.Now()) {
+		return httperr.NewBadRequest("invalid-locked-until", "Locked until must be in the future.")
+	}
+
 	switch g {
 	case UserGroupMods:
 		is, err := UserMod(ctx, p.db, p.CommunityID, user)
@@ -903,12 +1162,20 @@ func (p *Post) Lock(ctx context.Context, user uid.ID, g UserGroup) error {
 	}
 
 	now := time.Now()
-	_, err := p.db.ExecContext(ctx, "UPDATE posts SET locked = ?, locked_by = ?, locked_by_group = ?, locked_at = ? WHERE id = ?", true, user, g, now, p.ID)
+	lockReason := msql.NewNullString(msql.NilIfEmptyString(reason))
+	var lockedUntil msql.NullTime
+	if until != nil {
+		lockedUntil = msql.NewNullTime(*until)
+	}
+	_, err := p.db.ExecContext(ctx, "UPDATE posts SET locked = ?, locked_by = ?, locked_by_group = ?, locked_at = ?, lock_reason = ?, locked_until = ? WHERE id = ?",
+		true, user, g, now, lockReason, lockedUntil, p.ID)
 	if err == nil {
 		p.Locked = true
 		p.LockedAt = msql.NewNullTime(now)
 		p.LockedBy.Valid, p.LockedBy.ID = true, user
 		p.LockedAs = g
+		p.LockReason = lockReason
+		p.LockedUntil = lockedUntil
 	}
 	return err
 }
@@ -930,16 +1197,59 @@ func (p *Post) Unlock(ctx context.Context, user uid.ID) error {
 		return httperr.NewForbidden("not-mod-not-admin", "User is neither a moderator nor an admin.")
 	}
 
-	_, err = p.db.ExecContext(ctx, "UPDATE posts SET locked = ?, locked_by = null, locked_by_group = ?, locked_at = null WHERE id = ?", false, UserGroupNaN, p.ID)
+	_, err = p.db.ExecContext(ctx, "UPDATE posts SET "+unlockPostSet+" WHERE id = ?", false, UserGroupNaN, p.ID)
 	if err == nil {
 		p.Locked = false
 		p.LockedAt.Valid = false
 		p.LockedBy.Valid = false
 		p.LockedAs = UserGroupNaN
+		p.LockReason.Valid = false
+		p.LockedUntil.Valid = false
 	}
 	return err
 }
 
+// unlockPostSet is the SET clause of the queries that unlock posts (with the
+// arguments false and UserGroupNaN).
+const unlockPostSet = "locked = ?, locked_by = null, locked_by_group = ?, locked_at = null, lock_reason = null, locked_until = null"
+
+// UnlockExpiredPosts unlocks the posts that were locked until a time that's
+// passed. It returns the number of posts unlocked.
+func UnlockExpiredPosts(ctx context.Context, db *sql.DB) (int, error) {
+	res, err := db.ExecContext(ctx, "UPDATE posts SET "+unlockPostSet+" WHERE locked = ? AND locked_until <= ?", false, UserGroupNaN, true, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// postLockedError returns the error of an action that's not allowed on a
+// locked post, with the reason the post was locked and when it's to be
+// unlocked, if they were set.
+func postLockedError(reason msql.NullString, until msql.NullTime) error {
+	if !reason.Valid && !until.Valid {
+		return errPostLocked
+	}
+	err := *errPostLocked.(*httperr.Error)
+	err.Details = make(map[string]any)
+	if reason.Valid {
+		err.Message = fmt.Sprintf("Post is locked: %s", reason.String)
+		err.MessageKey = "error.post_locked_reason"
+		err.Details["reason"] = reason.String
+	}
+	if until.Valid {
+		err.Details["lockedUntil"] = until.Time.UTC().Format(time.RFC3339)
+	}
+	return &err
+}
+
+// lockedError returns the error of an action that's not allowed because the
+// post is locked (see postLockedError).
+func (p *Post) lockedError() error {
+	return postLockedError(p.LockReason, p.LockedUntil)
+}
+
 const MaxPinnedPosts = 2
 
 // Pin pins a post on behalf of user to its community if siteWide is false,
@@ -1029,7 +1339,7 @@ func (p *Post) updatePostsTablesPoints(ctx context.Context) error {
 
 func (p *Post) Vote(ctx context.Context, user uid.ID, up bool) error {
 	if p.Locked {
-		return errPostLocked
+		return p.lockedError()
 	}
 
 	tx, err := p.db.BeginTx(ctx, nil)
@@ -1055,7 +1365,7 @@ func (p *Post) Vote(ctx context.Context, user uid.ID, up bool) error {
 		point = -1
 	}
 
-	query := "UPDATE posts SET points = points + ?, hotness = ?"
+	query := "UPDATE posts SET points = points + ?, hotness = ?, controversy = ?"
 	newUpvotes, newDownvotes := p.Upvotes, p.Downvotes
 	if up {
 		query += ", upvotes = upvotes + 1"
@@ -1066,34 +1376,44 @@ func (p *Post) Vote(ctx context.Context, user uid.ID, up bool) error {
 	}
 	query += " WHERE id = ?"
 
-	_, err = tx.ExecContext(ctx, query, point, PostHotness(newUpvotes, newDownvotes, p.CreatedAt), p.ID)
+	_, err = tx.ExecContext(ctx, query, point, PostHotness(newUpvotes, newDownvotes, p.CreatedAt), Controversy(newUpvotes, newDownvotes), p.ID)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
+	// Notify the author (only of upvotes).
+	if up && !p.AuthorID.EqualsTo(user) {
+		if err = enqueueNotificationTx(ctx, tx, &outboxNotification{
+			Type:      NotificationTypeUpvote,
+			User:      p.AuthorID,
+			Post:      uid.NullID{Valid: true, ID: p.ID},
+			Community: p.CommunityName,
+			IsPost:    true,
+		}); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
 	if err = tx.Commit(); err != nil {
 		return err
 	}
 
 	p.Upvotes = newUpvotes
 	p.Downvotes = newDownvotes
+	p.Controversy = Controversy(newUpvotes, newDownvotes)
+	p.Controversial = Controversial(newUpvotes, newDownvotes)
 	p.Points += point
 	p.ViewerVoted = msql.NewNullBool(true)
 	p.ViewerVotedUp = msql.NewNullBool(up)
 
 	// Attempt to update user's points.
 	if up && !p.AuthorID.EqualsTo(user) {
-		incrementUserPoints(ctx, p.db, p.AuthorID, 1)
+		addUserPoints(ctx, p.db, p.AuthorID, p.CommunityID, true, 1)
 	}
-
-	// Attempt to create a notification (only for upvotes).
-	if !p.AuthorID.EqualsTo(user) && up {
-		go func() {
-			if err := CreateNewVotesNotification(context.Background(), p.db, p.AuthorID, p.CommunityName, true, p.ID); err != nil {
-				log.Printf("Failed creating new_votes notification: %v\n", err)
-			}
-		}()
+	if !p.AuthorID.EqualsTo(user) {
+		autoHidePost(ctx, p.db, user, p.ID, "auto_hide_voted_posts")
 	}
 
 	return p.updatePostsTablesPoints(ctx)
@@ -1102,7 +1422,7 @@ func (p *Post) Vote(ctx context.Context, user uid.ID, up bool) error {
 // DeleteVote undos users's vote on post.
 func (p *Post) DeleteVote(ctx context.Context, user uid.ID) error {
 	if p.Locked {
-		return errPostLocked
+		return p.lockedError()
 	}
 
 	id, up := 0, false
@@ -1122,7 +1442,7 @@ func (p *Post) DeleteVote(ctx context.Context, user uid.ID) error {
 		return err
 	}
 
-	query := "UPDATE posts SET points = points + ?, hotness = ?"
+	query := "UPDATE posts SET points = points + ?, hotness = ?, controversy = ?"
 	point := 1
 	newUpvotes, newDownvotes := p.Upvotes, p.Downvotes
 	if up {
@@ -1135,7 +1455,7 @@ func (p *Post) DeleteVote(ctx context.Context, user uid.ID) error {
 	}
 	query += " WHERE id = ?"
 
-	_, err = tx.ExecContext(ctx, query, point, PostHotness(newUpvotes, newDownvotes, p.CreatedAt), p.ID)
+	_, err = tx.ExecContext(ctx, query, point, PostHotness(newUpvotes, newDownvotes, p.CreatedAt), Controversy(newUpvotes, newDownvotes), p.ID)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -1147,13 +1467,15 @@ func (p *Post) DeleteVote(ctx context.Context, user uid.ID) error {
 
 	p.Upvotes = newUpvotes
 	p.Downvotes = newDownvotes
+	p.Controversy = Controversy(newUpvotes, newDownvotes)
+	p.Controversial = Controversial(newUpvotes, newDownvotes)
 	p.Points += point
 	p.ViewerVoted.Valid = false
 	p.ViewerVotedUp.Valid = false
 
 	// Attempt to update user's points.
 	if up && !p.AuthorID.EqualsTo(user) {
-		incrementUserPoints(ctx, p.db, p.AuthorID, -1)
+		addUserPoints(ctx, p.db, p.AuthorID, p.CommunityID, true, -1)
 	}
 
 	return p.updatePostsTablesPoints(ctx)
@@ -1162,7 +1484,7 @@ func (p *Post) DeleteVote(ctx context.Context, user uid.ID) error {
 // ChangeVote changes user's vote on post.
 func (p *Post) ChangeVote(ctx context.Context, user uid.ID, up bool) error {
 	if p.Locked {
-		return errPostLocked
+		return p.lockedError()
 	}
 
 	id, dbUp := 0, false
@@ -1186,7 +1508,7 @@ func (p *Post) ChangeVote(ctx context.Context, user uid.ID, up bool) error {
 		return err
 	}
 
-	query := "UPDATE posts SET points = points + ?, hotness = ?"
+	query := "UPDATE posts SET points = points + ?, hotness = ?, controversy = ?"
 	points := 2
 	newUpvotes, newDownvotes := p.Upvotes, p.Downvotes
 	if dbUp {
@@ -1201,7 +1523,7 @@ func (p *Post) ChangeVote(ctx context.Context, user uid.ID, up bool) error {
 	}
 	query += " WHERE id = ?"
 
-	_, err = tx.ExecContext(ctx, query, points, PostHotness(newUpvotes, newDownvotes, p.CreatedAt), p.ID)
+	_, err = tx.ExecContext(ctx, query, points, PostHotness(newUpvotes, newDownvotes, p.CreatedAt), Controversy(newUpvotes, newDownvotes), p.ID)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -1213,6 +1535,8 @@ func (p *Post) ChangeVote(ctx context.Context, user uid.ID, up bool) error {
 
 	p.Upvotes = newUpvotes
 	p.Downvotes = newDownvotes
+	p.Controversy = Controversy(newUpvotes, newDownvotes)
+	p.Controversial = Controversial(newUpvotes, newDownvotes)
 	p.Points += points
 	p.ViewerVotedUp = msql.NewNullBool(up)
 
@@ -1222,13 +1546,20 @@ func (p *Post) ChangeVote(ctx context.Context, user uid.ID, up bool) error {
 		if dbUp {
 			point = -1
 		}
-		incrementUserPoints(ctx, p.db, p.AuthorID, point)
+		addUserPoints(ctx, p.db, p.AuthorID, p.CommunityID, true, point)
 	}
 
 	return p.updatePostsTablesPoints(ctx)
 }
 
+// getComments returns the comments selected with where, which is to be a fixed
+// string, as the query is run through a prepared statement.
 func getComments(ctx context.Context, db *sql.DB, viewer *uid.ID, where string, args ...interface{}) ([]*Comment, error) {
+	return selectComments(ctx, db, commentsStmtCache(db), viewer, where, args...)
+}
+
+// selectComments is like getComments except that the query is run with q.
+func selectComments(ctx context.Context, db *sql.DB, q commentsQuerier, viewer *uid.ID, where string, args ...interface{}) ([]*Comment, error) {
 	var (
 		loggedIn = viewer != nil
 		query    = buildSelectCommentsQuery(loggedIn, where)
@@ -1249,9 +1580,9 @@ func getComments(ctx context.Context, db *sql.DB, viewer *uid.ID, where string,
 		for i := range args {
 			args2[i+1] = args[i]
 		}
-		rows, err = db.QueryContext(ctx, query, args2...)
+		rows, err = q.QueryContext(ctx, query, args2...)
 	} else {
-		rows, err = db.QueryContext(ctx, query, args...)
+		rows, err = q.QueryContext(ctx, query, args...)
 	}
 	if err != nil {
 		return ret(nil, err)
@@ -1274,29 +1605,103 @@ func getCommentsList(ctx context.Context, db *sql.DB, viewer *uid.ID, IDs []uid.
 		args[i] = IDs[i]
 	}
 
-	c, err := getComments(ctx, db, viewer, where, args...)
+	// Not prepared, since there's a query for each number of IDs.
+	c, err := selectComments(ctx, db, db, viewer, where, args...)
 	if err != nil {
 		return nil, err
 	}
 	return c, nil
 }
 
-// CommentsCursor is an API pagination cursor.
+// CommentSort is the order in which the comments of a post are listed.
+type CommentSort string
+
+const (
+	CommentSortTop           = CommentSort("top")           // The most upvoted first.
+	CommentSortNew           = CommentSort("new")           // The newest first.
+	CommentSortControversial = CommentSort("controversial") // The most controversial first.
+)
+
+// Valid reports whether s is a valid CommentSort.
+func (s CommentSort) Valid() bool {
+	return s == CommentSortTop || s == CommentSortNew || s == CommentSortControversial
+}
+
+// A CommentsCursor is the position of a page of comments. Score is the
+// upvotes, for CommentSortTop, or the controversy, for
+// CommentSortControversial, of the next comment (and it's unused for
+// CommentSortNew).
 type CommentsCursor struct {
-	Upvotes int
-	NextID  uid.ID
+	Score  int
+	NextID uid.ID
 }
 
-// GetComments populates c.Comments and returns the next comment's cursor.
-func (p *Post) GetComments(ctx context.Context, viewer *uid.ID, cursor *CommentsCursor) (*CommentsCursor, error) {
+// GetComments populates c.Comments, sorted by sort (or, if it's empty, by the
+// default comment sort of the post's community), and returns the next
+// comment's cursor.
+//
+// The first page of comments of posts with many comments is, for logged out
+// users, served from a snapshot, one for each sort (in which case the returned
+// cursor is nil and the next page's cursor is only found in p.CommentsNext).
+//
+// Comments deeper than commentsContinueDepth levels are left out, behind
+// continuations (see continueThreads), and chains of self-replies are marked
+// (see markSelfReplyChains).
+func (p *Post) GetComments(ctx context.Context, viewer *uid.ID, sort CommentSort, cursor *CommentsCursor) (_ *CommentsCursor, err error) {
+	if sort == "" {
+		sort = p.defaultCommentSort
+	}
+	if !sort.Valid() {
+		sort = CommentSortTop
+	}
+	p.CommentsSort = sort
+
+	snapshot := viewer == nil && cursor == nil && p.NumComments >= commentSnapshotThreshold
+	ctx, span := startSpan(ctx, "core.Post.GetComments",
+		attribute.String("post", p.PublicID),
+		attribute.Int("num_comments", p.NumComments),
+		attribute.String("sort", string(sort)),
+		attribute.Bool("snapshot", snapshot))
+	defer func() { endSpan(span, err) }()
+
+	var next *CommentsCursor
+	if snapshot {
+		err = p.loadCommentsFromSnapshot(ctx, sort)
+	} else {
+		next, err = p.fetchComments(ctx, viewer, sort, cursor)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.Comments = continueThreads(p.Comments, commentsContinueDepth, false)
+	markSelfReplyChains(p.Comments)
+	return next, nil
+}
+
+// fetchComments is GetComments without snapshots.
+func (p *Post) fetchComments(ctx context.Context, viewer *uid.ID, sort CommentSort, cursor *CommentsCursor) (*CommentsCursor, error) {
 	var args []any
-	where := "WHERE comments.post_id = ? "
-	args = append(args, p.ID)
-	if cursor != nil {
-		where += "AND (comments.upvotes, comments.id) <= (?, ?) "
-		args = append(args, cursor.Upvotes, cursor.NextID)
+	where := "WHERE comments.post_id = ? AND comments.depth < ? "
+	args = append(args, p.ID, commentsContinueDepth)
+	if sort == CommentSortNew {
+		if cursor != nil {
+			where += "AND comments.id <= ? "
+			args = append(args, cursor.NextID)
+		}
+		where += "ORDER BY comments.id DESC LIMIT ?"
+	} else if sort == CommentSortControversial {
+		if cursor != nil {
+			where += "AND (comments.controversy, comments.id) <= (?, ?) "
+			args = append(args, cursor.Score, cursor.NextID)
+		}
+		where += "ORDER BY comments.controversy DESC, comments.id DESC LIMIT ?"
+	} else {
+		if cursor != nil {
+			where += "AND (comments.upvotes, comments.id) <= (?, ?) "
+			args = append(args, cursor.Score, cursor.NextID)
+		}
+		where += "ORDER BY upvotes DESC, comments.id DESC LIMIT ?"
 	}
-	where += "ORDER BY upvotes DESC, comments.id DESC LIMIT ?"
 	args = append(args, commentsFetchLimit+1)
 
 	all, err := getComments(ctx, p.db, viewer, where, args...)
@@ -1309,7 +1714,10 @@ func (p *Post) GetComments(ctx context.Context, viewer *uid.ID, cursor *Comments
 	var nextCursor *CommentsCursor
 	if len(all) >= commentsFetchLimit+1 {
 		nextCursor = new(CommentsCursor)
-		nextCursor.Upvotes = all[commentsFetchLimit].Upvotes
+		nextCursor.Score = all[commentsFetchLimit].Upvotes
+		if sort == CommentSortControversial {
+			nextCursor.Score = all[commentsFetchLimit].Controversy
+		}
 		nextCursor.NextID = all[commentsFetchLimit].ID
 		comments = all[:commentsFetchLimit]
 	}
@@ -1348,14 +1756,15 @@ func (p *Post) GetComments(ctx context.Context, viewer *uid.ID, cursor *Comments
 	}
 
 	if nextCursor != nil {
-		p.CommentsNext.String = strconv.Itoa(nextCursor.Upvotes) + "." + nextCursor.NextID.String()
+		p.CommentsNext.String = strconv.Itoa(nextCursor.Score) + "." + nextCursor.NextID.String()
 		p.CommentsNext.Valid = true
 	}
 
 	return nextCursor, nil
 }
 
-// GetCommentReplies returns all the replies of comment.
+// GetCommentReplies returns all the replies of comment, with their chains of
+// self-replies marked.
 func (p *Post) GetCommentReplies(ctx context.Context, viewer *uid.ID, comment uid.ID) ([]*Comment, error) {
 	rows, err := p.db.QueryContext(ctx, "SELECT reply_id FROM comment_replies WHERE parent_id = ?", comment)
 	if err != nil {
@@ -1371,13 +1780,21 @@ func (p *Post) GetCommentReplies(ctx context.Context, viewer *uid.ID, comment ui
 		return nil, nil
 	}
 
-	return getCommentsList(ctx, p.db, viewer, ids)
+	replies, err := getCommentsList(ctx, p.db, viewer, ids)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.markReplySelfReplyChains(ctx, comment, replies); err != nil {
+		return nil, err
+	}
+	return replies, nil
 }
 
-// AddComment adds a new comment to post.
-func (p *Post) AddComment(ctx context.Context, user uid.ID, g UserGroup, parentComment *uid.ID, body string) (*Comment, error) {
+// AddComment adds a new comment to post. If image is non-nil, it's attached to
+// the comment, and it must be an image saved by user with SaveCommentImage.
+func (p *Post) AddComment(ctx context.Context, user uid.ID, g UserGroup, parentComment *uid.ID, body string, image *uid.ID) (*Comment, error) {
 	if p.Locked {
-		return nil, errPostLocked
+		return nil, p.lockedError()
 	}
 
 	// Check if author is banned from community.
@@ -1411,8 +1828,37 @@ func (p *Post) AddComment(ctx context.Context, user uid.ID, g UserGroup, parentC
 		return nil, errInvalidUserGroup
 	}
 
+	if image != nil {
+		var allowed, noGIFs bool
+		if err := p.db.QueryRowContext(ctx, "SELECT allow_comment_images, disable_gifs FROM communities WHERE id = ?", p.CommunityID).Scan(&allowed, &noGIFs); err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, errCommentImagesNotAllowed
+		}
+		if noGIFs {
+			record, err := images.GetImageRecord(ctx, p.db, *image)
+			if err != nil {
+				if err == images.ErrImageNotFound {
+					return nil, errImageNotFound
+				}
+				return nil, err
+			}
+			if record.Format == images.ImageFormatGIF {
+				return nil, errGIFsNotAllowed
+			}
+		}
+		var n int
+		if err := p.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM temp_images_2 WHERE image_id = ? AND user_id = ?", *image, user).Scan(&n); err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, errImageNotFound
+		}
+	}
+
 	body = strings.TrimSpace(body)
-	comment, err := addComment(ctx, p.db, p, u, parentComment, body)
+	comment, err := addComment(ctx, p.db, p, u, parentComment, body, &addCommentOpts{image: image})
 	if err != nil {
 		return nil, err
 	}
@@ -1459,6 +1905,75 @@ func (p *Post) ChangeUserGroup(ctx context.Context, user uid.ID, g UserGroup) er
 	return err
 }
 
+// Distinguish changes the capacity in which the post was submitted to g, on
+// behalf of its author, by a mod or an admin (see checkDistinguish).
+func (p *Post) Distinguish(ctx context.Context, by uid.ID, g UserGroup) error {
+	if p.Deleted {
+		return errPostDeleted
+	}
+	if p.PostedAs == g {
+		return nil
+	}
+	if err := checkDistinguish(ctx, p.db, p.CommunityID, by, p.AuthorID, p.PostedAs, g); err != nil {
+		return err
+	}
+
+	_, err := p.db.ExecContext(ctx, "UPDATE posts SET user_group = ? WHERE id = ? AND deleted_at IS NULL", g, p.ID)
+	if err == nil {
+		p.PostedAs = g
+	}
+	return err
+}
+
+// checkDistinguish returns an httperr.Error if by may not change the capacity
+// in which author submitted content in community from one group to another.
+// Content may be distinguished only in a capacity its author has (as a mod of
+// community or as an admin), and only by someone of that group. Content
+// distinguished as admins may only be undistinguished by admins.
+func checkDistinguish(ctx context.Context, db *sql.DB, community, by, author uid.ID, from, to UserGroup) error {
+	isAdmin := func(user uid.ID) (bool, error) {
+		var is bool
+		err := db.QueryRowContext(ctx, "SELECT is_admin FROM users WHERE id = ?", user).Scan(&is)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return is, err
+	}
+
+	switch to {
+	case UserGroupNormal:
+	case UserGroupMods:
+		if is, err := UserMod(ctx, db, community, author); err != nil {
+			return err
+		} else if !is {
+			return errAuthorNotInGroup
+		}
+	case UserGroupAdmins:
+		if is, err := isAdmin(author); err != nil {
+			return err
+		} else if !is {
+			return errAuthorNotInGroup
+		}
+	default:
+		return errInvalidUserGroup
+	}
+
+	if from == UserGroupAdmins || to == UserGroupAdmins {
+		if is, err := isAdmin(by); err != nil {
+			return err
+		} else if !is {
+			return errNotAdmin
+		}
+		return nil
+	}
+	if is, err := UserModOrAdmin(ctx, db, community, by); err != nil {
+		return err
+	} else if !is {
+		return errNotMod
+	}
+	return nil
+}
+
 // PurgePostsFromTempTables removes posts from posts_today, posts_week, etc
 // tables. Call this function periodically.
 func PurgePostsFromTempTables(ctx context.Context, db *sql.DB) error {
@@ -1468,7 +1983,12 @@ func PurgePostsFromTempTables(ctx context.Context, db *sql.DB) error {
 		total := 0
 		for again {
 			t := time.Now().Add(postsTablesValidity[i])
-			res, err := db.ExecContext(ctx, "DELETE FROM "+table+" WHERE created_at < ? LIMIT ?", t, bulk)
+			query, args := "DELETE FROM "+table+" WHERE created_at < ?", []any{t}
+			if !msql.IsSQLite() { // SQLite doesn't support DELETE ... LIMIT.
+				query += " LIMIT ?"
+				args = append(args, bulk)
+			}
+			res, err := db.ExecContext(ctx, query, args...)
 			if err != nil {
 				return err
 			}
@@ -1494,6 +2014,24 @@ func IsPostLocked(ctx context.Context, db *sql.DB, post uid.ID) (bool, error) {
 	return is, err
 }
 
+// checkPostNotLocked returns an error if post is locked (see
+// postLockedError).
+func checkPostNotLocked(ctx context.Context, db *sql.DB, post uid.ID) error {
+	var (
+		locked bool
+		reason msql.NullString
+		until  msql.NullTime
+	)
+	row := db.QueryRowContext(ctx, "SELECT locked, lock_reason, locked_until FROM posts WHERE id = ?", post)
+	if err := row.Scan(&locked, &reason, &until); err != nil {
+		return err
+	}
+	if locked {
+		return postLockedError(reason, until)
+	}
+	return nil
+}
+
 // PostHotness calculates the hotness score of a post.
 func PostHotness(upvotes, downvotes int, date time.Time) int {
 	s := 0
@@ -1559,7 +2097,7 @@ func UpdateAllPostsHotness(ctx context.Context, db *sql.DB) error {
 				return err
 			}
 			if _, err := tx.ExecContext(ctx, "UPDATE posts SET hotness = ? WHERE id = ?", PostHotness(upvotes, downvotes, createdAt), postID); err != nil {
-				log.Println(err)
+				logger.ErrorContext(ctx, "Failed to update post hotness", "err", err, "post", postID)
 				goOn = false
 				break
 			}
@@ -1587,27 +2125,48 @@ func UpdateAllPostsHotness(ctx context.Context, db *sql.DB) error {
 		}
 	}
 
-	log.Printf("Fixed %v posts", totalCount)
+	logger.InfoContext(ctx, "Updated the hotness of all posts", "count", totalCount)
 	return nil
 }
 
+// saveImageError wraps err, returned by images.SaveImageTx, with msg, unless
+// it's to be shown to the user.
+func saveImageError(msg string, err error) error {
+	if errors.Is(err, images.ErrImageRejected) {
+		return errImageRejected
+	}
+	if errors.Is(err, images.ErrInvalidCrop) {
+		return errInvalidCrop
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
 func SavePostImage(ctx context.Context, db *sql.DB, authorID uid.ID, image []byte) (*images.ImageRecord, error) {
+	return saveTempImage(ctx, db, authorID, image, &images.ImageOptions{
+		Width:  5000,
+		Height: 5000,
+		Format: images.ImageFormatJPEG,
+		Fit:    images.ImageFitContain,
+	})
+}
+
+// saveTempImage saves image and adds it to the temp images table (the rows of
+// which are deleted once the image is used in a post or a comment).
+func saveTempImage(ctx context.Context, db *sql.DB, authorID uid.ID, image []byte, opts *images.ImageOptions) (*images.ImageRecord, error) {
 	var imageID uid.ID
 	err := msql.Transact(ctx, db, func(tx *sql.Tx) (err error) {
-		id, err := images.SaveImageTx(ctx, tx, "disk", image, &images.ImageOptions{
-			Width:  5000,
-			Height: 5000,
-			Format: images.ImageFormatJPEG,
-			Fit:    images.ImageFitContain,
-		})
+		id, err := images.SaveImageTx(ctx, tx, images.DefaultStore, image, opts)
 		if err != nil {
-			return fmt.Errorf("failed to save post image (author: %v): %w", authorID, err)
+			return saveImageError(fmt.Sprintf("failed to save image (author: %v)", authorID), err)
 		}
 		imageID = id
 		if _, err := tx.ExecContext(ctx, "INSERT INTO temp_images_2 (user_id, image_id) values (?, ?)", authorID, imageID); err != nil {
 			return fmt.Errorf("failed to insert row into temp_images (author: %v, image: %v): %w", authorID, imageID, err)
 		}
-		return nil
+		if err := addImageFingerprintTx(ctx, tx, imageID, authorID); err != nil {
+			return err
+		}
+		return addImageUploadTx(ctx, tx, imageID, &authorID, nil)
 	})
 	if err != nil {
 		return nil, err
@@ -1619,7 +2178,12 @@ func SavePostImage(ctx context.Context, db *sql.DB, authorID uid.ID, image []byt
 // many were removed.
 func RemoveTempImages(ctx context.Context, db *sql.DB) (int, error) {
 	t := time.Now().Add(-time.Hour * 12)
-	rows, err := db.QueryContext(ctx, "select image_id from temp_images_2 where created_at < ?", t)
+	// The images of held posts and comments are kept until they are reviewed.
+	rows, err := db.QueryContext(ctx, `
+		SELECT image_id FROM temp_images_2
+		WHERE created_at < ?
+			AND NOT EXISTS (SELECT 1 FROM held_posts WHERE held_posts.image_id = temp_images_2.image_id)
+			AND NOT EXISTS (SELECT 1 FROM held_comments WHERE held_comments.image_id = temp_images_2.image_id)`, t)
 	if err != nil {
 		return 0, err
 	}
@@ -1663,11 +2227,57 @@ func RemoveTempImages(ctx context.Context, db *sql.DB) (int, error) {
 	return len(imageIDs), nil
 }
 
+// RemoveOrphanedImages deletes images older than age that are used neither
+// by any user, community, post, comment, nor video (nor are temp images). It
+// returns how many were deleted.
+func RemoveOrphanedImages(ctx context.Context, db *sql.DB, age time.Duration) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT images.id FROM images
+		WHERE images.created_at < ?
+			AND NOT EXISTS (SELECT 1 FROM users WHERE users.pro_pic = images.id)
+			AND NOT EXISTS (SELECT 1 FROM communities WHERE communities.pro_pic_2 = images.id OR communities.banner_image_2 = images.id)
+			AND NOT EXISTS (SELECT 1 FROM posts WHERE posts.link_image = images.id)
+			AND NOT EXISTS (SELECT 1 FROM post_images WHERE post_images.image_id = images.id)
+			AND NOT EXISTS (SELECT 1 FROM comment_images WHERE comment_images.image_id = images.id)
+			AND NOT EXISTS (SELECT 1 FROM temp_images_2 WHERE temp_images_2.image_id = images.id)
+			AND NOT EXISTS (SELECT 1 FROM videos WHERE videos.poster_image = images.id)
+		LIMIT 1000`, time.Now().Add(-age))
+	if err != nil {
+		return 0, err
+	}
+
+	var imageIDs []uid.ID
+	for rows.Next() {
+		var imageID uid.ID
+		if err = rows.Scan(&imageID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		imageIDs = append(imageIDs, imageID)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range imageIDs {
+		err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+			return images.DeleteImageTx(ctx, tx, db, id)
+		})
+		if err != nil {
+			return n, fmt.Errorf("failed to delete orphaned image %v: %w", id, err)
+		}
+		n++
+	}
+	return n, nil
+}
+
 // postLink is the link metadata of a link post as stored in the database.
 type postLink struct {
-	Version  int    `json:"v"`
-	URL      string `json:"u"`
-	Hostname string `json:"h"`
+	Version  int           `json:"v"`
+	URL      string        `json:"u"`
+	Hostname string        `json:"h"`
+	Embed    *oembed.Embed `json:"e,omitempty"`
 }
 
 func (pl *postLink) PostLink() *PostLink {
@@ -1675,6 +2285,7 @@ func (pl *postLink) PostLink() *PostLink {
 		Version:  pl.Version,
 		URL:      pl.URL,
 		Hostname: pl.Hostname,
+		Embed:    pl.Embed,
 	}
 }
 
@@ -1684,6 +2295,10 @@ type PostLink struct {
 	URL      string        `json:"url"`
 	Hostname string        `json:"hostname"`
 	Image    *images.Image `json:"image"`
+
+	// If the link is to content that can be embedded (a YouTube video, for
+	// example), its metadata.
+	Embed *oembed.Embed `json:"embed,omitempty"`
 }
 
 func (pl *PostLink) SetImageCopies() {
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+const maxPostLicenseTextLength = 1000 // in runes.
+
+// PostLicense is the license under which the content of a post (its image,
+// say) may be reused.
+type PostLicense string
+
+// These are all the valid PostLicenses.
+const (
+	PostLicenseNone   = PostLicense("")       // All rights reserved.
+	PostLicenseCCBY   = PostLicense("cc-by")  // Creative Commons Attribution 4.0.
+	PostLicenseCC0    = PostLicense("cc0")    // Creative Commons Zero (public domain).
+	PostLicenseCustom = PostLicense("custom") // As given in the license text of the post.
+)
+
+// Valid reports whether l is a valid PostLicense.
+func (l PostLicense) Valid() bool {
+	switch l {
+	case PostLicenseNone, PostLicenseCCBY, PostLicenseCC0, PostLicenseCustom:
+		return true
+	}
+	return false
+}
+
+// Validate returns an error if l is not a valid PostLicense, or if it's a
+// custom license and text is not a valid license text.
+func (l PostLicense) Validate(text string) error {
+	if !l.Valid() {
+		return httperr.NewInvalidField("license", "invalid-license", "License must be either cc-by, cc0, custom, or empty.")
+	}
+	if l == PostLicenseCustom {
+		if strings.TrimSpace(text) == "" {
+			return httperr.NewInvalidField("licenseText", "license-text-empty", "Custom licenses need a license text.")
+		}
+		if utf8.RuneCountInString(text) > maxPostLicenseTextLength {
+			return httperr.NewInvalidField("licenseText", "license-text-too-long", "License text is too long.")
+		}
+	}
+	return nil
+}
+
+// Name returns the name of l, or the empty string for custom licenses and
+// none.
+func (l PostLicense) Name() string {
+	switch l {
+	case PostLicenseCCBY:
+		return "CC BY 4.0"
+	case PostLicenseCC0:
+		return "CC0 1.0"
+	}
+	return ""
+}
+
+// URL returns the URL of the deed of l, or the empty string for custom
+// licenses and none.
+func (l PostLicense) URL() string {
+	switch l {
+	case PostLicenseCCBY:
+		return "https://creativecommons.org/licenses/by/4.0/"
+	case PostLicenseCC0:
+		return "https://creativecommons.org/publicdomain/zero/1.0/"
+	}
+	return ""
+}
+
+// Rights returns a plain text statement of the license of p (as in the rights
+// element of feeds), or the empty string if it has none.
+func (p *Post) Rights() string {
+	switch p.License {
+	case PostLicenseNone:
+		return ""
+	case PostLicenseCustom:
+		return p.LicenseText.String
+	}
+	return p.License.Name() + " (" + p.License.URL() + ")"
+}
+
+// SetLicense changes the license of p, on behalf of its author, user, to
+// license. The license text, text, is required for custom licenses, and is
+// ignored for others.
+func (p *Post) SetLicense(ctx context.Context, user uid.ID, license PostLicense, text string) error {
+	if !p.AuthorID.EqualsTo(user) {
+		return errNotAuthor
+	}
+	text = strings.TrimSpace(text)
+	if err := license.Validate(text); err != nil {
+		return err
+	}
+	var licenseText msql.NullString
+	if license == PostLicenseCustom {
+		licenseText = msql.NewNullString(text)
+	}
+
+	if _, err := p.db.ExecContext(ctx, "UPDATE posts SET license = ?, license_text = ? WHERE id = ?", license, licenseText, p.ID); err != nil {
+		return err
+	}
+	p.License, p.LicenseText = license, licenseText
+	return nil
+}
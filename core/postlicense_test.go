@@ -0,0 +1,45 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+)
+
+func TestPostLicenseValidate(t *testing.T) {
+	tests := []struct {
+		license PostLicense
+		text    string
+		valid   bool
+	}{
+		{PostLicenseNone, "", true},
+		{PostLicenseCCBY, "", true},
+		{PostLicenseCC0, "ignored", true},
+		{PostLicenseCustom, "Non-commercial use only.", true},
+		{PostLicenseCustom, "  ", false},
+		{PostLicenseCustom, strings.Repeat("a", maxPostLicenseTextLength+1), false},
+		{PostLicense("gpl"), "", false},
+	}
+	for _, test := range tests {
+		if err := test.license.Validate(test.text); (err == nil) != test.valid {
+			t.Errorf("PostLicense(%q).Validate(%q) = %v, want valid %v", test.license, test.text, err, test.valid)
+		}
+	}
+}
+
+func TestPostRights(t *testing.T) {
+	tests := []struct {
+		post *Post
+		want string
+	}{
+		{&Post{}, ""},
+		{&Post{License: PostLicenseCCBY}, "CC BY 4.0 (https://creativecommons.org/licenses/by/4.0/)"},
+		{&Post{License: PostLicenseCustom, LicenseText: msql.NewNullString("Ask first.")}, "Ask first."},
+	}
+	for _, test := range tests {
+		if got := test.post.Rights(); got != test.want {
+			t.Errorf("Rights() of a post with license %q = %q, want %q", test.post.License, got, test.want)
+		}
+	}
+}
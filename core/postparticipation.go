@@ -0,0 +1,118 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// participationHours is the number of hours, up to now, in the vote timeline
+// of PostParticipation.
+const participationHours = 24
+
+// defaultNewAccountAge is the age under which accounts count as new in a
+// PostParticipation, if there's no NewAccountPolicy.
+const defaultNewAccountAge = 7 * 24 * time.Hour
+
+// PostVotesHour is the votes cast on a post in the hour starting at Time (of
+// the votes that were not undone since).
+type PostVotesHour struct {
+	Time      time.Time `json:"time"`
+	Upvotes   int       `json:"upvotes"`
+	Downvotes int       `json:"downvotes"`
+}
+
+// PostParticipation is a summary of who's taking part in a post, and how
+// fast, for the mods to tell whether the post needs locking (or the like).
+type PostParticipation struct {
+	Comments             int     `json:"comments"`
+	Commenters           int     `json:"commenters"` // Unique.
+	NewAccountCommenters int     `json:"newAccountCommenters"`
+	NewAccountPercent    float64 `json:"newAccountPercent"` // Of the commenters.
+
+	// The votes in each hour of the last participationHours hours (or since
+	// the post was posted, if it's younger), the oldest first.
+	Votes []*PostVotesHour `json:"votes"`
+
+	Reports     int `json:"reports"` // Of the post and its comments.
+	OpenReports int `json:"openReports"`
+}
+
+// GetPostParticipation returns the participation summary of post. Commenters
+// are new accounts as per policy (admins excepted), or, if policy is nil, if
+// they are younger than defaultNewAccountAge.
+func GetPostParticipation(ctx context.Context, db *sql.DB, post *Post, policy *NewAccountPolicy) (*PostParticipation, error) {
+	if policy == nil {
+		policy = &NewAccountPolicy{MinAge: defaultNewAccountAge}
+	}
+	now := time.Now()
+	p := &PostParticipation{}
+
+	newCond, args := "FALSE", []any{}
+	if policy.MinAge > 0 {
+		newCond += " OR users.created_at > ?"
+		args = append(args, now.Add(-policy.MinAge))
+	}
+	if policy.MinPoints > 0 {
+		newCond += " OR users.points < ?"
+		args = append(args, policy.MinPoints)
+	}
+	args = append(args, post.ID)
+	row := db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(DISTINCT comments.user_id), COUNT(DISTINCT CASE WHEN users.is_admin = FALSE AND (`+newCond+`) THEN users.id END)
+		FROM comments
+		INNER JOIN users ON users.id = comments.user_id
+		WHERE comments.post_id = ? AND comments.deleted_at IS NULL`, args...)
+	if err := row.Scan(&p.Comments, &p.Commenters, &p.NewAccountCommenters); err != nil {
+		return nil, err
+	}
+	if p.Commenters > 0 {
+		p.NewAccountPercent = float64(p.NewAccountCommenters) * 100 / float64(p.Commenters)
+	}
+
+	from := now.UTC().Truncate(time.Hour).Add(-(participationHours - 1) * time.Hour)
+	if created := post.CreatedAt.UTC().Truncate(time.Hour); created.After(from) {
+		from = created
+	}
+	p.Votes = []*PostVotesHour{}
+	byTime := make(map[time.Time]*PostVotesHour)
+	for t := from; !t.After(now); t = t.Add(time.Hour) {
+		hour := &PostVotesHour{Time: t}
+		p.Votes = append(p.Votes, hour)
+		byTime[t] = hour
+	}
+	rows, err := db.QueryContext(ctx, "SELECT created_at, up FROM post_votes WHERE post_id = ? AND created_at >= ?", post.ID, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			t  time.Time
+			up bool
+		)
+		if err := rows.Scan(&t, &up); err != nil {
+			return nil, err
+		}
+		hour := byTime[t.UTC().Truncate(time.Hour)]
+		if hour == nil {
+			continue
+		}
+		if up {
+			hour.Upvotes++
+		} else {
+			hour.Downvotes++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	row = db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN dealt_at IS NULL THEN 1 ELSE 0 END), 0)
+		FROM reports WHERE post_id = ?`, post.ID)
+	if err := row.Scan(&p.Reports, &p.OpenReports); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
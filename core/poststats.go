@@ -0,0 +1,83 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// maxPostStatsPoints is the maximum number of points in the series returned
+// by GetPostStats.
+const maxPostStatsPoints = 1000
+
+// PostStatsPoint is the views, votes, and comments of a post in the hour or
+// the day starting at Time. Votes are net of the votes that were undone.
+type PostStatsPoint struct {
+	Time      time.Time `json:"time"`
+	Views     int64     `json:"views"`
+	Upvotes   int64     `json:"upvotes"`
+	Downvotes int64     `json:"downvotes"`
+	Comments  int64     `json:"comments"`
+}
+
+func (p *PostStatsPoint) add(q *PostStatsPoint) {
+	p.Views += q.Views
+	p.Upvotes += q.Upvotes
+	p.Downvotes += q.Downvotes
+	p.Comments += q.Comments
+}
+
+// PostStats is how a post did since it was posted.
+type PostStats struct {
+	Interval string            `json:"interval"` // Of the points of Series: hour or day.
+	Totals   PostStatsPoint    `json:"totals"`   // Time is that of the first point.
+	Series   []*PostStatsPoint `json:"series"`
+}
+
+// GetPostStats returns the statistics of the post p, counted by
+// AnalyticsCounter, in each hour (or, if daily is true, each day, in UTC)
+// since it was posted. The series has a point for every hour (or day), even
+// those without events, up to maxPostStatsPoints.
+func GetPostStats(ctx context.Context, db *sql.DB, p *Post, daily bool) (*PostStats, error) {
+	step, interval := time.Hour, "hour"
+	if daily {
+		step, interval = 24*time.Hour, "day"
+	}
+	from := p.CreatedAt.UTC().Truncate(step)
+	to := from.Add(maxPostStatsPoints * step)
+	if now := time.Now(); to.After(now) {
+		to = now
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT hour, views, upvotes, downvotes, comments
+		FROM post_stats
+		WHERE post_id = ? AND hour >= ? AND hour < ?
+		ORDER BY hour`, p.ID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := &PostStats{Interval: interval, Totals: PostStatsPoint{Time: from}, Series: []*PostStatsPoint{}}
+	byTime := make(map[time.Time]*PostStatsPoint)
+	for t := from; t.Before(to); t = t.Add(step) {
+		point := &PostStatsPoint{Time: t}
+		stats.Series = append(stats.Series, point)
+		byTime[t] = point
+	}
+	for rows.Next() {
+		row := &PostStatsPoint{}
+		if err := rows.Scan(&row.Time, &row.Views, &row.Upvotes, &row.Downvotes, &row.Comments); err != nil {
+			return nil, err
+		}
+		if point := byTime[row.Time.UTC().Truncate(step)]; point != nil {
+			point.add(row)
+		}
+		stats.Totals.add(row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+const (
+	// postVisitGap is how long after the last request of a visit to a post
+	// that a request starts a new visit. The comments shown as new in a visit
+	// are those added since the previous visit.
+	postVisitGap = 30 * time.Minute
+
+	// postVisitTTL is how long the visits to a post are remembered.
+	postVisitTTL = 180 * 24 * time.Hour
+)
+
+// VisitPost records that user is visiting post, and returns when the user's
+// previous visit to it was, or a null time if it's the user's first.
+func VisitPost(ctx context.Context, db *sql.DB, user, post uid.ID) (msql.NullTime, error) {
+	var previous msql.NullTime
+	err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		var visitedAt time.Time
+		previous = msql.NullTime{}
+		row := tx.QueryRowContext(ctx, "SELECT visited_at, previous_visited_at FROM post_visits WHERE user_id = ? AND post_id = ?", user, post)
+		err := row.Scan(&visitedAt, &previous)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		now := time.Now()
+		if err == nil && now.Sub(visitedAt) > postVisitGap {
+			previous = msql.NewNullTime(visitedAt) // A new visit.
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO post_visits (user_id, post_id, visited_at, previous_visited_at) VALUES (?, ?, ?, ?) "+
+			msql.UpsertClause([]string{"user_id", "post_id"}, "visited_at", "previous_visited_at"), user, post, now, previous)
+		return err
+	})
+	return previous, err
+}
+
+// MarkNewComments sets the IsNew field of the comments that were added since
+// since by others than viewer.
+func MarkNewComments(comments []*Comment, viewer uid.ID, since time.Time) {
+	for _, c := range comments {
+		c.IsNew = c.CreatedAt.After(since) && !c.Deleted() && !c.AuthorID.EqualsTo(viewer)
+	}
+}
+
+// PrunePostVisits deletes the visits to posts older than postVisitTTL.
+func PrunePostVisits(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM post_visits WHERE visited_at < ?", time.Now().Add(-postVisitTTL))
+	return err
+}
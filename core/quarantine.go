@@ -0,0 +1,284 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// signupsTTL is how long signups are remembered for velocity checks.
+const signupsTTL = 24 * time.Hour
+
+// RecordSignup records that user signed up from the IP address ip, which is
+// part of network (an autonomous system, or an address prefix).
+func RecordSignup(ctx context.Context, db *sql.DB, user uid.ID, ip, network string) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO signups (user_id, ip, network, created_at) VALUES (?, ?, ?, ?)", user, ip, network, time.Now())
+	return err
+}
+
+// CountSignups returns the number of signups, since the time since, from the
+// IP address ip and from network.
+func CountSignups(ctx context.Context, db *sql.DB, ip, network string, since time.Time) (fromIP, fromNetwork int, err error) {
+	if err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM signups WHERE ip = ? AND created_at > ?", ip, since).Scan(&fromIP); err != nil {
+		return
+	}
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM signups WHERE network = ? AND created_at > ?", network, since).Scan(&fromNetwork)
+	return
+}
+
+// PruneSignups removes the signups that are too old to matter to velocity
+// checks.
+func PruneSignups(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM signups WHERE created_at < ?", time.Now().Add(-signupsTTL))
+	return err
+}
+
+// QuarantinedUser is a suspicious account whose posts and comments are held
+// for review.
+type QuarantinedUser struct {
+	UserID    uid.ID    `json:"userId"`
+	Username  string    `json:"username"`
+	Reason    string    `json:"reason"`
+	Approved  int       `json:"approved"` // The number of held posts and comments approved.
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// QuarantineUser quarantines user for reason.
+func QuarantineUser(ctx context.Context, db *sql.DB, user uid.ID, reason string) error {
+	query := "INSERT INTO quarantined_users (user_id, reason, created_at) VALUES (?, ?, ?) " + msql.UpsertClause([]string{"user_id"}, "reason")
+	_, err := db.ExecContext(ctx, query, user, reason, time.Now())
+	return err
+}
+
+// IsUserQuarantined reports whether user is quarantined.
+func IsUserQuarantined(ctx context.Context, db *sql.DB, user uid.ID) (bool, error) {
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM quarantined_users WHERE user_id = ?", user).Scan(&n)
+	return n > 0, err
+}
+
+// GetQuarantinedUsers returns the quarantined users, newest first.
+func GetQuarantinedUsers(ctx context.Context, db *sql.DB) ([]*QuarantinedUser, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT quarantined_users.user_id, users.username, quarantined_users.reason, quarantined_users.approved, quarantined_users.created_at
+		FROM quarantined_users
+		INNER JOIN users ON users.id = quarantined_users.user_id
+		ORDER BY quarantined_users.created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*QuarantinedUser{}
+	for rows.Next() {
+		u := &QuarantinedUser{}
+		if err := rows.Scan(&u.UserID, &u.Username, &u.Reason, &u.Approved, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, u)
+	}
+	return items, rows.Err()
+}
+
+// ReleaseUser lifts the quarantine of user.
+func ReleaseUser(ctx context.Context, db *sql.DB, user uid.ID) error {
+	res, err := db.ExecContext(ctx, "DELETE FROM quarantined_users WHERE user_id = ?", user)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return httperr.NewNotFound("user/not-quarantined", "User is not quarantined.")
+	}
+	return nil
+}
+
+// AddQuarantineApproval counts a held post or comment of user that was
+// approved, and releases user, if they are quarantined, once releaseAfter of
+// them are.
+func AddQuarantineApproval(ctx context.Context, db *sql.DB, user uid.ID, releaseAfter int) error {
+	if _, err := db.ExecContext(ctx, "UPDATE quarantined_users SET approved = approved + 1 WHERE user_id = ?", user); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, "DELETE FROM quarantined_users WHERE user_id = ? AND approved >= ?", user, releaseAfter)
+	return err
+}
+
+// HoldQuarantinedComment withholds the comment of the quarantined user
+// (posted as g) on post until an admin reviews it.
+func HoldQuarantinedComment(ctx context.Context, db *sql.DB, post *Post, user uid.ID, g UserGroup, parentComment *uid.ID, body string, image *uid.ID) (*HeldComment, error) {
+	hash, _ := commentFingerprint(body)
+	return HoldComment(ctx, db, post, user, g, parentComment, body, image, &CommentFloodCheck{
+		Simhash: hash,
+		Reason:  "Quarantined account",
+	})
+}
+
+// HeldPost is a post withheld until an admin reviews it.
+type HeldPost struct {
+	ID          uid.ID          `json:"id"`
+	Type        PostType        `json:"type"`
+	CommunityID uid.ID          `json:"communityId"`
+	UserID      uid.ID          `json:"userId"`
+	Username    string          `json:"username"`
+	Title       string          `json:"title"`
+	Body        msql.NullString `json:"body"`
+	Link        msql.NullString `json:"link"`
+	ImageID     uid.NullID      `json:"imageId"`
+	VideoID     uid.NullID      `json:"videoId"`
+	Reason      string          `json:"reason"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+// HoldPost withholds the post of user in community until an admin reviews it.
+// Depending on the post type, the post has a body, a link, an image (imageID),
+// or a video (videoID).
+func HoldPost(ctx context.Context, db *sql.DB, postType PostType, community, user uid.ID, title, body, link string, media uid.ID, reason string) (*HeldPost, error) {
+	if err := validatePost(title, body); err != nil {
+		return nil, err
+	}
+	held := &HeldPost{
+		ID:          uid.New(),
+		Type:        postType,
+		CommunityID: community,
+		UserID:      user,
+		Title:       title,
+		Reason:      reason,
+		CreatedAt:   time.Now(),
+	}
+	switch postType {
+	case PostTypeText:
+		held.Body = msql.NewNullString(body)
+	case PostTypeLink:
+		pl, err := parsePostLink(link)
+		if err != nil {
+			return nil, err
+		}
+		held.Link = msql.NewNullString(pl.URL)
+	case PostTypeImage:
+		held.ImageID = uid.NullID{ID: media, Valid: true}
+	case PostTypeVideo:
+		held.VideoID = uid.NullID{ID: media, Valid: true}
+	default:
+		return nil, errPostTypeUnsupported
+	}
+	query, args := msql.BuildInsertQuery("held_posts", []msql.ColumnValue{
+		{Name: "id", Value: held.ID},
+		{Name: "type", Value: held.Type},
+		{Name: "community_id", Value: held.CommunityID},
+		{Name: "user_id", Value: held.UserID},
+		{Name: "title", Value: held.Title},
+		{Name: "body", Value: held.Body},
+		{Name: "link", Value: held.Link},
+		{Name: "image_id", Value: held.ImageID},
+		{Name: "video_id", Value: held.VideoID},
+		{Name: "reason", Value: held.Reason},
+		{Name: "created_at", Value: held.CreatedAt},
+	})
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+	return held, nil
+}
+
+var heldPostColumns = []string{
+	"held_posts.id",
+	"held_posts.type",
+	"held_posts.community_id",
+	"held_posts.user_id",
+	"users.username",
+	"held_posts.title",
+	"held_posts.body",
+	"held_posts.link",
+	"held_posts.image_id",
+	"held_posts.video_id",
+	"held_posts.reason",
+	"held_posts.created_at",
+}
+
+func scanHeldPosts(rows *sql.Rows) ([]*HeldPost, error) {
+	defer rows.Close()
+	items := []*HeldPost{}
+	for rows.Next() {
+		p := &HeldPost{}
+		if err := rows.Scan(&p.ID, &p.Type, &p.CommunityID, &p.UserID, &p.Username, &p.Title, &p.Body, &p.Link, &p.ImageID, &p.VideoID, &p.Reason, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, p)
+	}
+	return items, rows.Err()
+}
+
+// GetHeldPosts returns the posts pending review, newest first.
+func GetHeldPosts(ctx context.Context, db *sql.DB) ([]*HeldPost, error) {
+	query := msql.BuildSelectQuery("held_posts", heldPostColumns, []string{"INNER JOIN users ON users.id = held_posts.user_id"}, "ORDER BY held_posts.created_at DESC")
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return scanHeldPosts(rows)
+}
+
+func getHeldPost(ctx context.Context, db *sql.DB, id uid.ID) (*HeldPost, error) {
+	query := msql.BuildSelectQuery("held_posts", heldPostColumns, []string{"INNER JOIN users ON users.id = held_posts.user_id"}, "WHERE held_posts.id = ?")
+	rows, err := db.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	items, err := scanHeldPosts(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errHeldPostNotFound
+	}
+	return items[0], nil
+}
+
+// ApproveHeldPost creates the held post with id and returns it.
+func ApproveHeldPost(ctx context.Context, db *sql.DB, id uid.ID) (*Post, *HeldPost, error) {
+	held, err := getHeldPost(ctx, db, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	var post *Post
+	switch held.Type {
+	case PostTypeText:
+		post, err = CreateTextPost(ctx, db, held.UserID, held.CommunityID, held.Title, held.Body.String)
+	case PostTypeLink:
+		post, err = CreateLinkPost(ctx, db, held.UserID, held.CommunityID, held.Title, held.Link.String)
+	case PostTypeImage:
+		post, err = CreateImagePost(ctx, db, held.UserID, held.CommunityID, held.Title, held.ImageID.ID)
+	case PostTypeVideo:
+		post, err = CreateVideoPost(ctx, db, held.UserID, held.CommunityID, held.Title, held.VideoID.ID)
+	default:
+		err = errPostTypeUnsupported
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM held_posts WHERE id = ?", id); err != nil {
+		return nil, nil, err
+	}
+	return post, held, nil
+}
+
+// RejectHeldPost discards the held post with id. Its image or video, if any,
+// is removed along with the other temporary media.
+func RejectHeldPost(ctx context.Context, db *sql.DB, id uid.ID) error {
+	res, err := db.ExecContext(ctx, "DELETE FROM held_posts WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errHeldPostNotFound
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// maxDeferredPushesPerUser is the maximum number of the push notifications
+// deferred during quiet hours that are sent to a user when they end (the most
+// recent ones). The rest are only in the app.
+const maxDeferredPushesPerUser = 5
+
+// parseClockTime returns the minutes since midnight of s (as 15:04).
+func parseClockTime(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// checkQuietHours returns an httperr.Error if start and end (see
+// User.QuietHoursStart) are neither both empty nor different times of the
+// day.
+func checkQuietHours(start, end string) error {
+	if start == "" && end == "" {
+		return nil
+	}
+	s, err := parseClockTime(start)
+	if err != nil {
+		return httperr.NewInvalidField("quietHoursStart", "invalid-quiet-hours", "Invalid start of quiet hours.")
+	}
+	e, err := parseClockTime(end)
+	if err != nil {
+		return httperr.NewInvalidField("quietHoursEnd", "invalid-quiet-hours", "Invalid end of quiet hours.")
+	}
+	if s == e {
+		return httperr.NewInvalidField("quietHoursEnd", "invalid-quiet-hours", "Quiet hours cannot start and end at the same time.")
+	}
+	return nil
+}
+
+// quietHoursEnd returns when the quiet hours from start to end (as 15:04, in
+// loc) that now is in end, or the zero time if now is not in quiet hours. The
+// quiet hours span midnight if end is before start.
+func quietHoursEnd(start, end string, loc *time.Location, now time.Time) time.Time {
+	s, err := parseClockTime(start)
+	if err != nil {
+		return time.Time{}
+	}
+	e, err := parseClockTime(end)
+	if err != nil || s == e {
+		return time.Time{}
+	}
+	t := now.In(loc)
+	m := t.Hour()*60 + t.Minute()
+	day := t
+	if s < e {
+		if m < s || m >= e {
+			return time.Time{}
+		}
+	} else {
+		if m < s && m >= e {
+			return time.Time{}
+		}
+		if m >= s {
+			day = t.AddDate(0, 0, 1) // They end tomorrow.
+		}
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), e/60, e%60, 0, 0, loc)
+}
+
+// userQuietHoursEnd returns when the quiet hours of user end, if they are in
+// quiet hours now, or the zero time.
+func userQuietHoursEnd(ctx context.Context, db *sql.DB, user uid.ID) (time.Time, error) {
+	var start, end, tz string
+	if err := db.QueryRowContext(ctx, "SELECT quiet_hours_start, quiet_hours_end, time_zone FROM users WHERE id = ?", user).Scan(&start, &end, &tz); err != nil {
+		return time.Time{}, err
+	}
+	if start == "" {
+		return time.Time{}, nil
+	}
+	return quietHoursEnd(start, end, timeZoneLocation(tz), time.Now()), nil
+}
+
+// deferPushNotification defers the push notification of n until sendAt (see
+// SendDeferredPushNotifications).
+func (n *Notification) deferPushNotification(ctx context.Context, sendAt time.Time) error {
+	_, err := n.db.ExecContext(ctx, "INSERT INTO deferred_push_notifications (notification_id, send_at) VALUES (?, ?) "+
+		msql.UpsertClause([]string{"notification_id"}, "send_at"), n.ID, sendAt)
+	return err
+}
+
+// SendDeferredPushNotifications sends the push notifications deferred during
+// the quiet hours of their users that have since ended, except those of
+// notifications seen in the meantime. Only the most recent
+// maxDeferredPushesPerUser of a user are sent. It returns the number of push
+// notifications sent.
+func SendDeferredPushNotifications(ctx context.Context, db *sql.DB) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT notification_id FROM deferred_push_notifications
+		WHERE send_at <= ?
+		ORDER BY notification_id DESC
+		LIMIT ?`, time.Now(), outboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	sent := make(map[uid.ID]int) // By user.
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+		// Deleting the row first makes sure that only one worker sends it.
+		res, err := db.ExecContext(ctx, "DELETE FROM deferred_push_notifications WHERE notification_id = ?", id)
+		if err != nil {
+			return n, err
+		}
+		if deleted, err := res.RowsAffected(); err != nil {
+			return n, err
+		} else if deleted == 0 {
+			continue
+		}
+
+		notif, err := GetNotification(ctx, db, strconv.Itoa(id))
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return n, err
+		}
+		if notif.Seen || sent[notif.UserID] >= maxDeferredPushesPerUser {
+			continue
+		}
+		if err := notif.sendPushNotification(ctx); err != nil {
+			notifLogger.WarnContext(ctx, "Failed to send deferred push notification", "notification", id, "err", err)
+			continue
+		}
+		sent[notif.UserID]++
+		n++
+	}
+	return n, nil
+}
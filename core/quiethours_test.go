@@ -0,0 +1,54 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHoursEnd(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	at := func(s string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", s, paris)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	cases := []struct {
+		name       string
+		start, end string
+		now        time.Time
+		want       time.Time
+	}{
+		{"before", "13:00", "14:30", at("2024-05-10 12:59"), time.Time{}},
+		{"during", "13:00", "14:30", at("2024-05-10 13:00"), at("2024-05-10 14:30")},
+		{"at the end", "13:00", "14:30", at("2024-05-10 14:30"), time.Time{}},
+		{"night, evening", "22:00", "07:00", at("2024-05-10 23:15"), at("2024-05-11 07:00")},
+		{"night, morning", "22:00", "07:00", at("2024-05-11 06:00"), at("2024-05-11 07:00")},
+		{"night, day", "22:00", "07:00", at("2024-05-11 12:00"), time.Time{}},
+		{"none", "", "", at("2024-05-11 12:00"), time.Time{}},
+	}
+	for _, c := range cases {
+		// The time zone of now doesn't matter, only that of the quiet hours.
+		got := quietHoursEnd(c.start, c.end, paris, c.now.UTC())
+		if !got.Equal(c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCheckQuietHours(t *testing.T) {
+	for _, c := range [][2]string{{"", ""}, {"22:00", "07:00"}, {"00:00", "23:59"}} {
+		if err := checkQuietHours(c[0], c[1]); err != nil {
+			t.Errorf("checkQuietHours(%q, %q) = %v", c[0], c[1], err)
+		}
+	}
+	for _, c := range [][2]string{{"22:00", ""}, {"", "07:00"}, {"25:00", "07:00"}, {"7am", "9am"}, {"08:00", "08:00"}} {
+		if err := checkQuietHours(c[0], c[1]); err == nil {
+			t.Errorf("checkQuietHours(%q, %q) = nil", c[0], c[1])
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/discuitnet/discuit/internal/ratelimits"
+	msql "github.com/discuitnet/discuit/internal/sql"
+)
+
+const rateLimitPoliciesDBKey = "rate_limit_policies" // for the key column of the application_data table
+
+// GetRateLimitPolicies returns the rate limit policies set by the admins, keyed
+// by name. These override the default policies of the server.
+func GetRateLimitPolicies(ctx context.Context, db *sql.DB) (map[string]ratelimits.Policy, error) {
+	rawJSON := ""
+	row := db.QueryRowContext(ctx, "SELECT `value` FROM application_data WHERE `key` = ?", rateLimitPoliciesDBKey)
+	if err := row.Scan(&rawJSON); err != nil {
+		if err == sql.ErrNoRows {
+			return map[string]ratelimits.Policy{}, nil
+		}
+		return nil, err
+	}
+
+	policies := make(map[string]ratelimits.Policy)
+	if err := json.Unmarshal([]byte(rawJSON), &policies); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// SaveRateLimitPolicies replaces the rate limit policies set by the admins
+// with policies.
+func SaveRateLimitPolicies(ctx context.Context, db *sql.DB, policies map[string]ratelimits.Policy) error {
+	data, err := json.Marshal(policies)
+	if err != nil {
+		return err
+	}
+	query := "INSERT INTO application_data (`key`, `value`) VALUES (?, ?) " + msql.UpsertClause([]string{"`key`"}, "`value`")
+	_, err = db.ExecContext(ctx, query, rateLimitPoliciesDBKey, string(data))
+	return err
+}
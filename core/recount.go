@@ -0,0 +1,106 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// statsCounters are the counters, kept in columns of their rows, that
+// RecountStats recounts: the name of the counter, its table and column, and
+// the subquery that counts it for a row of the table.
+var statsCounters = []struct {
+	name, table, column, count string
+}{
+	{"communities.no_members", "communities", "no_members",
+		"SELECT COUNT(*) FROM community_members WHERE community_members.community_id = communities.id"},
+	{"posts.no_comments", "posts", "no_comments",
+		"SELECT COUNT(*) FROM comments WHERE comments.post_id = posts.id"},
+	{"users.no_posts", "users", "no_posts",
+		"SELECT COUNT(*) FROM posts WHERE posts.user_id = users.id AND posts.deleted = FALSE"},
+	{"users.no_comments", "users", "no_comments",
+		"SELECT COUNT(*) FROM comments WHERE comments.user_id = users.id AND comments.deleted_at IS NULL"},
+}
+
+// RecountStats recounts the numbers of members of communities, of comments of
+// posts, and of posts and comments of users, fixing those that have drifted,
+// and returns the number of rows fixed by counter (ex: users.no_posts).
+func RecountStats(ctx context.Context, db *sql.DB) (map[string]int64, error) {
+	fixed := make(map[string]int64)
+	for _, c := range statsCounters {
+		query := "UPDATE " + c.table + " SET " + c.column + " = (" + c.count + ") WHERE " + c.column + " <> (" + c.count + ")"
+		res, err := db.ExecContext(ctx, query)
+		if err != nil {
+			return fixed, err
+		}
+		if fixed[c.name], err = res.RowsAffected(); err != nil {
+			return fixed, err
+		}
+	}
+	return fixed, nil
+}
+
+// A CounterAudit is a counter found off by AuditStats.
+type CounterAudit struct {
+	ID        int       `json:"id"`
+	Counter   string    `json:"counter"` // Ex: users.no_comments.
+	RowsOff   int       `json:"rowsOff"`
+	Drift     int64     `json:"drift"` // The sum of how much each row was off by.
+	AuditedAt time.Time `json:"auditedAt"`
+}
+
+// AuditStats is RecountStats that also records (and returns) the counters
+// that were off (audits older than 90 days are pruned), for the admins to
+// see the drift.
+func AuditStats(ctx context.Context, db *sql.DB) ([]*CounterAudit, error) {
+	audits := []*CounterAudit{}
+	now := time.Now()
+	for _, c := range statsCounters {
+		a := &CounterAudit{Counter: c.name, AuditedAt: now}
+		query := "SELECT COUNT(*), COALESCE(SUM(ABS(CAST(" + c.column + " AS SIGNED) - (" + c.count + "))), 0) FROM " + c.table +
+			" WHERE " + c.column + " <> (" + c.count + ")"
+		if err := db.QueryRowContext(ctx, query).Scan(&a.RowsOff, &a.Drift); err != nil {
+			return audits, err
+		}
+		if a.RowsOff == 0 {
+			continue
+		}
+		query = "UPDATE " + c.table + " SET " + c.column + " = (" + c.count + ") WHERE " + c.column + " <> (" + c.count + ")"
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return audits, err
+		}
+		res, err := db.ExecContext(ctx, "INSERT INTO counter_audits (counter, rows_off, drift, audited_at) VALUES (?, ?, ?, ?)",
+			a.Counter, a.RowsOff, a.Drift, a.AuditedAt)
+		if err != nil {
+			return audits, err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return audits, err
+		}
+		a.ID = int(id)
+		audits = append(audits, a)
+	}
+	_, err := db.ExecContext(ctx, "DELETE FROM counter_audits WHERE audited_at < ?", now.AddDate(0, 0, -90))
+	return audits, err
+}
+
+// GetCounterAudits returns the last n counters found off by AuditStats, the
+// latest first.
+func GetCounterAudits(ctx context.Context, db *sql.DB, n int) ([]*CounterAudit, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, counter, rows_off, drift, audited_at FROM counter_audits ORDER BY audited_at DESC, id DESC LIMIT ?", n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	audits := []*CounterAudit{}
+	for rows.Next() {
+		a := &CounterAudit{}
+		if err := rows.Scan(&a.ID, &a.Counter, &a.RowsOff, &a.Drift, &a.AuditedAt); err != nil {
+			return nil, err
+		}
+		audits = append(audits, a)
+	}
+	return audits, rows.Err()
+}
@@ -0,0 +1,265 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+const (
+	// maxRelatedCommunities is the maximum number of communities the mods of
+	// a community may declare as related to it.
+	maxRelatedCommunities = 10
+
+	// similarCommunitiesSize is the number of similar communities (by
+	// members in common) kept for each community.
+	similarCommunitiesSize = 20
+
+	// minSimilarCommunitiesOverlap is the minimum number of members two
+	// communities must have in common to be considered similar.
+	minSimilarCommunitiesOverlap = 3
+)
+
+func newRelatedCommunity(c *Community) *RelatedCommunity {
+	return &RelatedCommunity{Name: c.Name, ID: c.ID, NumMembers: c.NumMembers, ProPic: c.ProPic}
+}
+
+// FetchRelated populates c.RelatedCommunities with the communities the mods of
+// c declared as related to it (leaving out those since deleted).
+func (c *Community) FetchRelated(ctx context.Context) error {
+	rows, err := c.db.QueryContext(ctx, "SELECT related_id FROM related_communities WHERE community_id = ? ORDER BY position", c.ID)
+	if err != nil {
+		return err
+	}
+	ids, err := scanIDs(rows)
+	if err != nil {
+		return err
+	}
+	comms, err := GetCommunitiesByIDs(ctx, c.db, ids, nil)
+	if err != nil {
+		return err
+	}
+
+	c.RelatedCommunities = []*RelatedCommunity{}
+	for _, id := range ids {
+		for _, comm := range comms {
+			if comm.ID == id && !comm.DeletedAt.Valid {
+				c.RelatedCommunities = append(c.RelatedCommunities, newRelatedCommunity(comm))
+			}
+		}
+	}
+	return nil
+}
+
+// SetRelated sets the communities related to c to those with names, in that
+// order. Only the mods of c (and admins) may set them.
+func (c *Community) SetRelated(ctx context.Context, mod uid.ID, names []string) error {
+	if is, err := c.UserModOrAdmin(ctx, mod); err != nil {
+		return err
+	} else if !is {
+		return errNotMod
+	}
+
+	var ids []uid.ID
+	for _, name := range names {
+		comm, err := GetCommunityByName(ctx, c.db, strings.TrimSpace(name), nil)
+		if err != nil {
+			return err
+		}
+		if comm.DeletedAt.Valid {
+			return errCommunityNotFound
+		}
+		if comm.ID == c.ID || slices.Contains(ids, comm.ID) {
+			continue
+		}
+		ids = append(ids, comm.ID)
+	}
+	if len(ids) > maxRelatedCommunities {
+		return httperr.NewBadRequest("community/too-many-related", "Too many related communities.")
+	}
+
+	err := msql.Transact(ctx, c.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM related_communities WHERE community_id = ?", c.ID); err != nil {
+			return err
+		}
+		now := time.Now()
+		for i, id := range ids {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO related_communities (community_id, related_id, position, created_by, created_at) VALUES (?, ?, ?, ?, ?)",
+				c.ID, id, i, mod, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return c.FetchRelated(ctx)
+}
+
+// A SimilarCommunity is a community that's either declared as related by the
+// mods of another, or that has many members in common with it.
+type SimilarCommunity struct {
+	RelatedCommunity
+	Declared bool    `json:"declared"` // Declared as related by the mods.
+	Score    float64 `json:"score"`    // Of the members in common, from 0 to 1.
+}
+
+// GetSimilarCommunities returns at most limit communities similar to
+// community: the ones declared as related by its mods first, and then the
+// ones with the most members in common with it (as of the last
+// ComputeSimilarCommunities).
+func GetSimilarCommunities(ctx context.Context, db *sql.DB, community uid.ID, limit int) ([]*SimilarCommunity, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT related_id, TRUE, 0 FROM related_communities WHERE community_id = ?
+		UNION ALL
+		SELECT similar_id, FALSE, score FROM similar_communities WHERE community_id = ?
+		ORDER BY 2 DESC, 3 DESC`, community, community)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type entry struct {
+		id       uid.ID
+		declared bool
+		score    float64
+	}
+	var (
+		entries []entry
+		ids     []uid.ID
+	)
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.id, &e.declared, &e.score); err != nil {
+			return nil, err
+		}
+		if slices.Contains(ids, e.id) {
+			continue
+		}
+		entries = append(entries, e)
+		ids = append(ids, e.id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	comms, err := GetCommunitiesByIDs(ctx, db, ids, nil)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uid.ID]*Community, len(comms))
+	for _, c := range comms {
+		byID[c.ID] = c
+	}
+
+	similar := []*SimilarCommunity{}
+	for _, e := range entries {
+		c := byID[e.id]
+		if c == nil || c.DeletedAt.Valid {
+			continue
+		}
+		similar = append(similar, &SimilarCommunity{RelatedCommunity: *newRelatedCommunity(c), Declared: e.declared, Score: e.score})
+		if len(similar) == limit {
+			break
+		}
+	}
+	return similar, nil
+}
+
+// ComputeSimilarCommunities recomputes, for each community, the communities
+// with the most members in common with it (by the Jaccard index of the
+// members of the two). Call this function periodically.
+func ComputeSimilarCommunities(ctx context.Context, db *sql.DB) error {
+	members := make(map[uid.ID]int)
+	rows, err := db.QueryContext(ctx, "SELECT id, no_members FROM communities WHERE deleted_at IS NULL")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var (
+			id uid.ID
+			n  int
+		)
+		if err := rows.Scan(&id, &n); err != nil {
+			rows.Close()
+			return err
+		}
+		members[id] = n
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	type pair struct {
+		community, similar uid.ID
+		overlap            int
+		score              float64
+	}
+	rows, err = db.QueryContext(ctx, `
+		SELECT a.community_id, b.community_id, COUNT(*) FROM community_members AS a
+		INNER JOIN community_members AS b ON b.user_id = a.user_id AND b.community_id <> a.community_id
+		GROUP BY a.community_id, b.community_id
+		HAVING COUNT(*) >= ?`, minSimilarCommunitiesOverlap)
+	if err != nil {
+		return err
+	}
+	byCommunity := make(map[uid.ID][]pair)
+	for rows.Next() {
+		var p pair
+		if err := rows.Scan(&p.community, &p.similar, &p.overlap); err != nil {
+			rows.Close()
+			return err
+		}
+		a, aok := members[p.community]
+		b, bok := members[p.similar]
+		if !aok || !bok {
+			continue // Deleted.
+		}
+		if union := a + b - p.overlap; union > 0 {
+			p.score = min(float64(p.overlap)/float64(union), 1)
+		}
+		byCommunity[p.community] = append(byCommunity[p.community], p)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM similar_communities"); err != nil {
+			return err
+		}
+		for _, pairs := range byCommunity {
+			slices.SortFunc(pairs, func(a, b pair) int {
+				if a.score != b.score {
+					if a.score > b.score {
+						return -1
+					}
+					return 1
+				}
+				return b.overlap - a.overlap
+			})
+			for _, p := range pairs[:min(len(pairs), similarCommunitiesSize)] {
+				if _, err := tx.ExecContext(ctx, "INSERT INTO similar_communities (community_id, similar_id, overlap, score, computed_at) VALUES (?, ?, ?, ?, ?)",
+					p.community, p.similar, p.overlap, p.score, now); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
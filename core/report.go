@@ -3,11 +3,13 @@ package core
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"time"
 
 	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/images"
 	msql "github.com/discuitnet/discuit/internal/sql"
 	"github.com/discuitnet/discuit/internal/uid"
 )
@@ -48,6 +50,17 @@ func (r *ReportType) UnmarshalText(text []byte) error {
 	return nil
 }
 
+// ReportCategory is the site-level category of a report reason.
+type ReportCategory string
+
+const (
+	ReportCategoryRules      = ReportCategory("rules") // Breaks the rules of the community.
+	ReportCategorySpam       = ReportCategory("spam")
+	ReportCategoryHarassment = ReportCategory("harassment")
+	ReportCategoryIllegal    = ReportCategory("illegal")
+	ReportCategoryOther      = ReportCategory("other")
+)
+
 // Report is a user submitted report.
 type Report struct {
 	db *sql.DB
@@ -58,7 +71,8 @@ type Report struct {
 	Reason      string          `json:"reason"`
 	Description msql.NullString `json:"description"`
 	ReasonID    int             `json:"reasonId"`
-	Type        ReportType      `json:"type"` // post or comment
+	Category    ReportCategory  `json:"category"` // Of the reason.
+	Type        ReportType      `json:"type"`     // post or comment
 	TargetID    uid.ID          `json:"targetId"`
 	CreatedBy   uid.ID          `json:"-"`
 	ActionTaken msql.NullString `json:"actionTaken"`
@@ -66,7 +80,60 @@ type Report struct {
 	DealtBy     uid.NullID      `json:"dealtBy"`
 	CreatedAt   time.Time       `json:"createdAt"`
 
+	// ToAdmins is true if the report went to the admins instead of the mods
+	// of the community (see ReportReason.ToAdmins).
+	ToAdmins bool `json:"toAdmins"`
+
+	// The reliability of the reporter (see ReporterScore), by which the
+	// reports of a community are prioritized.
+	Weight float64 `json:"weight"`
+
 	Target interface{} `json:"target"`
+
+	// The reported content as it was when it was reported (nil for reports
+	// made before snapshots were taken), and whether the target has been
+	// edited since (the current content is in Target).
+	Snapshot     *ReportSnapshot `json:"snapshot"`
+	TargetEdited bool            `json:"targetEdited"`
+}
+
+// A ReportSnapshot is a copy of the content of a post or a comment taken when
+// it's reported, so that the author can't hide what was reported by editing
+// it.
+type ReportSnapshot struct {
+	Title string `json:"title,omitempty"` // Of posts only.
+	Body  string `json:"body"`
+
+	// The media of the target, if any.
+	Image   *images.Image `json:"image,omitempty"`
+	LinkURL string        `json:"linkUrl,omitempty"`
+	VideoID *uid.ID       `json:"videoId,omitempty"`
+
+	EditedAt msql.NullTime `json:"editedAt"` // When the target was last edited before it was reported.
+}
+
+func newPostReportSnapshot(p *Post) *ReportSnapshot {
+	s := &ReportSnapshot{
+		Title:    p.Title,
+		Body:     p.Body.String,
+		Image:    p.Image,
+		EditedAt: p.EditedAt,
+	}
+	if p.Link != nil {
+		s.LinkURL = p.Link.URL
+	}
+	if p.Video != nil {
+		s.VideoID = &p.Video.ID
+	}
+	return s
+}
+
+func newCommentReportSnapshot(c *Comment) *ReportSnapshot {
+	return &ReportSnapshot{
+		Body:     c.Body,
+		Image:    c.Image,
+		EditedAt: c.EditedAt,
+	}
 }
 
 var selectReportCols = []string{
@@ -81,22 +148,38 @@ var selectReportCols = []string{
 	"reports.dealt_at",
 	"reports.dealt_by",
 	"reports.created_at",
+	"reports.snapshot",
+	"reports.to_admins",
 	"report_reasons.title",
 	"report_reasons.description",
+	"report_reasons.category",
+	"COALESCE(reporter_scores.upheld, 0)",
+	"COALESCE(reporter_scores.dismissed, 0)",
 }
 
 var selectReportJoins = []string{
 	"INNER JOIN report_reasons ON reports.reason_id = report_reasons.id",
+	"LEFT JOIN reporter_scores ON reporter_scores.user_id = reports.created_by",
 }
 
-// NewReport creates a new report on target.
-func NewReport(ctx context.Context, db *sql.DB, community uid.ID, post uid.NullID, t ReportType, reason int, target, createdBy uid.ID) (*Report, error) {
+// NewReport creates a new report on target, with snapshot (which may be nil)
+// being a copy of the content of target. Reports of reasons that go to the
+// admins don't show up in the reports of community.
+func NewReport(ctx context.Context, db *sql.DB, community uid.ID, post uid.NullID, t ReportType, reason int, target, createdBy uid.ID, snapshot *ReportSnapshot) (*Report, error) {
 	if is, err := IsUserBannedFromCommunity(ctx, db, community, createdBy); err != nil {
 		return nil, err
 	} else if is {
 		return nil, errUserBannedFromCommunity
 	}
 
+	var toAdmins bool
+	if err := db.QueryRowContext(ctx, "SELECT to_admins FROM report_reasons WHERE id = ?", reason).Scan(&toAdmins); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, httperr.NewBadRequest("invalid_report_reason", "Invalid report reason.")
+		}
+		return nil, err
+	}
+
 	has, err := hasUserMadeReport(ctx, db, createdBy, target, t, reason)
 	if err != nil {
 		return nil, err
@@ -112,8 +195,17 @@ func NewReport(ctx context.Context, db *sql.DB, community uid.ID, post uid.NullI
 		reason_id, 
 		report_type, 
 		target_id, 
-		created_by
-	) VALUES (?, ?, ?, ?, ?, ?)`
+		created_by,
+		snapshot,
+		to_admins
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	var snapshotJSON []byte
+	if snapshot != nil {
+		var err error
+		if snapshotJSON, err = json.Marshal(snapshot); err != nil {
+			return nil, err
+		}
+	}
 	args := []any{
 		community,
 		post,
@@ -121,6 +213,8 @@ func NewReport(ctx context.Context, db *sql.DB, community uid.ID, post uid.NullI
 		t,
 		target,
 		createdBy,
+		msql.NilIfEmptyString(string(snapshotJSON)),
+		toAdmins,
 	}
 
 	result, err := db.ExecContext(ctx, query, args...)
@@ -132,6 +226,9 @@ func NewReport(ctx context.Context, db *sql.DB, community uid.ID, post uid.NullI
 	if err != nil {
 		return nil, err
 	}
+	if err := addAbuseEvent(ctx, db, community, abuseEventReport, t, reason); err != nil {
+		logger.ErrorContext(ctx, "Failed to count report in the abuse trends", "err", err)
+	}
 	return GetReport(ctx, db, int(id))
 }
 
@@ -142,7 +239,12 @@ func NewPostReport(ctx context.Context, db *sql.DB, post uid.ID, reason int, cre
 		return nil, err
 	}
 	ni := uid.NullID{ID: p.ID, Valid: true}
-	return NewReport(ctx, db, p.CommunityID, ni, ReportTypePost, reason, p.ID, createdBy)
+	report, err := NewReport(ctx, db, p.CommunityID, ni, ReportTypePost, reason, p.ID, createdBy, newPostReportSnapshot(p))
+	if err != nil {
+		return nil, err
+	}
+	autoHidePost(ctx, db, createdBy, p.ID, "auto_hide_reported_posts")
+	return report, nil
 }
 
 // NewCommentReport creates a report on comment.
@@ -152,7 +254,7 @@ func NewCommentReport(ctx context.Context, db *sql.DB, comment uid.ID, reason in
 		return nil, err
 	}
 	ni := uid.NullID{ID: c.PostID, Valid: true}
-	return NewReport(ctx, db, c.CommunityID, ni, ReportTypeComment, reason, c.ID, createdBy)
+	return NewReport(ctx, db, c.CommunityID, ni, ReportTypeComment, reason, c.ID, createdBy, newCommentReportSnapshot(c))
 }
 
 func hasUserMadeReport(ctx context.Context, db *sql.DB, userID, targetID uid.ID, t ReportType, reasonID int) (bool, error) {
@@ -174,6 +276,10 @@ func scanReports(db *sql.DB, rows *sql.Rows) ([]*Report, error) {
 	var reports []*Report
 	for rows.Next() {
 		r := &Report{db: db}
+		var (
+			upheld, dismissed int
+			snapshot          []byte
+		)
 		err := rows.Scan(
 			&r.ID,
 			&r.CommunityID,
@@ -186,11 +292,23 @@ func scanReports(db *sql.DB, rows *sql.Rows) ([]*Report, error) {
 			&r.DealtAt,
 			&r.DealtBy,
 			&r.CreatedAt,
+			&snapshot,
+			&r.ToAdmins,
 			&r.Reason,
-			&r.Description)
+			&r.Description,
+			&r.Category,
+			&upheld,
+			&dismissed)
 		if err != nil {
 			return nil, err
 		}
+		r.Weight = reportReliability(upheld, dismissed)
+		if snapshot != nil {
+			r.Snapshot = &ReportSnapshot{}
+			if err := json.Unmarshal(snapshot, r.Snapshot); err != nil {
+				return nil, err
+			}
+		}
 		reports = append(reports, r)
 	}
 
@@ -228,6 +346,7 @@ func (r *Report) FetchTarget(ctx context.Context) error {
 			return err
 		}
 		r.Target = post
+		r.TargetEdited = post.EditedAt.Valid && post.EditedAt.Time.After(r.CreatedAt)
 	} else if r.Type == ReportTypeComment {
 		comment, err := GetComment(ctx, r.db, r.TargetID, nil)
 		if err != nil {
@@ -237,6 +356,7 @@ func (r *Report) FetchTarget(ctx context.Context) error {
 			return err
 		}
 		r.Target = comment
+		r.TargetEdited = comment.EditedAt.Valid && comment.EditedAt.Time.After(r.CreatedAt)
 	}
 	return nil
 }
@@ -259,22 +379,46 @@ func (r *Report) Delete(ctx context.Context, mod uid.ID) error {
 	return err
 }
 
-// GetReports retrives user submitted reports in community. The results are paginated.
-func GetReports(ctx context.Context, db *sql.DB, community uid.ID, t ReportType, limit, page int) ([]*Report, error) {
-	query := msql.BuildSelectQuery("reports", selectReportCols, selectReportJoins, "WHERE reports.community_id = ?")
+// Resolve deletes the report, counting it as upheld, or, if upheld is false,
+// as dismissed, in the score of the reporter.
+func (r *Report) Resolve(ctx context.Context, mod uid.ID, upheld bool) error {
+	if err := r.Delete(ctx, mod); err != nil {
+		return err
+	}
+	if err := addReportResolutions(ctx, r.db, mod, upheld, []reportResolution{{community: r.CommunityID, reportedAt: r.CreatedAt}}); err != nil {
+		return err
+	}
+	return addReportOutcomes(ctx, r.db, []uid.ID{r.CreatedBy}, upheld)
+}
+
+// GetReports retrives user submitted reports in community (except for those
+// that went to the admins). The results are paginated. If byPriority is true,
+// the reports of the most reliable reporters come first; otherwise, the newest
+// reports do.
+func GetReports(ctx context.Context, db *sql.DB, community uid.ID, t ReportType, byPriority bool, limit, page int) ([]*Report, error) {
+	return getReports(ctx, db, "WHERE reports.community_id = ? AND reports.to_admins = FALSE", []any{community}, t, byPriority, limit, page)
+}
+
+// GetAdminReports retrives the reports, of all communities, that went to the
+// admins. The results are paginated like those of GetReports.
+func GetAdminReports(ctx context.Context, db *sql.DB, t ReportType, byPriority bool, limit, page int) ([]*Report, error) {
+	return getReports(ctx, db, "WHERE reports.to_admins = TRUE", nil, t, byPriority, limit, page)
+}
+
+func getReports(ctx context.Context, db *sql.DB, where string, args []any, t ReportType, byPriority bool, limit, page int) ([]*Report, error) {
+	query := msql.BuildSelectQuery("reports", selectReportCols, selectReportJoins, where)
 	if t != ReportTypeAll {
 		query += " AND report_type = ?"
+		args = append(args, t)
 	}
-	query += " ORDER BY created_at DESC LIMIT ? OFFSET ?"
-
-	var rows *sql.Rows
-	var err error
-	offset := limit * (page - 1)
-	if t == ReportTypeAll {
-		rows, err = db.QueryContext(ctx, query, community, limit, offset)
-	} else {
-		rows, err = db.QueryContext(ctx, query, community, t, limit, offset)
+	query += " ORDER BY "
+	if byPriority {
+		query += "(COALESCE(reporter_scores.upheld, 0) + 1.0) / (COALESCE(reporter_scores.upheld, 0) + COALESCE(reporter_scores.dismissed, 0) + 2) DESC, "
 	}
+	query += "reports.created_at DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, limit*(page-1))
+
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -295,14 +439,21 @@ type ReportReason struct {
 	ID          int             `json:"id"`
 	Title       string          `json:"title"`
 	Description msql.NullString `json:"description"`
-	CreatedAt   time.Time       `json:"-"`
+	Category    ReportCategory  `json:"category"`
+
+	// ToAdmins is true if the reports of the reason (such as those of illegal
+	// content) go to the admins directly, instead of the mods of the
+	// community.
+	ToAdmins bool `json:"toAdmins"`
+
+	CreatedAt time.Time `json:"-"`
 }
 
 // GetReportReasons returns all report reasons. These are supposed to be about a
 // dozen at most.
 func GetReportReasons(ctx context.Context, db *sql.DB) ([]ReportReason, error) {
 	var all []ReportReason
-	rows, err := db.QueryContext(ctx, "SELECT id, title, description, created_at FROM report_reasons")
+	rows, err := db.QueryContext(ctx, "SELECT id, title, description, category, to_admins, created_at FROM report_reasons ORDER BY id")
 	if err != nil {
 		return nil, err
 	}
@@ -310,7 +461,7 @@ func GetReportReasons(ctx context.Context, db *sql.DB) ([]ReportReason, error) {
 
 	for rows.Next() {
 		r := ReportReason{}
-		if err = rows.Scan(&r.ID, &r.Title, &r.Description, &r.CreatedAt); err != nil {
+		if err = rows.Scan(&r.ID, &r.Title, &r.Description, &r.Category, &r.ToAdmins, &r.CreatedAt); err != nil {
 			return nil, err
 		}
 		all = append(all, r)
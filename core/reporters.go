@@ -0,0 +1,145 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+const (
+	// A reporter with at least unreliableReporterMinDismissed dismissed
+	// reports, and a reliability of less than unreliableReporterReliability,
+	// is unreliable.
+	unreliableReporterMinDismissed = 5
+	unreliableReporterReliability  = 0.25
+)
+
+// ReporterScore is how accurate the reports of a user were, as judged by the
+// mods.
+type ReporterScore struct {
+	UserID   uid.ID `json:"userId"`
+	Username string `json:"username"`
+
+	// The number of reports the mods upheld (by removing the reported post or
+	// comment) and dismissed.
+	Upheld    int `json:"upheld"`
+	Dismissed int `json:"dismissed"`
+
+	// The (smoothed) fraction of reports upheld, between 0 and 1. It's 0.5 for
+	// a user without resolved reports.
+	Reliability float64 `json:"reliability"`
+
+	// If true, the reports of the user are rate limited more strictly.
+	Unreliable bool `json:"unreliable"`
+}
+
+// reportReliability returns the fraction of reports upheld, smoothed so that a
+// few outcomes don't count for much.
+func reportReliability(upheld, dismissed int) float64 {
+	return float64(upheld+1) / float64(upheld+dismissed+2)
+}
+
+func (s *ReporterScore) setReliability() {
+	s.Reliability = reportReliability(s.Upheld, s.Dismissed)
+	s.Unreliable = s.Dismissed >= unreliableReporterMinDismissed && s.Reliability < unreliableReporterReliability
+}
+
+// GetReporterScore returns the reporter score of user.
+func GetReporterScore(ctx context.Context, db *sql.DB, user uid.ID) (*ReporterScore, error) {
+	s := &ReporterScore{UserID: user}
+	row := db.QueryRowContext(ctx, `
+		SELECT users.username, COALESCE(reporter_scores.upheld, 0), COALESCE(reporter_scores.dismissed, 0)
+		FROM users
+		LEFT JOIN reporter_scores ON reporter_scores.user_id = users.id
+		WHERE users.id = ?`, user)
+	if err := row.Scan(&s.Username, &s.Upheld, &s.Dismissed); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errUserNotFound
+		}
+		return nil, err
+	}
+	s.setReliability()
+	return s, nil
+}
+
+// GetLeastReliableReporters returns the scores of the limit users, out of
+// those with dismissed reports, whose reports are the least reliable.
+func GetLeastReliableReporters(ctx context.Context, db *sql.DB, limit int) ([]*ReporterScore, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT reporter_scores.user_id, users.username, reporter_scores.upheld, reporter_scores.dismissed
+		FROM reporter_scores
+		INNER JOIN users ON users.id = reporter_scores.user_id
+		WHERE reporter_scores.dismissed > 0
+		ORDER BY (reporter_scores.upheld + 1.0) / (reporter_scores.upheld + reporter_scores.dismissed + 2), reporter_scores.dismissed DESC
+		LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scores := []*ReporterScore{}
+	for rows.Next() {
+		s := &ReporterScore{}
+		if err := rows.Scan(&s.UserID, &s.Username, &s.Upheld, &s.Dismissed); err != nil {
+			return nil, err
+		}
+		s.setReliability()
+		scores = append(scores, s)
+	}
+	return scores, rows.Err()
+}
+
+// addReportOutcomes adds, to the reporter scores of reporters, a report that
+// was upheld, or, if upheld is false, dismissed (once for each occurrence of
+// a user in reporters).
+func addReportOutcomes(ctx context.Context, db *sql.DB, reporters []uid.ID, upheld bool) error {
+	col := "dismissed"
+	if upheld {
+		col = "upheld"
+	}
+	query := "INSERT INTO reporter_scores (user_id, " + col + ", updated_at) VALUES (?, ?, ?) " +
+		msql.OnConflictUpdate("user_id") + col + " = " + col + " + " + msql.Inserted(col) + ", updated_at = " + msql.Inserted("updated_at")
+	now := time.Now()
+	counts := make(map[uid.ID]int)
+	for _, user := range reporters {
+		counts[user]++
+	}
+	for user, n := range counts {
+		if _, err := db.ExecContext(ctx, query, user, n, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upholdReports counts the reports of type t on target as upheld, in the
+// scores of their reporters, and as resolved by mod.
+func upholdReports(ctx context.Context, db *sql.DB, t ReportType, target, mod uid.ID) error {
+	rows, err := db.QueryContext(ctx, "SELECT created_by, community_id, created_at FROM reports WHERE target_id = ? AND report_type = ?", target, t)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var reporters []uid.ID
+	var resolutions []reportResolution
+	for rows.Next() {
+		var reporter uid.ID
+		var r reportResolution
+		if err := rows.Scan(&reporter, &r.community, &r.reportedAt); err != nil {
+			return err
+		}
+		reporters = append(reporters, reporter)
+		resolutions = append(resolutions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := addReportResolutions(ctx, db, mod, true, resolutions); err != nil {
+		return err
+	}
+	return addReportOutcomes(ctx, db, reporters, true)
+}
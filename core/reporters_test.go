@@ -0,0 +1,26 @@
+package core
+
+import "testing"
+
+func TestReporterScoreReliability(t *testing.T) {
+	tests := []struct {
+		upheld, dismissed int
+		reliability       float64
+		unreliable        bool
+	}{
+		{0, 0, 0.5, false},
+		{3, 1, 4.0 / 6, false},
+		{0, 4, 1.0 / 6, false}, // Too few dismissed reports to tell.
+		{0, 5, 1.0 / 7, true},
+		{2, 8, 0.25, false},
+		{1, 9, 2.0 / 12, true},
+	}
+	for _, test := range tests {
+		s := &ReporterScore{Upheld: test.upheld, Dismissed: test.dismissed}
+		s.setReliability()
+		if s.Reliability != test.reliability || s.Unreliable != test.unreliable {
+			t.Errorf("upheld %d, dismissed %d: got %v, %v; expected %v, %v",
+				test.upheld, test.dismissed, s.Reliability, s.Unreliable, test.reliability, test.unreliable)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// A RetentionPolicy is how long the titles and bodies of deleted posts and
+// comments are archived before they're purged for good.
+type RetentionPolicy struct {
+	// Days deleted content is kept. Zero keeps it.
+	Days int
+
+	// Overrides of Days by the jurisdiction (a country code) of the author.
+	ByJurisdiction map[string]int
+}
+
+// normalizeJurisdiction returns j (as set by a reverse proxy, like
+// CF-IPCountry) in the form it's saved in, or an empty string if it's not a
+// jurisdiction.
+func normalizeJurisdiction(j string) string {
+	j = strings.ToUpper(strings.TrimSpace(j))
+	if len(j) == 0 || len(j) > 8 || j == "XX" || j == "T1" { // XX is unknown and T1 is Tor (on Cloudflare).
+		return ""
+	}
+	for _, r := range j {
+		if !((r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-') {
+			return ""
+		}
+	}
+	return j
+}
+
+// SetUserJurisdiction sets the jurisdiction of user to j, which is ignored if
+// it's not a valid jurisdiction.
+func SetUserJurisdiction(ctx context.Context, db *sql.DB, user uid.ID, j string) error {
+	if j = normalizeJurisdiction(j); j == "" {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, "UPDATE users SET jurisdiction = ? WHERE id = ?", j, user)
+	return err
+}
+
+// archiveDeletedContentTx saves the title (of posts only) and body of the
+// deleted post or comment target, of author, to the archive, from which they
+// are purged according to the RetentionPolicy.
+func archiveDeletedContentTx(ctx context.Context, tx *sql.Tx, t ReportType, target, author uid.ID, title, body string, deletedAt time.Time) error {
+	if title == "" && body == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO deleted_content_archive (target_type, target_id, author_id, jurisdiction, title, body, deleted_at)
+		VALUES (?, ?, ?, (SELECT jurisdiction FROM users WHERE id = ?), ?, ?, ?)`,
+		t, target, author, author, msql.NilIfEmptyString(title), body, deletedAt)
+	return err
+}
+
+// A ContentPurgeRun is a run of PurgeDeletedContent.
+type ContentPurgeRun struct {
+	ID         int       `json:"id"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+
+	// The number of archived posts and comments purged, and the number of
+	// deleted posts whose bodies were purged.
+	ArchivedPurged int `json:"archivedPurged"`
+	PostsPurged    int `json:"postsPurged"`
+
+	Error msql.NullString `json:"error"`
+}
+
+// PurgeDeletedContent purges the archived posts and comments that were deleted
+// before their retention period (as per p), and clears the bodies of the
+// deleted posts themselves. Each run is recorded (and runs older than 90 days
+// are pruned).
+func PurgeDeletedContent(ctx context.Context, db *sql.DB, p RetentionPolicy) (*ContentPurgeRun, error) {
+	run := &ContentPurgeRun{StartedAt: time.Now()}
+	err := purgeDeletedContent(ctx, db, p, run)
+	run.FinishedAt = time.Now()
+	if err != nil {
+		run.Error = msql.NewNullString(err.Error())
+	}
+
+	if run.ArchivedPurged > 0 || run.PostsPurged > 0 || err != nil {
+		res, rerr := db.ExecContext(ctx, `INSERT INTO content_purge_runs (started_at, finished_at, archived_purged, posts_purged, error)
+			VALUES (?, ?, ?, ?, ?)`, run.StartedAt, run.FinishedAt, run.ArchivedPurged, run.PostsPurged, run.Error)
+		if rerr != nil {
+			return run, rerr
+		}
+		id, rerr := res.LastInsertId()
+		if rerr != nil {
+			return run, rerr
+		}
+		run.ID = int(id)
+	}
+	if _, rerr := db.ExecContext(ctx, "DELETE FROM content_purge_runs WHERE started_at < ?", time.Now().AddDate(0, 0, -90)); rerr != nil {
+		return run, rerr
+	}
+	return run, err
+}
+
+func purgeDeletedContent(ctx context.Context, db *sql.DB, p RetentionPolicy, run *ContentPurgeRun) error {
+	purge := func(where string, days int, args ...any) error {
+		t := time.Now().AddDate(0, 0, -days)
+		res, err := db.ExecContext(ctx, "DELETE FROM deleted_content_archive WHERE deleted_at < ? AND "+where, append([]any{t}, args...)...)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		run.ArchivedPurged += int(n)
+
+		// Posts keep their bodies when they're deleted (unless their content
+		// is deleted too).
+		res, err = db.ExecContext(ctx, `UPDATE posts SET body = '' WHERE deleted = TRUE AND deleted_at < ? AND body <> ''
+			AND user_id IN (SELECT id FROM users WHERE `+where+`)`, append([]any{t}, args...)...)
+		if err != nil {
+			return err
+		}
+		if n, err = res.RowsAffected(); err != nil {
+			return err
+		}
+		run.PostsPurged += int(n)
+		return nil
+	}
+
+	var overridden []any
+	for j, days := range p.ByJurisdiction {
+		if j = normalizeJurisdiction(j); j == "" {
+			continue
+		}
+		overridden = append(overridden, j)
+		if days > 0 {
+			if err := purge("jurisdiction = ?", days, j); err != nil {
+				return err
+			}
+		}
+	}
+	if p.Days > 0 {
+		where := "jurisdiction IS NULL"
+		if len(overridden) > 0 {
+			where = "(jurisdiction IS NULL OR jurisdiction NOT IN " + msql.InClauseQuestionMarks(len(overridden)) + ")"
+		}
+		if err := purge(where, p.Days, overridden...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetContentPurgeRuns returns the last n runs of PurgeDeletedContent (that
+// purged anything or failed), the latest first.
+func GetContentPurgeRuns(ctx context.Context, db *sql.DB, n int) ([]*ContentPurgeRun, error) {
+	rows, err := db.QueryContext(ctx, `SELECT id, started_at, finished_at, archived_purged, posts_purged, error
+		FROM content_purge_runs ORDER BY started_at DESC LIMIT ?`, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []*ContentPurgeRun{}
+	for rows.Next() {
+		r := &ContentPurgeRun{}
+		if err := rows.Scan(&r.ID, &r.StartedAt, &r.FinishedAt, &r.ArchivedPurged, &r.PostsPurged, &r.Error); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
@@ -0,0 +1,23 @@
+package core
+
+import "testing"
+
+func TestNormalizeJurisdiction(t *testing.T) {
+	tests := []struct {
+		j, expect string
+	}{
+		{"de", "DE"},
+		{" US ", "US"},
+		{"US-CA", "US-CA"},
+		{"", ""},
+		{"XX", ""},
+		{"T1", ""},
+		{"D E", ""},
+		{"verylongcode", ""},
+	}
+	for _, test := range tests {
+		if got := normalizeJurisdiction(test.j); got != test.expect {
+			t.Errorf("normalizeJurisdiction(%q) = %q, expected %q", test.j, got, test.expect)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// searchQueriesTTL is how long the counts of search queries are kept.
+const searchQueriesTTL = 90 * 24 * time.Hour
+
+// maxSearchQueryLength is the number of characters of a search query that are
+// recorded.
+const maxSearchQueryLength = 64
+
+var (
+	searchEmailRegexp  = regexp.MustCompile(`\S+@\S+`)
+	searchURLRegexp    = regexp.MustCompile(`(?:https?://|www\.)\S+`)
+	searchNumberRegexp = regexp.MustCompile(`[+(]?\d[\d\s().+-]{3,}\d`)
+)
+
+// normalizeSearchQuery returns query in lower case, with whitespace collapsed
+// and truncated to maxSearchQueryLength characters. Email addresses, URLs, and
+// long numbers (like phone numbers), which may identify the searcher, are
+// replaced with placeholders.
+func normalizeSearchQuery(query string) string {
+	query = strings.ToLower(strings.Join(strings.Fields(query), " "))
+	query = searchEmailRegexp.ReplaceAllString(query, "<email>")
+	query = searchURLRegexp.ReplaceAllString(query, "<url>")
+	query = searchNumberRegexp.ReplaceAllString(query, "<number>")
+	if utf8.RuneCountInString(query) > maxSearchQueryLength {
+		query = string([]rune(query)[:maxSearchQueryLength])
+	}
+	return strings.TrimSpace(query)
+}
+
+// PruneSearchQueries deletes the counts of search queries older than
+// searchQueriesTTL.
+func PruneSearchQueries(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM search_queries WHERE hour < ?", time.Now().Add(-searchQueriesTTL))
+	return err
+}
+
+// SearchQueryCount is the number of searches of a query.
+type SearchQueryCount struct {
+	Query       string `json:"query"`
+	Searches    int64  `json:"searches"`
+	ZeroResults int64  `json:"zeroResults"`
+}
+
+// SearchDay is the number of searches in a day.
+type SearchDay struct {
+	Day         time.Time `json:"day"`
+	Searches    int64     `json:"searches"`
+	ZeroResults int64     `json:"zeroResults"`
+}
+
+// SearchAnalytics are the searches of a period.
+type SearchAnalytics struct {
+	Searches       int64   `json:"searches"`
+	ZeroResults    int64   `json:"zeroResults"`
+	ZeroResultRate float64 `json:"zeroResultRate"` // From 0 to 1.
+
+	Top           []*SearchQueryCount `json:"top"`           // The most common queries.
+	TopZeroResult []*SearchQueryCount `json:"topZeroResult"` // The most common queries that found nothing.
+	Days          []*SearchDay        `json:"days"`          // Days without searches are omitted.
+}
+
+// GetSearchAnalytics returns the searches from from to to, with the limit most
+// common queries (and queries that found nothing).
+func GetSearchAnalytics(ctx context.Context, db *sql.DB, from, to time.Time, limit int) (*SearchAnalytics, error) {
+	from, to = from.UTC().Truncate(time.Hour), to.UTC()
+	sa := &SearchAnalytics{Days: []*SearchDay{}}
+
+	rows, err := db.QueryContext(ctx, "SELECT hour, SUM(searches), SUM(zero_results) FROM search_queries WHERE hour >= ? AND hour < ? GROUP BY hour ORDER BY hour", from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		d := &SearchDay{}
+		if err := rows.Scan(&d.Day, &d.Searches, &d.ZeroResults); err != nil {
+			return nil, err
+		}
+		sa.Searches += d.Searches
+		sa.ZeroResults += d.ZeroResults
+		d.Day = d.Day.UTC().Truncate(24 * time.Hour)
+		if n := len(sa.Days); n > 0 && sa.Days[n-1].Day.Equal(d.Day) {
+			sa.Days[n-1].Searches += d.Searches
+			sa.Days[n-1].ZeroResults += d.ZeroResults
+			continue
+		}
+		sa.Days = append(sa.Days, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if sa.Searches > 0 {
+		sa.ZeroResultRate = float64(sa.ZeroResults) / float64(sa.Searches)
+	}
+
+	if sa.Top, err = getTopSearchQueries(ctx, db, from, to, "SUM(searches)", limit); err != nil {
+		return nil, err
+	}
+	if sa.TopZeroResult, err = getTopSearchQueries(ctx, db, from, to, "SUM(zero_results)", limit); err != nil {
+		return nil, err
+	}
+	return sa, nil
+}
+
+// getTopSearchQueries returns the limit queries from from to to with the
+// largest orderBy (an aggregate expression).
+func getTopSearchQueries(ctx context.Context, db *sql.DB, from, to time.Time, orderBy string, limit int) ([]*SearchQueryCount, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT query, SUM(searches), SUM(zero_results)
+		FROM search_queries
+		WHERE hour >= ? AND hour < ?
+		GROUP BY query
+		HAVING `+orderBy+` > 0
+		ORDER BY `+orderBy+` DESC, query
+		LIMIT ?`, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*SearchQueryCount{}
+	for rows.Next() {
+		q := &SearchQueryCount{}
+		if err := rows.Scan(&q.Query, &q.Searches, &q.ZeroResults); err != nil {
+			return nil, err
+		}
+		items = append(items, q)
+	}
+	return items, rows.Err()
+}
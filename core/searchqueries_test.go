@@ -0,0 +1,23 @@
+package core
+
+import "testing"
+
+func TestNormalizeSearchQuery(t *testing.T) {
+	tests := []struct {
+		query, expect string
+	}{
+		{"  Cats   AND dogs ", "cats and dogs"},
+		{"", ""},
+		{"contact me@example.com please", "contact <email> please"},
+		{"see https://example.com/a?b=c", "see <url>"},
+		{"call +1 (555) 123-4567", "call <number>"},
+		{"2024", "2024"},
+		{"ps5", "ps5"},
+		{"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
+	}
+	for _, test := range tests {
+		if got := normalizeSearchQuery(test.query); got != test.expect {
+			t.Errorf("normalizeSearchQuery(%q) = %q, expected %q", test.query, got, test.expect)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/internal/utils"
+)
+
+// SeedOptions is the amount of content Seed generates.
+type SeedOptions struct {
+	Users, Communities, Posts, Comments, Votes int
+
+	Days     int    // The content is spread over the last Days days.
+	Password string // The password of the users.
+	Seed     int64  // The seed of the random choices (the text is random regardless).
+}
+
+// SeedStats is the content generated by Seed.
+type SeedStats struct {
+	Admin       string // The username of the admin that created the communities.
+	Users       int
+	Communities int
+	Posts       int
+	Comments    int
+	Votes       int
+}
+
+var (
+	seedAdjectives = []string{"quiet", "brave", "lucky", "sunny", "misty", "rapid", "gentle", "clever", "cosmic", "rusty", "silver", "wild", "humble", "fuzzy", "bright", "sleepy"}
+	seedNouns      = []string{"otter", "falcon", "maple", "comet", "badger", "willow", "pixel", "harbor", "fox", "cactus", "lantern", "walrus", "meadow", "robot", "pebble", "heron"}
+	seedTopics     = []string{"cooking", "gardening", "programming", "cycling", "photography", "books", "movies", "music", "history", "science", "space", "gaming", "travel", "fitness", "design", "linux", "birds", "coffee", "chess", "woodworking"}
+)
+
+// seedComment is a comment generated by Seed.
+type seedComment struct {
+	id        uid.ID
+	depth     int
+	createdAt time.Time
+}
+
+// seedTally is the votes of a post or of a comment.
+type seedTally struct {
+	author    uid.ID
+	up, down  int
+	createdAt time.Time // Of posts, for their hotness.
+	isPost    bool
+}
+
+// Seed generates users, communities (created by a new admin user), posts,
+// nested comments, and votes, at the scale of opts, for development, demos,
+// and load testing. Popularity is skewed, like on a real site: a few posts get
+// most comments and votes. Notifications are not created.
+func Seed(ctx context.Context, db *sql.DB, opts SeedOptions) (*SeedStats, error) {
+	r := rand.New(rand.NewSource(opts.Seed))
+	now := time.Now()
+	if opts.Days < 1 {
+		opts.Days = 1
+	}
+	window := time.Duration(opts.Days) * 24 * time.Hour
+	randomTime := func(after time.Time) time.Time {
+		if after.IsZero() {
+			after = now.Add(-window)
+		}
+		if !after.Before(now) {
+			return now
+		}
+		return after.Add(time.Duration(r.Int63n(int64(now.Sub(after)))))
+	}
+	stats := &SeedStats{}
+
+	// Users.
+	hash, err := HashPassword([]byte(opts.Password))
+	if err != nil {
+		return nil, err
+	}
+	var users []uid.ID
+	userCreatedAt := make(map[uid.ID]time.Time)
+	for i := 0; i < opts.Users+1; i++ { // The first user is the admin.
+		name := ""
+		for attempt := 0; attempt < 5 && name == ""; attempt++ {
+			candidate := seedAdjectives[r.Intn(len(seedAdjectives))] + "_" + seedNouns[r.Intn(len(seedNouns))] + fmt.Sprint(r.Intn(1000))
+			if i == 0 {
+				candidate = "admin_" + seedNouns[r.Intn(len(seedNouns))] + fmt.Sprint(r.Intn(1000))
+			}
+			if exists, _, err := usernameExists(ctx, db, candidate); err != nil {
+				return nil, err
+			} else if !exists {
+				name = candidate
+			}
+		}
+		if name == "" {
+			continue
+		}
+		id, createdAt := uid.New(), randomTime(time.Time{})
+		query, args := msql.BuildInsertQuery("users", []msql.ColumnValue{
+			{Name: "id", Value: id},
+			{Name: "username", Value: name},
+			{Name: "username_lc", Value: strings.ToLower(name)},
+			{Name: "password", Value: hash},
+			{Name: "is_admin", Value: i == 0},
+			{Name: "created_at", Value: createdAt},
+		})
+		if _, err := db.ExecContext(ctx, query, args...); err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			stats.Admin = name
+			// The admin creates the communities, and so predates everything.
+			if _, err := db.ExecContext(ctx, "UPDATE users SET created_at = ? WHERE id = ?", now.Add(-window), id); err != nil {
+				return nil, err
+			}
+			createdAt = now.Add(-window)
+		} else {
+			stats.Users++
+		}
+		users = append(users, id)
+		userCreatedAt[id] = createdAt
+	}
+	if len(users) == 0 {
+		return stats, fmt.Errorf("no usernames left to seed")
+	}
+
+	// Communities.
+	var comms []*Community
+	for i := 0; i < opts.Communities; i++ {
+		name := seedTopics[i%len(seedTopics)]
+		if i >= len(seedTopics) || r.Intn(2) == 0 {
+			name += fmt.Sprint(r.Intn(10000))
+		}
+		if exists, _, err := CommunityExists(ctx, db, name); err != nil {
+			return nil, err
+		} else if exists {
+			continue
+		}
+		comm, err := CreateCommunity(ctx, db, users[0], 0, opts.Communities, name, utils.GenerateSenetence(12))
+		if err != nil {
+			return nil, err
+		}
+		comms = append(comms, comm)
+		stats.Communities++
+	}
+	if len(comms) == 0 && opts.Posts > 0 {
+		return stats, fmt.Errorf("no communities to post in")
+	}
+	for _, user := range users[1:] {
+		for _, i := range r.Perm(len(comms))[:min(len(comms), 1+r.Intn(5))] {
+			if err := comms[i].Join(ctx, user); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Posts.
+	var posts []*Post
+	tallies := make(map[uid.ID]*seedTally)
+	for i := 0; i < opts.Posts; i++ {
+		author := users[r.Intn(len(users))]
+		postOpts := &createPostOpts{
+			postType:  PostTypeText,
+			author:    author,
+			community: comms[r.Intn(len(comms))].ID,
+			title:     strings.TrimSuffix(utils.GenerateSenetence(4+r.Intn(10)), "."),
+			createdAt: randomTime(userCreatedAt[author]),
+		}
+		if r.Intn(5) == 0 {
+			link, err := parsePostLink(fmt.Sprintf("https://example.com/%s/%d", seedNouns[r.Intn(len(seedNouns))], r.Intn(100000)))
+			if err != nil {
+				return nil, err
+			}
+			postOpts.postType, postOpts.link = PostTypeLink, *link
+		} else {
+			postOpts.body = utils.GenerateText()
+		}
+		post, err := createPost(ctx, db, postOpts)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+		tallies[post.ID] = &seedTally{author: author, createdAt: post.CreatedAt, isPost: true}
+		stats.Posts++
+	}
+	if len(posts) == 0 {
+		return stats, nil
+	}
+
+	// A few posts get most of the comments and votes.
+	zipf := rand.NewZipf(r, 1.2, 1, uint64(max(len(posts)-1, 1)))
+	popular := func() int { return int(zipf.Uint64()) % len(posts) }
+
+	// Comments, in trees: a comment replies either to the post or to a
+	// comment before it.
+	postComments := make(map[uid.ID][]*seedComment)
+	var comments []uid.ID
+	authors := make(map[uid.ID]*User)
+	for i := 0; i < opts.Comments; i++ {
+		post := posts[popular()]
+		author := users[r.Intn(len(users))]
+		if authors[author] == nil {
+			u, err := GetUser(ctx, db, author, nil)
+			if err != nil {
+				return nil, err
+			}
+			authors[author] = u
+		}
+		after := post.CreatedAt
+		var parent *seedComment
+		if siblings := postComments[post.ID]; len(siblings) > 0 && r.Intn(10) < 6 {
+			parent = siblings[r.Intn(len(siblings))]
+			if parent.depth >= maxCommentDepth {
+				parent = nil
+			} else {
+				after = parent.createdAt
+			}
+		}
+		var parentID *uid.ID
+		if parent != nil {
+			parentID = &parent.id
+		}
+		created := randomTime(after)
+		// Replies come mostly soon after what they reply to.
+		if d := created.Sub(after); d > 6*time.Hour && r.Intn(4) > 0 {
+			created = after.Add(time.Duration(r.Int63n(int64(6 * time.Hour))))
+		}
+		c, err := addComment(ctx, db, post, authors[author], parentID, utils.GenerateText(), &addCommentOpts{
+			createdAt:       created,
+			noNotifications: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		sc := &seedComment{id: c.ID, createdAt: created}
+		if parent != nil {
+			sc.depth = parent.depth + 1
+		}
+		postComments[post.ID] = append(postComments[post.ID], sc)
+		comments = append(comments, c.ID)
+		tallies[c.ID] = &seedTally{author: author}
+		stats.Comments++
+	}
+
+	// Votes: mostly up, and mostly on posts.
+	for i := 0; i < opts.Votes; i++ {
+		user, up := users[r.Intn(len(users))], r.Intn(5) > 0
+		var target uid.ID
+		query := "INSERT INTO post_votes (post_id, user_id, up) VALUES (?, ?, ?)"
+		if len(comments) > 0 && r.Intn(10) < 3 {
+			target = comments[r.Intn(len(comments))]
+			query = "INSERT INTO comment_votes (comment_id, user_id, up) VALUES (?, ?, ?)"
+		} else {
+			target = posts[popular()].ID
+		}
+		if _, err := db.ExecContext(ctx, query, target, user, up); err != nil {
+			if msql.IsErrDuplicateErr(err) {
+				continue
+			}
+			return nil, err
+		}
+		t := tallies[target]
+		if up {
+			t.up++
+		} else {
+			t.down++
+		}
+		stats.Votes++
+	}
+	userPoints := make(map[uid.ID]int)
+	for id, t := range tallies {
+		if t.up == 0 && t.down == 0 {
+			continue
+		}
+		userPoints[t.author] += t.up
+		if !t.isPost {
+			if _, err := db.ExecContext(ctx, "UPDATE comments SET upvotes = ?, downvotes = ?, points = ? WHERE id = ?", t.up, t.down, t.up-t.down, id); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if _, err := db.ExecContext(ctx, "UPDATE posts SET upvotes = ?, downvotes = ?, points = ?, hotness = ? WHERE id = ?",
+			t.up, t.down, t.up-t.down, PostHotness(t.up, t.down, t.createdAt), id); err != nil {
+			return nil, err
+		}
+		for _, table := range postsTables {
+			if _, err := db.ExecContext(ctx, "UPDATE "+table+" SET points = ? WHERE post_id = ?", t.up-t.down, id); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for user, points := range userPoints {
+		if err := incrementUserPoints(ctx, db, user, points); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,293 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+)
+
+// maxSitemapURLs is the maximum number of URLs in a sitemap (as per the
+// sitemaps protocol).
+const maxSitemapURLs = 50000
+
+// sitemapCommunities is the name of the sitemap of the communities. The posts
+// are in a sitemap per month in which they were created (named like
+// posts-2024-05).
+const sitemapCommunities = "communities"
+
+// A SitemapEntry is a page of a sitemap.
+type SitemapEntry struct {
+	Path    string    `json:"path"` // Ex: /general/post/abcdefgh.
+	LastMod time.Time `json:"lastMod"`
+}
+
+// A Sitemap is a list of the pages of the site, for search engines. The
+// sitemaps are saved by GenerateSitemaps, and listed in an index (see
+// WriteSitemapIndex).
+type Sitemap struct {
+	Name    string
+	LastMod time.Time // Of the last modified page.
+	Entries []SitemapEntry
+}
+
+// GenerateSitemaps saves the sitemap of the communities, and those of the
+// posts of the months in which posts were added, edited, commented on, or
+// deleted since the last run (so that only those are regenerated). It
+// returns the number of sitemaps saved.
+func GenerateSitemaps(ctx context.Context, db *sql.DB) (int, error) {
+	var since time.Time
+	err := db.QueryRowContext(ctx, "SELECT generated_at FROM sitemaps ORDER BY generated_at DESC LIMIT 1").Scan(&since)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	now := time.Now()
+
+	comms, err := communitiesSitemap(ctx, db, since)
+	if err != nil {
+		return 0, err
+	}
+	if err := saveSitemap(ctx, db, comms, now); err != nil {
+		return 0, err
+	}
+	n := 1
+
+	months, err := changedPostMonths(ctx, db, since)
+	if err != nil {
+		return n, err
+	}
+	for _, month := range months {
+		sitemap, err := postsSitemap(ctx, db, month)
+		if err != nil {
+			return n, err
+		}
+		if len(sitemap.Entries) == 0 {
+			if _, err := db.ExecContext(ctx, "DELETE FROM sitemaps WHERE name = ?", sitemap.Name); err != nil {
+				return n, err
+			}
+			continue
+		}
+		if err := saveSitemap(ctx, db, sitemap, now); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// changedPostMonths returns the months (as the times they begin, in UTC) in
+// which the posts that have changed since since were created.
+func changedPostMonths(ctx context.Context, db *sql.DB, since time.Time) ([]time.Time, error) {
+	rows, err := db.QueryContext(ctx, "SELECT created_at FROM posts WHERE last_activity_at > ? OR edited_at > ? OR deleted_at > ?", since, since, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seen := make(map[time.Time]bool)
+	var months []time.Time
+	for rows.Next() {
+		var createdAt time.Time
+		if err := rows.Scan(&createdAt); err != nil {
+			return nil, err
+		}
+		t := createdAt.UTC()
+		month := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if !seen[month] {
+			seen[month] = true
+			months = append(months, month)
+		}
+	}
+	return months, rows.Err()
+}
+
+// postsSitemap returns the sitemap of the posts (those not deleted, of
+// communities not deleted) created in month.
+func postsSitemap(ctx context.Context, db *sql.DB, month time.Time) (*Sitemap, error) {
+	sitemap := &Sitemap{Name: "posts-" + month.Format("2006-01")}
+	rows, err := db.QueryContext(ctx, `
+		SELECT posts.public_id, communities.name, posts.last_activity_at, posts.edited_at
+		FROM posts
+		INNER JOIN communities ON communities.id = posts.community_id
+		WHERE posts.deleted = FALSE AND communities.deleted_at IS NULL AND posts.created_at >= ? AND posts.created_at < ?
+		ORDER BY posts.created_at
+		LIMIT ?`, month, month.AddDate(0, 1, 0), maxSitemapURLs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			publicID, community string
+			lastActivityAt      time.Time
+			editedAt            msql.NullTime
+		)
+		if err := rows.Scan(&publicID, &community, &lastActivityAt, &editedAt); err != nil {
+			return nil, err
+		}
+		lastMod := lastActivityAt
+		if editedAt.Valid && editedAt.Time.After(lastMod) {
+			lastMod = editedAt.Time
+		}
+		sitemap.add("/"+community+"/post/"+publicID, lastMod)
+	}
+	return sitemap, rows.Err()
+}
+
+// communitiesSitemap returns the sitemap of the communities (those not
+// deleted). The last modified time of a community is that of the last
+// activity in its posts, which is updated with the activity since since.
+func communitiesSitemap(ctx context.Context, db *sql.DB, since time.Time) (*Sitemap, error) {
+	lastMods := make(map[string]time.Time) // By path.
+	if prev, err := GetSitemap(ctx, db, sitemapCommunities); err == nil {
+		for _, e := range prev.Entries {
+			lastMods[e.Path] = e.LastMod
+		}
+	} else if err != errSitemapNotFound {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT communities.name, posts.last_activity_at
+		FROM posts
+		INNER JOIN communities ON communities.id = posts.community_id
+		WHERE posts.deleted = FALSE AND posts.last_activity_at > ?`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			name           string
+			lastActivityAt time.Time
+		)
+		if err := rows.Scan(&name, &lastActivityAt); err != nil {
+			return nil, err
+		}
+		if path := "/" + name; lastActivityAt.After(lastMods[path]) {
+			lastMods[path] = lastActivityAt
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sitemap := &Sitemap{Name: sitemapCommunities}
+	rows, err = db.QueryContext(ctx, "SELECT name, created_at FROM communities WHERE deleted_at IS NULL ORDER BY created_at LIMIT ?", maxSitemapURLs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			name      string
+			createdAt time.Time
+		)
+		if err := rows.Scan(&name, &createdAt); err != nil {
+			return nil, err
+		}
+		path := "/" + name
+		lastMod := createdAt
+		if t := lastMods[path]; t.After(lastMod) {
+			lastMod = t
+		}
+		sitemap.add(path, lastMod)
+	}
+	return sitemap, rows.Err()
+}
+
+func (s *Sitemap) add(path string, lastMod time.Time) {
+	s.Entries = append(s.Entries, SitemapEntry{Path: path, LastMod: lastMod})
+	if lastMod.After(s.LastMod) {
+		s.LastMod = lastMod
+	}
+}
+
+func saveSitemap(ctx context.Context, db *sql.DB, s *Sitemap, generatedAt time.Time) error {
+	entries, err := json.Marshal(s.Entries)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO sitemaps (name, entries, last_mod, generated_at) VALUES (?, ?, ?, ?) "+
+		msql.UpsertClause([]string{"name"}, "entries", "last_mod", "generated_at"), s.Name, string(entries), s.LastMod, generatedAt)
+	return err
+}
+
+// GetSitemap returns the sitemap named name.
+func GetSitemap(ctx context.Context, db *sql.DB, name string) (*Sitemap, error) {
+	s := &Sitemap{Name: name}
+	var entries []byte
+	err := db.QueryRowContext(ctx, "SELECT entries, last_mod FROM sitemaps WHERE name = ?", name).Scan(&entries, &s.LastMod)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errSitemapNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(entries, &s.Entries); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// The elements of the XML of sitemaps.
+type (
+	xmlSitemapIndex struct {
+		XMLName  xml.Name      `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+		Sitemaps []xmlLocation `xml:"sitemap"`
+	}
+	xmlURLSet struct {
+		XMLName xml.Name      `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+		URLs    []xmlLocation `xml:"url"`
+	}
+	xmlLocation struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	}
+)
+
+func writeXML(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(v)
+}
+
+// WriteXML writes the sitemap as XML to w, with the URLs of its pages made of
+// baseURL (ex: https://discuit.org) and their paths.
+func (s *Sitemap) WriteXML(w io.Writer, baseURL string) error {
+	set := xmlURLSet{URLs: make([]xmlLocation, len(s.Entries))}
+	for i, e := range s.Entries {
+		set.URLs[i] = xmlLocation{Loc: baseURL + e.Path, LastMod: e.LastMod.UTC().Format(time.RFC3339)}
+	}
+	return writeXML(w, set)
+}
+
+// WriteSitemapIndex writes the XML of the sitemap index, which lists all the
+// sitemaps, to w. The URL of a sitemap is made of baseURL (ex:
+// https://discuit.org/sitemaps/) and its name, followed by .xml.
+func WriteSitemapIndex(ctx context.Context, db *sql.DB, w io.Writer, baseURL string) error {
+	rows, err := db.QueryContext(ctx, "SELECT name, last_mod FROM sitemaps ORDER BY name")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var index xmlSitemapIndex
+	for rows.Next() {
+		var (
+			name    string
+			lastMod time.Time
+		)
+		if err := rows.Scan(&name, &lastMod); err != nil {
+			return err
+		}
+		index.Sitemaps = append(index.Sitemaps, xmlLocation{Loc: baseURL + name + ".xml", LastMod: lastMod.UTC().Format(time.RFC3339)})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return writeXML(w, index)
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSitemapWriteXML(t *testing.T) {
+	s := &Sitemap{Name: "posts-2024-05"}
+	s.add("/general/post/abcdefgh", time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC))
+	s.add("/a&b/post/ijklmnop", time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+	if want := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC); !s.LastMod.Equal(want) {
+		t.Errorf("LastMod = %v, want %v", s.LastMod, want)
+	}
+
+	var b strings.Builder
+	if err := s.WriteXML(&b, "https://example.com"); err != nil {
+		t.Fatal(err)
+	}
+	got := b.String()
+	for _, want := range []string{
+		`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`,
+		`<url><loc>https://example.com/general/post/abcdefgh</loc><lastmod>2024-05-02T10:00:00Z</lastmod></url>`,
+		`<loc>https://example.com/a&amp;b/post/ijklmnop</loc>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("WriteXML: %s lacks %s", got, want)
+		}
+	}
+}
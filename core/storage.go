@@ -0,0 +1,258 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/images"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// The values of the media_type column of the media_uploads table.
+const (
+	mediaTypeImage = "image"
+	mediaTypeVideo = "video"
+)
+
+// mediaUploadExists is an SQL condition that's true if the media of a row of
+// media_uploads is not yet deleted.
+const mediaUploadExists = `(
+	(media_uploads.media_type = 'image' AND EXISTS (SELECT 1 FROM images WHERE images.id = media_uploads.media_id))
+	OR (media_uploads.media_type = 'video' AND EXISTS (SELECT 1 FROM videos WHERE videos.id = media_uploads.media_id)))`
+
+// addMediaUploadTx records the upload of an image or a video (of mediaType),
+// of size bytes, by user for community (either of which may be nil), for
+// storage accounting.
+func addMediaUploadTx(ctx context.Context, tx *sql.Tx, id uid.ID, mediaType string, user, community *uid.ID, size int64) error {
+	cols := []msql.ColumnValue{
+		{Name: "media_id", Value: id},
+		{Name: "media_type", Value: mediaType},
+		{Name: "size", Value: size},
+	}
+	if user != nil {
+		cols = append(cols, msql.ColumnValue{Name: "user_id", Value: *user})
+	}
+	if community != nil {
+		cols = append(cols, msql.ColumnValue{Name: "community_id", Value: *community})
+	}
+	query, args := msql.BuildInsertQuery("media_uploads", cols)
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// addImageUploadTx is addMediaUploadTx for a just saved image.
+func addImageUploadTx(ctx context.Context, tx *sql.Tx, image uid.ID, user, community *uid.ID) error {
+	var size int64
+	if err := tx.QueryRowContext(ctx, "SELECT size FROM images WHERE id = ?", image).Scan(&size); err != nil {
+		return err
+	}
+	return addMediaUploadTx(ctx, tx, image, mediaTypeImage, user, community, size)
+}
+
+// setMediaUploadCommunityTx charges the upload of media, once it's posted, to
+// community.
+func setMediaUploadCommunityTx(ctx context.Context, tx *sql.Tx, media, community uid.ID) error {
+	_, err := tx.ExecContext(ctx, "UPDATE media_uploads SET community_id = ? WHERE media_id = ?", community, media)
+	return err
+}
+
+// StorageUsage is the number of bytes of media stored on behalf of a user or
+// a community.
+type StorageUsage struct {
+	Images int64 `json:"images"`
+	Videos int64 `json:"videos"`
+	Total  int64 `json:"total"`
+	Quota  int64 `json:"quota"` // In bytes; zero if there's no quota.
+}
+
+// CheckQuota returns an error if storing size more bytes would take u over its
+// quota.
+func (u *StorageUsage) CheckQuota(size int64) error {
+	if u.Quota > 0 && u.Total+size > u.Quota {
+		return httperr.NewForbidden("storage/quota-exceeded", fmt.Sprintf("Storage quota of %d MB exceeded.", u.Quota>>20))
+	}
+	return nil
+}
+
+// GetUserStorageUsage returns the storage used by the uploads of user, with
+// quota (in bytes) set as its quota.
+func GetUserStorageUsage(ctx context.Context, db *sql.DB, user uid.ID, quota int64) (*StorageUsage, error) {
+	return getStorageUsage(ctx, db, "user_id", user, quota)
+}
+
+// GetCommunityStorageUsage returns the storage used by the media posted to
+// community (and by its profile picture and banner), with quota (in bytes) set
+// as its quota.
+func GetCommunityStorageUsage(ctx context.Context, db *sql.DB, community uid.ID, quota int64) (*StorageUsage, error) {
+	return getStorageUsage(ctx, db, "community_id", community, quota)
+}
+
+func getStorageUsage(ctx context.Context, db *sql.DB, col string, id uid.ID, quota int64) (*StorageUsage, error) {
+	usage, err := queryStorageUsage(ctx, db, col+" = ? AND "+mediaUploadExists, id)
+	if err != nil {
+		return nil, err
+	}
+	usage.Quota = quota
+	return usage, nil
+}
+
+// queryStorageUsage returns the sum of the sizes of the rows of media_uploads
+// that match the SQL condition where.
+func queryStorageUsage(ctx context.Context, db *sql.DB, where string, args ...any) (*StorageUsage, error) {
+	rows, err := db.QueryContext(ctx, "SELECT media_type, SUM(size) FROM media_uploads WHERE "+where+" GROUP BY media_type", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := &StorageUsage{}
+	for rows.Next() {
+		var mediaType string
+		var size int64
+		if err := rows.Scan(&mediaType, &size); err != nil {
+			return nil, err
+		}
+		switch mediaType {
+		case mediaTypeImage:
+			usage.Images = size
+		case mediaTypeVideo:
+			usage.Videos = size
+		}
+		usage.Total += size
+	}
+	return usage, rows.Err()
+}
+
+// MediaUploadSize returns the size of the uploaded image or video with id, or
+// zero if it's not accounted for.
+func MediaUploadSize(ctx context.Context, db *sql.DB, id uid.ID) (int64, error) {
+	var size int64
+	if err := db.QueryRowContext(ctx, "SELECT size FROM media_uploads WHERE media_id = ?", id).Scan(&size); err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	return size, nil
+}
+
+// StorageConsumer is a user or a community, along with the number of bytes
+// of media stored on behalf of it.
+type StorageConsumer struct {
+	ID   uid.ID `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// GetTopStorageUsers returns the n users that use the most storage.
+func GetTopStorageUsers(ctx context.Context, db *sql.DB, n int) ([]*StorageConsumer, error) {
+	return getTopStorageConsumers(ctx, db, "users", "username", "user_id", n)
+}
+
+// GetTopStorageCommunities returns the n communities that use the most
+// storage.
+func GetTopStorageCommunities(ctx context.Context, db *sql.DB, n int) ([]*StorageConsumer, error) {
+	return getTopStorageConsumers(ctx, db, "communities", "name", "community_id", n)
+}
+
+func getTopStorageConsumers(ctx context.Context, db *sql.DB, table, nameCol, col string, n int) ([]*StorageConsumer, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %[1]s.id, %[1]s.%[2]s, SUM(media_uploads.size) AS total
+		FROM media_uploads
+		INNER JOIN %[1]s ON %[1]s.id = media_uploads.%[3]s
+		WHERE %[4]s
+		GROUP BY %[1]s.id, %[1]s.%[2]s
+		ORDER BY total DESC
+		LIMIT ?`, table, nameCol, col, mediaUploadExists), n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*StorageConsumer{}
+	for rows.Next() {
+		item := &StorageConsumer{}
+		if err := rows.Scan(&item.ID, &item.Name, &item.Size); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// GetTotalStorageUsage returns the storage used by all uploaded media.
+func GetTotalStorageUsage(ctx context.Context, db *sql.DB) (*StorageUsage, error) {
+	return queryStorageUsage(ctx, db, mediaUploadExists)
+}
+
+// PruneMediaUploads deletes the rows of media_uploads whose media is deleted,
+// and it returns how many were deleted.
+func PruneMediaUploads(ctx context.Context, db *sql.DB) (int, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM media_uploads WHERE NOT "+mediaUploadExists)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// RemoveDeletedContentMedia deletes the images and videos of posts deleted
+// more than age ago (whose content is otherwise kept for moderators), and the
+// profile pictures of deleted users. It returns the number of posts and users
+// whose media was deleted.
+func RemoveDeletedContentMedia(ctx context.Context, db *sql.DB, age time.Duration) (int, error) {
+	t := time.Now().Add(-age)
+	rows, err := db.QueryContext(ctx, `
+		SELECT id FROM posts
+		WHERE deleted_at < ? AND deleted_content = FALSE AND (
+			link_image IS NOT NULL
+			OR EXISTS (SELECT 1 FROM post_images WHERE post_images.post_id = posts.id)
+			OR EXISTS (SELECT 1 FROM post_videos WHERE post_videos.post_id = posts.id))
+		LIMIT 1000`, t)
+	if err != nil {
+		return 0, err
+	}
+	postIDs, err := scanIDs(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, id := range postIDs {
+		post, err := GetPost(ctx, db, &id, "", nil, true)
+		if err != nil {
+			return n, err
+		}
+		if err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, "UPDATE posts SET link_image = NULL WHERE id = ?", post.ID); err != nil {
+				return err
+			}
+			return post.deleteMediaTx(ctx, tx)
+		}); err != nil {
+			return n, fmt.Errorf("failed to delete media of post %v: %w", post.ID, err)
+		}
+		n++
+	}
+
+	rows, err = db.QueryContext(ctx, "SELECT pro_pic FROM users WHERE deleted_at < ? AND pro_pic IS NOT NULL LIMIT 1000", t)
+	if err != nil {
+		return n, err
+	}
+	imageIDs, err := scanIDs(rows)
+	if err != nil {
+		return n, err
+	}
+	for _, id := range imageIDs {
+		if err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, "UPDATE users SET pro_pic = NULL WHERE pro_pic = ?", id); err != nil {
+				return err
+			}
+			return images.DeleteImageTx(ctx, tx, db, id)
+		}); err != nil {
+			return n, fmt.Errorf("failed to delete image %v: %w", id, err)
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,212 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// SupporterPerks are what supporters get for a payment.
+type SupporterPerks struct {
+	Badge        string // The type of the badge of supporters. Empty for none.
+	ExtraStorage int64  // In bytes, added to the storage quota of supporters.
+	Days         int    // How long the perks last, unless a payment says otherwise. Zero means forever.
+}
+
+// A SupporterEntitlement is the perks a user gets for a payment (which may
+// have been made by another user, as a gift).
+type SupporterEntitlement struct {
+	ID           int           `json:"id"`
+	UserID       uid.ID        `json:"userId"`
+	GiftedBy     uid.NullID    `json:"giftedBy"`
+	Provider     string        `json:"provider"`
+	PaymentID    string        `json:"-"`
+	Badge        string        `json:"badge"`
+	ExtraStorage int64         `json:"extraStorage"`
+	ExpiresAt    msql.NullTime `json:"expiresAt"`
+	EndedAt      msql.NullTime `json:"endedAt"` // When it expired, or was refunded.
+	CreatedAt    time.Time     `json:"createdAt"`
+}
+
+var selectSupporterEntitlementCols = []string{
+	"id",
+	"user_id",
+	"gifted_by",
+	"provider",
+	"payment_id",
+	"badge",
+	"extra_storage",
+	"expires_at",
+	"ended_at",
+	"created_at",
+}
+
+func scanSupporterEntitlements(rows *sql.Rows) ([]*SupporterEntitlement, error) {
+	defer rows.Close()
+	var es []*SupporterEntitlement
+	for rows.Next() {
+		e := &SupporterEntitlement{}
+		if err := rows.Scan(&e.ID, &e.UserID, &e.GiftedBy, &e.Provider, &e.PaymentID, &e.Badge, &e.ExtraStorage, &e.ExpiresAt, &e.EndedAt, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		es = append(es, e)
+	}
+	return es, rows.Err()
+}
+
+// GetSupporterEntitlements returns the entitlements of user, the latest first.
+func GetSupporterEntitlements(ctx context.Context, db *sql.DB, user uid.ID) ([]*SupporterEntitlement, error) {
+	query := msql.BuildSelectQuery("supporter_entitlements", selectSupporterEntitlementCols, nil, "WHERE user_id = ? ORDER BY id DESC")
+	rows, err := db.QueryContext(ctx, query, user)
+	if err != nil {
+		return nil, err
+	}
+	return scanSupporterEntitlements(rows)
+}
+
+func getSupporterEntitlement(ctx context.Context, db *sql.DB, provider, paymentID string) (*SupporterEntitlement, error) {
+	query := msql.BuildSelectQuery("supporter_entitlements", selectSupporterEntitlementCols, nil, "WHERE provider = ? AND payment_id = ?")
+	rows, err := db.QueryContext(ctx, query, provider, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	es, err := scanSupporterEntitlements(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(es) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return es[0], nil
+}
+
+// GrantSupporterEntitlement gives user perks for the payment paymentID (to
+// provider), made by giftedBy, if it's not nil, for user. The perks last for
+// days days, or perks.Days if days is zero. Granting the perks of a payment
+// more than once (as webhooks may be retried) only grants them once.
+func GrantSupporterEntitlement(ctx context.Context, db *sql.DB, provider, paymentID string, user uid.ID, giftedBy *uid.ID, perks *SupporterPerks, days int) (*SupporterEntitlement, error) {
+	if e, err := getSupporterEntitlement(ctx, db, provider, paymentID); err == nil {
+		return e, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	hadBadge, err := hasSupporterBadge(ctx, db, user, perks.Badge)
+	if err != nil {
+		return nil, err
+	}
+
+	if days == 0 {
+		days = perks.Days
+	}
+	now := time.Now()
+	var expiresAt msql.NullTime
+	if days > 0 {
+		expiresAt = msql.NewNullTime(now.AddDate(0, 0, days))
+	}
+	var gifter uid.NullID
+	if giftedBy != nil {
+		gifter = uid.NullID{ID: *giftedBy, Valid: true}
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO supporter_entitlements (user_id, gifted_by, provider, payment_id, badge, extra_storage, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, user, gifter, provider, paymentID, perks.Badge, perks.ExtraStorage, expiresAt, now)
+	if err != nil && !msql.IsErrDuplicateErr(err) { // A duplicate if granted concurrently.
+		return nil, err
+	}
+
+	if perks.Badge != "" && !hadBadge {
+		if err := NewBadgeType(db, perks.Badge); err != nil {
+			return nil, err
+		}
+		u, err := GetUser(ctx, db, user, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := u.AddBadge(ctx, perks.Badge); err != nil {
+			return nil, err
+		}
+	}
+	return getSupporterEntitlement(ctx, db, provider, paymentID)
+}
+
+// hasSupporterBadge reports whether user has the badge badge of supporters
+// from an entitlement that has not ended.
+func hasSupporterBadge(ctx context.Context, db *sql.DB, user uid.ID, badge string) (bool, error) {
+	if badge == "" {
+		return false, nil
+	}
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM supporter_entitlements WHERE user_id = ? AND badge = ? AND ended_at IS NULL", user, badge).Scan(&n)
+	return n > 0, err
+}
+
+// RevokeSupporterEntitlement ends the entitlement of the payment paymentID
+// (to provider), which was refunded, if there's one.
+func RevokeSupporterEntitlement(ctx context.Context, db *sql.DB, provider, paymentID string) error {
+	e, err := getSupporterEntitlement(ctx, db, provider, paymentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if e.EndedAt.Valid {
+		return nil
+	}
+	return endSupporterEntitlement(ctx, db, e)
+}
+
+// endSupporterEntitlement ends e, removing the badge of e from its user
+// unless it's also theirs through another entitlement.
+func endSupporterEntitlement(ctx context.Context, db *sql.DB, e *SupporterEntitlement) error {
+	if _, err := db.ExecContext(ctx, "UPDATE supporter_entitlements SET ended_at = ? WHERE id = ? AND ended_at IS NULL", time.Now(), e.ID); err != nil {
+		return err
+	}
+	if e.Badge == "" {
+		return nil
+	}
+	if has, err := hasSupporterBadge(ctx, db, e.UserID, e.Badge); err != nil || has {
+		return err
+	}
+	u, err := GetUser(ctx, db, e.UserID, nil)
+	if err != nil {
+		if errors.Is(err, errUserNotFound) {
+			return nil
+		}
+		return err
+	}
+	return u.RemoveBadgesByType(e.Badge)
+}
+
+// ExpireSupporterEntitlements ends the entitlements that have expired. It
+// returns the number of entitlements ended.
+func ExpireSupporterEntitlements(ctx context.Context, db *sql.DB) (int, error) {
+	query := msql.BuildSelectQuery("supporter_entitlements", selectSupporterEntitlementCols, nil, "WHERE ended_at IS NULL AND expires_at <= ?")
+	rows, err := db.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	es, err := scanSupporterEntitlements(rows)
+	if err != nil {
+		return 0, err
+	}
+	for i, e := range es {
+		if err := endSupporterEntitlement(ctx, db, e); err != nil {
+			return i, err
+		}
+	}
+	return len(es), nil
+}
+
+// SupporterExtraStorage returns the storage (in bytes) added to the storage
+// quota of user by their entitlements (the most of those that have not
+// ended, since they don't add up).
+func SupporterExtraStorage(ctx context.Context, db *sql.DB, user uid.ID) (int64, error) {
+	var extra int64
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(extra_storage), 0) FROM supporter_entitlements WHERE user_id = ? AND ended_at IS NULL", user).Scan(&extra)
+	return extra, err
+}
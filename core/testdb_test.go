@@ -0,0 +1,49 @@
+package core
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newSQLiteTestDB returns an in-memory SQLite database with all the (SQLite)
+// migrations applied.
+func newSQLiteTestDB(t *testing.T) *sql.DB {
+	return openSQLiteTestDB(t, "sqlite3")
+}
+
+// openSQLiteTestDB is like newSQLiteTestDB except that the database is opened
+// with the driver driverName (a wrapper of the SQLite driver). The SQL dialect
+// is SQLite's until the end of the test.
+func openSQLiteTestDB(t *testing.T, driverName string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1) // Each connection has its own in-memory database.
+	t.Cleanup(func() { db.Close() })
+	msql.SetDriver(msql.DriverSQLite)
+	t.Cleanup(func() { msql.SetDriver(msql.DriverMySQL) })
+
+	files, err := filepath.Glob(filepath.Join("..", "migrations", "sqlite", "*.up.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		query, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(query)); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+	}
+	return db
+}
@@ -0,0 +1,34 @@
+package core
+
+import (
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+)
+
+// checkTimeZone returns an httperr.Error for the field field of a request if
+// tz is neither empty (for UTC) nor the IANA name of a time zone (like
+// Europe/Paris).
+func checkTimeZone(field, tz string) error {
+	if tz == "" {
+		return nil
+	}
+	// Local is accepted by time.LoadLocation, but it's the server's time zone.
+	if _, err := time.LoadLocation(tz); err != nil || tz == "Local" {
+		return httperr.NewInvalidField(field, "invalid-time-zone", "Invalid time zone.")
+	}
+	return nil
+}
+
+// timeZoneLocation returns the location of the time zone tz (see
+// checkTimeZone), or UTC if it's empty or invalid.
+func timeZoneLocation(tz string) *time.Location {
+	if tz == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil || tz == "Local" {
+		return time.UTC
+	}
+	return loc
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/translate"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/internal/utils"
+)
+
+// CommentTranslation is the body of a comment translated to another language.
+type CommentTranslation struct {
+	CommentID uid.ID `json:"commentId"`
+	PostID    uid.ID `json:"postId"`
+	Lang      string `json:"lang"`
+	Body      string `json:"body"`
+}
+
+// TranslateComment returns the body of the comment commentID translated to the
+// language lang (a BCP 47 language tag). Translations are cached until the
+// comment is edited (or deleted), so t is only asked to translate each version
+// of the comment to each language once.
+func TranslateComment(ctx context.Context, db *sql.DB, t translate.Translator, commentID uid.ID, lang string) (*CommentTranslation, error) {
+	lang, err := translate.NormalizeLanguage(lang)
+	if err != nil {
+		return nil, httperr.NewBadRequest("invalid-language", "Invalid language.")
+	}
+	comment, err := GetComment(ctx, db, commentID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if comment.Deleted() {
+		return nil, httperr.NewBadRequest("comment-deleted", "Cannot translate a deleted comment.")
+	}
+
+	var version int64
+	if comment.EditedAt.Valid {
+		version = comment.EditedAt.Time.Unix()
+	}
+	tr := &CommentTranslation{CommentID: commentID, PostID: comment.PostID, Lang: lang}
+	row := db.QueryRowContext(ctx, "SELECT body FROM comment_translations WHERE comment_id = ? AND lang = ? AND source_version = ?", commentID, lang, version)
+	if err := row.Scan(&tr.Body); err == nil {
+		return tr, nil
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	body, err := t.Translate(ctx, comment.Body, lang)
+	if err != nil {
+		if errors.Is(err, translate.ErrUnsupportedLanguage) {
+			return nil, httperr.NewBadRequest("unsupported-language", "Translating to this language is not supported.")
+		}
+		return nil, err
+	}
+	tr.Body = utils.TruncateUnicodeString(body, 2*maxCommentBodyLength)
+
+	query := "INSERT INTO comment_translations (comment_id, lang, source_version, body, created_at) VALUES (?, ?, ?, ?, ?) " +
+		msql.UpsertClause([]string{"comment_id", "lang"}, "source_version", "body", "created_at")
+	if _, err := db.ExecContext(ctx, query, commentID, lang, version, tr.Body, time.Now()); err != nil {
+		logger.ErrorContext(ctx, "Failed to cache comment translation", "comment", commentID, "lang", lang, "err", err)
+		// Continue on failure.
+	}
+	return tr, nil
+}
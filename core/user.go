@@ -5,12 +5,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/i18n"
 	"github.com/discuitnet/discuit/internal/images"
 	msql "github.com/discuitnet/discuit/internal/sql"
 	"github.com/discuitnet/discuit/internal/uid"
@@ -110,6 +110,37 @@ type User struct {
 	EmbedsOff               bool     `json:"embedsOff"`
 	HideUserProfilePictures bool     `json:"hideUserProfilePictures"`
 
+	// The privacy options of the user's profile (see CheckProfileVisible and
+	// GetUserPoints).
+	HideVoteHistory          bool `json:"hideVoteHistory"`
+	HideCommunityMemberships bool `json:"hideCommunityMemberships"`
+	HideOnlineStatus         bool `json:"hideOnlineStatus"`
+	ProfileLoggedInOnly      bool `json:"profileLoggedInOnly"`
+
+	// Whether posts are hidden from the user's feeds as soon as the user
+	// votes on them, or reports them (see HidePost).
+	AutoHideVotedPosts    bool `json:"autoHideVotedPosts"`
+	AutoHideReportedPosts bool `json:"autoHideReportedPosts"`
+
+	// Locale is the locale (like fr or pt-BR) of the text the server produces
+	// for the user, like emails. If empty, that of the user's browser is used.
+	Locale string `json:"locale"`
+
+	// TimeZone is the time zone (like Europe/Paris) of the times in the text
+	// the server produces for the user. If empty, UTC is used.
+	TimeZone string `json:"timeZone"`
+
+	// QuietHoursStart and QuietHoursEnd are the start and end (as 15:04, in
+	// the user's time zone) of the user's quiet hours, during which push
+	// notifications are deferred until they end. Both are empty if the user
+	// has none.
+	QuietHoursStart string `json:"quietHoursStart"`
+	QuietHoursEnd   string `json:"quietHoursEnd"`
+
+	// If PasswordLoginDisabled is true, the user may only log in with an
+	// external identity or a passkey.
+	PasswordLoginDisabled bool `json:"passwordLoginDisabled"`
+
 	// No banned users are supposed to be logged in. Make sure to log them out
 	// before banning.
 	BannedAt msql.NullTime `json:"bannedAt"`
@@ -117,6 +148,11 @@ type User struct {
 
 	MutedByViewer bool `json:"-"`
 
+	// If the user is the shadow user of a remote (ActivityPub) actor, Remote
+	// is the actor. Such users can't log in; they only exist so that remote
+	// replies and votes can be attributed to someone.
+	Remote *RemoteActor `json:"remote,omitempty"`
+
 	NumNewNotifications int `json:"notificationsNewCount"`
 
 	// The list of communities the user moderates.
@@ -208,10 +244,28 @@ func buildSelectUserQuery(where string) string {
 		"users.remember_feed_sort",
 		"users.embeds_off",
 		"users.hide_user_profile_pictures",
+		"users.hide_vote_history",
+		"users.hide_community_memberships",
+		"users.hide_online_status",
+		"users.profile_logged_in_only",
+		"users.auto_hide_voted_posts",
+		"users.auto_hide_reported_posts",
+		"users.locale",
+		"users.time_zone",
+		"users.quiet_hours_start",
+		"users.quiet_hours_end",
+		"users.password_login_disabled",
 	}
 	cols = append(cols, images.ImageColumns("pro_pic")...)
+	cols = append(cols,
+		"activitypub_actors.actor_id",
+		"activitypub_actors.handle",
+		"activitypub_actors.domain",
+		"activitypub_actors.url",
+	)
 	joins := []string{
 		"LEFT JOIN images AS pro_pic ON pro_pic.id = users.pro_pic",
+		"LEFT JOIN activitypub_actors ON activitypub_actors.user_id = users.id",
 	}
 	return msql.BuildSelectQuery("users", cols, joins, where)
 }
@@ -307,11 +361,25 @@ func scanUsers(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *uid.ID)
 			&u.RememberFeedSort,
 			&u.EmbedsOff,
 			&u.HideUserProfilePictures,
+			&u.HideVoteHistory,
+			&u.HideCommunityMemberships,
+			&u.HideOnlineStatus,
+			&u.ProfileLoggedInOnly,
+			&u.AutoHideVotedPosts,
+			&u.AutoHideReportedPosts,
+			&u.Locale,
+			&u.TimeZone,
+			&u.QuietHoursStart,
+			&u.QuietHoursEnd,
+			&u.PasswordLoginDisabled,
 		}
 
 		proPic := &images.Image{}
 		dests = append(dests, proPic.ScanDestinations()...)
 
+		var remoteID, remoteHandle, remoteDomain, remoteURL msql.NullString
+		dests = append(dests, &remoteID, &remoteHandle, &remoteDomain, &remoteURL)
+
 		if err := rows.Scan(dests...); err != nil {
 			return nil, err
 		}
@@ -323,6 +391,14 @@ func scanUsers(ctx context.Context, db *sql.DB, rows *sql.Rows, viewer *uid.ID)
 			setCommunityProPicCopies(proPic)
 			u.ProPic = proPic
 		}
+		if remoteID.Valid {
+			u.Remote = &RemoteActor{
+				ActorID: remoteID.String,
+				Handle:  remoteHandle.String,
+				Domain:  remoteDomain.String,
+				URL:     remoteURL.String,
+			}
+		}
 		if viewer != nil && *viewer == u.ID {
 			if u.Email.Valid {
 				u.EmailPublic = new(string)
@@ -367,16 +443,18 @@ func RegisterUser(ctx context.Context, db *sql.DB, username, email, password str
 	if exists, _, err := usernameExists(ctx, db, username); err != nil {
 		return nil, err
 	} else if exists {
+		message := fmt.Sprintf("A user with username %s already exists.", username)
 		return nil, &httperr.Error{
 			HTTPStatus: http.StatusConflict,
 			Code:       "user_exists",
-			Message:    fmt.Sprintf("A user with username %s already exists.", username),
+			Message:    message,
+			Fields:     []httperr.FieldError{{Field: "username", Code: "user_exists", Message: message}},
 		}
 	}
 
 	// Check if username is valid.
 	if err := IsUsernameValid(username); err != nil {
-		return nil, httperr.NewBadRequest("invalid-username", fmt.Sprintf("Username %v.", err))
+		return nil, httperr.NewInvalidField("username", "invalid-username", fmt.Sprintf("Username %v.", err))
 	}
 
 	hash, err := HashPassword([]byte(password))
@@ -406,10 +484,16 @@ func RegisterUser(ctx context.Context, db *sql.DB, username, email, password str
 	}
 
 	if err := addUserToDefaultCommunities(ctx, db, id); err != nil {
-		log.Println("Failed to add user to default communities: ", err)
+		logger.ErrorContext(ctx, "Failed to add user to default communities", "err", err, "user", id)
 		// Continue on failure.
 	}
-	return GetUser(ctx, db, id, nil)
+
+	user, err := GetUser(ctx, db, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	runUserRegisteredHooks(ctx, db, user)
+	return user, nil
 }
 
 func addUserToDefaultCommunities(ctx context.Context, db *sql.DB, user uid.ID) error {
@@ -523,9 +607,27 @@ func incrementUserPoints(ctx context.Context, db *sql.DB, user uid.ID, amount in
 	return err
 }
 
+// Location returns the location of the user's time zone (see TimeZone).
+func (u *User) Location() *time.Location {
+	return timeZoneLocation(u.TimeZone)
+}
+
 // Update updates the user's updatable fields.
 func (u *User) Update(ctx context.Context) error {
 	u.About.String = utils.TruncateUnicodeString(u.About.String, maxUserProfileAboutLength)
+	if u.Locale != "" {
+		locale, err := i18n.NormalizeLocale(u.Locale)
+		if err != nil {
+			return httperr.NewInvalidField("locale", "invalid-locale", "Invalid locale.")
+		}
+		u.Locale = locale
+	}
+	if err := checkTimeZone("timeZone", u.TimeZone); err != nil {
+		return err
+	}
+	if err := checkQuietHours(u.QuietHoursStart, u.QuietHoursEnd); err != nil {
+		return err
+	}
 	_, err := u.db.ExecContext(ctx, `
 	UPDATE users SET
 		email = ?, 
@@ -535,7 +637,17 @@ func (u *User) Update(ctx context.Context) error {
 		home_feed = ?,
 		remember_feed_sort = ?,
 		embeds_off = ?,
-		hide_user_profile_pictures = ?
+		hide_user_profile_pictures = ?,
+		hide_vote_history = ?,
+		hide_community_memberships = ?,
+		hide_online_status = ?,
+		profile_logged_in_only = ?,
+		auto_hide_voted_posts = ?,
+		auto_hide_reported_posts = ?,
+		locale = ?,
+		time_zone = ?,
+		quiet_hours_start = ?,
+		quiet_hours_end = ?
 	WHERE id = ?`,
 		u.EmailPublic,
 		u.About,
@@ -545,6 +657,16 @@ func (u *User) Update(ctx context.Context) error {
 		u.RememberFeedSort,
 		u.EmbedsOff,
 		u.HideUserProfilePictures,
+		u.HideVoteHistory,
+		u.HideCommunityMemberships,
+		u.HideOnlineStatus,
+		u.ProfileLoggedInOnly,
+		u.AutoHideVotedPosts,
+		u.AutoHideReportedPosts,
+		u.Locale,
+		u.TimeZone,
+		u.QuietHoursStart,
+		u.QuietHoursEnd,
 		u.ID)
 	return err
 }
@@ -565,6 +687,18 @@ func (u *User) Delete(ctx context.Context) error {
 		if _, err := tx.ExecContext(ctx, "DELETE FROM notifications WHERE user_id = ?", u.ID); err != nil {
 			return err
 		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM api_tokens WHERE user_id = ?", u.ID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM passkeys WHERE user_id = ?", u.ID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM external_identities WHERE user_id = ?", u.ID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM directory_memberships WHERE user_id = ?", u.ID); err != nil {
+			return err
+		}
 		u.DeletedAt = msql.NewNullTime(now)
 		u.NumNewNotifications = 0
 		return nil
@@ -608,7 +742,13 @@ func (u *User) ChangePassword(ctx context.Context, previousPass, newPass string)
 	if _, err := MatchLoginCredentials(ctx, u.db, u.Username, previousPass); err != nil {
 		return err
 	}
-	hash, err := HashPassword([]byte(newPass))
+	return u.SetPassword(ctx, newPass)
+}
+
+// SetPassword sets the password of the user to password, without checking the
+// current one. Log out all sessions of the user after calling this function.
+func (u *User) SetPassword(ctx context.Context, password string) error {
+	hash, err := HashPassword([]byte(password))
 	if err != nil {
 		return err
 	}
@@ -719,28 +859,49 @@ func (u *User) DeleteProPic(ctx context.Context) error {
 	})
 }
 
-func (u *User) UpdateProPic(ctx context.Context, image []byte) error {
+// UpdateProPic replaces the profile picture of the user with image, cropped to
+// crop (if it's non-nil) and then scaled down to a standard size.
+// CheckProfileVisible returns an error if the profile of u, and the posts and
+// comments listed on it, may not be seen by viewer (nil if logged out).
+func (u *User) CheckProfileVisible(viewer *uid.ID) error {
+	if u.ProfileLoggedInOnly && viewer == nil {
+		return errProfileLoggedInOnly
+	}
+	return nil
+}
+
+// hidesFrom reports whether the parts of the profile of u that u chose to hide
+// are hidden from viewer (anyone but u).
+func (u *User) hidesFrom(viewer *uid.ID) bool {
+	return viewer == nil || *viewer != u.ID
+}
+
+func (u *User) UpdateProPic(ctx context.Context, image []byte, crop *images.CropRect) error {
 	var newImageID uid.ID
 	err := msql.Transact(ctx, u.db, func(tx *sql.Tx) error {
 		if err := u.DeleteProPicTx(ctx, tx); err != nil {
 			return err
 		}
-		imageID, err := images.SaveImageTx(ctx, tx, "disk", image, &images.ImageOptions{
-			Width:  2000,
-			Height: 2000,
+		imageID, err := images.SaveImageTx(ctx, tx, images.DefaultStore, image, &images.ImageOptions{
+			Width:  proPicSize,
+			Height: proPicSize,
 			Format: images.ImageFormatJPEG,
-			Fit:    images.ImageFitContain,
+			Fit:    images.ImageFitCover,
+			Crop:   crop,
 		})
 		if err != nil {
-			return fmt.Errorf("fail to save user pro pic: %w", err)
+			return saveImageError("fail to save user pro pic", err)
 		}
 		if _, err := tx.ExecContext(ctx, "UPDATE users SET pro_pic = ? WHERE id = ?", imageID, u.ID); err != nil {
 			// Attempt to delete the image
 			if err := images.DeleteImageTx(ctx, tx, u.db, imageID); err != nil {
-				log.Printf("failed to delete image (core.User.UpdateProPic): %v\n", err)
+				logger.ErrorContext(ctx, "Failed to delete image", "err", err, "image", imageID)
 			}
 			return fmt.Errorf("failed to set users.pro_pic to value: %w", err)
 		}
+		if err := addImageUploadTx(ctx, tx, imageID, &u.ID, nil); err != nil {
+			return err
+		}
 		newImageID = imageID
 		return nil
 	})
@@ -782,7 +943,7 @@ func (u *User) AddBadge(ctx context.Context, badgeType string) error {
 	}
 
 	if err := CreateNewBadgeNotification(ctx, u.db, u.ID, badgeType); err != nil {
-		log.Printf("Error creating new badge notification: %v\n", err)
+		notifLogger.ErrorContext(ctx, "Failed to create new_badge notification", "err", err, "user", u.ID)
 	}
 
 	return fetchBadges(u.db, u)
@@ -829,7 +990,7 @@ func fetchBadges(db *sql.DB, users ...*User) error {
 		return nil
 	}
 	if len(users) > 1000 {
-		log.Printf("Warning: fetching more than %d users badges at once\n", len(users))
+		logger.Warn("Fetching the badges of too many users at once", "count", len(users))
 	}
 
 	userIDs := make([]any, len(users))
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// maxUserPointsCommunities is the maximum number of communities in the
+// breakdown of the points of a user (those in which the user has the most
+// points).
+const maxUserPointsCommunities = 50
+
+// UserPoints is the breakdown of the points of a user.
+type UserPoints struct {
+	Points        int `json:"points"` // As in User.Points.
+	PostPoints    int `json:"postPoints"`
+	CommentPoints int `json:"commentPoints"`
+
+	// The communities in which the user has the most points, in descending
+	// order of points.
+	Communities []*CommunityUserPoints `json:"communities"`
+}
+
+// CommunityUserPoints are the points of a user in a community.
+type CommunityUserPoints struct {
+	CommunityID   uid.ID `json:"communityId"`
+	CommunityName string `json:"communityName"`
+	Points        int    `json:"points"`
+	PostPoints    int    `json:"postPoints"`
+	CommentPoints int    `json:"commentPoints"`
+}
+
+// addUserPoints adds amount to the points of user, and to those of the posts
+// (if isPost), or of the comments, of user in community.
+func addUserPoints(ctx context.Context, db *sql.DB, user, community uid.ID, isPost bool, amount int) error {
+	if err := incrementUserPoints(ctx, db, user, amount); err != nil {
+		return err
+	}
+	col, postPoints, commentPoints := "comment_points", 0, amount
+	if isPost {
+		col, postPoints, commentPoints = "post_points", amount, 0
+	}
+	_, err := db.ExecContext(ctx, "INSERT INTO user_points (user_id, community_id, post_points, comment_points) VALUES (?, ?, ?, ?) "+
+		msql.OnConflictUpdate("user_id", "community_id")+col+" = "+col+" + ?", user, community, postPoints, commentPoints, amount)
+	return err
+}
+
+// GetUserPoints returns the breakdown of the points of user, as seen by viewer.
+// Only the total is shown to others if user hides their vote history, and the
+// communities are left out if user hides their community memberships.
+func GetUserPoints(ctx context.Context, db *sql.DB, user *User, viewer *uid.ID) (*UserPoints, error) {
+	if user.hidesFrom(viewer) && user.HideVoteHistory {
+		return &UserPoints{Points: user.Points, Communities: []*CommunityUserPoints{}}, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT user_points.community_id, communities.name, user_points.post_points, user_points.comment_points
+		FROM user_points
+		INNER JOIN communities ON communities.id = user_points.community_id
+		WHERE user_points.user_id = ? AND communities.deleted_at IS NULL`, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := &UserPoints{Points: user.Points, Communities: []*CommunityUserPoints{}}
+	for rows.Next() {
+		c := &CommunityUserPoints{}
+		if err := rows.Scan(&c.CommunityID, &c.CommunityName, &c.PostPoints, &c.CommentPoints); err != nil {
+			return nil, err
+		}
+		c.Points = c.PostPoints + c.CommentPoints
+		points.PostPoints += c.PostPoints
+		points.CommentPoints += c.CommentPoints
+		if c.Points != 0 {
+			points.Communities = append(points.Communities, c)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if user.hidesFrom(viewer) && user.HideCommunityMemberships {
+		points.Communities = []*CommunityUserPoints{}
+	}
+	sort.SliceStable(points.Communities, func(i, j int) bool {
+		return points.Communities[i].Points > points.Communities[j].Points
+	})
+	if len(points.Communities) > maxUserPointsCommunities {
+		points.Communities = points.Communities[:maxUserPointsCommunities]
+	}
+	return points, nil
+}
@@ -0,0 +1,467 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/images"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/discuitnet/discuit/internal/videos"
+)
+
+var (
+	errVideoNotFound      = httperr.NewNotFound("video/not-found", "Video not found.")
+	errVideoFailed        = httperr.NewBadRequest("video/failed", "Video could not be processed.")
+	errVideoPostsDisabled = httperr.NewForbidden("video-posts/disabled", "Video posts are not allowed in this community.")
+)
+
+// VideoStatus is the processing status of an uploaded video.
+type VideoStatus int
+
+// These are all the valid VideoStatus values.
+const (
+	VideoStatusPending    = VideoStatus(iota) // Waiting to be transcoded.
+	VideoStatusProcessing                     // Being transcoded.
+	VideoStatusReady
+	VideoStatusFailed
+)
+
+// MarshalText implements encoding.TextMarshaler interface.
+func (s VideoStatus) MarshalText() ([]byte, error) {
+	switch s {
+	case VideoStatusPending:
+		return []byte("pending"), nil
+	case VideoStatusProcessing:
+		return []byte("processing"), nil
+	case VideoStatusReady:
+		return []byte("ready"), nil
+	case VideoStatusFailed:
+		return []byte("failed"), nil
+	}
+	return nil, fmt.Errorf("invalid video status %d", int(s))
+}
+
+// Video is an uploaded video.
+//
+// Table name: videos.
+type Video struct {
+	ID     uid.ID      `json:"id"`
+	UserID uid.ID      `json:"userId"`
+	Status VideoStatus `json:"status"`
+
+	Duration float64 `json:"duration"` // In seconds.
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Size     int     `json:"size"` // Of the MP4 file (zero until the video is ready).
+
+	Poster *images.Image `json:"poster"`
+
+	// URLs of the transcoded MP4 file and of the HLS playlist (set only once
+	// the video is ready).
+	URL    string `json:"url,omitempty"`
+	HLSURL string `json:"hlsUrl,omitempty"`
+
+	CreatedAt   time.Time     `json:"createdAt"`
+	ProcessedAt msql.NullTime `json:"processedAt"`
+
+	durationMS int
+	hasHLS     bool
+}
+
+func buildSelectVideoQuery(where string) string {
+	cols := []string{
+		"videos.id",
+		"videos.user_id",
+		"videos.status",
+		"videos.duration",
+		"videos.width",
+		"videos.height",
+		"videos.size",
+		"videos.has_hls",
+		"videos.created_at",
+		"videos.processed_at",
+	}
+	cols = append(cols, images.ImageColumns("poster")...)
+	return msql.BuildSelectQuery("videos", cols, []string{"LEFT JOIN images AS poster ON poster.id = videos.poster_image"}, where)
+}
+
+// scanDestinations returns the scan destinations of the columns of
+// buildSelectVideoQuery.
+func (v *Video) scanDestinations() []any {
+	v.Poster = &images.Image{}
+	dests := []any{
+		&v.ID,
+		&v.UserID,
+		&v.Status,
+		&v.durationMS,
+		&v.Width,
+		&v.Height,
+		&v.Size,
+		&v.hasHLS,
+		&v.CreatedAt,
+		&v.ProcessedAt,
+	}
+	return append(dests, v.Poster.ScanDestinations()...)
+}
+
+// postScan sets the fields of v that are derived from database values.
+func (v *Video) postScan() {
+	v.Duration = float64(v.durationMS) / 1000
+	if v.Poster.ID == nil {
+		v.Poster = nil
+	} else {
+		v.Poster.PostScan()
+		v.Poster.AppendCopy("small", 325, 250, images.ImageFitCover, "")
+		v.Poster.AppendCopy("medium", 720, 1440, images.ImageFitContain, "")
+	}
+	if v.Status == VideoStatusReady {
+		v.URL = videos.URL(v.ID, videos.MP4File)
+		if v.hasHLS {
+			v.HLSURL = videos.URL(v.ID, videos.HLSPlaylist)
+		}
+	}
+}
+
+func getVideos(ctx context.Context, db *sql.DB, where string, args ...any) ([]*Video, error) {
+	rows, err := db.QueryContext(ctx, buildSelectVideoQuery(where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vids []*Video
+	for rows.Next() {
+		v := &Video{}
+		if err := rows.Scan(v.scanDestinations()...); err != nil {
+			return nil, err
+		}
+		v.postScan()
+		vids = append(vids, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return vids, nil
+}
+
+// GetVideo returns a not-found httperr.Error if no video is found.
+func GetVideo(ctx context.Context, db *sql.DB, id uid.ID) (*Video, error) {
+	vids, err := getVideos(ctx, db, "WHERE videos.id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(vids) == 0 {
+		return nil, errVideoNotFound
+	}
+	return vids[0], nil
+}
+
+// SaveVideo saves the video file read from r, uploaded by author, and queues
+// it to be transcoded (by ProcessVideos). Videos longer than maxDuration are
+// rejected.
+func SaveVideo(ctx context.Context, db *sql.DB, author uid.ID, r io.Reader, maxDuration time.Duration) (*Video, error) {
+	id := uid.New()
+	dir := videos.Folder(id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	removeFiles := func() {
+		if err := videos.Remove(id); err != nil {
+			logger.WarnContext(ctx, "Failed to remove video files", "video", id, "err", err)
+		}
+	}
+
+	original := filepath.Join(dir, videos.OriginalFile)
+	f, err := os.Create(original)
+	if err != nil {
+		removeFiles()
+		return nil, err
+	}
+	size, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		removeFiles()
+		return nil, err
+	}
+
+	info, err := videos.Probe(ctx, original)
+	if err != nil {
+		removeFiles()
+		logger.InfoContext(ctx, "Rejected an unreadable video upload", "user", author, "err", err)
+		return nil, httperr.NewBadRequest("video/invalid", "File is not a supported video.")
+	}
+	if maxDuration > 0 && info.Duration > maxDuration {
+		removeFiles()
+		return nil, httperr.NewBadRequest("video/too-long", fmt.Sprintf("Video is longer than %v.", maxDuration))
+	}
+
+	query, args := msql.BuildInsertQuery("videos", []msql.ColumnValue{
+		{Name: "id", Value: id},
+		{Name: "user_id", Value: author},
+		{Name: "status", Value: VideoStatusPending},
+		{Name: "duration", Value: info.Duration.Milliseconds()},
+		{Name: "width", Value: info.Width},
+		{Name: "height", Value: info.Height},
+		{Name: "upload_size", Value: size},
+	})
+	if err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+		return addMediaUploadTx(ctx, tx, id, mediaTypeVideo, &author, nil, size)
+	}); err != nil {
+		removeFiles()
+		return nil, err
+	}
+	return GetVideo(ctx, db, id)
+}
+
+// ProcessVideos transcodes, with t, all videos that are waiting to be
+// transcoded, and it returns the number of videos processed (including those
+// that failed to be transcoded).
+func ProcessVideos(ctx context.Context, db *sql.DB, t videos.Transcoder) (int, error) {
+	n := 0
+	for {
+		var id uid.ID
+		row := db.QueryRowContext(ctx, "SELECT id FROM videos WHERE status = ? ORDER BY created_at LIMIT 1", VideoStatusPending)
+		if err := row.Scan(&id); err != nil {
+			if err == sql.ErrNoRows {
+				return n, nil
+			}
+			return n, err
+		}
+
+		// Claim the video, in case more than one process is transcoding
+		// videos.
+		res, err := db.ExecContext(ctx, "UPDATE videos SET status = ? WHERE id = ? AND status = ?", VideoStatusProcessing, id, VideoStatusPending)
+		if err != nil {
+			return n, err
+		}
+		if claimed, err := res.RowsAffected(); err != nil {
+			return n, err
+		} else if claimed == 0 {
+			continue
+		}
+
+		if err := processVideo(ctx, db, t, id); err != nil {
+			if ctx.Err() != nil {
+				// Shutting down. Leave the video for the next run.
+				if _, err := db.ExecContext(context.Background(), "UPDATE videos SET status = ? WHERE id = ?", VideoStatusPending, id); err != nil {
+					return n, err
+				}
+				return n, ctx.Err()
+			}
+			logger.ErrorContext(ctx, "Failed to transcode video", "video", id, "err", err)
+			if _, err := db.ExecContext(ctx, "UPDATE videos SET status = ?, error = ?, processed_at = ? WHERE id = ?",
+				VideoStatusFailed, err.Error(), time.Now(), id); err != nil {
+				return n, err
+			}
+		}
+		n++
+	}
+}
+
+func processVideo(ctx context.Context, db *sql.DB, t videos.Transcoder, id uid.ID) error {
+	dir := videos.Folder(id)
+	original := filepath.Join(dir, videos.OriginalFile)
+	if err := t.Transcode(ctx, original, dir); err != nil {
+		return err
+	}
+
+	mp4 := filepath.Join(dir, videos.MP4File)
+	info, err := videos.Probe(ctx, mp4)
+	if err != nil {
+		return err
+	}
+	stat, err := os.Stat(mp4)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(filepath.Join(dir, videos.HLSPlaylist))
+	hasHLS := err == nil
+
+	poster, err := os.ReadFile(filepath.Join(dir, videos.PosterFile))
+	if err != nil {
+		return err
+	}
+	posterRecord, err := images.SaveImage(ctx, db, images.DefaultStore, poster, &images.ImageOptions{
+		Width:  1920,
+		Height: 1920,
+		Format: images.ImageFormatJPEG,
+		Fit:    images.ImageFitContain,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save poster of video %v: %w", id, err)
+	}
+
+	query := `
+		UPDATE videos SET
+			status = ?,
+			duration = ?,
+			width = ?,
+			height = ?,
+			size = ?,
+			has_hls = ?,
+			poster_image = ?,
+			processed_at = ?
+		WHERE id = ?`
+	if _, err := db.ExecContext(ctx, query, VideoStatusReady, info.Duration.Milliseconds(), info.Width, info.Height,
+		stat.Size(), hasHLS, posterRecord.ID, time.Now(), id); err != nil {
+		return err
+	}
+
+	// The original and the poster (now an image) are no longer needed.
+	for _, file := range []string{videos.OriginalFile, videos.PosterFile} {
+		if err := os.Remove(filepath.Join(dir, file)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.WarnContext(ctx, "Failed to remove video file", "video", id, "file", file, "err", err)
+		}
+	}
+
+	// What's stored is now the transcoded files (and the poster), rather
+	// than the upload.
+	size := int64(posterRecord.Size)
+	err = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err == nil {
+			size += info.Size()
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "UPDATE media_uploads SET size = ? WHERE media_id = ?", size, id)
+	return err
+}
+
+// deleteVideoTx deletes the video, its poster, and its files.
+func deleteVideoTx(ctx context.Context, tx *sql.Tx, db *sql.DB, v *Video) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM videos WHERE id = ?", v.ID); err != nil {
+		return err
+	}
+	if v.Poster != nil {
+		if err := images.DeleteImageTx(ctx, tx, db, *v.Poster.ID); err != nil {
+			return err
+		}
+	}
+	return videos.Remove(v.ID)
+}
+
+// RemoveTempVideos removes all videos older than 12 hours that are not part
+// of any post (nor of a held post) and returns how many were removed.
+func RemoveTempVideos(ctx context.Context, db *sql.DB) (int, error) {
+	t := time.Now().Add(-time.Hour * 12)
+	vids, err := getVideos(ctx, db, `WHERE videos.created_at < ? AND videos.status <> ?
+		AND NOT EXISTS (SELECT 1 FROM post_videos WHERE post_videos.video_id = videos.id)
+		AND NOT EXISTS (SELECT 1 FROM held_posts WHERE held_posts.video_id = videos.id)`,
+		t, VideoStatusProcessing)
+	if err != nil {
+		return 0, err
+	}
+	for i, v := range vids {
+		if err := msql.Transact(ctx, db, func(tx *sql.Tx) error {
+			return deleteVideoTx(ctx, tx, db, v)
+		}); err != nil {
+			return i, err
+		}
+	}
+	return len(vids), nil
+}
+
+// populatePostsVideos sets posts[i].Video of the video posts in posts (except
+// for content deleted posts).
+func populatePostsVideos(ctx context.Context, db *sql.DB, posts []*Post) error {
+	var videoPosts []*Post
+	for _, post := range posts {
+		if post.Type == PostTypeVideo && !post.DeletedContent {
+			videoPosts = append(videoPosts, post)
+		}
+	}
+	if len(videoPosts) == 0 {
+		return nil
+	}
+
+	args := make([]any, len(videoPosts))
+	for i := range videoPosts {
+		args[i] = videoPosts[i].ID
+	}
+	rows, err := db.QueryContext(ctx, "SELECT post_id, video_id FROM post_videos WHERE post_id IN "+msql.InClauseQuestionMarks(len(args)), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	postVideos := make(map[uid.ID]uid.ID)
+	var videoIDs []any
+	for rows.Next() {
+		var postID, videoID uid.ID
+		if err := rows.Scan(&postID, &videoID); err != nil {
+			return err
+		}
+		postVideos[postID] = videoID
+		videoIDs = append(videoIDs, videoID)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(videoIDs) == 0 {
+		return nil
+	}
+
+	vids, err := getVideos(ctx, db, "WHERE videos.id IN "+msql.InClauseQuestionMarks(len(videoIDs)), videoIDs...)
+	if err != nil {
+		return err
+	}
+	for _, post := range videoPosts {
+		for _, v := range vids {
+			if v.ID == postVideos[post.ID] {
+				post.Video = v
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func CreateVideoPost(ctx context.Context, db *sql.DB, author, community uid.ID, title string, videoID uid.ID) (*Post, error) {
+	video, err := GetVideo(ctx, db, videoID)
+	if err != nil {
+		return nil, err
+	}
+	if video.UserID != author {
+		return nil, errVideoNotFound
+	}
+	if video.Status == VideoStatusFailed {
+		return nil, errVideoFailed
+	}
+
+	comm, err := GetCommunityByID(ctx, db, community, nil)
+	if err != nil {
+		return nil, err
+	}
+	if comm.DisableVideoPosts {
+		return nil, errVideoPostsDisabled
+	}
+
+	return createPost(ctx, db, &createPostOpts{
+		postType:  PostTypeVideo,
+		author:    author,
+		community: community,
+		title:     title,
+		video:     videoID,
+	})
+}
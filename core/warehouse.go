@@ -0,0 +1,332 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// A warehouse export is a set of gzipped CSV files, one for each table below,
+// of the public content and the aggregate metrics of a day (UTC), for loading
+// into a data warehouse. Exports are incremental: posts and comments are those
+// created or edited in the day (with their counts as of the export), and those
+// deleted in the day (along with deleted communities) are listed in
+// deletions.csv.gz. Nothing private (emails, IP addresses, votes of users,
+// and the like) is exported.
+//
+// The files of the export of a day are saved under a folder named after the
+// day (ex: "2024-03-15/posts.csv.gz"). The last file saved is manifest.json,
+// which lists the files and their number of rows, and so an export is
+// complete if its manifest exists.
+
+// WarehouseManifest is the last file of a warehouse export.
+type WarehouseManifest struct {
+	Day        string         `json:"day"` // YYYY-MM-DD
+	ExportedAt time.Time      `json:"exportedAt"`
+	Files      map[string]int `json:"files"` // Number of rows of each file.
+}
+
+// warehouseTable is a file of a warehouse export.
+type warehouseTable struct {
+	name    string
+	columns []string
+
+	// The query selecting the rows, and its arguments for the day from start
+	// to end.
+	query string
+	args  func(start, end time.Time) []any
+
+	// scan scans a row of the query into the values of the columns.
+	scan func(rows *sql.Rows) ([]string, error)
+}
+
+// dayArgs returns the arguments of a query that checks n times whether a time
+// is within the day from start to end.
+func dayArgs(n int) func(start, end time.Time) []any {
+	return func(start, end time.Time) []any {
+		var args []any
+		for i := 0; i < n; i++ {
+			args = append(args, start, end)
+		}
+		return args
+	}
+}
+
+func csvTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func csvNullTime(t msql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return csvTime(t.Time)
+}
+
+var warehouseTables = []warehouseTable{
+	{
+		name:    "communities",
+		columns: []string{"id", "name", "nsfw", "members", "created_at"},
+		query:   "SELECT id, name, nsfw, no_members, created_at FROM communities WHERE deleted_at IS NULL AND created_at < ?",
+		args: func(start, end time.Time) []any {
+			return []any{end} // All communities, as of the end of the day.
+		},
+		scan: func(rows *sql.Rows) ([]string, error) {
+			var (
+				id        uid.ID
+				name      string
+				nsfw      bool
+				members   int
+				createdAt time.Time
+			)
+			if err := rows.Scan(&id, &name, &nsfw, &members, &createdAt); err != nil {
+				return nil, err
+			}
+			return []string{id.String(), name, strconv.FormatBool(nsfw), strconv.Itoa(members), csvTime(createdAt)}, nil
+		},
+	},
+	{
+		name: "posts",
+		columns: []string{"id", "public_id", "type", "community_id", "user_id", "username", "title", "body", "link_domain",
+			"upvotes", "downvotes", "points", "comments", "locked", "created_at", "edited_at"},
+		query: `
+			SELECT posts.id, posts.public_id, posts.type, posts.community_id, posts.user_id, users.username, users.deleted_at,
+				posts.title, posts.body, posts.link_domain, posts.upvotes, posts.downvotes, posts.points, posts.no_comments,
+				posts.locked, posts.created_at, posts.edited_at
+			FROM posts
+			INNER JOIN users ON users.id = posts.user_id
+			INNER JOIN communities ON communities.id = posts.community_id
+			WHERE posts.deleted = FALSE AND communities.deleted_at IS NULL
+				AND ((posts.created_at >= ? AND posts.created_at < ?) OR (posts.edited_at >= ? AND posts.edited_at < ?))`,
+		args: dayArgs(2),
+		scan: func(rows *sql.Rows) ([]string, error) {
+			var (
+				id, community, user        uid.ID
+				publicID, username, title  string
+				postType                   PostType
+				userDeletedAt, editedAt    msql.NullTime
+				body, linkDomain           msql.NullString
+				upvotes, downvotes, points int
+				comments                   int
+				locked                     bool
+				createdAt                  time.Time
+			)
+			if err := rows.Scan(&id, &publicID, &postType, &community, &user, &username, &userDeletedAt,
+				&title, &body, &linkDomain, &upvotes, &downvotes, &points, &comments,
+				&locked, &createdAt, &editedAt); err != nil {
+				return nil, err
+			}
+			if userDeletedAt.Valid {
+				username = "[deleted]"
+			}
+			typ, _ := postType.MarshalText()
+			return []string{id.String(), publicID, string(typ), community.String(), user.String(), username, title, body.String, linkDomain.String,
+				strconv.Itoa(upvotes), strconv.Itoa(downvotes), strconv.Itoa(points), strconv.Itoa(comments),
+				strconv.FormatBool(locked), csvTime(createdAt), csvNullTime(editedAt)}, nil
+		},
+	},
+	{
+		name: "comments",
+		columns: []string{"id", "post_id", "community_id", "parent_id", "depth", "user_id", "username", "body",
+			"upvotes", "downvotes", "points", "replies", "created_at", "edited_at"},
+		query: `
+			SELECT comments.id, comments.post_id, comments.community_id, comments.parent_id, comments.depth,
+				comments.user_id, comments.username, comments.user_deleted, comments.body,
+				comments.upvotes, comments.downvotes, comments.points, comments.no_replies,
+				comments.created_at, comments.edited_at
+			FROM comments
+			INNER JOIN posts ON posts.id = comments.post_id
+			INNER JOIN communities ON communities.id = comments.community_id
+			WHERE comments.deleted_at IS NULL AND posts.deleted = FALSE AND communities.deleted_at IS NULL
+				AND ((comments.created_at >= ? AND comments.created_at < ?) OR (comments.edited_at >= ? AND comments.edited_at < ?))`,
+		args: dayArgs(2),
+		scan: func(rows *sql.Rows) ([]string, error) {
+			var (
+				id, post, community, user  uid.ID
+				parent                     uid.NullID
+				depth                      int
+				username                   string
+				userDeleted                bool
+				body                       msql.NullString
+				upvotes, downvotes, points int
+				replies                    int
+				createdAt                  time.Time
+				editedAt                   msql.NullTime
+			)
+			if err := rows.Scan(&id, &post, &community, &parent, &depth,
+				&user, &username, &userDeleted, &body,
+				&upvotes, &downvotes, &points, &replies,
+				&createdAt, &editedAt); err != nil {
+				return nil, err
+			}
+			if userDeleted {
+				username = "[deleted]"
+			}
+			parentID := ""
+			if parent.Valid {
+				parentID = parent.ID.String()
+			}
+			return []string{id.String(), post.String(), community.String(), parentID, strconv.Itoa(depth),
+				user.String(), username, body.String,
+				strconv.Itoa(upvotes), strconv.Itoa(downvotes), strconv.Itoa(points), strconv.Itoa(replies),
+				csvTime(createdAt), csvNullTime(editedAt)}, nil
+		},
+	},
+	{
+		name:    "deletions",
+		columns: []string{"type", "id", "deleted_at"},
+		query: `
+			SELECT 'post', id, deleted_at FROM posts WHERE deleted_at >= ? AND deleted_at < ?
+			UNION ALL
+			SELECT 'comment', id, deleted_at FROM comments WHERE deleted_at >= ? AND deleted_at < ?
+			UNION ALL
+			SELECT 'community', id, deleted_at FROM communities WHERE deleted_at >= ? AND deleted_at < ?`,
+		args: dayArgs(3),
+		scan: func(rows *sql.Rows) ([]string, error) {
+			var (
+				typ       string
+				id        uid.ID
+				deletedAt time.Time
+			)
+			if err := rows.Scan(&typ, &id, &deletedAt); err != nil {
+				return nil, err
+			}
+			return []string{typ, id.String(), csvTime(deletedAt)}, nil
+		},
+	},
+	{
+		name:    "post_stats",
+		columns: []string{"post_id", "views", "upvotes", "downvotes", "comments"},
+		query: `
+			SELECT post_id, SUM(views), SUM(upvotes), SUM(downvotes), SUM(comments)
+			FROM post_stats
+			WHERE hour >= ? AND hour < ?
+			GROUP BY post_id`,
+		args: dayArgs(1),
+		scan: func(rows *sql.Rows) ([]string, error) {
+			var (
+				post                                uid.ID
+				views, upvotes, downvotes, comments int64
+			)
+			if err := rows.Scan(&post, &views, &upvotes, &downvotes, &comments); err != nil {
+				return nil, err
+			}
+			return []string{post.String(), strconv.FormatInt(views, 10), strconv.FormatInt(upvotes, 10),
+				strconv.FormatInt(downvotes, 10), strconv.FormatInt(comments, 10)}, nil
+		},
+	},
+	{
+		name:    "metrics",
+		columns: []string{"event", "dimension", "total"},
+		query: `
+			SELECT event, dimension, SUM(total)
+			FROM analytics_rollups
+			WHERE hour >= ? AND hour < ?
+			GROUP BY event, dimension`,
+		args: dayArgs(1),
+		scan: func(rows *sql.Rows) ([]string, error) {
+			var (
+				event, dimension string
+				total            int64
+			)
+			if err := rows.Scan(&event, &dimension, &total); err != nil {
+				return nil, err
+			}
+			return []string{event, dimension, strconv.FormatInt(total, 10)}, nil
+		},
+	},
+	{
+		name:    "signup_cohorts",
+		columns: []string{"cohort", "users", "activated", "retained_7", "retained_30", "computed_at"},
+		query:   "SELECT cohort, users, activated, retained_7, retained_30, computed_at FROM signup_cohorts WHERE cohort >= ? AND cohort < ?",
+		args: func(start, end time.Time) []any {
+			// The 30-day retention of a cohort is known 31 days later.
+			return []any{start.AddDate(0, 0, -31), end.AddDate(0, 0, -31)}
+		},
+		scan: func(rows *sql.Rows) ([]string, error) {
+			c := &Cohort{}
+			if err := rows.Scan(&c.Cohort, &c.Users, &c.Activated, &c.Retained7, &c.Retained30, &c.ComputedAt); err != nil {
+				return nil, err
+			}
+			count := func(n *int) string {
+				if n == nil {
+					return ""
+				}
+				return strconv.Itoa(*n)
+			}
+			return []string{c.Cohort.UTC().Format(time.DateOnly), strconv.Itoa(c.Users), count(c.Activated),
+				count(c.Retained7), count(c.Retained30), csvTime(c.ComputedAt)}, nil
+		},
+	},
+}
+
+// ExportWarehouseDay exports the public content and the aggregate metrics of
+// day (see WarehouseManifest), calling save with the name (relative to the
+// root of the warehouse) and the contents of each file. The signup cohorts
+// exported are those whose windows ended in the day.
+func ExportWarehouseDay(ctx context.Context, db *sql.DB, day time.Time, save func(name string, data []byte) error) (*WarehouseManifest, error) {
+	day = cohortDay(day)
+	end := day.AddDate(0, 0, 1)
+	folder := day.Format(time.DateOnly)
+	manifest := &WarehouseManifest{Day: folder, Files: make(map[string]int)}
+
+	for _, table := range warehouseTables {
+		data, n, err := exportWarehouseTable(ctx, db, &table, table.args(day, end))
+		if err != nil {
+			return nil, err
+		}
+		name := folder + "/" + table.name + ".csv.gz"
+		if err := save(name, data); err != nil {
+			return nil, err
+		}
+		manifest.Files[table.name+".csv.gz"] = n
+	}
+
+	manifest.ExportedAt = time.Now()
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := save(folder+"/manifest.json", data); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// exportWarehouseTable returns the gzipped CSV file of table, and its number
+// of rows (not counting the header).
+func exportWarehouseTable(ctx context.Context, db *sql.DB, table *warehouseTable, args []any) ([]byte, int, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := csv.NewWriter(gz)
+	if err := w.Write(table.columns); err != nil {
+		return nil, 0, err
+	}
+	n := 0
+	if err := exportRows(ctx, db, table.query, func(rows *sql.Rows) error {
+		record, err := table.scan(rows)
+		if err != nil {
+			return err
+		}
+		n++
+		return w.Write(record)
+	}, args...); err != nil {
+		return nil, 0, err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), n, nil
+}
@@ -0,0 +1,246 @@
+// Package activitypub implements the parts of the ActivityPub protocol (and of
+// the protocols that ActivityPub servers rely on, such as WebFinger and HTTP
+// signatures) needed to federate with other servers.
+package activitypub
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+const (
+	// ContentType is the media type of ActivityPub documents.
+	ContentType = "application/activity+json"
+
+	// Public is the special collection that addresses an activity to
+	// everyone.
+	Public = "https://www.w3.org/ns/activitystreams#Public"
+)
+
+// Context is the JSON-LD context of the documents served.
+var Context = []any{
+	"https://www.w3.org/ns/activitystreams",
+	"https://w3id.org/security/v1",
+}
+
+// IsActivityPubRequest reports whether accept, the value of an Accept header,
+// asks for an ActivityPub document.
+func IsActivityPubRequest(accept string) bool {
+	return strings.Contains(accept, ContentType) || strings.Contains(accept, `profile="https://www.w3.org/ns/activitystreams"`)
+}
+
+// Site builds the IDs (URLs) of the objects of the site at BaseURL.
+type Site struct {
+	BaseURL string // For example, https://discuit.net (without a trailing slash).
+}
+
+// Host returns the host of s.
+func (s Site) Host() string {
+	u, err := url.Parse(s.BaseURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// CommunityActorID returns the ID of the actor of the community named name.
+func (s Site) CommunityActorID(name string) string {
+	return s.BaseURL + "/ap/c/" + url.PathEscape(name)
+}
+
+// CommunityKeyID returns the ID of the public key of the actor of the
+// community named name.
+func (s Site) CommunityKeyID(name string) string {
+	return s.CommunityActorID(name) + "#main-key"
+}
+
+// PostObjectID returns the ID of the object of the post with the public ID
+// publicID.
+func (s Site) PostObjectID(publicID string) string {
+	return s.BaseURL + "/ap/p/" + url.PathEscape(publicID)
+}
+
+// PublicKey is the public key of an actor.
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPEM string `json:"publicKeyPem"`
+}
+
+// Endpoints holds the endpoints of an actor.
+type Endpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+// Actor is an actor document.
+type Actor struct {
+	Context           any        `json:"@context,omitempty"`
+	ID                string     `json:"id"`
+	Type              string     `json:"type"`
+	PreferredUsername string     `json:"preferredUsername,omitempty"`
+	Name              string     `json:"name,omitempty"`
+	Summary           string     `json:"summary,omitempty"`
+	URL               string     `json:"url,omitempty"`
+	Icon              *Image     `json:"icon,omitempty"`
+	Image             *Image     `json:"image,omitempty"`
+	Inbox             string     `json:"inbox"`
+	Outbox            string     `json:"outbox,omitempty"`
+	Followers         string     `json:"followers,omitempty"`
+	Endpoints         *Endpoints `json:"endpoints,omitempty"`
+	PublicKey         *PublicKey `json:"publicKey,omitempty"`
+	Published         string     `json:"published,omitempty"`
+
+	// Whether the actor approves followers manually.
+	ManuallyApprovesFollowers bool `json:"manuallyApprovesFollowers"`
+}
+
+// CheckKey returns an error unless a, the actor document fetched from actorID,
+// is that of actorID, and has the key keyID (which is on the same host as
+// actorID, so that no server can vouch for the keys of the actors of another).
+func (a *Actor) CheckKey(actorID, keyID string) error {
+	if a.ID != actorID {
+		return errors.New("activitypub: actor document is not that of " + actorID)
+	}
+	if a.PublicKey == nil || a.PublicKey.ID != keyID || a.PublicKey.Owner != a.ID {
+		return errors.New("activitypub: actor " + actorID + " has no key " + keyID)
+	}
+	actorURL, err := url.Parse(actorID)
+	if err != nil {
+		return err
+	}
+	keyURL, err := url.Parse(keyID)
+	if err != nil {
+		return err
+	}
+	if actorURL.Host == "" || !strings.EqualFold(keyURL.Host, actorURL.Host) {
+		return errors.New("activitypub: key " + keyID + " is not on the host of actor " + actorID)
+	}
+	return nil
+}
+
+// SharedInbox returns the shared inbox of the actor, if it has one, or its
+// inbox.
+func (a *Actor) SharedInbox() string {
+	if a.Endpoints != nil && a.Endpoints.SharedInbox != "" {
+		return a.Endpoints.SharedInbox
+	}
+	return a.Inbox
+}
+
+type Image struct {
+	Type string `json:"type"` // Always "Image".
+	URL  string `json:"url"`
+}
+
+// Object is an object (other than an actor or an activity), such as a Page
+// or a Note.
+type Object struct {
+	Context      any      `json:"@context,omitempty"`
+	ID           string   `json:"id"`
+	Type         string   `json:"type"`
+	AttributedTo string   `json:"attributedTo,omitempty"`
+	Audience     string   `json:"audience,omitempty"`
+	Name         string   `json:"name,omitempty"`
+	Content      string   `json:"content,omitempty"`
+	MediaType    string   `json:"mediaType,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	Attachment   []any    `json:"attachment,omitempty"`
+	Image        *Image   `json:"image,omitempty"`
+	To           []string `json:"to,omitempty"`
+	CC           []string `json:"cc,omitempty"`
+	Published    string   `json:"published,omitempty"`
+	Updated      string   `json:"updated,omitempty"`
+	Sensitive    bool     `json:"sensitive"`
+
+	// The remote shares (Announces) of the object.
+	Shares *OrderedCollection `json:"shares,omitempty"`
+}
+
+// Activity is an activity.
+type Activity struct {
+	Context   any      `json:"@context,omitempty"`
+	ID        string   `json:"id"`
+	Type      string   `json:"type"`
+	Actor     string   `json:"actor"`
+	Object    any      `json:"object"` // Either the ID of an object, or an object.
+	To        []string `json:"to,omitempty"`
+	CC        []string `json:"cc,omitempty"`
+	Published string   `json:"published,omitempty"`
+}
+
+// OrderedCollection is an ordered collection, such as an outbox.
+type OrderedCollection struct {
+	Context      any    `json:"@context,omitempty"`
+	ID           string `json:"id"`
+	Type         string `json:"type"` // Always "OrderedCollection".
+	TotalItems   int    `json:"totalItems"`
+	OrderedItems []any  `json:"orderedItems,omitempty"`
+}
+
+// IncomingActivity is an activity received in an inbox, of which only the
+// fields needed to handle it are decoded.
+type IncomingActivity struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Actor  string `json:"actor"`
+	Object any    `json:"object"`
+}
+
+// ObjectID returns the ID of the object of a, which is either the object
+// itself or an embedded object with an id field.
+func (a *IncomingActivity) ObjectID() string {
+	switch o := a.Object.(type) {
+	case string:
+		return o
+	case map[string]any:
+		id, _ := o["id"].(string)
+		return id
+	}
+	return ""
+}
+
+// ObjectType returns the type of the object of a, if the object is embedded.
+func (a *IncomingActivity) ObjectType() string {
+	if o, ok := a.Object.(map[string]any); ok {
+		t, _ := o["type"].(string)
+		return t
+	}
+	return ""
+}
+
+// DecodeObject decodes the object of a, if it's embedded, into v.
+func (a *IncomingActivity) DecodeObject(v any) error {
+	if _, ok := a.Object.(map[string]any); !ok {
+		return errors.New("activitypub: object is not embedded")
+	}
+	data, err := json.Marshal(a.Object)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WebFinger is a WebFinger (JRD) document.
+type WebFinger struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases,omitempty"`
+	Links   []WebFingerLink `json:"links"`
+}
+
+type WebFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href"`
+}
+
+// Note is a Note (a reply, for example) received from another server, of which
+// only the fields needed to handle it are decoded.
+type Note struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	AttributedTo string `json:"attributedTo"`
+	InReplyTo    string `json:"inReplyTo"`
+	Content      string `json:"content"` // HTML.
+}
@@ -0,0 +1,116 @@
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// maxDocumentSize is the maximum size of a document fetched from another
+// server.
+const maxDocumentSize = 1 << 20
+
+var client = &http.Client{Timeout: 15 * time.Second}
+
+// StatusError is returned by Deliver and FetchActor when the remote server
+// responds with a status code other than 2xx.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("activitypub: %s responded with status %d", e.URL, e.StatusCode)
+}
+
+// Permanent reports whether retrying the request is pointless.
+func (e *StatusError) Permanent() bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusGone:
+		return true
+	}
+	return false
+}
+
+// checkURL returns an error if rawURL is not an https URL (which all the URLs
+// of other servers we make requests to must be).
+func checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return errors.New("activitypub: not an https URL: " + rawURL)
+	}
+	return nil
+}
+
+// Deliver posts activity (a JSON document) to inbox, signing the request with
+// key, whose ID is keyID.
+func Deliver(ctx context.Context, inbox string, activity []byte, keyID string, key *rsa.PrivateKey) error {
+	if err := checkURL(inbox); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", inbox, bytes.NewReader(activity))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Accept", ContentType)
+	if err := Sign(req, activity, keyID, key); err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, maxDocumentSize))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &StatusError{URL: inbox, StatusCode: res.StatusCode}
+	}
+	return nil
+}
+
+// FetchActor fetches the actor document at actorID (which, for a key ID, may
+// have a fragment). Many servers require that such requests be signed, so the
+// request is signed with key, whose ID is keyID.
+func FetchActor(ctx context.Context, actorID string, keyID string, key *rsa.PrivateKey) (*Actor, error) {
+	if err := checkURL(actorID); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", actorID, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.URL.Fragment = ""
+	req.Header.Set("Accept", ContentType)
+	if err := Sign(req, nil, keyID, key); err != nil {
+		return nil, err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, &StatusError{URL: actorID, StatusCode: res.StatusCode}
+	}
+	actor := &Actor{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxDocumentSize)).Decode(actor); err != nil {
+		return nil, err
+	}
+	if actor.ID == "" || actor.Inbox == "" {
+		return nil, errors.New("activitypub: invalid actor document at " + actorID)
+	}
+	return actor, nil
+}
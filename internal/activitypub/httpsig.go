@@ -0,0 +1,199 @@
+package activitypub
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxClockSkew is how far the Date header of a signed request may be from now.
+const maxClockSkew = 12 * time.Hour
+
+var (
+	ErrNoSignature      = errors.New("activitypub: request is not signed")
+	ErrInvalidSignature = errors.New("activitypub: invalid signature")
+)
+
+// GenerateKey generates an RSA key pair and returns it PEM encoded.
+func GenerateKey() (privatePEM, publicPEM string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+	privatePEM = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	publicPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}))
+	return privatePEM, publicPEM, nil
+}
+
+// ParsePrivateKey parses a PEM encoded (PKCS #1) RSA private key.
+func ParsePrivateKey(s string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("activitypub: no PEM data in private key")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// ParsePublicKey parses a PEM encoded (PKIX or PKCS #1) RSA public key.
+func ParsePublicKey(s string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("activitypub: no PEM data in public key")
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("activitypub: public key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// digest returns the value of the Digest header of a request with body.
+func digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// signingString returns the string that's signed for the headers of req.
+func signingString(req *http.Request, headers []string) (string, error) {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		var v string
+		switch h {
+		case "(request-target)":
+			v = strings.ToLower(req.Method) + " " + req.URL.RequestURI()
+		case "host":
+			v = req.Host
+			if v == "" {
+				v = req.URL.Host
+			}
+		default:
+			values := req.Header.Values(h)
+			if len(values) == 0 {
+				return "", fmt.Errorf("activitypub: signed header %s is missing", h)
+			}
+			v = strings.Join(values, ", ")
+		}
+		lines = append(lines, h+": "+v)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Sign signs req, whose body is body (nil for GET requests), with key, using
+// the draft-cavage HTTP signatures scheme that ActivityPub servers use. It
+// sets the Date, Digest (if body is not nil), and Signature headers.
+func Sign(req *http.Request, body []byte, keyID string, key *rsa.PrivateKey) error {
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	headers := []string{"(request-target)", "host", "date"}
+	if body != nil {
+		req.Header.Set("Digest", digest(body))
+		headers = append(headers, "digest")
+	}
+
+	s, err := signingString(req, headers)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256([]byte(s))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+// SignatureKeyID returns the ID of the key that req is signed with.
+func SignatureKeyID(req *http.Request) (string, error) {
+	params, err := parseSignature(req.Header.Get("Signature"))
+	if err != nil {
+		return "", err
+	}
+	return params["keyId"], nil
+}
+
+// Verify verifies the signature of req, whose body is body, with key. The
+// Digest header, if it's signed (which it must be if body is not empty), must
+// match body.
+func Verify(req *http.Request, body []byte, key *rsa.PublicKey) error {
+	params, err := parseSignature(req.Header.Get("Signature"))
+	if err != nil {
+		return err
+	}
+	headers := strings.Fields(params["headers"])
+	if len(headers) == 0 {
+		headers = []string{"date"}
+	}
+
+	signed := make(map[string]bool, len(headers))
+	for _, h := range headers {
+		signed[h] = true
+	}
+	if !signed["(request-target)"] || !signed["date"] && !signed["(created)"] {
+		return ErrInvalidSignature
+	}
+	if len(body) > 0 {
+		if !signed["digest"] || req.Header.Get("Digest") != digest(body) {
+			return ErrInvalidSignature
+		}
+	}
+	if date := req.Header.Get("Date"); date != "" {
+		t, err := http.ParseTime(date)
+		if err != nil || time.Since(t).Abs() > maxClockSkew {
+			return ErrInvalidSignature
+		}
+	}
+
+	s, err := signingString(req, headers)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	sig, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	hash := sha256.Sum256([]byte(s))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig); err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// parseSignature parses the value of a Signature header.
+func parseSignature(header string) (map[string]string, error) {
+	if header == "" {
+		return nil, ErrNoSignature
+	}
+	params := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, ErrInvalidSignature
+		}
+		params[k] = strings.Trim(v, `"`)
+	}
+	if params["keyId"] == "" || params["signature"] == "" {
+		return nil, ErrInvalidSignature
+	}
+	return params, nil
+}
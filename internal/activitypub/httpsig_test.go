@@ -0,0 +1,67 @@
+package activitypub
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	privatePEM, publicPEM, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := ParsePrivateKey(privatePEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := ParsePublicKey(publicPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"type":"Follow"}`)
+	req := httptest.NewRequest("POST", "https://example.com/ap/c/general/inbox", strings.NewReader(string(body)))
+	if err := Sign(req, body, "https://example.org/users/a#main-key", private); err != nil {
+		t.Fatal(err)
+	}
+	if keyID, err := SignatureKeyID(req); err != nil || keyID != "https://example.org/users/a#main-key" {
+		t.Errorf("SignatureKeyID: got %q, %v", keyID, err)
+	}
+	if err := Verify(req, body, public); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if err := Verify(req, []byte(`{"type":"Undo"}`), public); err == nil {
+		t.Error("Verify: tampered body verified")
+	}
+	req.URL.Path = "/ap/c/other/inbox"
+	if err := Verify(req, body, public); err == nil {
+		t.Error("Verify: tampered request target verified")
+	}
+}
+
+func TestActorCheckKey(t *testing.T) {
+	const alice = "https://victim.social/users/alice"
+	actor := func(id, keyID, owner string) *Actor {
+		return &Actor{ID: id, PublicKey: &PublicKey{ID: keyID, Owner: owner}}
+	}
+	tests := []struct {
+		name    string
+		actor   *Actor
+		keyID   string
+		wantErr bool
+	}{
+		{"fragment key", actor(alice, alice+"#main-key", alice), alice + "#main-key", false},
+		{"key on the same host", actor(alice, "https://VICTIM.social/keys/1", alice), "https://VICTIM.social/keys/1", false},
+		{"other key", actor(alice, alice+"#main-key", alice), "https://evil.example/key", true},
+		{"cross-host key document", actor(alice, "https://evil.example/key", alice), "https://evil.example/key", true},
+		{"other actor", actor("https://evil.example/users/mallory", "https://evil.example/key", "https://evil.example/users/mallory"), "https://evil.example/key", true},
+		{"key of another owner", actor(alice, alice+"#main-key", "https://victim.social/users/bob"), alice + "#main-key", true},
+		{"no key", &Actor{ID: alice}, alice + "#main-key", true},
+	}
+	for _, test := range tests {
+		if err := test.actor.CheckKey(alice, test.keyID); (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error: %v", test.name, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package activitypub
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	htmlBreaks  = regexp.MustCompile(`(?i)<br\s*/?>`)
+	htmlParaEnd = regexp.MustCompile(`(?i)</p>\s*`)
+	htmlTags    = regexp.MustCompile(`<[^>]*>`)
+	blankLines  = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToText converts the HTML content of a remote object (such as a Note) to
+// plain text: paragraphs and line breaks are kept, and all other markup is
+// dropped.
+func HTMLToText(s string) string {
+	s = htmlBreaks.ReplaceAllString(s, "\n")
+	s = htmlParaEnd.ReplaceAllString(s, "\n\n")
+	s = htmlTags.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	s = blankLines.ReplaceAllString(s, "\n\n")
+	return strings.TrimSpace(s)
+}
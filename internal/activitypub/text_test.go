@@ -0,0 +1,20 @@
+package activitypub
+
+import "testing"
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"<p>Hello</p>", "Hello"},
+		{`<p><span class="h-card"><a href="https://example.com/@a">@<span>a</span></a></span> hi &amp; bye</p><p>two<br>lines</p>`, "@a hi & bye\n\ntwo\nlines"},
+		{"<p>a</p>\n\n\n<p>b</p>", "a\n\nb"},
+		{"&lt;script&gt;", "<script>"},
+	}
+	for _, test := range tests {
+		if got := HTMLToText(test.in); got != test.want {
+			t.Errorf("HTMLToText(%q): got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
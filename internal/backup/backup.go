@@ -0,0 +1,247 @@
+// Package backup writes and reads backup archives: gzipped tar files,
+// optionally encrypted with a passphrase, that end with a manifest of the
+// files in them and their checksums.
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// ManifestFile is the name of the manifest in an archive.
+const ManifestFile = "manifest.json"
+
+// Manifest describes the contents of an archive.
+type Manifest struct {
+	CreatedAt     time.Time `json:"createdAt"`
+	DBDriver      string    `json:"dbDriver"`
+	SchemaVersion uint      `json:"schemaVersion"` // The version of the last applied migration.
+	Files         []*File   `json:"files"`
+}
+
+// File is a file in an archive.
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Writer writes an archive.
+type Writer struct {
+	tw       *tar.Writer
+	gw       *gzip.Writer
+	ew       *encryptWriter // Nil if the archive is not encrypted.
+	manifest *Manifest
+}
+
+// NewWriter returns a Writer that writes an archive to w, encrypted with
+// passphrase unless it's empty. Close must be called to finish the archive.
+func NewWriter(w io.Writer, passphrase string) (*Writer, error) {
+	bw := &Writer{manifest: &Manifest{Files: []*File{}}}
+	if passphrase != "" {
+		ew, err := newEncryptWriter(w, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		bw.ew, w = ew, ew
+	}
+	bw.gw = gzip.NewWriter(w)
+	bw.tw = tar.NewWriter(bw.gw)
+	return bw, nil
+}
+
+// AddFile adds the file at path (on disk) to the archive as name.
+func (w *Writer) AddFile(name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return w.add(name, f, info.Size(), info.ModTime())
+}
+
+// AddBytes adds a file, named name, with contents data to the archive.
+func (w *Writer) AddBytes(name string, data []byte) error {
+	return w.add(name, bytes.NewReader(data), int64(len(data)), time.Now())
+}
+
+// AddDir adds the files in dir, and in its subfolders, to the archive, under
+// the folder name. It does nothing if dir does not exist.
+func (w *Writer) AddDir(name, dir string) error {
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		return w.AddFile(path.Join(name, filepath.ToSlash(rel)), p)
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, statErr := os.Stat(dir); errors.Is(statErr, fs.ErrNotExist) {
+			return nil
+		}
+	}
+	return err
+}
+
+func (w *Writer) add(name string, r io.Reader, size int64, modTime time.Time) error {
+	if name == ManifestFile {
+		return fmt.Errorf("backup: %s is a reserved name", name)
+	}
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     0600,
+		Size:     size,
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(w.tw, io.TeeReader(r, h))
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("backup: %s changed while being added", name)
+	}
+	w.manifest.Files = append(w.manifest.Files, &File{Name: name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))})
+	return nil
+}
+
+// Close writes m, with the list of the files added, as the manifest of the
+// archive, and finishes the archive. It does not close the underlying writer.
+func (w *Writer) Close(m Manifest) error {
+	m.Files = w.manifest.Files
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name:     ManifestFile,
+		Mode:     0600,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+	if _, err := w.tw.Write(data); err != nil {
+		return err
+	}
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	if err := w.gw.Close(); err != nil {
+		return err
+	}
+	if w.ew != nil {
+		return w.ew.Close()
+	}
+	return nil
+}
+
+// Read reads the archive in r, decrypting it with passphrase if it's
+// encrypted, and calls fn, if it's not nil, for each file in it (but the
+// manifest), with a reader of its contents. It returns the manifest of the
+// archive, or an error if the archive is corrupted, has no manifest, or if
+// any file does not match its checksum in the manifest. Since the manifest is
+// at the end of an archive, the files passed to fn may not be valid (run Read
+// first with a nil fn to verify the archive).
+func Read(r io.Reader, passphrase string, fn func(name string, r io.Reader) error) (*Manifest, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(encryptedMagic)); err == nil && string(magic) == encryptedMagic {
+		if passphrase == "" {
+			return nil, errors.New("backup: the archive is encrypted (and no passphrase was given)")
+		}
+		br.Discard(len(encryptedMagic))
+		dr, err := newDecryptReader(br, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		r = dr
+	} else {
+		r = br
+	}
+
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, readError(err)
+	}
+	tr := tar.NewReader(gr)
+	sums := make(map[string]*File)
+	var manifest *Manifest
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, readError(err)
+		}
+		if hdr.Name == ManifestFile {
+			manifest = &Manifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("backup: reading manifest: %w", err)
+			}
+			continue
+		}
+		h := sha256.New()
+		var fr io.Reader = io.TeeReader(tr, h)
+		if fn != nil {
+			if err := fn(hdr.Name, fr); err != nil {
+				return nil, err
+			}
+		}
+		// Whatever fn didn't read.
+		if _, err := io.Copy(h, tr); err != nil {
+			return nil, fmt.Errorf("backup: reading %s: %w", hdr.Name, err)
+		}
+		sums[hdr.Name] = &File{Name: hdr.Name, Size: hdr.Size, SHA256: hex.EncodeToString(h.Sum(nil))}
+	}
+	if manifest == nil {
+		return nil, errors.New("backup: the archive has no manifest (it may be truncated)")
+	}
+	if len(sums) != len(manifest.Files) {
+		return nil, fmt.Errorf("backup: the archive has %d files but its manifest lists %d", len(sums), len(manifest.Files))
+	}
+	for _, f := range manifest.Files {
+		got, ok := sums[f.Name]
+		if !ok {
+			return nil, fmt.Errorf("backup: %s is missing", f.Name)
+		}
+		if got.Size != f.Size || got.SHA256 != f.SHA256 {
+			return nil, fmt.Errorf("backup: %s does not match its checksum", f.Name)
+		}
+	}
+	return manifest, nil
+}
+
+func readError(err error) error {
+	if err == ErrWrongPassphrase {
+		return err
+	}
+	return fmt.Errorf("backup: reading archive: %w", err)
+}
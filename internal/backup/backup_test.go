@@ -0,0 +1,77 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func writeArchive(t *testing.T, passphrase string, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := w.AddBytes(name, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(Manifest{DBDriver: "sqlite3", SchemaVersion: 7}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	files := map[string][]byte{
+		"db.sql":         []byte(strings.Repeat("INSERT INTO posts VALUES (1);\n", 10000)), // Several chunks.
+		"images/a/b.jpg": {0xff, 0xd8, 0xff},
+		"empty":          {},
+	}
+	for _, passphrase := range []string{"", "correct horse"} {
+		archive := writeArchive(t, passphrase, files)
+		got := make(map[string][]byte)
+		m, err := Read(bytes.NewReader(archive), passphrase, func(name string, r io.Reader) error {
+			data, err := io.ReadAll(r)
+			got[name] = data
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Read (passphrase %q): %v", passphrase, err)
+		}
+		if m.SchemaVersion != 7 || len(m.Files) != len(files) {
+			t.Errorf("Manifest: %+v", m)
+		}
+		for name, data := range files {
+			if !bytes.Equal(got[name], data) {
+				t.Errorf("%s: got %d bytes, want %d", name, len(got[name]), len(data))
+			}
+		}
+	}
+}
+
+func TestReadDetectsDamage(t *testing.T) {
+	data := make([]byte, 3*chunkSize) // Random, so that it's not compressed.
+	rand.New(rand.NewSource(1)).Read(data)
+	files := map[string][]byte{"db.sql": data}
+
+	archive := writeArchive(t, "secret", files)
+	if _, err := Read(bytes.NewReader(archive), "wrong", nil); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Wrong passphrase: got %v", err)
+	}
+	if _, err := Read(bytes.NewReader(archive), "", nil); err == nil {
+		t.Error("Encrypted archive read without a passphrase")
+	}
+	if _, err := Read(bytes.NewReader(archive[:len(archive)-chunkSize/2]), "secret", nil); err == nil {
+		t.Error("Truncated encrypted archive read")
+	}
+
+	archive = writeArchive(t, "", files)
+	if _, err := Read(bytes.NewReader(archive[:len(archive)/2]), "", nil); err == nil {
+		t.Error("Truncated archive read")
+	}
+}
@@ -0,0 +1,116 @@
+// Package cdn lets a CDN in front of the site cache responses, and be told when
+// cached responses go stale.
+//
+// Cacheable responses are tagged with surrogate keys (in the Surrogate-Key
+// header) that identify the content in them (like post-<id>). When that
+// content is edited or deleted, the keys are purged with the registered
+// Purgers.
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/logging"
+)
+
+var logger = logging.Logger("cdn")
+
+// SurrogateKeyHeader is the response header that holds the surrogate keys of
+// a response, separated by spaces.
+const SurrogateKeyHeader = "Surrogate-Key"
+
+// Key returns the surrogate key of the item of kind (like post, community,
+// user, or image) with id.
+func Key(kind string, id fmt.Stringer) string {
+	return kind + "-" + id.String()
+}
+
+// AddKeys adds keys to the surrogate keys of a response with header h.
+func AddKeys(h http.Header, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	if existing := h.Get(SurrogateKeyHeader); existing != "" {
+		keys = append(strings.Fields(existing), keys...)
+	}
+	h.Set(SurrogateKeyHeader, strings.Join(keys, " "))
+}
+
+// A Purger invalidates the cached responses tagged with any of keys.
+type Purger interface {
+	Purge(ctx context.Context, keys []string) error
+}
+
+var purgers []Purger
+
+// RegisterPurger adds p to the purgers called by Purge.
+func RegisterPurger(p Purger) {
+	purgers = append(purgers, p)
+}
+
+// Purge invalidates, with all registered purgers, the cached responses tagged
+// with any of keys. It doesn't wait for the purgers to finish; errors are only
+// logged.
+func Purge(ctx context.Context, keys ...string) {
+	if len(purgers) == 0 || len(keys) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	for _, p := range purgers {
+		go func(p Purger) {
+			ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			defer cancel()
+			if err := p.Purge(ctx, keys); err != nil {
+				logger.ErrorContext(ctx, "CDN purge failed", "keys", keys, "err", err)
+			}
+		}(p)
+	}
+}
+
+// HTTPPurger purges keys by POSTing them to a URL, as a JSON object (ex:
+// {"keys": ["post-17c4b3e6a8f0d2c1e9b7a5f3"]}). This works with CDNs whose
+// purge API accepts such requests or with a small adapter in front of the
+// CDN's API.
+type HTTPPurger struct {
+	URL    string
+	Token  string       // If non-empty, sent as a bearer token.
+	Client *http.Client // If nil, a client with a 10 second timeout is used.
+}
+
+var defaultPurgeClient = &http.Client{Timeout: 10 * time.Second}
+
+func (p *HTTPPurger) Purge(ctx context.Context, keys []string) error {
+	body, err := json.Marshal(struct {
+		Keys []string `json:"keys"`
+	}{keys})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+	client := p.Client
+	if client == nil {
+		client = defaultPurgeClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("cdn purge endpoint %s responded with status %d", p.URL, res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+// Package highlight finds the fenced code blocks of Markdown text and renders
+// them as syntax highlighted HTML, so that clients don't have to highlight
+// code themselves.
+//
+// The highlighting is lexical (keywords, strings, comments, and numbers) and
+// only for the languages in languages. The code of other languages is
+// rendered as is.
+package highlight
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The classes of the spans of highlighted code.
+const (
+	ClassKeyword = "hl-keyword"
+	ClassString  = "hl-string"
+	ClassComment = "hl-comment"
+	ClassNumber  = "hl-number"
+)
+
+// A Block is a fenced code block of Markdown text.
+type Block struct {
+	// Language is the language in the info string of the fence (the first
+	// word of it, lowercased), or empty if there's none.
+	Language string `json:"language"`
+
+	// HTML is the code as a pre element, highlighted if the language is
+	// known.
+	HTML string `json:"html"`
+}
+
+var (
+	openingFence = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})\\s*([^\\s`]*)")
+	closingFence = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})\\s*$")
+)
+
+// Blocks returns the fenced code blocks of the Markdown text md, in order, or
+// nil if there are none.
+func Blocks(md string) []Block {
+	if !strings.Contains(md, "```") && !strings.Contains(md, "~~~") {
+		return nil
+	}
+	var (
+		blocks   []Block
+		code     []string
+		fence    string // The opening fence of the block that's open, if any.
+		indent   int
+		language string
+	)
+	for _, line := range strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n") {
+		if fence == "" {
+			if m := openingFence.FindStringSubmatch(line); m != nil {
+				fence, indent, language = m[2], len(m[1]), strings.ToLower(m[3])
+				code = code[:0]
+			}
+			continue
+		}
+		if m := closingFence.FindStringSubmatch(line); m != nil && m[1][0] == fence[0] && len(m[1]) >= len(fence) {
+			blocks = append(blocks, Block{Language: language, HTML: HTML(strings.Join(code, "\n"), language)})
+			fence = ""
+			continue
+		}
+		code = append(code, trimIndent(line, indent))
+	}
+	if fence != "" {
+		// A block that's not closed runs to the end of the text.
+		blocks = append(blocks, Block{Language: language, HTML: HTML(strings.Join(code, "\n"), language)})
+	}
+	return blocks
+}
+
+// trimIndent removes up to n spaces from the start of line.
+func trimIndent(line string, n int) string {
+	for i := 0; i < n && strings.HasPrefix(line, " "); i++ {
+		line = line[1:]
+	}
+	return line
+}
+
+// HTML returns code, of the language language, as a pre element with a code
+// element in it (of the class language-<language>, if language is not
+// empty), with the tokens of the code in spans of the classes Class*.
+func HTML(code, language string) string {
+	var b strings.Builder
+	b.WriteString("<pre><code")
+	if language != "" {
+		b.WriteString(` class="language-`)
+		b.WriteString(html.EscapeString(language))
+		b.WriteString(`"`)
+	}
+	b.WriteString(">")
+	if lang := languages[language]; lang != nil {
+		lang.highlight(&b, code)
+	} else {
+		b.WriteString(html.EscapeString(code))
+	}
+	b.WriteString("</code></pre>")
+	return b.String()
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
+
+func writeSpan(b *strings.Builder, class, text string) {
+	b.WriteString(`<span class="`)
+	b.WriteString(class)
+	b.WriteString(`">`)
+	b.WriteString(html.EscapeString(text))
+	b.WriteString("</span>")
+}
+
+// highlight writes code, highlighted as l, to b.
+func (l *language) highlight(b *strings.Builder, code string) {
+	plainStart := 0 // Of the text not yet written.
+	flush := func(i int) {
+		b.WriteString(html.EscapeString(code[plainStart:i]))
+	}
+	for i := 0; i < len(code); {
+		if end := l.comment(code, i); end > i {
+			flush(i)
+			writeSpan(b, ClassComment, code[i:end])
+			i, plainStart = end, end
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(code[i:])
+		prev, _ := utf8.DecodeLastRuneInString(code[:i])
+		switch {
+		case strings.ContainsRune(l.quotes, r):
+			end := l.stringEnd(code, i, r)
+			flush(i)
+			writeSpan(b, ClassString, code[i:end])
+			i, plainStart = end, end
+		case unicode.IsDigit(r) && (i == 0 || !isIdentPart(prev)):
+			end := i + size
+			for end < len(code) {
+				r, size := utf8.DecodeRuneInString(code[end:])
+				if !isIdentPart(r) && r != '.' {
+					break
+				}
+				end += size
+			}
+			flush(i)
+			writeSpan(b, ClassNumber, code[i:end])
+			i, plainStart = end, end
+		case isIdentStart(r):
+			end := i + size
+			for end < len(code) {
+				r, size := utf8.DecodeRuneInString(code[end:])
+				if !isIdentPart(r) {
+					break
+				}
+				end += size
+			}
+			word := code[i:end]
+			if l.ignoreCase {
+				word = strings.ToLower(word)
+			}
+			if l.keywords[word] {
+				flush(i)
+				writeSpan(b, ClassKeyword, code[i:end])
+				plainStart = end
+			}
+			i = end
+		default:
+			i += size
+		}
+	}
+	flush(len(code))
+}
+
+// comment returns the end of the comment that starts at i in code, or i if
+// none does.
+func (l *language) comment(code string, i int) int {
+	for _, prefix := range l.lineComments {
+		if strings.HasPrefix(code[i:], prefix) {
+			if n := strings.IndexByte(code[i:], '\n'); n >= 0 {
+				return i + n
+			}
+			return len(code)
+		}
+	}
+	if l.blockComment[0] != "" && strings.HasPrefix(code[i:], l.blockComment[0]) {
+		start := i + len(l.blockComment[0])
+		if n := strings.Index(code[start:], l.blockComment[1]); n >= 0 {
+			return start + n + len(l.blockComment[1])
+		}
+		return len(code)
+	}
+	return i
+}
+
+// stringEnd returns the end of the string that starts, with the quote quote,
+// at i in code. Strings end at the end of the line, unless their quote is one
+// of the multiline quotes of l.
+func (l *language) stringEnd(code string, i int, quote rune) int {
+	multiline := strings.ContainsRune(l.multilineQuotes, quote)
+	for j := i + 1; j < len(code); j++ {
+		switch c := code[j]; {
+		case c == '\\' && !multiline:
+			j++ // Skip the escaped character.
+		case rune(c) == quote:
+			return j + 1
+		case c == '\n' && !multiline:
+			return j
+		}
+	}
+	return len(code)
+}
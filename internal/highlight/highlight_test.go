@@ -0,0 +1,55 @@
+package highlight
+
+import "testing"
+
+func TestBlocks(t *testing.T) {
+	md := "Some code:\n\n```Go\nfunc main() {}\n```\n\n  ~~~\n  <b>\n  ~~~\n\n````sql\nselect 1\n```\nstill code\n"
+	blocks := Blocks(md)
+	if len(blocks) != 3 {
+		t.Fatalf("got %d blocks, want 3: %+v", len(blocks), blocks)
+	}
+	want := []Block{
+		{"go", `<pre><code class="language-go"><span class="hl-keyword">func</span> main() {}</code></pre>`},
+		{"", `<pre><code>&lt;b&gt;</code></pre>`},
+		{"sql", `<pre><code class="language-sql"><span class="hl-keyword">select</span> <span class="hl-number">1</span>` + "\n```\nstill code\n</code></pre>"},
+	}
+	for i := range want {
+		if blocks[i] != want[i] {
+			t.Errorf("block %d: got %+v, want %+v", i, blocks[i], want[i])
+		}
+	}
+	if Blocks("no code here") != nil {
+		t.Error("got blocks for text with no code")
+	}
+}
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		code, language, want string
+	}{
+		{
+			`x := "a\"<b>" // if`, "go",
+			`x := <span class="hl-string">&#34;a\&#34;&lt;b&gt;&#34;</span> <span class="hl-comment">// if</span>`,
+		},
+		{
+			"def f(x2):\n    return None # done", "python",
+			`<span class="hl-keyword">def</span> f(x2):` + "\n    " + `<span class="hl-keyword">return</span> <span class="hl-keyword">None</span> <span class="hl-comment"># done</span>`,
+		},
+		{
+			"/* a\nb */ const s = `x\ny`;", "js",
+			`<span class="hl-comment">/* a` + "\n" + `b */</span> <span class="hl-keyword">const</span> s = <span class="hl-string">` + "`x\ny`</span>;",
+		},
+		{"SELECT x FROM t", "sql", `<span class="hl-keyword">SELECT</span> x <span class="hl-keyword">FROM</span> t`},
+		{"if x < 1", "cobol", "if x &lt; 1"},
+	}
+	for _, test := range tests {
+		got := HTML(test.code, test.language)
+		class := ""
+		if test.language != "" {
+			class = ` class="language-` + test.language + `"`
+		}
+		if want := "<pre><code" + class + ">" + test.want + "</code></pre>"; got != want {
+			t.Errorf("HTML(%q, %q):\ngot  %s\nwant %s", test.code, test.language, got, want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package highlight
+
+import "strings"
+
+// A language is the lexical syntax of a programming language.
+type language struct {
+	keywords        map[string]bool
+	ignoreCase      bool // Of keywords.
+	lineComments    []string
+	blockComment    [2]string // The start and end.
+	quotes          string    // The characters that quote strings.
+	multilineQuotes string    // Those of quotes that quote raw strings that span lines.
+}
+
+func words(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var (
+	langGo = &language{
+		keywords: words(`break case chan const continue default defer else fallthrough for func go goto if
+			import interface map package range return select struct switch type var
+			true false nil iota any bool byte rune string error int int8 int16 int32 int64
+			uint uint8 uint16 uint32 uint64 uintptr float32 float64 complex64 complex128`),
+		lineComments:    []string{"//"},
+		blockComment:    [2]string{"/*", "*/"},
+		quotes:          "\"'`",
+		multilineQuotes: "`",
+	}
+	langJavaScript = &language{
+		keywords: words(`async await break case catch class const continue debugger default delete do else
+			export extends finally for from function if import in instanceof let new of return
+			static super switch this throw try typeof var void while with yield true false null undefined`),
+		lineComments:    []string{"//"},
+		blockComment:    [2]string{"/*", "*/"},
+		quotes:          "\"'`",
+		multilineQuotes: "`",
+	}
+	langTypeScript = &language{
+		keywords: words(`abstract any as async await boolean break case catch class const continue declare
+			default delete do else enum export extends finally for from function if implements
+			import in instanceof interface keyof let namespace never new number of private
+			protected public readonly return static string super switch this throw try type
+			typeof unknown var void while yield true false null undefined`),
+		lineComments:    []string{"//"},
+		blockComment:    [2]string{"/*", "*/"},
+		quotes:          "\"'`",
+		multilineQuotes: "`",
+	}
+	langPython = &language{
+		keywords: words(`and as assert async await break class continue def del elif else except finally
+			for from global if import in is lambda nonlocal not or pass raise return try while
+			with yield True False None self`),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+	}
+	langRust = &language{
+		keywords: words(`as async await break const continue crate dyn else enum extern false fn for if impl
+			in let loop match mod move mut pub ref return self Self static struct super trait true
+			type unsafe use where while bool char str i8 i16 i32 i64 i128 isize u8 u16 u32 u64
+			u128 usize f32 f64 String Option Some None Result Ok Err`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"`,
+	}
+	langC = &language{
+		keywords: words(`auto break case char const continue default do double else enum extern float for
+			goto if inline int long register restrict return short signed sizeof static struct
+			switch typedef union unsigned void volatile while NULL true false bool`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+	}
+	langCPP = &language{
+		keywords: words(`auto bool break case catch char class const constexpr continue default delete do
+			double else enum explicit extern false float for friend goto if inline int long
+			mutable namespace new noexcept nullptr operator private protected public return
+			short signed sizeof static struct switch template this throw true try typedef
+			typename union unsigned using virtual void volatile while`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+	}
+	langJava = &language{
+		keywords: words(`abstract assert boolean break byte case catch char class const continue default do
+			double else enum extends final finally float for goto if implements import
+			instanceof int interface long native new package private protected public return
+			short static super switch synchronized this throw throws transient try var void
+			volatile while true false null`),
+		lineComments: []string{"//"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `"'`,
+	}
+	langSQL = &language{
+		keywords: words(`add all alter and as asc between by case create default delete desc distinct drop
+			else end exists from group having in index inner insert into is join key left like
+			limit not null on or order outer primary references right select set table then
+			union unique update values when where with true false`),
+		ignoreCase:   true,
+		lineComments: []string{"--"},
+		blockComment: [2]string{"/*", "*/"},
+		quotes:       `'"`,
+	}
+	langShell = &language{
+		keywords: words(`if then else elif fi for while until do done case esac in function return
+			local export exit echo cd`),
+		lineComments:    []string{"#"},
+		quotes:          `"'`,
+		multilineQuotes: `'`,
+	}
+	langJSON = &language{
+		keywords: words(`true false null`),
+		quotes:   `"`,
+	}
+)
+
+// languages are the languages highlighted, by their names (and aliases) in
+// the info strings of fences.
+var languages = map[string]*language{
+	"go":         langGo,
+	"golang":     langGo,
+	"javascript": langJavaScript,
+	"js":         langJavaScript,
+	"jsx":        langJavaScript,
+	"typescript": langTypeScript,
+	"ts":         langTypeScript,
+	"tsx":        langTypeScript,
+	"python":     langPython,
+	"py":         langPython,
+	"rust":       langRust,
+	"rs":         langRust,
+	"c":          langC,
+	"h":          langC,
+	"cpp":        langCPP,
+	"c++":        langCPP,
+	"cc":         langCPP,
+	"java":       langJava,
+	"sql":        langSQL,
+	"sh":         langShell,
+	"bash":       langShell,
+	"shell":      langShell,
+	"zsh":        langShell,
+	"json":       langJSON,
+}
@@ -2,7 +2,11 @@ package httperr
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Error represents an HTTP error of a REST API. This type is used for all
@@ -18,12 +22,88 @@ type Error struct {
 	// Message is a human readable error message. Message should begin with a
 	// capital letter and each sentence should end in a period.
 	Message string `json:"message"`
+
+	// MessageKey identifies Message, for clients to show a translation of it
+	// instead. If empty, the key derived from Code is used (see Key).
+	MessageKey string `json:"messageKey,omitempty"`
+
+	// RetryAfter, for throttled requests (and requests refused for a while),
+	// is the number of seconds after which the request may be retried. It's
+	// also sent as the Retry-After header.
+	RetryAfter int `json:"retryAfter,omitempty"`
+
+	// Fields are the errors of the individual fields of the request body (ex:
+	// of a post being created), if the request failed validation.
+	Fields []FieldError `json:"fields,omitempty"`
+
+	// Details are data about the error (ex: the reason a post was locked), by
+	// name. They're also the values of the placeholders (ex: {reason}) of the
+	// translations of Message.
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// A FieldError is the error of a field of a request body.
+type FieldError struct {
+	Field   string `json:"field"` // As in the request body (ex: title).
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 func (err *Error) Error() string {
 	return fmt.Sprintf("%d %s (%s): %s", err.HTTPStatus, http.StatusText(err.HTTPStatus), err.Code, err.Message)
 }
 
+// Key returns MessageKey, or, if it's empty, the key derived from Code (ex:
+// error.post.title_too_short for the code post/title-too-short).
+func (err *Error) Key() string {
+	if err.MessageKey != "" {
+		return err.MessageKey
+	}
+	code := err.Code
+	if code == "" {
+		code = StatusCode(err.HTTPStatus)
+	}
+	return codeKey(code)
+}
+
+// Key returns the message key of the field error, which is derived from its
+// Code (see Error.Key).
+func (f *FieldError) Key() string {
+	return codeKey(f.Code)
+}
+
+func codeKey(code string) string {
+	return "error." + strings.NewReplacer("/", ".", "-", "_").Replace(strings.ToLower(code))
+}
+
+// Complete returns a copy of err (which may then be modified) with the fields that are empty but that
+// shouldn't be when the error is sent to a client (Code, Message, and
+// MessageKey) set to their defaults.
+func (err *Error) Complete() *Error {
+	e := *err
+	if len(err.Fields) > 0 {
+		e.Fields = append([]FieldError(nil), err.Fields...)
+	}
+	if e.Code == "" {
+		e.Code = StatusCode(e.HTTPStatus)
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(e.HTTPStatus) + "."
+	}
+	e.MessageKey = e.Key()
+	return &e
+}
+
+// StatusCode returns the error code of errors of the HTTP status code status
+// that don't have one (ex: too_many_requests for 429).
+func StatusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "http_" + strconv.Itoa(status)
+	}
+	return strings.ToLower(strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text))
+}
+
 // NewNotFound returns an error with 404 HTTP status code.
 func NewNotFound(code, message string) error {
 	return &Error{
@@ -51,6 +131,47 @@ func NewForbidden(code, message string) error {
 	}
 }
 
+// NewRequestTooLarge returns an error with 413 HTTP status code.
+func NewRequestTooLarge(code, message string) error {
+	return &Error{
+		HTTPStatus: http.StatusRequestEntityTooLarge,
+		Code:       code,
+		Message:    message,
+	}
+}
+
+// NewTooManyRequests returns an error with 429 HTTP status code, for requests
+// that may be retried after retryAfter.
+func NewTooManyRequests(code, message string, retryAfter time.Duration) error {
+	return &Error{
+		HTTPStatus: http.StatusTooManyRequests,
+		Code:       code,
+		Message:    message,
+		RetryAfter: int(math.Ceil(retryAfter.Seconds())),
+	}
+}
+
+// NewInvalidField returns an error with 400 HTTP status code for the invalid
+// field of a request body.
+func NewInvalidField(field, code, message string) error {
+	return NewInvalidFields(FieldError{Field: field, Code: code, Message: message})
+}
+
+// NewInvalidFields returns an error with 400 HTTP status code for the invalid
+// fields of a request body. The code and the message of the error are those
+// of the first field.
+func NewInvalidFields(fields ...FieldError) error {
+	err := &Error{
+		HTTPStatus: http.StatusBadRequest,
+		Code:       "invalid_fields",
+		Fields:     fields,
+	}
+	if len(fields) > 0 {
+		err.Code, err.Message = fields[0].Code, fields[0].Message
+	}
+	return err
+}
+
 // IsInternalServerError reports whether err should be treated as an HTTP 500
 // error.
 func IsInternalServerError(err error) bool {
@@ -0,0 +1,40 @@
+package httperr
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestComplete(t *testing.T) {
+	tests := []struct {
+		err           *Error
+		code, message string
+		key           string
+	}{
+		{&Error{HTTPStatus: http.StatusNotFound, Code: "post/not-found", Message: "Post(s) not found."}, "post/not-found", "Post(s) not found.", "error.post.not_found"},
+		{&Error{HTTPStatus: http.StatusTooManyRequests}, "too_many_requests", "Too Many Requests.", "error.too_many_requests"},
+		{&Error{HTTPStatus: http.StatusBadRequest, Code: "x", MessageKey: "custom.key"}, "x", "Bad Request.", "custom.key"},
+	}
+	for _, test := range tests {
+		got := test.err.Complete()
+		if got.Code != test.code || got.Message != test.message || got.MessageKey != test.key {
+			t.Errorf("Complete(%+v): got %q, %q, %q", test.err, got.Code, got.Message, got.MessageKey)
+		}
+		if got == test.err {
+			t.Errorf("Complete(%+v): returned the error itself", test.err)
+		}
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	err := NewTooManyRequests("rate_limited", "Too many requests.", 1500*time.Millisecond).(*Error)
+	if err.HTTPStatus != http.StatusTooManyRequests || err.RetryAfter != 2 {
+		t.Errorf("NewTooManyRequests: got %+v", err)
+	}
+
+	err = NewInvalidField("title", "post/title-too-short", "Title too short.").(*Error)
+	if err.HTTPStatus != http.StatusBadRequest || err.Code != "post/title-too-short" || len(err.Fields) != 1 || err.Fields[0].Field != "title" {
+		t.Errorf("NewInvalidField: got %+v", err)
+	}
+}
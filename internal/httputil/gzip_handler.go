@@ -112,6 +112,16 @@ func (w gzipResponseWriter) Write(p []byte) (int, error) {
 	return w.Writer.Write(p)
 }
 
+// Flush flushes the data compressed so far to the client.
+func (w gzipResponseWriter) Flush() {
+	if gz, ok := w.Writer.(*gzip.Writer); ok {
+		gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func GzipHandler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !AcceptEncoding(r.Header, "gzip") {
@@ -2,9 +2,11 @@
 package httputil
 
 import (
+	"context"
 	"io"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"golang.org/x/net/html"
@@ -20,6 +22,14 @@ var httpClient = &http.Client{
 	Timeout: time.Second * 6,
 }
 
+// noRedirectsClient is httpClient that doesn't follow redirects.
+var noRedirectsClient = &http.Client{
+	Timeout: httpClient.Timeout,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
 const (
 	userAgent = "Mozilla/5.0 (X11; Linux x86_64; rv:94.0) Gecko/20100101 Firefox/94.0"
 )
@@ -35,6 +45,29 @@ func Get(url string) (*http.Response, error) {
 	return httpClient.Do(req)
 }
 
+// Redirect returns the URL that url redirects to (without following it), or
+// an empty string if url doesn't redirect.
+func Redirect(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	res, err := noRedirectsClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	if res.StatusCode < 300 || res.StatusCode >= 400 {
+		return "", nil
+	}
+	loc, err := res.Location()
+	if err != nil {
+		return "", err
+	}
+	return loc.String(), nil
+}
+
 // ExtractOpenGraphImage returns the Open Graph image tag of the HTML document in r.
 func ExtractOpenGraphImage(r io.Reader) (string, error) {
 	doc, err := html.Parse(r)
@@ -98,3 +131,16 @@ func ExtractOpenGraphTitle(r io.Reader) (string, error) {
 
 	return title, nil
 }
+
+// ETagMatch reports whether the If-None-Match header value inm matches etag
+// (weakly, so that W/"x" matches "x").
+func ETagMatch(inm, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(inm, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,23 @@
+package httputil
+
+import "testing"
+
+func TestETagMatch(t *testing.T) {
+	tests := []struct {
+		inm, etag string
+		expect    bool
+	}{
+		{`"abc"`, `"abc"`, true},
+		{`W/"abc"`, `"abc"`, true},
+		{`"abc"`, `W/"abc"`, true},
+		{`"x", "abc"`, `"abc"`, true},
+		{`*`, `"abc"`, true},
+		{``, `"abc"`, false},
+		{`"abcd"`, `"abc"`, false},
+	}
+	for _, test := range tests {
+		if got := ETagMatch(test.inm, test.etag); got != test.expect {
+			t.Errorf("ETagMatch(%q, %q) = %v, expected %v", test.inm, test.etag, got, test.expect)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Package i18n translates the user-facing text that's produced by the server
+// (like error messages and emails), with translations loaded at runtime from
+// files.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLocale is the locale of the text in the code, which needs no
+// translation file.
+const DefaultLocale = "en"
+
+// localeTag matches the locales accepted by NormalizeLocale: a language
+// subtag optionally followed by a script and a region subtag.
+var localeTag = regexp.MustCompile(`^[a-z]{2,3}(-[a-z]{4})?(-([a-z]{2}|[0-9]{3}))?$`)
+
+// NormalizeLocale returns the locale (a BCP 47 language tag, like pt-BR) in
+// its canonical case, or an error if it's not a valid tag.
+func NormalizeLocale(locale string) (string, error) {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	if !localeTag.MatchString(locale) {
+		return "", fmt.Errorf("i18n: invalid locale %q", locale)
+	}
+	subtags := strings.Split(locale, "-")
+	for i := 1; i < len(subtags); i++ {
+		switch len(subtags[i]) {
+		case 4: // script
+			subtags[i] = strings.ToUpper(subtags[i][:1]) + subtags[i][1:]
+		case 2: // region
+			subtags[i] = strings.ToUpper(subtags[i])
+		}
+	}
+	return strings.Join(subtags, "-"), nil
+}
+
+// A Catalog is a set of translations, loaded from a directory with a JSON file
+// per locale, named after the locale (like fr.json or pt-BR.json). Each file is
+// an object of message keys to translated messages, which may contain
+// placeholders like {username} (see Translate).
+//
+// A nil *Catalog has no translations. A Catalog is safe for concurrent use.
+type Catalog struct {
+	dir string
+
+	mu       sync.RWMutex
+	messages map[string]map[string]string // By locale, and by key.
+}
+
+// Load loads the translation files of the directory dir.
+func Load(dir string) (*Catalog, error) {
+	c := &Catalog{dir: dir}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reloads the translation files (so that translations may be changed
+// without a restart). If any file is invalid, the translations in use are
+// kept.
+func (c *Catalog) Reload() error {
+	files, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	messages := make(map[string]map[string]string, len(files))
+	for _, file := range files {
+		locale, err := NormalizeLocale(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			return fmt.Errorf("i18n: translation file %s: %w", file, err)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		m := make(map[string]string)
+		if err := json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("i18n: translation file %s: %w", file, err)
+		}
+		messages[locale] = m
+	}
+	c.mu.Lock()
+	c.messages = messages
+	c.mu.Unlock()
+	return nil
+}
+
+// Locales returns the locales there are translations of, along with the
+// number of messages translated to each.
+func (c *Catalog) Locales() map[string]int {
+	locales := make(map[string]int)
+	if c == nil {
+		return locales
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for locale, m := range c.messages {
+		locales[locale] = len(m)
+	}
+	return locales
+}
+
+// message returns the message of key in locale, or in the language of locale
+// if locale has a region or a script (pt for pt-BR).
+func (c *Catalog) message(locale, key string) (string, bool) {
+	if c == nil || locale == "" {
+		return "", false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for {
+		if message, ok := c.messages[locale][key]; ok && message != "" {
+			return message, true
+		}
+		i := strings.LastIndexByte(locale, '-')
+		if i == -1 {
+			return "", false
+		}
+		locale = locale[:i]
+	}
+}
+
+// Translate returns the message of key translated to locale, or, if there's
+// no such translation, fallback (the message in DefaultLocale). The
+// placeholders of the message are replaced by args, which are pairs of
+// placeholder names and values (as in "username", "alice" for {username}).
+func (c *Catalog) Translate(locale, key, fallback string, args ...any) string {
+	message, ok := c.message(locale, key)
+	if !ok {
+		message = fallback
+	}
+	if len(args) == 0 {
+		return message
+	}
+	pairs := make([]string, 0, len(args))
+	for i := 0; i+1 < len(args); i += 2 {
+		pairs = append(pairs, "{"+fmt.Sprint(args[i])+"}", fmt.Sprint(args[i+1]))
+	}
+	return strings.NewReplacer(pairs...).Replace(message)
+}
+
+// DefaultTimeLayout is the layout (see time.Layout) of the times formatted by
+// FormatTime, unless the translation of the locale has one (the message
+// format.datetime).
+const DefaultTimeLayout = "Mon, 02 Jan 2006 15:04 MST"
+
+// FormatTime formats t (in its location) as per the layout of locale (see
+// DefaultTimeLayout).
+func (c *Catalog) FormatTime(locale string, t time.Time) string {
+	return t.Format(c.Translate(locale, "format.datetime", DefaultTimeLayout))
+}
+
+// Match returns the locale, of those there are translations of (or
+// DefaultLocale), that best matches the Accept-Language header value
+// acceptLanguage, or DefaultLocale if none does.
+func (c *Catalog) Match(acceptLanguage string) string {
+	if c == nil || acceptLanguage == "" {
+		return DefaultLocale
+	}
+	type weighted struct {
+		locale string
+		q      float64
+	}
+	var prefs []weighted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if locale, err := NormalizeLocale(tag); err == nil && q > 0 {
+			prefs = append(prefs, weighted{locale, q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, pref := range prefs {
+		for locale := pref.locale; ; {
+			if _, ok := c.messages[locale]; ok || locale == DefaultLocale {
+				return locale
+			}
+			i := strings.LastIndexByte(locale, '-')
+			if i == -1 {
+				break
+			}
+			locale = locale[:i]
+		}
+	}
+	return DefaultLocale
+}
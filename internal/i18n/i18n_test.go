@@ -0,0 +1,74 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCatalog(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"fr.json":    `{"error.post.not_found": "Publication introuvable.", "email.hello": "Bonjour {username},", "format.datetime": "02/01/2006 15:04 MST"}`,
+		"pt-BR.json": `{"error.post.not_found": "Post não encontrado."}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		locale, key, want string
+	}{
+		{"fr", "error.post.not_found", "Publication introuvable."},
+		{"fr-CA", "error.post.not_found", "Publication introuvable."},
+		{"pt-BR", "error.post.not_found", "Post não encontrado."},
+		{"pt", "error.post.not_found", "Post(s) not found."},
+		{"fr", "error.missing", "Post(s) not found."},
+		{"", "error.post.not_found", "Post(s) not found."},
+	}
+	for _, test := range tests {
+		if got := c.Translate(test.locale, test.key, "Post(s) not found."); got != test.want {
+			t.Errorf("Translate(%q, %q): got %q, want %q", test.locale, test.key, got, test.want)
+		}
+	}
+	if got := c.Translate("fr", "email.hello", "Hi {username},", "username", "alice"); got != "Bonjour alice," {
+		t.Errorf("Translate with args: got %q", got)
+	}
+
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 3, 9, 14, 5, 0, 0, time.UTC).In(paris)
+	if got := c.FormatTime("fr", at); got != "09/03/2024 15:05 CET" {
+		t.Errorf("FormatTime(fr): got %q", got)
+	}
+	if got := c.FormatTime("de", at); got != "Sat, 09 Mar 2024 15:05 CET" {
+		t.Errorf("FormatTime(de): got %q", got)
+	}
+
+	matches := map[string]string{
+		"fr-CA,fr;q=0.9,en;q=0.8": "fr",
+		"de,pt-BR;q=0.5":          "pt-BR",
+		"en-US,fr;q=0.5":          "en",
+		"de":                      DefaultLocale,
+		"":                        DefaultLocale,
+	}
+	for header, want := range matches {
+		if got := c.Match(header); got != want {
+			t.Errorf("Match(%q): got %q, want %q", header, got, want)
+		}
+	}
+
+	var nilCatalog *Catalog
+	if got := nilCatalog.Translate("fr", "error.post.not_found", "Post(s) not found."); got != "Post(s) not found." {
+		t.Errorf("nil catalog: got %q", got)
+	}
+}
@@ -0,0 +1,357 @@
+// Package idp implements logging in with external identity providers: the
+// OAuth 2 authorization code flow (with PKCE) against Google, GitHub, or any
+// OpenID Connect provider, and fetching the identity of the user from the
+// provider.
+package idp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoEmail is returned by GitHub providers if the user has no verified
+// email address.
+var ErrNoEmail = errors.New("idp: no verified email address")
+
+// An Identity is an account at an identity provider.
+type Identity struct {
+	Subject       string // The ID of the account at the provider (which never changes).
+	Email         string
+	EmailVerified bool
+	Name          string // The display name of the user, if any.
+	Username      string // The preferred username of the user, if any.
+	Groups        []string
+}
+
+// A Provider is an identity provider.
+type Provider struct {
+	Name        string // A short name (ex: google), used in URLs.
+	DisplayName string // Shown to users (ex: Google).
+
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	// The endpoints of the provider. If Issuer is set, those that aren't are
+	// discovered (once) from the OpenID configuration of the issuer.
+	Issuer      string
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+
+	// GroupsClaim is the claim of the user info with the groups of the user
+	// (ex: groups), if any.
+	GroupsClaim string
+
+	Client *http.Client // If nil, a client with a 10 second timeout is used.
+
+	github bool // The identity is fetched from the GitHub API.
+
+	mu         sync.Mutex
+	discovered bool
+}
+
+// Google returns the Google provider.
+func Google(clientID, clientSecret string) *Provider {
+	return &Provider{
+		Name:         "google",
+		DisplayName:  "Google",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"openid", "email", "profile"},
+		AuthURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL:     "https://oauth2.googleapis.com/token",
+		UserInfoURL:  "https://openidconnect.googleapis.com/v1/userinfo",
+	}
+}
+
+// GitHub returns the GitHub provider.
+func GitHub(clientID, clientSecret string) *Provider {
+	return &Provider{
+		Name:         "github",
+		DisplayName:  "GitHub",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"read:user", "user:email"},
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		UserInfoURL:  "https://api.github.com/user",
+		github:       true,
+	}
+}
+
+// OIDC returns a generic OpenID Connect provider, whose endpoints are
+// discovered from issuer.
+func OIDC(displayName, issuer, clientID, clientSecret string) *Provider {
+	return &Provider{
+		Name:         "oidc",
+		DisplayName:  displayName,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"openid", "email", "profile"},
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+	}
+}
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+func (p *Provider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return defaultClient
+}
+
+// discover sets the endpoints of p that aren't set from the OpenID
+// configuration of its issuer.
+func (p *Provider) discover(ctx context.Context) error {
+	if p.Issuer == "" {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovered {
+		return nil
+	}
+
+	var config struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return err
+	}
+	if err := p.do(req, &config); err != nil {
+		return fmt.Errorf("idp: discovering %s: %w", p.Issuer, err)
+	}
+	if strings.TrimSuffix(config.Issuer, "/") != p.Issuer {
+		return fmt.Errorf("idp: discovering %s: issuer mismatch (%s)", p.Issuer, config.Issuer)
+	}
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" || config.UserInfoEndpoint == "" {
+		return fmt.Errorf("idp: discovering %s: missing endpoints", p.Issuer)
+	}
+
+	if p.AuthURL == "" {
+		p.AuthURL = config.AuthorizationEndpoint
+	}
+	if p.TokenURL == "" {
+		p.TokenURL = config.TokenEndpoint
+	}
+	if p.UserInfoURL == "" {
+		p.UserInfoURL = config.UserInfoEndpoint
+	}
+	p.discovered = true
+	return nil
+}
+
+// do sends req and decodes its JSON response into v.
+func (p *Provider) do(req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", req.URL.Path, res.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
+
+// NewState returns a random value, for the state parameter and for the PKCE
+// code verifier.
+func NewState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthCodeURL returns the URL to which the user is sent to log in at the
+// provider, which then redirects them back to redirectURI with state and an
+// authorization code (see Exchange).
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURI, state, verifier string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + q.Encode(), nil
+}
+
+// Exchange exchanges the authorization code code (obtained with verifier)
+// for an access token.
+func (p *Provider) Exchange(ctx context.Context, redirectURI, code, verifier string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequestWithContext(ctx, "POST", p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.do(req, &token); err != nil {
+		return "", fmt.Errorf("idp: exchanging code: %w", err)
+	}
+	if token.Error != "" {
+		return "", fmt.Errorf("idp: exchanging code: %s (%s)", token.Error, token.ErrorDescription)
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("idp: exchanging code: no access token")
+	}
+	return token.AccessToken, nil
+}
+
+// flexBool is a boolean that may be encoded as a string (as some providers
+// do for email_verified).
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseBool(strings.Trim(string(data), `"`))
+	*b = flexBool(v)
+	return err
+}
+
+// Identity returns the identity of the user whose access token is token.
+func (p *Provider) Identity(ctx context.Context, token string) (*Identity, error) {
+	if err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+	if p.github {
+		return p.githubIdentity(ctx, token)
+	}
+
+	var raw json.RawMessage
+	if err := p.get(ctx, p.UserInfoURL, token, &raw); err != nil {
+		return nil, err
+	}
+	var info struct {
+		Subject           string   `json:"sub"`
+		Email             string   `json:"email"`
+		EmailVerified     flexBool `json:"email_verified"`
+		Name              string   `json:"name"`
+		PreferredUsername string   `json:"preferred_username"`
+	}
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return nil, fmt.Errorf("idp: fetching identity: %w", err)
+	}
+	if info.Subject == "" {
+		return nil, errors.New("idp: fetching identity: no subject")
+	}
+	id := &Identity{
+		Subject:       info.Subject,
+		Email:         info.Email,
+		EmailVerified: bool(info.EmailVerified) && info.Email != "",
+		Name:          info.Name,
+		Username:      info.PreferredUsername,
+	}
+	if p.GroupsClaim != "" {
+		var claims map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &claims); err != nil {
+			return nil, fmt.Errorf("idp: fetching identity: %w", err)
+		}
+		if v, ok := claims[p.GroupsClaim]; ok {
+			// The groups are either a list or, with a single group, a string.
+			if err := json.Unmarshal(v, &id.Groups); err != nil {
+				var group string
+				if json.Unmarshal(v, &group) != nil {
+					return nil, fmt.Errorf("idp: fetching identity: invalid %s claim", p.GroupsClaim)
+				}
+				id.Groups = []string{group}
+			}
+		}
+	}
+	return id, nil
+}
+
+func (p *Provider) get(ctx context.Context, url, token string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if err := p.do(req, v); err != nil {
+		return fmt.Errorf("idp: fetching identity: %w", err)
+	}
+	return nil
+}
+
+// githubIdentity returns the identity of a GitHub user, with their primary
+// (verified) email address.
+func (p *Provider) githubIdentity(ctx context.Context, token string) (*Identity, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := p.get(ctx, p.UserInfoURL, token, &user); err != nil {
+		return nil, err
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.get(ctx, p.UserInfoURL+"/emails", token, &emails); err != nil {
+		return nil, err
+	}
+
+	id := &Identity{
+		Subject:  strconv.FormatInt(user.ID, 10),
+		Name:     user.Name,
+		Username: user.Login,
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			id.Email, id.EmailVerified = e.Email, true
+		}
+	}
+	if !id.EmailVerified {
+		return nil, ErrNoEmail
+	}
+	return id, nil
+}
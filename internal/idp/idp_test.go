@@ -0,0 +1,139 @@
+package idp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// fakeProvider serves the endpoints of an OpenID Connect provider (and of the
+// GitHub API) for the user with the access token "token".
+func fakeProvider(t *testing.T) *httptest.Server {
+	var challenge string
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	writeJSON := func(w http.ResponseWriter, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	authorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"userinfo_endpoint":      srv.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		challenge = r.URL.Query().Get("code_challenge")
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != "code" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		writeJSON(w, map[string]string{"access_token": "token"})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) {
+			writeJSON(w, map[string]any{"sub": "42", "email": "jo@example.com", "email_verified": "true", "preferred_username": "jo", "groups": []string{"staff", "eng"}})
+		}
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) {
+			writeJSON(w, map[string]any{"id": 42, "login": "jo", "name": "Jo"})
+		}
+	})
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) {
+			writeJSON(w, []map[string]any{
+				{"email": "old@example.com", "primary": false, "verified": true},
+				{"email": "jo@example.com", "primary": true, "verified": true},
+			})
+		}
+	})
+	return srv
+}
+
+func TestOIDC(t *testing.T) {
+	srv := fakeProvider(t)
+	defer srv.Close()
+	ctx := context.Background()
+
+	p := OIDC("Example", srv.URL+"/", "client", "secret")
+	p.GroupsClaim = "groups"
+	verifier, _ := NewState()
+	authURL, err := p.AuthCodeURL(ctx, "https://discuit.example/callback", "state", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	if u.Path != "/authorize" || u.Query().Get("state") != "state" || u.Query().Get("client_id") != "client" {
+		t.Fatalf("AuthCodeURL: got %s", authURL)
+	}
+	if _, err := http.Get(authURL); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := NewState()
+	if _, err := p.Exchange(ctx, "https://discuit.example/callback", "code", other); err == nil {
+		t.Error("Exchange with the wrong verifier: no error")
+	}
+	token, err := p.Exchange(ctx, "https://discuit.example/callback", "code", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := p.Identity(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Subject != "42" || id.Email != "jo@example.com" || !id.EmailVerified || id.Username != "jo" || len(id.Groups) != 2 {
+		t.Errorf("Identity: got %+v", id)
+	}
+	if _, err := p.Identity(ctx, "expired"); err == nil {
+		t.Error("Identity with an invalid token: no error")
+	}
+}
+
+func TestGitHub(t *testing.T) {
+	srv := fakeProvider(t)
+	defer srv.Close()
+
+	p := GitHub("client", "secret")
+	p.UserInfoURL = srv.URL + "/user"
+	id, err := p.Identity(context.Background(), "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Subject != "42" || id.Email != "jo@example.com" || !id.EmailVerified || id.Username != "jo" || id.Name != "Jo" {
+		t.Errorf("Identity: got %+v", id)
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	srv := fakeProvider(t)
+	defer srv.Close()
+
+	// The configuration is served at the URL of a different issuer.
+	p := OIDC("Example", srv.URL, "client", "secret")
+	p.Issuer = strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	if _, err := p.AuthCodeURL(context.Background(), "https://discuit.example/callback", "state", "verifier"); err == nil {
+		t.Error("AuthCodeURL: no error")
+	}
+}
@@ -122,3 +122,9 @@ func SetImagesRootFolder(p string) {
 	}
 	filesRootFolder = p
 }
+
+// RootFolder returns the folder in which images are saved (see
+// SetImagesRootFolder).
+func RootFolder() string {
+	return filesRootFolder
+}
@@ -9,10 +9,10 @@ import (
 	"database/sql/driver"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"image"
-	"log"
 	"math"
 	"net/url"
 	"os"
@@ -23,12 +23,15 @@ import (
 	"sync"
 	"time"
 
+	"github.com/discuitnet/discuit/internal/cdn"
+	"github.com/discuitnet/discuit/internal/logging"
 	msql "github.com/discuitnet/discuit/internal/sql"
 	"github.com/discuitnet/discuit/internal/uid"
 	"github.com/h2non/bimg"
 	"golang.org/x/exp/slices"
 
-	// Register jpeg and png decoding for images pkg.
+	// Register gif, jpeg, and png decoding for images pkg.
+	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 
@@ -36,10 +39,15 @@ import (
 	_ "golang.org/x/image/webp"
 )
 
+var logger = logging.Logger("images")
+
 var (
 	// Global registered stores.
 	stores []store
 
+	// DefaultStore is the name of the store in which new images are saved.
+	DefaultStore = "disk"
+
 	// HMACKey is the key used to set the HMAC portion of an image's URL.
 	HMACKey []byte
 
@@ -63,6 +71,7 @@ var (
 	ErrBadURL                 = errors.New("bad image request url")
 	ErrImageFormatUnsupported = errors.New("image format not supported")
 	ErrImageFitUnsupported    = errors.New("invalid image fit")
+	ErrInvalidCrop            = errors.New("crop rectangle is not within the image")
 )
 
 func registerStore(s store) error {
@@ -101,6 +110,8 @@ const (
 	ImageFormatJPEG = ImageFormat("jpeg")
 	ImageFormatWEBP = ImageFormat("webp")
 	ImageFormatPNG  = ImageFormat("png")
+	ImageFormatAVIF = ImageFormat("avif")
+	ImageFormatGIF  = ImageFormat("gif")
 )
 
 // Valid reports whether f is supported by the image package.
@@ -109,6 +120,8 @@ func (f ImageFormat) Valid() bool {
 		ImageFormatJPEG,
 		ImageFormatWEBP,
 		ImageFormatPNG,
+		ImageFormatAVIF,
+		ImageFormatGIF,
 	}, f)
 }
 
@@ -125,12 +138,48 @@ func (f ImageFormat) BIMGType() (t bimg.ImageType, err error) {
 		t = bimg.WEBP
 	case ImageFormatPNG:
 		t = bimg.PNG
+	case ImageFormatAVIF:
+		t = bimg.AVIF
+	case ImageFormatGIF:
+		t = bimg.GIF
 	default:
 		err = errors.New("unsupported bimg image type")
 	}
 	return
 }
 
+// negotiableFormats are the formats, in order of preference, to which images
+// may be converted when the client accepts them.
+var negotiableFormats = []ImageFormat{ImageFormatAVIF, ImageFormatWEBP}
+
+// negotiateFormat returns the most preferred of negotiableFormats that's
+// acceptable as per the Accept header value accept (and that can be encoded by
+// libvips). If there's no such format, it returns f.
+func negotiateFormat(accept string, f ImageFormat) ImageFormat {
+	if f == ImageFormatGIF {
+		return f // Converting a GIF would drop its animation.
+	}
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		mime, params, _ := strings.Cut(part, ";")
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(mime))] = true
+	}
+	for _, nf := range negotiableFormats {
+		if !accepted["image/"+string(nf)] {
+			continue
+		}
+		if t, err := nf.BIMGType(); err == nil && bimg.IsTypeSupportedSave(t) {
+			return nf
+		}
+	}
+	return f
+}
+
 // RGB represents color values of range (0, 255). It implements sql.Scanner and
 // driver.Valuer interfaces. Use a 12-byte binary database column type to store
 // values of this type in SQL databases.
@@ -444,6 +493,13 @@ func (r *request) hashData() []byte {
 	return []byte(id + size + fit + ext)
 }
 
+// etag returns the entity tag of the image served for r. An image never
+// changes once saved, so this is derived from r alone.
+func (r *request) etag() string {
+	sum := sha256.Sum256(r.hashData())
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
 // filename returns a string of the format "{FileHash}_300x400_contain.jpeg"
 // used for storing images for caching purposes.
 func (r *request) filename() string {
@@ -501,7 +557,7 @@ func removeFromCache(image uid.ID) error {
 	folder, filename := idToFolder(image)
 	return filepath.Walk(path.Join(filesRootFolder, folder), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			log.Printf("skipping unwalkable directory: %v", err)
+			logger.Warn("Skipping unwalkable directory", "err", err)
 			return nil
 		}
 		if info.IsDir() {
@@ -509,7 +565,7 @@ func removeFromCache(image uid.ID) error {
 		}
 		base := filepath.Base(path)
 		if strings.HasPrefix(base, filename) && strings.Contains(base, "_") {
-			log.Println("deleting cached image: ", path)
+			logger.Debug("Deleting cached image", "path", path)
 			if err := os.Remove(path); err != nil {
 				return fmt.Errorf("failed to delete cached image %s: %w", image, err)
 			}
@@ -522,14 +578,14 @@ func removeFromCache(image uid.ID) error {
 func ClearCache() error {
 	return filepath.Walk(path.Join(filesRootFolder), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			log.Printf("skipping unwalkable directory: %v", err)
+			logger.Warn("Skipping unwalkable directory", "err", err)
 			return nil
 		}
 		if info.IsDir() {
 			return nil
 		}
 		if strings.Contains(filepath.Base(path), "_") {
-			log.Println("deleting cached image: ", path)
+			logger.Debug("Deleting cached image", "path", path)
 			if err := os.Remove(path); err != nil {
 				return fmt.Errorf("failed to delete cached image: %w", err)
 			}
@@ -545,7 +601,7 @@ func getImage(ctx context.Context, db *sql.DB, r *request, cacheEnabled bool) ([
 	if cacheEnabled {
 		if image, err := getCachedImage(r); err != nil {
 			if !os.IsNotExist(err) {
-				log.Printf("getCachedImage error: %v\n", err)
+				logger.ErrorContext(ctx, "Failed to get cached image", "err", err)
 			}
 			// Failed retreiving from cache, proceed.
 		} else {
@@ -553,7 +609,7 @@ func getImage(ctx context.Context, db *sql.DB, r *request, cacheEnabled bool) ([
 		}
 	}
 
-	record, err := GetImageRecord(ctx, db, r.id)
+	record, err := getServableImageRecord(ctx, db, r.id)
 	if err != nil {
 		return nil, err
 	}
@@ -587,13 +643,24 @@ func getImage(ctx context.Context, db *sql.DB, r *request, cacheEnabled bool) ([
 		image, err = defaultConverter.convert(ctx, image, r)
 		if err == nil && cacheEnabled {
 			if err := putToCache(image, r); err != nil {
-				log.Printf("putToCache error: %v\n", err)
+				logger.ErrorContext(ctx, "Failed to cache image", "err", err)
 			}
 		}
 	}
 	return image, err
 }
 
+// getServableImageRecord is like GetImageRecord except that it returns
+// ErrImageNotFound for quarantined images.
+func getServableImageRecord(ctx context.Context, db *sql.DB, id uid.ID) (*ImageRecord, error) {
+	if quarantined, err := isQuarantined(ctx, db, id); err != nil {
+		return nil, err
+	} else if quarantined {
+		return nil, ErrImageNotFound
+	}
+	return GetImageRecord(ctx, db, id)
+}
+
 type convertRequest struct {
 	request  *request
 	image    []byte
@@ -684,7 +751,7 @@ func (c *converter) convert(ctx context.Context, image []byte, r *request) ([]by
 	case res := <-req.response:
 		if time.Since(t0) > time.Millisecond*300 {
 			// Make note of requests that take too long.
-			log.Printf("converter.convert (id: %v) took %v (format: %v, size: %v, fit: %v)\n", r.id, time.Since(t0), r.format, r.size, r.fit)
+			logger.WarnContext(ctx, "Slow image conversion", "image", r.id, "took", time.Since(t0), "format", r.format, "size", r.size, "fit", r.fit)
 		}
 		return res.image, res.err
 	case <-ctx.Done():
@@ -746,11 +813,40 @@ func resizeImage(image []byte, width, height int, fit ImageFit) ([]byte, error)
 	}
 }
 
+// CropRect is a rectangle within an image, in pixels, with the origin at the
+// top-left corner of the image (after it's rotated as per its EXIF
+// orientation).
+type CropRect struct {
+	X, Y          int
+	Width, Height int
+}
+
+// crop returns the part of image within r.
+func (r CropRect) crop(image []byte) ([]byte, error) {
+	size, err := bimg.Size(image)
+	if err != nil {
+		return nil, err
+	}
+	if r.X < 0 || r.Y < 0 || r.Width <= 0 || r.Height <= 0 || r.X+r.Width > size.Width || r.Y+r.Height > size.Height {
+		return nil, ErrInvalidCrop
+	}
+	if r.X == 0 && r.Y == 0 && r.Width == size.Width && r.Height == size.Height {
+		return image, nil
+	}
+	return bimg.NewImage(image).Extract(r.Y, r.X, r.Width, r.Height)
+}
+
 // ImageOptions hold optional arguments to SaveImage.
 type ImageOptions struct {
 	Width, Height int
 	Format        ImageFormat
 	Fit           ImageFit
+	Crop          *CropRect // If non-nil, the image is cropped to it before it's resized.
+
+	// If true, the image is saved as it is, except for its metadata, which
+	// is stripped (for animated GIFs, whose animations would otherwise be
+	// lost). The fields Width, Height, Format, Fit, and Crop are ignored.
+	Unprocessed bool
 }
 
 // SaveImage saves the provided image in the image store with the name storeName
@@ -765,7 +861,7 @@ func SaveImage(ctx context.Context, db *sql.DB, storeName string, file []byte, o
 	id, err := SaveImageTx(ctx, tx, storeName, file, opts)
 	if err != nil {
 		if err := tx.Rollback(); err != nil {
-			log.Println("images.SaveImage rollback error: ", err)
+			logger.ErrorContext(ctx, "Failed to roll back transaction", "err", err)
 		}
 		return nil, err
 	}
@@ -789,9 +885,15 @@ func SaveImageTx(ctx context.Context, tx *sql.Tx, storeName string, file []byte,
 		}
 	}
 
+	result, scanner := scan(ctx, file)
+	if result.Verdict == VerdictReject {
+		logger.InfoContext(ctx, "Image rejected by scan", "scanner", scanner, "reason", result.Reason)
+		return uid.ID{}, ErrImageRejected
+	}
+
 	var img []byte
 	var err error
-	if SkipProcessing {
+	if SkipProcessing || opts.Unprocessed {
 		img = file
 		opts.Format = ImageFormat(bimg.DetermineImageTypeName(img))
 		if !opts.Format.Valid() {
@@ -812,8 +914,19 @@ func SaveImageTx(ctx context.Context, tx *sql.Tx, storeName string, file []byte,
 		}
 	}
 
-	img, err = resizeImage(img, opts.Width, opts.Height, opts.Fit)
-	if err != nil {
+	if !opts.Unprocessed {
+		if opts.Crop != nil {
+			if img, err = opts.Crop.crop(img); err != nil {
+				return uid.ID{}, err
+			}
+		}
+		if img, err = resizeImage(img, opts.Width, opts.Height, opts.Fit); err != nil {
+			return uid.ID{}, err
+		}
+	}
+	// Processed images ought to have no metadata already, but unprocessed
+	// images do, and not all versions of libvips strip everything.
+	if img, err = stripMetadata(img); err != nil {
 		return uid.ID{}, err
 	}
 	size, err := bimg.Size(img)
@@ -832,6 +945,7 @@ func SaveImageTx(ctx context.Context, tx *sql.Tx, storeName string, file []byte,
 		return uid.ID{}, err
 	}
 	averageColor := AverageColor(decodedImg)
+	phash := PerceptualHash(decodedImg)
 
 	id := uid.New()
 	query, args := msql.BuildInsertQuery("images", []msql.ColumnValue{
@@ -843,12 +957,19 @@ func SaveImageTx(ctx context.Context, tx *sql.Tx, storeName string, file []byte,
 		{Name: "size", Value: len(img)},
 		{Name: "upload_size", Value: len(file)},
 		{Name: "average_color", Value: averageColor},
+		{Name: "phash", Value: int64(phash)},
 	})
 
 	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
 		return uid.ID{}, err
 	}
 
+	if result.Verdict == VerdictQuarantine {
+		if _, err = tx.ExecContext(ctx, "INSERT INTO image_quarantine (image_id, scanner, reason) VALUES (?, ?, ?)", id, scanner, result.Reason); err != nil {
+			return uid.ID{}, err
+		}
+	}
+
 	if err = store.save(&ImageRecord{
 		ID:        id,
 		StoreName: storeName,
@@ -872,9 +993,56 @@ func DeleteImageTx(ctx context.Context, tx *sql.Tx, db *sql.DB, image uid.ID) er
 
 	// Attempt to remove images from cache. Continue even on failure.
 	if err := removeFromCache(image); err != nil {
-		log.Printf("error removing images from cache on image id %v", err)
+		logger.ErrorContext(ctx, "Failed to remove image from cache", "err", err, "image", image)
 	}
+	cdn.Purge(ctx, cdn.Key("image", image))
 
+	if _, err = tx.ExecContext(ctx, "DELETE FROM image_quarantine WHERE image_id = ?", image); err != nil {
+		return err
+	}
 	_, err = tx.ExecContext(ctx, "DELETE FROM images WHERE id = ?", image)
 	return err
 }
+
+// MoveImages moves all images in the store named from to the store named to,
+// and returns the number of images moved. Images are copied to the new store
+// before their records are updated, so a failed run may be safely resumed.
+func MoveImages(ctx context.Context, db *sql.DB, from, to string) (int, error) {
+	src, dst := matchStore(from), matchStore(to)
+	if src == nil || dst == nil {
+		return 0, ErrStoreNotRegistered
+	}
+
+	moved := 0
+	for {
+		query := msql.BuildSelectQuery("images", imageRecordSelectColumns, nil, "WHERE store_name = ? ORDER BY id LIMIT 100")
+		rows, err := db.QueryContext(ctx, query, from)
+		if err != nil {
+			return moved, err
+		}
+		records, err := scanImageRecords(db, rows)
+		if err != nil {
+			return moved, err
+		}
+		if len(records) == 0 {
+			return moved, nil
+		}
+
+		for _, record := range records {
+			image, err := src.get(record)
+			if err != nil {
+				return moved, err
+			}
+			if err := dst.save(record, image); err != nil {
+				return moved, fmt.Errorf("error saving image %v: %w", record.ID, err)
+			}
+			if _, err := db.ExecContext(ctx, "UPDATE images SET store_name = ? WHERE id = ?", to, record.ID); err != nil {
+				return moved, err
+			}
+			if err := src.delete(record); err != nil {
+				logger.WarnContext(ctx, "Failed to delete moved image from the old store", "image", record.ID, "store", from, "err", err)
+			}
+			moved++
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/h2non/bimg"
 )
 
 func init() {
@@ -150,3 +151,25 @@ func TestRGBUnmarshal(t *testing.T) {
 		}
 	}
 }
+
+func TestNegotiateFormat(t *testing.T) {
+	best := ImageFormatWEBP
+	if bimg.IsTypeSupportedSave(bimg.AVIF) {
+		best = ImageFormatAVIF
+	}
+	cases := []struct {
+		accept string
+		want   ImageFormat
+	}{
+		{"", ImageFormatJPEG},
+		{"text/html,*/*;q=0.8", ImageFormatJPEG},
+		{"image/webp;q=0,image/png", ImageFormatJPEG},
+		{"image/webp,image/apng,*/*;q=0.8", ImageFormatWEBP},
+		{"image/avif, image/webp;q=0.9, */*", best},
+	}
+	for _, c := range cases {
+		if got := negotiateFormat(c.accept, ImageFormatJPEG); got != c.want {
+			t.Errorf("accept %q: got %v, want %v", c.accept, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformedImage = errors.New("malformed image")
+
+// stripMetadata removes the metadata (EXIF, XMP, IPTC, comments, and the like,
+// which may include the location where a photo was taken) from JPEG, PNG,
+// WebP, and GIF images, without re-encoding them. Color profiles are kept. Images of
+// other formats are returned as they are.
+func stripMetadata(image []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(image, []byte{0xFF, 0xD8}):
+		return stripJPEGMetadata(image)
+	case bytes.HasPrefix(image, pngSignature):
+		return stripPNGMetadata(image)
+	case len(image) >= 12 && string(image[:4]) == "RIFF" && string(image[8:12]) == "WEBP":
+		return stripWebPMetadata(image)
+	case bytes.HasPrefix(image, []byte("GIF87a")), bytes.HasPrefix(image, []byte("GIF89a")):
+		return stripGIFMetadata(image)
+	}
+	return image, nil
+}
+
+func stripJPEGMetadata(image []byte) ([]byte, error) {
+	out := make([]byte, 0, len(image))
+	out = append(out, image[:2]...)
+	i := 2
+	for {
+		if i+4 > len(image) || image[i] != 0xFF {
+			return nil, errMalformedImage
+		}
+		marker := image[i+1]
+		if marker == 0xFF {
+			i++ // Fill byte.
+			continue
+		}
+		if marker == 0xD9 || (0xD0 <= marker && marker <= 0xD7) || marker == 0x01 {
+			// Markers without a payload.
+			out = append(out, image[i:i+2]...)
+			i += 2
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(image[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(image) {
+			return nil, errMalformedImage
+		}
+		if marker == 0xDA {
+			// Start of scan: the rest is image data.
+			return append(out, image[i:]...), nil
+		}
+		switch {
+		case marker == 0xE0, marker == 0xE2, marker == 0xEE:
+			// JFIF, ICC profile, and Adobe (needed to get colors right).
+			out = append(out, image[i:end]...)
+		case 0xE1 <= marker && marker <= 0xEF, marker == 0xFE:
+			// Other application segments (EXIF, XMP, IPTC, etc) and comments.
+		default:
+			out = append(out, image[i:end]...)
+		}
+		i = end
+	}
+}
+
+var pngSignature = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+
+// pngMetadataChunks are the types of PNG chunks that are removed.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+func stripPNGMetadata(image []byte) ([]byte, error) {
+	out := make([]byte, 0, len(image))
+	out = append(out, pngSignature...)
+	for i := len(pngSignature); i < len(image); {
+		if i+12 > len(image) {
+			return nil, errMalformedImage
+		}
+		length := int(binary.BigEndian.Uint32(image[i:]))
+		end := i + 12 + length // Length, type, data, and CRC.
+		if length < 0 || end > len(image) {
+			return nil, errMalformedImage
+		}
+		if !pngMetadataChunks[string(image[i+4:i+8])] {
+			out = append(out, image[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
+
+func stripWebPMetadata(image []byte) ([]byte, error) {
+	out := make([]byte, 0, len(image))
+	out = append(out, image[:12]...)
+	vp8x := -1 // Offset of the VP8X chunk in out.
+	for i := 12; i < len(image); {
+		if i+8 > len(image) {
+			return nil, errMalformedImage
+		}
+		fourCC := string(image[i : i+4])
+		size := int(binary.LittleEndian.Uint32(image[i+4:]))
+		end := i + 8 + size + size%2 // Chunks are padded to an even size.
+		if size < 0 || end > len(image) {
+			if i+8+size != len(image) {
+				return nil, errMalformedImage
+			}
+			end = len(image) // The last chunk is missing its padding.
+		}
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			vp8x = len(out)
+			out = append(out, image[i:end]...)
+		default:
+			out = append(out, image[i:end]...)
+		}
+		i = end
+	}
+	if vp8x != -1 && vp8x+8 < len(out) {
+		// Clear the EXIF and XMP flags.
+		out[vp8x+8] &^= 0x08 | 0x04
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// gifSubBlocksEnd returns the index after the sequence of data sub-blocks that
+// begins at i.
+func gifSubBlocksEnd(image []byte, i int) (int, error) {
+	for {
+		if i >= len(image) {
+			return 0, errMalformedImage
+		}
+		size := int(image[i])
+		i++
+		if size == 0 {
+			return i, nil
+		}
+		i += size
+	}
+}
+
+func stripGIFMetadata(image []byte) ([]byte, error) {
+	if len(image) < 13 {
+		return nil, errMalformedImage
+	}
+	i := 13 // Header and logical screen descriptor.
+	if flags := image[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1) // Global color table.
+	}
+	if i > len(image) {
+		return nil, errMalformedImage
+	}
+	out := make([]byte, 0, len(image))
+	out = append(out, image[:i]...)
+	for {
+		if i >= len(image) {
+			return nil, errMalformedImage
+		}
+		start := i
+		switch image[i] {
+		case 0x3B: // Trailer.
+			return append(out, image[i]), nil
+		case 0x2C: // Image descriptor.
+			if i+10 > len(image) {
+				return nil, errMalformedImage
+			}
+			flags := image[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1) // Local color table.
+			}
+			end, err := gifSubBlocksEnd(image, i+1) // After the LZW minimum code size.
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, image[start:end]...)
+			i = end
+		case 0x21: // Extension.
+			if i+2 > len(image) {
+				return nil, errMalformedImage
+			}
+			label := image[i+1]
+			end, err := gifSubBlocksEnd(image, i+2)
+			if err != nil {
+				return nil, err
+			}
+			keep := true
+			switch label {
+			case 0xFE: // Comment.
+				keep = false
+			case 0xFF: // Application (kept only if it's the looping extension).
+				keep = i+14 <= len(image) && image[i+2] == 11 &&
+					(string(image[i+3:i+14]) == "NETSCAPE2.0" || string(image[i+3:i+14]) == "ANIMEXTS1.0")
+			}
+			if keep {
+				out = append(out, image[start:end]...)
+			}
+			i = end
+		default:
+			return nil, errMalformedImage
+		}
+	}
+}
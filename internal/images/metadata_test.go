@@ -0,0 +1,119 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func jpegSegment(marker byte, payload string) []byte {
+	b := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(payload)+2))
+	return append(b, payload...)
+}
+
+func pngChunk(typ, data string) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	b = append(b, typ...)
+	b = append(b, data...)
+	return append(b, 0, 0, 0, 0) // CRC (not checked).
+}
+
+func webpChunk(fourCC, data string) []byte {
+	b := []byte(fourCC)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(data)))
+	b = append(b, data...)
+	if len(data)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func gifBlock(label byte, subBlocks ...string) []byte {
+	b := []byte{0x21, label}
+	for _, data := range subBlocks {
+		b = append(append(b, byte(len(data))), data...)
+	}
+	return append(b, 0)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestStripMetadata(t *testing.T) {
+	jpegHead := []byte{0xFF, 0xD8}
+	jfif := jpegSegment(0xE0, "JFIF\x00\x01\x02")
+	icc := jpegSegment(0xE2, "ICC_PROFILE\x00")
+	quant := jpegSegment(0xDB, "\x00quant")
+	scan := concat(jpegSegment(0xDA, "\x01sos"), []byte{0x12, 0xFF, 0x00, 0x34, 0xFF, 0xD9})
+
+	// A 1x1 GIF with a 2-color global color table.
+	gifHead := concat([]byte("GIF89a\x01\x00\x01\x00\x80\x00\x00"), make([]byte, 6))
+	gifFrame := concat(gifBlock(0xF9, "\x04\x00\x00\x00"), []byte("\x2C\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02\x44\x01\x00"))
+
+	webpHeader := func(chunks ...[]byte) []byte {
+		body := concat(append([][]byte{[]byte("WEBP")}, chunks...)...)
+		return concat([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body))), body)
+	}
+
+	cases := []struct {
+		name      string
+		in, want  []byte
+		wantError bool
+	}{
+		{
+			name: "jpeg",
+			in:   concat(jpegHead, jfif, jpegSegment(0xE1, "Exif\x00\x00GPS"), icc, jpegSegment(0xFE, "comment"), jpegSegment(0xED, "IPTC"), quant, scan),
+			want: concat(jpegHead, jfif, icc, quant, scan),
+		},
+		{
+			name:      "truncated jpeg",
+			in:        concat(jpegHead, jfif[:5]),
+			wantError: true,
+		},
+		{
+			name: "png",
+			in:   concat(pngSignature, pngChunk("IHDR", "header"), pngChunk("eXIf", "GPS"), pngChunk("tEXt", "Author\x00me"), pngChunk("IDAT", "data"), pngChunk("IEND", "")),
+			want: concat(pngSignature, pngChunk("IHDR", "header"), pngChunk("IDAT", "data"), pngChunk("IEND", "")),
+		},
+		{
+			name: "webp",
+			in:   webpHeader(webpChunk("VP8X", "\x0C\x00\x00\x00"), webpChunk("VP8 ", "frame"), webpChunk("EXIF", "GPS"), webpChunk("XMP ", "<x/>")),
+			want: webpHeader(webpChunk("VP8X", "\x00\x00\x00\x00"), webpChunk("VP8 ", "frame")),
+		},
+		{
+			name: "gif",
+			in: concat(gifHead, gifBlock(0xFF, "NETSCAPE2.0", "\x01\x00\x00"), gifBlock(0xFF, "XMP DataXMP", "<x/>"),
+				gifBlock(0xFE, "comment"), gifFrame, []byte{0x3B}),
+			want: concat(gifHead, gifBlock(0xFF, "NETSCAPE2.0", "\x01\x00\x00"), gifFrame, []byte{0x3B}),
+		},
+		{
+			name:      "truncated gif",
+			in:        concat(gifHead, gifFrame[:5]),
+			wantError: true,
+		},
+		{
+			name: "unknown",
+			in:   []byte("BM\x00\x00"),
+			want: []byte("BM\x00\x00"),
+		},
+	}
+	for _, c := range cases {
+		got, err := stripMetadata(c.in)
+		if c.wantError {
+			if err == nil {
+				t.Errorf("%s: expected an error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !bytes.Equal(got, c.want) {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
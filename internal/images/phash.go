@@ -0,0 +1,51 @@
+package images
+
+import (
+	"image"
+	"math/bits"
+)
+
+// PerceptualHash returns the difference hash (dHash) of img: img is shrunk to
+// 9x8 grayscale cells, and each of the 64 bits of the hash is set if a cell is
+// brighter than the one to its right. Images that look alike (the same image
+// resized, recompressed, or slightly edited) have hashes that differ in only a
+// few bits. Each cell is the average of at most 8x8 pixels sampled from it.
+func PerceptualHash(img image.Image) uint64 {
+	const cols, rows, samples = 9, 8, 8
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return 0
+	}
+
+	var cells [rows][cols]float64
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			var sum float64
+			for j := 0; j < samples; j++ {
+				py := bounds.Min.Y + (y*samples+j)*bounds.Dy()/(rows*samples)
+				for i := 0; i < samples; i++ {
+					px := bounds.Min.X + (x*samples+i)*bounds.Dx()/(cols*samples)
+					r, g, b, _ := img.At(px, py).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			cells[y][x] = sum
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols-1; x++ {
+			if cells[y][x] > cells[y][x+1] {
+				hash |= 1 << (y*(cols-1) + x)
+			}
+		}
+	}
+	return hash
+}
+
+// HashDistance returns the number of bits in which the perceptual hashes a and
+// b differ.
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,48 @@
+package images
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// gradient returns a width by height image whose brightness follows a
+// pattern that's independent of its size.
+func gradient(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8((x*255/width + (y*255/height)/3) % 256)
+			if (x*7/width)%2 == 1 {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHash(t *testing.T) {
+	a := PerceptualHash(gradient(400, 300))
+	if a == 0 {
+		t.Fatal("hash of a non-uniform image is zero")
+	}
+	if d := HashDistance(a, PerceptualHash(gradient(1200, 900))); d > 4 {
+		t.Errorf("hashes of the same image at different sizes differ in %d bits", d)
+	}
+
+	flipped := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	src := gradient(400, 300)
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 400; x++ {
+			flipped.Set(399-x, y, src.At(x, y))
+		}
+	}
+	if d := HashDistance(a, PerceptualHash(flipped)); d < 16 {
+		t.Errorf("hashes of different images differ in only %d bits", d)
+	}
+
+	if h := PerceptualHash(image.NewRGBA(image.Rect(0, 0, 0, 0))); h != 0 {
+		t.Errorf("hash of an empty image is %x, want 0", h)
+	}
+}
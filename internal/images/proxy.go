@@ -0,0 +1,255 @@
+package images
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ProxyEnabled is whether the external images in the Markdown of posts and
+// comments are served through the image proxy (see ProxyURL and Proxy).
+var ProxyEnabled bool
+
+// proxyFolder is the folder, in the images root folder, in which proxied
+// images are cached.
+const proxyFolder = "proxy"
+
+// proxyContentTypes are the types of images served by the image proxy (SVG
+// images, which may have scripts, are not).
+var proxyContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+	"image/bmp":  true,
+}
+
+// proxySignature returns the signature of the image proxy URL of src.
+func proxySignature(src string) string {
+	hm := hmac.New(sha256.New, HMACKey)
+	hm.Write([]byte("proxy\n" + src))
+	return base64.RawURLEncoding.EncodeToString(hm.Sum(nil))
+}
+
+// ProxyURL returns the URL at which the external image at src is served by
+// the image proxy, or src itself if the proxy is off or src is not an HTTP(S)
+// URL.
+func ProxyURL(src string) string {
+	if !ProxyEnabled || HMACKey == nil {
+		return src
+	}
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return src
+	}
+	v := url.Values{}
+	v.Set("url", src)
+	v.Set("sig", proxySignature(src))
+	return "/images/proxy?" + v.Encode()
+}
+
+// Proxy is an http.Handler that serves external images (at the URLs returned
+// by ProxyURL), so that the IP addresses of readers are hidden from the hosts
+// of the images, and so that pages served over HTTPS have no insecure
+// content. Fetched images are cached on disk for CacheTTL.
+//
+// Set HMACKey before this handler is used.
+type Proxy struct {
+	MaxSize  int64 // In bytes.
+	CacheTTL time.Duration
+}
+
+// errProxyTooLarge is returned by fetch for images larger than the maximum
+// size.
+var errProxyTooLarge = errors.New("images: proxied image too large")
+
+// proxyClient is the HTTP client with which the image proxy fetches images. It
+// doesn't connect to private or local addresses.
+var proxyClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(host)
+				if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+					return fmt.Errorf("images: proxying images from %s is not allowed", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		MaxIdleConns:        50,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 3 {
+			return errors.New("images: too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return errors.New("images: redirect to a non-HTTP URL")
+		}
+		return nil
+	},
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	src := r.URL.Query().Get("url")
+	if src == "" || !hmac.Equal([]byte(r.URL.Query().Get("sig")), []byte(proxySignature(src))) {
+		p.writeError(w, http.StatusBadRequest, "Bad signature")
+		return
+	}
+
+	image, contentType, err := p.cached(src)
+	if err != nil {
+		image, contentType, err = p.fetch(r.Context(), src)
+		if err != nil {
+			status := http.StatusBadGateway
+			if errors.Is(err, errProxyTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			p.writeError(w, status, "")
+			logger.Warn("Failed to proxy image", "url", src, "err", err)
+			return
+		}
+		if err := p.cache(src, image); err != nil {
+			logger.Error("Failed to cache proxied image", "url", src, "err", err)
+		}
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(image)))
+	h.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(p.CacheTTL.Seconds())))
+	h.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.Write(image)
+}
+
+// fetch fetches the image at src, checking its size and type.
+func (p *Proxy) fetch(ctx context.Context, src string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; image proxy)")
+	req.Header.Set("Accept", "image/*")
+	res, err := proxyClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("images: proxied image fetch: status %d", res.StatusCode)
+	}
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "image/") {
+		return nil, "", fmt.Errorf("images: proxied image has content type %q", res.Header.Get("Content-Type"))
+	}
+	if res.ContentLength > p.MaxSize {
+		return nil, "", errProxyTooLarge
+	}
+	image, err := io.ReadAll(io.LimitReader(res.Body, p.MaxSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(image)) > p.MaxSize {
+		return nil, "", errProxyTooLarge
+	}
+	contentType, err := proxyContentType(image)
+	if err != nil {
+		return nil, "", err
+	}
+	return image, contentType, nil
+}
+
+// proxyContentType returns the content type of image (as sniffed from its
+// contents, not as told by the host) if it's one of proxyContentTypes.
+func proxyContentType(image []byte) (string, error) {
+	contentType := http.DetectContentType(image)
+	if !proxyContentTypes[contentType] {
+		return "", fmt.Errorf("images: proxied image is of type %s", contentType)
+	}
+	return contentType, nil
+}
+
+// cacheFile returns the path of the file in which the image at src is cached.
+func (p *Proxy) cacheFile(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	name := hex.EncodeToString(sum[:])
+	return path.Join(filesRootFolder, proxyFolder, name[:2], name)
+}
+
+// cached returns the image at src from the cache, if it's there and has not
+// expired.
+func (p *Proxy) cached(src string) ([]byte, string, error) {
+	file := p.cacheFile(src)
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, "", err
+	}
+	if time.Since(info.ModTime()) > p.CacheTTL {
+		return nil, "", fs.ErrNotExist
+	}
+	image, err := os.ReadFile(file)
+	if err != nil {
+		return nil, "", err
+	}
+	contentType, err := proxyContentType(image)
+	return image, contentType, err
+}
+
+// cache saves image, the image at src, to the cache.
+func (p *Proxy) cache(src string, image []byte) error {
+	file := p.cacheFile(src)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, image, 0644)
+}
+
+// PruneProxyCache deletes the images cached by the image proxy more than ttl
+// ago.
+func PruneProxyCache(ttl time.Duration) error {
+	root := path.Join(filesRootFolder, proxyFolder)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && time.Since(info.ModTime()) > ttl {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (p *Proxy) writeError(w http.ResponseWriter, statusCode int, message string) {
+	w.WriteHeader(statusCode)
+	if message == "" {
+		message = http.StatusText(statusCode)
+	}
+	io.WriteString(w, message)
+}
@@ -0,0 +1,64 @@
+package images
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestProxyURL(t *testing.T) {
+	HMACKey, ProxyEnabled = []byte("secret"), true
+	defer func() { HMACKey, ProxyEnabled = nil, false }()
+
+	src := "http://example.com/a.png?b=c"
+	u, err := url.Parse(ProxyURL(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/images/proxy" || u.Query().Get("url") != src || u.Query().Get("sig") != proxySignature(src) {
+		t.Errorf("ProxyURL(%q) = %s", src, u)
+	}
+	for _, src := range []string{"/images/a.png", "data:image/png;base64,AAAA", "javascript:alert(1)"} {
+		if got := ProxyURL(src); got != src {
+			t.Errorf("ProxyURL(%q) = %q (want it unchanged)", src, got)
+		}
+	}
+}
+
+func TestProxyRefuses(t *testing.T) {
+	HMACKey = []byte("secret")
+	defer func() { HMACKey = nil }()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	}))
+	defer origin.Close()
+
+	p := &Proxy{MaxSize: 1 << 20, CacheTTL: time.Hour}
+	tests := []struct {
+		name, query string
+		status      int
+	}{
+		{"bad signature", "?url=" + url.QueryEscape(origin.URL) + "&sig=x", http.StatusBadRequest},
+		{"private address", "?url=" + url.QueryEscape(origin.URL) + "&sig=" + proxySignature(origin.URL), http.StatusBadGateway},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, httptest.NewRequest("GET", "/images/proxy"+test.query, nil))
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, test.status)
+		}
+	}
+}
+
+func TestProxyContentType(t *testing.T) {
+	if _, err := proxyContentType([]byte("\x89PNG\r\n\x1a\n")); err != nil {
+		t.Errorf("PNG refused: %v", err)
+	}
+	if _, err := proxyContentType([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`)); err == nil {
+		t.Error("SVG not refused")
+	}
+}
@@ -149,6 +149,17 @@ func (r *ImageRecord) StoreExists() bool {
 	return r.store() != nil
 }
 
+// SignedURL returns a URL, valid for the duration expires, with which the
+// image r may be fetched directly from its store. It returns an empty string if
+// the store of r does not support signed URLs (as is the case with the disk
+// store).
+func (r *ImageRecord) SignedURL(expires time.Duration) (string, error) {
+	if s, ok := r.store().(signer); ok {
+		return s.signedURL(r, expires)
+	}
+	return "", nil
+}
+
 func (r *ImageRecord) Image() *Image {
 	m := NewImage()
 	*m.ID = r.ID
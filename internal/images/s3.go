@@ -0,0 +1,333 @@
+package images
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Options are the options of an S3-compatible (AWS S3, MinIO, R2, etc)
+// bucket in which to store images.
+type S3Options struct {
+	Endpoint  string // Ex: https://s3.us-east-1.amazonaws.com or http://localhost:9000.
+	Region    string // Defaults to us-east-1.
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// If true, the bucket is addressed in the path of the URL rather than as a
+	// subdomain of the endpoint (as is usually the case with MinIO).
+	PathStyle bool
+
+	// Prefix is prepended to the keys of all objects.
+	Prefix string
+}
+
+// s3Store implements the interface store. Objects are laid out just as files
+// are with diskStore (ex: "prefix/a7/e/{hash}.jpeg"), and requests are signed
+// with AWS Signature Version 4.
+type s3Store struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func newS3Store(opts S3Options) (*s3Store, error) {
+	if opts.Bucket == "" {
+		return nil, errors.New("s3 store: bucket name is empty")
+	}
+	if opts.AccessKey == "" || opts.SecretKey == "" {
+		return nil, errors.New("s3 store: access key or secret key is empty")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("s3 store: invalid endpoint %q", opts.Endpoint)
+	}
+	opts.Prefix = strings.Trim(opts.Prefix, "/")
+	return &s3Store{
+		opts:     opts,
+		endpoint: u,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// RegisterS3Store registers a store, with the name "s3", that saves images to
+// the bucket of opts.
+func RegisterS3Store(opts S3Options) error {
+	s, err := newS3Store(opts)
+	if err != nil {
+		return err
+	}
+	return registerStore(s)
+}
+
+func (s *s3Store) name() string {
+	return "s3"
+}
+
+// key returns the key of the object of the image r.
+func (s *s3Store) key(r *ImageRecord) string {
+	folder, filename := idToFolder(r.ID)
+	key := folder + "/" + filename + r.Format.Extension()
+	if s.opts.Prefix != "" {
+		key = s.opts.Prefix + "/" + key
+	}
+	return key
+}
+
+// objectURL returns the URL of the object with key.
+func (s *s3Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	u.RawQuery = ""
+	if s.opts.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.opts.Bucket + "/" + key
+	} else {
+		u.Host = s.opts.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	return &u
+}
+
+func (s *s3Store) do(method string, r *ImageRecord, body []byte) (*http.Response, error) {
+	contentType := ""
+	if body != nil {
+		contentType = "image/" + string(r.Format)
+	}
+	return s.doKey(method, s.key(r), contentType, body)
+}
+
+func (s *s3Store) doKey(method, key, contentType string, body []byte) (*http.Response, error) {
+	u := s.objectURL(key)
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, body, time.Now())
+	return s.client.Do(req)
+}
+
+// PutS3Object saves data, as the object with key (prepended with opts.Prefix),
+// in the bucket of opts. It's for files other than images that are kept in the
+// same bucket.
+func PutS3Object(opts S3Options, key, contentType string, data []byte) error {
+	s, err := newS3Store(opts)
+	if err != nil {
+		return err
+	}
+	if s.opts.Prefix != "" {
+		key = s.opts.Prefix + "/" + key
+	}
+	res, err := s.doKey("PUT", key, contentType, data)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("s3: put %s: status %d: %s", key, res.StatusCode, bytes.TrimSpace(data))
+	}
+	return nil
+}
+
+// responseError returns an error describing an unsuccessful response.
+func responseError(op string, r *ImageRecord, res *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	return fmt.Errorf("s3 store: %s image %v: status %d: %s", op, r.ID, res.StatusCode, bytes.TrimSpace(data))
+}
+
+func (s *s3Store) get(r *ImageRecord) ([]byte, error) {
+	res, err := s.do("GET", r, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("get image %v: %w", r.ID, ErrImageNotFound)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, responseError("get", r, res)
+	}
+	return io.ReadAll(res.Body)
+}
+
+func (s *s3Store) save(r *ImageRecord, image []byte) error {
+	res, err := s.do("PUT", r, image)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return responseError("save", r, res)
+	}
+	return nil
+}
+
+func (s *s3Store) delete(r *ImageRecord) error {
+	res, err := s.do("DELETE", r, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// S3 responds with 204 even if the object doesn't exist.
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotFound {
+		return responseError("delete", r, res)
+	}
+	return nil
+}
+
+// signedURL returns a presigned URL, valid for the duration expires, with
+// which the image r can be fetched directly from the bucket.
+func (s *s3Store) signedURL(r *ImageRecord, expires time.Duration) (string, error) {
+	return s.presign(s.objectURL(s.key(r)), expires, time.Now()), nil
+}
+
+// A signer is a store from which images can be fetched directly, using a URL
+// that expires.
+type signer interface {
+	signedURL(r *ImageRecord, expires time.Duration) (string, error)
+}
+
+const (
+	sigV4Algorithm       = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat      = "20060102T150405Z"
+	sigV4UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// sign adds the headers of AWS Signature Version 4 to req.
+func (s *s3Store) sign(req *http.Request, body []byte, t time.Time) {
+	t = t.UTC()
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", t.Format(sigV4TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           t.Format(sigV4TimeFormat),
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		headers["content-type"] = ct
+	}
+	signedHeaders, signature := s.signature(req.Method, req.URL, headers, payloadHash, t)
+	req.Header.Set("Authorization", sigV4Algorithm+" Credential="+s.opts.AccessKey+"/"+s.scope(t)+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// presign returns u with the query parameters of a presigned GET request.
+func (s *s3Store) presign(u *url.URL, expires time.Duration, t time.Time) string {
+	t = t.UTC()
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", sigV4Algorithm)
+	query.Set("X-Amz-Credential", s.opts.AccessKey+"/"+s.scope(t))
+	query.Set("X-Amz-Date", t.Format(sigV4TimeFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	v := *u
+	v.RawQuery = canonicalQuery(query)
+	_, signature := s.signature("GET", &v, map[string]string{"host": v.Host}, sigV4UnsignedPayload, t)
+	v.RawQuery += "&X-Amz-Signature=" + signature
+	return v.String()
+}
+
+// scope returns the credential scope of a request signed at t.
+func (s *s3Store) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.opts.Region + "/s3/aws4_request"
+}
+
+// signature returns the list of signed headers and the signature of a request.
+// The keys of headers must be lower case.
+func (s *s3Store) signature(method string, u *url.URL, headers map[string]string, payloadHash string, t time.Time) (signedHeaders, signature string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders = strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		awsEscape(u.Path, false),
+		u.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		t.Format(sigV4TimeFormat),
+		s.scope(t),
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.opts.SecretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalQuery returns the query string of v sorted by key and escaped as
+// per AWS's rules.
+func canonicalQuery(v url.Values) string {
+	keys := make([]string, 0, len(v))
+	for key := range v {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		for _, value := range v[key] {
+			parts = append(parts, awsEscape(key, true)+"="+awsEscape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes all characters of s other than the unreserved
+// ones (and, if encodeSlash is false, forward slashes).
+func awsEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
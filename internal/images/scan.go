@@ -0,0 +1,288 @@
+package images
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// ErrImageRejected is returned by SaveImageTx if a scanner rejects the image.
+var ErrImageRejected = errors.New("image rejected by content scan")
+
+// A Verdict is the outcome of scanning an image.
+type Verdict int
+
+// Verdicts, in increasing order of severity.
+const (
+	VerdictAllow      = Verdict(iota)
+	VerdictQuarantine // Saved, but not served until an admin approves it.
+	VerdictReject     // Not saved.
+)
+
+// MarshalText implements encoding.TextMarshaler interface.
+func (v Verdict) MarshalText() ([]byte, error) {
+	switch v {
+	case VerdictAllow:
+		return []byte("allow"), nil
+	case VerdictQuarantine:
+		return []byte("quarantine"), nil
+	case VerdictReject:
+		return []byte("reject"), nil
+	}
+	return nil, fmt.Errorf("invalid verdict %d", int(v))
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler interface.
+func (v *Verdict) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "allow":
+		*v = VerdictAllow
+	case "quarantine":
+		*v = VerdictQuarantine
+	case "reject":
+		*v = VerdictReject
+	default:
+		return fmt.Errorf("invalid verdict %q", string(text))
+	}
+	return nil
+}
+
+// ScanResult is the result of scanning an image.
+type ScanResult struct {
+	Verdict Verdict `json:"verdict"`
+	Reason  string  `json:"reason"`
+}
+
+// A Scanner inspects uploaded images (as uploaded, before they're processed)
+// for content that's not allowed on the site.
+type Scanner interface {
+	Name() string
+	Scan(ctx context.Context, image []byte) (ScanResult, error)
+}
+
+var scanners []Scanner
+
+// RegisterScanner adds s to the scanners run on all images saved with
+// SaveImageTx.
+func RegisterScanner(s Scanner) {
+	scanners = append(scanners, s)
+}
+
+// scan runs all registered scanners on image and returns the most severe
+// result, along with the name of the scanner that returned it. An image that
+// fails to be scanned is quarantined.
+func scan(ctx context.Context, image []byte) (result ScanResult, scanner string) {
+	for _, s := range scanners {
+		res, err := s.Scan(ctx, image)
+		if err != nil {
+			logger.ErrorContext(ctx, "Image scan failed", "scanner", s.Name(), "err", err)
+			res = ScanResult{Verdict: VerdictQuarantine, Reason: "Scan failed."}
+		}
+		if res.Verdict > result.Verdict {
+			result, scanner = res, s.Name()
+		}
+		if result.Verdict == VerdictReject {
+			break
+		}
+	}
+	return
+}
+
+// HashListScanner matches the SHA-256 hashes of images against a list of
+// known hashes.
+type HashListScanner struct {
+	hashes  map[[sha256.Size]byte]bool
+	verdict Verdict
+}
+
+// NewHashListScanner returns a HashListScanner with the hex-encoded hashes in
+// the file at path (one per line; blank lines and lines that begin with # are
+// ignored). Matching images get the verdict v.
+func NewHashListScanner(path string, v Verdict) (*HashListScanner, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := &HashListScanner{hashes: make(map[[sha256.Size]byte]bool), verdict: v}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var hash [sha256.Size]byte
+		if b, err := hex.DecodeString(line); err != nil || len(b) != len(hash) {
+			return nil, fmt.Errorf("%s:%d: invalid SHA-256 hash", path, n)
+		} else {
+			copy(hash[:], b)
+		}
+		s.hashes[hash] = true
+	}
+	return s, scanner.Err()
+}
+
+func (s *HashListScanner) Name() string {
+	return "hashlist"
+}
+
+func (s *HashListScanner) Scan(ctx context.Context, image []byte) (ScanResult, error) {
+	if s.hashes[sha256.Sum256(image)] {
+		return ScanResult{Verdict: s.verdict, Reason: "Image matches a known hash."}, nil
+	}
+	return ScanResult{}, nil
+}
+
+// HTTPScanner sends images to an external classifier. The image is POSTed as
+// the request body, and the response is expected to be a JSON ScanResult (ex:
+// {"verdict": "quarantine", "reason": "Possibly explicit."}).
+type HTTPScanner struct {
+	URL    string
+	Client *http.Client // If nil, a client with a 10 second timeout is used.
+}
+
+var defaultScanClient = &http.Client{Timeout: 10 * time.Second}
+
+func (s *HTTPScanner) Name() string {
+	return "http"
+}
+
+func (s *HTTPScanner) Scan(ctx context.Context, image []byte) (ScanResult, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", s.URL, bytes.NewReader(image))
+	if err != nil {
+		return ScanResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	client := s.Client
+	if client == nil {
+		client = defaultScanClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return ScanResult{}, fmt.Errorf("image scanner %s responded with status %d", s.URL, res.StatusCode)
+	}
+	var result ScanResult
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&result); err != nil {
+		return ScanResult{}, fmt.Errorf("image scanner %s: %w", s.URL, err)
+	}
+	return result, nil
+}
+
+// QuarantinedImage is an image withheld, by a scanner, until an admin reviews
+// it.
+//
+// Table name: image_quarantine.
+type QuarantinedImage struct {
+	Image      *Image        `json:"image"` // Its URL is not served.
+	Scanner    string        `json:"scanner"`
+	Reason     string        `json:"reason"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	Rejected   bool          `json:"rejected"`
+	ReviewedBy uid.NullID    `json:"reviewedBy"`
+	ReviewedAt msql.NullTime `json:"reviewedAt"`
+}
+
+// GetQuarantinedImages returns the images pending review (and, if
+// withRejected is true, also the images rejected by admins), newest first.
+func GetQuarantinedImages(ctx context.Context, db *sql.DB, withRejected bool) ([]*QuarantinedImage, error) {
+	where := "WHERE image_quarantine.rejected = FALSE"
+	if withRejected {
+		where = ""
+	}
+	cols := append(ImageRecordColumns(),
+		"image_quarantine.scanner",
+		"image_quarantine.reason",
+		"image_quarantine.created_at",
+		"image_quarantine.rejected",
+		"image_quarantine.reviewed_by",
+		"image_quarantine.reviewed_at")
+	query := msql.BuildSelectQuery("image_quarantine", cols, []string{"INNER JOIN images ON images.id = image_quarantine.image_id"},
+		where+" ORDER BY image_quarantine.created_at DESC LIMIT 500")
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*QuarantinedImage{}
+	for rows.Next() {
+		record, item := &ImageRecord{}, &QuarantinedImage{}
+		var reason sql.NullString
+		dests := append(record.ScanDestinations(), &item.Scanner, &reason, &item.CreatedAt, &item.Rejected, &item.ReviewedBy, &item.ReviewedAt)
+		if err := rows.Scan(dests...); err != nil {
+			return nil, err
+		}
+		item.Reason = reason.String
+		item.Image = record.Image()
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// ReviewQuarantinedImage releases the quarantined image, if approve is true,
+// or otherwise keeps it withheld for good.
+func ReviewQuarantinedImage(ctx context.Context, db *sql.DB, image uid.ID, approve bool, reviewer uid.ID) error {
+	var res sql.Result
+	var err error
+	if approve {
+		res, err = db.ExecContext(ctx, "DELETE FROM image_quarantine WHERE image_id = ?", image)
+	} else {
+		res, err = db.ExecContext(ctx, "UPDATE image_quarantine SET rejected = TRUE, reviewed_by = ?, reviewed_at = ? WHERE image_id = ?", reviewer, time.Now(), image)
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrImageNotFound
+	}
+	return nil
+}
+
+// ReadQuarantinedImage returns the image file of a quarantined image (for
+// admins to review).
+func ReadQuarantinedImage(ctx context.Context, db *sql.DB, id uid.ID) ([]byte, *ImageRecord, error) {
+	if quarantined, err := isQuarantined(ctx, db, id); err != nil {
+		return nil, nil, err
+	} else if !quarantined {
+		return nil, nil, ErrImageNotFound
+	}
+	record, err := GetImageRecord(ctx, db, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	store := record.store()
+	if store == nil {
+		return nil, nil, fmt.Errorf("image store %v is not found", record.StoreName)
+	}
+	image, err := store.get(record)
+	return image, record, err
+}
+
+func isQuarantined(ctx context.Context, db *sql.DB, id uid.ID) (bool, error) {
+	var n int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM image_quarantine WHERE image_id = ?", id).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -3,8 +3,12 @@ package images
 import (
 	"database/sql"
 	"io"
-	"log"
 	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/cdn"
+	"github.com/discuitnet/discuit/internal/httputil"
 )
 
 // Server implements the http.Handler interface.
@@ -14,6 +18,16 @@ type Server struct {
 	SkipHashCheck bool
 	DB            *sql.DB
 	CacheDisabled bool
+
+	// If true, images are served in the most efficient format (AVIF or WebP)
+	// the client accepts, as per its Accept header, rather than in the format
+	// of the URL.
+	NegotiateFormat bool
+
+	// If non-zero, requests for unaltered images that reside in stores that
+	// support signed URLs (like S3) are redirected to a URL, valid for this
+	// duration, with which the image is fetched directly from the store.
+	SignedURLExpiry time.Duration
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -30,6 +44,33 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	if s.NegotiateFormat {
+		imgReq.format = negotiateFormat(r.Header.Get("Accept"), imgReq.format)
+		w.Header().Add("Vary", "Accept")
+	}
+	cdn.AddKeys(w.Header(), cdn.Key("image", imgReq.id))
+
+	if s.SignedURLExpiry > 0 && imgReq.size.Zero() {
+		if redirected, err := s.redirectToStore(w, r, imgReq); err != nil {
+			if err == ErrImageNotFound {
+				s.writeError(w, http.StatusNotFound, "Image not found")
+			} else {
+				s.writeInternalServerError(w, err)
+			}
+			return
+		} else if redirected {
+			return
+		}
+	}
+
+	etag := imgReq.etag()
+	if httputil.ETagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Set("ETag", etag)
+		w.Header().Add("Cache-Control", "public, max-age=31536000, immutable")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	image, err := getImage(r.Context(), s.DB, imgReq, !s.CacheDisabled)
 	if err != nil {
 		if err == ErrImageNotFound {
@@ -39,10 +80,34 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	w.Header().Set("Content-Type", "image/"+string(imgReq.format))
+	w.Header().Set("ETag", etag)
 	w.Header().Add("Cache-Control", "public, max-age=31536000, immutable")
 	w.Write(image)
 }
 
+// redirectToStore redirects the request to a signed URL of the store of the
+// image, if the store supports it and if the image is requested in its
+// original format.
+func (s *Server) redirectToStore(w http.ResponseWriter, r *http.Request, imgReq *request) (bool, error) {
+	record, err := getServableImageRecord(r.Context(), s.DB, imgReq.id)
+	if err != nil {
+		return false, err
+	}
+	if imgReq.format != record.Format {
+		return false, nil
+	}
+	url, err := record.SignedURL(s.SignedURLExpiry)
+	if err != nil || url == "" {
+		return false, err
+	}
+	// The redirect should not outlive the signed URL.
+	maxAge := int((s.SignedURLExpiry / 2).Seconds())
+	w.Header().Add("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
+	http.Redirect(w, r, url, http.StatusFound)
+	return true, nil
+}
+
 func (s *Server) writeError(w http.ResponseWriter, statusCode int, message string) {
 	w.WriteHeader(statusCode)
 	if message == "" {
@@ -54,5 +119,5 @@ func (s *Server) writeError(w http.ResponseWriter, statusCode int, message strin
 // err is for logging purposes only.
 func (s *Server) writeInternalServerError(w http.ResponseWriter, err error) {
 	s.writeError(w, http.StatusInternalServerError, "")
-	log.Println("images server 500 error: ", err)
+	logger.Error("Images server 500 error", "err", err)
 }
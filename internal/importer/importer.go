@@ -0,0 +1,315 @@
+// Package importer reads the posts and comments of community dumps exported
+// from other sites (Reddit and Lemmy), in a common form.
+//
+// Dumps are newline delimited JSON files. For Reddit, each line is either a
+// submission or a comment, as found in Pushshift dumps or in the responses of
+// the Reddit API (in which case they are wrapped in {"kind": ..., "data":
+// ...}). For Lemmy, each line is either a post_view or a comment_view, as
+// found in the responses of the Lemmy API.
+package importer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is the format of a dump.
+type Format string
+
+const (
+	FormatReddit = Format("reddit")
+	FormatLemmy  = Format("lemmy")
+)
+
+// Valid reports whether f is a known format.
+func (f Format) Valid() bool {
+	return f == FormatReddit || f == FormatLemmy
+}
+
+// Kind is the kind of an Item.
+type Kind int
+
+const (
+	KindPost Kind = iota
+	KindComment
+)
+
+func (k Kind) String() string {
+	if k == KindComment {
+		return "comment"
+	}
+	return "post"
+}
+
+// Item is a post or a comment of a dump.
+type Item struct {
+	Kind Kind
+	ID   string // Unique among the items of the same kind.
+
+	// For comments: the ID of the post, and, for replies, the ID of the
+	// parent comment (empty for top-level comments).
+	PostID   string
+	ParentID string
+
+	AuthorID string // Unique per author (the username or, for Lemmy, the actor ID).
+	Author   string // The username.
+
+	Title string // For posts.
+	Body  string // Markdown.
+	URL   string // For link (and media) posts.
+
+	CreatedAt time.Time
+	Upvotes   int
+	Downvotes int
+}
+
+// maxLineSize is the maximum size of a line of a dump.
+const maxLineSize = 16 << 20
+
+// Reader reads the items of a dump.
+type Reader struct {
+	format  Format
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewReader returns a Reader that reads the items of the dump r, which is in
+// format.
+func NewReader(r io.Reader, format Format) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLineSize)
+	return &Reader{format: format, scanner: scanner}
+}
+
+// Next returns the next item of the dump. It returns io.EOF at the end of the
+// dump. Lines that are neither posts nor comments are skipped.
+func (r *Reader) Next() (*Item, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" {
+			continue
+		}
+		var (
+			item *Item
+			err  error
+		)
+		if r.format == FormatLemmy {
+			item, err = parseLemmy([]byte(line))
+		} else {
+			item, err = parseReddit([]byte(line))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		if item != nil {
+			return item, nil
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// flexInt is a number that's either a JSON number or a string.
+type flexInt int
+
+func (n *flexInt) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*n = flexInt(f)
+	return nil
+}
+
+// flexID is an ID that's either a JSON number or a string.
+type flexID string
+
+func (id *flexID) UnmarshalJSON(data []byte) error {
+	*id = flexID(strings.Trim(string(data), `"`))
+	return nil
+}
+
+type redditThing struct {
+	Kind string          `json:"kind"`
+	Data json.RawMessage `json:"data"`
+}
+
+type redditItem struct {
+	ID         string  `json:"id"`
+	Title      *string `json:"title"`
+	Selftext   string  `json:"selftext"`
+	URL        string  `json:"url"`
+	IsSelf     bool    `json:"is_self"`
+	LinkID     string  `json:"link_id"`
+	ParentID   string  `json:"parent_id"`
+	Body       string  `json:"body"`
+	Author     string  `json:"author"`
+	CreatedUTC flexInt `json:"created_utc"`
+	Score      flexInt `json:"score"`
+	Ups        flexInt `json:"ups"`
+	Downs      flexInt `json:"downs"`
+}
+
+func parseReddit(data []byte) (*Item, error) {
+	var thing redditThing
+	if err := json.Unmarshal(data, &thing); err != nil {
+		return nil, err
+	}
+	if thing.Kind != "" && thing.Data != nil {
+		if thing.Kind != "t1" && thing.Kind != "t3" {
+			return nil, nil
+		}
+		data = thing.Data
+	}
+
+	var v redditItem
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	if v.ID == "" {
+		return nil, errors.New("reddit item without an id")
+	}
+	item := &Item{
+		ID:        v.ID,
+		AuthorID:  v.Author,
+		Author:    v.Author,
+		CreatedAt: time.Unix(int64(v.CreatedUTC), 0),
+	}
+	item.Upvotes, item.Downvotes = int(v.Ups), int(v.Downs)
+	if item.Upvotes == 0 && item.Downvotes == 0 {
+		item.Upvotes, item.Downvotes = splitScore(int(v.Score))
+	}
+
+	switch {
+	case v.LinkID != "":
+		item.Kind = KindComment
+		item.PostID = strings.TrimPrefix(v.LinkID, "t3_")
+		if parent, ok := strings.CutPrefix(v.ParentID, "t1_"); ok {
+			item.ParentID = parent
+		}
+		item.Body = v.Body
+	case v.Title != nil:
+		item.Kind = KindPost
+		item.Title = *v.Title
+		item.Body = v.Selftext
+		if !v.IsSelf {
+			item.URL = v.URL
+		}
+	default:
+		return nil, nil
+	}
+	return item, nil
+}
+
+type lemmyCreator struct {
+	Name    string `json:"name"`
+	ActorID string `json:"actor_id"`
+}
+
+type lemmyCounts struct {
+	Upvotes   flexInt `json:"upvotes"`
+	Downvotes flexInt `json:"downvotes"`
+	Score     flexInt `json:"score"`
+}
+
+type lemmyView struct {
+	Post *struct {
+		ID        flexID `json:"id"`
+		Name      string `json:"name"`
+		Body      string `json:"body"`
+		URL       string `json:"url"`
+		Published string `json:"published"`
+	} `json:"post"`
+	Comment *struct {
+		ID        flexID `json:"id"`
+		PostID    flexID `json:"post_id"`
+		Content   string `json:"content"`
+		Path      string `json:"path"`
+		Published string `json:"published"`
+	} `json:"comment"`
+	Creator lemmyCreator `json:"creator"`
+	Counts  lemmyCounts  `json:"counts"`
+}
+
+func parseLemmy(data []byte) (*Item, error) {
+	var v lemmyView
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	item := &Item{
+		AuthorID:  v.Creator.ActorID,
+		Author:    v.Creator.Name,
+		Upvotes:   int(v.Counts.Upvotes),
+		Downvotes: int(v.Counts.Downvotes),
+	}
+	if item.AuthorID == "" {
+		item.AuthorID = item.Author
+	}
+	if item.Upvotes == 0 && item.Downvotes == 0 {
+		item.Upvotes, item.Downvotes = splitScore(int(v.Counts.Score))
+	}
+
+	var published string
+	switch {
+	case v.Comment != nil: // comment_views also have a post
+		item.Kind = KindComment
+		item.ID = string(v.Comment.ID)
+		item.PostID = string(v.Comment.PostID)
+		item.Body = v.Comment.Content
+		// The path is of the form 0.{ancestor IDs}.{ID}.
+		if ids := strings.Split(v.Comment.Path, "."); len(ids) > 2 {
+			item.ParentID = ids[len(ids)-2]
+		}
+		published = v.Comment.Published
+	case v.Post != nil:
+		item.Kind = KindPost
+		item.ID = string(v.Post.ID)
+		item.Title = v.Post.Name
+		item.Body = v.Post.Body
+		item.URL = v.Post.URL
+		published = v.Post.Published
+	default:
+		return nil, nil
+	}
+	if item.ID == "" {
+		return nil, errors.New("lemmy item without an id")
+	}
+
+	t, err := parseLemmyTime(published)
+	if err != nil {
+		return nil, err
+	}
+	item.CreatedAt = t
+	return item, nil
+}
+
+// parseLemmyTime parses a Lemmy timestamp, which, in older versions, has no
+// time zone (and is in UTC).
+func parseLemmyTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02T15:04:05.999999999", s)
+}
+
+// splitScore splits score, the difference of upvotes and downvotes, into
+// upvotes and downvotes (assuming the fewest votes possible).
+func splitScore(score int) (up, down int) {
+	if score < 0 {
+		return 0, -score
+	}
+	return score, 0
+}
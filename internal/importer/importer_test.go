@@ -0,0 +1,71 @@
+package importer
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, format Format, dump string) []*Item {
+	t.Helper()
+	r := NewReader(strings.NewReader(dump), format)
+	var items []*Item
+	for {
+		item, err := r.Next()
+		if err == io.EOF {
+			return items
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, item)
+	}
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		format Format
+		dump   string
+		want   []Item
+	}{
+		{
+			format: FormatReddit,
+			dump: `{"id":"p1","title":"Hello","selftext":"text","is_self":true,"url":"https://reddit.com/r/x/p1","author":"alice","created_utc":1700000000,"score":5}
+
+{"kind":"t1","data":{"id":"c2","link_id":"t3_p1","parent_id":"t1_c1","body":"reply","author":"bob","created_utc":"1700000100","ups":1,"downs":2}}
+{"kind":"more","data":{"id":"m1"}}
+{"id":"p2","title":"Link","is_self":false,"url":"https://i.example.com/a.jpg","author":"alice","created_utc":1700000000,"score":-3}`,
+			want: []Item{
+				{Kind: KindPost, ID: "p1", AuthorID: "alice", Author: "alice", Title: "Hello", Body: "text", CreatedAt: time.Unix(1700000000, 0), Upvotes: 5},
+				{Kind: KindComment, ID: "c2", PostID: "p1", ParentID: "c1", AuthorID: "bob", Author: "bob", Body: "reply", CreatedAt: time.Unix(1700000100, 0), Upvotes: 1, Downvotes: 2},
+				{Kind: KindPost, ID: "p2", AuthorID: "alice", Author: "alice", Title: "Link", URL: "https://i.example.com/a.jpg", CreatedAt: time.Unix(1700000000, 0), Downvotes: 3},
+			},
+		},
+		{
+			format: FormatLemmy,
+			dump: `{"post":{"id":7,"name":"Hi","body":"b","published":"2023-06-01T12:00:00.5Z"},"creator":{"name":"carol","actor_id":"https://lemmy.example/u/carol"},"counts":{"upvotes":3,"downvotes":1}}
+{"comment":{"id":9,"post_id":7,"content":"c","path":"0.8.9","published":"2023-06-01T12:00:00"},"post":{"id":7},"creator":{"name":"dave"},"counts":{"score":2}}`,
+			want: []Item{
+				{Kind: KindPost, ID: "7", AuthorID: "https://lemmy.example/u/carol", Author: "carol", Title: "Hi", Body: "b", CreatedAt: time.Date(2023, 6, 1, 12, 0, 0, 5e8, time.UTC), Upvotes: 3, Downvotes: 1},
+				{Kind: KindComment, ID: "9", PostID: "7", ParentID: "8", AuthorID: "dave", Author: "dave", Body: "c", CreatedAt: time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC), Upvotes: 2},
+			},
+		},
+	}
+	for _, test := range tests {
+		items := readAll(t, test.format, test.dump)
+		if len(items) != len(test.want) {
+			t.Fatalf("%s: got %d items, want %d", test.format, len(items), len(test.want))
+		}
+		for i, item := range items {
+			want := test.want[i]
+			if !item.CreatedAt.Equal(want.CreatedAt) {
+				t.Errorf("%s item %d: got created at %v, want %v", test.format, i, item.CreatedAt, want.CreatedAt)
+			}
+			item.CreatedAt = want.CreatedAt
+			if *item != want {
+				t.Errorf("%s item %d: got %+v, want %+v", test.format, i, *item, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// The subset of BER (the encoding of LDAP messages) that LDAP clients need:
+// definite lengths, and tags of a single byte.
+
+// The tags of the universal types.
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+)
+
+// Tag classes, and the constructed bit.
+const (
+	classApplication = 0x40
+	classContext     = 0x80
+	constructed      = 0x20
+)
+
+var errMalformed = errors.New("ldap: malformed message")
+
+// maxMessageSize is the size of the largest message accepted.
+const maxMessageSize = 16 << 20
+
+// tlv encodes a value with the tag tag and the contents contents.
+func tlv(tag byte, contents ...[]byte) []byte {
+	n := 0
+	for _, c := range contents {
+		n += len(c)
+	}
+	b := []byte{tag}
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n <= 0xff:
+		b = append(b, 0x81, byte(n))
+	case n <= 0xffff:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	for _, c := range contents {
+		b = append(b, c...)
+	}
+	return b
+}
+
+func sequence(contents ...[]byte) []byte { return tlv(tagSequence, contents...) }
+
+func octetString(s string) []byte { return tlv(tagOctetString, []byte(s)) }
+
+func boolean(v bool) []byte {
+	if v {
+		return tlv(tagBoolean, []byte{0xff})
+	}
+	return tlv(tagBoolean, []byte{0})
+}
+
+// integer encodes n, with the tag tag (either tagInteger or tagEnumerated).
+func integer(tag byte, n int) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		if (n < 0x80 && n >= -0x80) || len(b) == 8 {
+			break
+		}
+		n >>= 8
+	}
+	return tlv(tag, b)
+}
+
+// parseTLV returns the tag and the contents of the value at the start of b,
+// and what follows it.
+func parseTLV(b []byte) (tag byte, contents, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errMalformed
+	}
+	tag, b = b[0], b[1:]
+	if tag&0x1f == 0x1f {
+		return 0, nil, nil, errMalformed // Multi-byte tags are not used by LDAP.
+	}
+	n := int(b[0])
+	b = b[1:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(b) < size {
+			return 0, nil, nil, errMalformed
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if n < 0 || n > len(b) {
+		return 0, nil, nil, errMalformed
+	}
+	return tag, b[:n], b[n:], nil
+}
+
+// parseInt parses the contents of an integer (or an enumerated value).
+func parseInt(b []byte) (int, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, errMalformed
+	}
+	n := int(int8(b[0]))
+	for _, c := range b[1:] {
+		n = n<<8 | int(c)
+	}
+	return n, nil
+}
+
+// A value is a decoded value (whose contents are not decoded).
+type value struct {
+	tag      byte
+	contents []byte
+}
+
+// parseValues returns the values in b (the contents of a constructed value).
+func parseValues(b []byte) ([]value, error) {
+	var values []value
+	for len(b) > 0 {
+		tag, contents, rest, err := parseTLV(b)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value{tag, contents})
+		b = rest
+	}
+	return values, nil
+}
+
+// readMessage reads a (whole) value from r, and returns its contents.
+func readMessage(r *bufio.Reader) (tag byte, contents []byte, err error) {
+	header := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	n := int(header[1])
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 {
+			return 0, nil, errMalformed
+		}
+		header = header[:2+size]
+		if _, err := io.ReadFull(r, header[2:]); err != nil {
+			return 0, nil, err
+		}
+		n = 0
+		for _, c := range header[2:] {
+			n = n<<8 | int(c)
+		}
+	}
+	if n < 0 || n > maxMessageSize {
+		return 0, nil, errMalformed
+	}
+	contents = make([]byte, n)
+	if _, err := io.ReadFull(r, contents); err != nil {
+		return 0, nil, err
+	}
+	return header[0], contents, nil
+}
@@ -0,0 +1,181 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// The tags of the filters.
+const (
+	filterAnd            = classContext | constructed | 0
+	filterOr             = classContext | constructed | 1
+	filterNot            = classContext | constructed | 2
+	filterEqualityMatch  = classContext | constructed | 3
+	filterSubstrings     = classContext | constructed | 4
+	filterGreaterOrEqual = classContext | constructed | 5
+	filterLessOrEqual    = classContext | constructed | 6
+	filterPresent        = classContext | 7
+)
+
+// compileFilter encodes the string representation of a search filter (RFC
+// 4515), such as (&(objectClass=person)(memberOf=cn=staff,dc=example,dc=com)).
+// Approximate matches and extensible matches are not supported.
+func compileFilter(s string) ([]byte, error) {
+	f, rest, err := parseFilter(s)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid filter %q: %w", s, err)
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("ldap: invalid filter %q: trailing %q", s, rest)
+	}
+	return f, nil
+}
+
+// parseFilter encodes the filter at the start of s, and returns what follows
+// it.
+func parseFilter(s string) ([]byte, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("expected ( at %q", s)
+	}
+	s = s[1:]
+	var f []byte
+	var err error
+	switch {
+	case strings.HasPrefix(s, "&"), strings.HasPrefix(s, "|"):
+		tag := byte(filterAnd)
+		if s[0] == '|' {
+			tag = filterOr
+		}
+		var list [][]byte
+		s = s[1:]
+		for strings.HasPrefix(s, "(") {
+			var item []byte
+			if item, s, err = parseFilter(s); err != nil {
+				return nil, "", err
+			}
+			list = append(list, item)
+		}
+		if len(list) == 0 {
+			return nil, "", fmt.Errorf("empty filter list")
+		}
+		f = tlv(tag, list...)
+	case strings.HasPrefix(s, "!"):
+		var item []byte
+		if item, s, err = parseFilter(s[1:]); err != nil {
+			return nil, "", err
+		}
+		f = tlv(filterNot, item)
+	default:
+		end := strings.IndexByte(s, ')')
+		if end < 0 {
+			return nil, "", fmt.Errorf("missing )")
+		}
+		if f, err = parseItem(s[:end]); err != nil {
+			return nil, "", err
+		}
+		s = s[end:]
+	}
+	if !strings.HasPrefix(s, ")") {
+		return nil, "", fmt.Errorf("missing )")
+	}
+	return f, s[1:], nil
+}
+
+// parseItem encodes a simple filter item (the part between the parentheses),
+// such as uid=jo, mail=*, or cn=Jo*.
+func parseItem(s string) ([]byte, error) {
+	i := strings.IndexByte(s, '=')
+	if i < 1 {
+		return nil, fmt.Errorf("invalid item %q", s)
+	}
+	attr, v := s[:i], s[i+1:]
+	switch attr[len(attr)-1] {
+	case '>', '<':
+		tag := byte(filterGreaterOrEqual)
+		if attr[len(attr)-1] == '<' {
+			tag = filterLessOrEqual
+		}
+		value, err := unescapeValue(v)
+		if err != nil {
+			return nil, err
+		}
+		return tlv(tag, octetString(attr[:len(attr)-1]), octetString(value)), nil
+	case '~', ':':
+		return nil, fmt.Errorf("unsupported item %q", s)
+	}
+
+	if v == "*" {
+		return tlv(filterPresent, []byte(attr)), nil
+	}
+	if !strings.Contains(v, "*") {
+		value, err := unescapeValue(v)
+		if err != nil {
+			return nil, err
+		}
+		return tlv(filterEqualityMatch, octetString(attr), octetString(value)), nil
+	}
+
+	// A substrings filter: [initial]*any*...*[final].
+	parts := strings.Split(v, "*")
+	var subs [][]byte
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		value, err := unescapeValue(part)
+		if err != nil {
+			return nil, err
+		}
+		var tag byte = classContext | 1 // any
+		if i == 0 {
+			tag = classContext | 0 // initial
+		} else if i == len(parts)-1 {
+			tag = classContext | 2 // final
+		}
+		subs = append(subs, tlv(tag, []byte(value)))
+	}
+	return tlv(filterSubstrings, octetString(attr), sequence(subs...)), nil
+}
+
+// unescapeValue decodes the \XX escapes of an assertion value.
+func unescapeValue(s string) (string, error) {
+	if strings.ContainsAny(s, "()") {
+		return "", fmt.Errorf("unescaped parenthesis in %q", s)
+	}
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		c, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		b.Write(c)
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// EscapeFilterValue escapes the special characters of v, for use as a value
+// in a filter.
+func EscapeFilterValue(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, `\%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,331 @@
+// Package ldap implements the small part of an LDAP (v3) client that syncing
+// users from a directory needs: binding with a password, and searching (with
+// paged results, as directories like Active Directory limit the size of
+// results).
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The tags of the protocol operations.
+const (
+	opBindRequest       = classApplication | constructed | 0
+	opBindResponse      = classApplication | constructed | 1
+	opUnbindRequest     = classApplication | 2
+	opSearchRequest     = classApplication | constructed | 3
+	opSearchResultEntry = classApplication | constructed | 4
+	opSearchResultDone  = classApplication | constructed | 5
+	opSearchResultRef   = classApplication | constructed | 19
+)
+
+// pagedResultsOID is the OID of the simple paged results control (RFC 2696).
+const pagedResultsOID = "1.2.840.113556.1.4.319"
+
+// pageSize is the number of entries requested per page.
+const pageSize = 500
+
+// A ResultError is a result, other than success, of an operation.
+type ResultError struct {
+	Code    int
+	Message string
+}
+
+func (e *ResultError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.Code)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.Code, e.Message)
+}
+
+// An Entry is an entry of a directory.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string // By the lowercase names of the attributes.
+}
+
+// Get returns the first value of the attribute name of e, or an empty string.
+func (e *Entry) Get(name string) string {
+	if values := e.Attributes[strings.ToLower(name)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// GetAll returns the values of the attribute name of e.
+func (e *Entry) GetAll(name string) []string {
+	return e.Attributes[strings.ToLower(name)]
+}
+
+// A Conn is a connection to an LDAP server. Operations are not concurrent.
+type Conn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	mu     sync.Mutex
+	lastID int
+}
+
+// Dial connects to the LDAP server at rawURL (ldap://host[:port] or
+// ldaps://host[:port]). Each operation times out after timeout.
+func Dial(ctx context.Context, rawURL string, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: invalid URL: %w", err)
+	}
+	host, port := u.Hostname(), u.Port()
+	d := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		if port == "" {
+			port = "389"
+		}
+		conn, err = d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	case "ldaps":
+		if port == "" {
+			port = "636"
+		}
+		td := &tls.Dialer{NetDialer: d, Config: &tls.Config{ServerName: host}}
+		conn, err = td.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	default:
+		return nil, fmt.Errorf("ldap: unsupported URL scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn, timeout), nil
+}
+
+// NewConn returns a Conn over conn.
+func NewConn(conn net.Conn, timeout time.Duration) *Conn {
+	return &Conn{conn: conn, r: bufio.NewReader(conn), timeout: timeout}
+}
+
+// Close unbinds and closes the connection.
+func (c *Conn) Close() error {
+	c.send(tlv(opUnbindRequest), nil)
+	return c.conn.Close()
+}
+
+// send sends the request op (with the controls controls, if non-nil), and
+// returns its message ID.
+func (c *Conn) send(op, controls []byte) (int, error) {
+	c.mu.Lock()
+	c.lastID++
+	id := c.lastID
+	c.mu.Unlock()
+
+	msg := [][]byte{integer(tagInteger, id), op}
+	if controls != nil {
+		msg = append(msg, tlv(classContext|constructed|0, controls))
+	}
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	_, err := c.conn.Write(sequence(msg...))
+	return id, err
+}
+
+// receive reads the next response to the request with the message ID id, and
+// returns its protocol operation and controls.
+func (c *Conn) receive(id int) (op value, controls []value, err error) {
+	for {
+		if c.timeout > 0 {
+			c.conn.SetDeadline(time.Now().Add(c.timeout))
+		}
+		tag, contents, err := readMessage(c.r)
+		if err != nil {
+			return value{}, nil, err
+		}
+		if tag != tagSequence {
+			return value{}, nil, errMalformed
+		}
+		values, err := parseValues(contents)
+		if err != nil || len(values) < 2 || values[0].tag != tagInteger {
+			return value{}, nil, errMalformed
+		}
+		msgID, err := parseInt(values[0].contents)
+		if err != nil {
+			return value{}, nil, err
+		}
+		if msgID != id {
+			continue // An unsolicited notification, or a response to an abandoned request.
+		}
+		if len(values) > 2 && values[2].tag == classContext|constructed|0 {
+			if controls, err = parseValues(values[2].contents); err != nil {
+				return value{}, nil, err
+			}
+		}
+		return values[1], controls, nil
+	}
+}
+
+// parseResult returns the LDAPResult in the contents b of a response as an
+// error (nil if it's a success).
+func parseResult(b []byte) error {
+	values, err := parseValues(b)
+	if err != nil || len(values) < 3 || values[0].tag != tagEnumerated {
+		return errMalformed
+	}
+	code, err := parseInt(values[0].contents)
+	if err != nil {
+		return err
+	}
+	if code == 0 {
+		return nil
+	}
+	return &ResultError{Code: code, Message: string(values[2].contents)}
+}
+
+// Bind authenticates with the DN dn and password.
+func (c *Conn) Bind(dn, password string) error {
+	id, err := c.send(tlv(opBindRequest,
+		integer(tagInteger, 3),
+		octetString(dn),
+		tlv(classContext|0, []byte(password)),
+	), nil)
+	if err != nil {
+		return err
+	}
+	op, _, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != opBindResponse {
+		return errMalformed
+	}
+	return parseResult(op.contents)
+}
+
+// Search returns the entries of the subtree at baseDN that match filter (see
+// compileFilter), with the attributes attributes.
+func (c *Conn) Search(baseDN, filter string, attributes []string) ([]*Entry, error) {
+	f, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	attrs := make([][]byte, len(attributes))
+	for i, a := range attributes {
+		attrs[i] = octetString(a)
+	}
+	req := tlv(opSearchRequest,
+		octetString(baseDN),
+		integer(tagEnumerated, 2), // wholeSubtree
+		integer(tagEnumerated, 0), // neverDerefAliases
+		integer(tagInteger, 0),    // No size limit.
+		integer(tagInteger, 0),    // No time limit.
+		boolean(false),            // Not only types.
+		f,
+		sequence(attrs...),
+	)
+
+	var entries []*Entry
+	var cookie []byte
+	for {
+		control := sequence(
+			octetString(pagedResultsOID),
+			octetString(string(sequence(integer(tagInteger, pageSize), tlv(tagOctetString, cookie)))),
+		)
+		id, err := c.send(req, control)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			op, controls, err := c.receive(id)
+			if err != nil {
+				return nil, err
+			}
+			if op.tag == opSearchResultRef {
+				continue // Referrals are not followed.
+			}
+			if op.tag == opSearchResultEntry {
+				entry, err := parseEntry(op.contents)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, entry)
+				continue
+			}
+			if op.tag != opSearchResultDone {
+				return nil, errMalformed
+			}
+			if err := parseResult(op.contents); err != nil {
+				return nil, err
+			}
+			if cookie, err = pagedResultsCookie(controls); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if len(cookie) == 0 {
+			return entries, nil
+		}
+	}
+}
+
+// pagedResultsCookie returns the cookie of the paged results control in
+// controls (empty if it's the last page, or if the server doesn't page
+// results).
+func pagedResultsCookie(controls []value) ([]byte, error) {
+	for _, control := range controls {
+		values, err := parseValues(control.contents)
+		if err != nil || len(values) < 1 {
+			return nil, errMalformed
+		}
+		if string(values[0].contents) != pagedResultsOID {
+			continue
+		}
+		v := values[len(values)-1]
+		if v.tag != tagOctetString {
+			return nil, errMalformed
+		}
+		_, contents, _, err := parseTLV(v.contents)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := parseValues(contents)
+		if err != nil || len(fields) != 2 {
+			return nil, errMalformed
+		}
+		return fields[1].contents, nil
+	}
+	return nil, nil
+}
+
+// parseEntry parses the contents of a SearchResultEntry.
+func parseEntry(b []byte) (*Entry, error) {
+	values, err := parseValues(b)
+	if err != nil || len(values) != 2 {
+		return nil, errMalformed
+	}
+	entry := &Entry{DN: string(values[0].contents), Attributes: make(map[string][]string)}
+	attrs, err := parseValues(values[1].contents)
+	if err != nil {
+		return nil, err
+	}
+	for _, attr := range attrs {
+		fields, err := parseValues(attr.contents)
+		if err != nil || len(fields) != 2 {
+			return nil, errMalformed
+		}
+		vals, err := parseValues(fields[1].contents)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.ToLower(string(fields[0].contents))
+		for _, v := range vals {
+			entry.Attributes[name] = append(entry.Attributes[name], string(v.contents))
+		}
+	}
+	return entry, nil
+}
@@ -0,0 +1,133 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCompileFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   []byte
+	}{
+		{"(uid=jo)", tlv(filterEqualityMatch, octetString("uid"), octetString("jo"))},
+		{"(mail=*)", tlv(filterPresent, []byte("mail"))},
+		{`(cn=a\2ab)`, tlv(filterEqualityMatch, octetString("cn"), octetString("a*b"))},
+		{"(cn=jo*n)", tlv(filterSubstrings, octetString("cn"), sequence(tlv(classContext|0, []byte("jo")), tlv(classContext|2, []byte("n"))))},
+		{"(&(objectClass=person)(!(uid=x)))", tlv(filterAnd,
+			tlv(filterEqualityMatch, octetString("objectClass"), octetString("person")),
+			tlv(filterNot, tlv(filterEqualityMatch, octetString("uid"), octetString("x"))),
+		)},
+	}
+	for _, test := range tests {
+		got, err := compileFilter(test.filter)
+		if err != nil {
+			t.Errorf("compileFilter(%q): %v", test.filter, err)
+		} else if !bytes.Equal(got, test.want) {
+			t.Errorf("compileFilter(%q): got %x, want %x", test.filter, got, test.want)
+		}
+	}
+
+	for _, filter := range []string{"", "uid=jo", "(uid=jo", "(&)", "(uid=jo))", `(cn=\2)`, "(cn~=jo)"} {
+		if _, err := compileFilter(filter); err == nil {
+			t.Errorf("compileFilter(%q): no error", filter)
+		}
+	}
+	if got := EscapeFilterValue("a*(b)"); got != `a\2a\28b\29` {
+		t.Errorf("EscapeFilterValue: got %q", got)
+	}
+}
+
+// fakeServer answers the requests of a client on conn: binds with the
+// password secret succeed, and searches return the entries, in pages of one.
+func fakeServer(t *testing.T, conn net.Conn, entries []*Entry) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	respond := func(id int, op []byte, controls ...[]byte) {
+		msg := [][]byte{integer(tagInteger, id), op}
+		if len(controls) > 0 {
+			msg = append(msg, tlv(classContext|constructed|0, controls...))
+		}
+		conn.Write(sequence(msg...))
+	}
+	result := func(tag byte, code int) []byte {
+		return tlv(tag, integer(tagEnumerated, code), octetString(""), octetString(""))
+	}
+	for {
+		_, contents, err := readMessage(r)
+		if err != nil {
+			return
+		}
+		values, _ := parseValues(contents)
+		id, _ := parseInt(values[0].contents)
+		switch values[1].tag {
+		case opBindRequest:
+			fields, _ := parseValues(values[1].contents)
+			code := 49 // invalidCredentials
+			if string(fields[2].contents) == "secret" {
+				code = 0
+			}
+			respond(id, result(opBindResponse, code))
+		case opSearchRequest:
+			// The cookie is the index of the next entry.
+			controls, _ := parseValues(values[2].contents)
+			fields, _ := parseValues(controls[0].contents)
+			_, control, _, _ := parseTLV(fields[1].contents)
+			page, _ := parseValues(control)
+			i := 0
+			if len(page[1].contents) > 0 {
+				i = int(page[1].contents[0])
+			}
+			e := entries[i]
+			var attrs [][]byte
+			for name, vals := range e.Attributes {
+				var vs [][]byte
+				for _, v := range vals {
+					vs = append(vs, octetString(v))
+				}
+				attrs = append(attrs, sequence(octetString(name), tlv(tagSet, vs...)))
+			}
+			respond(id, tlv(opSearchResultEntry, octetString(e.DN), sequence(attrs...)))
+			var cookie []byte
+			if i+1 < len(entries) {
+				cookie = []byte{byte(i + 1)}
+			}
+			respond(id, result(opSearchResultDone, 0), sequence(
+				octetString(pagedResultsOID),
+				octetString(string(sequence(integer(tagInteger, 0), tlv(tagOctetString, cookie)))),
+			))
+		case opUnbindRequest:
+			return
+		}
+	}
+}
+
+func TestBindAndSearch(t *testing.T) {
+	entries := []*Entry{
+		{DN: "uid=jo,dc=example,dc=com", Attributes: map[string][]string{"uid": {"jo"}, "memberof": {"cn=staff", "cn=eng"}}},
+		{DN: "uid=al,dc=example,dc=com", Attributes: map[string][]string{"uid": {"al"}}},
+	}
+	client, server := net.Pipe()
+	go fakeServer(t, server, entries)
+	c := NewConn(client, time.Second)
+	defer c.Close()
+
+	err := c.Bind("cn=admin", "wrong")
+	if e, ok := err.(*ResultError); !ok || e.Code != 49 {
+		t.Fatalf("Bind with a wrong password: got %v", err)
+	}
+	if err := c.Bind("cn=admin", "secret"); err != nil {
+		t.Fatalf("Bind: %v", err)
+	}
+
+	got, err := c.Search("dc=example,dc=com", "(objectClass=person)", []string{"uid", "memberOf"})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(got) != 2 || got[0].DN != entries[0].DN || got[1].Get("UID") != "al" || len(got[0].GetAll("memberOf")) != 2 {
+		t.Errorf("Search: got %+v", got)
+	}
+}
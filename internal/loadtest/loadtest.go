@@ -0,0 +1,433 @@
+// Package loadtest sends a mix of the requests users commonly make (reading
+// feeds and threads, voting, and commenting) to a running instance of the
+// site, from many clients at once, and measures how long each kind takes.
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/cookiejar"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/utils"
+)
+
+// An Action is something a client does, in one or more requests.
+type Action string
+
+const (
+	ActionFeed    = Action("feed")    // Read a page of a feed.
+	ActionThread  = Action("thread")  // Read a post and its comments.
+	ActionVote    = Action("vote")    // Vote on a post or a comment.
+	ActionComment = Action("comment") // Comment on a post, or reply to a comment.
+)
+
+// A Mix is how often, relative to each other, clients take each action.
+type Mix map[Action]int
+
+// DefaultMix is mostly reads, as is the traffic of the site.
+var DefaultMix = Mix{ActionFeed: 50, ActionThread: 35, ActionVote: 10, ActionComment: 5}
+
+// ParseMix parses a mix written as "feed=50,thread=35,vote=10,comment=5".
+// Actions left out are not taken.
+func ParseMix(s string) (Mix, error) {
+	m := make(Mix)
+	for _, part := range strings.Split(s, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid mix %q (want action=weight)", part)
+		}
+		a := Action(name)
+		switch a {
+		case ActionFeed, ActionThread, ActionVote, ActionComment:
+		default:
+			return nil, fmt.Errorf("unknown action %q", name)
+		}
+		n, err := strconv.Atoi(weight)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid weight %q of %s", weight, name)
+		}
+		m[a] = n
+	}
+	if m.total() == 0 {
+		return nil, errors.New("the weights of the mix add up to zero")
+	}
+	return m, nil
+}
+
+func (m Mix) total() int {
+	n := 0
+	for _, w := range m {
+		n += w
+	}
+	return n
+}
+
+// pick returns a random action, weighted by m.
+func (m Mix) pick(r *rand.Rand) Action {
+	actions := make([]Action, 0, len(m))
+	for a := range m {
+		actions = append(actions, a)
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i] < actions[j] })
+	n := r.Intn(m.total())
+	for _, a := range actions {
+		if n < m[a] {
+			return a
+		}
+		n -= m[a]
+	}
+	return actions[len(actions)-1]
+}
+
+// Options are the options of Run.
+type Options struct {
+	BaseURL     string        // Of the site, like http://localhost:8080.
+	Concurrency int           // Number of clients.
+	Duration    time.Duration // For how long requests are sent.
+	Mix         Mix
+
+	// The users clients log in as (they take turns). If there are none,
+	// clients don't log in and only read.
+	Usernames []string
+	Password  string
+}
+
+// RouteStats are the results of the requests to a route.
+type RouteStats struct {
+	Route    string // Like "GET /api/posts/{postID}".
+	Requests int
+	Errors   int         // Failed requests, and those with a status of 400 or more.
+	Statuses map[int]int // The number of responses of each status.
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+}
+
+// Report is the results of Run.
+type Report struct {
+	Duration time.Duration
+	Routes   []*RouteStats // Sorted by route.
+}
+
+// Run sends requests to the site at opts.BaseURL, from opts.Concurrency
+// clients, until opts.Duration passes or ctx is canceled. Clients start with
+// no posts or comments to act on, and find them in the feeds and threads they
+// read. The site's rate limits apply to clients as to anyone, so for votes and
+// comments to succeed in numbers disableRateLimits should be set on the site.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 1
+	}
+	if opts.Mix == nil {
+		opts.Mix = DefaultMix
+	}
+	mix := make(Mix)
+	for a, w := range opts.Mix {
+		if len(opts.Usernames) == 0 && (a == ActionVote || a == ActionComment) {
+			continue // These need a logged in user.
+		}
+		mix[a] = w
+	}
+	if mix.total() == 0 {
+		return nil, errors.New("nothing to do: votes and comments need users to log in as")
+	}
+	base := strings.TrimSuffix(opts.BaseURL, "/")
+
+	t := &targets{}
+	rec := &recorder{latencies: make(map[string][]time.Duration), statuses: make(map[string]map[int]int), errors: make(map[string]int)}
+	clients := make([]*client, opts.Concurrency)
+	for i := range clients {
+		jar, _ := cookiejar.New(nil)
+		c := &client{
+			base:    base,
+			http:    &http.Client{Jar: jar, Timeout: time.Minute},
+			rand:    rand.New(rand.NewSource(time.Now().UnixNano() + int64(i))),
+			targets: t,
+			rec:     rec,
+		}
+		// A session, and with it a CSRF token.
+		if err := c.do(ctx, "GET", "/api/_initial", "", nil, nil); err != nil {
+			return nil, fmt.Errorf("connecting to %s: %w", base, err)
+		}
+		if len(opts.Usernames) > 0 {
+			username := opts.Usernames[i%len(opts.Usernames)]
+			body := map[string]string{"username": username, "password": opts.Password}
+			if err := c.do(ctx, "POST", "/api/_login", "", body, nil); err != nil {
+				return nil, fmt.Errorf("logging in as %s: %w", username, err)
+			}
+			c.loggedIn = true
+		}
+		clients[i] = c
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *client) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				c.act(ctx, mix.pick(c.rand))
+			}
+		}(c)
+	}
+	wg.Wait()
+	return rec.report(time.Since(start)), nil
+}
+
+// targets are the posts and comments clients have come across, to read, vote
+// on, and reply to.
+type targets struct {
+	mu       sync.Mutex
+	posts    []post
+	comments []comment
+}
+
+// maxTargets is the number of posts, and of comments, targets keeps (later
+// ones replace random earlier ones).
+const maxTargets = 5000
+
+type post struct {
+	ID       string `json:"id"`
+	PublicID string `json:"publicId"`
+}
+
+type comment struct {
+	ID     string `json:"id"`
+	PostID string `json:"-"` // Public ID.
+}
+
+func (t *targets) add(r *rand.Rand, posts []post, comments []comment) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, p := range posts {
+		if len(t.posts) < maxTargets {
+			t.posts = append(t.posts, p)
+		} else {
+			t.posts[r.Intn(len(t.posts))] = p
+		}
+	}
+	for _, c := range comments {
+		if len(t.comments) < maxTargets {
+			t.comments = append(t.comments, c)
+		} else {
+			t.comments[r.Intn(len(t.comments))] = c
+		}
+	}
+}
+
+func (t *targets) randomPost(r *rand.Rand) (post, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.posts) == 0 {
+		return post{}, false
+	}
+	return t.posts[r.Intn(len(t.posts))], true
+}
+
+func (t *targets) randomComment(r *rand.Rand) (comment, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.comments) == 0 {
+		return comment{}, false
+	}
+	return t.comments[r.Intn(len(t.comments))], true
+}
+
+// recorder collects the latencies and the statuses of responses.
+type recorder struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	statuses  map[string]map[int]int
+	errors    map[string]int
+}
+
+// record records a request to route that took d, and got a response with
+// status, or, if status is 0, failed.
+func (rec *recorder) record(route string, d time.Duration, status int) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.latencies[route] = append(rec.latencies[route], d)
+	if rec.statuses[route] == nil {
+		rec.statuses[route] = make(map[int]int)
+	}
+	if status != 0 {
+		rec.statuses[route][status]++
+	}
+	if status == 0 || status >= 400 {
+		rec.errors[route]++
+	}
+}
+
+func (rec *recorder) report(d time.Duration) *Report {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	report := &Report{Duration: d}
+	for route, latencies := range rec.latencies {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		report.Routes = append(report.Routes, &RouteStats{
+			Route:    route,
+			Requests: len(latencies),
+			Errors:   rec.errors[route],
+			Statuses: rec.statuses[route],
+			P50:      percentile(latencies, 50),
+			P90:      percentile(latencies, 90),
+			P99:      percentile(latencies, 99),
+			Max:      latencies[len(latencies)-1],
+		})
+	}
+	sort.Slice(report.Routes, func(i, j int) bool { return report.Routes[i].Route < report.Routes[j].Route })
+	return report
+}
+
+// percentile returns the pth percentile (by the nearest-rank method) of
+// sorted, which is sorted in increasing order.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// client is a user of the site, with a session of its own.
+type client struct {
+	base     string
+	http     *http.Client
+	csrf     string
+	loggedIn bool
+	rand     *rand.Rand
+	targets  *targets
+	rec      *recorder
+}
+
+var feedSorts = []string{"hot", "hot", "hot", "latest", "activity", "day", "week"}
+
+func (c *client) act(ctx context.Context, a Action) {
+	switch a {
+	case ActionThread:
+		p, ok := c.targets.randomPost(c.rand)
+		if !ok {
+			break
+		}
+		var res struct {
+			Comments []comment `json:"comments"`
+		}
+		if c.do(ctx, "GET", "/api/posts/"+p.PublicID, "GET /api/posts/{postID}", nil, &res) == nil {
+			for i := range res.Comments {
+				res.Comments[i].PostID = p.PublicID
+			}
+			c.targets.add(c.rand, nil, res.Comments)
+		}
+		return
+	case ActionVote:
+		up := c.rand.Intn(5) > 0
+		if cm, ok := c.targets.randomComment(c.rand); ok && c.rand.Intn(2) == 0 {
+			c.do(ctx, "POST", "/api/_commentVote", "POST /api/_commentVote", map[string]any{"commentId": cm.ID, "up": up}, nil)
+			return
+		}
+		if p, ok := c.targets.randomPost(c.rand); ok {
+			c.do(ctx, "POST", "/api/_postVote", "POST /api/_postVote", map[string]any{"postId": p.ID, "up": up}, nil)
+			return
+		}
+	case ActionComment:
+		body := map[string]any{"body": utils.GenerateSenetence(5 + c.rand.Intn(30))}
+		postID := ""
+		if cm, ok := c.targets.randomComment(c.rand); ok && c.rand.Intn(2) == 0 {
+			postID, body["parentCommentId"] = cm.PostID, cm.ID
+		} else if p, ok := c.targets.randomPost(c.rand); ok {
+			postID = p.PublicID
+		}
+		if postID != "" {
+			var res comment
+			if c.do(ctx, "POST", "/api/posts/"+postID+"/comments", "POST /api/posts/{postID}/comments", body, &res) == nil {
+				res.PostID = postID
+				c.targets.add(c.rand, nil, []comment{res})
+			}
+			return
+		}
+	}
+
+	// Read a feed (which is also what's done when there's nothing yet to act
+	// on).
+	feed := "all"
+	if c.loggedIn && c.rand.Intn(2) == 0 {
+		feed = "home"
+	}
+	var res struct {
+		Posts []post `json:"posts"`
+	}
+	path := "/api/posts?feed=" + feed + "&sort=" + feedSorts[c.rand.Intn(len(feedSorts))]
+	if c.do(ctx, "GET", path, "GET /api/posts", nil, &res) == nil {
+		c.targets.add(c.rand, res.Posts, nil)
+	}
+}
+
+// do sends a request, with body, if it's not nil, as JSON, and decodes the
+// response into out, if it's not nil. The request is recorded under route,
+// unless route is empty. Requests cut short by the end of the run are not
+// recorded.
+func (c *client) do(ctx context.Context, method, path, route string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.csrf != "" {
+		req.Header.Set("X-Csrf-Token", c.csrf)
+	}
+
+	start := time.Now()
+	res, err := c.http.Do(req)
+	if err == nil {
+		defer res.Body.Close()
+		var data []byte
+		if data, err = io.ReadAll(res.Body); err == nil {
+			if token := res.Header.Get("Csrf-Token"); token != "" {
+				c.csrf = token
+			}
+			if res.StatusCode >= 400 {
+				err = fmt.Errorf("%s %s: %s: %s", method, path, res.Status, bytes.TrimSpace(data))
+			} else if out != nil {
+				err = json.Unmarshal(data, out)
+			}
+		}
+	}
+	d := time.Since(start)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if route != "" {
+		status := 0
+		if res != nil {
+			status = res.StatusCode
+		}
+		c.rec.record(route, d, status)
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package loadtest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseMix(t *testing.T) {
+	m, err := ParseMix("feed=60, thread=30,vote=10,comment=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Mix{ActionFeed: 60, ActionThread: 30, ActionVote: 10, ActionComment: 0}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got %v, want %v", m, want)
+	}
+	for _, s := range []string{"", "feed", "feed=-1", "feed=x", "browse=10", "feed=0,thread=0"} {
+		if _, err := ParseMix(s); err == nil {
+			t.Errorf("ParseMix(%q) succeeded", s)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 200; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{{50, 100}, {90, 180}, {99, 198}, {100, 200}, {0, 1}}
+	for _, test := range tests {
+		if got := percentile(sorted, test.p); got != test.want {
+			t.Errorf("percentile(%v) = %v, want %v", test.p, got, test.want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile of nothing = %v", got)
+	}
+}
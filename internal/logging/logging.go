@@ -0,0 +1,243 @@
+// Package logging provides structured loggers (built on log/slog) for the
+// modules of the application (core, server, images, and so on). The level of
+// each module's logger can be changed at runtime with SetLevel.
+//
+// Request-scoped values (the request ID, the user ID, and the name of the
+// operation) are carried in the context and are added to every record logged
+// with that context, for example with Logger.ErrorContext. So is the ID of the
+// trace, if the context carries a span.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	mu             sync.Mutex
+	defaultLevel   slog.Level = slog.LevelInfo
+	levels                    = make(map[string]*slog.LevelVar)
+	explicitLevels            = make(map[string]bool) // The modules whose level was set with SetLevel.
+	loggers                   = make(map[string]*slog.Logger)
+)
+
+// baseHandler is the handler set by Setup, to which every module's logger
+// writes. It is swapped atomically so that logging doesn't take mu.
+type baseHandler struct{ slog.Handler }
+
+var base atomic.Pointer[baseHandler]
+
+func init() {
+	base.Store(&baseHandler{slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})})
+}
+
+// Setup sets where, and in which format ("text" or "json"), logs are written
+// to. It also sets the level of the modules that don't have one set explicitly
+// (with SetLevel). It should be called once, on startup, before any logging.
+func Setup(w io.Writer, format string, level slog.Level) error {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug} // Levels are checked by moduleHandler.
+	mu.Lock()
+	defer mu.Unlock()
+	switch format {
+	case "", "text":
+		base.Store(&baseHandler{slog.NewTextHandler(w, opts)})
+	case "json":
+		base.Store(&baseHandler{slog.NewJSONHandler(w, opts)})
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	defaultLevel = level
+	for module, l := range levels {
+		if !explicitLevels[module] {
+			l.Set(level)
+		}
+	}
+	return nil
+}
+
+// levelVar returns the level of module, creating it if need be. The caller
+// should hold mu.
+func levelVar(module string) *slog.LevelVar {
+	l, ok := levels[module]
+	if !ok {
+		l = new(slog.LevelVar)
+		l.Set(defaultLevel)
+		levels[module] = l
+	}
+	return l
+}
+
+// Logger returns the logger of module. Every record logged with it has a
+// "module" attribute.
+func Logger(module string) *slog.Logger {
+	mu.Lock()
+	defer mu.Unlock()
+	if logger, ok := loggers[module]; ok {
+		return logger
+	}
+	h := &moduleHandler{level: levelVar(module)}
+	logger := slog.New(h).With("module", module)
+	loggers[module] = logger
+	return logger
+}
+
+// SetLevel sets the minimum level of the records logged by the logger of
+// module. The level is kept by later calls to Setup.
+func SetLevel(module string, level slog.Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	levelVar(module).Set(level)
+	explicitLevels[module] = true
+}
+
+// Levels returns the current level of each module.
+func Levels() map[string]string {
+	mu.Lock()
+	defer mu.Unlock()
+	m := make(map[string]string, len(levels))
+	for module, l := range levels {
+		m[module] = l.Level().String()
+	}
+	return m
+}
+
+// Modules returns the names of the modules that have a logger, sorted.
+func Modules() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var modules []string
+	for module := range levels {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	return modules
+}
+
+// ParseLevel parses a level name (debug, info, warn, or error; case
+// insensitive).
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.TrimSpace(s)))
+	return level, err
+}
+
+// moduleHandler is the slog.Handler of a module's logger. It filters records
+// by the module's level and adds the request-scoped attributes found in the
+// context before passing records on to the base handler.
+type moduleHandler struct {
+	level *slog.LevelVar
+
+	// Attributes and groups added with WithAttrs and WithGroup, which are
+	// applied to the base handler lazily (since it may change with Setup).
+	ops []func(slog.Handler) slog.Handler
+
+	// The base handler with ops applied, rebuilt when the base changes.
+	wrapped atomic.Pointer[wrappedHandler]
+}
+
+type wrappedHandler struct {
+	base    *baseHandler
+	handler slog.Handler
+}
+
+func (h *moduleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *moduleHandler) Handle(ctx context.Context, r slog.Record) error {
+	if v, ok := ctx.Value(contextKey{}).(*contextValues); ok {
+		if v.requestID != "" {
+			r.AddAttrs(slog.String("request_id", v.requestID))
+		}
+		if v.userID != "" {
+			r.AddAttrs(slog.String("user_id", v.userID))
+		}
+		if v.op != "" {
+			r.AddAttrs(slog.String("op", v.op))
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.handler().Handle(ctx, r)
+}
+
+// handler returns the base handler with the attributes and groups of h.
+func (h *moduleHandler) handler() slog.Handler {
+	b := base.Load()
+	if w := h.wrapped.Load(); w != nil && w.base == b {
+		return w.handler
+	}
+	var handler slog.Handler = b.Handler
+	for _, op := range h.ops {
+		handler = op(handler)
+	}
+	h.wrapped.Store(&wrappedHandler{base: b, handler: handler})
+	return handler
+}
+
+func (h *moduleHandler) with(op func(slog.Handler) slog.Handler) *moduleHandler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &moduleHandler{level: h.level, ops: append(ops, op)}
+}
+
+func (h *moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *moduleHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+type contextKey struct{}
+
+// contextValues are the request-scoped values stored in a context.
+type contextValues struct {
+	requestID string
+	userID    string
+	op        string
+}
+
+func values(ctx context.Context) contextValues {
+	if v, ok := ctx.Value(contextKey{}).(*contextValues); ok {
+		return *v
+	}
+	return contextValues{}
+}
+
+// WithRequestID returns a copy of ctx that carries the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	v := values(ctx)
+	v.requestID = id
+	return context.WithValue(ctx, contextKey{}, &v)
+}
+
+// WithUserID returns a copy of ctx that carries the ID of the logged in user.
+func WithUserID(ctx context.Context, id string) context.Context {
+	v := values(ctx)
+	v.userID = id
+	return context.WithValue(ctx, contextKey{}, &v)
+}
+
+// WithOperation returns a copy of ctx that carries the name of the operation
+// being performed (for HTTP requests, the route).
+func WithOperation(ctx context.Context, op string) context.Context {
+	v := values(ctx)
+	v.op = op
+	return context.WithValue(ctx, contextKey{}, &v)
+}
+
+// RequestID returns the request ID carried by ctx, if any.
+func RequestID(ctx context.Context) string {
+	return values(ctx).requestID
+}
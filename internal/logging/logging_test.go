@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Setup(&buf, "text", slog.LevelInfo); err != nil {
+		t.Fatal(err)
+	}
+	logger := Logger("test")
+
+	ctx := WithRequestID(context.Background(), "abc")
+	ctx = WithUserID(ctx, "u1")
+	ctx = WithOperation(ctx, "GET /api/posts")
+	logger.InfoContext(ctx, "hello", "n", 1)
+	line := buf.String()
+	for _, want := range []string{"msg=hello", "module=test", "n=1", "request_id=abc", "user_id=u1", `op="GET /api/posts"`} {
+		if !strings.Contains(line, want) {
+			t.Errorf("expected %q in log line: %s", want, line)
+		}
+	}
+
+	buf.Reset()
+	logger.Debug("hidden")
+	if buf.Len() != 0 {
+		t.Errorf("debug record logged at level info: %s", buf.String())
+	}
+	SetLevel("test", slog.LevelDebug)
+	logger.Debug("shown")
+	if !strings.Contains(buf.String(), "msg=shown") {
+		t.Errorf("debug record not logged after SetLevel: %s", buf.String())
+	}
+
+	// Setup leaves the levels set with SetLevel be.
+	if err := Setup(&buf, "text", slog.LevelWarn); err != nil {
+		t.Fatal(err)
+	}
+	if got := Levels()["test"]; got != "DEBUG" {
+		t.Errorf("level of test after Setup = %s (expected DEBUG)", got)
+	}
+	if got := Logger("other"); got.Enabled(context.Background(), slog.LevelInfo) {
+		t.Error("info enabled for a module without a level set after Setup with level warn")
+	}
+
+	// Loggers (with their attributes) write to the handler of the last Setup.
+	var buf2 bytes.Buffer
+	withAttrs := logger.With("a", "b")
+	withAttrs.Warn("before")
+	if err := Setup(&buf2, "json", slog.LevelInfo); err != nil {
+		t.Fatal(err)
+	}
+	withAttrs.Warn("after")
+	if line := buf2.String(); !strings.Contains(line, `"msg":"after"`) || !strings.Contains(line, `"a":"b"`) || strings.Contains(line, "before") {
+		t.Errorf("unexpected log line after Setup: %s", line)
+	}
+}
@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessage(t *testing.T) {
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	got := string(message("a@example.com", "b@example.com", "Hello", "line 1\nline 2", date))
+	want := "From: a@example.com\r\n" +
+		"To: b@example.com\r\n" +
+		"Subject: Hello\r\n" +
+		"Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		"line 1\r\nline 2"
+	if got != want {
+		t.Errorf("message() = %q, want %q", got, want)
+	}
+
+	if got := string(message("a@example.com", "b@example.com", "Héllo", "", date)); !strings.Contains(got, "Subject: =?utf-8?q?H=C3=A9llo?=\r\n") {
+		t.Errorf("non-ASCII subject not encoded: %q", got)
+	}
+}
+
+func TestSendRejectsHeaderInjection(t *testing.T) {
+	m := &Mailer{Addr: "localhost:25", From: "a@example.com"}
+	if err := m.Send("b@example.com\r\nBcc: c@example.com", "Hello", ""); err == nil {
+		t.Error("Send accepted a recipient with a newline")
+	}
+}
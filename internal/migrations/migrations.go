@@ -0,0 +1,357 @@
+// Package migrations runs and reports on the database migrations (the SQL files
+// in the migrations folder). Migrations are applied with golang-migrate; this
+// package adds checksums (so that migration files modified after being applied
+// are detected), dry runs, and a lock that prevents two instances of the
+// server from migrating the same database at the same time.
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	msql "github.com/discuitnet/discuit/internal/sql"
+)
+
+// Dir returns the folder containing the migrations of the database driver
+// driver (relative to the root of the repository).
+func Dir(driver string) string {
+	if driver == msql.DriverSQLite {
+		return "migrations/sqlite"
+	}
+	return "migrations"
+}
+
+// Migration is a pair of up and down migration files.
+type Migration struct {
+	Version uint   `json:"version"`
+	Name    string `json:"name"`
+
+	// Checksum is the hex encoded SHA-256 hash of the contents of the up and
+	// down files of the migration.
+	Checksum string `json:"checksum"`
+
+	upFile, downFile string
+}
+
+// String returns the name of the migration files without the extensions (for
+// example, 0001_initial).
+func (m *Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// UpSQL returns the contents of the up file of the migration.
+func (m *Migration) UpSQL() (string, error) {
+	data, err := os.ReadFile(m.upFile)
+	return string(data), err
+}
+
+// DownSQL returns the contents of the down file of the migration. If the
+// migration has no down file, it returns an empty string.
+func (m *Migration) DownSQL() (string, error) {
+	if m.downFile == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(m.downFile)
+	return string(data), err
+}
+
+// Load returns the migrations in dir sorted by version.
+func Load(dir string) ([]*Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		filename := entry.Name()
+		base, up := strings.CutSuffix(filename, ".up.sql")
+		if !up {
+			var down bool
+			if base, down = strings.CutSuffix(filename, ".down.sql"); !down {
+				continue
+			}
+		}
+		versionText, name, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration filename %s", filename)
+		}
+		version, err := strconv.ParseUint(versionText, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration filename %s: %w", filename, err)
+		}
+
+		m := byVersion[uint(version)]
+		if m == nil {
+			m = &Migration{Version: uint(version), Name: name}
+			byVersion[m.Version] = m
+		}
+		if up {
+			m.upFile = filepath.Join(dir, filename)
+		} else {
+			m.downFile = filepath.Join(dir, filename)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.upFile == "" {
+			return nil, fmt.Errorf("migration %s has no up file", m)
+		}
+		h := sha256.New()
+		for _, file := range []string{m.upFile, m.downFile} {
+			if file == "" {
+				continue
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			h.Write(data)
+		}
+		m.Checksum = hex.EncodeToString(h.Sum(nil))
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// CurrentVersion returns the version of the last applied migration (0 if no
+// migrations have been applied) and whether the last migration failed midway.
+func CurrentVersion(ctx context.Context, db *sql.DB) (version uint, dirty bool, err error) {
+	row := db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1")
+	if err = row.Scan(&version, &dirty); err != nil {
+		if err == sql.ErrNoRows || msql.IsErrNoTable(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return
+}
+
+// appliedChecksums returns the recorded checksums of the applied migrations,
+// keyed by version.
+func appliedChecksums(ctx context.Context, db *sql.DB) (map[uint]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT version, checksum FROM migration_checksums")
+	if err != nil {
+		if msql.IsErrNoTable(err) {
+			// Migrations prior to the one that created the table.
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	checksums := make(map[uint]string)
+	for rows.Next() {
+		var version uint
+		var checksum string
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return nil, err
+		}
+		checksums[version] = checksum
+	}
+	return checksums, rows.Err()
+}
+
+// modified returns the applied migrations, out of migrations, whose files have
+// changed since they were applied.
+func modified(ctx context.Context, db *sql.DB, migrations []*Migration) ([]*Migration, error) {
+	checksums, err := appliedChecksums(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	var list []*Migration
+	for _, m := range migrations {
+		if checksum, ok := checksums[m.Version]; ok && checksum != m.Checksum {
+			list = append(list, m)
+		}
+	}
+	return list, nil
+}
+
+// recordChecksums brings the migration_checksums table in line with the
+// current version of the database: checksums are added for applied migrations
+// that have none and removed for reverted migrations.
+func recordChecksums(ctx context.Context, db *sql.DB, migrations []*Migration) error {
+	version, _, err := CurrentVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	checksums, err := appliedChecksums(ctx, db)
+	if err != nil || checksums == nil {
+		return err
+	}
+
+	return msql.Transact(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM migration_checksums WHERE version > ?", version); err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := checksums[m.Version]; ok || m.Version > version {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, "INSERT INTO migration_checksums (version, name, checksum) VALUES (?, ?, ?)", m.Version, m.Name, m.Checksum); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Status is the state of the database schema.
+type Status struct {
+	Version uint `json:"version"`
+	Dirty   bool `json:"dirty"`
+
+	// Migrations that are yet to be applied.
+	Pending []*Migration `json:"pending"`
+
+	// Applied migrations whose files have changed since.
+	Modified []*Migration `json:"modified"`
+}
+
+// GetStatus returns the state of the schema of db given the migrations in
+// dir.
+func GetStatus(ctx context.Context, db *sql.DB, dir string) (*Status, error) {
+	migrations, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Status{Pending: []*Migration{}}
+	if s.Version, s.Dirty, err = CurrentVersion(ctx, db); err != nil {
+		return nil, err
+	}
+	for _, m := range migrations {
+		if m.Version > s.Version {
+			s.Pending = append(s.Pending, m)
+		}
+	}
+	if s.Modified, err = modified(ctx, db, migrations); err != nil {
+		return nil, err
+	}
+	if s.Modified == nil {
+		s.Modified = []*Migration{}
+	}
+	return s, nil
+}
+
+// Step is a migration to be applied (Up is true) or reverted.
+type Step struct {
+	Migration *Migration
+	Up        bool
+}
+
+func (s Step) String() string {
+	if s.Up {
+		return s.Migration.String() + " (up)"
+	}
+	return s.Migration.String() + " (down)"
+}
+
+// Plan returns the steps to go from version current to version target. If
+// target is negative, the steps to apply all the pending migrations are
+// returned.
+func Plan(migrations []*Migration, current uint, target int) ([]Step, error) {
+	if target > 0 && !hasVersion(migrations, uint(target)) {
+		return nil, fmt.Errorf("no migration with version %d", target)
+	}
+
+	var steps []Step
+	if target < 0 || uint(target) >= current {
+		for _, m := range migrations {
+			if m.Version > current && (target < 0 || m.Version <= uint(target)) {
+				steps = append(steps, Step{Migration: m, Up: true})
+			}
+		}
+		return steps, nil
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if m := migrations[i]; m.Version <= current && m.Version > uint(target) {
+			steps = append(steps, Step{Migration: m})
+		}
+	}
+	return steps, nil
+}
+
+// planSteps is like Plan except that it returns the first n steps up (if n is
+// positive) or down (if n is negative).
+func planSteps(migrations []*Migration, current uint, n int) ([]Step, error) {
+	target := -1
+	if n < 0 {
+		target = 0
+	}
+	steps, err := Plan(migrations, current, target)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		n = -n
+	}
+	if n > len(steps) {
+		return nil, fmt.Errorf("cannot run %d migrations (only %d can be run)", n, len(steps))
+	}
+	return steps[:n], nil
+}
+
+func hasVersion(migrations []*Migration, version uint) bool {
+	for _, m := range migrations {
+		if m.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrLocked is returned by Run if another process is migrating the database.
+var ErrLocked = errors.New("another process is running migrations")
+
+// lockName is the name of the MySQL advisory lock held while migrating.
+const lockName = "discuit_migrations"
+
+// lock acquires the migrations lock, which prevents two instances of the
+// server from migrating the same database at the same time. It returns
+// ErrLocked if the lock is held by someone else. Call the returned function to
+// release the lock.
+//
+// SQLite databases are meant to be used by a single instance, so for them the
+// lock is a no-op.
+func lock(ctx context.Context, db *sql.DB) (func(), error) {
+	if msql.IsSQLite() {
+		return func() {}, nil
+	}
+
+	// The lock is held by the connection, so a dedicated one is used. If the
+	// process dies, the connection is closed and the lock is released.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var acquired sql.NullInt32
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", lockName).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if acquired.Int32 != 1 {
+		conn.Close()
+		return nil, ErrLocked
+	}
+	return func() {
+		conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", lockName)
+		conn.Close()
+	}, nil
+}
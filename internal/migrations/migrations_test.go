@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	var migrations []*Migration
+	for _, v := range []uint{1, 2, 3, 5} {
+		migrations = append(migrations, &Migration{Version: v})
+	}
+	versions := func(steps []Step) (list []int) {
+		for _, s := range steps {
+			v := int(s.Migration.Version)
+			if !s.Up {
+				v = -v
+			}
+			list = append(list, v)
+		}
+		return
+	}
+
+	tests := []struct {
+		current uint
+		target  int
+		want    []int // Negative for down migrations.
+	}{
+		{0, -1, []int{1, 2, 3, 5}},
+		{2, -1, []int{3, 5}},
+		{5, -1, nil},
+		{1, 3, []int{2, 3}},
+		{5, 2, []int{-5, -3}},
+		{3, 0, []int{-3, -2, -1}},
+		{3, 3, nil},
+	}
+	for _, test := range tests {
+		steps, err := Plan(migrations, test.current, test.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := versions(steps); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Plan(current: %d, target: %d): expected %v, got %v", test.current, test.target, test.want, got)
+		}
+	}
+
+	if _, err := Plan(migrations, 0, 4); err == nil {
+		t.Error("Plan to a non-existent version: expected an error")
+	}
+}
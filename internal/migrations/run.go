@@ -0,0 +1,151 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	gomigrate "github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/mysql"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+// Options configure Run.
+type Options struct {
+	// The folder containing the migrations (see Dir).
+	Dir string
+
+	// The golang-migrate database URL (for example, mysql://user:pass@/db).
+	DatabaseURL string
+
+	// If Steps is 0, all pending migrations are applied. Otherwise, Steps
+	// migrations are applied (or reverted, if Steps is negative).
+	Steps int
+
+	// If To is not nil, the database is migrated up or down to version *To
+	// (and Steps is ignored). Migrating to version 0 reverts all migrations.
+	To *uint
+
+	// If DryRun is true, the migrations that would be run are logged along
+	// with their SQL, but nothing is changed.
+	DryRun bool
+
+	// Normally, Run refuses to run if any of the applied migrations has been
+	// modified since it was applied. If IgnoreChecksums is true, the check is
+	// skipped (and the recorded checksums are kept).
+	IgnoreChecksums bool
+
+	// Whether to log the migrations as they're run.
+	Log bool
+}
+
+// migrationsLogger implements the migrate.Logger interface.
+type migrationsLogger struct {
+	verbose bool
+}
+
+func (ml *migrationsLogger) Printf(format string, v ...any) {
+	log.Printf(format, v...)
+}
+
+func (ml *migrationsLogger) Verbose() bool {
+	return ml.verbose
+}
+
+// Run migrates db according to opts and returns the steps that were run (or,
+// in case of a dry run, that would have been run). The database at
+// opts.DatabaseURL and db should be the same.
+func Run(ctx context.Context, db *sql.DB, opts Options) ([]Step, error) {
+	unlock, err := lock(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	migrations, err := Load(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	current, dirty, err := CurrentVersion(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if dirty {
+		return nil, fmt.Errorf("database is dirty (migration %d failed midway); fix it manually and force the version", current)
+	}
+
+	if !opts.IgnoreChecksums {
+		list, err := modified(ctx, db, migrations)
+		if err != nil {
+			return nil, err
+		}
+		if len(list) > 0 {
+			return nil, fmt.Errorf("migration %s was modified after it was applied", list[0])
+		}
+	}
+
+	var steps []Step
+	if opts.To != nil {
+		steps, err = Plan(migrations, current, int(*opts.To))
+	} else if opts.Steps == 0 {
+		steps, err = Plan(migrations, current, -1)
+	} else {
+		steps, err = planSteps(migrations, current, opts.Steps)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.DryRun {
+		for _, step := range steps {
+			query, err := step.Migration.UpSQL()
+			if !step.Up {
+				query, err = step.Migration.DownSQL()
+			}
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("Would run %s:\n%s\n", step, query)
+		}
+		return steps, nil
+	}
+	if len(steps) == 0 {
+		return nil, nil
+	}
+
+	m, err := gomigrate.New("file://"+opts.Dir+"/", opts.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Log {
+		m.Log = &migrationsLogger{verbose: false}
+	}
+
+	if opts.To != nil {
+		if *opts.To == 0 {
+			err = m.Down()
+		} else {
+			err = m.Migrate(*opts.To)
+		}
+	} else if opts.Steps == 0 {
+		err = m.Up()
+	} else {
+		err = m.Steps(opts.Steps)
+	}
+	if err != nil && !errors.Is(err, gomigrate.ErrNoChange) {
+		m.Close()
+		return nil, err
+	}
+	if srcErr, dbErr := m.Close(); srcErr != nil || dbErr != nil {
+		return nil, errors.Join(srcErr, dbErr)
+	}
+
+	if err := recordChecksums(ctx, db, migrations); err != nil {
+		return nil, fmt.Errorf("recording migration checksums: %w", err)
+	}
+	return steps, nil
+}
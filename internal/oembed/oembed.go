@@ -0,0 +1,233 @@
+// Package oembed implements the parts of oEmbed (https://oembed.com) needed to
+// embed the content of an allowlist of providers (YouTube, Vimeo, and Twitter)
+// and to be an oEmbed provider.
+package oembed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response is an oEmbed response.
+type Response struct {
+	Type    string `json:"type"`    // One of photo, video, link, or rich.
+	Version string `json:"version"` // Always "1.0".
+
+	Title        string `json:"title,omitempty"`
+	AuthorName   string `json:"author_name,omitempty"`
+	AuthorURL    string `json:"author_url,omitempty"`
+	ProviderName string `json:"provider_name,omitempty"`
+	ProviderURL  string `json:"provider_url,omitempty"`
+	CacheAge     int    `json:"cache_age,omitempty"` // In seconds.
+
+	ThumbnailURL    string    `json:"thumbnail_url,omitempty"`
+	ThumbnailWidth  Dimension `json:"thumbnail_width,omitempty"`
+	ThumbnailHeight Dimension `json:"thumbnail_height,omitempty"`
+
+	URL    string    `json:"url,omitempty"`  // Of photos.
+	HTML   string    `json:"html,omitempty"` // Of videos and rich content.
+	Width  Dimension `json:"width,omitempty"`
+	Height Dimension `json:"height,omitempty"`
+}
+
+// Dimension is a width or a height, which some providers send as a string (or
+// as null).
+type Dimension int
+
+func (d *Dimension) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*d = 0
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*d = Dimension(f)
+	return nil
+}
+
+// Embed is the metadata of embeddable content, from which it can be rendered
+// without running any code of the provider other than in an iframe.
+type Embed struct {
+	Provider     string `json:"provider"` // One of youtube, vimeo, or twitter.
+	Type         string `json:"type"`     // The oEmbed type (video or rich).
+	Title        string `json:"title,omitempty"`
+	AuthorName   string `json:"authorName,omitempty"`
+	AuthorURL    string `json:"authorUrl,omitempty"`
+	ThumbnailURL string `json:"thumbnailUrl,omitempty"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+
+	// The URL of the provider's player, to be used as the src of an iframe.
+	EmbedURL string `json:"embedUrl"`
+}
+
+// provider is an oEmbed provider on the allowlist.
+type provider struct {
+	name     string
+	hosts    []string
+	endpoint string
+
+	// contentID returns the ID of the content at u, or an empty string if u
+	// is not the URL of embeddable content.
+	contentID func(u *url.URL) string
+
+	embedURL func(id string) string
+}
+
+var (
+	youtubeIDRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{6,20}$`)
+	numericRegexp   = regexp.MustCompile(`^[0-9]{1,25}$`)
+)
+
+var providers = []*provider{
+	{
+		name:     "youtube",
+		hosts:    []string{"youtube.com", "www.youtube.com", "m.youtube.com", "youtu.be"},
+		endpoint: "https://www.youtube.com/oembed",
+		contentID: func(u *url.URL) string {
+			var id string
+			if u.Hostname() == "youtu.be" {
+				id = strings.Trim(u.Path, "/")
+			} else if u.Path == "/watch" {
+				id = u.Query().Get("v")
+			} else if rest, ok := strings.CutPrefix(u.Path, "/shorts/"); ok {
+				id = rest
+			} else if rest, ok := strings.CutPrefix(u.Path, "/embed/"); ok {
+				id = rest
+			}
+			if !youtubeIDRegexp.MatchString(id) {
+				return ""
+			}
+			return id
+		},
+		embedURL: func(id string) string {
+			return "https://www.youtube-nocookie.com/embed/" + id
+		},
+	},
+	{
+		name:     "vimeo",
+		hosts:    []string{"vimeo.com", "www.vimeo.com", "player.vimeo.com"},
+		endpoint: "https://vimeo.com/api/oembed.json",
+		contentID: func(u *url.URL) string {
+			parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+			if id := parts[len(parts)-1]; numericRegexp.MatchString(id) {
+				return id
+			}
+			return ""
+		},
+		embedURL: func(id string) string {
+			return "https://player.vimeo.com/video/" + id
+		},
+	},
+	{
+		name:     "twitter",
+		hosts:    []string{"twitter.com", "www.twitter.com", "mobile.twitter.com", "x.com", "www.x.com"},
+		endpoint: "https://publish.twitter.com/oembed",
+		contentID: func(u *url.URL) string {
+			// /{username}/status/{id}
+			parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+			if len(parts) >= 3 && parts[1] == "status" && numericRegexp.MatchString(parts[2]) {
+				return parts[2]
+			}
+			return ""
+		},
+		embedURL: func(id string) string {
+			return "https://platform.twitter.com/embed/Tweet.html?id=" + id
+		},
+	},
+}
+
+// match returns the provider, on the allowlist, of the content at u, along
+// with the ID of the content. It returns nil if there's no such provider.
+func match(u *url.URL) (*provider, string) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, ""
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, p := range providers {
+		for _, h := range p.hosts {
+			if host == h {
+				if id := p.contentID(u); id != "" {
+					return p, id
+				}
+				return nil, ""
+			}
+		}
+	}
+	return nil, ""
+}
+
+// Supported reports whether the content at rawURL can be embedded.
+func Supported(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	p, _ := match(u)
+	return p != nil
+}
+
+// ErrNotSupported is returned by Fetch for URLs whose content can't be
+// embedded.
+var ErrNotSupported = errors.New("oembed: provider not supported")
+
+var client = &http.Client{Timeout: 5 * time.Second}
+
+// maxResponseSize is the maximum size of an oEmbed response.
+const maxResponseSize = 1 << 20
+
+// Fetch returns the Embed of the content at rawURL, the metadata of which is
+// fetched from the provider's oEmbed endpoint.
+func Fetch(ctx context.Context, rawURL string) (*Embed, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, ErrNotSupported
+	}
+	p, id := match(u)
+	if p == nil {
+		return nil, ErrNotSupported
+	}
+
+	query := url.Values{"url": {rawURL}, "format": {"json"}}
+	req, err := http.NewRequestWithContext(ctx, "GET", p.endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oembed: %s responded with status %d", p.name, res.StatusCode)
+	}
+
+	r := &Response{}
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxResponseSize)).Decode(r); err != nil {
+		return nil, err
+	}
+	return &Embed{
+		Provider:     p.name,
+		Type:         r.Type,
+		Title:        r.Title,
+		AuthorName:   r.AuthorName,
+		AuthorURL:    r.AuthorURL,
+		ThumbnailURL: r.ThumbnailURL,
+		Width:        int(r.Width),
+		Height:       int(r.Height),
+		EmbedURL:     p.embedURL(id),
+	}, nil
+}
@@ -0,0 +1,43 @@
+package oembed
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		url, provider, embedURL string
+	}{
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=10", "youtube", "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ"},
+		{"https://youtu.be/dQw4w9WgXcQ", "youtube", "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ"},
+		{"https://m.youtube.com/shorts/dQw4w9WgXcQ", "youtube", "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ"},
+		{"https://www.youtube.com/watch?v=bad\"id", "", ""},
+		{"https://www.youtube.com/channel/abc", "", ""},
+		{"https://vimeo.com/76979871", "vimeo", "https://player.vimeo.com/video/76979871"},
+		{"https://vimeo.com/channels/staffpicks/76979871", "vimeo", "https://player.vimeo.com/video/76979871"},
+		{"https://x.com/jack/status/20", "twitter", "https://platform.twitter.com/embed/Tweet.html?id=20"},
+		{"https://twitter.com/jack", "", ""},
+		{"javascript://youtube.com/watch?v=dQw4w9WgXcQ", "", ""},
+		{"https://example.com/watch?v=dQw4w9WgXcQ", "", ""},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, id := match(u)
+		if p == nil {
+			if test.provider != "" {
+				t.Errorf("%s: no provider, want %s", test.url, test.provider)
+			}
+			continue
+		}
+		if p.name != test.provider {
+			t.Errorf("%s: got provider %s, want %s", test.url, p.name, test.provider)
+		}
+		if got := p.embedURL(id); got != test.embedURL {
+			t.Errorf("%s: got embed URL %s, want %s", test.url, got, test.embedURL)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Generator generates JSON schemas from Go types, following the rules of
+// encoding/json. Named struct types are added to Schemas and referred to by
+// name.
+type Generator struct {
+	// Schemas of named struct types, keyed by type name.
+	Schemas map[string]*Schema
+
+	defined map[reflect.Type]*Schema
+	names   map[reflect.Type]string
+}
+
+// NewGenerator returns a new Generator.
+func NewGenerator() *Generator {
+	return &Generator{
+		Schemas: make(map[string]*Schema),
+		defined: make(map[reflect.Type]*Schema),
+		names:   make(map[reflect.Type]string),
+	}
+}
+
+// Define sets the schema of values of the type of v. It's meant for types that
+// implement json.Marshaler, whose JSON cannot be inferred from their
+// definitions.
+func (g *Generator) Define(v any, schema *Schema) {
+	g.defined[reflect.TypeOf(v)] = schema
+}
+
+// SchemaOf returns the schema of the JSON encoding of values of the type of v.
+// It returns nil if v is nil.
+func (g *Generator) SchemaOf(v any) *Schema {
+	if v == nil {
+		return nil
+	}
+	return g.schema(reflect.TypeOf(v))
+}
+
+func (g *Generator) schema(t reflect.Type) *Schema {
+	if s, ok := g.defined[t]; ok {
+		return s
+	}
+	if t.Kind() == reflect.Pointer {
+		s := g.schema(t.Elem())
+		if s.Ref != "" {
+			return s
+		}
+		c := *s
+		c.Nullable = true
+		return &c
+	}
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	if !implements(t, jsonMarshalerType) && implements(t, textMarshalerType) {
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 && t.Kind() == reflect.Slice {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.structSchema(t)
+	}
+	return &Schema{} // any value
+}
+
+// structSchema returns the schema of the struct type t. Named types are
+// referred to by reference.
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	if t.Name() == "" {
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		g.addFields(s, t)
+		return s
+	}
+
+	name, ok := g.names[t]
+	if !ok {
+		name = g.uniqueName(t)
+		g.names[t] = name
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		g.Schemas[name] = s // before adding fields, for recursive types
+		g.addFields(s, t)
+	}
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// uniqueName returns the name of the named type t, qualified with its package
+// name if another type of the same name exists.
+func (g *Generator) uniqueName(t reflect.Type) string {
+	name := t.Name()
+	if _, taken := g.Schemas[name]; taken {
+		pkg := t.PkgPath()
+		pkg = pkg[strings.LastIndex(pkg, "/")+1:]
+		name = pkg + "." + name
+	}
+	return name
+}
+
+// addFields adds the (JSON encoded) fields of the struct type t to the
+// properties of s.
+func (g *Generator) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(s, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = g.schema(f.Type)
+	}
+}
+
+// implements reports whether values of type t (or pointers to them) implement
+// the interface type it.
+func implements(t, it reflect.Type) bool {
+	return t.Implements(it) || reflect.PointerTo(t).Implements(it)
+}
@@ -0,0 +1,52 @@
+package openapi
+
+import (
+	"testing"
+	"time"
+)
+
+type testItem struct {
+	ID        int        `json:"id"`
+	Tags      []string   `json:"tags"`
+	Parent    *testItem  `json:"parent"`
+	CreatedAt time.Time  `json:"createdAt"`
+	DeletedAt *time.Time `json:"deletedAt"`
+	secret    string
+	Hidden    string `json:"-"`
+}
+
+type testPage struct {
+	testEmbedded
+	Items []*testItem `json:"items"`
+}
+
+type testEmbedded struct {
+	Next string `json:"next,omitempty"`
+}
+
+func TestSchemaOf(t *testing.T) {
+	g := NewGenerator()
+	s := g.SchemaOf(testPage{})
+	if s.Ref != "#/components/schemas/testPage" {
+		t.Fatalf("schema of testPage is not a reference: %+v", s)
+	}
+
+	page := g.Schemas["testPage"]
+	if page == nil || len(page.Properties) != 2 || page.Properties["next"].Type != "string" {
+		t.Fatalf("unexpected testPage schema %+v", page)
+	}
+	if items := page.Properties["items"]; items.Type != "array" || items.Items.Ref != "#/components/schemas/testItem" {
+		t.Errorf("unexpected items schema %+v", items)
+	}
+
+	item := g.Schemas["testItem"]
+	if item == nil || len(item.Properties) != 5 {
+		t.Fatalf("unexpected testItem schema %+v", item)
+	}
+	if p := item.Properties["parent"]; p.Ref != "#/components/schemas/testItem" {
+		t.Errorf("recursive field schema is %+v", p)
+	}
+	if p := item.Properties["deletedAt"]; p.Format != "date-time" || !p.Nullable {
+		t.Errorf("deletedAt schema is %+v", p)
+	}
+}
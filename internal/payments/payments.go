@@ -0,0 +1,77 @@
+// Package payments turns the webhooks of payment services (like Stripe) into
+// the payments of users for the perks of supporters.
+package payments
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// An EventType is the type of an Event.
+type EventType string
+
+const (
+	EventPurchase = EventType("purchase")
+	EventRefund   = EventType("refund")
+)
+
+// An Event is a payment for the perks of supporters being made, or refunded.
+type Event struct {
+	Type EventType
+
+	// PaymentID identifies the payment with the provider. It's the same in a
+	// purchase and in its refund.
+	PaymentID string
+
+	// The username of the user who gets the perks, and, if they are a gift,
+	// that of the user who paid for them. Only set for purchases.
+	Username string
+	GiftedBy string
+
+	// The days the perks last. Zero means the default of the site.
+	Days int
+}
+
+// ErrInvalidSignature is returned by Provider.ParseWebhook for requests that
+// were not sent by the provider.
+var ErrInvalidSignature = errors.New("payments: invalid webhook signature")
+
+// A Provider is a payment service that tells the site of payments with
+// webhooks (at /webhooks/payments/{name}).
+type Provider interface {
+	// Name is the name of the provider in the URL of its webhook.
+	Name() string
+
+	// ParseWebhook verifies the webhook request, with the header header and
+	// the body body, and returns its event, or nil if the event is not about
+	// a payment for the perks of supporters.
+	ParseWebhook(header http.Header, body []byte) (*Event, error)
+}
+
+var (
+	mu        sync.Mutex // Guards providers.
+	providers = make(map[string]Provider)
+)
+
+// Register adds p to the providers of all sites. Providers compiled in by
+// plugins call this in init functions (those configured, like Stripe, are
+// added by the server).
+func Register(p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[p.Name()] = p
+}
+
+// Providers returns the registered providers, in the order of their names.
+func Providers() []Provider {
+	mu.Lock()
+	defer mu.Unlock()
+	var ps []Provider
+	for _, p := range providers {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Name() < ps[j].Name() })
+	return ps
+}
@@ -0,0 +1,124 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripeTolerance is how old the timestamps of the webhook requests of Stripe
+// may be (so that requests can't be replayed later).
+const stripeTolerance = 5 * time.Minute
+
+// Stripe is the Provider of Stripe. Payments are made with Stripe Checkout,
+// with the username of the user who gets the perks as the
+// client_reference_id of the session, and, optionally, the metadata gifted_by
+// (the username of the user paying, if it's a gift) and days. The webhook is
+// to be sent the checkout.session.completed and charge.refunded events.
+type Stripe struct {
+	WebhookSecret string // The signing secret of the webhook endpoint.
+}
+
+// Name implements Provider.
+func (s *Stripe) Name() string {
+	return "stripe"
+}
+
+// ParseWebhook implements Provider.
+func (s *Stripe) ParseWebhook(header http.Header, body []byte) (*Event, error) {
+	if err := s.verify(header.Get("Stripe-Signature"), body, time.Now()); err != nil {
+		return nil, err
+	}
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object json.RawMessage `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("payments: parsing stripe event: %w", err)
+	}
+
+	switch event.Type {
+	case "checkout.session.completed":
+		var session struct {
+			ID                string            `json:"id"`
+			PaymentIntent     string            `json:"payment_intent"`
+			PaymentStatus     string            `json:"payment_status"`
+			ClientReferenceID string            `json:"client_reference_id"`
+			Metadata          map[string]string `json:"metadata"`
+		}
+		if err := json.Unmarshal(event.Data.Object, &session); err != nil {
+			return nil, fmt.Errorf("payments: parsing stripe checkout session: %w", err)
+		}
+		if session.PaymentStatus != "paid" || session.ClientReferenceID == "" {
+			return nil, nil
+		}
+		paymentID := session.PaymentIntent // As in the charge of a refund.
+		if paymentID == "" {
+			paymentID = session.ID
+		}
+		days, _ := strconv.Atoi(session.Metadata["days"])
+		return &Event{
+			Type:      EventPurchase,
+			PaymentID: paymentID,
+			Username:  session.ClientReferenceID,
+			GiftedBy:  session.Metadata["gifted_by"],
+			Days:      days,
+		}, nil
+	case "charge.refunded":
+		var charge struct {
+			PaymentIntent string `json:"payment_intent"`
+			Refunded      bool   `json:"refunded"` // Fully.
+		}
+		if err := json.Unmarshal(event.Data.Object, &charge); err != nil {
+			return nil, fmt.Errorf("payments: parsing stripe charge: %w", err)
+		}
+		if !charge.Refunded || charge.PaymentIntent == "" {
+			return nil, nil
+		}
+		return &Event{Type: EventRefund, PaymentID: charge.PaymentIntent}, nil
+	}
+	return nil, nil
+}
+
+// verify checks the Stripe-Signature header, sig, of a webhook request with
+// the body body, received at now.
+func (s *Stripe) verify(sig string, body []byte, now time.Time) error {
+	var (
+		timestamp  string
+		signatures []string
+	)
+	for _, part := range strings.Split(sig, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+	if d := now.Sub(time.Unix(t, 0)); d > stripeTolerance || d < -stripeTolerance {
+		return ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(s.WebhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
@@ -0,0 +1,60 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func stripeSignature(secret string, t time.Time, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac.Write([]byte(ts + "." + body))
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestStripeParseWebhook(t *testing.T) {
+	s := &Stripe{WebhookSecret: "whsec_test"}
+	webhook := func(sig, body string) (*Event, error) {
+		h := http.Header{}
+		h.Set("Stripe-Signature", sig)
+		return s.ParseWebhook(h, []byte(body))
+	}
+
+	purchase := `{"type": "checkout.session.completed", "data": {"object": {"id": "cs_1", "payment_intent": "pi_1", "payment_status": "paid",
+		"client_reference_id": "alice", "metadata": {"gifted_by": "bob", "days": "365"}}}}`
+	event, err := webhook(stripeSignature("whsec_test", time.Now(), purchase), purchase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Event{Type: EventPurchase, PaymentID: "pi_1", Username: "alice", GiftedBy: "bob", Days: 365}
+	if event == nil || *event != want {
+		t.Errorf("got %+v, want %+v", event, want)
+	}
+
+	refund := `{"type": "charge.refunded", "data": {"object": {"id": "ch_1", "payment_intent": "pi_1", "refunded": true}}}`
+	event, err = webhook(stripeSignature("whsec_test", time.Now(), refund), refund)
+	if err != nil || event == nil || *event != (Event{Type: EventRefund, PaymentID: "pi_1"}) {
+		t.Errorf("refund: got %+v, %v", event, err)
+	}
+
+	other := `{"type": "customer.created", "data": {"object": {}}}`
+	if event, err := webhook(stripeSignature("whsec_test", time.Now(), other), other); err != nil || event != nil {
+		t.Errorf("other event: got %+v, %v", event, err)
+	}
+
+	for name, sig := range map[string]string{
+		"wrong secret": stripeSignature("whsec_other", time.Now(), purchase),
+		"too old":      stripeSignature("whsec_test", time.Now().Add(-time.Hour), purchase),
+		"missing":      "",
+	} {
+		if _, err := webhook(sig, purchase); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: got %v, want ErrInvalidSignature", name, err)
+		}
+	}
+}
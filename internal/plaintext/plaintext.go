@@ -0,0 +1,113 @@
+// Package plaintext renders Markdown as plain text, for screen readers and
+// clients that don't render Markdown.
+package plaintext
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	fence       = regexp.MustCompile("^\\s*(```|~~~)")
+	heading     = regexp.MustCompile(`^\s{0,3}#{1,6}\s+`)
+	headingEnd  = regexp.MustCompile(`\s+#+\s*$`)
+	quote       = regexp.MustCompile(`^\s{0,3}(>\s?)+`)
+	bullet      = regexp.MustCompile(`^(\s*)[*+]\s+`)
+	rule        = regexp.MustCompile(`^\s{0,3}([-*_]\s*){3,}$`)
+	link        = regexp.MustCompile(`(!?)\[([^\]]*)\]\(\s*<?([^)\s>]+)>?(?:\s+"[^"]*")?\s*\)`) // and images
+	autolink    = regexp.MustCompile(`<((?:https?|mailto):[^>\s]+)>`)
+	code        = regexp.MustCompile("`+([^`]+)`+")
+	strong      = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+	emphasis    = regexp.MustCompile(`\*(\S(?:.*?\S)?)\*`)
+	underscored = regexp.MustCompile(`(^|[^\pL\pN_])_(\S(?:.*?\S)?)_([^\pL\pN_]|$)`)
+	strike      = regexp.MustCompile(`~~(.+?)~~`)
+	escaped     = regexp.MustCompile(`\\([\\` + "`" + `*_{}\[\]()#+\-.!>~|])`)
+	blankLines  = regexp.MustCompile(`\n{3,}`)
+)
+
+// escapedBase is the start of the private use area, to which escaped
+// characters are mapped while the Markdown syntax around them is stripped.
+const escapedBase = 0xF0000
+
+// FromMarkdown returns the Markdown text md as plain text: formatting is
+// removed, and links (and images) are replaced by their text followed by a
+// footnote number, with the URLs listed, by number, at the end.
+func FromMarkdown(md string) string {
+	md = strings.ReplaceAll(md, "\r\n", "\n")
+	md = escaped.ReplaceAllStringFunc(md, func(s string) string {
+		return string(rune(escapedBase + int(s[1])))
+	})
+
+	var (
+		b       strings.Builder
+		urls    []string
+		inFence bool
+	)
+	footnote := func(url string) string {
+		for i, u := range urls {
+			if u == url {
+				return " [" + strconv.Itoa(i+1) + "]"
+			}
+		}
+		urls = append(urls, url)
+		return " [" + strconv.Itoa(len(urls)) + "]"
+	}
+	for _, line := range strings.Split(md, "\n") {
+		if fence.MatchString(line) {
+			inFence = !inFence
+			continue
+		}
+		if !inFence {
+			line = inline(line, footnote)
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+
+	text := blankLines.ReplaceAllString(strings.TrimSpace(b.String()), "\n\n")
+	if len(urls) > 0 {
+		text += "\n\n"
+		for i, u := range urls {
+			text += "[" + strconv.Itoa(i+1) + "] " + u + "\n"
+		}
+		text = strings.TrimSuffix(text, "\n")
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= escapedBase && r < escapedBase+128 {
+			return r - escapedBase
+		}
+		return r
+	}, text)
+}
+
+// inline strips the Markdown of a line outside a code block.
+func inline(line string, footnote func(url string) string) string {
+	if rule.MatchString(line) {
+		return ""
+	}
+	if heading.MatchString(line) {
+		line = headingEnd.ReplaceAllString(heading.ReplaceAllString(line, ""), "")
+	}
+	line = quote.ReplaceAllString(line, "")
+	line = bullet.ReplaceAllString(line, "$1- ")
+
+	line = link.ReplaceAllStringFunc(line, func(s string) string {
+		m := link.FindStringSubmatch(s)
+		text, url := strings.TrimSpace(m[2]), m[3]
+		if m[1] == "!" && text == "" {
+			text = "image"
+		}
+		if text == url {
+			return url
+		}
+		return text + footnote(url)
+	})
+	line = autolink.ReplaceAllString(line, "$1")
+	line = code.ReplaceAllString(line, "$1")
+	line = strong.ReplaceAllString(line, "$2")
+	line = emphasis.ReplaceAllString(line, "$1")
+	line = underscored.ReplaceAllString(line, "$1$2$3")
+	line = strike.ReplaceAllString(line, "$1")
+	return line
+}
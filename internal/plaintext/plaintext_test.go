@@ -0,0 +1,28 @@
+package plaintext
+
+import "testing"
+
+func TestFromMarkdown(t *testing.T) {
+	tests := []struct {
+		md, expect string
+	}{
+		{"Hello", "Hello"},
+		{"# Title #\n\nSome **bold**, *italic*, _also_, and ~~struck~~ text.", "Title\n\nSome bold, italic, also, and struck text."},
+		{"snake_case_name and 2 * 3 * 4", "snake_case_name and 2 * 3 * 4"},
+		{"> quoted\n> > nested", "quoted\nnested"},
+		{"* one\n+ two\n  * three\n1. four", "- one\n- two\n  - three\n1. four"},
+		{"See [the docs](https://example.com/docs) and [again](https://example.com/docs).",
+			"See the docs [1] and again [1].\n\n[1] https://example.com/docs"},
+		{"![a cat](https://example.com/cat.png \"Cat\") [x](https://a.example) ![](https://b.example/i.png)",
+			"a cat [1] x [2] image [3]\n\n[1] https://example.com/cat.png\n[2] https://a.example\n[3] https://b.example/i.png"},
+		{"[https://example.com](https://example.com) <https://example.org>", "https://example.com https://example.org"},
+		{"Use `go vet` here.\n\n```\n**not bold**\n```", "Use go vet here.\n\n**not bold**"},
+		{"\\*not italic\\* and \\[not a link\\](x)", "*not italic* and [not a link](x)"},
+		{"above\n\n---\n\n\n\nbelow", "above\n\nbelow"},
+	}
+	for _, test := range tests {
+		if got := FromMarkdown(test.md); got != test.expect {
+			t.Errorf("FromMarkdown(%q) = %q, expected %q", test.md, got, test.expect)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package ratelimits
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Policy is a token bucket rate limit. The bucket holds at most Burst tokens
+// and is refilled at the rate of Rate tokens every Interval seconds. Every
+// request takes a token from the bucket, and requests are denied when the
+// bucket is empty.
+type Policy struct {
+	Rate     int `json:"rate"`
+	Interval int `json:"interval"` // in seconds
+	Burst    int `json:"burst"`
+}
+
+// Validate returns an error if p is not a valid policy.
+func (p Policy) Validate() error {
+	if p.Rate <= 0 || p.Interval <= 0 || p.Burst <= 0 {
+		return errors.New("rate, interval, and burst must be positive")
+	}
+	return nil
+}
+
+// refillRate returns the number of tokens added to the bucket per second.
+func (p Policy) refillRate() float64 {
+	return float64(p.Rate) / float64(p.Interval)
+}
+
+// Window returns the time it takes for an empty bucket to be filled.
+func (p Policy) Window() time.Duration {
+	return time.Duration(float64(p.Burst) / p.refillRate() * float64(time.Second))
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed bool
+
+	// The size of the bucket (the policy's burst).
+	Limit int
+
+	// The number of whole tokens left in the bucket.
+	Remaining int
+
+	// The time until the bucket is full again.
+	Reset time.Duration
+
+	// If the request was denied, the time until a token is available.
+	RetryAfter time.Duration
+}
+
+func newResult(p Policy, allowed bool, tokens float64) Result {
+	rate := p.refillRate()
+	seconds := func(n float64) time.Duration {
+		return time.Duration(math.Max(n, 0) / rate * float64(time.Second))
+	}
+	r := Result{
+		Allowed:   allowed,
+		Limit:     p.Burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds(float64(p.Burst) - tokens),
+	}
+	if !allowed {
+		r.RetryAfter = seconds(1 - tokens)
+	}
+	return r
+}
+
+// takeScript takes a token from the bucket stored in the hash KEYS[1], after
+// refilling it for the time elapsed since it was last updated. The arguments
+// are the size of the bucket, the number of tokens added per millisecond, and
+// the current time in milliseconds. It returns whether a token was taken and
+// the number of tokens left (as a string, since Lua numbers are truncated to
+// integers in replies).
+var takeScript = redis.NewScript(1, `
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(now - ts, 0) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// Take takes a token from the bucket bucketID, which is limited by policy p.
+func Take(conn redis.Conn, bucketID string, p Policy) (Result, error) {
+	if err := p.Validate(); err != nil {
+		return Result{}, err
+	}
+	now := time.Now().UnixMilli()
+	reply, err := redis.Values(takeScript.Do(conn, redisKey(bucketID, "tb"), p.Burst, p.refillRate()/1000, now))
+	if err != nil {
+		return Result{}, err
+	}
+	var allowed int
+	var tokensText string
+	if _, err := redis.Scan(reply, &allowed, &tokensText); err != nil {
+		return Result{}, err
+	}
+	tokens, err := strconv.ParseFloat(tokensText, 64)
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(p, allowed == 1, tokens), nil
+}
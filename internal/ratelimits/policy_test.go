@@ -0,0 +1,36 @@
+package ratelimits
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewResult(t *testing.T) {
+	p := Policy{Rate: 60, Interval: 60, Burst: 10} // a token per second
+
+	r := newResult(p, true, 4.5)
+	if !r.Allowed || r.Limit != 10 || r.Remaining != 4 || r.RetryAfter != 0 {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if r.Reset != 5500*time.Millisecond {
+		t.Errorf("reset is %v (want 5.5s)", r.Reset)
+	}
+
+	r = newResult(p, false, 0.25)
+	if r.Allowed || r.Remaining != 0 {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if r.RetryAfter != 750*time.Millisecond {
+		t.Errorf("retry after is %v (want 750ms)", r.RetryAfter)
+	}
+}
+
+func TestPolicyWindow(t *testing.T) {
+	p := Policy{Rate: 70, Interval: 86400, Burst: 7}
+	if got := p.Window(); got != 144*time.Minute {
+		t.Errorf("window is %v (want 2h24m)", got)
+	}
+	if err := (Policy{Rate: 1, Interval: 0, Burst: 1}).Validate(); err == nil {
+		t.Error("policy with a zero interval is valid")
+	}
+}
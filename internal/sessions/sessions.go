@@ -1,8 +1,9 @@
 package sessions
 
 import (
+	"crypto/rand"
 	"encoding/json"
-	"math/rand"
+	"math/big"
 	"net/http"
 	"time"
 
@@ -21,6 +22,12 @@ type Store interface {
 	// Save saves the session to the underlying store and sets http cookie
 	// headers.
 	Save(w http.ResponseWriter, r *http.Request, s *Session) error
+
+	// Regenerate gives the session a new ID, keeping its values, and deletes
+	// it from the store under the old ID. Call it whenever the privileges of
+	// the session change (on login and logout, for instance), so that an ID
+	// obtained before then is of no use after.
+	Regenerate(w http.ResponseWriter, r *http.Request, s *Session) error
 }
 
 // Session stores a map of session values.
@@ -46,6 +53,11 @@ func (s *Session) Save(w http.ResponseWriter, r *http.Request) error {
 	return s.store.Save(w, r, s)
 }
 
+// Regenerate implements Store.Regenerate method.
+func (s *Session) Regenerate(w http.ResponseWriter, r *http.Request) error {
+	return s.store.Regenerate(w, r, s)
+}
+
 // Clear sets s.Values to a new map. The new map is not persisted to the store
 // until Save is called.
 func (s *Session) Clear() {
@@ -61,19 +73,27 @@ type RedisStore struct {
 	// Session ID length (ie cookie value).
 	IDLength int
 
+	// The SameSite attribute of the session cookie (http.SameSiteLaxMode by
+	// default).
+	SameSite http.SameSite
+
+	// If non-nil, Secure reports whether the session cookie set in response
+	// to r is to be marked secure (otherwise it always is).
+	Secure func(r *http.Request) bool
+
 	pool *redis.Pool
 }
 
-// NewRedisStore returns a session store that uses Redis for storage. Redis
-// runs on tcp port 6379 by default.
-func NewRedisStore(network, address, cookieName string) (*RedisStore, error) {
-	store := &RedisStore{CookieName: cookieName, IDLength: defaultSessionIDLength}
+// NewRedisStore returns a session store that uses the Redis database db (by
+// index) for storage. Redis runs on tcp port 6379 by default.
+func NewRedisStore(network, address string, db int, cookieName string) (*RedisStore, error) {
+	store := &RedisStore{CookieName: cookieName, IDLength: defaultSessionIDLength, SameSite: http.SameSiteLaxMode}
 	store.pool = &redis.Pool{
 		MaxIdle: 30,
 		// MaxActive:   10,
 		IdleTimeout: 240 * time.Second,
 		Dial: func() (redis.Conn, error) {
-			return redis.Dial(network, address)
+			return redis.Dial(network, address, redis.DialDatabase(db))
 		},
 	}
 
@@ -143,11 +163,11 @@ func (rs *RedisStore) Save(w http.ResponseWriter, r *http.Request, s *Session) e
 		http.SetCookie(w, &http.Cookie{
 			Name:     rs.CookieName,
 			Value:    s.ID,
-			Secure:   true,
+			Secure:   rs.Secure == nil || rs.Secure(r),
 			HttpOnly: true,
 			Path:     "/",
 			Expires:  time.Now().UTC().Add(expires),
-			SameSite: http.SameSiteLaxMode,
+			SameSite: rs.SameSite,
 		})
 		s.CookieSet = true
 	}
@@ -164,18 +184,36 @@ func (rs *RedisStore) Save(w http.ResponseWriter, r *http.Request, s *Session) e
 	return err
 }
 
+// Regenerate gives s a new ID, keeping its values, deletes it from Redis under
+// the old ID, and saves it under the new one (setting the session cookie).
+func (rs *RedisStore) Regenerate(w http.ResponseWriter, r *http.Request, s *Session) error {
+	conn := rs.pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("DEL", rs.RedisKey(s.ID)); err != nil {
+		return err
+	}
+	s.ID = generateID(rs.IDLength)
+	s.CookieSet = false
+	return rs.Save(w, r, s)
+}
+
 // RedisKey returns the key the session data is stored in Redis.
 func (rs *RedisStore) RedisKey(sessionID string) string {
 	return "rs_" + rs.CookieName + ":" + sessionID
 }
 
+// generateID returns a random session ID of length characters, drawn from a
+// cryptographically secure source.
 func generateID(length int) string {
 	letters := "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz_"
-	var id string
-
-	for i := 0; i < length; i++ {
-		id = id + string(letters[rand.Intn(len(letters))])
+	max := big.NewInt(int64(len(letters)))
+	id := make([]byte, length)
+	for i := range id {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err) // crypto/rand never fails on supported platforms.
+		}
+		id[i] = letters[n.Int64()]
 	}
-
-	return id
+	return string(id)
 }
@@ -0,0 +1,236 @@
+// Package sharecard renders the images (like those of the og:image meta tags)
+// shown in the previews of the pages of posts shared on other sites.
+package sharecard
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// The size of cards, in pixels (the size recommended for og:image).
+const (
+	Width  = 1200
+	Height = 630
+)
+
+const (
+	margin        = 72
+	maxTitleLines = 4
+)
+
+var (
+	background = color.RGBA{0x1b, 0x1b, 0x1f, 0xff}
+	foreground = color.RGBA{0xf2, 0xf2, 0xf2, 0xff}
+	muted      = color.RGBA{0xa0, 0xa0, 0xa8, 0xff}
+	accent     = color.RGBA{0x4d, 0x9d, 0xe0, 0xff}
+)
+
+// A Card is the text on the card of a post.
+type Card struct {
+	SiteName  string
+	Community string
+	Title     string
+	Points    int
+	Comments  int
+}
+
+// key returns a string that's unique to the text of c (for caching).
+func (c *Card) key() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{c.SiteName, c.Community, c.Title, strconv.Itoa(c.Points), strconv.Itoa(c.Comments)}, "\x00")))
+	return hex.EncodeToString(sum[:16])
+}
+
+type faces struct {
+	title, community, stats font.Face
+}
+
+var (
+	facesOnce sync.Once
+	facesErr  error
+	loaded    faces
+)
+
+func loadFaces() (*faces, error) {
+	facesOnce.Do(func() {
+		face := func(ttf []byte, size float64) font.Face {
+			if facesErr != nil {
+				return nil
+			}
+			f, err := opentype.Parse(ttf)
+			if err != nil {
+				facesErr = err
+				return nil
+			}
+			var face font.Face
+			face, facesErr = opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+			return face
+		}
+		loaded = faces{
+			title:     face(gobold.TTF, 64),
+			community: face(gobold.TTF, 36),
+			stats:     face(goregular.TTF, 34),
+		}
+	})
+	return &loaded, facesErr
+}
+
+// Render renders c as a PNG image.
+func Render(c *Card) ([]byte, error) {
+	faces, err := loadFaces()
+	if err != nil {
+		return nil, fmt.Errorf("sharecard: loading fonts: %w", err)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 16, Height), image.NewUniform(accent), image.Point{}, draw.Src)
+
+	drawText := func(face font.Face, col color.Color, x, y int, text string) {
+		d := &font.Drawer{Dst: img, Src: image.NewUniform(col), Face: face, Dot: fixed.P(x, y)}
+		d.DrawString(text)
+	}
+
+	y := margin + faces.community.Metrics().Ascent.Ceil()
+	drawText(faces.community, accent, margin, y, fitLine(faces.community, c.Community, Width-2*margin))
+
+	lineHeight := faces.title.Metrics().Height.Ceil() + 8
+	y += 40
+	for _, line := range wrap(faces.title, c.Title, Width-2*margin, maxTitleLines) {
+		y += lineHeight
+		drawText(faces.title, foreground, margin, y, line)
+	}
+
+	stats := pluralize(c.Points, "point") + "  •  " + pluralize(c.Comments, "comment")
+	drawText(faces.stats, muted, margin, Height-margin, stats)
+	if c.SiteName != "" {
+		w := font.MeasureString(faces.stats, c.SiteName).Ceil()
+		drawText(faces.stats, muted, Width-margin-w, Height-margin, c.SiteName)
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func pluralize(n int, noun string) string {
+	if n == 1 || n == -1 {
+		return strconv.Itoa(n) + " " + noun
+	}
+	return strconv.Itoa(n) + " " + noun + "s"
+}
+
+// wrap breaks text into at most maxLines lines that are each at most width
+// pixels wide, as drawn in face. If text doesn't fit, the last line ends with
+// an ellipsis.
+func wrap(face font.Face, text string, width, maxLines int) []string {
+	var lines []string
+	line := ""
+	words := strings.Fields(text)
+	for i, word := range words {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if font.MeasureString(face, candidate).Ceil() <= width {
+			line = candidate
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		if len(lines) == maxLines {
+			lines[maxLines-1] = fitLine(face, lines[maxLines-1]+" "+strings.Join(words[i:], " "), width)
+			return lines
+		}
+		line = fitLine(face, word, width) // A word longer than a line is cut.
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// fitLine returns text, or, if it's wider than width pixels as drawn in face,
+// as much of it as fits followed by an ellipsis.
+func fitLine(face font.Face, text string, width int) string {
+	if font.MeasureString(face, text).Ceil() <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		if s := strings.TrimSpace(string(runes)) + "…"; font.MeasureString(face, s).Ceil() <= width {
+			return s
+		}
+	}
+	return "…"
+}
+
+// A Cache keeps rendered cards, as files in a folder, so that a card is
+// rendered again only if its text changes.
+type Cache struct {
+	Dir string
+}
+
+// Get returns the PNG image of c, rendering it if it's not in the cache.
+func (cache *Cache) Get(name string, c *Card) ([]byte, error) {
+	file := filepath.Join(cache.Dir, name+"_"+c.key()+".png")
+	if data, err := os.ReadFile(file); err == nil {
+		return data, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	data, err := Render(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cache.Dir, 0755); err != nil {
+		return nil, err
+	}
+	// Cards of the previous text of the card are left to Prune.
+	return data, os.WriteFile(file, data, 0644)
+}
+
+// Prune deletes the cards that were rendered more than ttl ago.
+func (cache *Cache) Prune(ttl time.Duration) error {
+	entries, err := os.ReadDir(cache.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if time.Since(info.ModTime()) > ttl {
+			if err := os.Remove(filepath.Join(cache.Dir, e.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
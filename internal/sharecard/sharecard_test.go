@@ -0,0 +1,68 @@
+package sharecard
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	data, err := Render(&Card{
+		SiteName:  "Discuit",
+		Community: "general",
+		Title:     "A title long enough to be wrapped onto more than one line of the card, and then some more words so that it has to be cut with an ellipsis at the end of the last line",
+		Points:    1,
+		Comments:  12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != Width || b.Dy() != Height {
+		t.Errorf("got a %dx%d image", b.Dx(), b.Dy())
+	}
+}
+
+func TestWrap(t *testing.T) {
+	faces, err := loadFaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := wrap(faces.title, "word word word word word word word word word word word word word word word word word word word word word word word word word word word word word word", 400, 3)
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines: %q", len(lines), lines)
+	}
+	if last := []rune(lines[2]); last[len(last)-1] != '…' {
+		t.Errorf("last line %q has no ellipsis", lines[2])
+	}
+	if lines := wrap(faces.title, "short", 400, 3); len(lines) != 1 || lines[0] != "short" {
+		t.Errorf("got %q", lines)
+	}
+}
+
+func TestCache(t *testing.T) {
+	cache := &Cache{Dir: t.TempDir()}
+	card := &Card{Community: "general", Title: "Title"}
+	a, err := cache.Get("post", card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := cache.Get("post", card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Error("cached card differs")
+	}
+	if err := cache.Prune(-time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(cache.Dir); len(entries) != 0 {
+		t.Errorf("%d cards left after pruning", len(entries))
+	}
+}
@@ -0,0 +1,72 @@
+// Package simhash computes locality-sensitive fingerprints of texts: texts
+// that differ only slightly have fingerprints that differ only in a few bits.
+package simhash
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+// shingleSize is the number of runes in each of the features of a text.
+const shingleSize = 4
+
+// Normalize returns text lowercased, with each run of characters other than
+// letters and digits replaced by a single space, and trimmed of spaces.
+func Normalize(text string) string {
+	var b strings.Builder
+	space := true
+	for _, c := range text {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			b.WriteRune(unicode.ToLower(c))
+			space = false
+		} else if !space {
+			b.WriteByte(' ')
+			space = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), " ")
+}
+
+// Hash returns the 64-bit simhash of text. The features of the text are the
+// overlapping sequences of 4 runes of its normalized form (see Normalize).
+func Hash(text string) uint64 {
+	runes := []rune(Normalize(text))
+	if len(runes) == 0 {
+		return 0
+	}
+
+	var weights [64]int
+	add := func(feature []rune) {
+		h := fnv.New64a()
+		h.Write([]byte(string(feature)))
+		sum := h.Sum64()
+		for i := range weights {
+			if sum&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+	if len(runes) < shingleSize {
+		add(runes)
+	}
+	for i := 0; i+shingleSize <= len(runes); i++ {
+		add(runes[i : i+shingleSize])
+	}
+
+	var hash uint64
+	for i, w := range weights {
+		if w > 0 {
+			hash |= 1 << i
+		}
+	}
+	return hash
+}
+
+// Distance returns the number of bits in which a and b differ.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
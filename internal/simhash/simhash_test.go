@@ -0,0 +1,39 @@
+package simhash
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{"", ""},
+		{"  Hello, World!!  ", "hello world"},
+		{"Buy-NOW at example.com", "buy now at example com"},
+	}
+	for _, test := range tests {
+		if got := Normalize(test.in); got != test.out {
+			t.Errorf("Normalize(%q) = %q, expected %q", test.in, got, test.out)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	spam := "Get free crypto now at my site, limited offer for the first hundred people who sign up today!"
+	tests := []struct {
+		a, b    string
+		maxDist int // If negative, the distance must be greater than -maxDist.
+	}{
+		{spam, spam, 0},
+		{spam, "GET FREE CRYPTO now at my site -- limited offer for the first hundred people who sign up today", 0},
+		{spam, "Get free crypto now at my site, limited offer for the first hundred people who sign up tonight!", 6},
+		{spam, "I don't think the author considered how the caching layer interacts with the session cookies.", -12},
+	}
+	for _, test := range tests {
+		d := Distance(Hash(test.a), Hash(test.b))
+		if test.maxDist >= 0 && d > test.maxDist {
+			t.Errorf("distance between %q and %q is %d, expected at most %d", test.a, test.b, d, test.maxDist)
+		} else if test.maxDist < 0 && d <= -test.maxDist {
+			t.Errorf("distance between %q and %q is %d, expected more than %d", test.a, test.b, d, -test.maxDist)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Names of the supported database drivers (as registered with database/sql).
+const (
+	DriverMySQL  = "mysql"
+	DriverSQLite = "sqlite3"
+)
+
+var driverName = DriverMySQL
+
+// SetDriver sets the database driver in use, which determines the SQL dialect
+// of the queries built by this package. It should be called once, before the
+// database is first used. The default is DriverMySQL.
+func SetDriver(name string) {
+	switch name {
+	case DriverMySQL, DriverSQLite:
+		driverName = name
+	default:
+		panic(fmt.Sprintf("unsupported database driver %q", name))
+	}
+}
+
+// Driver returns the database driver in use.
+func Driver() string {
+	return driverName
+}
+
+// IsSQLite reports whether the database in use is SQLite.
+func IsSQLite() bool {
+	return driverName == DriverSQLite
+}
+
+// OnConflictUpdate returns the start of the clause of an INSERT statement that
+// updates the existing row, instead of failing, when a row with the same unique
+// key (made up of the columns keys) already exists. It's to be followed by a
+// list of assignments (of the form "col = value").
+func OnConflictUpdate(keys ...string) string {
+	if IsSQLite() {
+		return "ON CONFLICT (" + strings.Join(keys, ", ") + ") DO UPDATE SET "
+	}
+	return "ON DUPLICATE KEY UPDATE "
+}
+
+// Inserted returns the expression, for use in an OnConflictUpdate clause, that
+// refers to the value of col that was to be inserted.
+func Inserted(col string) string {
+	if IsSQLite() {
+		return "excluded." + col
+	}
+	return "VALUES(" + col + ")"
+}
+
+// UpsertClause returns an OnConflictUpdate clause that sets the columns cols of
+// the existing row to the values being inserted.
+func UpsertClause(keys []string, cols ...string) string {
+	var b strings.Builder
+	b.WriteString(OnConflictUpdate(keys...))
+	for i, col := range cols {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(col + " = " + Inserted(col))
+	}
+	return b.String()
+}
+
+// ForUpdate returns the clause of a SELECT statement (run in a transaction)
+// that locks the rows selected until the end of the transaction. It's empty for
+// SQLite, which doesn't have row locks (writing transactions don't run at the
+// same time).
+func ForUpdate() string {
+	if IsSQLite() {
+		return ""
+	}
+	return " FOR UPDATE"
+}
+
+// RandomFunc returns the SQL function that returns a random number (for
+// ordering rows randomly).
+func RandomFunc() string {
+	if IsSQLite() {
+		return "RANDOM()"
+	}
+	return "RAND()"
+}
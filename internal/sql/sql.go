@@ -316,14 +316,30 @@ func BuildSelectQuery(table string, columns, joins []string, whereClause string)
 }
 
 // IsErrDuplicateErr checks MySQL/MariaDB error string
-// to see a '1062' can be found.
+// to see a '1062' can be found (or, for SQLite, whether
+// a unique constraint failed).
 func IsErrDuplicateErr(err error) bool {
 	if err == nil {
 		return false
 	}
+	if IsSQLite() {
+		return strings.Contains(err.Error(), "UNIQUE constraint failed")
+	}
 	return strings.Contains(err.Error(), "1062")
 }
 
+// IsErrNoTable reports whether err is the error returned when a query refers
+// to a table that doesn't exist.
+func IsErrNoTable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if IsSQLite() {
+		return strings.Contains(err.Error(), "no such table")
+	}
+	return strings.Contains(err.Error(), "1146")
+}
+
 // In question mark returns a string of the format
 // "(?, ?, ?)" where there are n question marks.
 // It panics if n <= 0.
@@ -381,7 +397,7 @@ func Transact(ctx context.Context, db *sql.DB, f func(tx *sql.Tx) error) error {
 	}
 
 	if err := f(tx); err != nil {
-		if rErr := tx.Rollback(); err != nil {
+		if rErr := tx.Rollback(); rErr != nil {
 			return fmt.Errorf("%w (rollback error: %w)", err, rErr)
 		}
 		return err
@@ -359,3 +359,28 @@ func TestBuildInsertQuery(t *testing.T) {
 		}
 	}
 }
+
+func BenchmarkBuildSelectQuery(b *testing.B) {
+	cols := []string{"comments.id", "comments.post_id", "comments.user_id", "comments.body", "comments.upvotes", "comments.created_at"}
+	joins := []string{"LEFT OUTER JOIN comment_votes ON comments.id = comment_votes.comment_id AND comment_votes.user_id = ?"}
+	for i := 0; i < b.N; i++ {
+		BuildSelectQuery("comments", cols, joins, "WHERE comments.post_id = ? ORDER BY upvotes DESC LIMIT ?")
+	}
+}
+
+func TestUpsertClause(t *testing.T) {
+	defer SetDriver(Driver())
+	tests := []struct {
+		driver string
+		want   string
+	}{
+		{DriverMySQL, "ON DUPLICATE KEY UPDATE a = VALUES(a), b = VALUES(b)"},
+		{DriverSQLite, "ON CONFLICT (id, sort) DO UPDATE SET a = excluded.a, b = excluded.b"},
+	}
+	for _, test := range tests {
+		SetDriver(test.driver)
+		if got := UpsertClause([]string{"id", "sort"}, "a", "b"); got != test.want {
+			t.Errorf("expected (%s): %s, got: %s", test.driver, test.want, got)
+		}
+	}
+}
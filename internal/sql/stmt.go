@@ -0,0 +1,98 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// StmtCache caches prepared statements keyed by their query string (the
+// query's shape). Queries that only differ in their arguments share a single
+// prepared statement, which saves the database from parsing and planning the
+// same query on each request. Statements are never evicted, so only queries of
+// a fixed set of shapes are to be run through a StmtCache (not, for instance,
+// ones with IN clauses of varying lengths).
+//
+// A StmtCache is safe for concurrent use.
+type StmtCache struct {
+	db *sql.DB
+
+	// maxSize is the maximum number of statements kept. Once the cache is
+	// full, queries are run without being prepared.
+	maxSize int
+
+	mu    sync.RWMutex
+	stmts map[string]*sql.Stmt
+}
+
+// NewStmtCache returns a StmtCache for db that holds at most maxSize prepared
+// statements.
+func NewStmtCache(db *sql.DB, maxSize int) *StmtCache {
+	return &StmtCache{
+		db:      db,
+		maxSize: maxSize,
+		stmts:   make(map[string]*sql.Stmt),
+	}
+}
+
+// Len returns the number of prepared statements in the cache.
+func (c *StmtCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.stmts)
+}
+
+// stmt returns the prepared statement for query, preparing it if need be. If
+// the cache is full, it returns nil and a nil error.
+func (c *StmtCache) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.mu.RLock()
+	stmt, ok := c.stmts[query]
+	c.mu.RUnlock()
+	if ok {
+		return stmt, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stmt, ok = c.stmts[query]; ok {
+		return stmt, nil
+	}
+	if len(c.stmts) >= c.maxSize {
+		return nil, nil
+	}
+	// The statement outlives the request that prepared it, so it's not
+	// prepared with a context that is canceled along with the request.
+	stmt, err := c.db.PrepareContext(context.WithoutCancel(ctx), query)
+	if err != nil {
+		return nil, err
+	}
+	c.stmts[query] = stmt
+	return stmt, nil
+}
+
+// QueryContext is like sql.DB.QueryContext except that the query is run
+// through a cached prepared statement.
+func (c *StmtCache) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	stmt, err := c.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return c.db.QueryContext(ctx, query, args...)
+	}
+	return stmt.QueryContext(ctx, args...)
+}
+
+// Close closes all the prepared statements in the cache.
+func (c *StmtCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for query, stmt := range c.stmts {
+		if cerr := stmt.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(c.stmts, query)
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// preparingDriver is a database/sql driver that returns no rows for every
+// query while keeping count of the statements prepared and closed. Queries
+// that are not prepared are run without preparing a statement.
+type preparingDriver struct {
+	mu       sync.Mutex
+	prepared int
+	closed   int
+}
+
+func (d *preparingDriver) Open(name string) (driver.Conn, error) { return &preparingConn{d: d}, nil }
+
+func (d *preparingDriver) counts() (prepared, closed int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.prepared, d.closed
+}
+
+type preparingConn struct{ d *preparingDriver }
+
+func (c *preparingConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.mu.Lock()
+	c.d.prepared++
+	c.d.mu.Unlock()
+	return &preparingStmt{d: c.d}, nil
+}
+func (c *preparingConn) Close() error              { return nil }
+func (c *preparingConn) Begin() (driver.Tx, error) { return nil, driver.ErrSkip }
+func (c *preparingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return emptyRows{}, nil
+}
+
+type preparingStmt struct{ d *preparingDriver }
+
+func (s *preparingStmt) Close() error {
+	s.d.mu.Lock()
+	s.d.closed++
+	s.d.mu.Unlock()
+	return nil
+}
+func (s *preparingStmt) NumInput() int { return -1 }
+func (s *preparingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+func (s *preparingStmt) Query(args []driver.Value) (driver.Rows, error) { return emptyRows{}, nil }
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string              { return nil }
+func (emptyRows) Close() error                   { return nil }
+func (emptyRows) Next(dest []driver.Value) error { return io.EOF }
+
+func newPreparingDB(t *testing.T) (*sql.DB, *preparingDriver) {
+	d := &preparingDriver{}
+	name := "preparing_" + t.Name()
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1) // Statements are prepared once per connection.
+	t.Cleanup(func() { db.Close() })
+	return db, d
+}
+
+func stmtCacheQuery(t *testing.T, c *StmtCache, query string, args ...any) {
+	t.Helper()
+	rows, err := c.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStmtCacheHits(t *testing.T) {
+	db, d := newPreparingDB(t)
+	c := NewStmtCache(db, 10)
+	for i := 0; i < 5; i++ {
+		stmtCacheQuery(t, c, "SELECT a FROM t WHERE id = ?", i)
+		stmtCacheQuery(t, c, "SELECT b FROM t WHERE id = ?", i)
+	}
+	if got := c.Len(); got != 2 {
+		t.Errorf("Len() = %d (expected 2)", got)
+	}
+	if prepared, _ := d.counts(); prepared != 2 {
+		t.Errorf("%d statements prepared for 2 queries (expected 2)", prepared)
+	}
+}
+
+func TestStmtCacheMaxSize(t *testing.T) {
+	db, d := newPreparingDB(t)
+	c := NewStmtCache(db, 3)
+	for i := 0; i < 10; i++ {
+		stmtCacheQuery(t, c, fmt.Sprintf("SELECT a FROM t WHERE id = %d", i))
+	}
+	if got := c.Len(); got != 3 {
+		t.Errorf("Len() = %d (expected 3)", got)
+	}
+	if prepared, _ := d.counts(); prepared != 3 {
+		t.Errorf("%d statements prepared (expected 3, the rest run unprepared)", prepared)
+	}
+
+	// The queries that made it in are still served from the cache.
+	stmtCacheQuery(t, c, "SELECT a FROM t WHERE id = 0")
+	if prepared, _ := d.counts(); prepared != 3 {
+		t.Errorf("%d statements prepared after a cache hit (expected 3)", prepared)
+	}
+}
+
+func TestStmtCacheClose(t *testing.T) {
+	db, d := newPreparingDB(t)
+	c := NewStmtCache(db, 10)
+	stmtCacheQuery(t, c, "SELECT a FROM t WHERE id = ?", 1)
+	stmtCacheQuery(t, c, "SELECT b FROM t WHERE id = ?", 1)
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Len(); got != 0 {
+		t.Errorf("Len() = %d after Close (expected 0)", got)
+	}
+	if prepared, closed := d.counts(); closed != prepared {
+		t.Errorf("%d of %d statements closed", closed, prepared)
+	}
+
+	// The cache is usable after Close, preparing statements anew.
+	stmtCacheQuery(t, c, "SELECT a FROM t WHERE id = ?", 1)
+	if got := c.Len(); got != 1 {
+		t.Errorf("Len() = %d (expected 1)", got)
+	}
+}
+
+func BenchmarkStmtCacheQuery(b *testing.B) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1) // Each connection has its own in-memory database.
+	if _, err := db.Exec(`CREATE TABLE comments (id INTEGER PRIMARY KEY, post_id INTEGER, upvotes INTEGER, body TEXT);
+		CREATE INDEX comments_post_upvotes ON comments (post_id, upvotes, id);`); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if _, err := db.Exec("INSERT INTO comments (post_id, upvotes, body) VALUES (?, ?, ?)", i%10, i%37, "comment"); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	query := "SELECT id, post_id, upvotes, body FROM comments WHERE post_id = ? AND (upvotes, id) <= (?, ?) ORDER BY upvotes DESC, id DESC LIMIT ?"
+	run := func(b *testing.B, q func(ctx context.Context, query string, args ...any) (*sql.Rows, error)) {
+		ctx := context.Background()
+		for i := 0; i < b.N; i++ {
+			rows, err := q(ctx, query, i%10, 30, 1<<30, 20)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for rows.Next() {
+			}
+			if err := rows.Close(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.Run("unprepared", func(b *testing.B) {
+		run(b, db.QueryContext)
+	})
+	b.Run("prepared", func(b *testing.B) {
+		c := NewStmtCache(db, 1)
+		defer c.Close()
+		run(b, c.QueryContext)
+	})
+}
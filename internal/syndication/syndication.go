@@ -0,0 +1,196 @@
+// Package syndication renders web feeds, in either the RSS 2.0 or the Atom
+// format.
+package syndication
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+// Feed is a web feed, independent of its format.
+type Feed struct {
+	Title       string
+	Link        string // The URL of the web page of the feed.
+	Self        string // The URL of the feed itself.
+	Description string
+	Updated     time.Time
+	Items       []*Item
+}
+
+// Item is an entry of a Feed.
+type Item struct {
+	ID        string // A permanent, unique identifier (a URL is fine).
+	Title     string
+	Link      string
+	Author    string
+	Content   string // Plain text.
+	Rights    string // The license of the content, in plain text (optional).
+	Published time.Time
+	Updated   time.Time // Optional.
+}
+
+// Format is the format of a rendered feed.
+type Format string
+
+const (
+	FormatRSS  = Format("rss")
+	FormatAtom = Format("atom")
+)
+
+// ContentType returns the value of the Content-Type header of feeds of format
+// f.
+func (f Format) ContentType() string {
+	return f.mediaType() + "; charset=utf-8"
+}
+
+// Write writes feed to w in format.
+func (feed *Feed) Write(w io.Writer, format Format) error {
+	var v any
+	if format == FormatAtom {
+		v = feed.atom()
+	} else {
+		v = feed.rss()
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+type rssFeed struct {
+	XMLName   xml.Name `xml:"rss"`
+	Version   string   `xml:"version,attr"`
+	XMLNSAtom string   `xml:"xmlns:atom,attr"`
+	XMLNSDC   string   `xml:"xmlns:dc,attr"`
+	Channel   struct {
+		Title         string    `xml:"title"`
+		Link          string    `xml:"link"`
+		AtomLink      atomLink  `xml:"atom:link"`
+		Description   string    `xml:"description"`
+		LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+		Items         []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title,omitempty"`
+	Link        string `xml:"link,omitempty"`
+	GUID        string `xml:"guid"`
+	Author      string `xml:"dc:creator,omitempty"`
+	Description string `xml:"description,omitempty"`
+	Rights      string `xml:"dc:rights,omitempty"`
+	PubDate     string `xml:"pubDate"`
+}
+
+func (feed *Feed) rss() *rssFeed {
+	r := &rssFeed{Version: "2.0", XMLNSAtom: "http://www.w3.org/2005/Atom", XMLNSDC: "http://purl.org/dc/elements/1.1/"}
+	c := &r.Channel
+	c.Title = feed.Title
+	c.Link = feed.Link
+	c.AtomLink = atomLink{Href: feed.Self, Rel: "self", Type: FormatRSS.mediaType()}
+	c.Description = feed.Description
+	if c.Description == "" {
+		c.Description = feed.Title // description is required
+	}
+	if !feed.Updated.IsZero() {
+		c.LastBuildDate = feed.Updated.UTC().Format(time.RFC1123Z)
+	}
+	c.Items = make([]rssItem, 0, len(feed.Items))
+	for _, item := range feed.Items {
+		c.Items = append(c.Items, rssItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			GUID:        item.ID,
+			Author:      item.Author,
+			Description: item.Content,
+			Rights:      item.Rights,
+			PubDate:     item.Published.UTC().Format(time.RFC1123Z),
+		})
+	}
+	return r
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string       `xml:"id"`
+	Title     string       `xml:"title"`
+	Link      atomLink     `xml:"link"`
+	Author    *atomAuthor  `xml:"author,omitempty"`
+	Content   *atomContent `xml:"content,omitempty"`
+	Rights    string       `xml:"rights,omitempty"`
+	Published string       `xml:"published"`
+	Updated   string       `xml:"updated"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func (feed *Feed) atom() *atomFeed {
+	a := &atomFeed{
+		ID:    feed.Self,
+		Title: feed.Title,
+		Links: []atomLink{
+			{Href: feed.Link, Rel: "alternate", Type: "text/html"},
+			{Href: feed.Self, Rel: "self", Type: FormatAtom.mediaType()},
+		},
+		Updated: feed.Updated.UTC().Format(time.RFC3339),
+	}
+	a.Entries = make([]atomEntry, 0, len(feed.Items))
+	for _, item := range feed.Items {
+		updated := item.Updated
+		if updated.IsZero() {
+			updated = item.Published
+		}
+		e := atomEntry{
+			ID:        item.ID,
+			Title:     item.Title,
+			Link:      atomLink{Href: item.Link, Rel: "alternate"},
+			Rights:    item.Rights,
+			Published: item.Published.UTC().Format(time.RFC3339),
+			Updated:   updated.UTC().Format(time.RFC3339),
+		}
+		if item.Author != "" {
+			e.Author = &atomAuthor{Name: item.Author}
+		}
+		if item.Content != "" {
+			e.Content = &atomContent{Type: "text", Body: item.Content}
+		}
+		a.Entries = append(a.Entries, e)
+	}
+	return a
+}
+
+// mediaType returns the media type of feeds of format f, without parameters.
+func (f Format) mediaType() string {
+	if f == FormatAtom {
+		return "application/atom+xml"
+	}
+	return "application/rss+xml"
+}
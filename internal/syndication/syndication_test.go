@@ -0,0 +1,58 @@
+package syndication
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testFeed() *Feed {
+	t := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	return &Feed{
+		Title:   "general",
+		Link:    "https://example.com/general",
+		Self:    "https://example.com/general/feed.rss",
+		Updated: t,
+		Items: []*Item{{
+			ID:        "https://example.com/general/post/abc",
+			Title:     "Fish & chips <3",
+			Link:      "https://example.com/general/post/abc",
+			Author:    "alice",
+			Content:   "Hello",
+			Rights:    "CC0 1.0",
+			Published: t,
+		}},
+	}
+}
+
+func TestWrite(t *testing.T) {
+	for _, format := range []Format{FormatRSS, FormatAtom} {
+		var b bytes.Buffer
+		if err := testFeed().Write(&b, format); err != nil {
+			t.Fatal(err)
+		}
+		out := b.String()
+		if err := xml.Unmarshal(b.Bytes(), new(struct{})); err != nil {
+			t.Errorf("%s feed is not valid XML: %v", format, err)
+		}
+		if !strings.Contains(out, "Fish &amp; chips &lt;3") {
+			t.Errorf("%s feed has an unescaped title:\n%s", format, out)
+		}
+		switch format {
+		case FormatRSS:
+			for _, s := range []string{`xmlns:atom="http://www.w3.org/2005/Atom"`, "<dc:creator>alice</dc:creator>", "<dc:rights>CC0 1.0</dc:rights>", "<pubDate>Wed, 01 May 2024 10:00:00 +0000</pubDate>"} {
+				if !strings.Contains(out, s) {
+					t.Errorf("RSS feed does not contain %q:\n%s", s, out)
+				}
+			}
+		case FormatAtom:
+			for _, s := range []string{`<feed xmlns="http://www.w3.org/2005/Atom">`, "<updated>2024-05-01T10:00:00Z</updated>", "<name>alice</name>", "<rights>CC0 1.0</rights>"} {
+				if !strings.Contains(out, s) {
+					t.Errorf("Atom feed does not contain %q:\n%s", s, out)
+				}
+			}
+		}
+	}
+}
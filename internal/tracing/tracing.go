@@ -0,0 +1,88 @@
+// Package tracing sets up OpenTelemetry tracing. Spans are exported, using
+// OTLP over HTTP, to a collector (such as the OpenTelemetry Collector or
+// Jaeger) if one is configured. Otherwise, tracing is a no-op.
+//
+// Spans are created for HTTP requests (by package server), for some of the
+// more expensive core functions, and for every SQL query (see OpenDB).
+package tracing
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/XSAM/otelsql"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Config configures the exporting of spans.
+type Config struct {
+	// The name of the service, as shown in tracing UIs.
+	ServiceName string
+
+	// The host and port of the OTLP/HTTP collector (for example,
+	// localhost:4318). If empty, spans are not exported.
+	Endpoint string
+
+	// If true, spans are exported over plain HTTP instead of HTTPS.
+	Insecure bool
+
+	// The fraction of traces (0 to 1) that are sampled. Traces started by
+	// incoming requests that carry a sampled trace context are always
+	// sampled.
+	SampleRatio float64
+}
+
+// Setup installs the global tracer provider and propagator as per c. Call the
+// returned function, on shutdown, to flush any buffered spans.
+func Setup(ctx context.Context, c Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if c.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(c.Endpoint)}
+	if c.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(c.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of the package (or module) named name.
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// OpenDB is like sql.Open except that a span is created for every query run
+// on the returned database.
+func OpenDB(driverName, dsn string) (*sql.DB, error) {
+	system := semconv.DBSystemMySQL
+	if driverName == msql.DriverSQLite {
+		system = semconv.DBSystemSqlite
+	}
+	return otelsql.Open(driverName, dsn,
+		otelsql.WithAttributes(system),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
+}
@@ -0,0 +1,101 @@
+// Package translate translates user content (like comments) to other
+// languages, with an external translation service.
+package translate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedLanguage is returned by a Translator asked to translate to a
+// language it doesn't support.
+var ErrUnsupportedLanguage = errors.New("translate: unsupported language")
+
+// A Translator translates text (Markdown, which is to be kept intact) to the
+// language lang, a BCP 47 language tag (like en or pt-BR).
+type Translator interface {
+	Translate(ctx context.Context, text, lang string) (string, error)
+}
+
+// languageTag matches the language tags accepted by NormalizeLanguage: a
+// language subtag optionally followed by a script and a region subtag.
+var languageTag = regexp.MustCompile(`^[a-z]{2,3}(-[a-z]{4})?(-([a-z]{2}|[0-9]{3}))?$`)
+
+// NormalizeLanguage returns the language tag lang in its canonical case (as
+// in zh-Hant-TW), or an error if it's not a valid tag.
+func NormalizeLanguage(lang string) (string, error) {
+	lang = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+	if !languageTag.MatchString(lang) {
+		return "", fmt.Errorf("invalid language tag %q", lang)
+	}
+	subtags := strings.Split(lang, "-")
+	for i := 1; i < len(subtags); i++ {
+		switch len(subtags[i]) {
+		case 4: // script
+			subtags[i] = strings.ToUpper(subtags[i][:1]) + subtags[i][1:]
+		case 2: // region
+			subtags[i] = strings.ToUpper(subtags[i])
+		}
+	}
+	return strings.Join(subtags, "-"), nil
+}
+
+// HTTPTranslator translates text by POSTing it to a URL, as a JSON object of
+// the form {"text": "...", "target": "fr"}. The response is expected to be a
+// JSON object of the form {"text": "..."}, or a 422 if the target language is
+// not supported. This works with translation services whose API accepts such
+// requests or with a small adapter in front of the service's API.
+type HTTPTranslator struct {
+	URL    string
+	Token  string       // If non-empty, sent as a bearer token.
+	Client *http.Client // If nil, a client with a 20 second timeout is used.
+}
+
+var defaultTranslateClient = &http.Client{Timeout: 20 * time.Second}
+
+func (t *HTTPTranslator) Translate(ctx context.Context, text, lang string) (string, error) {
+	body, err := json.Marshal(struct {
+		Text   string `json:"text"`
+		Target string `json:"target"`
+	}{text, lang})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", t.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+	client := t.Client
+	if client == nil {
+		client = defaultTranslateClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusUnprocessableEntity {
+		return "", ErrUnsupportedLanguage
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return "", fmt.Errorf("translation endpoint %s responded with status %d", t.URL, res.StatusCode)
+	}
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decoding response of translation endpoint %s: %w", t.URL, err)
+	}
+	return result.Text, nil
+}
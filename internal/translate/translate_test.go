@@ -0,0 +1,32 @@
+package translate
+
+import "testing"
+
+func TestNormalizeLanguage(t *testing.T) {
+	tests := []struct {
+		lang, expect string
+		valid        bool
+	}{
+		{"fr", "fr", true},
+		{" EN ", "en", true},
+		{"pt-br", "pt-BR", true},
+		{"pt_BR", "pt-BR", true},
+		{"zh-hant-tw", "zh-Hant-TW", true},
+		{"es-419", "es-419", true},
+		{"", "", false},
+		{"english", "", false},
+		{"en-", "", false},
+		{"en-US-x", "", false},
+		{"../etc", "", false},
+	}
+	for _, test := range tests {
+		got, err := NormalizeLanguage(test.lang)
+		if (err == nil) != test.valid {
+			t.Errorf("NormalizeLanguage(%q) returned error %v, expected valid: %v", test.lang, err, test.valid)
+			continue
+		}
+		if got != test.expect {
+			t.Errorf("NormalizeLanguage(%q) = %q, expected %q", test.lang, got, test.expect)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package videos
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// servableFiles matches the names of the files of a video that may be served
+// (the original file, notably, is not).
+var servableFiles = regexp.MustCompile(`^(video\.mp4|hls/index\.m3u8|hls/segment_[0-9]{3,}\.ts)$`)
+
+// Server serves the transcoded files of videos, at paths of the form
+// "/{videoID}/video.mp4". It implements the http.Handler interface.
+type Server struct{}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, file, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if !found || !servableFiles.MatchString(file) {
+		http.NotFound(w, r)
+		return
+	}
+	videoID, err := uid.FromString(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	path := filepath.Join(Folder(videoID), filepath.FromSlash(file))
+	if _, err := os.Stat(path); err != nil {
+		// Either the video does not exist or it's not yet transcoded.
+		http.NotFound(w, r)
+		return
+	}
+
+	switch filepath.Ext(file) {
+	case ".m3u8":
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	case ".ts":
+		w.Header().Set("Content-Type", "video/mp2t")
+	}
+	// The files of a video never change once they're created.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeFile(w, r, path)
+}
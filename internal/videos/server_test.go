@@ -0,0 +1,46 @@
+package videos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func TestServer(t *testing.T) {
+	SetVideosRootFolder(t.TempDir())
+	id := uid.New()
+	if err := os.MkdirAll(filepath.Join(Folder(id), HLSFolder), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{OriginalFile, MP4File, HLSPlaylist, "hls/segment_000.ts"} {
+		if err := os.WriteFile(filepath.Join(Folder(id), file), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		path   string
+		status int
+	}{
+		{"/" + id.String() + "/video.mp4", http.StatusOK},
+		{"/" + id.String() + "/hls/index.m3u8", http.StatusOK},
+		{"/" + id.String() + "/hls/segment_000.ts", http.StatusOK},
+		{"/" + id.String() + "/hls/segment_001.ts", http.StatusNotFound},
+		{"/" + id.String() + "/original", http.StatusNotFound},
+		{"/" + id.String() + "/../" + id.String() + "/original", http.StatusNotFound},
+		{"/" + uid.New().String() + "/video.mp4", http.StatusNotFound},
+		{"/notanid/video.mp4", http.StatusNotFound},
+	}
+	s := &Server{}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest("GET", "http://localhost"+c.path, nil))
+		if rec.Code != c.status {
+			t.Errorf("%s: got status %d, want %d", c.path, rec.Code, c.status)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Package videos stores uploaded videos on disk and converts them, using a
+// Transcoder, into an MP4 file (and optionally an HLS stream) along with a
+// poster frame.
+package videos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// The names of the files in the folder of a video.
+const (
+	OriginalFile = "original"       // As uploaded.
+	MP4File      = "video.mp4"      // H.264 and AAC, for progressive download.
+	PosterFile   = "poster.jpg"     // A frame of the video.
+	HLSFolder    = "hls"            // Holds the HLS playlist and its segments.
+	HLSPlaylist  = "hls/index.m3u8" // Relative to the folder of the video.
+)
+
+// In the working directory of the running process, by default.
+var rootFolder = ""
+
+// SetVideosRootFolder sets the folder in which videos are saved. The path p
+// must be an absolute path.
+func SetVideosRootFolder(p string) {
+	if !filepath.IsAbs(p) {
+		panic(fmt.Sprintf("path %v is not an absolute path", p))
+	}
+	rootFolder = p
+}
+
+// RootFolder returns the folder in which videos are saved (see
+// SetVideosRootFolder).
+func RootFolder() string {
+	return rootFolder
+}
+
+// Folder returns the folder in which the files of the video with id are
+// saved.
+func Folder(id uid.ID) string {
+	return filepath.Join(rootFolder, id.String())
+}
+
+// URL returns the URL path of the file, one of MP4File or HLSPlaylist, of the
+// video with id.
+func URL(id uid.ID, file string) string {
+	return "/videos/" + id.String() + "/" + file
+}
+
+// Remove deletes all files of the video with id.
+func Remove(id uid.ID) error {
+	return os.RemoveAll(Folder(id))
+}
+
+// FFprobePath is the path of the ffprobe executable.
+var FFprobePath = "ffprobe"
+
+// Info is the metadata of a video file.
+type Info struct {
+	Duration      time.Duration
+	Width, Height int
+}
+
+// ErrNoVideoStream is returned by Probe if the file has no video stream.
+var ErrNoVideoStream = errors.New("file has no video stream")
+
+// Probe returns the metadata of the video file at path.
+func Probe(ctx context.Context, path string) (*Info, error) {
+	cmd := exec.CommandContext(ctx, FFprobePath, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	var res struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return nil, fmt.Errorf("ffprobe: %w", err)
+	}
+
+	info := &Info{}
+	for _, s := range res.Streams {
+		if s.CodecType == "video" && s.Width > 0 {
+			info.Width, info.Height = s.Width, s.Height
+			break
+		}
+	}
+	if info.Width == 0 {
+		return nil, ErrNoVideoStream
+	}
+	if secs, err := strconv.ParseFloat(res.Format.Duration, 64); err == nil {
+		info.Duration = time.Duration(secs * float64(time.Second))
+	}
+	return info, nil
+}
+
+// A Transcoder converts the video file input into an MP4File and a PosterFile,
+// and optionally an HLSPlaylist, written into the folder dir.
+type Transcoder interface {
+	Transcode(ctx context.Context, input, dir string) error
+}
+
+// FFmpegTranscoder transcodes videos by running ffmpeg.
+type FFmpegTranscoder struct {
+	Path      string // Path of the ffmpeg executable. If empty, "ffmpeg".
+	MaxHeight int    // Videos taller than this are scaled down. If zero, 720.
+	HLS       bool   // Whether to create an HLS stream in addition to the MP4 file.
+}
+
+func (t *FFmpegTranscoder) Transcode(ctx context.Context, input, dir string) error {
+	ffmpeg := t.Path
+	if ffmpeg == "" {
+		ffmpeg = "ffmpeg"
+	}
+	maxHeight := t.MaxHeight
+	if maxHeight == 0 {
+		maxHeight = 720
+	}
+	// Dimensions of H.264 videos have to be even.
+	scale := fmt.Sprintf("scale=-2:'min(%d,trunc(ih/2)*2)'", maxHeight)
+
+	run := func(args ...string) error {
+		cmd := exec.CommandContext(ctx, ffmpeg, append([]string{"-hide_banner", "-loglevel", "error", "-y", "-i", input}, args...)...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("ffmpeg: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		return nil
+	}
+
+	if err := run("-vf", scale, "-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "128k", "-movflags", "+faststart", filepath.Join(dir, MP4File)); err != nil {
+		return err
+	}
+
+	// The poster is the frame at the first second (or the first frame of
+	// shorter videos).
+	poster := filepath.Join(dir, PosterFile)
+	err := run("-ss", "1", "-frames:v", "1", "-update", "1", poster)
+	if _, statErr := os.Stat(poster); err != nil || statErr != nil {
+		if err := run("-frames:v", "1", "-update", "1", poster); err != nil {
+			return err
+		}
+	}
+
+	if t.HLS {
+		if err := os.MkdirAll(filepath.Join(dir, HLSFolder), 0755); err != nil {
+			return err
+		}
+		// Segment the already transcoded MP4 file, which is much cheaper than
+		// transcoding the original again.
+		cmd := exec.CommandContext(ctx, ffmpeg, "-hide_banner", "-loglevel", "error", "-y", "-i", filepath.Join(dir, MP4File),
+			"-c", "copy", "-f", "hls", "-hls_time", "6", "-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(dir, HLSFolder, "segment_%03d.ts"), filepath.Join(dir, HLSPlaylist))
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("ffmpeg: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		}
+	}
+	return nil
+}
+
+// CommandTranscoder transcodes videos by running an external command (which
+// may, for instance, hand the video off to a transcoding service and wait for
+// it to finish). The command is run with the arguments Args followed by the
+// path of the input file and the path of the output folder, and it's expected
+// to write the files of FFmpegTranscoder into the output folder.
+type CommandTranscoder struct {
+	Command string
+	Args    []string
+}
+
+func (t *CommandTranscoder) Transcode(ctx context.Context, input, dir string) error {
+	args := append(append([]string{}, t.Args...), input, dir)
+	cmd := exec.CommandContext(ctx, t.Command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("transcode command %s: %w: %s", t.Command, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	for _, file := range []string{MP4File, PosterFile} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			return fmt.Errorf("transcode command %s did not create %s", t.Command, file)
+		}
+	}
+	return nil
+}
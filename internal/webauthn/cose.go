@@ -0,0 +1,115 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// The COSE algorithms supported, in the order of preference.
+const (
+	AlgES256 = -7   // ECDSA with P-256 and SHA-256.
+	AlgEdDSA = -8   // Ed25519.
+	AlgRS256 = -257 // RSASSA-PKCS1-v1_5 with SHA-256.
+)
+
+// Algorithms are the COSE algorithms of the public keys supported.
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+var errUnsupportedKey = errors.New("webauthn: unsupported public key")
+
+// The COSE key parameters used.
+const (
+	coseKty    = 1
+	coseAlg    = 3
+	coseCrv    = -1 // Of EC2 and OKP keys.
+	coseX      = -2 // Of EC2 and OKP keys.
+	coseY      = -3 // Of EC2 keys.
+	coseRSAN   = -1
+	coseRSAE   = -2
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+	coseP256   = 1
+	coseEd255  = 6
+)
+
+// publicKey is a credential public key.
+type publicKey struct {
+	alg int
+	key crypto.PublicKey
+}
+
+// parsePublicKey parses the COSE encoded public key data.
+func parsePublicKey(data []byte) (*publicKey, error) {
+	v, _, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, errUnsupportedKey
+	}
+	kty, _ := cborInt(cborMapInt(m, coseKty))
+	alg, _ := cborInt(cborMapInt(m, coseAlg))
+	bytesParam := func(key int64) []byte {
+		b, _ := cborMapInt(m, key).([]byte)
+		return b
+	}
+
+	switch {
+	case kty == coseKtyEC2 && alg == AlgES256:
+		if crv, _ := cborInt(cborMapInt(m, coseCrv)); crv != coseP256 {
+			return nil, errUnsupportedKey
+		}
+		x, y := bytesParam(coseX), bytesParam(coseY)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errUnsupportedKey
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errUnsupportedKey
+		}
+		return &publicKey{alg: AlgES256, key: key}, nil
+	case kty == coseKtyOKP && alg == AlgEdDSA:
+		if crv, _ := cborInt(cborMapInt(m, coseCrv)); crv != coseEd255 {
+			return nil, errUnsupportedKey
+		}
+		x := bytesParam(coseX)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errUnsupportedKey
+		}
+		return &publicKey{alg: AlgEdDSA, key: ed25519.PublicKey(x)}, nil
+	case kty == coseKtyRSA && alg == AlgRS256:
+		n, e := bytesParam(coseRSAN), bytesParam(coseRSAE)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errUnsupportedKey
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		return &publicKey{alg: AlgRS256, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}}, nil
+	}
+	return nil, errUnsupportedKey
+}
+
+// verify reports whether sig is a valid signature of data by k.
+func (k *publicKey) verify(data, sig []byte) bool {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, hash[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) == nil
+	}
+	return false
+}
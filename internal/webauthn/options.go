@@ -0,0 +1,105 @@
+package webauthn
+
+// The JSON encodings of the options of WebAuthn ceremonies, as passed to
+// navigator.credentials.create and navigator.credentials.get (with binary
+// values base64url encoded).
+
+// CreationOptions are PublicKeyCredentialCreationOptions.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RPEntity               `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameters `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"` // In milliseconds.
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are PublicKeyCredentialRequestOptions.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int                    `json:"timeout"` // In milliseconds.
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+type RPEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameters struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// timeout is the timeout of the ceremonies, in milliseconds.
+const timeout = 5 * 60 * 1000
+
+func (rp *RelyingParty) userVerification() string {
+	if rp.UserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+// CreationOptions returns the options to create a credential for the user
+// with the ID userID (an opaque handle, returned with assertions of the
+// credential) and name, which doesn't already have one of the credentials
+// exclude.
+func (rp *RelyingParty) CreationOptions(challenge, userID []byte, name string, exclude []CredentialDescriptor) *CreationOptions {
+	opts := &CreationOptions{
+		Challenge:          EncodeBase64(challenge),
+		RP:                 RPEntity{ID: rp.ID, Name: rp.Name},
+		User:               UserEntity{ID: EncodeBase64(userID), Name: name, DisplayName: name},
+		Timeout:            timeout,
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: rp.userVerification(),
+		},
+		Attestation: "none",
+	}
+	if opts.ExcludeCredentials == nil {
+		opts.ExcludeCredentials = []CredentialDescriptor{}
+	}
+	for _, alg := range Algorithms {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, CredentialParameters{Type: "public-key", Alg: alg})
+	}
+	return opts
+}
+
+// RequestOptions returns the options to get an assertion of one of the
+// credentials allow, or, if allow is empty, of any (discoverable) credential.
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow []CredentialDescriptor) *RequestOptions {
+	opts := &RequestOptions{
+		Challenge:        EncodeBase64(challenge),
+		Timeout:          timeout,
+		RPID:             rp.ID,
+		AllowCredentials: allow,
+		UserVerification: rp.userVerification(),
+	}
+	if opts.AllowCredentials == nil {
+		opts.AllowCredentials = []CredentialDescriptor{}
+	}
+	return opts
+}
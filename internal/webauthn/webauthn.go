@@ -0,0 +1,245 @@
+// Package webauthn implements the relying party side of WebAuthn
+// (https://www.w3.org/TR/webauthn-2/) needed for passkeys: registering
+// credentials (without verifying attestations) and verifying assertions.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+)
+
+// ChallengeSize is the size, in bytes, of the challenges.
+const ChallengeSize = 32
+
+var (
+	ErrInvalidClientData    = errors.New("webauthn: invalid client data")
+	ErrChallengeMismatch    = errors.New("webauthn: challenge mismatch")
+	ErrOriginMismatch       = errors.New("webauthn: origin not allowed")
+	ErrInvalidAuthData      = errors.New("webauthn: invalid authenticator data")
+	ErrRPIDMismatch         = errors.New("webauthn: relying party ID mismatch")
+	ErrUserNotPresent       = errors.New("webauthn: user not present")
+	ErrUserNotVerified      = errors.New("webauthn: user not verified")
+	ErrInvalidSignature     = errors.New("webauthn: invalid signature")
+	ErrSignCountNotIncrease = errors.New("webauthn: signature counter did not increase (the authenticator may be cloned)")
+)
+
+// The flags of authenticator data.
+const (
+	flagUserPresent    = 0x01
+	flagUserVerified   = 0x04
+	flagBackupEligible = 0x08
+	flagBackedUp       = 0x10
+	flagAttestedData   = 0x40
+)
+
+// NewChallenge returns a random challenge.
+func NewChallenge() ([]byte, error) {
+	b := make([]byte, ChallengeSize)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// EncodeBase64 returns b encoded as WebAuthn encodes binary data in JSON (as
+// unpadded base64url).
+func EncodeBase64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeBase64 decodes s, encoded as base64url (padded or not).
+func DecodeBase64(s string) ([]byte, error) {
+	for len(s) > 0 && s[len(s)-1] == '=' {
+		s = s[:len(s)-1]
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// A RelyingParty is a website to which users authenticate with WebAuthn.
+type RelyingParty struct {
+	ID      string   // The domain of the site (or a registrable suffix of it).
+	Name    string   // Shown to users by authenticators.
+	Origins []string // Where credentials may be used from (ex: https://example.com).
+
+	// UserVerification is true if authenticators must verify the user (with
+	// a PIN or biometrics, for instance), and not just that the user is
+	// present.
+	UserVerification bool
+}
+
+// A Credential is a public key credential registered with a relying party.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE encoded.
+	SignCount uint32
+	AAGUID    []byte // The model of the authenticator (all zeros if unknown).
+
+	BackupEligible bool // Whether the credential may be synced (as a passkey).
+	BackedUp       bool // Whether the credential is synced.
+}
+
+// clientData is the CollectedClientData of a registration or an assertion.
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// verifyClientData verifies that data is the client data of a ceremony of
+// typ, for challenge, from an allowed origin.
+func (rp *RelyingParty) verifyClientData(data []byte, typ string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(data, &cd); err != nil || cd.Type != typ {
+		return ErrInvalidClientData
+	}
+	got, err := DecodeBase64(cd.Challenge)
+	if err != nil || len(challenge) == 0 || subtle.ConstantTimeCompare(got, challenge) != 1 {
+		return ErrChallengeMismatch
+	}
+	if !slices.Contains(rp.Origins, cd.Origin) {
+		return ErrOriginMismatch
+	}
+	return nil
+}
+
+// authData is parsed authenticator data.
+type authData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+
+	// Only set if the attested credential data flag is set.
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthData(data []byte) (*authData, error) {
+	if len(data) < 37 {
+		return nil, ErrInvalidAuthData
+	}
+	ad := &authData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if ad.flags&flagAttestedData == 0 {
+		return ad, nil
+	}
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, ErrInvalidAuthData
+	}
+	ad.aaguid = rest[:16]
+	n := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if n == 0 || n > 1023 || len(rest) < n {
+		return nil, ErrInvalidAuthData
+	}
+	ad.credentialID, rest = rest[:n], rest[n:]
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, ErrInvalidAuthData
+	}
+	ad.publicKey = rest[:len(rest)-len(after)] // Extensions, if any, follow.
+	return ad, nil
+}
+
+// verifyAuthData verifies the relying party and the flags of ad.
+func (rp *RelyingParty) verifyAuthData(ad *authData) error {
+	hash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.rpIDHash, hash[:]) {
+		return ErrRPIDMismatch
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return ErrUserNotPresent
+	}
+	if rp.UserVerification && ad.flags&flagUserVerified == 0 {
+		return ErrUserNotVerified
+	}
+	return nil
+}
+
+// VerifyRegistration verifies the response (clientDataJSON and
+// attestationObject) of an authenticator to the creation of a credential with
+// challenge, and returns the credential. The attestation statement is not
+// verified (credentials are requested with the attestation "none").
+func (rp *RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	v, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := v.(map[any]any)
+	if !ok {
+		return nil, ErrInvalidAuthData
+	}
+	data, ok := obj["authData"].([]byte)
+	if !ok {
+		return nil, ErrInvalidAuthData
+	}
+	ad, err := parseAuthData(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthData(ad); err != nil {
+		return nil, err
+	}
+	if ad.credentialID == nil {
+		return nil, ErrInvalidAuthData
+	}
+	if _, err := parsePublicKey(ad.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:             append([]byte(nil), ad.credentialID...),
+		PublicKey:      append([]byte(nil), ad.publicKey...),
+		SignCount:      ad.signCount,
+		AAGUID:         append([]byte(nil), ad.aaguid...),
+		BackupEligible: ad.flags&flagBackupEligible != 0,
+		BackedUp:       ad.flags&flagBackedUp != 0,
+	}, nil
+}
+
+// VerifyAssertion verifies the response (clientDataJSON, authenticatorData,
+// and signature) of an authenticator to a request, with challenge, for an
+// assertion of cred. It returns the new signature counter of cred, and
+// whether it's backed up now.
+func (rp *RelyingParty) VerifyAssertion(cred *Credential, challenge, clientDataJSON, authenticatorData, signature []byte) (signCount uint32, backedUp bool, err error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, false, err
+	}
+	ad, err := parseAuthData(authenticatorData)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := rp.verifyAuthData(ad); err != nil {
+		return 0, false, err
+	}
+
+	key, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, false, err
+	}
+	hash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authenticatorData...), hash[:]...)
+	if !key.verify(signed, signature) {
+		return 0, false, ErrInvalidSignature
+	}
+
+	// Authenticators that don't count signatures (like those of synced
+	// passkeys) always report zero.
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return 0, false, ErrSignCountNotIncrease
+	}
+	return ad.signCount, ad.flags&flagBackedUp != 0, nil
+}
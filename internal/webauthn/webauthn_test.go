@@ -0,0 +1,186 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+)
+
+// The CBOR encoding of the values used in the tests (small unsigned and
+// negative integers, byte and text strings, and maps).
+func cborHead(major byte, n int) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 256:
+		return []byte{major<<5 | 24, byte(n)}
+	}
+	return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+}
+
+func cborInteger(n int) []byte {
+	if n < 0 {
+		return cborHead(1, -1-n)
+	}
+	return cborHead(0, n)
+}
+
+func cborBytes(b []byte) []byte { return append(cborHead(2, len(b)), b...) }
+
+func cborText(s string) []byte { return append(cborHead(3, len(s)), s...) }
+
+// cborMap encodes the map of the pairs of (already encoded) keys and values kv.
+func cborMap(kv ...[]byte) []byte {
+	b := cborHead(5, len(kv)/2)
+	for _, item := range kv {
+		b = append(b, item...)
+	}
+	return b
+}
+
+// authenticator is a fake authenticator with a single ES256 credential.
+type authenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	signCount uint32
+}
+
+func newAuthenticator(t *testing.T) *authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authenticator{key: key, id: []byte("credential-1")}
+}
+
+func (a *authenticator) coseKey() []byte {
+	x, y := make([]byte, 32), make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+	return cborMap(
+		cborInteger(coseKty), cborInteger(coseKtyEC2),
+		cborInteger(coseAlg), cborInteger(AlgES256),
+		cborInteger(coseCrv), cborInteger(coseP256),
+		cborInteger(coseX), cborBytes(x),
+		cborInteger(coseY), cborBytes(y),
+	)
+}
+
+func (a *authenticator) authData(rpID string, flags byte, attested bool) []byte {
+	hash := sha256.Sum256([]byte(rpID))
+	data := append(hash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], a.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+		data = append(data, a.id...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func clientDataJSON(typ string, challenge []byte, origin string) []byte {
+	data, _ := json.Marshal(clientData{Type: typ, Challenge: EncodeBase64(challenge), Origin: origin})
+	return data
+}
+
+func (a *authenticator) create(rpID, origin string, challenge []byte) (clientData, attestationObject []byte) {
+	obj := cborMap(
+		cborText("fmt"), cborText("none"),
+		cborText("attStmt"), cborMap(),
+		cborText("authData"), cborBytes(a.authData(rpID, flagUserPresent|flagUserVerified|flagAttestedData, true)),
+	)
+	return clientDataJSON("webauthn.create", challenge, origin), obj
+}
+
+func (a *authenticator) get(t *testing.T, rpID, origin string, challenge []byte) (clientData, authData, sig []byte) {
+	a.signCount++
+	clientData = clientDataJSON("webauthn.get", challenge, origin)
+	authData = a.authData(rpID, flagUserPresent|flagUserVerified, false)
+	hash := sha256.Sum256(clientData)
+	signed := sha256.Sum256(append(append([]byte(nil), authData...), hash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, signed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientData, authData, sig
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	rp := &RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://example.com"}, UserVerification: true}
+	a := newAuthenticator(t)
+
+	challenge, _ := NewChallenge()
+	clientData, attestation := a.create("example.com", "https://example.com", challenge)
+	cred, err := rp.VerifyRegistration(challenge, clientData, attestation)
+	if err != nil {
+		t.Fatalf("VerifyRegistration: %v", err)
+	}
+	if string(cred.ID) != string(a.id) {
+		t.Errorf("credential ID: got %q, want %q", cred.ID, a.id)
+	}
+
+	other, _ := NewChallenge()
+	if _, err := rp.VerifyRegistration(other, clientData, attestation); err != ErrChallengeMismatch {
+		t.Errorf("VerifyRegistration with another challenge: got %v", err)
+	}
+	clientData, attestation = a.create("example.com", "https://evil.example", challenge)
+	if _, err := rp.VerifyRegistration(challenge, clientData, attestation); err != ErrOriginMismatch {
+		t.Errorf("VerifyRegistration from another origin: got %v", err)
+	}
+	clientData, attestation = a.create("evil.example", "https://example.com", challenge)
+	if _, err := rp.VerifyRegistration(challenge, clientData, attestation); err != ErrRPIDMismatch {
+		t.Errorf("VerifyRegistration for another RP: got %v", err)
+	}
+
+	challenge, _ = NewChallenge()
+	clientData, authData, sig := a.get(t, "example.com", "https://example.com", challenge)
+	n, _, err := rp.VerifyAssertion(cred, challenge, clientData, authData, sig)
+	if err != nil {
+		t.Fatalf("VerifyAssertion: %v", err)
+	}
+	if n != a.signCount {
+		t.Errorf("sign count: got %d, want %d", n, a.signCount)
+	}
+	cred.SignCount = n
+
+	sig[len(sig)-1] ^= 1
+	if _, _, err := rp.VerifyAssertion(cred, challenge, clientData, authData, sig); err != ErrInvalidSignature {
+		t.Errorf("VerifyAssertion with a tampered signature: got %v", err)
+	}
+
+	// A replayed (or cloned) assertion.
+	a.signCount--
+	clientData, authData, sig = a.get(t, "example.com", "https://example.com", challenge)
+	if _, _, err := rp.VerifyAssertion(cred, challenge, clientData, authData, sig); err != ErrSignCountNotIncrease {
+		t.Errorf("VerifyAssertion with a stale counter: got %v", err)
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	for _, data := range [][]byte{
+		{},
+		{0x5f},             // Indefinite length byte string.
+		{0x42, 0x01},       // Truncated byte string.
+		{0xa1, 0x01},       // Map without a value.
+		{0xa1, 0x40, 0x01}, // Map with a byte string key.
+		{0xfb, 0, 0, 0, 0, 0, 0, 0, 0},
+	} {
+		if _, _, err := decodeCBOR(data); err == nil {
+			t.Errorf("decodeCBOR(%x): no error", data)
+		}
+	}
+
+	v, rest, err := decodeCBOR(append(cborMap(cborInteger(-7), cborText("a"), cborText("b"), cborBytes([]byte{1})), 0xff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := v.(map[any]any)
+	if cborMapInt(m, -7) != "a" || string(m["b"].([]byte)) != "\x01" || len(rest) != 1 {
+		t.Errorf("decodeCBOR: got %v, rest %x", m, rest)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// The first file descriptor passed by systemd socket activation (see
+// sd_listen_fds(3)).
+const listenFDsStart = 3
+
+// inheritedListeners returns the sockets passed to the process by systemd
+// socket activation, in the order they're listed in the socket unit, or nil if
+// there are none. With socket activation, the socket stays open (and
+// connections queue up on it) while the server restarts, and so no requests
+// are refused during a deploy.
+func inheritedListeners() ([]net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	// Not to be inherited by the processes the server starts (like ffmpeg).
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, n)
+	for i := range listeners {
+		fd := listenFDsStart + i
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		ln, err := net.FileListener(f)
+		f.Close() // FileListener dups the file descriptor (closed on exec).
+		if err != nil {
+			return nil, fmt.Errorf("socket %d passed by systemd: %w", fd, err)
+		}
+		listeners[i] = ln
+	}
+	return listeners, nil
+}
+
+// listen listens on the TCP address addr, with SO_REUSEPORT if reusePort is
+// true (so that another process, like the one replacing this one in a deploy,
+// may listen on addr too).
+func listen(addr string, reusePort bool) (net.Listener, error) {
+	var lc net.ListenConfig
+	if reusePort {
+		lc.Control = setReusePort
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
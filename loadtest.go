@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/discuitnet/discuit/config"
+	"github.com/discuitnet/discuit/internal/loadtest"
+)
+
+func runLoadtest(ctx context.Context, db *sql.DB, c *config.Config, fs *flag.FlagSet, args []string) error {
+	addr := c.Addr
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	baseURL := fs.String("url", "http://"+addr, "URL of the site to load test")
+	concurrency := fs.Int("concurrency", 10, "Number of clients sending requests at once")
+	duration := fs.Duration("duration", 30*time.Second, "For how long to send requests")
+	mixText := fs.String("mix", "feed=50,thread=35,vote=10,comment=5", "How often, relative to each other, clients read feeds and threads, vote, and comment")
+	usernames := fs.String("users", "", "Comma separated users for clients to log in as (without any, clients only read)")
+	password := fs.String("password", "password", "The password of the users (that of users made by the seed command is password)")
+	fs.Parse(args)
+
+	mix, err := loadtest.ParseMix(*mixText)
+	if err != nil {
+		return err
+	}
+	opts := loadtest.Options{
+		BaseURL:     *baseURL,
+		Concurrency: *concurrency,
+		Duration:    *duration,
+		Mix:         mix,
+		Password:    *password,
+	}
+	for _, name := range strings.Split(*usernames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.Usernames = append(opts.Usernames, name)
+		}
+	}
+	if *concurrency < 1 {
+		return errors.New("-concurrency must be at least 1")
+	}
+
+	// Interrupting the run ends it early, with a report of what was done.
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	log.Printf("Sending requests to %s from %d clients for %v\n", opts.BaseURL, opts.Concurrency, opts.Duration)
+	report, err := loadtest.Run(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Route\tRequests\tReq/s\tErrors\tp50\tp90\tp99\tMax\t")
+	for _, r := range report.Routes {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%d\t%v\t%v\t%v\t%v\t\n", r.Route, r.Requests, float64(r.Requests)/report.Duration.Seconds(),
+			r.Errors, roundLatency(r.P50), roundLatency(r.P90), roundLatency(r.P99), roundLatency(r.Max))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, r := range report.Routes {
+		if r.Errors == 0 {
+			continue
+		}
+		var statuses []string
+		for status, n := range r.Statuses {
+			if status >= 400 {
+				statuses = append(statuses, fmt.Sprintf("%d × %d", n, status))
+			}
+		}
+		sort.Strings(statuses)
+		if failed := r.Errors - countErrorStatuses(r.Statuses); failed > 0 {
+			statuses = append(statuses, fmt.Sprintf("%d failed", failed))
+		}
+		fmt.Printf("Errors of %s: %s\n", r.Route, strings.Join(statuses, ", "))
+	}
+	return nil
+}
+
+// countErrorStatuses returns the number of responses in statuses with a
+// status of 400 or more.
+func countErrorStatuses(statuses map[int]int) int {
+	n := 0
+	for status, count := range statuses {
+		if status >= 400 {
+			n += count
+		}
+	}
+	return n
+}
+
+func roundLatency(d time.Duration) time.Duration {
+	if d > time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(10 * time.Microsecond)
+}
@@ -9,39 +9,133 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/discuitnet/discuit/config"
 	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/activitypub"
+	"github.com/discuitnet/discuit/internal/cdn"
 	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/importer"
+	"github.com/discuitnet/discuit/internal/logging"
+	"github.com/discuitnet/discuit/internal/migrations"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/tracing"
 	"github.com/discuitnet/discuit/internal/uid"
 	"github.com/discuitnet/discuit/internal/utils"
+	"github.com/discuitnet/discuit/internal/videos"
 	"github.com/discuitnet/discuit/server"
 	"github.com/go-sql-driver/mysql"
 	"github.com/gomodule/redigo/redis"
-
-	gomigrate "github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/mysql"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 func main() {
 	// Load config file.
-	conf, err := config.Parse("./config.yaml")
+	conf, err := config.Parse(configPath())
+	if hasFlag("check-config") {
+		os.Exit(checkConfig(configPath(), conf, err))
+	}
 	if err != nil {
 		log.Fatal("Error parsing config file: ", err)
 	}
+	for _, warning := range conf.Warnings {
+		log.Printf("Config warning: %s\n", warning)
+	}
+
+	if err = setupLogging(conf); err != nil {
+		log.Fatal("Error setting up logging: ", err)
+	}
 
-	// Connect to MariaDB.
-	db := openDatabase(conf.DBUser, conf.DBPassword, conf.DBName)
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName: "discuit",
+		Endpoint:    conf.OTLPEndpoint,
+		Insecure:    conf.OTLPInsecure,
+		SampleRatio: conf.TraceSampleRatio,
+	})
+	if err != nil {
+		log.Fatal("Error setting up tracing: ", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// Connect to MariaDB (or SQLite).
+	db := openDatabase(conf)
 	defer db.Close()
 
+	// Set images folder.
+	p := "images"
+	if conf.ImagesFolderPath != "" {
+		p = conf.ImagesFolderPath
+	}
+	p, err = filepath.Abs(p)
+	if err != nil {
+		log.Fatalf("Error attempting to set the images folder location (%s): %v", p, err)
+	}
+	images.SetImagesRootFolder(p)
+
+	// Register the S3 image store.
+	if conf.S3Bucket != "" {
+		if err := images.RegisterS3Store(images.S3Options{
+			Endpoint:  conf.S3Endpoint,
+			Region:    conf.S3Region,
+			Bucket:    conf.S3Bucket,
+			AccessKey: conf.S3AccessKey,
+			SecretKey: conf.S3SecretKey,
+			PathStyle: conf.S3PathStyle,
+			Prefix:    conf.S3Prefix,
+		}); err != nil {
+			log.Fatal("Error registering the S3 image store: ", err)
+		}
+	}
+	images.DefaultStore = conf.ImagesStore
+
+	// Register the image scanners.
+	if conf.ImageScanHashesFile != "" {
+		var v images.Verdict
+		if err := v.UnmarshalText([]byte(conf.ImageScanHashesVerdict)); err != nil {
+			log.Fatal(err)
+		}
+		s, err := images.NewHashListScanner(conf.ImageScanHashesFile, v)
+		if err != nil {
+			log.Fatal("Error loading image hashes: ", err)
+		}
+		images.RegisterScanner(s)
+	}
+	if conf.ImageScanURL != "" {
+		images.RegisterScanner(&images.HTTPScanner{URL: conf.ImageScanURL})
+	}
+
+	if conf.CDNPurgeURL != "" {
+		cdn.RegisterPurger(&cdn.HTTPPurger{URL: conf.CDNPurgeURL, Token: conf.CDNPurgeToken})
+	}
+
+	// Set videos folder.
+	vp := "videos"
+	if conf.VideosFolderPath != "" {
+		vp = conf.VideosFolderPath
+	}
+	if vp, err = filepath.Abs(vp); err != nil {
+		log.Fatalf("Error attempting to set the videos folder location (%s): %v", vp, err)
+	}
+	videos.SetVideosRootFolder(vp)
+	videos.FFprobePath = conf.FFprobePath
+
+	// Run a command (see commands.go).
+	if len(os.Args) > 1 && runCommand(db, conf, os.Args[1], os.Args[2:]) {
+		return
+	}
+
 	// Parse flags.
 	runServer, err := parseFlags(db, conf)
 	if err != nil {
@@ -53,46 +147,59 @@ func main() {
 		return
 	}
 
-	// Set images folder.
-	p := "images"
-	if conf.ImagesFolderPath != "" {
-		p = conf.ImagesFolderPath
+	// The site of conf, and the other sites served by the process, if any.
+	sites, err := loadSites(db, conf)
+	if err != nil {
+		log.Fatal("Error loading sites: ", err)
 	}
-	if p, err = filepath.Abs(p); err != nil {
-		log.Fatalf("Error attempting to set the images folder location (%s): %v", p, err)
+	for _, st := range sites[1:] {
+		defer st.db.Close()
 	}
-	images.SetImagesRootFolder(p)
 
 	// Create default badges.
-	if err = core.NewBadgeType(db, "supporter"); err != nil {
-		log.Fatalf("Error creating 'supporter' user badge: %v\n", err)
+	for _, st := range sites {
+		if err = core.NewBadgeType(st.db, "supporter"); err != nil {
+			log.Fatalf("Error creating 'supporter' user badge: %v\n", err)
+		}
+		if err = core.NewBadgeType(st.db, core.OnboardingBadge); err != nil {
+			log.Fatalf("Error creating '%s' user badge: %v\n", core.OnboardingBadge, err)
+		}
 	}
 
+	// ctx is canceled when the process receives a signal to terminate.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var workers sync.WaitGroup
+	workerDone := make(chan struct{})
+	for _, st := range sites {
+		startWorkers(ctx, &workers, st.db, st.conf)
+	}
 	go func() {
-		// This go-routine runs a set of periodic functions every hour.
-		time.Sleep(time.Second * 5) // Just so the first console output isn't from this goroutine.
-		for {
-			if err := core.PurgePostsFromTempTables(context.TODO(), db); err != nil {
-				log.Printf("Temp posts purging failed: %v\n", err)
-			}
-			if n, err := core.RemoveTempImages(context.TODO(), db); err != nil {
-				log.Printf("Failed to remove temp images: %v\n", err)
-			} else {
-				log.Printf("Removed %d temp images\n", n)
-			}
-			time.Sleep(time.Hour)
-		}
+		workers.Wait()
+		close(workerDone)
 	}()
 
-	site, err := server.New(db, conf)
-	if err != nil {
-		log.Fatal("Error creating server: ", err)
+	hosts := make(map[string]*server.Server) // The sites other than that of conf.
+	for _, st := range sites {
+		if st.server, err = server.New(st.db, st.conf); err != nil {
+			log.Fatal("Error creating server: ", err)
+		}
+		defer st.server.Close()
+		for _, host := range st.hosts {
+			hosts[host] = st.server
+		}
 	}
-	defer site.Close()
+	defaultSite := sites[0].server
 
 	server := &http.Server{
 		Addr: conf.Addr,
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/healthz" {
+				healthz(w, r)
+				return
+			}
+
 			// If the domain name contains www. redirect to one without.
 			host := r.Host
 			if strings.HasPrefix(host, "www.") {
@@ -101,18 +208,51 @@ func main() {
 				http.Redirect(w, r, url.String(), http.StatusMovedPermanently)
 				return
 			}
-			site.ServeHTTP(w, r)
+			if s, ok := hosts[requestHostname(host)]; ok {
+				s.ServeHTTP(w, r)
+				return
+			}
+			defaultSite.ServeHTTP(w, r)
 		}),
 	}
+	for _, st := range sites {
+		server.RegisterOnShutdown(st.server.CloseStreams)
+	}
+	servers := []*http.Server{server}
 
-	log.Println("Starting server on " + conf.Addr)
+	// The sockets passed by systemd, if any (the first is that of the server,
+	// and the second, if any, that of the redirect server). Otherwise, the
+	// servers listen on sockets of their own.
+	inherited, err := inheritedListeners()
+	if err != nil {
+		log.Fatal("Error getting the sockets passed by systemd: ", err)
+	}
+	listener := func(i int, addr string) net.Listener {
+		if i < len(inherited) {
+			return inherited[i]
+		}
+		ln, err := listen(addr, conf.ReusePort)
+		if err != nil {
+			log.Fatalf("Error listening on %s: %v", addr, err)
+		}
+		return ln
+	}
+	if names := core.Plugins(); len(names) > 0 {
+		log.Println("Plugins: " + strings.Join(names, ", "))
+	}
+	ln := listener(0, conf.Addr)
+	log.Println("Starting server on " + ln.Addr().String())
 
 	if conf.CertFile != "" {
 		// Running HTTPS server.
 		//
 		// A server to redirect traffic from HTTP to HTTPS. Started only if the
-		// main server is on port 443.
-		if conf.Addr[strings.Index(conf.Addr, ":"):] == ":443" {
+		// main server is on port 443 (or if systemd passed a socket for it).
+		startRedirect := conf.Addr[strings.Index(conf.Addr, ":"):] == ":443"
+		if len(inherited) > 0 {
+			startRedirect = len(inherited) > 1
+		}
+		if startRedirect {
 			redirectServer := &http.Server{
 				Addr: ":80",
 				Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -122,64 +262,471 @@ func main() {
 					http.Redirect(w, r, url.String(), http.StatusMovedPermanently)
 				}),
 			}
+			servers = append(servers, redirectServer)
+			redirectLn := listener(1, redirectServer.Addr)
 			go func() {
-				if err = redirectServer.ListenAndServe(); err != nil {
+				if err := redirectServer.Serve(redirectLn); err != nil && err != http.ErrServerClosed {
 					log.Fatal("Error starting redirect server: ", err)
 				}
 			}()
 		}
-		if err := server.ListenAndServeTLS(conf.CertFile, conf.KeyFile); err != nil {
-			log.Fatal("Error starting server (TLS): ", err)
-		}
+		go func() {
+			if err := server.ServeTLS(ln, conf.CertFile, conf.KeyFile); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Error starting server (TLS): ", err)
+			}
+		}()
 	} else {
 		// Running HTTP server.
-		if err := server.ListenAndServe(); err != nil {
-			log.Fatal("Error starting server: ", err)
+		go func() {
+			if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Error starting server: ", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	stop() // A second signal kills the process right away.
+	if conf.DrainDelay > 0 {
+		// Keep serving while clients (and load balancers, which see /healthz
+		// fail) move to the new process, if there's one.
+		draining.Store(true)
+		for _, server := range servers {
+			server.SetKeepAlivesEnabled(false)
 		}
+		log.Printf("Draining for %ds\n", conf.DrainDelay)
+		time.Sleep(time.Duration(conf.DrainDelay) * time.Second)
 	}
+	shutdown(servers, workerDone, time.Duration(conf.ShutdownTimeout)*time.Second)
 }
 
-// migrationLogger implements the migrate.Logger interface.
-type migrationsLogger struct {
-	verbose bool
+// draining is set when the server starts to shut down (see drainDelay).
+var draining atomic.Bool
+
+// healthz responds to health checks (of load balancers and deploy scripts),
+// failing once the server is draining.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("draining\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
 }
 
-func (ml *migrationsLogger) Printf(format string, v ...any) {
-	log.Printf(format, v...)
+// startWorkers starts the background workers of the site of db and conf (which
+// call workers.Done when they're done, after ctx is canceled).
+func startWorkers(ctx context.Context, workers *sync.WaitGroup, db *sql.DB, conf *config.Config) {
+	workers.Add(1)
+	go func() {
+		// This go-routine runs a set of periodic functions every hour.
+		defer workers.Done()
+		select {
+		case <-time.After(time.Second * 5): // Just so the first console output isn't from this goroutine.
+		case <-ctx.Done():
+			return
+		}
+		for {
+			if err := core.PurgePostsFromTempTables(ctx, db); err != nil {
+				log.Printf("Temp posts purging failed: %v\n", err)
+			}
+			if n, err := core.RemoveTempImages(ctx, db); err != nil {
+				log.Printf("Failed to remove temp images: %v\n", err)
+			} else {
+				log.Printf("Removed %d temp images\n", n)
+			}
+			if conf.OrphanedImagesTTL > 0 {
+				if n, err := core.RemoveOrphanedImages(ctx, db, time.Duration(conf.OrphanedImagesTTL)*time.Hour); err != nil {
+					log.Printf("Failed to remove orphaned images: %v\n", err)
+				} else if n > 0 {
+					log.Printf("Removed %d orphaned images\n", n)
+				}
+			}
+			if conf.DeletedContentMediaTTL > 0 {
+				if n, err := core.RemoveDeletedContentMedia(ctx, db, time.Duration(conf.DeletedContentMediaTTL)*24*time.Hour); err != nil {
+					log.Printf("Failed to remove media of deleted content: %v\n", err)
+				} else if n > 0 {
+					log.Printf("Removed media of %d deleted posts and users\n", n)
+				}
+			}
+			if conf.DeletedContentRetention > 0 || len(conf.DeletedContentRetentionByJurisdiction) > 0 {
+				policy := core.RetentionPolicy{Days: conf.DeletedContentRetention, ByJurisdiction: conf.DeletedContentRetentionByJurisdiction}
+				if run, err := core.PurgeDeletedContent(ctx, db, policy); err != nil {
+					log.Printf("Failed to purge deleted content: %v\n", err)
+				} else if run.ArchivedPurged > 0 || run.PostsPurged > 0 {
+					log.Printf("Purged %d archived posts and comments, and the bodies of %d deleted posts\n", run.ArchivedPurged, run.PostsPurged)
+				}
+			}
+			if _, err := core.PruneMediaUploads(ctx, db); err != nil {
+				log.Printf("Failed to prune media uploads: %v\n", err)
+			}
+			if n, err := core.RemoveTempVideos(ctx, db); err != nil {
+				log.Printf("Failed to remove temp videos: %v\n", err)
+			} else if n > 0 {
+				log.Printf("Removed %d temp videos\n", n)
+			}
+			if err := core.PurgeExpiredOAuthCodes(ctx, db); err != nil {
+				log.Printf("Failed to purge expired OAuth codes: %v\n", err)
+			}
+			if err := core.PruneCommentFingerprints(ctx, db); err != nil {
+				log.Printf("Failed to prune comment fingerprints: %v\n", err)
+			}
+			if err := core.PruneImageFingerprints(ctx, db); err != nil {
+				log.Printf("Failed to prune image fingerprints: %v\n", err)
+			}
+			if err := core.PruneAuthEvents(ctx, db); err != nil {
+				log.Printf("Failed to prune auth events: %v\n", err)
+			}
+			if err := core.PruneSignups(ctx, db); err != nil {
+				log.Printf("Failed to prune signups: %v\n", err)
+			}
+			if err := core.ComputeLeaderboards(ctx, db); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to compute leaderboards: %v\n", err)
+			}
+			if err := core.SnapshotModQueues(ctx, db); err != nil {
+				log.Printf("Failed to snapshot mod queues: %v\n", err)
+			}
+			if err := core.PruneModStats(ctx, db); err != nil {
+				log.Printf("Failed to prune mod stats: %v\n", err)
+			}
+			if err := core.PrunePostVisits(ctx, db); err != nil {
+				log.Printf("Failed to prune post visits: %v\n", err)
+			}
+			if n, err := core.ExpireSupporterEntitlements(ctx, db); err != nil {
+				log.Printf("Failed to expire supporter perks: %v\n", err)
+			} else if n > 0 {
+				log.Printf("Expired the supporter perks of %d payments\n", n)
+			}
+			if conf.ImageProxy {
+				if err := images.PruneProxyCache(time.Duration(conf.ImageProxyCacheTTL) * time.Hour); err != nil {
+					log.Printf("Failed to prune the image proxy cache: %v\n", err)
+				}
+			}
+			if err := core.PruneSearchQueries(ctx, db); err != nil {
+				log.Printf("Failed to prune search queries: %v\n", err)
+			}
+			if _, err := core.GenerateSitemaps(ctx, db); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to generate sitemaps: %v\n", err)
+			}
+			if err := server.PruneShareCards(); err != nil {
+				log.Printf("Failed to prune share cards: %v\n", err)
+			}
+			select {
+			case <-time.After(time.Hour):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	workers.Add(1)
+	go func() {
+		// This go-routine computes the signup and community cohorts, and the
+		// similar communities, and recounts the stats, nightly (just after
+		// midnight UTC), and then exports the previous day to the warehouse.
+		defer workers.Done()
+		for {
+			next := time.Now().UTC().Truncate(24 * time.Hour).Add(24*time.Hour + 10*time.Minute)
+			select {
+			case <-time.After(time.Until(next)):
+			case <-ctx.Done():
+				return
+			}
+			now := time.Now()
+			if err := core.ComputeCohorts(ctx, db, now.AddDate(0, 0, -core.CohortRecomputeDays), now); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to compute cohorts: %v\n", err)
+			}
+			if err := core.ComputeSimilarCommunities(ctx, db); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to compute similar communities: %v\n", err)
+			}
+			if audits, err := core.AuditStats(ctx, db); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to recount stats: %v\n", err)
+			} else {
+				for _, a := range audits {
+					log.Printf("Fixed %d %s (off by %d in all)\n", a.RowsOff, a.Counter, a.Drift)
+				}
+			}
+			if save := warehouseSaver(conf); save != nil {
+				if _, err := core.ExportWarehouseDay(ctx, db, now.AddDate(0, 0, -1), save); err != nil && ctx.Err() == nil {
+					log.Printf("Failed to export to the warehouse: %v\n", err)
+				}
+			}
+		}
+	}()
+
+	workers.Add(1)
+	go func() {
+		// This go-routine unlocks the posts that were locked until a time
+		// that's passed.
+		defer workers.Done()
+		for {
+			if n, err := core.UnlockExpiredPosts(ctx, db); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to unlock expired post locks: %v\n", err)
+			} else if n > 0 {
+				log.Printf("Unlocked %d posts whose locks expired\n", n)
+			}
+			select {
+			case <-time.After(time.Minute):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	workers.Add(1)
+	go func() {
+		// This go-routine creates the queued notifications, and sends the push
+		// notifications deferred during quiet hours once they end. It also
+		// executes the mod actions that can no longer be undone (before the
+		// notifications are created, since they notify authors).
+		defer workers.Done()
+		for {
+			if _, err := core.ExecutePendingModActions(ctx, db); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to execute pending mod actions: %v\n", err)
+			}
+			if _, err := core.DeliverNotifications(ctx, db); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to create notifications: %v\n", err)
+			}
+			if _, err := core.SendDeferredPushNotifications(ctx, db); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to send deferred push notifications: %v\n", err)
+			}
+			select {
+			case <-time.After(2 * time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if conf.Federation {
+		workers.Add(1)
+		go func() {
+			// This go-routine delivers the queued ActivityPub activities.
+			defer workers.Done()
+			site := activitypub.Site{BaseURL: "https://" + conf.FederationDomain}
+			for {
+				if _, err := core.DeliverActivities(ctx, db, site); err != nil && ctx.Err() == nil {
+					log.Printf("Failed to deliver ActivityPub activities: %v\n", err)
+				}
+				select {
+				case <-time.After(30 * time.Second):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	if conf.LDAPURL != "" {
+		workers.Add(1)
+		go func() {
+			// This go-routine syncs the users from the LDAP directory.
+			defer workers.Done()
+			directory := &core.LDAPDirectory{
+				URL:               conf.LDAPURL,
+				BindDN:            conf.LDAPBindDN,
+				BindPassword:      conf.LDAPBindPassword,
+				BaseDN:            conf.LDAPBaseDN,
+				UserFilter:        conf.LDAPUserFilter,
+				UsernameAttribute: conf.LDAPUsernameAttribute,
+				EmailAttribute:    conf.LDAPEmailAttribute,
+				GroupAttribute:    conf.LDAPGroupAttribute,
+			}
+			dirSync := &core.DirectorySync{
+				Source:           core.DirectorySourceLDAP,
+				GroupCommunities: conf.DirectoryGroupCommunities,
+				Deactivate:       conf.DirectoryDeactivateRemoved,
+			}
+			for {
+				if users, err := directory.FetchUsers(ctx); err != nil {
+					if ctx.Err() == nil {
+						log.Printf("Failed to fetch the users of the LDAP directory: %v\n", err)
+					}
+				} else {
+					run, deactivated, err := dirSync.Sync(ctx, db, users)
+					if err != nil && ctx.Err() == nil {
+						log.Printf("Failed to sync the LDAP directory: %v\n", err)
+					}
+					for _, u := range deactivated {
+						if err := server.LogoutAllSessions(conf, u); err != nil {
+							log.Printf("Failed to log out deactivated user %s: %v\n", u.Username, err)
+						}
+					}
+					if run.UsersCreated+run.UsersDeactivated+run.UsersReactivated > 0 {
+						log.Printf("Synced the LDAP directory: %d users created, %d deactivated, and %d reactivated\n",
+							run.UsersCreated, run.UsersDeactivated, run.UsersReactivated)
+					}
+				}
+				select {
+				case <-time.After(time.Duration(conf.DirectorySyncInterval) * time.Minute):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	if conf.EnableVideoPosts {
+		var transcoder videos.Transcoder = &videos.FFmpegTranscoder{
+			Path:      conf.FFmpegPath,
+			MaxHeight: conf.MaxVideoHeight,
+			HLS:       conf.VideoHLS,
+		}
+		if len(conf.VideoTranscodeCommand) > 0 {
+			transcoder = &videos.CommandTranscoder{
+				Command: conf.VideoTranscodeCommand[0],
+				Args:    conf.VideoTranscodeCommand[1:],
+			}
+		}
+		workers.Add(1)
+		go func() {
+			// This go-routine transcodes uploaded videos.
+			defer workers.Done()
+			for {
+				if _, err := core.ProcessVideos(ctx, db, transcoder); err != nil && ctx.Err() == nil {
+					log.Printf("Failed to process videos: %v\n", err)
+				}
+				select {
+				case <-time.After(5 * time.Second):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
 }
 
-func (ml *migrationsLogger) Verbose() bool {
-	return ml.verbose
+// shutdown gracefully shuts down the servers: no new connections are
+// accepted, and in-flight requests, the background workers (which signal
+// that they are done by closing workerDone), and the tasks run in the
+// background by package core (such as the creation of notifications) are
+// waited for, for at most timeout.
+func shutdown(servers []*http.Server, workerDone <-chan struct{}, timeout time.Duration) {
+	log.Printf("Shutting down (waiting at most %v for in-flight work to finish)\n", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down server %s: %v\n", server.Addr, err)
+		}
+	}
+
+	select {
+	case <-workerDone:
+	case <-ctx.Done():
+		log.Println("Timed out waiting for the background workers to stop")
+	}
+
+	if err := core.WaitForBackgroundTasks(ctx); err != nil {
+		log.Printf("Timed out waiting for background tasks: %v\n", err)
+	}
+	log.Println("Shutdown complete")
 }
 
-// If steps is 0, all migrations are run. Otherwise, steps migrations are run up
-// or down depending on steps > 0 or not.
-func migrate(c *config.Config, log bool, steps int) error {
-	m, err := gomigrate.New("file://migrations/", "mysql://"+mysqlDSN(c.DBUser, c.DBPassword, c.DBName))
+// setupLogging configures the application's structured loggers as per c.
+func setupLogging(c *config.Config) error {
+	level, err := logging.ParseLevel(c.LogLevel)
 	if err != nil {
 		return err
 	}
-	if log {
-		m.Log = &migrationsLogger{verbose: false}
+	if err = logging.Setup(os.Stderr, c.LogFormat, level); err != nil {
+		return err
+	}
+	for module, text := range c.LogLevels {
+		level, err := logging.ParseLevel(text)
+		if err != nil {
+			return fmt.Errorf("log level of module %s: %w", module, err)
+		}
+		logging.SetLevel(module, level)
 	}
+	return nil
+}
 
-	if steps == 0 {
-		err = m.Up()
-	} else {
-		err = m.Steps(steps)
+// migrate runs the migrations of the database of c (see migrations.Options
+// for what opts.Steps and opts.To do). The Dir and DatabaseURL fields of opts
+// are set by this function.
+//
+// SQLite databases are migrated using the migrations in migrations/sqlite/,
+// which are numbered so that they match their MySQL counterparts.
+func migrate(db *sql.DB, c *config.Config, opts migrations.Options) error {
+	opts.Dir = migrations.Dir(c.DBDriver)
+	opts.DatabaseURL = "mysql://" + mysqlDSN(c.DBUser, c.DBPassword, c.DBName)
+	if c.DBDriver == msql.DriverSQLite {
+		opts.DatabaseURL = "sqlite3://" + sqliteDSN(c.DBName)
 	}
-	if err != nil && err != gomigrate.ErrNoChange {
+
+	steps, err := migrations.Run(context.Background(), db, opts)
+	if err != nil {
 		return err
 	}
+	if opts.DryRun {
+		log.Printf("Dry run: %d migration(s) would be run\n", len(steps))
+	} else if len(steps) == 0 {
+		log.Println("No migrations to run")
+	}
+	return nil
+}
+
+// configPath returns the path of the config file.
+func configPath() string {
+	if v := os.Getenv(config.EnvPrefix + "CONFIG"); v != "" {
+		return v
+	}
+	return "./config.yaml"
+}
+
+// hasFlag reports whether the boolean flag name was passed on the command line
+// (for flags that must be handled before the config is loaded, and so before
+// the flags are parsed).
+func hasFlag(name string) bool {
+	for _, arg := range os.Args[1:] {
+		switch strings.TrimLeft(arg, "-") {
+		case name, name + "=true":
+			return strings.HasPrefix(arg, "-")
+		}
+	}
+	return false
+}
 
-	_, err = m.Close()
-	return err
+// checkConfig reports whether the config at path, loaded as conf (or with
+// err), is valid, and returns the exit code of -check-config.
+func checkConfig(path string, conf *config.Config, err error) int {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	fmt.Printf("%s: config is valid\n", path)
+	for _, name := range conf.EnvOverrides {
+		fmt.Printf("Overridden by %s\n", name)
+	}
+	for _, warning := range conf.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	status := 0
+	for host, sitePath := range conf.Sites {
+		c, err := config.ParseSite(sitePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s (site %s): %v\n", sitePath, host, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s (site %s): config is valid\n", sitePath, host)
+		for _, warning := range c.Warnings {
+			fmt.Printf("Warning: %s\n", warning)
+		}
+	}
+	return status
 }
 
 // parseFlags returns whether to run the server and any error encountered.
 func parseFlags(db *sql.DB, c *config.Config) (bool, error) {
+	flag.Bool("check-config", false, "Check the config (the file and the environment variables) and exit") // Handled before the config is loaded.
 	runMigrations := flag.Bool("migrate", false, "Run DB migrations")
 	steps := flag.Int("steps", 0, "Migrations steps to run (0 runs all migrations)")
+	migrateDown := flag.Bool("down", false, "Roll back the last migration (or -steps migrations)") // Uses -migrate flag
+	migrateTo := flag.Int("to", -1, "Migrate up or down to this version")                          // Uses -migrate flag
+	dryRun := flag.Bool("dry-run", false, "Show the migrations that would be run without running them")
+	ignoreChecksums := flag.Bool("ignore-checksums", false, "Run migrations even if applied migrations were modified")
 	runServer := flag.Bool("serve", false, "Start web server")
 	makeAdmin := flag.String("make-admin", "", "Make user an admin")
 	removeAdmin := flag.String("remove-admin", "", "Remove user as admin")
@@ -193,6 +740,8 @@ func parseFlags(db *sql.DB, c *config.Config) (bool, error) {
 	password := flag.String("password", "", "Password")                                // Helper flag for -force-pass-change
 
 	showImagePath := flag.String("image-path", "", "Show where an image is stored on disk.")
+	moveImages := flag.String("move-images", "", "Move all images to a store (disk or s3)")
+	moveImagesFrom := flag.String("move-images-from", "disk", "Store to move images from") // Helper flag for -move-images
 
 	runPopulatePost := flag.String("populate-post", "", "Populate post with random comments") // Populate post with random comments
 
@@ -201,6 +750,22 @@ func parseFlags(db *sql.DB, c *config.Config) (bool, error) {
 
 	newBadge := flag.String("new-badge", "", "New user badge")
 
+	importDump := flag.String("import", "", "Import a community dump (comma separated files) into a community") // Uses -community flag
+	importFormat := flag.String("import-format", "reddit", "Format of the dump to import (reddit or lemmy)")    // Helper flag for -import
+	importSource := flag.String("import-source", "", "Name of the dump to import (default: format/community)")  // Helper flag for -import
+
+	exportInstance := flag.String("export-instance", "", "Export the whole site to a file")
+	exportName := flag.String("export-name", "", "Name that identifies this site in the export (default: siteName)") // Helper flag for -export-instance
+	importInstance := flag.String("import-instance", "", "Import an export made with -export-instance")
+
+	computeCohorts := flag.Int("compute-cohorts", 0, "Compute the signup and community cohorts of the last days")
+	exportWarehouse := flag.String("export-warehouse", "", "Export a day (YYYY-MM-DD) to the warehouse (see warehouseExport)")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		printCommands()
+	}
 	flag.Parse()
 	serve := *runServer
 
@@ -225,10 +790,30 @@ func parseFlags(db *sql.DB, c *config.Config) (bool, error) {
 	}
 
 	if *runMigrations {
-		if err := migrate(c, true, *steps); err != nil {
+		opts := migrations.Options{
+			Steps:           *steps,
+			DryRun:          *dryRun,
+			IgnoreChecksums: *ignoreChecksums,
+			Log:             true,
+		}
+		if *migrateTo >= 0 {
+			to := uint(*migrateTo)
+			opts.To = &to
+		}
+		if *migrateDown {
+			if opts.Steps == 0 {
+				opts.Steps = 1
+			}
+			if opts.Steps > 0 {
+				opts.Steps = -opts.Steps
+			}
+		}
+		if err := migrate(db, c, opts); err != nil {
 			return false, err
 		}
-		log.Println("Migrations ran successfully.")
+		if !*dryRun {
+			log.Println("Migrations ran successfully.")
+		}
 	}
 
 	// New-migration command:
@@ -240,13 +825,15 @@ func parseFlags(db *sql.DB, c *config.Config) (bool, error) {
 		}
 		return false
 	}() {
-		folder, err := os.Open("./migrations/")
+		entries, err := os.ReadDir("./migrations/")
 		if err != nil {
 			return false, err
 		}
-		files, err := folder.Readdirnames(0)
-		if err != nil {
-			return false, err
+		var files []string
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, entry.Name())
+			}
 		}
 		sort.Strings(files)
 
@@ -273,13 +860,15 @@ func parseFlags(db *sql.DB, c *config.Config) (bool, error) {
 		}
 		name = newVersion + "_" + strings.ToLower(strings.ReplaceAll(name, " ", "_"))
 		newFiles := []string{name + ".down.sql", name + ".up.sql"}
-		for _, name := range newFiles {
-			file, err := os.Create("./migrations/" + name)
-			if err != nil {
-				return false, err
-			}
-			if err := file.Close(); err != nil {
-				return false, err
+		for _, folder := range []string{"./migrations/", "./migrations/sqlite/"} {
+			for _, name := range newFiles {
+				file, err := os.Create(folder + name)
+				if err != nil {
+					return false, err
+				}
+				if err := file.Close(); err != nil {
+					return false, err
+				}
 			}
 		}
 		return false, nil
@@ -343,11 +932,7 @@ func parseFlags(db *sql.DB, c *config.Config) (bool, error) {
 		if err != nil {
 			return false, err
 		}
-		pass, err := core.HashPassword([]byte(*password))
-		if err != nil {
-			return false, err
-		}
-		if _, err = db.Exec("update users set password = ? where id = ?", pass, user.ID); err != nil {
+		if err := user.SetPassword(ctx, *password); err != nil {
 			return false, err
 		}
 		log.Println("Password changed successfully")
@@ -362,6 +947,101 @@ func parseFlags(db *sql.DB, c *config.Config) (bool, error) {
 		return false, nil
 	}
 
+	if *importDump != "" {
+		comm, err := core.GetCommunityByName(ctx, db, *community, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		source := *importSource
+		if source == "" {
+			source = *importFormat + "/" + comm.Name
+		}
+		stats, err := core.ImportCommunityDump(ctx, db, &core.ImportOptions{
+			Community: comm.ID,
+			Format:    importer.Format(*importFormat),
+			Paths:     strings.Split(*importDump, ","),
+			Source:    source,
+		})
+		if stats != nil {
+			log.Printf("Import stats: %+v\n", *stats)
+		}
+		if err != nil {
+			log.Fatal("Import failed (run it again to resume): ", err)
+		}
+		return false, nil
+	}
+
+	if *exportInstance != "" {
+		name := *exportName
+		if name == "" {
+			name = c.SiteName
+		}
+		f, err := os.Create(*exportInstance)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := core.ExportInstance(ctx, db, f, name); err != nil {
+			f.Close()
+			log.Fatal("Export failed: ", err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Site exported to ", *exportInstance)
+		return false, nil
+	}
+
+	if *importInstance != "" {
+		f, err := os.Open(*importInstance)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		stats, err := core.ImportInstance(ctx, db, f)
+		if stats != nil {
+			log.Printf("Import stats: %+v\n", *stats)
+		}
+		if err != nil {
+			log.Fatal("Import failed (run it again to resume): ", err)
+		}
+		return false, nil
+	}
+
+	if *computeCohorts > 0 {
+		now := time.Now()
+		if err := core.ComputeCohorts(ctx, db, now.AddDate(0, 0, -*computeCohorts), now); err != nil {
+			log.Fatal("Computing cohorts failed: ", err)
+		}
+		log.Printf("Computed the cohorts of the last %d days\n", *computeCohorts)
+		return false, nil
+	}
+
+	if *exportWarehouse != "" {
+		day, err := time.Parse(time.DateOnly, *exportWarehouse)
+		if err != nil {
+			log.Fatal("Invalid day: ", err)
+		}
+		save := warehouseSaver(c)
+		if save == nil {
+			log.Fatal("warehouseExport is not set in the config")
+		}
+		manifest, err := core.ExportWarehouseDay(ctx, db, day, save)
+		if err != nil {
+			log.Fatal("Exporting to the warehouse failed: ", err)
+		}
+		log.Printf("Exported %s to the warehouse: %v\n", manifest.Day, manifest.Files)
+		return false, nil
+	}
+
+	if *moveImages != "" {
+		n, err := images.MoveImages(ctx, db, *moveImagesFrom, *moveImages)
+		log.Printf("Moved %d images from %s to %s\n", n, *moveImagesFrom, *moveImages)
+		if err != nil {
+			log.Fatal("Moving images failed (run it again to resume): ", err)
+		}
+		return false, nil
+	}
+
 	if *showImagePath != "" {
 		id, err := uid.FromString(*showImagePath)
 		if err != nil {
@@ -381,6 +1061,39 @@ func parseFlags(db *sql.DB, c *config.Config) (bool, error) {
 	return serve, nil
 }
 
+// warehouseSaver returns the function with which the files of warehouse
+// exports are saved (as per c.WarehouseExport), or nil if exports are off.
+func warehouseSaver(c *config.Config) func(name string, data []byte) error {
+	switch c.WarehouseExport {
+	case "disk":
+		return func(name string, data []byte) error {
+			path := filepath.Join(c.WarehouseExportPath, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			return os.WriteFile(path, data, 0644)
+		}
+	case "s3":
+		opts := images.S3Options{
+			Endpoint:  c.S3Endpoint,
+			Region:    c.S3Region,
+			Bucket:    c.S3Bucket,
+			AccessKey: c.S3AccessKey,
+			SecretKey: c.S3SecretKey,
+			PathStyle: c.S3PathStyle,
+			Prefix:    c.WarehouseExportPath,
+		}
+		return func(name string, data []byte) error {
+			contentType := "application/gzip"
+			if filepath.Ext(name) == ".json" {
+				contentType = "application/json"
+			}
+			return images.PutS3Object(opts, name, contentType, data)
+		}
+	}
+	return nil
+}
+
 // mysqlDSN returns a DSN that could be used to connect to a MySQL database. You
 // may want to append mysql:// to the beginning of the returned string.
 func mysqlDSN(user, password, dbName string) string {
@@ -392,16 +1105,34 @@ func mysqlDSN(user, password, dbName string) string {
 	return cfg.FormatDSN()
 }
 
-// openDatabase returns a connection to mysql.
-func openDatabase(user, password, dbName string) *sql.DB {
-	if dbName == "" {
+// sqliteDSN returns a DSN that could be used to open the SQLite database file
+// at path. You may want to append sqlite3:// to the beginning of the returned
+// string.
+func sqliteDSN(path string) string {
+	return "file:" + path + "?_foreign_keys=on&_busy_timeout=5000"
+}
+
+// openDatabase returns a connection to the database of c (which is either
+// MySQL or SQLite).
+func openDatabase(c *config.Config) *sql.DB {
+	if c.DBName == "" {
 		log.Fatal("No database selected")
 	}
 
-	db, err := sql.Open("mysql", mysqlDSN(user, password, dbName))
+	dsn := mysqlDSN(c.DBUser, c.DBPassword, c.DBName)
+	if c.DBDriver == msql.DriverSQLite {
+		dsn = sqliteDSN(c.DBName)
+	}
+	msql.SetDriver(c.DBDriver)
+
+	db, err := tracing.OpenDB(c.DBDriver, dsn)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if c.DBDriver == msql.DriverSQLite {
+		// SQLite allows only one writer at a time.
+		db.SetMaxOpenConns(1)
+	}
 
 	if err = db.Ping(); err != nil {
 		log.Fatal(err)
@@ -424,7 +1155,7 @@ func populatePost(db *sql.DB, id, username string, n int, onlyTopLevel bool) {
 
 	selectComment := func() *core.Comment {
 		var id uid.ID
-		row := db.QueryRow("select id from comments where deleted_at is null and post_id = ? order by RAND() limit 1", post.ID)
+		row := db.QueryRow("select id from comments where deleted_at is null and post_id = ? order by "+msql.RandomFunc()+" limit 1", post.ID)
 		if err := row.Scan(&id); err != nil {
 			return nil
 		}
@@ -445,7 +1176,7 @@ func populatePost(db *sql.DB, id, username string, n int, onlyTopLevel bool) {
 			}
 		}
 		text := utils.GenerateText()
-		nc, err := post.AddComment(ctx, user.ID, core.UserGroupNormal, parent, text)
+		nc, err := post.AddComment(ctx, user.ID, core.UserGroupNormal, parent, text, nil)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -457,11 +1188,6 @@ func populatePost(db *sql.DB, id, username string, n int, onlyTopLevel bool) {
 
 // hardReset deletes and recreates the database and Redis.
 func hardReset(c *config.Config) error {
-	mysql, err := sql.Open("mysql", c.DBUser+":"+c.DBPassword+"@/?parseTime=true")
-	if err != nil {
-		return err
-	}
-
 	r := bufio.NewReader(os.Stdin)
 	fmt.Print("Type YES to continue: ")
 	if s, err := r.ReadString('\n'); err != nil {
@@ -470,31 +1196,45 @@ func hardReset(c *config.Config) error {
 		return errors.New("cannot continue without YES")
 	}
 
-	if _, err = mysql.Exec("drop database if exists " + c.DBName); err != nil {
-		return err
-	}
+	if c.DBDriver == msql.DriverSQLite {
+		if err := os.Remove(c.DBName); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	} else {
+		mysql, err := sql.Open("mysql", c.DBUser+":"+c.DBPassword+"@/?parseTime=true")
+		if err != nil {
+			return err
+		}
 
-	if _, err = mysql.Exec("create database " + c.DBName + " default character set utf8mb4"); err != nil {
-		return err
-	}
+		if _, err = mysql.Exec("drop database if exists " + c.DBName); err != nil {
+			return err
+		}
 
-	log.Println("Database (" + c.DBName + ") created")
+		if _, err = mysql.Exec("create database " + c.DBName + " default character set utf8mb4"); err != nil {
+			return err
+		}
 
-	if err = mysql.Close(); err != nil {
-		return err
+		if err = mysql.Close(); err != nil {
+			return err
+		}
 	}
 
-	if err = migrate(c, true, 0); err != nil {
+	log.Println("Database (" + c.DBName + ") created")
+
+	db := openDatabase(c)
+	defer db.Close()
+	if err := migrate(db, c, migrations.Options{Log: true}); err != nil {
 		return err
 	}
 
-	conn, err := redis.Dial("tcp", c.RedisAddress)
+	conn, err := redis.Dial("tcp", c.RedisAddress, redis.DialDatabase(c.RedisDB))
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 
-	if _, err = conn.Do("flushall"); err != nil {
+	// Only the database of the site (other sites may share the server).
+	if _, err = conn.Do("flushdb"); err != nil {
 		return err
 	}
 
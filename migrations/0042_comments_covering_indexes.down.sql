@@ -0,0 +1,3 @@
+alter table comments drop index user_id_created_at;
+
+alter table comments drop index community_id_created_at;
@@ -0,0 +1,3 @@
+alter table comments add index user_id_created_at (user_id, created_at);
+
+alter table comments add index community_id_created_at (community_id, created_at);
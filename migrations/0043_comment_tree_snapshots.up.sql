@@ -0,0 +1,11 @@
+create table if not exists comment_tree_snapshots (
+	post_id binary (12) not null,
+	sort varchar (16) not null,
+	comments mediumblob not null,
+	next_cursor varchar (64),
+	no_comments int unsigned not null default 0,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (post_id, sort),
+	foreign key (post_id) references posts (id)
+);
@@ -0,0 +1 @@
+drop table if exists migration_checksums;
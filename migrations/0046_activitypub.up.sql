@@ -0,0 +1,36 @@
+create table if not exists activitypub_keys (
+	community_id binary (12) not null,
+	public_key text not null,
+	private_key text not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (community_id),
+	foreign key (community_id) references communities (id)
+);
+
+create table if not exists activitypub_followers (
+	id bigint not null auto_increment,
+	community_id binary (12) not null,
+	actor_id varchar (512) not null,
+	inbox varchar (512) not null,
+	shared_inbox varchar (512),
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	foreign key (community_id) references communities (id),
+	unique (community_id, actor_id)
+);
+
+create table if not exists activitypub_deliveries (
+	id bigint not null auto_increment,
+	community_id binary (12) not null,
+	inbox varchar (512) not null,
+	activity mediumtext not null,
+	attempts int not null default 0,
+	next_attempt_at datetime not null default current_timestamp(),
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	foreign key (community_id) references communities (id),
+	index (next_attempt_at)
+);
@@ -0,0 +1,4 @@
+drop table if exists activitypub_blocks;
+drop table if exists activitypub_announces;
+drop table if exists activitypub_objects;
+drop table if exists activitypub_actors;
@@ -0,0 +1,49 @@
+create table if not exists activitypub_actors (
+	user_id binary (12) not null,
+	actor_id varchar (512) not null,
+	handle varchar (255) not null,
+	domain varchar (255) not null,
+	url varchar (512),
+	inbox varchar (512) not null,
+	created_at datetime not null default current_timestamp(),
+	updated_at datetime not null default current_timestamp(),
+
+	primary key (user_id),
+	foreign key (user_id) references users (id),
+	unique (actor_id),
+	index (domain)
+);
+
+create table if not exists activitypub_objects (
+	object_id varchar (512) not null,
+	comment_id binary (12) not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (object_id),
+	foreign key (comment_id) references comments (id),
+	unique (comment_id)
+);
+
+create table if not exists activitypub_announces (
+	post_id binary (12) not null,
+	actor_id varchar (512) not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (post_id, actor_id),
+	foreign key (post_id) references posts (id)
+);
+
+create table if not exists activitypub_blocks (
+	id bigint not null auto_increment,
+	community_id binary (12),
+	domain varchar (255),
+	actor_id varchar (512),
+	created_by binary (12) not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	foreign key (community_id) references communities (id),
+	foreign key (created_by) references users (id),
+	index (domain),
+	index (actor_id)
+);
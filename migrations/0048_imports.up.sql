@@ -0,0 +1,9 @@
+create table if not exists imports (
+	source varchar (255) not null,
+	kind varchar (16) not null,
+	source_id varchar (255) not null,
+	target_id binary (12) not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (source, kind, source_id)
+);
@@ -0,0 +1,13 @@
+create table if not exists image_quarantine (
+	image_id binary (12) not null,
+	scanner varchar (64) not null,
+	reason text,
+	created_at datetime not null default current_timestamp(),
+	rejected bool not null default false,
+	reviewed_by binary (12),
+	reviewed_at datetime,
+
+	primary key (image_id),
+	foreign key (image_id) references images (id),
+	foreign key (reviewed_by) references users (id)
+);
@@ -0,0 +1,34 @@
+create table if not exists media_uploads (
+	media_id binary (12) not null, -- The id of an image or a video.
+	media_type varchar (16) not null, -- Either image or video.
+	user_id binary (12),
+	community_id binary (12),
+	size bigint not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (media_id),
+	index (user_id),
+	index (community_id),
+	foreign key (user_id) references users (id),
+	foreign key (community_id) references communities (id)
+);
+
+-- Account for the media uploaded before this table existed.
+insert into media_uploads (media_id, media_type, user_id, community_id, size, created_at)
+	select images.id, 'image', users.id, null, images.size, images.created_at
+	from users inner join images on images.id = users.pro_pic;
+insert into media_uploads (media_id, media_type, user_id, community_id, size, created_at)
+	select images.id, 'image', null, communities.id, images.size, images.created_at
+	from communities inner join images on images.id = communities.pro_pic_2 or images.id = communities.banner_image_2;
+insert into media_uploads (media_id, media_type, user_id, community_id, size, created_at)
+	select images.id, 'image', posts.user_id, posts.community_id, images.size, images.created_at
+	from post_images inner join posts on posts.id = post_images.post_id inner join images on images.id = post_images.image_id;
+insert into media_uploads (media_id, media_type, user_id, community_id, size, created_at)
+	select images.id, 'image', comments.user_id, comments.community_id, images.size, images.created_at
+	from comment_images inner join comments on comments.id = comment_images.comment_id inner join images on images.id = comment_images.image_id;
+insert into media_uploads (media_id, media_type, user_id, community_id, size, created_at)
+	select images.id, 'image', temp_images_2.user_id, null, images.size, images.created_at
+	from temp_images_2 inner join images on images.id = temp_images_2.image_id;
+insert into media_uploads (media_id, media_type, user_id, community_id, size, created_at)
+	select videos.id, 'video', videos.user_id, posts.community_id, case when videos.size > 0 then videos.size else videos.upload_size end, videos.created_at
+	from videos left join post_videos on post_videos.video_id = videos.id left join posts on posts.id = post_videos.post_id;
@@ -0,0 +1,32 @@
+-- Simhashes of the bodies of recent comments, for detecting floods of
+-- duplicate comments.
+create table if not exists comment_fingerprints (
+	comment_id binary (12) not null,
+	user_id binary (12) not null,
+	simhash bigint not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (comment_id),
+	index (created_at),
+	foreign key (comment_id) references comments (id),
+	foreign key (user_id) references users (id)
+);
+
+-- Comments withheld, as likely duplicate floods, until an admin reviews them.
+create table if not exists held_comments (
+	id binary (12) not null,
+	post_id binary (12) not null,
+	parent_id binary (12),
+	user_id binary (12) not null,
+	user_group tinyint not null default 1,
+	body text not null,
+	image_id binary (12),
+	simhash bigint not null,
+	reason varchar (255) not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	index (created_at),
+	foreign key (post_id) references posts (id),
+	foreign key (user_id) references users (id)
+);
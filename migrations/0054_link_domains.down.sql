@@ -0,0 +1,3 @@
+alter table posts drop index link_domain;
+alter table posts drop column link_domain;
+drop table if exists link_domain_rules;
@@ -0,0 +1,20 @@
+-- Site-wide (if community_id is null) and per-community rules that block or
+-- allow links to domains matching pattern.
+create table if not exists link_domain_rules (
+	id bigint not null auto_increment,
+	community_id binary (12),
+	pattern varchar (255) not null,
+	allow bool not null default false,
+	created_by binary (12) not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	unique (community_id, pattern),
+	foreign key (community_id) references communities (id),
+	foreign key (created_by) references users (id)
+);
+
+alter table posts add column link_domain varchar (255) after link_info;
+alter table posts add index (link_domain, created_at);
+
+update posts set link_domain = lower(json_unquote(json_extract(link_info, '$.h'))) where link_info is not null;
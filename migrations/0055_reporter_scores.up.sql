@@ -0,0 +1,11 @@
+-- How many of the reports of each user the mods upheld (by removing the
+-- reported post or comment) and dismissed.
+create table if not exists reporter_scores (
+	user_id binary (12) not null,
+	upheld int not null default 0,
+	dismissed int not null default 0,
+	updated_at datetime not null default current_timestamp(),
+
+	primary key (user_id),
+	foreign key (user_id) references users (id)
+);
@@ -0,0 +1,46 @@
+-- Recent signups, for checking how many accounts are being created from the
+-- same IP address or network.
+create table if not exists signups (
+	user_id binary (12) not null,
+	ip varchar (45) not null,
+	network varchar (64) not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (user_id),
+	index (ip, created_at),
+	index (network, created_at),
+	index (created_at),
+	foreign key (user_id) references users (id)
+);
+
+-- Suspicious new accounts, whose posts and comments are held for review until
+-- enough of them are approved.
+create table if not exists quarantined_users (
+	user_id binary (12) not null,
+	reason varchar (255) not null,
+	approved int not null default 0,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (user_id),
+	foreign key (user_id) references users (id)
+);
+
+-- Posts withheld until an admin reviews them.
+create table if not exists held_posts (
+	id binary (12) not null,
+	type tinyint not null,
+	community_id binary (12) not null,
+	user_id binary (12) not null,
+	title varchar (255) not null,
+	body text,
+	link text,
+	image_id binary (12),
+	video_id binary (12),
+	reason varchar (255) not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	index (created_at),
+	foreign key (community_id) references communities (id),
+	foreign key (user_id) references users (id)
+);
@@ -0,0 +1,2 @@
+drop table if exists login_devices;
+drop table if exists auth_events;
@@ -0,0 +1,30 @@
+-- Failed logins, lockouts, and logins from new devices, for throttling logins
+-- and for the admins to review.
+create table if not exists auth_events (
+	id bigint not null auto_increment,
+	kind varchar (32) not null,
+	user_id binary (12),
+	username varchar (64) not null,
+	ip varchar (64) not null,
+	detail varchar (512),
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	index (username, created_at),
+	index (ip, created_at),
+	index (created_at)
+);
+
+-- The devices (a hash of the user agent and the network of the IP address)
+-- each user logged in from.
+create table if not exists login_devices (
+	user_id binary (12) not null,
+	device char (64) not null,
+	ip varchar (64) not null,
+	user_agent varchar (255) not null,
+	created_at datetime not null default current_timestamp(),
+	last_seen_at datetime not null default current_timestamp(),
+
+	primary key (user_id, device),
+	foreign key (user_id) references users (id)
+);
@@ -0,0 +1,5 @@
+alter table community_members drop index created_at;
+
+drop table if exists community_cohorts;
+
+drop table if exists signup_cohorts;
@@ -0,0 +1,18 @@
+-- The top posts, comments, and users (by the points of their posts and
+-- comments) of each period, site-wide (community_id is null) and of each
+-- community. Computed periodically.
+create table if not exists leaderboards (
+	id bigint not null auto_increment,
+	kind varchar (16) not null,
+	period varchar (16) not null,
+	community_id binary (12),
+	position int not null,
+	item_id binary (12) not null,
+	score int not null,
+	computed_at datetime not null,
+
+	primary key (id),
+	index (kind, period, community_id, position),
+	index (period, computed_at),
+	foreign key (community_id) references communities (id) on delete cascade
+);
@@ -0,0 +1,12 @@
+-- Hourly views, votes (net of those undone), and comments of each post, shown
+-- to their authors.
+create table if not exists post_stats (
+	post_id binary (12) not null,
+	hour datetime not null,
+	views int not null default 0,
+	upvotes int not null default 0,
+	downvotes int not null default 0,
+	comments int not null default 0,
+
+	primary key (post_id, hour)
+);
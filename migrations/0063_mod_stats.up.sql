@@ -0,0 +1,40 @@
+-- The actions of the mods (and admins) in each community, counted in the mod
+-- stats.
+create table if not exists mod_actions (
+	id bigint not null auto_increment,
+	community_id binary (12) not null,
+	user_id binary (12) not null,
+	action varchar (32) not null,
+	created_at datetime not null,
+
+	primary key (id),
+	index (community_id, created_at),
+	foreign key (community_id) references communities (id) on delete cascade,
+	foreign key (user_id) references users (id)
+);
+
+-- When each report was made and when it was resolved (reports are deleted on
+-- resolution), for the time mods take to resolve reports.
+create table if not exists report_resolutions (
+	id bigint not null auto_increment,
+	community_id binary (12) not null,
+	user_id binary (12) not null,
+	upheld boolean not null,
+	reported_at datetime not null,
+	resolved_at datetime not null,
+
+	primary key (id),
+	index (community_id, resolved_at),
+	foreign key (community_id) references communities (id) on delete cascade,
+	foreign key (user_id) references users (id)
+);
+
+-- Hourly snapshots of the number of open reports of each community with any.
+create table if not exists mod_queue_snapshots (
+	community_id binary (12) not null,
+	hour datetime not null,
+	open_reports int not null,
+
+	primary key (community_id, hour),
+	foreign key (community_id) references communities (id) on delete cascade
+);
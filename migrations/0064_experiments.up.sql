@@ -0,0 +1,27 @@
+-- Experiments (feature flags with percentage rollouts). Users are bucketed by
+-- a hash of the name of the experiment and their ID.
+create table if not exists experiments (
+	name varchar (64) not null,
+	description text,
+	variants varchar (512) not null, -- Comma separated.
+	rollout int not null default 0, -- The percentage of users in the experiment.
+	enabled boolean not null default false,
+	created_at datetime not null,
+	updated_at datetime not null,
+
+	primary key (name)
+);
+
+-- The first time each user was shown each experiment, and the variant they
+-- were shown.
+create table if not exists experiment_exposures (
+	experiment varchar (64) not null,
+	user_id binary (12) not null,
+	variant varchar (64) not null,
+	created_at datetime not null,
+
+	primary key (experiment, user_id),
+	index (experiment, created_at),
+	foreign key (experiment) references experiments (name) on delete cascade,
+	foreign key (user_id) references users (id) on delete cascade
+);
@@ -0,0 +1,15 @@
+-- The number of reports, and of removals by mods and admins, in each community
+-- in each day, by the report reason (for removals, the reason the removed post
+-- or comment was most reported for, or 0 if it was not reported).
+create table if not exists abuse_rollups (
+	day datetime not null,
+	community_id binary (12) not null,
+	event varchar (16) not null, -- report or removal
+	target_type tinyint not null, -- As in reports.report_type.
+	reason_id int not null,
+	total int not null default 0,
+
+	primary key (community_id, day, event, target_type, reason_id),
+	index (day),
+	foreign key (community_id) references communities (id) on delete cascade
+);
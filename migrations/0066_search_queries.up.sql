@@ -0,0 +1,11 @@
+-- Hourly counts of the (normalized and anonymized) search queries, and of those
+-- that found nothing. No user, session, or IP address is recorded.
+create table if not exists search_queries (
+	query varchar (128) not null,
+	hour datetime not null,
+	searches int not null default 0,
+	zero_results int not null default 0,
+
+	primary key (query, hour),
+	index (hour)
+);
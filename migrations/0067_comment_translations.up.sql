@@ -0,0 +1,12 @@
+-- Translations of comments, made on demand and cached. source_version is the
+-- edit time (as a Unix timestamp, or 0 if never edited) of the comment body
+-- that was translated; translations of older versions are stale.
+create table if not exists comment_translations (
+	comment_id binary (12) not null,
+	lang varchar (16) not null,
+	source_version bigint not null,
+	body text not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (comment_id, lang)
+);
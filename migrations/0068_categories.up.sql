@@ -0,0 +1,23 @@
+-- The site-curated categories (like Tech, Gaming, and Science) communities are
+-- listed under, for discovery.
+create table if not exists categories (
+	id bigint not null auto_increment,
+	slug varchar (32) not null,
+	name varchar (64) not null,
+	description varchar (255) not null default '',
+	position int not null default 0,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	unique (slug)
+);
+
+create table if not exists community_categories (
+	community_id binary (12) not null,
+	category_id bigint not null,
+
+	primary key (community_id, category_id),
+	index (category_id),
+	foreign key (community_id) references communities (id),
+	foreign key (category_id) references categories (id)
+);
@@ -0,0 +1 @@
+alter table reports drop column snapshot;
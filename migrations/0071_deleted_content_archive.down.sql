@@ -0,0 +1,4 @@
+drop table if exists content_purge_runs;
+drop table if exists deleted_content_archive;
+
+alter table users drop column jurisdiction;
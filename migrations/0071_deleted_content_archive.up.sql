@@ -0,0 +1,33 @@
+-- The jurisdiction (a country code) of each user, by which the retention of
+-- their deleted content may be overridden.
+alter table users add column jurisdiction varchar (8);
+
+-- The titles and bodies of deleted posts and comments, kept until they're
+-- purged (see core.PurgeDeletedContent).
+create table if not exists deleted_content_archive (
+	id bigint not null auto_increment,
+	target_type tinyint not null, -- Either core.ReportTypePost or core.ReportTypeComment.
+	target_id binary (12) not null,
+	author_id binary (12) not null,
+	jurisdiction varchar (8),
+	title text,
+	body text,
+	deleted_at datetime not null,
+
+	primary key (id),
+	index (target_id),
+	index (deleted_at)
+);
+
+-- The runs of the job that purges deleted content.
+create table if not exists content_purge_runs (
+	id bigint not null auto_increment,
+	started_at datetime not null,
+	finished_at datetime not null,
+	archived_purged int not null default 0,
+	posts_purged int not null default 0,
+	error text,
+
+	primary key (id),
+	index (started_at)
+);
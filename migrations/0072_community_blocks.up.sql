@@ -0,0 +1,20 @@
+-- The blocks (the rules, links, related communities, and markdown) of the
+-- sidebar and about page of each community, in ascending order of z_index.
+create table if not exists community_blocks (
+	id bigint not null auto_increment,
+	community_id binary (12) not null,
+	block_type varchar (16) not null,
+	title varchar (128) not null default '',
+	body text, -- Of markdown blocks.
+	links text, -- Of links blocks, JSON encoded.
+	related text, -- Of related communities blocks, JSON encoded.
+	z_index int not null default 0,
+	created_by binary (12) not null,
+	created_at datetime not null default current_timestamp(),
+	edited_at datetime,
+
+	primary key (id),
+	index (community_id, z_index),
+	foreign key (community_id) references communities (id),
+	foreign key (created_by) references users (id)
+);
@@ -0,0 +1,28 @@
+-- The communities the mods of each community declared as related to it, in
+-- ascending order of position.
+create table if not exists related_communities (
+	community_id binary (12) not null,
+	related_id binary (12) not null,
+	position int not null default 0,
+	created_by binary (12) not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (community_id, related_id),
+	foreign key (community_id) references communities (id),
+	foreign key (related_id) references communities (id),
+	foreign key (created_by) references users (id)
+);
+
+-- The communities most similar to each community by the overlap of their
+-- members (recomputed periodically, see core.ComputeSimilarCommunities).
+create table if not exists similar_communities (
+	community_id binary (12) not null,
+	similar_id binary (12) not null,
+	overlap int not null, -- The number of members in common.
+	score double not null, -- The Jaccard index of the members of the two.
+	computed_at datetime not null,
+
+	primary key (community_id, similar_id),
+	foreign key (community_id) references communities (id),
+	foreign key (similar_id) references communities (id)
+);
@@ -0,0 +1,7 @@
+alter table reports drop index reports_to_admins;
+alter table reports drop column to_admins;
+
+delete from report_reasons where title in ('Harassment', 'Illegal content', 'Other') and id not in (select reason_id from reports);
+
+alter table report_reasons drop column to_admins;
+alter table report_reasons drop column category;
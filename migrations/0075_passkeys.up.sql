@@ -0,0 +1,19 @@
+-- The passkeys (WebAuthn credentials) of users.
+create table if not exists passkeys (
+	id bigint not null auto_increment,
+	user_id binary (12) not null,
+	credential_id varbinary (1023) not null,
+	public_key blob not null, -- COSE encoded.
+	sign_count int unsigned not null default 0,
+	aaguid binary (16),
+	name varchar (64) not null,
+	backup_eligible bool not null default false,
+	backed_up bool not null default false,
+	created_at datetime not null default current_timestamp(),
+	last_used_at datetime,
+
+	primary key (id),
+	unique (credential_id),
+	index (user_id),
+	foreign key (user_id) references users (id)
+);
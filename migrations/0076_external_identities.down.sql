@@ -0,0 +1,3 @@
+alter table users drop column password_login_disabled;
+
+drop table if exists external_identities;
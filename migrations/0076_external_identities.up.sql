@@ -0,0 +1,20 @@
+-- The accounts at external identity providers (Google, GitHub, or an OpenID
+-- Connect provider) linked to users, with which they log in.
+create table if not exists external_identities (
+	id bigint not null auto_increment,
+	user_id binary (12) not null,
+	provider varchar (32) not null,
+	subject varchar (255) not null, -- The ID of the account at the provider.
+	email varchar (320),
+	created_at datetime not null default current_timestamp(),
+	last_used_at datetime,
+
+	primary key (id),
+	unique (provider, subject),
+	index (user_id),
+	foreign key (user_id) references users (id)
+);
+
+-- Whether the user may not log in with their password (only with an external
+-- identity or a passkey).
+alter table users add column password_login_disabled bool not null default false;
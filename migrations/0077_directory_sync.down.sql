@@ -0,0 +1,5 @@
+drop table if exists directory_sync_runs;
+
+alter table external_identities drop column deactivated_at;
+
+drop table if exists directory_memberships;
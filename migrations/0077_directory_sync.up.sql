@@ -0,0 +1,33 @@
+-- The community memberships made by syncing the groups of users (from a
+-- directory or an identity provider, the source), which are removed if the
+-- users leave the groups.
+create table if not exists directory_memberships (
+	user_id binary (12) not null,
+	community_id binary (12) not null,
+	source varchar (32) not null,
+	created_at datetime not null default current_timestamp(),
+
+	primary key (user_id, community_id),
+	foreign key (user_id) references users (id),
+	foreign key (community_id) references communities (id)
+);
+
+-- When the user of the identity was deactivated (banned) for being removed
+-- from the directory.
+alter table external_identities add column deactivated_at datetime;
+
+-- The runs of the directory sync.
+create table if not exists directory_sync_runs (
+	id bigint not null auto_increment,
+	started_at datetime not null,
+	finished_at datetime not null,
+	users_created int not null default 0,
+	users_deactivated int not null default 0,
+	users_reactivated int not null default 0,
+	memberships_added int not null default 0,
+	memberships_removed int not null default 0,
+	error text,
+
+	primary key (id),
+	index (started_at)
+);
@@ -0,0 +1,12 @@
+-- The counters (ex: users.no_comments) found off, and fixed, by the nightly
+-- recount: the number of rows that were off, and by how much in all.
+create table if not exists counter_audits (
+	id bigint not null auto_increment,
+	counter varchar (64) not null,
+	rows_off int not null,
+	drift bigint not null,
+	audited_at datetime not null,
+
+	primary key (id),
+	index (audited_at)
+);
@@ -0,0 +1,12 @@
+-- The notifications to be created (see core.DeliverNotifications), queued in
+-- the same transactions as the changes they're about.
+create table if not exists notification_outbox (
+	id bigint not null auto_increment,
+	notification text not null,
+	attempts int not null default 0,
+	next_attempt_at datetime not null default current_timestamp(),
+	created_at datetime not null default current_timestamp(),
+
+	primary key (id),
+	index (next_attempt_at)
+);
@@ -0,0 +1,5 @@
+-- The number of times the post or comment has been edited, which edits are
+-- conditioned on (so that concurrent edits aren't lost).
+alter table posts add column version int not null default 0;
+
+alter table comments add column version int not null default 0;
@@ -0,0 +1,5 @@
+-- The time zones (IANA names, like Europe/Paris) of users and communities, in
+-- which the times shown to them are. Empty for UTC.
+alter table users add column time_zone varchar (64) not null default '';
+
+alter table communities add column time_zone varchar (64) not null default '';
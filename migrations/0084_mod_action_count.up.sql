@@ -0,0 +1,3 @@
+-- The number of things acted on by a mod action (like the comments of a
+-- subtree removed at once), which is counted in the mod stats as one action.
+alter table mod_actions add column count int not null default 1;
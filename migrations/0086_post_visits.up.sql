@@ -0,0 +1,13 @@
+-- When users last visited posts, to highlight the comments added since.
+create table if not exists post_visits (
+	user_id binary (12) not null,
+	post_id binary (12) not null,
+	visited_at datetime not null,
+	previous_visited_at datetime,
+
+	primary key (user_id, post_id),
+	foreign key (user_id) references users (id) on delete cascade,
+	foreign key (post_id) references posts (id) on delete cascade
+);
+
+create index post_visits_visited_at on post_visits (visited_at);
@@ -0,0 +1,11 @@
+-- The sitemaps of the site (of the communities, and of the posts created in
+-- each month), regenerated periodically. The entries are a JSON array of the
+-- paths of the pages and their last modified times.
+create table if not exists sitemaps (
+	name varchar (64) not null,
+	entries longtext not null,
+	last_mod datetime not null,
+	generated_at datetime not null,
+
+	primary key (name)
+);
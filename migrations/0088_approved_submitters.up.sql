@@ -0,0 +1,13 @@
+-- Users whose posts and comments in a community skip the automatic holds and
+-- the new-account restrictions there.
+create table if not exists community_approved_submitters (
+	community_id binary (12) not null,
+	user_id binary (12) not null,
+	added_by binary (12) not null,
+	created_at datetime not null,
+
+	primary key (community_id, user_id),
+	foreign key (community_id) references communities (id) on delete cascade,
+	foreign key (user_id) references users (id) on delete cascade,
+	foreign key (added_by) references users (id)
+);
@@ -0,0 +1,5 @@
+drop table if exists deferred_push_notifications;
+
+alter table users drop column quiet_hours_end;
+
+alter table users drop column quiet_hours_start;
@@ -0,0 +1 @@
+drop table if exists supporter_entitlements;
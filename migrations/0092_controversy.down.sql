@@ -0,0 +1,9 @@
+alter table comments drop index comments_post_controversy;
+
+alter table posts drop index posts_deleted_community_controversy;
+
+alter table posts drop index posts_deleted_controversy;
+
+alter table comments drop column controversy;
+
+alter table posts drop column controversy;
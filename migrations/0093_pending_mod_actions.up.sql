@@ -0,0 +1,19 @@
+-- The destructive mod actions whose side effects (notifications, and the
+-- upholding and purging of reports) are held back until execute_at, before
+-- which they can be undone (see core.PendingModAction).
+create table if not exists pending_mod_actions (
+	id bigint not null auto_increment,
+	community_id binary (12) not null,
+	mod_id binary (12) not null,
+	mod_group tinyint not null, -- In what capacity (as mod or admin) it was taken.
+	action varchar (32) not null, -- One of the core.ModAction constants.
+	target_id binary (12) not null, -- The post, the comment, or the user banned.
+	execute_at datetime not null,
+	created_at datetime not null,
+
+	primary key (id),
+	index (community_id, created_at),
+	index (execute_at),
+	foreign key (community_id) references communities (id) on delete cascade,
+	foreign key (mod_id) references users (id) on delete cascade
+);
@@ -0,0 +1,5 @@
+-- Whether image posts, and GIFs (in image posts and attached to comments), are
+-- disallowed in communities, by their mods.
+alter table communities add column disable_image_posts bool not null default false;
+
+alter table communities add column disable_gifs bool not null default false;
@@ -0,0 +1,3 @@
+alter table posts drop column license_text;
+
+alter table posts drop column license;
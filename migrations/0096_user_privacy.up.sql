@@ -0,0 +1,8 @@
+-- The privacy options of the profiles of users.
+alter table users add column hide_vote_history bool not null default false;
+
+alter table users add column hide_community_memberships bool not null default false;
+
+alter table users add column hide_online_status bool not null default false;
+
+alter table users add column profile_logged_in_only bool not null default false;
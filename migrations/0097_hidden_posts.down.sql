@@ -0,0 +1,5 @@
+alter table users drop column auto_hide_reported_posts;
+
+alter table users drop column auto_hide_voted_posts;
+
+drop table if exists hidden_posts;
@@ -0,0 +1,11 @@
+-- The accounts users linked together (say, a personal account and a bot), to
+-- switch between without logging in again. Each link is stored both ways.
+create table if not exists linked_accounts (
+	user_id binary (12) not null,
+	linked_user_id binary (12) not null,
+	created_at datetime not null,
+
+	primary key (user_id, linked_user_id),
+	foreign key (user_id) references users (id) on delete cascade,
+	foreign key (linked_user_id) references users (id) on delete cascade
+);
@@ -0,0 +1 @@
+alter table comment_tree_snapshots drop column version;
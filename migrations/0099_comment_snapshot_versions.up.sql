@@ -0,0 +1,3 @@
+-- Incremented on every save of a snapshot, so that the concurrent adding of
+-- comments to a snapshot is detected (instead of one overwriting the other).
+alter table comment_tree_snapshots add column version int not null default 0;
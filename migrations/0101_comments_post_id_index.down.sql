@@ -0,0 +1 @@
+alter table comments drop index comments_post_id;
@@ -0,0 +1,12 @@
+-- API tokens issued to OAuth clients expire (see core.oauthTokenLifetime) and
+-- are renewed with their refresh tokens.
+alter table api_tokens add column refresh_token_hash char (64);
+
+alter table api_tokens add column refresh_expires_at datetime;
+
+create unique index api_tokens_refresh_token_hash on api_tokens (refresh_token_hash);
+
+-- The tokens issued before, which have no refresh tokens, are left a month
+-- for their clients to have them authorized again.
+update api_tokens set expires_at = date_add(current_timestamp(), interval 30 day)
+where client_id is not null and expires_at is null;
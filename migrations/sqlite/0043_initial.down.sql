@@ -0,0 +1,34 @@
+drop table if exists user_badges;
+drop table if exists badge_types;
+drop table if exists post_images;
+drop table if exists muted_users;
+drop table if exists muted_communities;
+drop table if exists analytics;
+drop table if exists web_push_subscriptions;
+drop table if exists application_data;
+drop table if exists community_requests;
+drop table if exists pinned_posts;
+drop table if exists temp_images_2;
+drop table if exists temp_images;
+drop table if exists default_communities;
+drop table if exists reports;
+drop table if exists report_reasons;
+drop table if exists notifications;
+drop table if exists comment_tree_snapshots;
+drop table if exists posts_comments;
+drop table if exists comment_votes;
+drop table if exists comment_replies;
+drop table if exists comments;
+drop table if exists post_votes;
+drop table if exists posts_year;
+drop table if exists posts_month;
+drop table if exists posts_week;
+drop table if exists posts_today;
+drop table if exists posts;
+drop table if exists community_rules;
+drop table if exists community_banned;
+drop table if exists community_mods;
+drop table if exists community_members;
+drop table if exists communities;
+drop table if exists users;
+drop table if exists images;
@@ -0,0 +1,550 @@
+/*
+ * The SQLite schema. It's equivalent to the MySQL schema as of migration 0043
+ * (the migrations in this folder are numbered so that they match their MySQL
+ * counterparts in the parent folder).
+ */
+
+create table if not exists images (
+	id blob not null,
+	store_name varchar (64) not null,
+	store_metadata text,
+	format varchar (16) not null,
+	width int not null,
+	height int not null,
+	size int not null,
+	upload_size int not null,
+	average_color blob not null,
+	created_at datetime not null default current_timestamp,
+	deleted_at datetime,
+
+	primary key (id)
+);
+
+create table if not exists users (
+	id blob not null,
+	user_index integer,
+	username varchar (20) not null,
+	username_lc varchar (20) not null,
+	email varchar (255),
+	email_confirmed_at datetime,
+	password varchar (128) not null,
+	about_me text,
+	points int not null default 1,
+	is_admin boolean not null default false,
+	notifications_new_count int not null default 0,
+	created_at datetime not null default current_timestamp,
+	deleted_at datetime,
+	no_posts int not null default 0,
+	no_comments int not null default 0,
+	last_seen datetime not null default current_timestamp,
+	last_seen_ip varchar (45),
+	banned_at datetime,
+	upvote_notifications_off boolean not null default false,
+	reply_notifications_off boolean not null default false,
+	home_feed int not null default 0,
+	remember_feed_sort boolean not null default false,
+	embeds_off boolean not null default false,
+	pro_pic blob,
+	hide_user_profile_pictures boolean not null default false,
+
+	primary key (id),
+	unique (user_index),
+	unique (username_lc),
+	foreign key (pro_pic) references images (id)
+);
+
+create index users_email on users (email);
+create index users_last_seen on users (last_seen);
+
+create table if not exists communities (
+	id blob not null,
+	user_id blob not null,
+	name varchar (128) not null,
+	name_lc varchar (128) not null,
+	nsfw boolean not null default false,
+	about text,
+	no_members int not null default 0,
+	pro_pic text,
+	pro_pic_2 blob,
+	banner_image text,
+	banner_image_2 blob,
+	created_at datetime not null default current_timestamp,
+	deleted_at datetime,
+	deleted_by blob,
+
+	primary key (id),
+	foreign key (user_id) references users (id),
+	unique (name_lc),
+	foreign key (deleted_by) references users (id),
+	foreign key (pro_pic_2) references images (id),
+	foreign key (banner_image_2) references images (id)
+);
+
+create table if not exists community_members (
+	id integer primary key autoincrement,
+	community_id blob not null,
+	user_id blob not null,
+	is_mod boolean not null default false,
+	created_at datetime not null default current_timestamp,
+
+	foreign key (community_id) references communities (id),
+	foreign key (user_id) references users (id),
+	unique (community_id, user_id)
+);
+
+create table if not exists community_mods (
+	id integer primary key autoincrement,
+	community_id blob not null,
+	user_id blob not null,
+	created_at datetime not null default current_timestamp,
+	position int not null default 0, /* lower the value, higher up the mod */
+
+	foreign key (community_id) references communities (id),
+	foreign key (user_id) references users (id),
+	unique (community_id, user_id)
+);
+
+create table if not exists community_banned (
+	id integer primary key autoincrement,
+	community_id blob not null,
+	user_id blob not null,
+	expires datetime,
+	banned_by blob not null,
+	created_at datetime not null default current_timestamp,
+
+	foreign key (community_id) references communities (id),
+	foreign key (user_id) references users (id),
+	foreign key (banned_by) references users (id),
+	unique (community_id, user_id)
+);
+
+create table if not exists community_rules (
+	id integer primary key autoincrement,
+	rule varchar(255) not null,
+	description varchar(1024),
+	community_id blob not null,
+	created_by blob not null,
+	z_index int not null default 0,
+	created_at datetime not null default current_timestamp,
+
+	foreign key (community_id) references communities (id),
+	foreign key (created_by) references users (id)
+);
+
+create table if not exists posts (
+	id blob not null,
+	type tinyint not null default 0,
+	public_id char (8) not null,
+	user_id blob not null,
+	user_group tinyint not null default 1,
+	community_id blob not null,
+	title varchar (255) not null,
+	body text,
+	image text,
+	link_info text,
+	link_image blob,
+	locked boolean not null default false,
+	locked_at datetime,
+	locked_by blob,
+	locked_by_group tinyint not null default 0,
+	no_comments int not null default 0,
+	upvotes int not null default 0,
+	downvotes int not null default 0,
+	points int not null default 0,
+	hotness bigint not null default 0,
+	created_at datetime not null default current_timestamp,
+	edited_at datetime,
+	deleted boolean not null default false,
+	deleted_at datetime,
+	deleted_by blob,
+	deleted_as tinyint not null default 0,
+	deleted_content boolean not null default false,
+	deleted_content_at datetime,
+	deleted_content_by blob,
+	deleted_content_as tinyint not null default 0,
+	is_pinned_site boolean not null default false,
+	last_activity_at datetime not null default current_timestamp,
+	is_pinned boolean not null default false,
+
+	primary key (id),
+	unique (public_id),
+	foreign key (user_id) references users (id),
+	foreign key (community_id) references communities (id),
+	foreign key (locked_by) references users (id),
+	foreign key (deleted_by) references users (id),
+	foreign key (link_image) references images (id)
+);
+
+create index posts_deleted_points on posts (deleted, points, id);
+create index posts_community_deleted_points on posts (community_id, deleted, points, id);
+create index posts_locked_at on posts (locked_at);
+create index posts_deleted on posts (deleted, id);
+create index posts_community_deleted on posts (community_id, deleted, id);
+create index posts_deleted_at on posts (deleted_at);
+create index posts_deleted_hotness on posts (deleted, hotness, id);
+create index posts_deleted_community_hotness on posts (deleted, community_id, hotness, id);
+create index posts_last_activity_at on posts (deleted, last_activity_at);
+create index posts_last_activity_at_2 on posts (community_id, deleted, last_activity_at);
+
+create table if not exists posts_today (
+	id integer primary key autoincrement,
+	community_id blob not null,
+	post_id blob not null,
+	points int not null default 0,
+	created_at datetime not null default current_timestamp,
+	user_id blob not null,
+
+	foreign key (post_id) references posts (id),
+	foreign key (community_id) references communities (id)
+);
+
+create index posts_today_created_at on posts_today (created_at);
+create index posts_today_community_created_at on posts_today (community_id, created_at);
+create index posts_today_points on posts_today (points, post_id);
+create index posts_today_community_points on posts_today (community_id, points);
+
+create table if not exists posts_week (
+	id integer primary key autoincrement,
+	community_id blob not null,
+	post_id blob not null,
+	points int not null default 0,
+	created_at datetime not null default current_timestamp,
+	user_id blob not null,
+
+	foreign key (post_id) references posts (id),
+	foreign key (community_id) references communities (id)
+);
+
+create index posts_week_created_at on posts_week (created_at);
+create index posts_week_community_created_at on posts_week (community_id, created_at);
+create index posts_week_points on posts_week (points, post_id);
+create index posts_week_community_points on posts_week (community_id, points);
+
+create table if not exists posts_month (
+	id integer primary key autoincrement,
+	community_id blob not null,
+	post_id blob not null,
+	points int not null default 0,
+	created_at datetime not null default current_timestamp,
+	user_id blob not null,
+
+	foreign key (post_id) references posts (id),
+	foreign key (community_id) references communities (id)
+);
+
+create index posts_month_created_at on posts_month (created_at);
+create index posts_month_community_created_at on posts_month (community_id, created_at);
+create index posts_month_points on posts_month (points, post_id);
+create index posts_month_community_points on posts_month (community_id, points);
+
+create table if not exists posts_year (
+	id integer primary key autoincrement,
+	community_id blob not null,
+	post_id blob not null,
+	points int not null default 0,
+	created_at datetime not null default current_timestamp,
+	user_id blob not null,
+
+	foreign key (post_id) references posts (id),
+	foreign key (community_id) references communities (id)
+);
+
+create index posts_year_created_at on posts_year (created_at);
+create index posts_year_community_created_at on posts_year (community_id, created_at);
+create index posts_year_points on posts_year (points, post_id);
+create index posts_year_community_points on posts_year (community_id, points);
+
+create table if not exists post_votes (
+	id integer primary key autoincrement,
+	post_id blob not null,
+	user_id blob not null,
+	up boolean not null default true,
+	created_at datetime not null default current_timestamp,
+
+	foreign key (post_id) references posts (id),
+	foreign key (user_id) references users (id),
+	unique (post_id, user_id)
+);
+
+create table if not exists comments (
+	id blob not null,
+	post_id blob not null,
+	post_public_id char (8) not null,
+	community_id blob not null,
+	community_name varchar (128) not null,
+	user_id blob not null,
+	username varchar (20) not null,
+	user_deleted boolean not null default false,
+	user_group tinyint not null default 1,
+	parent_id blob,
+	ancestors text,
+	depth tinyint not null default 0,
+	no_replies int not null default 0,
+	no_replies_direct int not null default 0,
+	body text,
+	upvotes int not null default 0,
+	downvotes int not null default 0,
+	points int not null default 0,
+	created_at datetime not null default current_timestamp,
+	edited_at datetime,
+	deleted_at datetime,
+	deleted_by blob,
+	deleted_as tinyint not null default 0,
+
+	primary key (id),
+	foreign key (post_id) references posts (id),
+	foreign key (community_id) references communities (id),
+	foreign key (user_id) references users (id),
+	foreign key (parent_id) references comments (id),
+	foreign key (deleted_by) references users (id)
+);
+
+create index comments_post_depth on comments (post_id, depth, id);
+create index comments_post_upvotes on comments (post_id, upvotes, id);
+create index comments_post_created_at on comments (post_id, created_at);
+create index comments_user_id_created_at on comments (user_id, created_at);
+create index comments_community_id_created_at on comments (community_id, created_at);
+
+create table if not exists comment_replies (
+	id integer primary key autoincrement,
+	parent_id blob not null,
+	reply_id blob not null
+);
+
+create index comment_replies_parent_reply on comment_replies (parent_id, reply_id);
+
+create table if not exists comment_votes (
+	id integer primary key autoincrement,
+	comment_id blob not null,
+	user_id blob not null,
+	up boolean not null default true,
+	created_at datetime not null default current_timestamp,
+
+	foreign key (comment_id) references comments (id),
+	foreign key (user_id) references users (id),
+	unique (comment_id, user_id)
+);
+
+create table if not exists posts_comments (
+	id integer primary key autoincrement,
+	target_id blob not null,
+	target_type tinyint not null,
+	user_id blob not null,
+
+	unique (user_id, target_id)
+);
+
+create index posts_comments_user_target on posts_comments (user_id, target_type, target_id);
+
+create table if not exists comment_tree_snapshots (
+	post_id blob not null,
+	sort varchar (16) not null,
+	comments blob not null,
+	next_cursor varchar (64),
+	no_comments int not null default 0,
+	created_at datetime not null default current_timestamp,
+
+	primary key (post_id, sort),
+	foreign key (post_id) references posts (id)
+);
+
+create table if not exists notifications (
+	id integer primary key autoincrement,
+	user_id blob not null,
+	type varchar (32) not null,
+	notif text not null,
+	seen boolean not null default false,
+	created_at datetime not null default current_timestamp,
+	seen_at datetime,
+	updated_at datetime not null default current_timestamp,
+
+	foreign key (user_id) references users (id)
+);
+
+create index notifications_user on notifications (user_id, id);
+create index notifications_user_id_updated_at on notifications (user_id, updated_at);
+
+create table if not exists report_reasons (
+	id integer primary key autoincrement,
+	title varchar (255) not null,
+	description varchar (1024),
+	created_at datetime not null default current_timestamp
+);
+
+insert into report_reasons (title) values ('Breaks community rules');
+insert into report_reasons (title) values ('Copyright violation');
+insert into report_reasons (title) values ('Spam');
+insert into report_reasons (title) values ('Pornography');
+
+create table if not exists reports (
+	id integer primary key autoincrement,
+	community_id blob not null,
+	post_id blob,
+	report_type tinyint not null,
+	reason_id int not null,
+	target_id blob not null,
+	created_by blob not null,
+	action_taken varchar (32),
+	dealt_at datetime,
+	dealt_by blob,
+	created_at datetime not null default current_timestamp,
+
+	foreign key (community_id) references communities (id),
+	foreign key (reason_id) references report_reasons (id),
+	foreign key (created_by) references users (id),
+	foreign key (dealt_by) references users (id)
+);
+
+create index reports_post on reports (post_id);
+create index reports_target on reports (target_id);
+create index reports_dealt_at on reports (dealt_at);
+create index reports_action_taken on reports (action_taken);
+create index reports_created_at on reports (created_at);
+
+create table if not exists default_communities (
+	id integer primary key autoincrement,
+	name_lc varchar (128) not null,
+	community_id blob not null,
+
+	unique (name_lc),
+	foreign key (name_lc) references communities (name_lc),
+	foreign key (community_id) references communities (id)
+);
+
+create table if not exists temp_images (
+	id blob not null,
+	user_id blob not null,
+	created_at datetime not null default current_timestamp,
+
+	primary key (id),
+	foreign key (user_id) references users (id)
+);
+
+create index temp_images_created_at on temp_images (created_at);
+
+create table if not exists temp_images_2 (
+	id integer primary key autoincrement,
+	user_id blob not null,
+	image_id blob not null,
+	created_at datetime not null default current_timestamp,
+
+	foreign key (user_id) references users (id),
+	foreign key (image_id) references images (id),
+	unique (image_id)
+);
+
+create index temp_images_2_created_at on temp_images_2 (created_at);
+
+create table if not exists pinned_posts (
+	id integer primary key autoincrement,
+	post_id blob not null,
+	z_index int not null default 0,
+	created_at datetime not null default current_timestamp,
+	community_id blob,
+	is_site_wide boolean not null,
+
+	foreign key (community_id) references communities (id),
+	foreign key (post_id) references posts (id),
+	unique (is_site_wide, post_id)
+);
+
+create index pinned_posts_community_id_post_id on pinned_posts (community_id, post_id);
+
+create table if not exists community_requests (
+	id integer primary key autoincrement,
+	by_user varchar (20) not null,
+	community_name varchar (128) not null,
+	community_name_lc varchar (128) not null,
+	note text,
+	created_at datetime not null default current_timestamp,
+	deleted_at datetime,
+
+	unique (by_user, community_name_lc)
+);
+
+create table if not exists application_data (
+	`key` varchar (255) not null,
+	`value` text,
+	created_at datetime not null default current_timestamp,
+
+	primary key (`key`)
+);
+
+create table if not exists web_push_subscriptions (
+	id integer primary key autoincrement,
+	session_id varchar (512) not null,
+	user_id blob not null,
+	push_subscription text not null,
+	created_at datetime not null default current_timestamp,
+	updated_at datetime,
+
+	foreign key (user_id) references users (id),
+	unique (session_id)
+);
+
+create table if not exists analytics (
+	id integer primary key autoincrement,
+	event_name varchar (255) not null,
+	unique_key blob, /* md5 hash */
+	payload text,
+	created_at datetime not null default current_timestamp,
+
+	unique (unique_key)
+);
+
+create index analytics_event_name_created_at on analytics (event_name, created_at);
+create index analytics_created_at on analytics (created_at);
+
+create table if not exists muted_communities (
+	id integer primary key autoincrement,
+	user_id blob not null,
+	community_id blob not null,
+	created_at datetime not null default current_timestamp,
+
+	foreign key (user_id) references users (id),
+	foreign key (community_id) references communities (id),
+	unique (user_id, community_id)
+);
+
+create table if not exists muted_users (
+	id integer primary key autoincrement,
+	user_id blob not null,
+	muted_user_id blob not null,
+	created_at datetime not null default current_timestamp,
+
+	foreign key (user_id) references users (id),
+	foreign key (muted_user_id) references users (id),
+	unique (user_id, muted_user_id)
+);
+
+create table if not exists post_images (
+	id integer primary key autoincrement,
+	post_id blob not null,
+	image_id blob not null,
+	z_index int not null default 0,
+
+	unique (post_id, image_id),
+	foreign key (post_id) references posts (id),
+	foreign key (image_id) references images (id),
+	unique (image_id)
+);
+
+create table if not exists badge_types (
+	id integer primary key autoincrement,
+	name varchar (64) not null,
+	created_at datetime not null default current_timestamp,
+
+	unique (name)
+);
+
+create table if not exists user_badges (
+	id integer primary key autoincrement,
+	type int not null,
+	user_id blob not null,
+	created_at datetime not null default current_timestamp,
+
+	foreign key (type) references badge_types (id),
+	foreign key (user_id) references users (id)
+);
+
+create index user_badges_user on user_badges (user_id);
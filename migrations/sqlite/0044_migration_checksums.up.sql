@@ -0,0 +1,8 @@
+create table if not exists migration_checksums (
+	version bigint not null,
+	name varchar (255) not null,
+	checksum char (64) not null,
+	applied_at datetime not null default current_timestamp,
+
+	primary key (version)
+);
@@ -0,0 +1,3 @@
+drop table if exists oauth_codes;
+drop table if exists api_tokens;
+drop table if exists oauth_clients;
@@ -0,0 +1,41 @@
+create table if not exists oauth_clients (
+	id blob not null,
+	user_id blob not null,
+	name varchar (255) not null,
+	secret_hash char (64) not null,
+	redirect_uris text not null,
+	created_at datetime not null default current_timestamp,
+
+	primary key (id),
+	foreign key (user_id) references users (id)
+);
+
+create table if not exists api_tokens (
+	id integer primary key autoincrement,
+	user_id blob not null,
+	client_id blob,
+	name varchar (255) not null,
+	token_hash char (64) not null,
+	scopes varchar (255) not null,
+	last_used_at datetime,
+	expires_at datetime,
+	created_at datetime not null default current_timestamp,
+
+	foreign key (user_id) references users (id),
+	foreign key (client_id) references oauth_clients (id) on delete cascade,
+	unique (token_hash)
+);
+
+create table if not exists oauth_codes (
+	code_hash char (64) not null,
+	client_id blob not null,
+	user_id blob not null,
+	scopes varchar (255) not null,
+	redirect_uri text not null,
+	code_challenge varchar (255),
+	expires_at datetime not null,
+
+	primary key (code_hash),
+	foreign key (client_id) references oauth_clients (id) on delete cascade,
+	foreign key (user_id) references users (id)
+);
@@ -0,0 +1,3 @@
+drop table if exists activitypub_deliveries;
+drop table if exists activitypub_followers;
+drop table if exists activitypub_keys;
@@ -0,0 +1,35 @@
+create table if not exists activitypub_keys (
+	community_id blob not null,
+	public_key text not null,
+	private_key text not null,
+	created_at datetime not null default current_timestamp,
+
+	primary key (community_id),
+	foreign key (community_id) references communities (id)
+);
+
+create table if not exists activitypub_followers (
+	id integer primary key autoincrement,
+	community_id blob not null,
+	actor_id varchar (512) not null,
+	inbox varchar (512) not null,
+	shared_inbox varchar (512),
+	created_at datetime not null default current_timestamp,
+
+	foreign key (community_id) references communities (id),
+	unique (community_id, actor_id)
+);
+
+create table if not exists activitypub_deliveries (
+	id integer primary key autoincrement,
+	community_id blob not null,
+	inbox varchar (512) not null,
+	activity text not null,
+	attempts int not null default 0,
+	next_attempt_at datetime not null default current_timestamp,
+	created_at datetime not null default current_timestamp,
+
+	foreign key (community_id) references communities (id)
+);
+
+create index if not exists activitypub_deliveries_next_attempt_at on activitypub_deliveries (next_attempt_at);
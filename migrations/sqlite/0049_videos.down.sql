@@ -0,0 +1,3 @@
+alter table communities drop column disable_video_posts;
+drop table if exists post_videos;
+drop table if exists videos;
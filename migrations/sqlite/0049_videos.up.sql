@@ -0,0 +1,31 @@
+create table if not exists videos (
+	id blob not null,
+	user_id blob not null,
+	status tinyint not null default 0,
+	duration int not null default 0, -- in milliseconds
+	width int not null default 0,
+	height int not null default 0,
+	size int not null default 0,
+	upload_size int not null,
+	has_hls bool not null default false,
+	poster_image blob,
+	error text,
+	created_at datetime not null default current_timestamp,
+	processed_at datetime,
+
+	primary key (id),
+	foreign key (user_id) references users (id),
+	foreign key (poster_image) references images (id)
+);
+
+create table if not exists post_videos (
+	post_id blob not null,
+	video_id blob not null,
+
+	primary key (post_id),
+	unique (video_id),
+	foreign key (post_id) references posts (id),
+	foreign key (video_id) references videos (id)
+);
+
+alter table communities add column disable_video_posts bool not null default false;
@@ -0,0 +1,2 @@
+alter table communities drop column allow_comment_images;
+drop table if exists comment_images;
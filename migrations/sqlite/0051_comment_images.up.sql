@@ -0,0 +1,11 @@
+create table if not exists comment_images (
+	comment_id blob not null,
+	image_id blob not null,
+
+	primary key (comment_id),
+	unique (image_id),
+	foreign key (comment_id) references comments (id),
+	foreign key (image_id) references images (id)
+);
+
+alter table communities add column allow_comment_images bool not null default false;
@@ -0,0 +1,2 @@
+drop table if exists held_comments;
+drop table if exists comment_fingerprints;
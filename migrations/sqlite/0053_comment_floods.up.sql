@@ -0,0 +1,34 @@
+-- Simhashes of the bodies of recent comments, for detecting floods of
+-- duplicate comments.
+create table if not exists comment_fingerprints (
+	comment_id blob not null,
+	user_id blob not null,
+	simhash bigint not null,
+	created_at datetime not null default current_timestamp,
+
+	primary key (comment_id),
+	foreign key (comment_id) references comments (id),
+	foreign key (user_id) references users (id)
+);
+
+create index comment_fingerprints_created_at on comment_fingerprints (created_at);
+
+-- Comments withheld, as likely duplicate floods, until an admin reviews them.
+create table if not exists held_comments (
+	id blob not null,
+	post_id blob not null,
+	parent_id blob,
+	user_id blob not null,
+	user_group tinyint not null default 1,
+	body text not null,
+	image_id blob,
+	simhash bigint not null,
+	reason varchar (255) not null,
+	created_at datetime not null default current_timestamp,
+
+	primary key (id),
+	foreign key (post_id) references posts (id),
+	foreign key (user_id) references users (id)
+);
+
+create index held_comments_created_at on held_comments (created_at);
@@ -0,0 +1,3 @@
+drop index if exists posts_link_domain;
+alter table posts drop column link_domain;
+drop table if exists link_domain_rules;
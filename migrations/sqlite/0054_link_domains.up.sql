@@ -0,0 +1,23 @@
+-- Site-wide (if community_id is null) and per-community rules that block or
+-- allow links to domains matching pattern.
+create table if not exists link_domain_rules (
+	id integer primary key autoincrement,
+	community_id blob,
+	pattern varchar (255) not null,
+	allow bool not null default false,
+	created_by blob not null,
+	created_at datetime not null default current_timestamp,
+
+	unique (community_id, pattern),
+	foreign key (community_id) references communities (id),
+	foreign key (created_by) references users (id)
+);
+
+alter table posts add column link_domain varchar (255);
+create index posts_link_domain on posts (link_domain, created_at);
+
+-- The JSON functions of SQLite are not always compiled in, hence the string
+-- functions (link_info is a JSON object with the hostname in the field h).
+update posts
+set link_domain = lower(substr(link_info, instr(link_info, '"h":"') + 5, instr(substr(link_info, instr(link_info, '"h":"') + 5), '"') - 1))
+where link_info is not null and instr(link_info, '"h":"') > 0;
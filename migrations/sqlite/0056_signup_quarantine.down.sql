@@ -0,0 +1,3 @@
+drop table if exists held_posts;
+drop table if exists quarantined_users;
+drop table if exists signups;
@@ -0,0 +1,48 @@
+-- Recent signups, for checking how many accounts are being created from the
+-- same IP address or network.
+create table if not exists signups (
+	user_id blob not null,
+	ip varchar (45) not null,
+	network varchar (64) not null,
+	created_at datetime not null default current_timestamp,
+
+	primary key (user_id),
+	foreign key (user_id) references users (id)
+);
+
+create index signups_ip on signups (ip, created_at);
+create index signups_network on signups (network, created_at);
+create index signups_created_at on signups (created_at);
+
+-- Suspicious new accounts, whose posts and comments are held for review until
+-- enough of them are approved.
+create table if not exists quarantined_users (
+	user_id blob not null,
+	reason varchar (255) not null,
+	approved int not null default 0,
+	created_at datetime not null default current_timestamp,
+
+	primary key (user_id),
+	foreign key (user_id) references users (id)
+);
+
+-- Posts withheld until an admin reviews them.
+create table if not exists held_posts (
+	id blob not null,
+	type tinyint not null,
+	community_id blob not null,
+	user_id blob not null,
+	title varchar (255) not null,
+	body text,
+	link text,
+	image_id blob,
+	video_id blob,
+	reason varchar (255) not null,
+	created_at datetime not null default current_timestamp,
+
+	primary key (id),
+	foreign key (community_id) references communities (id),
+	foreign key (user_id) references users (id)
+);
+
+create index held_posts_created_at on held_posts (created_at);
@@ -0,0 +1,3 @@
+drop table if exists image_fingerprints;
+
+alter table images drop column phash;
@@ -0,0 +1,18 @@
+-- The perceptual hash (dHash) of each image.
+alter table images add column phash bigint;
+
+-- Perceptual hashes of recently uploaded post and comment images, for
+-- detecting the same image being uploaded by many accounts. The reason is set
+-- if the upload was flagged as such a duplicate.
+create table if not exists image_fingerprints (
+	image_id blob not null,
+	user_id blob not null,
+	phash bigint not null,
+	reason varchar (255),
+	created_at datetime not null default current_timestamp,
+
+	primary key (image_id),
+	foreign key (user_id) references users (id)
+);
+
+create index image_fingerprints_created_at on image_fingerprints (created_at);
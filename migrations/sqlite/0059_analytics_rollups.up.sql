@@ -0,0 +1,13 @@
+-- Hourly counts of coarse analytics events (page views, post views, votes,
+-- signups), per dimension (like the community of a viewed post). No user,
+-- session, or IP address is recorded.
+create table if not exists analytics_rollups (
+	event varchar (32) not null,
+	dimension varchar (128) not null default '',
+	hour datetime not null,
+	total bigint not null default 0,
+
+	primary key (event, hour, dimension)
+);
+
+create index analytics_rollups_hour on analytics_rollups (hour);
@@ -0,0 +1,7 @@
+drop index if exists posts_user_id_created_at;
+
+drop index if exists community_members_created_at;
+
+drop table if exists community_cohorts;
+
+drop table if exists signup_cohorts;
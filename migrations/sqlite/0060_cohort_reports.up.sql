@@ -0,0 +1,33 @@
+-- Signup cohorts (the users who signed up on a day), and how many of them
+-- were activated (posted or commented within 7 days) and retained (seen 7 and
+-- 30 days later). Computed nightly; the counts whose window has not yet closed
+-- are null.
+create table if not exists signup_cohorts (
+	cohort datetime not null,
+	users int not null,
+	activated int,
+	retained_7 int,
+	retained_30 int,
+	computed_at datetime not null,
+
+	primary key (cohort)
+);
+
+-- The same as signup_cohorts, but of the users who joined a community, and
+-- with activation and retention measured by posts and comments in it.
+create table if not exists community_cohorts (
+	community_id blob not null,
+	cohort datetime not null,
+	users int not null,
+	activated int,
+	retained_7 int,
+	retained_30 int,
+	computed_at datetime not null,
+
+	primary key (community_id, cohort),
+	foreign key (community_id) references communities (id) on delete cascade
+);
+
+create index community_members_created_at on community_members (created_at);
+
+create index posts_user_id_created_at on posts (user_id, created_at);
@@ -0,0 +1,18 @@
+-- The top posts, comments, and users (by the points of their posts and
+-- comments) of each period, site-wide (community_id is null) and of each
+-- community. Computed periodically.
+create table if not exists leaderboards (
+	id integer primary key autoincrement,
+	kind varchar (16) not null,
+	period varchar (16) not null,
+	community_id blob,
+	position int not null,
+	item_id blob not null,
+	score int not null,
+	computed_at datetime not null,
+
+	foreign key (community_id) references communities (id) on delete cascade
+);
+
+create index leaderboards_kind_period_community_position on leaderboards (kind, period, community_id, position);
+create index leaderboards_period_computed_at on leaderboards (period, computed_at);
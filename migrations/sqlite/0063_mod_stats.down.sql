@@ -0,0 +1,5 @@
+drop table if exists mod_queue_snapshots;
+
+drop table if exists report_resolutions;
+
+drop table if exists mod_actions;
@@ -0,0 +1,40 @@
+-- The actions of the mods (and admins) in each community, counted in the mod
+-- stats.
+create table if not exists mod_actions (
+	id integer primary key autoincrement,
+	community_id blob not null,
+	user_id blob not null,
+	action varchar (32) not null,
+	created_at datetime not null,
+
+	foreign key (community_id) references communities (id) on delete cascade,
+	foreign key (user_id) references users (id)
+);
+
+create index mod_actions_community_id_created_at on mod_actions (community_id, created_at);
+
+-- When each report was made and when it was resolved (reports are deleted on
+-- resolution), for the time mods take to resolve reports.
+create table if not exists report_resolutions (
+	id integer primary key autoincrement,
+	community_id blob not null,
+	user_id blob not null,
+	upheld boolean not null,
+	reported_at datetime not null,
+	resolved_at datetime not null,
+
+	foreign key (community_id) references communities (id) on delete cascade,
+	foreign key (user_id) references users (id)
+);
+
+create index report_resolutions_community_id_resolved_at on report_resolutions (community_id, resolved_at);
+
+-- Hourly snapshots of the number of open reports of each community with any.
+create table if not exists mod_queue_snapshots (
+	community_id blob not null,
+	hour datetime not null,
+	open_reports int not null,
+
+	primary key (community_id, hour),
+	foreign key (community_id) references communities (id) on delete cascade
+);
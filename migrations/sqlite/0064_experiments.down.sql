@@ -0,0 +1,3 @@
+drop table if exists experiment_exposures;
+
+drop table if exists experiments;
@@ -0,0 +1,2 @@
+drop table if exists community_categories;
+drop table if exists categories;
@@ -0,0 +1,3 @@
+drop table if exists onboarding_steps;
+
+alter table users drop column onboarded_at;
@@ -0,0 +1,12 @@
+-- The onboarding steps (see core.Onboarding) each user has completed.
+create table if not exists onboarding_steps (
+	user_id blob not null,
+	step varchar (32) not null,
+	completed_at datetime not null default current_timestamp,
+
+	primary key (user_id, step),
+	foreign key (user_id) references users (id)
+);
+
+-- When the user completed all of the onboarding steps.
+alter table users add column onboarded_at datetime;
@@ -0,0 +1,3 @@
+-- The reported content as it was when it was reported (a JSON encoded
+-- core.ReportSnapshot), so that later edits don't hide what was reported.
+alter table reports add column snapshot text;
@@ -0,0 +1,31 @@
+-- The jurisdiction (a country code) of each user, by which the retention of
+-- their deleted content may be overridden.
+alter table users add column jurisdiction varchar (8);
+
+-- The titles and bodies of deleted posts and comments, kept until they're
+-- purged (see core.PurgeDeletedContent).
+create table if not exists deleted_content_archive (
+	id integer primary key autoincrement,
+	target_type tinyint not null, -- Either core.ReportTypePost or core.ReportTypeComment.
+	target_id blob not null,
+	author_id blob not null,
+	jurisdiction varchar (8),
+	title text,
+	body text,
+	deleted_at datetime not null
+);
+
+create index deleted_content_archive_target_id on deleted_content_archive (target_id);
+create index deleted_content_archive_deleted_at on deleted_content_archive (deleted_at);
+
+-- The runs of the job that purges deleted content.
+create table if not exists content_purge_runs (
+	id integer primary key autoincrement,
+	started_at datetime not null,
+	finished_at datetime not null,
+	archived_purged int not null default 0,
+	posts_purged int not null default 0,
+	error text
+);
+
+create index content_purge_runs_started_at on content_purge_runs (started_at);
@@ -0,0 +1,20 @@
+-- The blocks (the rules, links, related communities, and markdown) of the
+-- sidebar and about page of each community, in ascending order of z_index.
+create table if not exists community_blocks (
+	id integer primary key autoincrement,
+	community_id blob not null,
+	block_type varchar (16) not null,
+	title varchar (128) not null default '',
+	body text, -- Of markdown blocks.
+	links text, -- Of links blocks, JSON encoded.
+	related text, -- Of related communities blocks, JSON encoded.
+	z_index int not null default 0,
+	created_by blob not null,
+	created_at datetime not null default current_timestamp,
+	edited_at datetime,
+
+	foreign key (community_id) references communities (id),
+	foreign key (created_by) references users (id)
+);
+
+create index community_blocks_community_id on community_blocks (community_id, z_index);
@@ -0,0 +1,2 @@
+drop table if exists similar_communities;
+drop table if exists related_communities;
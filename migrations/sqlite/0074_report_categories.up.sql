@@ -0,0 +1,15 @@
+-- The site-level category of each report reason, and whether the reports of
+-- it go to the admins instead of the mods of the community.
+alter table report_reasons add column category varchar (16) not null default 'rules';
+alter table report_reasons add column to_admins bool not null default false;
+
+update report_reasons set category = 'illegal', to_admins = true where title = 'Copyright violation';
+update report_reasons set category = 'spam' where title = 'Spam';
+update report_reasons set category = 'other' where title = 'Pornography';
+
+insert into report_reasons (title, category) values ('Harassment', 'harassment');
+insert into report_reasons (title, category, to_admins) values ('Illegal content', 'illegal', true);
+insert into report_reasons (title, category) values ('Other', 'other');
+
+alter table reports add column to_admins bool not null default false;
+create index reports_to_admins on reports (to_admins, created_at);
@@ -0,0 +1,19 @@
+-- The passkeys (WebAuthn credentials) of users.
+create table if not exists passkeys (
+	id integer primary key autoincrement,
+	user_id blob not null,
+	credential_id blob not null,
+	public_key blob not null, -- COSE encoded.
+	sign_count int not null default 0,
+	aaguid blob,
+	name varchar (64) not null,
+	backup_eligible bool not null default false,
+	backed_up bool not null default false,
+	created_at datetime not null default current_timestamp,
+	last_used_at datetime,
+
+	unique (credential_id),
+	foreign key (user_id) references users (id)
+);
+
+create index passkeys_user_id on passkeys (user_id);
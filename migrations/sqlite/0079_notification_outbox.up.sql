@@ -0,0 +1,11 @@
+-- The notifications to be created (see core.DeliverNotifications), queued in
+-- the same transactions as the changes they're about.
+create table if not exists notification_outbox (
+	id integer primary key autoincrement,
+	notification text not null,
+	attempts int not null default 0,
+	next_attempt_at datetime not null default current_timestamp,
+	created_at datetime not null default current_timestamp
+);
+
+create index if not exists notification_outbox_next_attempt_at on notification_outbox (next_attempt_at);
@@ -0,0 +1,3 @@
+alter table comments drop column version;
+
+alter table posts drop column version;
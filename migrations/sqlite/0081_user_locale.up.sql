@@ -0,0 +1,3 @@
+-- The locale (like fr or pt-BR) of the text the server produces for the user,
+-- like emails. Empty for that of the user's browser.
+alter table users add column locale varchar (35) not null default '';
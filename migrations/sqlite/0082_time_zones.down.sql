@@ -0,0 +1,3 @@
+alter table communities drop column time_zone;
+
+alter table users drop column time_zone;
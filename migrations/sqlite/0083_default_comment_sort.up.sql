@@ -0,0 +1,3 @@
+-- The order in which the comments of the posts of the community are listed
+-- unless another is asked for (top or new).
+alter table communities add column default_comment_sort varchar (16) not null default 'top';
@@ -0,0 +1,3 @@
+drop index posts_locked_until;
+alter table posts drop column locked_until;
+alter table posts drop column lock_reason;
@@ -0,0 +1,5 @@
+-- Why a post was locked (shown to users), and when it's to be unlocked (by a
+-- background job).
+alter table posts add column lock_reason varchar (255);
+alter table posts add column locked_until datetime;
+create index posts_locked_until on posts (locked_until);
@@ -0,0 +1,16 @@
+-- The quiet hours of users (as 15:04, in their time zones), during which their
+-- push notifications are deferred. Empty if they have none.
+alter table users add column quiet_hours_start varchar (5) not null default '';
+
+alter table users add column quiet_hours_end varchar (5) not null default '';
+
+-- The push notifications deferred until the quiet hours of their users end.
+create table if not exists deferred_push_notifications (
+	notification_id integer not null,
+	send_at datetime not null,
+
+	primary key (notification_id),
+	foreign key (notification_id) references notifications (id) on delete cascade
+);
+
+create index deferred_push_notifications_send_at on deferred_push_notifications (send_at);
@@ -0,0 +1,29 @@
+-- The points of users by community, of their posts and of their comments (the
+-- breakdown of users.points).
+create table if not exists user_points (
+	user_id binary (12) not null,
+	community_id binary (12) not null,
+	post_points int not null default 0,
+	comment_points int not null default 0,
+
+	primary key (user_id, community_id),
+	foreign key (user_id) references users (id) on delete cascade,
+	foreign key (community_id) references communities (id) on delete cascade
+);
+
+-- The points so far (the upvotes of others).
+insert into user_points (user_id, community_id, post_points, comment_points)
+select user_id, community_id, sum(post_points), sum(comment_points) from (
+	select posts.user_id, posts.community_id, count(*) as post_points, 0 as comment_points
+	from post_votes
+	inner join posts on posts.id = post_votes.post_id
+	where post_votes.up = true and post_votes.user_id <> posts.user_id
+	group by posts.user_id, posts.community_id
+	union all
+	select comments.user_id, comments.community_id, 0 as post_points, count(*) as comment_points
+	from comment_votes
+	inner join comments on comments.id = comment_votes.comment_id
+	where comment_votes.up = true and comment_votes.user_id <> comments.user_id
+	group by comments.user_id, comments.community_id
+) as points
+group by user_id, community_id;
@@ -0,0 +1,22 @@
+-- The perks (a badge and more storage) of supporters, one row per payment (to a
+-- payment provider, like Stripe) for them. A user may pay for another's.
+create table if not exists supporter_entitlements (
+	id integer primary key autoincrement,
+	user_id binary (12) not null,
+	gifted_by binary (12),
+	provider varchar (32) not null,
+	payment_id varchar (255) not null,
+	badge varchar (64) not null default '',
+	extra_storage bigint not null default 0,
+	expires_at datetime,
+	ended_at datetime, -- When it expired or was refunded.
+	created_at datetime not null,
+
+	unique (provider, payment_id),
+	foreign key (user_id) references users (id) on delete cascade,
+	foreign key (gifted_by) references users (id) on delete set null
+);
+
+create index supporter_entitlements_user_id on supporter_entitlements (user_id);
+
+create index supporter_entitlements_expires_at on supporter_entitlements (expires_at);
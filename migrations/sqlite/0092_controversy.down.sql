@@ -0,0 +1,9 @@
+drop index if exists comments_post_controversy;
+
+drop index if exists posts_deleted_community_controversy;
+
+drop index if exists posts_deleted_controversy;
+
+alter table comments drop column controversy;
+
+alter table posts drop column controversy;
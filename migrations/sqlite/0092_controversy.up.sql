@@ -0,0 +1,16 @@
+-- The controversy scores of posts and comments (see core.Controversy).
+alter table posts add column controversy int not null default 0;
+
+alter table comments add column controversy int not null default 0;
+
+update posts set controversy = (upvotes + downvotes) * 1000 * min(upvotes, downvotes) / max(upvotes, downvotes)
+where upvotes > 0 and downvotes > 0;
+
+update comments set controversy = (upvotes + downvotes) * 1000 * min(upvotes, downvotes) / max(upvotes, downvotes)
+where upvotes > 0 and downvotes > 0;
+
+create index posts_deleted_controversy on posts (deleted, controversy, id);
+
+create index posts_deleted_community_controversy on posts (deleted, community_id, controversy, id);
+
+create index comments_post_controversy on comments (post_id, controversy, id);
@@ -0,0 +1,20 @@
+-- The destructive mod actions whose side effects (notifications, and the
+-- upholding and purging of reports) are held back until execute_at, before
+-- which they can be undone (see core.PendingModAction).
+create table if not exists pending_mod_actions (
+	id integer primary key autoincrement,
+	community_id binary (12) not null,
+	mod_id binary (12) not null,
+	mod_group tinyint not null, -- In what capacity (as mod or admin) it was taken.
+	action varchar (32) not null, -- One of the core.ModAction constants.
+	target_id binary (12) not null, -- The post, the comment, or the user banned.
+	execute_at datetime not null,
+	created_at datetime not null,
+
+	foreign key (community_id) references communities (id) on delete cascade,
+	foreign key (mod_id) references users (id) on delete cascade
+);
+
+create index pending_mod_actions_community_id on pending_mod_actions (community_id, created_at);
+
+create index pending_mod_actions_execute_at on pending_mod_actions (execute_at);
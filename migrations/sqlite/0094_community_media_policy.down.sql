@@ -0,0 +1,3 @@
+alter table communities drop column disable_gifs;
+
+alter table communities drop column disable_image_posts;
@@ -0,0 +1,5 @@
+-- The license under which the content of posts may be reused (empty for none),
+-- and, for custom licenses, their terms.
+alter table posts add column license varchar(16) not null default '';
+
+alter table posts add column license_text varchar(1024) null;
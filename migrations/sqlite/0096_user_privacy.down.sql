@@ -0,0 +1,7 @@
+alter table users drop column profile_logged_in_only;
+
+alter table users drop column hide_online_status;
+
+alter table users drop column hide_community_memberships;
+
+alter table users drop column hide_vote_history;
@@ -0,0 +1,15 @@
+-- The posts users hid from their feeds.
+create table if not exists hidden_posts (
+	user_id binary (12) not null,
+	post_id binary (12) not null,
+	created_at datetime not null,
+
+	primary key (user_id, post_id),
+	foreign key (user_id) references users (id) on delete cascade,
+	foreign key (post_id) references posts (id) on delete cascade
+);
+
+-- Whether posts are hidden as soon as users vote on them, or report them.
+alter table users add column auto_hide_voted_posts bool not null default false;
+
+alter table users add column auto_hide_reported_posts bool not null default false;
@@ -0,0 +1 @@
+alter table mod_actions drop column target_id;
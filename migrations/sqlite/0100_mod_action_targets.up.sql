@@ -0,0 +1,4 @@
+-- The post, the comment, or the user that a mod action was taken on, for the
+-- actions that can be undone (see pending_mod_actions), so that undoing one
+-- removes its own record from the mod stats.
+alter table mod_actions add column target_id binary (12);
@@ -0,0 +1 @@
+drop index if exists comments_post_id;
@@ -0,0 +1,4 @@
+-- The comments of a post in the new sort order (see Post.fetchComments). The
+-- other sort orders have their indexes, (post_id, upvotes, id) and (post_id,
+-- controversy, id), but 0042_comments_covering_indexes left this one out.
+create index comments_post_id on comments (post_id, id);
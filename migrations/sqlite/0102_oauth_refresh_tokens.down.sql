@@ -0,0 +1,5 @@
+drop index if exists api_tokens_refresh_token_hash;
+
+alter table api_tokens drop column refresh_expires_at;
+
+alter table api_tokens drop column refresh_token_hash;
@@ -0,0 +1,6 @@
+package main
+
+// Plugins (see core.Plugin) are compiled in by importing their packages here,
+// for their init functions to register them. For example:
+//
+//	import _ "example.com/discuit-plugins/automod"
@@ -0,0 +1,13 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// setReusePort fails, for SO_REUSEPORT is not supported on this platform.
+func setReusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("reusePort is not supported on this platform")
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+)
+
+// abuseTrendsSince returns the start of the period of the abuse trends
+// requested by r (the last 30 days by default).
+func abuseTrendsSince(r *request) (time.Time, error) {
+	days := 30
+	if v := r.urlQuery().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 365 {
+			return time.Time{}, httperr.NewBadRequest("invalid_days", "Days must be between 1 and 365.")
+		}
+		days = n
+	}
+	return time.Now().AddDate(0, 0, -days), nil
+}
+
+// /api/_admin/abuse_trends [GET]
+func (s *Server) getAbuseTrends(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	since, err := abuseTrendsSince(r)
+	if err != nil {
+		return err
+	}
+	trends, err := core.GetAbuseTrends(r.ctx, s.db, nil, since)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(trends)
+}
+
+// /api/communities/{communityID}/abuse_trends [GET]
+func (s *Server) getCommunityAbuseTrends(w *responseWriter, r *request) error {
+	comm, err := s.modOrAdminCommunity(r)
+	if err != nil {
+		return err
+	}
+	since, err := abuseTrendsSince(r)
+	if err != nil {
+		return err
+	}
+	trends, err := core.GetAbuseTrends(r.ctx, s.db, &comm.ID, since)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(trends)
+}
@@ -0,0 +1,486 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/activitypub"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// The number of posts listed in the outbox of a community.
+	outboxItemsLimit = 20
+
+	// The maximum size of an activity posted to an inbox.
+	maxInboxBodySize = 256 << 10
+)
+
+// activityPubSite returns the activitypub.Site of s.
+func (s *Server) activityPubSite() activitypub.Site {
+	return activitypub.Site{BaseURL: "https://" + s.config.FederationDomain}
+}
+
+// registerActivityPubRoutes registers the routes of the ActivityPub actors of
+// communities (and of WebFinger, with which remote users find them) on router.
+func (s *Server) registerActivityPubRoutes(router *mux.Router) {
+	router.HandleFunc("/.well-known/webfinger", s.withActivityPub(s.webFinger)).Methods("GET")
+	router.HandleFunc("/ap/c/{communityName}", s.withActivityPub(s.communityActor)).Methods("GET")
+	router.HandleFunc("/ap/c/{communityName}/outbox", s.withActivityPub(s.communityOutbox)).Methods("GET")
+	router.HandleFunc("/ap/c/{communityName}/followers", s.withActivityPub(s.communityFollowers)).Methods("GET")
+	router.HandleFunc("/ap/c/{communityName}/inbox", s.withActivityPub(s.communityInbox)).Methods("POST")
+	router.HandleFunc("/ap/p/{postID}", s.withActivityPub(s.postObject)).Methods("GET")
+}
+
+// An activityPubHandler returns the document to respond with (nil for an empty
+// response with status 202).
+type activityPubHandler func(r *http.Request) (any, error)
+
+func (s *Server) withActivityPub(h activityPubHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v, err := h(r)
+		if err != nil {
+			if httpErr, ok := err.(*httperr.Error); ok && httpErr.HTTPStatus != http.StatusInternalServerError {
+				http.Error(w, httpErr.Message, httpErr.HTTPStatus)
+				return
+			}
+			logger.ErrorContext(r.Context(), "ActivityPub request failed", "url", r.URL.String(), "err", err)
+			http.Error(w, "Internal server error.", http.StatusInternalServerError)
+			return
+		}
+		if v == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			logger.ErrorContext(r.Context(), "Failed to marshal ActivityPub document", "url", r.URL.String(), "err", err)
+			http.Error(w, "Internal server error.", http.StatusInternalServerError)
+			return
+		}
+		contentType := activitypub.ContentType
+		if _, ok := v.(*activitypub.WebFinger); ok {
+			contentType = "application/jrd+json"
+		}
+		w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Write(data)
+	}
+}
+
+// federatedCommunity returns the community named name, if it has an actor.
+// Deleted communities have none.
+func (s *Server) federatedCommunity(ctx context.Context, name string) (*core.Community, error) {
+	comm, err := core.GetCommunityByName(ctx, s.db, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if comm.DeletedAt.Valid {
+		return nil, httperr.NewNotFound("community_not_found", "Community not found.")
+	}
+	return comm, nil
+}
+
+// /.well-known/webfinger [GET]
+//
+// The resource URL query parameter is either of the form
+// acct:communityName@domain, or the ID of a community actor.
+func (s *Server) webFinger(r *http.Request) (any, error) {
+	site := s.activityPubSite()
+	resource := r.URL.Query().Get("resource")
+	var name string
+	if acct, ok := strings.CutPrefix(resource, "acct:"); ok {
+		var domain string
+		name, domain, _ = strings.Cut(strings.TrimPrefix(acct, "!"), "@")
+		if !strings.EqualFold(domain, site.Host()) {
+			return nil, httperr.NewNotFound("not_found", "Resource not found.")
+		}
+	} else if rest, ok := strings.CutPrefix(resource, site.BaseURL+"/ap/c/"); ok {
+		name = rest
+	}
+	if name == "" {
+		return nil, httperr.NewBadRequest("invalid_resource", "Invalid resource.")
+	}
+	comm, err := s.federatedCommunity(r.Context(), name)
+	if err != nil {
+		return nil, err
+	}
+	return &activitypub.WebFinger{
+		Subject: "acct:" + comm.Name + "@" + site.Host(),
+		Aliases: []string{site.CommunityActorID(comm.Name)},
+		Links: []activitypub.WebFingerLink{
+			{Rel: "self", Type: activitypub.ContentType, Href: site.CommunityActorID(comm.Name)},
+			{Rel: "http://webfinger.net/rel/profile-page", Type: "text/html", Href: site.BaseURL + "/" + comm.Name},
+		},
+	}, nil
+}
+
+// /ap/c/{communityName} [GET]
+func (s *Server) communityActor(r *http.Request) (any, error) {
+	comm, err := s.federatedCommunity(r.Context(), mux.Vars(r)["communityName"])
+	if err != nil {
+		return nil, err
+	}
+	key, err := core.GetActivityPubKey(r.Context(), s.db, comm.ID)
+	if err != nil {
+		return nil, err
+	}
+	site := s.activityPubSite()
+	id := site.CommunityActorID(comm.Name)
+	actor := &activitypub.Actor{
+		Context:           activitypub.Context,
+		ID:                id,
+		Type:              "Group",
+		PreferredUsername: comm.Name,
+		Name:              comm.Name,
+		Summary:           htmlParagraphs(comm.About.String),
+		URL:               site.BaseURL + "/" + comm.Name,
+		Inbox:             id + "/inbox",
+		Outbox:            id + "/outbox",
+		Followers:         id + "/followers",
+		PublicKey: &activitypub.PublicKey{
+			ID:           site.CommunityKeyID(comm.Name),
+			Owner:        id,
+			PublicKeyPEM: key.PublicKey,
+		},
+		Published: comm.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if comm.ProPic != nil && comm.ProPic.URL != nil {
+		actor.Icon = &activitypub.Image{Type: "Image", URL: site.BaseURL + *comm.ProPic.URL}
+	}
+	if comm.BannerImage != nil && comm.BannerImage.URL != nil {
+		actor.Image = &activitypub.Image{Type: "Image", URL: site.BaseURL + *comm.BannerImage.URL}
+	}
+	return actor, nil
+}
+
+// /ap/c/{communityName}/outbox [GET]
+//
+// The most recent posts of the community, as Create activities.
+func (s *Server) communityOutbox(r *http.Request) (any, error) {
+	comm, err := s.federatedCommunity(r.Context(), mux.Vars(r)["communityName"])
+	if err != nil {
+		return nil, err
+	}
+	set, err := core.GetFeed(r.Context(), s.db, &core.FeedOptions{
+		Sort:      core.FeedSortLatest,
+		Community: &comm.ID,
+		Limit:     outboxItemsLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	items := []any{}
+	for _, post := range set.Posts {
+		if !post.Deleted {
+			items = append(items, s.createPostActivity(comm, post, false))
+		}
+	}
+	return &activitypub.OrderedCollection{
+		Context:      activitypub.Context,
+		ID:           s.activityPubSite().CommunityActorID(comm.Name) + "/outbox",
+		Type:         "OrderedCollection",
+		TotalItems:   len(items),
+		OrderedItems: items,
+	}, nil
+}
+
+// /ap/c/{communityName}/followers [GET]
+//
+// Only the number of followers is public.
+func (s *Server) communityFollowers(r *http.Request) (any, error) {
+	comm, err := s.federatedCommunity(r.Context(), mux.Vars(r)["communityName"])
+	if err != nil {
+		return nil, err
+	}
+	n, err := core.CountActivityPubFollowers(r.Context(), s.db, comm.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &activitypub.OrderedCollection{
+		Context:    activitypub.Context,
+		ID:         s.activityPubSite().CommunityActorID(comm.Name) + "/followers",
+		Type:       "OrderedCollection",
+		TotalItems: n,
+	}, nil
+}
+
+// /ap/c/{communityName}/inbox [POST]
+//
+// Handles Follow activities (which are accepted right away), the likes,
+// dislikes, shares, and replies of remote actors to the posts of the
+// community (and to the remote replies), and the Undo and Delete of all of
+// them. Other activities are ignored. The request must be signed by the actor
+// of the activity, who must not be blocked.
+func (s *Server) communityInbox(r *http.Request) (any, error) {
+	comm, err := s.federatedCommunity(r.Context(), mux.Vars(r)["communityName"])
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxInboxBodySize+1))
+	if err != nil || len(body) > maxInboxBodySize {
+		return nil, httperr.NewBadRequest("invalid_body", "Request body too large or unreadable.")
+	}
+	activity := &activitypub.IncomingActivity{}
+	if err := json.Unmarshal(body, activity); err != nil || activity.Type == "" || activity.Actor == "" {
+		return nil, httperr.NewBadRequest("invalid_activity", "Invalid activity.")
+	}
+
+	actor, err := s.verifyActivitySignature(r, body, comm, activity)
+	if err != nil {
+		return nil, err
+	}
+	if blocked, err := core.IsActivityPubBlocked(r.Context(), s.db, comm.ID, actor.ID); err != nil {
+		return nil, err
+	} else if blocked {
+		return nil, httperr.NewForbidden("blocked", "You are blocked from this community.")
+	}
+
+	ctx := r.Context()
+	site := s.activityPubSite()
+	communityID := site.CommunityActorID(comm.Name)
+	switch activity.Type {
+	case "Follow":
+		if activity.ObjectID() != communityID {
+			return nil, httperr.NewBadRequest("invalid_object", "Follow of another object.")
+		}
+		if err := core.AddActivityPubFollower(ctx, s.db, comm.ID, actor); err != nil {
+			return nil, err
+		}
+		accept := &activitypub.Activity{
+			Context: activitypub.Context,
+			ID:      communityID + "#accept-" + time.Now().UTC().Format("20060102150405.000000000"),
+			Type:    "Accept",
+			Actor:   communityID,
+			Object:  activity,
+			To:      []string{actor.ID},
+		}
+		err = core.EnqueueActivityTo(ctx, s.db, comm.ID, actor.Inbox, accept)
+	case "Like", "Dislike":
+		err = s.remoteVote(ctx, comm, actor, activity.ObjectID(), activity.Type == "Like")
+	case "Announce":
+		err = s.remoteAnnounce(ctx, comm, actor, activity.ObjectID(), false)
+	case "Create":
+		err = s.remoteReply(ctx, comm, actor, activity)
+	case "Undo":
+		inner := &activitypub.IncomingActivity{}
+		if err := activity.DecodeObject(inner); err != nil {
+			return nil, nil // undo of an activity by ID only; ignored
+		}
+		if inner.Actor != "" && inner.Actor != actor.ID {
+			return nil, httperr.NewForbidden("not_actor", "Undo of another actor's activity.")
+		}
+		switch inner.Type {
+		case "Follow":
+			err = core.RemoveActivityPubFollower(ctx, s.db, comm.ID, actor.ID)
+		case "Like", "Dislike":
+			err = s.undoRemoteVote(ctx, comm, actor, inner.ObjectID())
+		case "Announce":
+			err = s.remoteAnnounce(ctx, comm, actor, inner.ObjectID(), true)
+		}
+	case "Delete":
+		if activity.ObjectID() == actor.ID { // the account is deleted
+			err = core.RemoveActivityPubFollower(ctx, s.db, comm.ID, actor.ID)
+		} else {
+			err = s.deleteRemoteReply(ctx, comm, actor, activity.ObjectID())
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// verifyActivitySignature verifies that the request r, with the body body,
+// which was posted to the inbox of comm, is signed by the actor of activity,
+// and returns the actor. The actor's document is fetched from its ID (not from
+// the key ID, which the sender picks), and the key must be its own.
+func (s *Server) verifyActivitySignature(r *http.Request, body []byte, comm *core.Community, activity *activitypub.IncomingActivity) (*activitypub.Actor, error) {
+	errUnauthorized := &httperr.Error{HTTPStatus: http.StatusUnauthorized, Code: "invalid_signature", Message: "Invalid or missing HTTP signature."}
+	keyID, err := activitypub.SignatureKeyID(r)
+	if err != nil {
+		return nil, errUnauthorized
+	}
+
+	key, err := core.GetActivityPubKey(r.Context(), s.db, comm.ID)
+	if err != nil {
+		return nil, err
+	}
+	private, err := activitypub.ParsePrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	actor, err := activitypub.FetchActor(r.Context(), activity.Actor, s.activityPubSite().CommunityKeyID(comm.Name), private)
+	if err != nil {
+		logger.WarnContext(r.Context(), "Failed to fetch ActivityPub actor", "actor", activity.Actor, "err", err)
+		return nil, errUnauthorized
+	}
+	if err := actor.CheckKey(activity.Actor, keyID); err != nil {
+		logger.WarnContext(r.Context(), "Invalid ActivityPub signature key", "keyId", keyID, "err", err)
+		return nil, errUnauthorized
+	}
+	public, err := activitypub.ParsePublicKey(actor.PublicKey.PublicKeyPEM)
+	if err != nil {
+		return nil, errUnauthorized
+	}
+	if err := activitypub.Verify(r, body, public); err != nil {
+		return nil, errUnauthorized
+	}
+	return actor, nil
+}
+
+// /ap/p/{postID} [GET]
+func (s *Server) postObject(r *http.Request) (any, error) {
+	post, err := core.GetPost(r.Context(), s.db, nil, mux.Vars(r)["postID"], nil, false)
+	if err != nil {
+		return nil, err
+	}
+	comm, err := core.GetCommunityByID(r.Context(), s.db, post.CommunityID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if comm.DeletedAt.Valid {
+		return nil, httperr.NewNotFound("post_not_found", "Post not found.")
+	}
+	obj := s.postToObject(comm, post, true)
+	shares, err := core.CountActivityPubAnnounces(r.Context(), s.db, post.ID)
+	if err != nil {
+		return nil, err
+	}
+	obj.Shares = &activitypub.OrderedCollection{ID: obj.ID + "/shares", Type: "OrderedCollection", TotalItems: shares}
+	return obj, nil
+}
+
+// postToObject returns the ActivityPub object (a Page) of post, which is in
+// comm. The object is attributed to the actor of the community, since users
+// have no actors.
+func (s *Server) postToObject(comm *core.Community, post *core.Post, withContext bool) *activitypub.Object {
+	site := s.activityPubSite()
+	communityID := site.CommunityActorID(comm.Name)
+	obj := &activitypub.Object{
+		ID:           site.PostObjectID(post.PublicID),
+		Type:         "Page",
+		AttributedTo: communityID,
+		Audience:     communityID,
+		Name:         post.Title,
+		MediaType:    "text/html",
+		URL:          site.BaseURL + "/" + comm.Name + "/post/" + post.PublicID,
+		To:           []string{activitypub.Public},
+		CC:           []string{communityID + "/followers"},
+		Published:    post.CreatedAt.UTC().Format(time.RFC3339),
+		Sensitive:    comm.NSFW,
+	}
+	if withContext {
+		obj.Context = activitypub.Context
+	}
+	if post.EditedAt.Valid {
+		obj.Updated = post.EditedAt.Time.UTC().Format(time.RFC3339)
+	}
+
+	byline := "<p>Posted by @" + html.EscapeString(post.AuthorUsername) + ` in <a href="` + html.EscapeString(site.BaseURL+"/"+comm.Name) + `">` +
+		html.EscapeString(comm.Name) + "</a></p>"
+	switch post.Type {
+	case core.PostTypeText:
+		obj.Content = htmlParagraphs(post.Body.String) + byline
+	case core.PostTypeLink:
+		if post.Link != nil {
+			obj.Content = `<p><a href="` + html.EscapeString(post.Link.URL) + `">` + html.EscapeString(post.Link.URL) + "</a></p>" + byline
+			obj.Attachment = []any{map[string]string{"type": "Link", "href": post.Link.URL}}
+		}
+	case core.PostTypeImage:
+		obj.Content = byline
+		if post.Image != nil && post.Image.URL != nil {
+			obj.Image = &activitypub.Image{Type: "Image", URL: site.BaseURL + *post.Image.URL}
+			obj.Attachment = []any{obj.Image}
+		}
+	case core.PostTypeVideo:
+		obj.Content = byline
+		if post.Video != nil && post.Video.URL != "" {
+			obj.Attachment = []any{map[string]string{"type": "Video", "mediaType": "video/mp4", "url": site.BaseURL + post.Video.URL}}
+			if post.Video.Poster != nil {
+				obj.Image = &activitypub.Image{Type: "Image", URL: site.BaseURL + *post.Video.Poster.URL}
+			}
+		}
+	}
+	return obj
+}
+
+// createPostActivity returns the Create activity of post, which is in comm.
+func (s *Server) createPostActivity(comm *core.Community, post *core.Post, withContext bool) *activitypub.Activity {
+	obj := s.postToObject(comm, post, false)
+	a := &activitypub.Activity{
+		ID:        obj.ID + "#create",
+		Type:      "Create",
+		Actor:     obj.AttributedTo,
+		Object:    obj,
+		To:        obj.To,
+		CC:        obj.CC,
+		Published: obj.Published,
+	}
+	if withContext {
+		a.Context = activitypub.Context
+	}
+	return a
+}
+
+// federatePost queues the Create activity of the newly created post for
+// delivery to the remote followers of its community. Errors are only logged.
+func (s *Server) federatePost(ctx context.Context, post *core.Post) {
+	if !s.config.Federation {
+		return
+	}
+	comm, err := core.GetCommunityByID(ctx, s.db, post.CommunityID, nil)
+	if err == nil {
+		_, err = core.EnqueueActivity(ctx, s.db, comm.ID, s.createPostActivity(comm, post, true))
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to federate post", "post", post.PublicID, "err", err)
+	}
+}
+
+// federatePostDeletion queues the Delete activity of the deleted post for
+// delivery to the remote followers of its community. Errors are only logged.
+func (s *Server) federatePostDeletion(ctx context.Context, post *core.Post) {
+	if !s.config.Federation {
+		return
+	}
+	site := s.activityPubSite()
+	comm, err := core.GetCommunityByID(ctx, s.db, post.CommunityID, nil)
+	if err == nil {
+		communityID := site.CommunityActorID(comm.Name)
+		objectID := site.PostObjectID(post.PublicID)
+		_, err = core.EnqueueActivity(ctx, s.db, comm.ID, &activitypub.Activity{
+			Context: activitypub.Context,
+			ID:      objectID + "#delete",
+			Type:    "Delete",
+			Actor:   communityID,
+			Object:  map[string]string{"id": objectID, "type": "Tombstone"},
+			To:      []string{activitypub.Public},
+			CC:      []string{communityID + "/followers"},
+		})
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to federate post deletion", "post", post.PublicID, "err", err)
+	}
+}
+
+// htmlParagraphs returns the plain text s as HTML paragraphs (split at blank
+// lines).
+func htmlParagraphs(s string) string {
+	var b strings.Builder
+	for _, p := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			b.WriteString("<p>")
+			b.WriteString(strings.ReplaceAll(html.EscapeString(p), "\n", "<br>"))
+			b.WriteString("</p>")
+		}
+	}
+	return b.String()
+}
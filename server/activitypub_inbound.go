@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/activitypub"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+var errActivityObjectNotFound = httperr.NewNotFound("object_not_found", "Object not found.")
+
+// activityTarget returns the post, or the (remote) comment, of comm whose
+// object ID is id. Exactly one of the returned post and comment is non-nil.
+func (s *Server) activityTarget(ctx context.Context, comm *core.Community, id string) (*core.Post, *core.Comment, error) {
+	if publicID, ok := strings.CutPrefix(id, s.activityPubSite().BaseURL+"/ap/p/"); ok {
+		post, err := core.GetPost(ctx, s.db, nil, publicID, nil, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if post.CommunityID != comm.ID {
+			return nil, nil, errActivityObjectNotFound
+		}
+		return post, nil, nil
+	}
+	comment, err := core.GetCommentByObjectID(ctx, s.db, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if comment.CommunityID != comm.ID {
+		return nil, nil, errActivityObjectNotFound
+	}
+	return nil, comment, nil
+}
+
+// isAlreadyVoted reports whether err is the error returned when voting twice.
+func isAlreadyVoted(err error) bool {
+	var httpErr *httperr.Error
+	return errors.As(err, &httpErr) && httpErr.Code == "already-voted"
+}
+
+// remoteVote handles a Like (if up is true) or a Dislike, by actor, of the
+// object id.
+func (s *Server) remoteVote(ctx context.Context, comm *core.Community, actor *activitypub.Actor, id string, up bool) error {
+	post, comment, err := s.activityTarget(ctx, comm, id)
+	if err != nil {
+		return err
+	}
+	user, err := core.GetRemoteUser(ctx, s.db, actor)
+	if err != nil {
+		return err
+	}
+	if post != nil {
+		if err = post.Vote(ctx, user.ID, up); isAlreadyVoted(err) {
+			err = post.ChangeVote(ctx, user.ID, up)
+		}
+		return err
+	}
+	if err = comment.Vote(ctx, user.ID, up); isAlreadyVoted(err) {
+		err = comment.ChangeVote(ctx, user.ID, up)
+	}
+	return err
+}
+
+// undoRemoteVote handles the Undo of a Like or a Dislike, by actor, of the
+// object id.
+func (s *Server) undoRemoteVote(ctx context.Context, comm *core.Community, actor *activitypub.Actor, id string) error {
+	post, comment, err := s.activityTarget(ctx, comm, id)
+	if err != nil {
+		return err
+	}
+	user, err := core.GetRemoteUser(ctx, s.db, actor)
+	if err != nil {
+		return err
+	}
+	if post != nil {
+		err = post.DeleteVote(ctx, user.ID)
+	} else {
+		err = comment.DeleteVote(ctx, user.ID)
+	}
+	if err == sql.ErrNoRows {
+		return nil // no vote to undo
+	}
+	return err
+}
+
+// remoteAnnounce handles an Announce (a share), by actor, of the post with the
+// object ID id, or, if undo is true, the Undo of it. Shares of anything other
+// than posts are ignored.
+func (s *Server) remoteAnnounce(ctx context.Context, comm *core.Community, actor *activitypub.Actor, id string, undo bool) error {
+	post, _, err := s.activityTarget(ctx, comm, id)
+	if err != nil || post == nil {
+		return err
+	}
+	if undo {
+		return core.RemoveActivityPubAnnounce(ctx, s.db, post.ID, actor.ID)
+	}
+	return core.AddActivityPubAnnounce(ctx, s.db, post.ID, actor.ID)
+}
+
+// remoteReply handles the Create, by actor, of a Note that's a reply either to
+// a post of comm or to a remote reply. The Note becomes a comment of the
+// actor's shadow user. Other Creates are ignored.
+func (s *Server) remoteReply(ctx context.Context, comm *core.Community, actor *activitypub.Actor, activity *activitypub.IncomingActivity) error {
+	note := &activitypub.Note{}
+	if err := activity.DecodeObject(note); err != nil || note.Type != "Note" || note.InReplyTo == "" {
+		return nil
+	}
+	if note.ID == "" || note.AttributedTo != actor.ID || core.ActorDomain(note.ID) != core.ActorDomain(actor.ID) {
+		return httperr.NewBadRequest("invalid_note", "Invalid note.")
+	}
+	body := activitypub.HTMLToText(note.Content)
+	if body == "" {
+		return httperr.NewBadRequest("empty_note", "Empty note.")
+	}
+
+	post, parent, err := s.activityTarget(ctx, comm, note.InReplyTo)
+	if err != nil {
+		return err
+	}
+	var parentID *uid.ID
+	if parent != nil {
+		parentID = &parent.ID
+		if post, err = core.GetPost(ctx, s.db, &parent.PostID, "", nil, false); err != nil {
+			return err
+		}
+	}
+	user, err := core.GetRemoteUser(ctx, s.db, actor)
+	if err != nil {
+		return err
+	}
+	_, err = core.AddRemoteComment(ctx, s.db, post, user.ID, parentID, note.ID, body)
+	return err
+}
+
+// deleteRemoteReply handles the Delete, by actor, of one of their replies.
+func (s *Server) deleteRemoteReply(ctx context.Context, comm *core.Community, actor *activitypub.Actor, id string) error {
+	comment, err := core.GetCommentByObjectID(ctx, s.db, id)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if comment.CommunityID != comm.ID || comment.Deleted() {
+		return nil
+	}
+	user, err := core.GetRemoteUser(ctx, s.db, actor)
+	if err != nil {
+		return err
+	}
+	return comment.Delete(ctx, user.ID, core.UserGroupNormal)
+}
+
+// isNotFound reports whether err is an httperr.Error with status 404.
+func isNotFound(err error) bool {
+	var httpErr *httperr.Error
+	return errors.As(err, &httpErr) && httpErr.HTTPStatus == http.StatusNotFound
+}
+
+type federationBlockRequest struct {
+	Domain  string `json:"domain"`
+	ActorID string `json:"actorId"`
+}
+
+// /api/communities/{communityID}/federation_blocks [GET, POST]
+//
+// The remote servers and actors blocked from a community (only accessible to
+// mods and admins).
+func (s *Server) handleCommunityFederationBlocks(w *responseWriter, r *request) error {
+	comm, err := s.modOrAdminCommunity(r)
+	if err != nil {
+		return err
+	}
+	return s.handleFederationBlocks(w, r, &comm.ID)
+}
+
+// /api/communities/{communityID}/federation_blocks/{blockID} [DELETE]
+func (s *Server) deleteCommunityFederationBlock(w *responseWriter, r *request) error {
+	comm, err := s.modOrAdminCommunity(r)
+	if err != nil {
+		return err
+	}
+	return s.deleteFederationBlock(w, r, &comm.ID)
+}
+
+// modOrAdminCommunity returns the community of r, after checking that
+// the viewer is a mod of it or an admin.
+func (s *Server) modOrAdminCommunity(r *request) (*core.Community, error) {
+	if !r.loggedIn {
+		return nil, errNotLoggedIn
+	}
+	cid, err := strToID(r.muxVar("communityID"))
+	if err != nil {
+		return nil, err
+	}
+	comm, err := core.GetCommunityByID(r.ctx, s.db, cid, r.viewer)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := userModOrAdmin(r.ctx, s.db, *r.viewer, comm); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errNotAdminNorMod
+	}
+	return comm, nil
+}
+
+// /api/_admin/federation_blocks [GET, POST]
+//
+// The remote servers and actors blocked from the whole site.
+func (s *Server) handleSiteFederationBlocks(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	return s.handleFederationBlocks(w, r, nil)
+}
+
+// /api/_admin/federation_blocks/{blockID} [DELETE]
+func (s *Server) deleteSiteFederationBlock(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	return s.deleteFederationBlock(w, r, nil)
+}
+
+func (s *Server) handleFederationBlocks(w *responseWriter, r *request, community *uid.ID) error {
+	if r.req.Method == "POST" {
+		req := federationBlockRequest{}
+		if err := r.unmarshalJSONBody(&req); err != nil {
+			return err
+		}
+		block, err := core.AddActivityPubBlock(r.ctx, s.db, community, req.Domain, req.ActorID, *r.viewer)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(block)
+	}
+	blocks, err := core.GetActivityPubBlocks(r.ctx, s.db, community)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(blocks)
+}
+
+func (s *Server) deleteFederationBlock(w *responseWriter, r *request, community *uid.ID) error {
+	id, err := strconv.Atoi(r.muxVar("blockID"))
+	if err != nil {
+		return httperr.NewNotFound("block_not_found", "Block not found.")
+	}
+	if err := core.DeleteActivityPubBlock(r.ctx, s.db, community, id); err != nil {
+		return err
+	}
+	return w.writeString(`{"success":true}`)
+}
@@ -1,10 +1,18 @@
 package server
 
 import (
+	"database/sql"
+	"log/slog"
 	"net/http"
+	"strconv"
 
 	"github.com/discuitnet/discuit/core"
 	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/logging"
+	"github.com/discuitnet/discuit/internal/migrations"
+	"github.com/discuitnet/discuit/internal/ratelimits"
+	"github.com/discuitnet/discuit/internal/uid"
 )
 
 // /api/_admin [POST]
@@ -74,3 +82,275 @@ func (s *Server) adminActions(w *responseWriter, r *request) error {
 
 	return w.writeString(`{"success:":true}`)
 }
+
+// requireAdmin returns an error if the user making the request r is not an
+// admin.
+func (s *Server) requireAdmin(r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	admin, err := core.GetUser(r.ctx, s.db, *r.viewer, r.viewer)
+	if err != nil {
+		return err
+	}
+	if !admin.Admin {
+		return httperr.NewForbidden("not_admin", "You are not an admin.")
+	}
+	return nil
+}
+
+// /api/_admin/migrations [GET]
+func (s *Server) getMigrationsStatus(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+
+	status, err := migrations.GetStatus(r.ctx, s.db, migrations.Dir(s.config.DBDriver))
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(status)
+}
+
+// /api/_admin/counter_audits [GET]
+func (s *Server) getCounterAudits(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	limit := 50
+	if v := r.urlQuery().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			return httperr.NewBadRequest("invalid_limit", "Limit must be between 1 and 500.")
+		}
+		limit = n
+	}
+
+	audits, err := core.GetCounterAudits(r.ctx, s.db, limit)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(audits)
+}
+
+// /api/_admin/log_levels [GET, PUT]
+//
+// The body of a PUT request is a map of module names to levels (for example,
+// {"core": "debug"}).
+func (s *Server) handleLogLevels(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+
+	if r.req.Method == "PUT" {
+		reqBody, err := r.unmarshalJSONBodyToStringsMap(true)
+		if err != nil {
+			return err
+		}
+		levels := make(map[string]slog.Level, len(reqBody))
+		for module, text := range reqBody {
+			level, err := logging.ParseLevel(text)
+			if err != nil {
+				return httperr.NewBadRequest("invalid_log_level", "Invalid log level for module "+module+".")
+			}
+			levels[module] = level
+		}
+		for module, level := range levels {
+			logging.SetLevel(module, level)
+			logger.InfoContext(r.ctx, "Log level changed", "target_module", module, "level", level)
+		}
+	}
+
+	return w.writeJSON(logging.Levels())
+}
+
+// /api/_admin/rate_limits [GET, PUT]
+//
+// The body of a PUT request is a map of policy names to policies (for example,
+// {"vote": {"rate": 1000, "interval": 86400, "burst": 30}}). A null policy
+// reverts the policy to its default.
+func (s *Server) handleRateLimits(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+
+	if r.req.Method == "PUT" {
+		reqBody := make(map[string]*ratelimits.Policy)
+		if err := r.unmarshalJSONBody(&reqBody); err != nil {
+			return err
+		}
+		overrides, err := core.GetRateLimitPolicies(r.ctx, s.db)
+		if err != nil {
+			return err
+		}
+		for name, p := range reqBody {
+			if _, ok := defaultRateLimitPolicies[name]; !ok {
+				return httperr.NewBadRequest("invalid_rate_limit", "No rate limit policy named "+name+".")
+			}
+			if p == nil {
+				delete(overrides, name)
+				continue
+			}
+			if err := p.Validate(); err != nil {
+				return httperr.NewBadRequest("invalid_rate_limit", "Invalid rate limit policy "+name+": "+err.Error()+".")
+			}
+			overrides[name] = *p
+		}
+		if err := core.SaveRateLimitPolicies(r.ctx, s.db, overrides); err != nil {
+			return err
+		}
+		s.rateLimitPolicies.invalidate()
+		logger.InfoContext(r.ctx, "Rate limit policies changed", "policies", overrides)
+	}
+
+	policies, err := s.loadRateLimitPolicies(r.ctx)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(policies)
+}
+
+// /api/_admin/quarantined_images [GET]
+func (s *Server) getQuarantinedImages(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	items, err := images.GetQuarantinedImages(r.ctx, s.db, r.urlQueryValue("rejected") == "true")
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(items)
+}
+
+type quarantineReviewRequest struct {
+	Action string `json:"action"` // Either approve or reject.
+}
+
+// /api/_admin/quarantined_images/{imageID} [PUT]
+func (s *Server) reviewQuarantinedImage(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	imageID, err := uid.FromString(r.muxVar("imageID"))
+	if err != nil {
+		return httperr.NewNotFound("image_not_found", "Image not found.")
+	}
+	req := quarantineReviewRequest{}
+	if err := r.unmarshalJSONBody(&req); err != nil {
+		return err
+	}
+	if req.Action != "approve" && req.Action != "reject" {
+		return httperr.NewBadRequest("invalid_action", "Action must be either approve or reject.")
+	}
+	if err := images.ReviewQuarantinedImage(r.ctx, s.db, imageID, req.Action == "approve", *r.viewer); err != nil {
+		if err == images.ErrImageNotFound {
+			return httperr.NewNotFound("image_not_found", "Image not found.")
+		}
+		return err
+	}
+	logger.InfoContext(r.ctx, "Quarantined image reviewed", "image", imageID, "action", req.Action)
+	return w.writeString(`{"success":true}`)
+}
+
+// /api/_admin/quarantined_images/{imageID}/file [GET]
+func (s *Server) getQuarantinedImageFile(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	imageID, err := uid.FromString(r.muxVar("imageID"))
+	if err != nil {
+		return httperr.NewNotFound("image_not_found", "Image not found.")
+	}
+	image, record, err := images.ReadQuarantinedImage(r.ctx, s.db, imageID)
+	if err != nil {
+		if err == images.ErrImageNotFound {
+			return httperr.NewNotFound("image_not_found", "Image not found.")
+		}
+		return err
+	}
+	w.Header().Set("Content-Type", "image/"+string(record.Format))
+	w.Header().Set("Cache-Control", "private, no-store")
+	_, err = w.Write(image)
+	return err
+}
+
+// adminReportsPage is a page of the reports that went to the admins.
+type adminReportsPage struct {
+	Reports []*core.Report `json:"reports"`
+	Limit   int            `json:"limit"`
+	Page    int            `json:"page"`
+}
+
+// /api/_admin/reports [GET]
+func (s *Server) getAdminReports(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	t, byPriority, limit, page, err := s.reportsQuery(r)
+	if err != nil {
+		return err
+	}
+
+	response := adminReportsPage{Reports: []*core.Report{}, Limit: limit, Page: page}
+	reports, err := core.GetAdminReports(r.ctx, s.db, t, byPriority, limit, page)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if reports != nil {
+		response.Reports = reports
+	}
+	return w.writeJSON(response)
+}
+
+// /api/_admin/reports/{reportID} [DELETE]
+func (s *Server) resolveAdminReport(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	reportID, err := strconv.Atoi(r.muxVar("reportID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_report_id", "Invalid report ID.")
+	}
+	report, err := core.GetReport(r.ctx, s.db, reportID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return httperr.NewNotFound("report_not_found", "Report not found.")
+		}
+		return err
+	}
+	if err = report.FetchTarget(r.ctx); err != nil {
+		return err
+	}
+	upheld := r.urlQueryValue("upheld") == "true"
+	if err = report.Resolve(r.ctx, *r.viewer, upheld); err != nil {
+		return err
+	}
+	logger.InfoContext(r.ctx, "Report resolved by admin", "report", report.ID, "upheld", upheld)
+	return w.writeJSON(report)
+}
+
+// /api/_admin/reporters [GET]
+//
+// The reporter score of the user with the username in the query, or, if there
+// is none, the scores of the least reliable reporters.
+func (s *Server) getReporterScores(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	if username := r.urlQueryValue("username"); username != "" {
+		user, err := core.GetUserByUsername(r.ctx, s.db, username, nil)
+		if err != nil {
+			return err
+		}
+		score, err := core.GetReporterScore(r.ctx, s.db, user.ID)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON([]*core.ReporterScore{score})
+	}
+	scores, err := core.GetLeastReliableReporters(r.ctx, s.db, 100)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(scores)
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+)
+
+// analyticsFlushInterval is how often the analytics events counted in memory
+// are written to the database.
+const analyticsFlushInterval = time.Minute
+
+// flushAnalytics writes the counted analytics events to the database every
+// analyticsFlushInterval, and once more when the server is closed.
+func (s *Server) flushAnalytics() {
+	defer close(s.analyticsDone)
+	ticker := time.NewTicker(analyticsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.analyticsStop:
+			if err := s.analytics.Flush(context.Background(), s.db); err != nil {
+				logger.Error("Failed to flush analytics", "err", err)
+			}
+			return
+		}
+		if err := s.analytics.Flush(context.Background(), s.db); err != nil {
+			logger.Error("Failed to flush analytics", "err", err)
+		}
+	}
+}
+
+type analyticsResponse struct {
+	Totals     map[string]int64           `json:"totals"`               // Of each event.
+	Series     []*core.AnalyticsPoint     `json:"series,omitempty"`     // Of the event requested.
+	Dimensions []*core.AnalyticsDimension `json:"dimensions,omitempty"` // Of the event requested.
+}
+
+// /api/_admin/analytics [GET]
+func (s *Server) getAnalytics(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	query := r.urlQuery()
+	days := 7
+	if v := query.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 365 {
+			return httperr.NewBadRequest("invalid_days", "Days must be between 1 and 365.")
+		}
+		days = n
+	}
+	daily := true
+	switch query.Get("interval") {
+	case "", "day":
+	case "hour":
+		daily = false
+	default:
+		return httperr.NewBadRequest("invalid_interval", "Interval must be either hour or day.")
+	}
+
+	to := time.Now()
+	from := to.Add(-time.Duration(days) * 24 * time.Hour)
+	res := analyticsResponse{}
+	var err error
+	if res.Totals, err = core.GetAnalyticsTotals(r.ctx, s.db, from, to); err != nil {
+		return err
+	}
+	if event := query.Get("event"); event != "" {
+		var dimension *string
+		if query.Has("dimension") {
+			v := query.Get("dimension")
+			dimension = &v
+		}
+		if res.Series, err = core.GetAnalyticsSeries(r.ctx, s.db, event, dimension, from, to, daily); err != nil {
+			return err
+		}
+		if res.Dimensions, err = core.GetAnalyticsDimensions(r.ctx, s.db, event, from, to, 50); err != nil {
+			return err
+		}
+	}
+	return w.writeJSON(res)
+}
+
+// /api/_admin/search_queries [GET]
+//
+// The searches of the last days (7 by default), and the most common queries.
+func (s *Server) getSearchAnalytics(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	query := r.urlQuery()
+	days, limit := 7, 50
+	if v := query.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 90 {
+			return httperr.NewBadRequest("invalid_days", "Days must be between 1 and 90.")
+		}
+		days = n
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			return httperr.NewBadRequest("invalid_limit", "Limit must be between 1 and 200.")
+		}
+		limit = n
+	}
+	to := time.Now()
+	res, err := core.GetSearchAnalytics(r.ctx, s.db, to.AddDate(0, 0, -days), to, limit)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(res)
+}
+
+// /api/posts/{postID}/stats [GET]
+//
+// The views, votes, and comments of a post over time, for its author.
+func (s *Server) getPostStats(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	if s.config.AnalyticsOff {
+		return httperr.NewForbidden("analytics_off", "Analytics are turned off.")
+	}
+	post, err := core.GetPost(r.ctx, s.db, nil, r.muxVar("postID"), r.viewer, false)
+	if err != nil {
+		return err
+	}
+	if post.AuthorID != *r.viewer {
+		if err := s.requireAdmin(r); err != nil {
+			return httperr.NewForbidden("not_author", "Only the author of the post can see its stats.")
+		}
+	}
+
+	var daily bool
+	switch r.urlQuery().Get("interval") {
+	case "hour":
+	case "day":
+		daily = true
+	case "":
+		// Hourly for the first few days.
+		daily = time.Since(post.CreatedAt) > 3*24*time.Hour
+	default:
+		return httperr.NewBadRequest("invalid_interval", "Interval must be either hour or day.")
+	}
+	stats, err := core.GetPostStats(r.ctx, s.db, post, daily)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(stats)
+}
+
+// /api/posts/{postID}/participation [GET]
+func (s *Server) getPostParticipation(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	post, err := core.GetPost(r.ctx, s.db, nil, r.muxVar("postID"), r.viewer, true)
+	if err != nil {
+		return err
+	}
+	comm, err := core.GetCommunityByID(r.ctx, s.db, post.CommunityID, r.viewer)
+	if err != nil {
+		return err
+	}
+	if ok, err := userModOrAdmin(r.ctx, s.db, *r.viewer, comm); err != nil {
+		return err
+	} else if !ok {
+		return errNotAdminNorMod
+	}
+	participation, err := core.GetPostParticipation(r.ctx, s.db, post, s.newAccountPolicy())
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(participation)
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func errInsufficientScope(scope core.Scope) error {
+	return httperr.NewForbidden("insufficient_scope", "The API token does not have the "+string(scope)+" scope.")
+}
+
+var errSessionOnly = httperr.NewForbidden("session_only", "This endpoint cannot be accessed with an API token.")
+
+// bearerToken returns the token in the Authorization header of r, if any.
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return strings.TrimSpace(token), ok
+}
+
+// authenticateToken returns the API token bearer of r (to route), looked up
+// with ctx, the context of r with its database deadline (see withDBTimeout).
+func (s *Server) authenticateToken(ctx context.Context, r *http.Request, route *apiRoute, bearer string) (*core.APIToken, error) {
+	token, err := core.AuthenticateAPIToken(ctx, s.db, bearer)
+	if err != nil {
+		return nil, s.dbTimeoutError(r.WithContext(ctx), route, err)
+	}
+	return token, nil
+}
+
+// sessionOnlyRoutes are the API routes (prefixes of path templates) that
+// cannot be accessed with an API token.
+var sessionOnlyRoutes = []string{
+	"/api/_login",
+	"/api/_signup",
+	"/api/_reauth",
+	"/api/_settings",
+	"/api/_linked_accounts",
+	"/api/_admin",
+	"/api/api_tokens",
+	"/api/passkeys",
+	"/api/oauth/",
+	"/api/push_subscriptions",
+}
+
+// modRoutes are the community routes (suffixes of path templates) whose
+// non-GET requests are moderator actions.
+var modRoutes = []string{
+	"/{communityID}",
+	"/rules",
+	"/rules/{ruleID}",
+	"/mods",
+	"/mods/{mod}",
+	"/reports",
+	"/reports/{reportID}",
+	"/banned",
+	"/pro_pic",
+	"/banner_image",
+}
+
+// routeScope returns the scope an API token needs to access the route with
+// the path template path using method. It returns false if the route cannot be
+// accessed with API tokens at all.
+//
+// Some handlers require more scopes depending on the request (for example,
+// the mod scope for deleting a post as a moderator).
+func routeScope(method, path string) (core.Scope, bool) {
+	for _, prefix := range sessionOnlyRoutes {
+		if strings.HasPrefix(path, prefix) {
+			return "", false
+		}
+	}
+	if path == "/api/_postVote" || path == "/api/_commentVote" {
+		return core.ScopeVote, true
+	}
+	if rest, ok := strings.CutPrefix(path, "/api/communities/{communityID}"); ok {
+		if rest == "/reports" || rest == "/reports/{reportID}" || strings.HasPrefix(rest, "/federation_blocks") {
+			return core.ScopeMod, true
+		}
+		if method != "GET" {
+			for _, suffix := range modRoutes {
+				if rest == suffix || "/{communityID}"+rest == suffix {
+					return core.ScopeMod, true
+				}
+			}
+		}
+	}
+	if method == "GET" {
+		return core.ScopeRead, true
+	}
+	return core.ScopeWrite, true
+}
+
+// requireModScope returns an error if the request is authenticated with an
+// API token without the mod scope and the action is performed as someone
+// other than a normal user.
+func (r *request) requireModScope(as core.UserGroup) error {
+	if as == core.UserGroupNormal {
+		return nil
+	}
+	return r.requireScope(core.ScopeMod)
+}
+
+type createAPITokenRequest struct {
+	Name      string `json:"name"`
+	Scopes    string `json:"scopes"`    // space separated
+	ExpiresIn int    `json:"expiresIn"` // in days; 0 for never.
+}
+
+// /api/api_tokens [GET, POST]
+func (s *Server) handleAPITokens(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	if r.req.Method == "POST" {
+		if err := s.requireRecentAuth(r); err != nil {
+			return err
+		}
+		req := createAPITokenRequest{}
+		if err := r.unmarshalJSONBody(&req); err != nil {
+			return err
+		}
+		scopes, err := core.ParseScopes(req.Scopes)
+		if err != nil {
+			return err
+		}
+		if req.ExpiresIn < 0 {
+			return httperr.NewBadRequest("invalid_expires_in", "expiresIn cannot be negative.")
+		}
+		token, err := core.CreatePersonalAccessToken(r.ctx, s.db, *r.viewer, req.Name, scopes, time.Duration(req.ExpiresIn)*24*time.Hour)
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusCreated)
+		return w.writeJSON(token)
+	}
+
+	tokens, err := core.GetAPITokens(r.ctx, s.db, *r.viewer)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(tokens)
+}
+
+// /api/api_tokens/{tokenID} [DELETE]
+func (s *Server) deleteAPIToken(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	id, err := strconv.Atoi(r.muxVar("tokenID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid ID.")
+	}
+	if err := core.DeleteAPIToken(r.ctx, s.db, *r.viewer, id); err != nil {
+		return err
+	}
+	return w.writeJSON(anymap{"success": true})
+}
+
+type createOAuthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirectUris"`
+}
+
+// /api/oauth/clients [GET, POST]
+func (s *Server) handleOAuthClients(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	if r.req.Method == "POST" {
+		req := createOAuthClientRequest{}
+		if err := r.unmarshalJSONBody(&req); err != nil {
+			return err
+		}
+		client, err := core.CreateOAuthClient(r.ctx, s.db, *r.viewer, req.Name, req.RedirectURIs)
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusCreated)
+		return w.writeJSON(client)
+	}
+
+	clients, err := core.GetOAuthClients(r.ctx, s.db, *r.viewer)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(clients)
+}
+
+// /api/oauth/clients/{clientID} [GET, DELETE]
+//
+// Anyone logged in can get a client (for the consent screen). Only the owner
+// can delete it.
+func (s *Server) handleOAuthClient(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	clientID, err := strToID(r.muxVar("clientID"))
+	if err != nil {
+		return err
+	}
+	client, err := core.GetOAuthClient(r.ctx, s.db, clientID)
+	if err != nil {
+		return err
+	}
+
+	if r.req.Method == "DELETE" {
+		if client.UserID != *r.viewer {
+			return httperr.NewForbidden("not_owner", "You do not own the client.")
+		}
+		if err := client.Delete(r.ctx, s.db); err != nil {
+			return err
+		}
+	}
+	return w.writeJSON(client)
+}
+
+type oauthAuthorizeRequest struct {
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// /api/oauth/authorize [POST]
+//
+// Called (by the consent screen) when the logged in user authorizes a client.
+// It returns the URL to redirect the user to, which carries the authorization
+// code.
+func (s *Server) oauthAuthorize(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	req := oauthAuthorizeRequest{}
+	if err := r.unmarshalJSONBody(&req); err != nil {
+		return err
+	}
+	if req.CodeChallenge != "" && req.CodeChallengeMethod != "S256" {
+		return httperr.NewBadRequest("invalid_request", "Only the S256 code challenge method is supported.")
+	}
+	clientID, err := strToID(req.ClientID)
+	if err != nil {
+		return err
+	}
+	client, err := core.GetOAuthClient(r.ctx, s.db, clientID)
+	if err != nil {
+		return err
+	}
+	scopes, err := core.ParseScopes(req.Scope)
+	if err != nil {
+		return err
+	}
+
+	code, err := client.CreateOAuthCode(r.ctx, s.db, *r.viewer, scopes, req.RedirectURI, req.CodeChallenge)
+	if err != nil {
+		return err
+	}
+
+	redirect, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		return err
+	}
+	query := redirect.Query()
+	query.Set("code", code)
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	redirect.RawQuery = query.Encode()
+	return w.writeJSON(anymap{"redirectUrl": redirect.String()})
+}
+
+// /api/oauth/token [POST]
+//
+// The OAuth2 token endpoint. The authorization_code and the refresh_token
+// grant types are supported. Client credentials are accepted either with HTTP
+// Basic authentication or in the (form encoded) body.
+func (s *Server) oauthToken(w *responseWriter, r *request) error {
+	if err := r.req.ParseForm(); err != nil {
+		return httperr.NewBadRequest("invalid_request", "Invalid form body.")
+	}
+	form := r.req.PostForm
+	grantType := form.Get("grant_type")
+	if grantType != "authorization_code" && grantType != "refresh_token" {
+		return httperr.NewBadRequest("unsupported_grant_type", "Unsupported grant type.")
+	}
+
+	errInvalidClient := &httperr.Error{HTTPStatus: http.StatusUnauthorized, Code: "invalid_client", Message: "Invalid client credentials."}
+	clientIDText, secret, ok := r.req.BasicAuth()
+	if !ok {
+		clientIDText, secret = form.Get("client_id"), form.Get("client_secret")
+	}
+	clientID, err := uid.FromString(clientIDText)
+	if err != nil {
+		return errInvalidClient
+	}
+	client, err := core.GetOAuthClient(r.ctx, s.db, clientID)
+	if err != nil {
+		if httpErr, ok := err.(*httperr.Error); ok && httpErr.HTTPStatus == http.StatusNotFound {
+			return errInvalidClient
+		}
+		return err
+	}
+	if !client.ValidSecret(secret) {
+		return errInvalidClient
+	}
+
+	var token *core.APIToken
+	if grantType == "refresh_token" {
+		token, err = client.RefreshOAuthToken(r.ctx, s.db, form.Get("refresh_token"))
+	} else {
+		token, err = client.ExchangeOAuthCode(r.ctx, s.db, form.Get("code"), form.Get("redirect_uri"), form.Get("code_verifier"))
+	}
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return w.writeJSON(anymap{
+		"access_token":  token.Token,
+		"token_type":    "Bearer",
+		"expires_in":    int(time.Until(token.ExpiresAt.Time).Seconds()),
+		"refresh_token": token.RefreshToken,
+		"scope":         token.Scopes.String(),
+	})
+}
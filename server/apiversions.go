@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// apiV1Prefix is the path prefix of version 1 of the API.
+//
+// The unversioned API (under /api) is what the web client uses, and it may
+// change at any time. The versioned API is for third-party clients: once a
+// version is published, routes under it only change in backwards compatible
+// ways. Breaking changes go into a new version.
+const apiV1Prefix = "/api/v1"
+
+// mountAPIV1 registers, under apiV1Prefix, a copy of each of the unversioned
+// API routes registered on r. Responses from the v1 routes, except for
+// streaming ones, are wrapped in an envelope (see withEnvelope). Responses from the unversioned routes, to
+// clients using API tokens, carry deprecation headers (see
+// withLegacyAPIHeaders).
+//
+// This function should be called after all the unversioned API routes are
+// registered on r.
+func (s *Server) mountAPIV1(r *mux.Router) {
+	type route struct {
+		path    string
+		methods []string
+		handler http.Handler
+	}
+	streaming := make(map[string]bool)
+	for _, route := range s.apiRoutes {
+		if route.streaming {
+			streaming[route.path] = true
+		}
+	}
+
+	var routes []route
+	r.Walk(func(rt *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := rt.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(path, "/api/") {
+			return nil
+		}
+		methods, _ := rt.GetMethods()
+		routes = append(routes, route{path: path, methods: methods, handler: rt.GetHandler()})
+		rt.Handler(s.withLegacyAPIHeaders(rt.GetHandler()))
+		return nil
+	})
+
+	v1 := r.PathPrefix(apiV1Prefix).Subrouter()
+	for _, rt := range routes {
+		h := rt.handler
+		if !streaming[rt.path] {
+			h = withEnvelope(h)
+		}
+		route := v1.Handle(strings.TrimPrefix(rt.path, "/api"), h)
+		if len(rt.methods) > 0 {
+			route.Methods(rt.methods...)
+		}
+	}
+	v1.NotFoundHandler = withEnvelope(http.HandlerFunc(s.apiNotFoundHandler))
+	v1.MethodNotAllowedHandler = withEnvelope(http.HandlerFunc(s.apiMethodNotAllowedHandler))
+}
+
+// unversionedPath returns the unversioned equivalent of the API path (or path
+// template) path.
+func unversionedPath(path string) string {
+	if rest, ok := strings.CutPrefix(path, apiV1Prefix); ok && (rest == "" || rest[0] == '/') {
+		return "/api" + rest
+	}
+	return path
+}
+
+// withLegacyAPIHeaders returns a handler that, for requests made with an API
+// token, sets the Deprecation header (and, if configured, the Sunset header)
+// and links to the v1 equivalent of the route in the Link header, before
+// calling h. Requests from the web client are left alone.
+func (s *Server) withLegacyAPIHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := bearerToken(r); ok {
+			header := w.Header()
+			header.Set("Deprecation", "true")
+			if s.config.LegacyAPISunset != "" {
+				if t, err := time.Parse(time.DateOnly, s.config.LegacyAPISunset); err == nil {
+					header.Set("Sunset", t.Format(http.TimeFormat))
+				}
+			}
+			header.Add("Link", "<"+apiV1Prefix+strings.TrimPrefix(r.URL.Path, "/api")+`>; rel="successor-version"`)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// envelope is the body of every response of the versioned API.
+type envelope struct {
+	// The response of the unversioned API on success.
+	Data json.RawMessage `json:"data,omitempty"`
+
+	// An httperr.Error on failure.
+	Error json.RawMessage `json:"error,omitempty"`
+
+	// The pagination cursor, for responses that are a page of a list. To get
+	// the next page, pass it as the cursor URL query parameter. It's null on
+	// the last page.
+	Cursor json.RawMessage `json:"cursor,omitempty"`
+}
+
+// bufferedResponseWriter is an http.ResponseWriter that holds the body of the
+// response in memory.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// withEnvelope returns a handler that calls h, which is an unversioned API
+// handler, and wraps its JSON response in an envelope. The cursor URL query
+// parameter is passed on to h as next, and the next field of paginated
+// responses is moved to the cursor field of the envelope.
+func withEnvelope(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if query := r.URL.Query(); query.Has("cursor") && !query.Has("next") {
+			query.Set("next", query.Get("cursor"))
+			r.URL.RawQuery = query.Encode()
+		}
+
+		bw := &bufferedResponseWriter{ResponseWriter: w}
+		h.ServeHTTP(bw, r)
+		if bw.status == 0 {
+			bw.status = http.StatusOK
+		}
+
+		body, ok := wrapInEnvelope(bw.status, bw.body.Bytes())
+		if !ok {
+			// Not a JSON response; send it as is.
+			body = bw.body.Bytes()
+		}
+		w.WriteHeader(bw.status)
+		w.Write(body)
+	})
+}
+
+// wrapInEnvelope wraps body, the JSON response of the unversioned API with
+// status code status, in an envelope. It returns false if body is not JSON.
+func wrapInEnvelope(status int, body []byte) ([]byte, bool) {
+	var env envelope
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		body = []byte("null")
+	} else if !json.Valid(body) {
+		return nil, false
+	}
+
+	if status >= 400 {
+		env.Error = body
+	} else {
+		env.Data = body
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(body, &fields) == nil {
+			if next, ok := fields["next"]; ok {
+				delete(fields, "next")
+				env.Data, _ = json.Marshal(fields)
+				env.Cursor = next
+			}
+		}
+	}
+
+	b, err := json.Marshal(env)
+	if err != nil {
+		return nil, false
+	}
+	return append(b, '\n'), true
+}
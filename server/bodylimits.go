@@ -0,0 +1,81 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+)
+
+// multipartMemory is the most of a multipart/form-data request body that's
+// kept in memory when it's parsed. Files are streamed to temporary files on
+// disk (which the http package removes once the request is handled), so that
+// concurrent uploads don't exhaust the memory of small instances.
+const multipartMemory = 64 << 10
+
+// maxBodySize returns the maximum size of the request body of route.
+func (s *Server) maxBodySize(route *apiRoute) int64 {
+	if route.maxBodySize > 0 {
+		return route.maxBodySize
+	}
+	return int64(s.config.MaxRequestBodySize)
+}
+
+// errBodyTooLarge returns the error for requests to route whose body is
+// larger than limit bytes.
+func errBodyTooLarge(route *apiRoute, limit int64) error {
+	if route.upload {
+		return httperr.NewRequestTooLarge("file_size_exceeded", fmt.Sprintf("Max file size (%s) exceeded.", formatBytes(limit)))
+	}
+	return httperr.NewRequestTooLarge("request_too_large", fmt.Sprintf("Request body is larger than %s.", formatBytes(limit)))
+}
+
+// withBodyLimit limits the size of the request body of r to that of route. It
+// returns an error if the body is known (from its Content-Length) to be too
+// large; otherwise, reading past the limit fails with an
+// *http.MaxBytesError (which bodyLimitError turns into a 413 error).
+func (s *Server) withBodyLimit(w http.ResponseWriter, r *http.Request, route *apiRoute) error {
+	limit := s.maxBodySize(route)
+	if r.ContentLength > limit {
+		return errBodyTooLarge(route, limit)
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	return nil
+}
+
+// bodyLimitError returns the 413 error for err, if err is (or wraps) the
+// error of reading past the limit of withBodyLimit, or else err.
+func bodyLimitError(err error, route *apiRoute) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return errBodyTooLarge(route, maxBytesErr.Limit)
+	}
+	return err
+}
+
+// parseMultipartForm parses the multipart/form-data request body, streaming
+// its files to disk (see multipartMemory).
+func (r *request) parseMultipartForm() error {
+	if err := r.req.ParseMultipartForm(multipartMemory); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return err
+		}
+		return httperr.NewBadRequest("invalid_multipart", "Invalid multipart/form-data request.")
+	}
+	return nil
+}
+
+// formatBytes formats n bytes (ex: 10 MB).
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%d MB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%d KB", n>>10)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
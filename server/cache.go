@@ -0,0 +1,110 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/cdn"
+	"github.com/discuitnet/discuit/internal/httputil"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// writeCacheable writes the buffered response rw of a cacheable route (see
+// apiRoute.cacheable) to a logged out user, with an ETag, responding with 304
+// Not Modified if the client has the response already.
+//
+// If shared is true, the response may be cached by browsers and CDNs (with
+// the surrogate keys the handler added). Otherwise, it may only be cached by
+// the browser, which must revalidate it each time.
+func (s *Server) writeCacheable(w http.ResponseWriter, r *http.Request, rw *responseWriter, shared bool) {
+	body := rw.buf.Bytes()
+	if rw.status != 0 && rw.status != http.StatusOK {
+		w.WriteHeader(rw.status)
+		w.Write(body)
+		return
+	}
+
+	sum := sha256.Sum256(body)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"` // Weak, since the body may be gzipped.
+
+	header := w.Header()
+	header.Set("ETag", etag)
+	if shared {
+		cc := "public, max-age=" + strconv.Itoa(s.config.PublicCacheMaxAge)
+		if s.config.CDNCacheMaxAge > 0 {
+			cc += ", s-maxage=" + strconv.Itoa(s.config.CDNCacheMaxAge)
+		}
+		header.Set("Cache-Control", cc)
+		header.Add("Vary", "Accept-Encoding, Cookie, Authorization")
+	} else {
+		header.Set("Cache-Control", "private, no-cache")
+		header.Del(cdn.SurrogateKeyHeader)
+	}
+
+	if httputil.ETagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(body)
+}
+
+// Surrogate keys of the API's responses.
+const (
+	surrogateKeyCommunities = "communities" // The list of communities.
+	surrogateKeyCategories  = "categories"  // The list of categories.
+)
+
+func postKey(id uid.ID) string {
+	return cdn.Key("post", id)
+}
+
+func communityKey(id uid.ID) string {
+	return cdn.Key("community", id)
+}
+
+func userKey(id uid.ID) string {
+	return cdn.Key("user", id)
+}
+
+// postsKeys returns the surrogate keys of posts.
+func postsKeys(posts []*core.Post) []string {
+	keys := make([]string, len(posts))
+	for i, post := range posts {
+		keys[i] = postKey(post.ID)
+	}
+	return keys
+}
+
+// purgePost purges the cached responses that include post (or a comment on
+// it) from the CDN.
+func purgePost(r *request, post *core.Post) {
+	cdn.Purge(r.ctx, postKey(post.ID), communityKey(post.CommunityID), userKey(post.AuthorID))
+}
+
+// purgeComment purges the cached responses that include a comment, of
+// author, on post from the CDN.
+func purgeComment(r *request, post, author uid.ID) {
+	cdn.Purge(r.ctx, postKey(post), userKey(author))
+}
+
+// purgeCommentSubtree purges the cached responses that include the comments,
+// of a subtree, on post from the CDN. The profiles of their authors are left
+// to expire.
+func purgeCommentSubtree(r *request, post uid.ID) {
+	cdn.Purge(r.ctx, postKey(post))
+}
+
+// purgeCommunity purges the cached responses that include community from the
+// CDN.
+func purgeCommunity(r *request, community uid.ID) {
+	cdn.Purge(r.ctx, communityKey(community), surrogateKeyCommunities)
+}
+
+// purgeUser purges the cached responses that include the profile of user from
+// the CDN.
+func purgeUser(r *request, user uid.ID) {
+	cdn.Purge(r.ctx, userKey(user))
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/cdn"
+	"github.com/discuitnet/discuit/internal/httperr"
+)
+
+// /api/categories [GET]
+func (s *Server) getCategories(w *responseWriter, r *request) error {
+	categories, err := core.GetCategories(r.ctx, s.db)
+	if err != nil {
+		return err
+	}
+	w.addSurrogateKeys(surrogateKeyCategories)
+	return w.writeJSON(categories)
+}
+
+// /api/_admin/categories [POST]
+func (s *Server) createCategory(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	req := core.Category{}
+	if err := r.unmarshalJSONBody(&req); err != nil {
+		return err
+	}
+	category, err := core.CreateCategory(r.ctx, s.db, &req)
+	if err != nil {
+		return err
+	}
+	logger.InfoContext(r.ctx, "Category created", "category", category.Slug)
+	cdn.Purge(r.ctx, surrogateKeyCategories)
+	return w.writeJSON(category)
+}
+
+// /api/_admin/categories/{categoryID} [PUT, DELETE]
+func (s *Server) handleCategory(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	id, err := strconv.Atoi(r.muxVar("categoryID"))
+	if err != nil {
+		return httperr.NewNotFound("category/not-found", "Category not found.")
+	}
+
+	if r.req.Method == "DELETE" {
+		if err := core.DeleteCategory(r.ctx, s.db, id); err != nil {
+			return err
+		}
+		logger.InfoContext(r.ctx, "Category deleted", "category", id)
+		cdn.Purge(r.ctx, surrogateKeyCategories, surrogateKeyCommunities)
+		return w.writeString(`{"success":true}`)
+	}
+
+	req := core.Category{}
+	if err := r.unmarshalJSONBody(&req); err != nil {
+		return err
+	}
+	req.ID = id
+	category, err := core.UpdateCategory(r.ctx, s.db, &req)
+	if err != nil {
+		return err
+	}
+	logger.InfoContext(r.ctx, "Category updated", "category", category.Slug)
+	cdn.Purge(r.ctx, surrogateKeyCategories, surrogateKeyCommunities)
+	return w.writeJSON(category)
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+)
+
+// cohortsSince returns the start of the period of the cohorts requested by r
+// (the last 90 days by default).
+func cohortsSince(r *request) (time.Time, error) {
+	days := 90
+	if v := r.urlQuery().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 730 {
+			return time.Time{}, httperr.NewBadRequest("invalid_days", "Days must be between 1 and 730.")
+		}
+		days = n
+	}
+	return time.Now().AddDate(0, 0, -days), nil
+}
+
+// /api/_admin/cohorts [GET]
+func (s *Server) getCohorts(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	since, err := cohortsSince(r)
+	if err != nil {
+		return err
+	}
+	cohorts, err := core.GetCohorts(r.ctx, s.db, nil, since)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(cohorts)
+}
+
+// /api/communities/{communityID}/cohorts [GET]
+//
+// The cohorts of the users who joined the community, for its mods.
+func (s *Server) getCommunityCohorts(w *responseWriter, r *request) error {
+	comm, err := s.modOrAdminCommunity(r)
+	if err != nil {
+		return err
+	}
+	since, err := cohortsSince(r)
+	if err != nil {
+		return err
+	}
+	cohorts, err := core.GetCohorts(r.ctx, s.db, &comm.ID, since)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(cohorts)
+}
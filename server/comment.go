@@ -1,6 +1,8 @@
 package server
 
 import (
+	"io"
+	"net/http"
 	"time"
 
 	"github.com/discuitnet/discuit/core"
@@ -10,11 +12,19 @@ import (
 )
 
 // /api/posts/:postID/comments [GET]
+// commentsPage is a page of the comments of a post.
+type commentsPage struct {
+	Comments []*core.Comment  `json:"comments"`
+	Next     msql.NullString  `json:"next"`
+	Sort     core.CommentSort `json:"sort"` // The community's default, unless another was asked for.
+}
+
 func (s *Server) getComments(w *responseWriter, r *request) error {
 	post, err := core.GetPost(r.ctx, s.db, nil, r.muxVar("postID"), r.viewer, true)
 	if err != nil {
 		return err
 	}
+	w.addSurrogateKeys(postKey(post.ID))
 
 	query := r.urlQuery()
 
@@ -29,41 +39,87 @@ func (s *Server) getComments(w *responseWriter, r *request) error {
 		if err != nil {
 			return err
 		}
+		s.markNewComments(r, post.ID, comments)
 		return w.writeJSON(comments)
 	}
 
-	var (
-		nextText   = query.Get("next")
-		nextPoints int
-		nextID     *uid.ID
-	)
-	if nextText != "" {
-		if nextPoints, nextID, err = core.NextPointsIDCursor(nextText); err != nil {
-			return core.ErrInvalidFeedCursor
-		}
-	}
-	var cursor *core.CommentsCursor
-	if nextID != nil {
-		cursor = new(core.CommentsCursor)
-		cursor.Upvotes = nextPoints
-		cursor.NextID = *nextID
+	cursor, err := commentsCursor(query.Get("next"))
+	if err != nil {
+		return err
 	}
 
-	if _, err = post.GetComments(r.ctx, r.viewer, cursor); err != nil {
+	sort := core.CommentSort(query.Get("sort"))
+	if sort != "" && !sort.Valid() {
+		return httperr.NewBadRequest("invalid_sort", "Sort must be either top, new, or controversial.")
+	}
+	if _, err = post.GetComments(r.ctx, r.viewer, sort, cursor); err != nil {
 		return err
 	}
+	s.markNewComments(r, post.ID, post.Comments)
 
-	res := struct {
-		Comments []*core.Comment `json:"comments"`
-		Next     msql.NullString `json:"next"`
-	}{
+	res := commentsPage{
 		Comments: post.Comments,
 		Next:     post.CommentsNext,
+		Sort:     post.CommentsSort,
 	}
 
 	return w.writeJSON(res)
 }
 
+// /api/posts/{postID}/comments/{commentID}/thread [GET]
+func (s *Server) getCommentThread(w *responseWriter, r *request) error {
+	post, err := core.GetPost(r.ctx, s.db, nil, r.muxVar("postID"), r.viewer, true)
+	if err != nil {
+		return err
+	}
+	w.addSurrogateKeys(postKey(post.ID))
+	commentID, err := strToID(r.muxVar("commentID"))
+	if err != nil {
+		return err
+	}
+	cursor, err := commentsCursor(r.urlQueryValue("next"))
+	if err != nil {
+		return err
+	}
+	thread, err := post.GetCommentThread(r.ctx, r.viewer, commentID, cursor)
+	if err != nil {
+		return err
+	}
+	s.markNewComments(r, post.ID, thread.Replies)
+	return w.writeJSON(thread)
+}
+
+// commentsCursor returns the cursor of a page of comments (or of the replies
+// of a comment thread) given in the next query parameter, or nil if next is
+// empty.
+func commentsCursor(next string) (*core.CommentsCursor, error) {
+	if next == "" {
+		return nil, nil
+	}
+	score, id, err := core.NextPointsIDCursor(next)
+	if err != nil || id == nil {
+		return nil, core.ErrInvalidFeedCursor
+	}
+	return &core.CommentsCursor{Score: score, NextID: *id}, nil
+}
+
+// markNewComments records the viewer's visit to post and marks the comments
+// added since the viewer's previous visit (see core.MarkNewComments). Failures
+// are only logged.
+func (s *Server) markNewComments(r *request, post uid.ID, comments []*core.Comment) {
+	if !r.loggedIn {
+		return
+	}
+	since, err := core.VisitPost(r.ctx, s.db, *r.viewer, post)
+	if err != nil {
+		logger.ErrorContext(r.ctx, "Failed to record post visit", "err", err, "post", post)
+		return
+	}
+	if since.Valid {
+		core.MarkNewComments(comments, *r.viewer, since.Time)
+	}
+}
+
 // /api/:commentID [GET]
 func (s *Server) getComment(w *responseWriter, r *request) error {
 	commentID, err := strToID(r.muxVar("commentID"))
@@ -76,26 +132,50 @@ func (s *Server) getComment(w *responseWriter, r *request) error {
 		return err
 	}
 
+	w.addSurrogateKeys(postKey(comment.PostID))
+	w.Header().Add("Vary", "Accept")
+	if r.wantsPlainText() {
+		return w.writePlainText(commentPlainText(comment, nil))
+	}
 	return w.writeJSON(comment)
 }
 
-// /api/posts/:postID/comments [POST]
-func (s *Server) addComment(w *responseWriter, r *request) error {
-	if !r.loggedIn {
-		return errNotLoggedIn
-	}
+// /api/comments/:commentID/translation [GET]
+type commentTranslation struct {
+	core.CommentTranslation
+	BodyHTML string `json:"bodyHtml"`
+}
 
-	if err := s.rateLimit(r, "add_comment_1_"+r.viewer.String(), time.Second*5, 2); err != nil {
+func (s *Server) getCommentTranslation(w *responseWriter, r *request) error {
+	if s.translator == nil {
+		return httperr.NewNotFound("translation-disabled", "Translation is not enabled on this site.")
+	}
+	commentID, err := strToID(r.muxVar("commentID"))
+	if err != nil {
 		return err
 	}
-	if err := s.rateLimit(r, "add_comment_2_"+r.viewer.String(), time.Hour*24, 300); err != nil {
+
+	tr, err := core.TranslateComment(r.ctx, s.db, s.translator, commentID, r.urlQueryValue("lang"))
+	if err != nil {
 		return err
 	}
+	w.addSurrogateKeys(postKey(tr.PostID))
+	return w.writeJSON(commentTranslation{CommentTranslation: *tr, BodyHTML: htmlParagraphs(tr.Body)})
+}
 
-	req := struct {
-		ParentCommentID uid.NullID `json:"parentCommentId"`
-		Body            string     `json:"body"`
-	}{}
+// /api/posts/:postID/comments [POST]
+type addCommentRequest struct {
+	ParentCommentID uid.NullID `json:"parentCommentId"`
+	Body            string     `json:"body"`
+	ImageID         uid.NullID `json:"imageId"` // Of an image uploaded to /api/_uploads/comment_image.
+}
+
+func (s *Server) addComment(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	req := addCommentRequest{}
 	if err := r.unmarshalJSONBody(&req); err != nil {
 		return err
 	}
@@ -118,7 +198,46 @@ func (s *Server) addComment(w *responseWriter, r *request) error {
 		parentID = &req.ParentCommentID.ID
 	}
 
-	comment, err := post.AddComment(r.ctx, *r.viewer, as, parentID, req.Body)
+	var imageID *uid.ID
+	if req.ImageID.Valid {
+		imageID = &req.ImageID.ID
+		if err := s.checkMediaStorageQuota(r, post.CommunityID, *imageID); err != nil {
+			return err
+		}
+	}
+
+	if err := s.checkNewAccountComment(r, post); err != nil {
+		return err
+	}
+
+	approved := false
+	if as == core.UserGroupNormal {
+		if approved, err = core.IsApprovedSubmitter(r.ctx, s.db, post.CommunityID, *r.viewer); err != nil {
+			return err
+		}
+	}
+
+	// Mods and admins often post the same comments, and approved submitters are
+	// trusted by the mods.
+	if as == core.UserGroupNormal && !approved {
+		var held *core.HeldComment
+		if quarantined, err := s.quarantined(r, as); err != nil {
+			return err
+		} else if quarantined {
+			held, err = core.HoldQuarantinedComment(r.ctx, s.db, post, *r.viewer, as, parentID, req.Body, imageID)
+			if err != nil {
+				return err
+			}
+		} else if held, err = s.checkCommentFlood(r, post, as, parentID, req.Body, imageID); err != nil {
+			return err
+		}
+		if held != nil {
+			w.WriteHeader(http.StatusAccepted)
+			return w.writeJSON(held)
+		}
+	}
+
+	comment, err := post.AddComment(r.ctx, *r.viewer, as, parentID, req.Body, imageID)
 	if err != nil {
 		return err
 	}
@@ -126,9 +245,53 @@ func (s *Server) addComment(w *responseWriter, r *request) error {
 	// +1 your own comment.
 	comment.Vote(r.ctx, *r.viewer, true)
 
+	s.analytics.AddPost(post.ID, core.PostStatComments, 1)
+	s.publishComment(r.ctx, comment.ID)
+
 	return w.writeJSON(comment)
 }
 
+// /api/_uploads/comment_image [POST]
+func (s *Server) commentImageUpload(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	if err := s.rateLimit(r, "uploads_1_"+r.viewer.String(), time.Second*2, 1); err != nil {
+		return err
+	}
+	if err := s.rateLimit(r, "uploads_2_"+r.viewer.String(), time.Hour*24, 40); err != nil {
+		return err
+	}
+
+	if err := r.parseMultipartForm(); err != nil {
+		return err
+	}
+
+	file, _, err := r.req.FormFile("image")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileData, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkStorageQuota(r, r.viewer, nil, int64(len(fileData))); err != nil {
+		return err
+	}
+
+	image, err := core.SaveCommentImage(r.ctx, s.db, *r.viewer, fileData)
+	if err != nil {
+		return err
+	}
+	s.checkDuplicateImage(r, image.ID)
+
+	return w.writeJSON(image.Image())
+}
+
 // /api/posts/:postID/comments/:commentID [PUT]
 func (s *Server) updateComment(w *responseWriter, r *request) error {
 	if !r.loggedIn {
@@ -151,10 +314,16 @@ func (s *Server) updateComment(w *responseWriter, r *request) error {
 	query := r.urlQuery()
 	action := query.Get("action")
 	if action == "" {
-		var tcom core.Comment
+		var tcom struct {
+			core.Comment
+			Version *int `json:"version"` // The version edited (optional).
+		}
 		if err := r.unmarshalJSONBody(&tcom); err != nil {
 			return err
 		}
+		if err = checkEditPreconditions(r, tcom.Version, comment.Version, comment.EditedAt, comment.CreatedAt); err != nil {
+			return err
+		}
 		// Override updatable fields.
 		comment.Body = tcom.Body
 		if err = comment.Save(r.ctx, *r.viewer); err != nil {
@@ -167,14 +336,36 @@ func (s *Server) updateComment(w *responseWriter, r *request) error {
 			if err = g.UnmarshalText([]byte(query.Get("userGroup"))); err != nil {
 				return err
 			}
+			if err = r.requireModScope(g); err != nil {
+				return err
+			}
 			if err = comment.ChangeUserGroup(r.ctx, *r.viewer, g); err != nil {
 				return err
 			}
+		case "distinguish", "undistinguish":
+			as := core.UserGroupNormal
+			if action == "distinguish" {
+				if err = as.UnmarshalText([]byte(query.Get("as"))); err != nil {
+					return err
+				}
+			}
+			if err = r.requireScope(core.ScopeMod); err != nil {
+				return err
+			}
+			if err = comment.Distinguish(r.ctx, *r.viewer, as); err != nil {
+				return err
+			}
+			if action == "distinguish" {
+				s.recordModAction(r, comment.CommunityID, core.ModActionDistinguish)
+			} else {
+				s.recordModAction(r, comment.CommunityID, core.ModActionUndistinguish)
+			}
 		default:
 			return httperr.NewBadRequest("unsupported_action", "Unsupported action.")
 		}
 	}
 
+	purgeComment(r, comment.PostID, comment.AuthorID)
 	return w.writeJSON(comment)
 }
 
@@ -204,28 +395,84 @@ func (s *Server) deleteComment(w *responseWriter, r *request) error {
 			return err
 		}
 	}
+	if err = r.requireModScope(deleteAs); err != nil {
+		return err
+	}
 
-	if err := comment.Delete(r.ctx, *r.viewer, deleteAs); err != nil {
+	author := comment.AuthorID // Cleared on deletion.
+	var pending *core.PendingModAction
+	if deleteAs != core.UserGroupNormal && s.modUndoWindow() > 0 {
+		pending, err = comment.DeleteUndoable(r.ctx, *r.viewer, deleteAs, s.modUndoWindow())
+	} else {
+		err = comment.Delete(r.ctx, *r.viewer, deleteAs)
+	}
+	if err != nil {
 		return err
 	}
+	setPendingModAction(w, pending)
+	if deleteAs != core.UserGroupNormal {
+		s.recordModActionOn(r, comment.CommunityID, core.ModActionRemoveComment, comment.ID)
+	}
+	purgeComment(r, comment.PostID, author)
 
 	return w.writeJSON(comment)
 }
 
-// /api/_commentVote [ POST ]
-func (s *Server) commentVote(w *responseWriter, r *request) error {
+// /api/posts/{postID}/comments/{commentID}/subtree [DELETE]
+func (s *Server) deleteCommentSubtree(w *responseWriter, r *request) error {
 	if !r.loggedIn {
 		return errNotLoggedIn
 	}
 
-	if err := s.rateLimitVoting(r, *r.viewer); err != nil {
+	commentID, err := strToID(r.muxVar("commentID"))
+	if err != nil {
+		return err
+	}
+	comment, err := core.GetComment(r.ctx, s.db, commentID, r.viewer)
+	if err != nil {
+		return err
+	}
+
+	deleteAs := core.UserGroupMods
+	if _deleteAs := r.urlQuery().Get("deleteAs"); _deleteAs != "" {
+		if err = deleteAs.UnmarshalText([]byte(_deleteAs)); err != nil {
+			return err
+		}
+	}
+	if deleteAs == core.UserGroupNormal {
+		return httperr.NewBadRequest("invalid_delete_as", "Only mods and admins can delete a comment subtree.")
+	}
+	if err = r.requireModScope(deleteAs); err != nil {
+		return err
+	}
+
+	n, err := comment.DeleteSubtree(r.ctx, *r.viewer, deleteAs)
+	if err != nil {
 		return err
 	}
+	s.recordBulkModAction(r, comment.CommunityID, core.ModActionRemoveSubtree, n)
+	purgeCommentSubtree(r, comment.PostID)
+
+	return w.writeJSON(deleteSubtreeResponse{Comment: comment, Deleted: n})
+}
+
+type deleteSubtreeResponse struct {
+	Comment *core.Comment `json:"comment"`
+	Deleted int           `json:"deleted"` // The number of comments deleted.
+}
+
+// /api/_commentVote [ POST ]
+type commentVoteRequest struct {
+	CommentID uid.ID `json:"commentId"`
+	Up        bool   `json:"up"`
+}
+
+func (s *Server) commentVote(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
 
-	req := struct {
-		CommentID uid.ID `json:"commentId"`
-		Up        bool   `json:"up"`
-	}{Up: true}
+	req := commentVoteRequest{Up: true}
 	if err := r.unmarshalJSONBody(&req); err != nil {
 		return err
 	}
@@ -247,6 +494,7 @@ func (s *Server) commentVote(w *responseWriter, r *request) error {
 	if err != nil {
 		return err
 	}
+	s.analytics.Add(core.AnalyticsVote, "comment")
 
 	return w.writeJSON(comment)
 }
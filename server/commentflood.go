@@ -0,0 +1,121 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func (s *Server) commentFloodPolicy() *core.CommentFloodPolicy {
+	return &core.CommentFloodPolicy{
+		Window:       time.Duration(s.config.CommentFloodWindow) * time.Minute,
+		MaxDistance:  s.config.CommentFloodMaxDistance,
+		UserLimit:    s.config.CommentFloodUserLimit,
+		AccountLimit: s.config.CommentFloodAccountLimit,
+	}
+}
+
+// checkCommentFlood checks whether the comment the logged in user is about to
+// post on post is part of a flood of near-identical comments. If it is, it
+// either returns core.ErrCommentFlood or holds the comment for review and
+// returns the held comment (as per config.CommentFloodAction).
+func (s *Server) checkCommentFlood(r *request, post *core.Post, g core.UserGroup, parent *uid.ID, body string, image *uid.ID) (*core.HeldComment, error) {
+	if s.config.CommentFloodWindow == 0 {
+		return nil, nil
+	}
+	check, err := core.CheckCommentFlood(r.ctx, s.db, *r.viewer, body, s.commentFloodPolicy())
+	if err != nil || !check.Flood() {
+		return nil, err
+	}
+	logger.InfoContext(r.ctx, "Comment flood detected", "post", post.ID, "reason", check.Reason, "action", s.config.CommentFloodAction)
+	if s.config.CommentFloodAction != "hold" {
+		return nil, core.ErrCommentFlood
+	}
+	return core.HoldComment(r.ctx, s.db, post, *r.viewer, g, parent, body, image, check)
+}
+
+// /api/_admin/spam/comment_clusters [GET]
+func (s *Server) getCommentClusters(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	query := r.urlQuery()
+	hours, minSize := 24, 3
+	if v := query.Get("hours"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 24*7 {
+			return httperr.NewBadRequest("invalid_hours", "Hours must be between 1 and 168.")
+		}
+		hours = n
+	}
+	if v := query.Get("minSize"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 {
+			return httperr.NewBadRequest("invalid_min_size", "Minimum size must be a number greater than 1.")
+		}
+		minSize = n
+	}
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	clusters, err := core.GetCommentClusters(r.ctx, s.db, since, s.config.CommentFloodMaxDistance, minSize)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(clusters)
+}
+
+// /api/_admin/spam/held_comments [GET]
+func (s *Server) getHeldComments(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	items, err := core.GetHeldComments(r.ctx, s.db)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(items)
+}
+
+type heldCommentReviewRequest struct {
+	Action string `json:"action"` // Either approve or reject.
+}
+
+// /api/_admin/spam/held_comments/{heldCommentID} [PUT]
+func (s *Server) reviewHeldComment(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	id, err := strToID(r.muxVar("heldCommentID"))
+	if err != nil {
+		return err
+	}
+	req := heldCommentReviewRequest{}
+	if err := r.unmarshalJSONBody(&req); err != nil {
+		return err
+	}
+
+	switch req.Action {
+	case "approve":
+		comment, err := core.ApproveHeldComment(r.ctx, s.db, id)
+		if err != nil {
+			return err
+		}
+		comment.Vote(r.ctx, comment.AuthorID, true)
+		s.publishComment(r.ctx, comment.ID)
+		purgeComment(r, comment.PostID, comment.AuthorID)
+		if err := core.AddQuarantineApproval(r.ctx, s.db, comment.AuthorID, s.config.QuarantineReleaseAfter); err != nil {
+			return err
+		}
+		logger.InfoContext(r.ctx, "Held comment approved", "id", id, "comment", comment.ID)
+		return w.writeJSON(comment)
+	case "reject":
+		if err := core.RejectHeldComment(r.ctx, s.db, id); err != nil {
+			return err
+		}
+		logger.InfoContext(r.ctx, "Held comment rejected", "id", id)
+		return w.writeString(`{"success":true}`)
+	}
+	return httperr.NewBadRequest("invalid_action", "Action must be either approve or reject.")
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/cdn"
 	"github.com/discuitnet/discuit/internal/httperr"
 	msql "github.com/discuitnet/discuit/internal/sql"
 	"github.com/discuitnet/discuit/internal/uid"
@@ -51,6 +52,7 @@ func (s *Server) createCommunity(w *responseWriter, r *request) error {
 	if err != nil {
 		return err
 	}
+	cdn.Purge(r.ctx, surrogateKeyCommunities)
 
 	return w.writeJSON(comm)
 }
@@ -64,6 +66,7 @@ func (s *Server) getCommunities(w *responseWriter, r *request) error {
 	if set == "" {
 		set = core.CommunitiesSetAll
 	}
+	category := query.Get("category") // The slug of a category, to list only the communities in it.
 
 	sort := core.CommunitiesSortDefault
 	__sort := query.Get("sort")
@@ -84,17 +87,21 @@ func (s *Server) getCommunities(w *responseWriter, r *request) error {
 	var comms []*core.Community
 	var err error
 
+	w.addSurrogateKeys(surrogateKeyCommunities)
 	if search != "" { // Search communities.
 		comms, err = core.GetCommunitiesPrefix(r.ctx, s.db, search)
+		if err == nil || httperr.IsNotFound(err) {
+			s.analytics.AddSearch(search, len(comms))
+		}
 	} else {
 		switch set {
 		case core.CommunitiesSetAll, core.CommunitiesSetDefault:
-			comms, err = core.GetCommunities(r.ctx, s.db, sort, set, limit, nil)
+			comms, err = core.GetCommunities(r.ctx, s.db, sort, set, category, limit, nil)
 		case core.CommunitiesSetSubscribed:
 			if !r.loggedIn {
 				return errNotLoggedIn
 			}
-			comms, err = core.GetCommunities(r.ctx, s.db, sort, set, limit, r.viewer)
+			comms, err = core.GetCommunities(r.ctx, s.db, sort, set, category, limit, r.viewer)
 		}
 	}
 	if err != nil {
@@ -149,10 +156,20 @@ func (s *Server) getCommunity(w *responseWriter, r *request) error {
 	if err = comm.FetchRules(r.ctx); err != nil {
 		return err
 	}
+	if err = comm.FetchBlocks(r.ctx); err != nil {
+		return err
+	}
+	if err = comm.FetchRelated(r.ctx); err != nil {
+		return err
+	}
+	if err = comm.FetchLinkDomainRules(r.ctx); err != nil {
+		return err
+	}
 	if _, err = comm.Default(r.ctx); err != nil {
 		return err
 	}
 
+	w.addSurrogateKeys(communityKey(comm.ID))
 	return w.writeJSON(comm)
 }
 
@@ -191,21 +208,71 @@ func (s *Server) updateCommunity(w *responseWriter, r *request) error {
 		return err
 	}
 
-	rcomm := core.Community{}
+	rcomm := struct {
+		core.Community
+		DisableVideoPosts  *bool     `json:"disableVideoPosts"`  // Unchanged if omitted.
+		AllowCommentImages *bool     `json:"allowCommentImages"` // Unchanged if omitted.
+		DisableImagePosts  *bool     `json:"disableImagePosts"`  // Unchanged if omitted.
+		DisableGIFs        *bool     `json:"disableGifs"`        // Unchanged if omitted.
+		TimeZone           *string   `json:"timeZone"`           // Unchanged if omitted.
+		DefaultCommentSort *string   `json:"defaultCommentSort"` // Unchanged if omitted.
+		Categories         *[]string `json:"categories"`         // Unchanged if omitted.
+		RelatedCommunities *[]string `json:"relatedCommunities"` // Names; unchanged if omitted.
+	}{}
 	if err = r.unmarshalJSONBody(&rcomm); err != nil {
 		return err
 	}
 	comm.NSFW = rcomm.NSFW
 	comm.About = rcomm.About
+	if rcomm.DisableVideoPosts != nil {
+		comm.DisableVideoPosts = *rcomm.DisableVideoPosts
+	}
+	if rcomm.AllowCommentImages != nil {
+		comm.AllowCommentImages = *rcomm.AllowCommentImages
+	}
+	if rcomm.DisableImagePosts != nil {
+		comm.DisableImagePosts = *rcomm.DisableImagePosts
+	}
+	if rcomm.DisableGIFs != nil {
+		comm.DisableGIFs = *rcomm.DisableGIFs
+	}
+	if rcomm.TimeZone != nil {
+		comm.TimeZone = *rcomm.TimeZone
+	}
+	if rcomm.DefaultCommentSort != nil {
+		comm.DefaultCommentSort = core.CommentSort(*rcomm.DefaultCommentSort)
+	}
 
+	if rcomm.Categories != nil {
+		if err = comm.SetCategories(r.ctx, *r.viewer, *rcomm.Categories, s.config.MaxCommunityCategories); err != nil {
+			return err
+		}
+		cdn.Purge(r.ctx, surrogateKeyCategories)
+	}
+	if rcomm.RelatedCommunities != nil {
+		if err = comm.SetRelated(r.ctx, *r.viewer, *rcomm.RelatedCommunities); err != nil {
+			return err
+		}
+	} else if err = comm.FetchRelated(r.ctx); err != nil {
+		return err
+	}
 	if err = comm.Update(r.ctx, *r.viewer); err != nil {
 		return err
 	}
+	purgeCommunity(r, comm.ID)
 
+	if err = comm.FetchBlocks(r.ctx); err != nil {
+		return err
+	}
 	return w.writeJSON(comm)
 }
 
 // /api/_joinCommunity [POST]
+type joinCommunityRequest struct {
+	CommunityID uid.ID `json:"communityId"`
+	Leave       bool   `json:"leave"`
+}
+
 func (s *Server) joinCommunity(w *responseWriter, r *request) error {
 	if !r.loggedIn {
 		return errNotLoggedIn
@@ -218,10 +285,7 @@ func (s *Server) joinCommunity(w *responseWriter, r *request) error {
 		return err
 	}
 
-	req := struct {
-		CommunityID uid.ID `json:"communityId"`
-		Leave       bool   `json:"leave"`
-	}{}
+	req := joinCommunityRequest{}
 	if err := r.unmarshalJSONBody(&req); err != nil {
 		return err
 	}
@@ -262,6 +326,7 @@ func (s *Server) getCommunityMods(w *responseWriter, r *request) error {
 	if err != nil {
 		return err
 	}
+	w.addSurrogateKeys(communityKey(comm.ID))
 
 	mods, err := core.GetCommunityMods(r.ctx, s.db, comm.ID)
 	if err != nil {
@@ -308,6 +373,8 @@ func (s *Server) addCommunityMod(w *responseWriter, r *request) error {
 	if err = core.MakeUserMod(r.ctx, s.db, comm, *r.viewer, user.ID, true); err != nil {
 		return err
 	}
+	purgeCommunity(r, comm.ID)
+	purgeUser(r, user.ID)
 
 	mods, err := core.GetCommunityMods(r.ctx, s.db, comm.ID)
 	if err != nil {
@@ -350,6 +417,8 @@ func (s *Server) removeCommunityMod(w *responseWriter, r *request) error {
 	if err = core.MakeUserMod(r.ctx, s.db, comm, *r.viewer, user.ID, false); err != nil {
 		return err
 	}
+	purgeCommunity(r, comm.ID)
+	purgeUser(r, user.ID)
 
 	return w.writeJSON(user)
 }
@@ -369,6 +438,7 @@ func (s *Server) getCommunityRules(w *responseWriter, r *request) error {
 		return err
 	}
 
+	w.addSurrogateKeys(communityKey(comm.ID))
 	if comm.Rules == nil {
 		return w.writeString("[]")
 	}
@@ -399,6 +469,7 @@ func (s *Server) addCommunityRule(w *responseWriter, r *request) error {
 	if err = comm.AddRule(r.ctx, rule.Rule, rule.Description.String, *r.viewer); err != nil {
 		return err
 	}
+	purgeCommunity(r, comm.ID)
 
 	if err = comm.FetchRules(r.ctx); err != nil {
 		return err
@@ -423,6 +494,7 @@ func (s *Server) getCommunityRule(w *responseWriter, r *request) error {
 		return err
 	}
 
+	w.addSurrogateKeys(communityKey(rule.CommunityID))
 	return w.writeJSON(rule)
 }
 
@@ -453,6 +525,7 @@ func (s *Server) updateCommunityRule(w *responseWriter, r *request) error {
 	if err = rule.Update(r.ctx, *r.viewer); err != nil {
 		return err
 	}
+	purgeCommunity(r, rule.CommunityID)
 
 	return w.writeJSON(rule)
 }
@@ -476,10 +549,145 @@ func (s *Server) deleteCommunityRule(w *responseWriter, r *request) error {
 	if err = rule.Delete(r.ctx, *r.viewer); err != nil {
 		return err
 	}
+	purgeCommunity(r, rule.CommunityID)
 
 	return w.writeJSON(rule)
 }
 
+// /api/communities/{communityID}/blocks [GET]
+func (s *Server) getCommunityBlocks(w *responseWriter, r *request) error {
+	cid, err := strToID(r.muxVar("communityID"))
+	if err != nil {
+		return err
+	}
+
+	comm, err := core.GetCommunityByID(r.ctx, s.db, cid, r.viewer)
+	if err != nil {
+		return err
+	}
+	if err = comm.FetchBlocks(r.ctx); err != nil {
+		return err
+	}
+
+	w.addSurrogateKeys(communityKey(comm.ID))
+	return w.writeJSON(comm.Blocks)
+}
+
+// /api/communities/{communityID}/blocks [POST]
+func (s *Server) addCommunityBlock(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	cid, err := strToID(r.muxVar("communityID"))
+	if err != nil {
+		return err
+	}
+
+	comm, err := core.GetCommunityByID(r.ctx, s.db, cid, r.viewer)
+	if err != nil {
+		return err
+	}
+
+	req := &core.CommunityBlock{}
+	if err := r.unmarshalJSONBody(req); err != nil {
+		return err
+	}
+
+	block, err := comm.AddBlock(r.ctx, req, *r.viewer)
+	if err != nil {
+		return err
+	}
+	purgeCommunity(r, comm.ID)
+
+	return w.writeJSON(block)
+}
+
+// getCommunityBlock returns the block of the request's URL.
+func (s *Server) getCommunityBlock(r *request) (*core.CommunityBlock, error) {
+	cid, err := strToID(r.muxVar("communityID"))
+	if err != nil {
+		return nil, err
+	}
+	blockID, err := strconv.Atoi(r.muxVar("blockID"))
+	if err != nil {
+		return nil, httperr.NewNotFound("community-block/not-found", "Community block not found.")
+	}
+	return core.GetCommunityBlock(r.ctx, s.db, cid, blockID)
+}
+
+// /api/communities/{communityID}/blocks/{blockID} [PUT]
+func (s *Server) updateCommunityBlock(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	block, err := s.getCommunityBlock(r)
+	if err != nil {
+		return err
+	}
+
+	req := core.CommunityBlock{}
+	if err := r.unmarshalJSONBody(&req); err != nil {
+		return err
+	}
+	block.Type = req.Type
+	block.Title = req.Title
+	block.Body = req.Body
+	block.Links = req.Links
+	block.Related = req.Related
+	block.ZIndex = req.ZIndex
+
+	if err = block.Update(r.ctx, *r.viewer); err != nil {
+		return err
+	}
+	purgeCommunity(r, block.CommunityID)
+
+	return w.writeJSON(block)
+}
+
+// /api/communities/{communityID}/blocks/{blockID} [DELETE]
+func (s *Server) deleteCommunityBlock(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	block, err := s.getCommunityBlock(r)
+	if err != nil {
+		return err
+	}
+
+	if err = block.Delete(r.ctx, *r.viewer); err != nil {
+		return err
+	}
+	purgeCommunity(r, block.CommunityID)
+
+	return w.writeJSON(block)
+}
+
+// /api/communities/{communityID}/similar [GET]
+func (s *Server) getSimilarCommunities(w *responseWriter, r *request) error {
+	cid, err := strToID(r.muxVar("communityID"))
+	if err != nil {
+		return err
+	}
+
+	limit := 10
+	if l := r.urlQuery().Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > 50 {
+			return httperr.NewBadRequest("invalid_limit", "Invalid limit.")
+		}
+	}
+
+	similar, err := core.GetSimilarCommunities(r.ctx, s.db, cid, limit)
+	if err != nil {
+		return err
+	}
+
+	w.addSurrogateKeys(communityKey(cid))
+	return w.writeJSON(similar)
+}
+
 // /api/_report [POST]
 func (s *Server) report(w *responseWriter, r *request) error {
 	if !r.loggedIn {
@@ -492,6 +700,13 @@ func (s *Server) report(w *responseWriter, r *request) error {
 	if err := s.rateLimit(r, "reporting_2_"+r.viewer.String(), time.Hour*24, 50); err != nil {
 		return err
 	}
+	if score, err := core.GetReporterScore(r.ctx, s.db, *r.viewer); err != nil {
+		return err
+	} else if score.Unreliable {
+		if err := s.takeRateLimit(w, r, rateLimitUnreliableReport); err != nil {
+			return err
+		}
+	}
 
 	inc := struct {
 		Type     core.ReportType `json:"type"`
@@ -519,6 +734,14 @@ func (s *Server) report(w *responseWriter, r *request) error {
 }
 
 // /api/communities/{communityID}/reports [GET]
+// reportsPage is a page of the reports of a community.
+type reportsPage struct {
+	Details core.CommunityReportsDetails `json:"details"`
+	Reports []*core.Report               `json:"reports"`
+	Limit   int                          `json:"limit"`
+	Page    int                          `json:"page"`
+}
+
 func (s *Server) getCommunityReports(w *responseWriter, r *request) error {
 	if !r.loggedIn {
 		return errNotLoggedIn
@@ -541,23 +764,45 @@ func (s *Server) getCommunityReports(w *responseWriter, r *request) error {
 		return errNotAdminNorMod
 	}
 
-	query := r.urlQuery()
+	t, byPriority, limit, page, err := s.reportsQuery(r)
+	if err != nil {
+		return err
+	}
 
-	limit, err := getFeedLimit(query, s.config.PaginationLimit, s.config.PaginationLimitMax)
+	response := reportsPage{Limit: limit, Page: page}
+
+	response.Details, err = core.FetchReportsDetails(r.ctx, s.db, cid)
 	if err != nil {
 		return err
 	}
 
-	page := 1
+	response.Reports, err = core.GetReports(r.ctx, s.db, cid, t, byPriority, limit, page)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	return w.writeJSON(response)
+}
+
+// reportsQuery returns the report type (by filter), the order (by sort), and
+// the page of the reports requested by the query of r.
+func (s *Server) reportsQuery(r *request) (t core.ReportType, byPriority bool, limit, page int, err error) {
+	query := r.urlQuery()
+
+	limit, err = getFeedLimit(query, s.config.PaginationLimit, s.config.PaginationLimitMax)
+	if err != nil {
+		return
+	}
+
+	page = 1
 	if spage := query.Get("page"); spage != "" {
 		if page, err = strconv.Atoi(spage); err != nil {
-			return httperr.NewBadRequest("invalid_page", "Invalid page.")
+			err = httperr.NewBadRequest("invalid_page", "Invalid page.")
+			return
 		}
 	}
 
-	var t core.ReportType
-	filter := query.Get("filter")
-	switch filter {
+	switch query.Get("filter") {
 	case "posts":
 		t = core.ReportTypePost
 	case "comments":
@@ -565,27 +810,19 @@ func (s *Server) getCommunityReports(w *responseWriter, r *request) error {
 	case "all", "":
 		t = core.ReportTypeAll
 	default:
-		return errInvalidFeedFilter
+		err = errInvalidFeedFilter
+		return
 	}
 
-	response := struct {
-		Details core.CommunityReportsDetails `json:"details"`
-		Reports []*core.Report               `json:"reports"`
-		Limit   int                          `json:"limit"`
-		Page    int                          `json:"page"`
-	}{Limit: limit, Page: page}
-
-	response.Details, err = core.FetchReportsDetails(r.ctx, s.db, cid)
-	if err != nil {
-		return err
-	}
-
-	response.Reports, err = core.GetReports(r.ctx, s.db, cid, t, limit, page)
-	if err != nil && err != sql.ErrNoRows {
-		return err
+	byPriority = true
+	switch query.Get("sort") {
+	case "priority", "":
+	case "new":
+		byPriority = false
+	default:
+		err = httperr.NewBadRequest("invalid_sort", "Sort must be either priority or new.")
 	}
-
-	return w.writeJSON(response)
+	return
 }
 
 // /api/communities/{communityID}/reports/{reportID} [DELETE]
@@ -620,12 +857,29 @@ func (s *Server) deleteReport(w *responseWriter, r *request) error {
 	if err != nil {
 		return err
 	}
+	if report.CommunityID != comm.ID {
+		return httperr.NewNotFound("report_not_found", "Report not found.")
+	}
+	if report.ToAdmins {
+		// Only admins may resolve the reports that went to them.
+		if err = s.requireAdmin(r); err != nil {
+			return err
+		}
+	}
 	if err = report.FetchTarget(r.ctx); err != nil {
 		return err
 	}
-	if err = report.Delete(r.ctx, *r.viewer); err != nil {
+	// A report is dismissed, unless the mods took action on it (without
+	// removing the reported post or comment, which upholds the report anyway).
+	upheld := r.urlQueryValue("upheld") == "true"
+	if err = report.Resolve(r.ctx, *r.viewer, upheld); err != nil {
 		return err
 	}
+	if upheld {
+		s.recordModAction(r, comm.ID, core.ModActionUpholdReport)
+	} else {
+		s.recordModAction(r, comm.ID, core.ModActionDismissReport)
+	}
 
 	return w.writeJSON(report)
 }
@@ -694,11 +948,13 @@ func (s *Server) handleCommunityBanned(w *responseWriter, r *request) error {
 			}
 		}
 
+		action := core.ModActionBanUser
 		if r.req.Method == "POST" {
 			err = comm.BanUser(r.ctx, *r.viewer, user.ID, expires)
 		} else {
 			// Unban user.
 			err = comm.UnbanUser(r.ctx, *r.viewer, user.ID)
+			action = core.ModActionUnbanUser
 		}
 		if err != nil {
 			if msql.IsErrDuplicateErr(err) {
@@ -710,6 +966,81 @@ func (s *Server) handleCommunityBanned(w *responseWriter, r *request) error {
 			}
 			return err
 		}
+		if action == core.ModActionBanUser && s.modUndoWindow() > 0 {
+			g := core.UserGroupMods
+			if !comm.ViewerMod.Bool {
+				g = core.UserGroupAdmins
+			}
+			s.queueModAction(w, r, comm.ID, g, action, user.ID)
+		}
+		s.recordModActionOn(r, comm.ID, action, user.ID)
+		return w.writeJSON(user)
+	}
+
+	return httperr.NewBadRequest("", "Unsupported HTTP method.")
+}
+
+// /api/communities/{communityID}/approved_submitters [GET, POST, DELETE]
+func (s *Server) handleCommunityApprovedSubmitters(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	cid, err := strToID(r.muxVar("communityID"))
+	if err != nil {
+		return err
+	}
+
+	comm, err := core.GetCommunityByID(r.ctx, s.db, cid, r.viewer)
+	if err != nil {
+		return err
+	}
+
+	// Only mods and admins have access.
+	if ok, err := userModOrAdmin(r.ctx, s.db, *r.viewer, comm); err != nil {
+		return err
+	} else if !ok {
+		return errNotAdminNorMod
+	}
+
+	if r.req.Method == "GET" {
+		users, err := comm.GetApprovedSubmitters(r.ctx)
+		if err != nil {
+			return err
+		}
+		if users == nil {
+			return w.writeString("[]")
+		}
+		return w.writeJSON(users)
+	}
+
+	if r.req.Method == "POST" || r.req.Method == "DELETE" {
+		values, err := r.unmarshalJSONBodyToStringsMap(true)
+		if err != nil {
+			return err
+		}
+
+		username, ok := values["username"]
+		if !ok {
+			return httperr.NewBadRequest("no_username", "No username.")
+		}
+
+		user, err := core.GetUserByUsername(r.ctx, s.db, username, nil)
+		if err != nil {
+			return err
+		}
+
+		action := core.ModActionAddApprovedSubmitter
+		if r.req.Method == "POST" {
+			err = comm.AddApprovedSubmitter(r.ctx, *r.viewer, user.ID)
+		} else {
+			err = comm.RemoveApprovedSubmitter(r.ctx, *r.viewer, user.ID)
+			action = core.ModActionRemoveApprovedSubmitter
+		}
+		if err != nil {
+			return err
+		}
+		s.recordModAction(r, comm.ID, action)
 		return w.writeJSON(user)
 	}
 
@@ -740,9 +1071,12 @@ func (s *Server) handleCommunityProPic(w *responseWriter, r *request) error {
 	}
 
 	if r.req.Method == "POST" {
-		r.req.Body = http.MaxBytesReader(w, r.req.Body, int64(s.config.MaxImageSize)) // limit max upload size
-		if err := r.req.ParseMultipartForm(int64(s.config.MaxImageSize)); err != nil {
-			return httperr.NewBadRequest("file_size_exceeded", "Max file size exceeded.")
+		if err := r.parseMultipartForm(); err != nil {
+			return err
+		}
+		crop, err := parseCropRect(r)
+		if err != nil {
+			return err
 		}
 
 		file, _, err := r.req.FormFile("image")
@@ -755,7 +1089,10 @@ func (s *Server) handleCommunityProPic(w *responseWriter, r *request) error {
 		if err != nil {
 			return err
 		}
-		if err = comm.UpdateProPic(r.ctx, buf); err != nil {
+		if err := s.checkStorageQuota(r, nil, &comm.ID, int64(len(buf))); err != nil {
+			return err
+		}
+		if err = comm.UpdateProPic(r.ctx, buf, crop); err != nil {
 			return err
 		}
 	} else if r.req.Method == "DELETE" {
@@ -763,6 +1100,7 @@ func (s *Server) handleCommunityProPic(w *responseWriter, r *request) error {
 			return err
 		}
 	}
+	purgeCommunity(r, comm.ID)
 
 	return w.writeJSON(comm)
 }
@@ -791,9 +1129,12 @@ func (s *Server) handleCommunityBannerImage(w *responseWriter, r *request) error
 	}
 
 	if r.req.Method == "POST" {
-		r.req.Body = http.MaxBytesReader(w, r.req.Body, int64(s.config.MaxImageSize)) // limit max upload size
-		if err := r.req.ParseMultipartForm(int64(s.config.MaxImageSize)); err != nil {
-			return httperr.NewBadRequest("file_size_exceeded", "Max file size exceeded.")
+		if err := r.parseMultipartForm(); err != nil {
+			return err
+		}
+		crop, err := parseCropRect(r)
+		if err != nil {
+			return err
 		}
 
 		file, _, err := r.req.FormFile("image")
@@ -806,7 +1147,10 @@ func (s *Server) handleCommunityBannerImage(w *responseWriter, r *request) error
 		if err != nil {
 			return err
 		}
-		if err = comm.UpdateBannerImage(r.ctx, buf); err != nil {
+		if err := s.checkStorageQuota(r, nil, &comm.ID, int64(len(buf))); err != nil {
+			return err
+		}
+		if err = comm.UpdateBannerImage(r.ctx, buf, crop); err != nil {
 			return err
 		}
 	} else if r.req.Method == "DELETE" {
@@ -814,6 +1158,7 @@ func (s *Server) handleCommunityBannerImage(w *responseWriter, r *request) error
 			return err
 		}
 	}
+	purgeCommunity(r, comm.ID)
 
 	return w.writeJSON(comm)
 }
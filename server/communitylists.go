@@ -0,0 +1,42 @@
+package server
+
+import (
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+)
+
+// /api/communities/{communityID}/lists/{list} [GET, POST]
+//
+// Exports a list of the users of a community as CSV, or imports (the request
+// body being a CSV file) users in bulk into the banned users or the approved
+// submitters.
+func (s *Server) handleCommunityList(w *responseWriter, r *request) error {
+	comm, err := s.modOrAdminCommunity(r)
+	if err != nil {
+		return err
+	}
+	list := core.CommunityList(r.muxVar("list"))
+	if !list.Valid() {
+		return httperr.NewNotFound("list_not_found", "List not found.")
+	}
+
+	if r.req.Method == "GET" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+comm.Name+"-"+string(list)+`.csv"`)
+		return comm.ExportList(r.ctx, list, w)
+	}
+
+	dryRun := r.urlQueryValue("dryRun") == "true"
+	result, err := comm.ImportList(r.ctx, *r.viewer, list, r.req.Body, dryRun)
+	if err != nil {
+		return err
+	}
+	if !dryRun && result.Added > 0 {
+		action := core.ModActionBanUser
+		if list == core.CommunityListApprovedSubmitters {
+			action = core.ModActionAddApprovedSubmitter
+		}
+		s.recordBulkModAction(r, comm.ID, action, result.Added)
+	}
+	return w.writeJSON(result)
+}
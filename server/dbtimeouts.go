@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errRequestTimeout is the error of requests whose database queries ran out of
+// time (see withDBTimeout).
+var errRequestTimeout = &httperr.Error{
+	HTTPStatus: http.StatusServiceUnavailable,
+	Code:       "request_timeout",
+	Message:    "The request took too long. Please try again.",
+	RetryAfter: 5,
+}
+
+// dbTimeout returns how long the database queries of a request with method
+// may take in all, or zero if there's no limit.
+func (s *Server) dbTimeout(method string) time.Duration {
+	if method == "GET" || method == "HEAD" {
+		return time.Duration(s.config.DBReadTimeout) * time.Second
+	}
+	return time.Duration(s.config.DBWriteTimeout) * time.Second
+}
+
+// withDBTimeout returns ctx, the context of a request with method to route,
+// with the deadline of its database queries (which, since core functions run
+// their queries with the context they're given, is the deadline of all of its
+// queries), and the function that releases it. Streams and uploads, which may
+// rightly take long, have no deadline.
+func (s *Server) withDBTimeout(ctx context.Context, method string, route *apiRoute) (context.Context, context.CancelFunc) {
+	timeout := s.dbTimeout(method)
+	if timeout == 0 || route.streaming || route.upload {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// dbTimeoutError returns errRequestTimeout, counting the timeout in the
+// analytics, if err is (or wraps) the error of the deadline of r (to route)
+// having passed, or else err.
+func (s *Server) dbTimeoutError(r *http.Request, route *apiRoute, err error) error {
+	ctx := r.Context()
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	s.analytics.Add(core.AnalyticsDBTimeout, r.Method+" "+route.path)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("db.timeout", true))
+	logger.WarnContext(ctx, "Request ran out of database time", "err", err)
+	return errRequestTimeout
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slowDriver is a database/sql driver whose queries don't return until their
+// context is done.
+type slowDriver struct{}
+
+func (slowDriver) Open(name string) (driver.Conn, error) { return slowConn{}, nil }
+
+type slowConn struct{}
+
+func (slowConn) Prepare(query string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (slowConn) Close() error                              { return nil }
+func (slowConn) Begin() (driver.Tx, error)                 { return nil, driver.ErrSkip }
+func (slowConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestAuthenticateTokenTimeout(t *testing.T) {
+	sql.Register("slow", slowDriver{})
+	db, err := sql.Open("slow", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	s := &Server{db: db}
+	r := httptest.NewRequest("GET", "/api/posts", nil)
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := s.authenticateToken(ctx, r, &apiRoute{path: "/api/posts"}, "dct_token"); err != errRequestTimeout {
+		t.Errorf("authenticateToken returned %v (expected errRequestTimeout)", err)
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+)
+
+// /api/_admin/directory_syncs [GET]
+func (s *Server) getDirectorySyncRuns(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	limit := 50
+	if v := r.urlQuery().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			return httperr.NewBadRequest("invalid_limit", "Limit must be between 1 and 500.")
+		}
+		limit = n
+	}
+
+	runs, err := core.GetDirectorySyncRuns(r.ctx, s.db, limit)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(runs)
+}
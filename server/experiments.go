@@ -0,0 +1,85 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
+)
+
+type experimentRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Variants    []string `json:"variants"` // Defaults to ["on"].
+	Rollout     int      `json:"rollout"`
+	Enabled     bool     `json:"enabled"`
+}
+
+// /api/_admin/experiments [GET, POST]
+//
+// Lists the experiments, or creates (or updates) one.
+func (s *Server) handleExperiments(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	if r.req.Method == "POST" {
+		req := experimentRequest{}
+		if err := r.unmarshalJSONBody(&req); err != nil {
+			return err
+		}
+		e := &core.Experiment{
+			Name:        req.Name,
+			Description: msql.NewNullString(msql.NilIfEmptyString(req.Description)),
+			Variants:    req.Variants,
+			Rollout:     req.Rollout,
+			Enabled:     req.Enabled,
+		}
+		if err := core.SaveExperiment(r.ctx, s.db, e); err != nil {
+			return err
+		}
+		logger.InfoContext(r.ctx, "Experiment saved", "name", e.Name, "variants", e.Variants, "rollout", e.Rollout, "enabled", e.Enabled)
+		saved, err := core.GetExperiment(r.ctx, s.db, e.Name)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(saved)
+	}
+	exps, err := core.GetExperiments(r.ctx, s.db)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(exps)
+}
+
+// /api/_admin/experiments/{name} [GET, DELETE]
+//
+// Gets the exposures of an experiment in the last days (30 by default), or
+// deletes it.
+func (s *Server) handleExperiment(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	name := r.muxVar("name")
+	if r.req.Method == "DELETE" {
+		if err := core.DeleteExperiment(r.ctx, s.db, name); err != nil {
+			return err
+		}
+		logger.InfoContext(r.ctx, "Experiment deleted", "name", name)
+		return w.writeString(`{"success":true}`)
+	}
+	days := 30
+	if v := r.urlQuery().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 365 {
+			return httperr.NewBadRequest("invalid_days", "Days must be between 1 and 365.")
+		}
+		days = n
+	}
+	exposures, err := core.GetExperimentExposures(r.ctx, s.db, name, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(exposures)
+}
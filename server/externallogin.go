@@ -0,0 +1,367 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/config"
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/httputil"
+	"github.com/discuitnet/discuit/internal/idp"
+)
+
+// Keys of the session values of external logins (in progress).
+const (
+	sessionExternalLoginProvider = "external_login_provider"
+	sessionExternalLoginState    = "external_login_state"
+	sessionExternalLoginVerifier = "external_login_verifier" // The PKCE code verifier.
+	sessionExternalLoginAt       = "external_login_at"       // Unix time.
+)
+
+// externalLoginTTL is how long users have to log in at an identity provider.
+const externalLoginTTL = 10 * time.Minute
+
+var (
+	errUnknownProvider       = httperr.NewNotFound("login/unknown-provider", "Unknown identity provider.")
+	errExternalLoginState    = httperr.NewBadRequest("login/external-invalid-state", "The login has expired. Try again.")
+	errExternalLoginFailed   = httperr.NewForbidden("login/external-failed", "Logging in with the identity provider failed.")
+	errExternalLoginNoEmail  = httperr.NewForbidden("login/external-no-email", "Your account at the identity provider has no verified email address.")
+	errExternalNoAccount     = httperr.NewForbidden("login/external-no-account", "There's no account linked to yours, and new accounts can't be created.")
+	errExternalUnconfirmed   = httperr.NewForbidden("login/external-email-unconfirmed", "An account with your email address exists. Log in to it to link your account.")
+	errExternalLinkedToOther = httperr.NewForbidden("identity/linked-to-other-user", "The account is linked to another user.")
+	errPasswordLoginDisabled = httperr.NewForbidden("login/password-disabled", "Password login is disabled for this account.")
+)
+
+// newIdentityProviders returns the external identity providers configured in
+// c.
+func newIdentityProviders(c *config.Config) []*idp.Provider {
+	var providers []*idp.Provider
+	if c.GoogleClientID != "" {
+		providers = append(providers, idp.Google(c.GoogleClientID, c.GoogleClientSecret))
+	}
+	if c.GitHubClientID != "" {
+		providers = append(providers, idp.GitHub(c.GitHubClientID, c.GitHubClientSecret))
+	}
+	if c.OIDCClientID != "" {
+		p := idp.OIDC(c.OIDCName, c.OIDCIssuer, c.OIDCClientID, c.OIDCClientSecret)
+		p.GroupsClaim = c.OIDCGroupsClaim
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+// A loginProvider is an external identity provider, as listed in the initial
+// data.
+type loginProvider struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	URL         string `json:"url"` // Where to send users to log in.
+}
+
+func (s *Server) loginProviders() []loginProvider {
+	providers := []loginProvider{}
+	for _, p := range s.identityProviders {
+		providers = append(providers, loginProvider{
+			Name:        p.Name,
+			DisplayName: p.DisplayName,
+			URL:         "/api/_login/external/" + p.Name,
+		})
+	}
+	return providers
+}
+
+func (s *Server) identityProvider(name string) (*idp.Provider, error) {
+	for _, p := range s.identityProviders {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, errUnknownProvider
+}
+
+// externalLoginRedirectURI returns the URL to which provider redirects users
+// back.
+func (s *Server) externalLoginRedirectURI(p *idp.Provider) string {
+	return s.config.ExternalLoginURL + "/api/_login/external/" + p.Name + "/callback"
+}
+
+// /api/_login/external/{provider} [GET]
+//
+// Redirects the user to the identity provider to log in, or (if they're
+// logged in) to link their account at the provider.
+func (s *Server) externalLogin(w *responseWriter, r *request) error {
+	p, err := s.identityProvider(r.muxVar("provider"))
+	if err != nil {
+		return err
+	}
+	state, err := idp.NewState()
+	if err != nil {
+		return err
+	}
+	verifier, err := idp.NewState()
+	if err != nil {
+		return err
+	}
+	authURL, err := p.AuthCodeURL(r.ctx, s.externalLoginRedirectURI(p), state, verifier)
+	if err != nil {
+		return err
+	}
+
+	r.ses.Values[sessionExternalLoginProvider] = p.Name
+	r.ses.Values[sessionExternalLoginState] = state
+	r.ses.Values[sessionExternalLoginVerifier] = verifier
+	r.ses.Values[sessionExternalLoginAt] = time.Now().Unix()
+	if err := r.ses.Save(w, r.req); err != nil {
+		return err
+	}
+	http.Redirect(w, r.req, authURL, http.StatusFound)
+	return nil
+}
+
+// /api/_login/external/{provider}/callback [GET]
+//
+// Completes the external login started by externalLogin, and redirects the
+// user to the home page (or, if they linked an account, to the settings
+// page). Errors are passed to the login page (or the settings page), in the
+// error query parameter.
+func (s *Server) externalLoginCallback(w *responseWriter, r *request) error {
+	redirect, err := s.completeExternalLogin(w, r)
+	if err != nil {
+		var httpErr *httperr.Error
+		if !errors.As(err, &httpErr) {
+			return err
+		}
+		redirect = "/login"
+		if r.loggedIn {
+			redirect = "/settings"
+		}
+		redirect += "?error=" + url.QueryEscape(httpErr.Code)
+	}
+	http.Redirect(w, r.req, redirect, http.StatusFound)
+	return nil
+}
+
+// completeExternalLogin completes the external login of r, and returns where
+// to redirect the user.
+func (s *Server) completeExternalLogin(w *responseWriter, r *request) (string, error) {
+	p, err := s.identityProvider(r.muxVar("provider"))
+	if err != nil {
+		return "", err
+	}
+
+	name, _ := r.ses.Values[sessionExternalLoginProvider].(string)
+	state, _ := r.ses.Values[sessionExternalLoginState].(string)
+	verifier, _ := r.ses.Values[sessionExternalLoginVerifier].(string)
+	at, ok := sessionInt64(r.ses, sessionExternalLoginAt)
+	delete(r.ses.Values, sessionExternalLoginProvider)
+	delete(r.ses.Values, sessionExternalLoginState)
+	delete(r.ses.Values, sessionExternalLoginVerifier)
+	delete(r.ses.Values, sessionExternalLoginAt)
+	if err := r.ses.Save(w, r.req); err != nil {
+		return "", err
+	}
+	if !ok || name != p.Name || state == "" || time.Since(time.Unix(at, 0)) > externalLoginTTL ||
+		subtle.ConstantTimeCompare([]byte(state), []byte(r.urlQueryValue("state"))) != 1 {
+		return "", errExternalLoginState
+	}
+	if code := r.urlQueryValue("error"); code != "" {
+		logger.InfoContext(r.ctx, "External login refused by the provider", "provider", p.Name, "error", code)
+		return "", errExternalLoginFailed
+	}
+
+	ip := httputil.GetIP(r.req)
+	if err := s.rateLimit(r, "login_external_"+ip, time.Hour, 20); err != nil {
+		return "", err
+	}
+
+	token, err := p.Exchange(r.ctx, s.externalLoginRedirectURI(p), r.urlQueryValue("code"), verifier)
+	if err != nil {
+		logger.WarnContext(r.ctx, "External login failed", "provider", p.Name, "err", err)
+		return "", errExternalLoginFailed
+	}
+	id, err := p.Identity(r.ctx, token)
+	if err != nil {
+		if err == idp.ErrNoEmail {
+			return "", errExternalLoginNoEmail
+		}
+		logger.WarnContext(r.ctx, "External login failed", "provider", p.Name, "err", err)
+		return "", errExternalLoginFailed
+	}
+	email := ""
+	if id.EmailVerified {
+		email = id.Email
+	}
+
+	identity, err := core.GetExternalIdentity(r.ctx, s.db, p.Name, id.Subject)
+	if err != nil && !httperr.IsNotFound(err) {
+		return "", err
+	}
+	linked := err == nil
+
+	if r.loggedIn {
+		// Link the account to the logged in user (or, if it's already linked,
+		// count the login as a reauthentication).
+		if linked {
+			if identity.UserID != *r.viewer {
+				return "", errExternalLinkedToOther
+			}
+			if err := identity.RecordUse(r.ctx, s.db, email); err != nil {
+				return "", err
+			}
+		} else {
+			if err := s.requireRecentAuth(r); err != nil {
+				return "", err
+			}
+			if _, err := core.LinkExternalIdentity(r.ctx, s.db, *r.viewer, p.Name, id.Subject, email); err != nil {
+				return "", err
+			}
+		}
+		r.ses.Values[sessionAuthAt] = time.Now().Unix()
+		if err := r.ses.Save(w, r.req); err != nil {
+			return "", err
+		}
+		return "/settings", nil
+	}
+
+	var user *core.User
+	if linked {
+		if err := identity.RecordUse(r.ctx, s.db, email); err != nil {
+			return "", err
+		}
+		if user, err = core.GetUser(r.ctx, s.db, identity.UserID, nil); err != nil {
+			return "", err
+		}
+	} else if user, err = s.externalLoginUser(r, p, id, ip); err != nil {
+		return "", err
+	}
+	if err := s.requirePasskeyLogin(r, user); err != nil {
+		return "", err
+	}
+	if p.GroupsClaim != "" {
+		if _, _, err := core.SyncUserGroups(r.ctx, s.db, user.ID, p.Name, id.Groups, s.config.DirectoryGroupCommunities); err != nil {
+			return "", err
+		}
+	}
+
+	if err := s.loginUser(user, r.ses, w, r.req); err != nil {
+		return "", err
+	}
+	s.recordLogin(r, user, ip, s.loginDevice(r, ip))
+	return "/", nil
+}
+
+// externalLoginUser returns the user to log in with the account id at p, which
+// isn't linked to a user: the user with the same (verified) email address, to
+// which the account is linked, or else a new user (as per the provisioning
+// policy of the site, config.ExternalLoginSignups).
+func (s *Server) externalLoginUser(r *request, p *idp.Provider, id *idp.Identity, ip string) (*core.User, error) {
+	if id.EmailVerified {
+		user, err := core.GetUserByEmail(r.ctx, s.db, id.Email, nil)
+		if err == nil {
+			// Unconfirmed email addresses may not be the user's (and linking
+			// would let whoever signed up with it take over the account).
+			if !user.EmailConfirmedAt.Valid {
+				return nil, errExternalUnconfirmed
+			}
+			if _, err := core.LinkExternalIdentity(r.ctx, s.db, user.ID, p.Name, id.Subject, id.Email); err != nil {
+				return nil, err
+			}
+			return user, nil
+		}
+		if !httperr.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	if s.config.ExternalLoginSignups == "closed" {
+		return nil, errExternalNoAccount
+	}
+	if len(s.config.ExternalLoginSignupDomains) > 0 && !(id.EmailVerified && emailAtDomains(id.Email, s.config.ExternalLoginSignupDomains)) {
+		return nil, errExternalNoAccount
+	}
+	network := s.signupNetwork(r, ip)
+	quarantine, err := s.checkSignup(r, map[string]string{"email": id.Email}, ip, network)
+	if err != nil {
+		return nil, err
+	}
+
+	preferred := id.Username
+	if preferred == "" {
+		preferred, _, _ = strings.Cut(id.Email, "@")
+	}
+	user, err := core.RegisterExternalUser(r.ctx, s.db, p.Name, id.Subject, preferred, id.Email, id.EmailVerified)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.recordSignup(r, user, ip, network, quarantine); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// /api/_settings/identities [GET]
+func (s *Server) getExternalIdentities(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	identities, err := core.GetExternalIdentities(r.ctx, s.db, *r.viewer)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(identities)
+}
+
+// /api/_settings/identities/{identityID} [DELETE]
+func (s *Server) unlinkExternalIdentity(w *responseWriter, r *request) error {
+	if err := s.requireRecentAuth(r); err != nil {
+		return err
+	}
+	id, err := strconv.Atoi(r.muxVar("identityID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid ID.")
+	}
+	if err := core.UnlinkExternalIdentity(r.ctx, s.db, *r.viewer, id); err != nil {
+		return err
+	}
+	return w.writeJSON(anymap{"success": true})
+}
+
+type passwordLoginRequest struct {
+	Enabled  bool   `json:"enabled"`
+	Password string `json:"password"` // The new password (if enabling password login).
+}
+
+// /api/_settings/password_login [PUT]
+func (s *Server) setPasswordLogin(w *responseWriter, r *request) error {
+	if err := s.requireRecentAuth(r); err != nil {
+		return err
+	}
+	user, err := core.GetUser(r.ctx, s.db, *r.viewer, r.viewer)
+	if err != nil {
+		return err
+	}
+	req := passwordLoginRequest{}
+	if err := r.unmarshalJSONBody(&req); err != nil {
+		return err
+	}
+	if err := user.SetPasswordLogin(r.ctx, req.Enabled, req.Password); err != nil {
+		return err
+	}
+	if req.Enabled {
+		// The password changed.
+		if err := s.rotateSession(user, r.ses, w, r.req); err != nil {
+			return err
+		}
+		r.ses.Values[sessionAuthAt] = time.Now().Unix()
+		if err := r.ses.Save(w, r.req); err != nil {
+			return err
+		}
+	}
+	return w.writeJSON(user)
+}
@@ -39,6 +39,9 @@ func (s *Server) getUsersFeed(w *responseWriter, r *request) error {
 	if err != nil {
 		return err
 	}
+	if err := user.CheckProfileVisible(r.viewer); err != nil {
+		return err
+	}
 
 	if user.Banned { // Forbid viewing profile of banned users except for admins.
 		if !r.loggedIn {
@@ -74,6 +77,7 @@ func (s *Server) getUsersFeed(w *responseWriter, r *request) error {
 		return err
 	}
 
+	w.addSurrogateKeys(userKey(user.ID))
 	return w.writeJSON(set)
 }
 
@@ -194,5 +198,6 @@ func (s *Server) feed(w *responseWriter, r *request) error {
 		return w.writeJSON(res)
 	}
 
+	w.addSurrogateKeys(postsKeys(set.Posts)...)
 	return w.writeJSON(set)
 }
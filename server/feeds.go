@@ -0,0 +1,325 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/syndication"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	feedItemsLimit = 25
+
+	// How long clients (and proxies) may cache feeds for.
+	feedMaxAge = 5 * time.Minute
+)
+
+// registerFeedRoutes registers the routes of the RSS and Atom feeds of the
+// front page, communities, users, and posts (of their comments) on router.
+// Each feed is available in both formats, by the extension of its path.
+func (s *Server) registerFeedRoutes(router *mux.Router) {
+	const ext = "feed.{format:rss|atom}"
+	router.HandleFunc("/"+ext, s.withFeed(s.frontPageFeed)).Methods("GET", "HEAD")
+	router.HandleFunc("/@{username}/"+ext, s.withFeed(s.userFeed)).Methods("GET", "HEAD")
+	router.HandleFunc("/{communityName}/"+ext, s.withFeed(s.communityFeed)).Methods("GET", "HEAD")
+	router.HandleFunc("/{communityName}/post/{postID}/"+ext, s.withFeed(s.postCommentsFeed)).Methods("GET", "HEAD")
+}
+
+// A feedBuilder returns the feed requested in r.
+type feedBuilder func(r *http.Request) (*syndication.Feed, error)
+
+// withFeed returns an http.HandlerFunc that writes the feed returned by build,
+// in the format in the path of the request, with caching headers.
+func (s *Server) withFeed(build feedBuilder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		feed, err := build(r)
+		if err != nil {
+			if httpErr, ok := err.(*httperr.Error); ok && httpErr.HTTPStatus != http.StatusInternalServerError {
+				http.Error(w, httpErr.Message, httpErr.HTTPStatus)
+				return
+			}
+			logger.ErrorContext(r.Context(), "Failed to build feed", "url", r.URL.String(), "err", err)
+			http.Error(w, "Internal server error.", http.StatusInternalServerError)
+			return
+		}
+
+		format := syndication.Format(mux.Vars(r)["format"])
+		feed.Self = s.absoluteURL(r, r.URL.Path)
+		var b bytes.Buffer
+		if err := feed.Write(&b, format); err != nil {
+			logger.ErrorContext(r.Context(), "Failed to write feed", "url", r.URL.String(), "err", err)
+			http.Error(w, "Internal server error.", http.StatusInternalServerError)
+			return
+		}
+
+		sum := sha256.Sum256(b.Bytes())
+		header := w.Header()
+		header.Set("Content-Type", format.ContentType())
+		header.Set("Cache-Control", "public, max-age="+formatSeconds(feedMaxAge))
+		header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		http.ServeContent(w, r, "", feed.Updated, bytes.NewReader(b.Bytes()))
+	}
+}
+
+// absoluteURL returns the absolute URL of path on the host of r.
+func (s *Server) absoluteURL(r *http.Request, path string) string {
+	u := url.URL{Scheme: "https", Host: r.Host, Path: path}
+	return u.String()
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.Itoa(int(d.Seconds()))
+}
+
+// postFeedItems returns the feed items of posts, leaving out deleted posts and
+// posts in NSFW communities.
+func (s *Server) postFeedItems(r *http.Request, posts []*core.Post) ([]*syndication.Item, error) {
+	nsfw, err := s.nsfwCommunities(r.Context(), posts)
+	if err != nil {
+		return nil, err
+	}
+	items := []*syndication.Item{}
+	for _, post := range posts {
+		if post.Deleted || nsfw[post.CommunityID] {
+			continue
+		}
+		link := s.absoluteURL(r, "/"+post.CommunityName+"/post/"+post.PublicID)
+		item := &syndication.Item{
+			ID:        link,
+			Title:     post.Title,
+			Link:      link,
+			Author:    post.AuthorUsername,
+			Rights:    post.Rights(),
+			Published: post.CreatedAt,
+		}
+		if post.EditedAt.Valid {
+			item.Updated = post.EditedAt.Time
+		}
+		switch post.Type {
+		case core.PostTypeText:
+			item.Content = post.Body.String
+		case core.PostTypeLink:
+			if post.Link != nil {
+				item.Content = post.Link.URL
+			}
+		case core.PostTypeImage:
+			if post.Image != nil && post.Image.URL != nil {
+				item.Content = s.absoluteURL(r, *post.Image.URL)
+			}
+		case core.PostTypeVideo:
+			if post.Video != nil && post.Video.URL != "" {
+				item.Content = s.absoluteURL(r, post.Video.URL)
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// nsfwCommunities returns the set of the NSFW communities that posts are in.
+func (s *Server) nsfwCommunities(ctx context.Context, posts []*core.Post) (map[uid.ID]bool, error) {
+	seen := make(map[uid.ID]bool)
+	var ids []uid.ID
+	for _, post := range posts {
+		if !seen[post.CommunityID] {
+			seen[post.CommunityID] = true
+			ids = append(ids, post.CommunityID)
+		}
+	}
+	nsfw := make(map[uid.ID]bool)
+	if len(ids) == 0 {
+		return nsfw, nil
+	}
+	comms, err := core.GetCommunitiesByIDs(ctx, s.db, ids, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, comm := range comms {
+		if comm.NSFW {
+			nsfw[comm.ID] = true
+		}
+	}
+	return nsfw, nil
+}
+
+// feedCommunity returns the community named name, if it has feeds. Deleted and
+// NSFW communities have none.
+func (s *Server) feedCommunity(ctx context.Context, name string) (*core.Community, error) {
+	comm, err := core.GetCommunityByName(ctx, s.db, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if comm.DeletedAt.Valid || comm.NSFW {
+		return nil, httperr.NewNotFound("no_feed", "Feed not found.")
+	}
+	return comm, nil
+}
+
+// feedUpdated returns the time of the most recent of items (or now, if there
+// are none).
+func feedUpdated(items []*syndication.Item) time.Time {
+	var t time.Time
+	for _, item := range items {
+		if item.Published.After(t) {
+			t = item.Published
+		}
+		if item.Updated.After(t) {
+			t = item.Updated
+		}
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t
+}
+
+// /feed.rss, /feed.atom
+func (s *Server) frontPageFeed(r *http.Request) (*syndication.Feed, error) {
+	set, err := core.GetFeed(r.Context(), s.db, &core.FeedOptions{
+		Sort:  core.FeedSortLatest,
+		Limit: feedItemsLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.postFeedItems(r, set.Posts)
+	if err != nil {
+		return nil, err
+	}
+	return &syndication.Feed{
+		Title:       s.config.SiteName,
+		Link:        s.absoluteURL(r, "/"),
+		Description: s.config.SiteDescription,
+		Updated:     feedUpdated(items),
+		Items:       items,
+	}, nil
+}
+
+// /{communityName}/feed.rss, /{communityName}/feed.atom
+func (s *Server) communityFeed(r *http.Request) (*syndication.Feed, error) {
+	comm, err := s.feedCommunity(r.Context(), mux.Vars(r)["communityName"])
+	if err != nil {
+		return nil, err
+	}
+	set, err := core.GetFeed(r.Context(), s.db, &core.FeedOptions{
+		Sort:      core.FeedSortLatest,
+		Community: &comm.ID,
+		Limit:     feedItemsLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.postFeedItems(r, set.Posts)
+	if err != nil {
+		return nil, err
+	}
+	return &syndication.Feed{
+		Title:       comm.Name + " - " + s.config.SiteName,
+		Link:        s.absoluteURL(r, "/"+comm.Name),
+		Description: comm.About.String,
+		Updated:     feedUpdated(items),
+		Items:       items,
+	}, nil
+}
+
+// /@{username}/feed.rss, /@{username}/feed.atom
+//
+// The posts of a user (but not their comments).
+func (s *Server) userFeed(r *http.Request) (*syndication.Feed, error) {
+	user, err := core.GetUserByUsername(r.Context(), s.db, mux.Vars(r)["username"], nil)
+	if err != nil {
+		return nil, err
+	}
+	if user.Banned || user.DeletedAt.Valid || user.CheckProfileVisible(nil) != nil {
+		return nil, httperr.NewNotFound("no_feed", "Feed not found.")
+	}
+	set, err := core.GetUserFeed(r.Context(), s.db, nil, user.ID, feedItemsLimit, nil)
+	if err != nil {
+		return nil, err
+	}
+	var posts []*core.Post
+	for _, item := range set.Items {
+		if post, ok := item.Item.(*core.Post); ok {
+			posts = append(posts, post)
+		}
+	}
+	items, err := s.postFeedItems(r, posts)
+	if err != nil {
+		return nil, err
+	}
+	return &syndication.Feed{
+		Title:   "@" + user.Username + " - " + s.config.SiteName,
+		Link:    s.absoluteURL(r, "/@"+user.Username),
+		Updated: feedUpdated(items),
+		Items:   items,
+	}, nil
+}
+
+// /{communityName}/post/{postID}/feed.rss, /{communityName}/post/{postID}/feed.atom
+//
+// The most recent comments of a post.
+func (s *Server) postCommentsFeed(r *http.Request) (*syndication.Feed, error) {
+	vars := mux.Vars(r)
+	comm, err := s.feedCommunity(r.Context(), vars["communityName"])
+	if err != nil {
+		return nil, err
+	}
+	post, err := core.GetPost(r.Context(), s.db, nil, vars["postID"], nil, false)
+	if err != nil {
+		return nil, err
+	}
+	if post.CommunityID != comm.ID {
+		return nil, httperr.NewNotFound("post_not_found", "Post not found.")
+	}
+	if _, err := post.GetComments(r.Context(), nil, core.CommentSortNew, nil); err != nil {
+		return nil, err
+	}
+
+	comments := make([]*core.Comment, 0, len(post.Comments))
+	for _, c := range post.Comments {
+		if !c.DeletedAt.Valid {
+			comments = append(comments, c)
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		return comments[i].CreatedAt.After(comments[j].CreatedAt)
+	})
+	if len(comments) > feedItemsLimit {
+		comments = comments[:feedItemsLimit]
+	}
+
+	postPath := "/" + comm.Name + "/post/" + post.PublicID
+	items := make([]*syndication.Item, 0, len(comments))
+	for _, c := range comments {
+		link := s.absoluteURL(r, postPath+"/"+c.ID.String())
+		item := &syndication.Item{
+			ID:        link,
+			Title:     "@" + c.AuthorUsername + " on " + post.Title,
+			Link:      link,
+			Author:    c.AuthorUsername,
+			Content:   c.Body,
+			Published: c.CreatedAt,
+		}
+		if c.EditedAt.Valid {
+			item.Updated = c.EditedAt.Time
+		}
+		items = append(items, item)
+	}
+	return &syndication.Feed{
+		Title:   "Comments on " + post.Title + " - " + s.config.SiteName,
+		Link:    s.absoluteURL(r, postPath),
+		Updated: feedUpdated(items),
+		Items:   items,
+	}, nil
+}
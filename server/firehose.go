@@ -0,0 +1,361 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	firehoseRedisChannel = "firehose"
+
+	// The maximum number of concurrent firehose streams of a user.
+	firehoseMaxStreamsPerUser = 2
+
+	// The number of events buffered for a client. Clients that fall further
+	// behind are disconnected.
+	firehoseClientBuffer = 256
+
+	firehoseKeepAliveInterval = 30 * time.Second
+)
+
+// firehoseChannel returns the Redis channel of the firehose of the site that
+// uses the Redis database redisDB. Unlike keys, channels are shared by all
+// databases, and so the channels of sites sharing a Redis server differ.
+func firehoseChannel(redisDB int) string {
+	if redisDB == 0 {
+		return firehoseRedisChannel
+	}
+	return firehoseRedisChannel + ":" + strconv.Itoa(redisDB)
+}
+
+// A firehoseEvent is a new public post or comment.
+type firehoseEvent struct {
+	Type          string          `json:"type"` // Either "post" or "comment".
+	ID            string          `json:"id"`
+	CommunityID   uid.ID          `json:"communityId"`
+	CommunityName string          `json:"communityName"`
+	Data          json.RawMessage `json:"data"`
+}
+
+type firehoseClient struct {
+	userID uid.ID
+	events chan *firehoseEvent
+
+	// Closed when the client is disconnected by the server (because it's too
+	// slow or because the server is shutting down).
+	dropped chan struct{}
+}
+
+// firehose fans out the events published to the Redis channel
+// firehoseRedisChannel (by any of the instances of the server) to the clients
+// of /api/firehose connected to this instance.
+type firehose struct {
+	redisPool *redis.Pool
+	channel   string // See firehoseChannel.
+
+	mu      sync.Mutex
+	clients map[*firehoseClient]struct{}
+	cancel  context.CancelFunc // stops the Redis subscriber; nil if not running
+	closed  bool
+}
+
+// subscribe adds a client for user. It returns an error if the user already
+// has too many streams open, or if the firehose is closed.
+func (f *firehose) subscribe(user uid.ID) (*firehoseClient, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, &httperr.Error{HTTPStatus: http.StatusServiceUnavailable, Code: "shutting_down", Message: "The server is shutting down."}
+	}
+	n := 0
+	for c := range f.clients {
+		if c.userID == user {
+			n++
+		}
+	}
+	if n >= firehoseMaxStreamsPerUser {
+		return nil, &httperr.Error{
+			HTTPStatus: http.StatusTooManyRequests,
+			Code:       "too_many_streams",
+			Message:    fmt.Sprintf("At most %d firehose streams can be open at once.", firehoseMaxStreamsPerUser),
+		}
+	}
+
+	c := &firehoseClient{
+		userID:  user,
+		events:  make(chan *firehoseEvent, firehoseClientBuffer),
+		dropped: make(chan struct{}),
+	}
+	if f.clients == nil {
+		f.clients = make(map[*firehoseClient]struct{})
+	}
+	f.clients[c] = struct{}{}
+	if f.cancel == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		f.cancel = cancel
+		go f.receive(ctx)
+	}
+	return c, nil
+}
+
+// unsubscribe removes c. The Redis subscriber is stopped once there are no
+// clients left.
+func (f *firehose) unsubscribe(c *firehoseClient) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.clients, c)
+	if len(f.clients) == 0 && f.cancel != nil {
+		f.cancel()
+		f.cancel = nil
+	}
+}
+
+// close disconnects all clients, and refuses new ones.
+func (f *firehose) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for c := range f.clients {
+		f.drop(c)
+	}
+	if f.cancel != nil {
+		f.cancel()
+		f.cancel = nil
+	}
+}
+
+// drop disconnects c. The caller must hold f.mu.
+func (f *firehose) drop(c *firehoseClient) {
+	if _, ok := f.clients[c]; ok {
+		delete(f.clients, c)
+		close(c.dropped)
+	}
+}
+
+// broadcast sends e to all clients, dropping those that can't keep up.
+func (f *firehose) broadcast(e *firehoseEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.clients {
+		select {
+		case c.events <- e:
+		default:
+			f.drop(c)
+		}
+	}
+}
+
+// receive receives events from Redis, and broadcasts them, until ctx is
+// canceled. It reconnects to Redis on failure.
+func (f *firehose) receive(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := f.receiveOnce(ctx); err != nil && ctx.Err() == nil {
+			logger.Error("Firehose Redis subscription failed", "err", err)
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+func (f *firehose) receiveOnce(ctx context.Context) error {
+	conn, err := f.redisPool.Dial()
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+	if err := psc.Subscribe(f.channel); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			psc.Unsubscribe()
+		case <-done:
+		}
+	}()
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			e := &firehoseEvent{}
+			if err := json.Unmarshal(v.Data, e); err != nil {
+				logger.Error("Invalid firehose event", "err", err)
+				continue
+			}
+			f.broadcast(e)
+		case redis.Subscription:
+			if v.Count == 0 {
+				return nil
+			}
+		case error:
+			return v
+		}
+	}
+}
+
+// publishToFirehose publishes the event of type typ (either "post" or
+// "comment") with the data v to the firehose. Errors are only logged.
+func (s *Server) publishToFirehose(ctx context.Context, typ, id string, communityID uid.ID, communityName string, v any) {
+	data, err := json.Marshal(v)
+	if err == nil {
+		data, err = json.Marshal(firehoseEvent{
+			Type:          typ,
+			ID:            id,
+			CommunityID:   communityID,
+			CommunityName: communityName,
+			Data:          data,
+		})
+	}
+	if err == nil {
+		conn := s.redisPool.Get()
+		_, err = conn.Do("PUBLISH", s.firehose.channel, data)
+		conn.Close()
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to publish to the firehose", "type", typ, "id", id, "err", err)
+	}
+}
+
+// publishPost publishes the newly created post to the firehose, as seen by
+// anonymous users.
+func (s *Server) publishPost(ctx context.Context, id uid.ID) {
+	post, err := core.GetPost(ctx, s.db, &id, "", nil, true)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to get post for the firehose", "id", id.String(), "err", err)
+		return
+	}
+	s.publishToFirehose(ctx, "post", post.ID.String(), post.CommunityID, post.CommunityName, post)
+}
+
+// publishComment publishes the newly created comment to the firehose, as seen
+// by anonymous users.
+func (s *Server) publishComment(ctx context.Context, id uid.ID) {
+	comment, err := core.GetComment(ctx, s.db, id, nil)
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to get comment for the firehose", "id", id.String(), "err", err)
+		return
+	}
+	s.publishToFirehose(ctx, "comment", comment.ID.String(), comment.CommunityID, comment.CommunityName, comment)
+}
+
+// CloseStreams disconnects the clients of streaming endpoints (so that the
+// server can shut down without waiting for them).
+func (s *Server) CloseStreams() {
+	s.firehose.close()
+}
+
+// canUseFirehose reports whether user may connect to the firehose.
+func (s *Server) canUseFirehose(user *core.User) bool {
+	if user.Admin {
+		return true
+	}
+	for _, username := range s.config.FirehoseUsers {
+		if strings.EqualFold(username, user.Username) {
+			return true
+		}
+	}
+	return false
+}
+
+// /api/firehose [GET]
+//
+// A stream (of server-sent events) of all new posts and comments. The events
+// can be limited to some communities with the communities URL query parameter
+// (a comma separated list of community names or IDs), and to either posts or
+// comments with the type parameter.
+func (s *Server) firehoseStream(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	user, err := core.GetUser(r.ctx, s.db, *r.viewer, nil)
+	if err != nil {
+		return err
+	}
+	if !s.canUseFirehose(user) {
+		return httperr.NewForbidden("no_firehose_access", "You do not have access to the firehose.")
+	}
+
+	query := r.urlQuery()
+	eventType := query.Get("type")
+	if eventType != "" && eventType != "post" && eventType != "comment" {
+		return httperr.NewBadRequest("invalid_type", "Type must be either post or comment.")
+	}
+	var communities map[string]bool
+	if text := query.Get("communities"); text != "" {
+		communities = make(map[string]bool)
+		for _, c := range strings.Split(text, ",") {
+			if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+				communities[c] = true
+			}
+		}
+	}
+	match := func(e *firehoseEvent) bool {
+		if eventType != "" && e.Type != eventType {
+			return false
+		}
+		if communities != nil {
+			return communities[strings.ToLower(e.CommunityName)] || communities[e.CommunityID.String()]
+		}
+		return true
+	}
+
+	client, err := s.firehose.subscribe(user.ID)
+	if err != nil {
+		return err
+	}
+	defer s.firehose.unsubscribe(client)
+
+	rc := http.NewResponseController(w.w)
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-store")
+	header.Set("X-Accel-Buffering", "no") // for nginx
+	w.WriteHeader(http.StatusOK)
+	if err := w.writeString(": connected\n\n"); err != nil {
+		return nil
+	}
+	rc.Flush()
+
+	keepAlive := time.NewTicker(firehoseKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case e := <-client.events:
+			if !match(e) {
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\nid: %s\ndata: %s\n\n", e.Type, e.ID, e.Data)
+		case <-keepAlive.C:
+			err = w.writeString(": keep-alive\n\n")
+		case <-client.dropped:
+			w.writeString("event: close\ndata: {}\n\n")
+			rc.Flush()
+			return nil
+		case <-r.ctx.Done():
+			return nil
+		}
+		if err != nil {
+			return nil // the client is gone
+		}
+		rc.Flush()
+	}
+}
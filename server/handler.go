@@ -1,12 +1,15 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"net/http"
 	"net/url"
 	"strings"
 
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/cdn"
 	"github.com/discuitnet/discuit/internal/httperr"
 	"github.com/discuitnet/discuit/internal/sessions"
 	"github.com/discuitnet/discuit/internal/uid"
@@ -18,6 +21,11 @@ type responseWriter struct {
 	w           http.ResponseWriter
 	wrote       bool
 	wroteHeader bool
+
+	// If non-nil, the response body is written to buf, and the status code
+	// to status, instead of to w (see Server.writeCacheable).
+	buf    *bytes.Buffer
+	status int
 }
 
 func (rw *responseWriter) Header() http.Header {
@@ -27,14 +35,27 @@ func (rw *responseWriter) Header() http.Header {
 func (rw *responseWriter) Write(b []byte) (int, error) {
 	rw.wrote = true
 	rw.wroteHeader = true
+	if rw.buf != nil {
+		return rw.buf.Write(b)
+	}
 	return rw.w.Write(b)
 }
 
 func (rw *responseWriter) WriteHeader(statusCode int) {
 	rw.wroteHeader = true
+	if rw.buf != nil {
+		rw.status = statusCode
+		return
+	}
 	rw.w.WriteHeader(statusCode)
 }
 
+// addSurrogateKeys tags the response with the surrogate keys of the content
+// in it, with which it's purged from a CDN when the content changes.
+func (rw *responseWriter) addSurrogateKeys(keys ...string) {
+	cdn.AddKeys(rw.Header(), keys...)
+}
+
 func (rw *responseWriter) writeJSON(v any) error {
 	return json.NewEncoder(rw).Encode(v)
 }
@@ -52,6 +73,10 @@ type request struct {
 
 	loggedIn bool
 	viewer   *uid.ID // logged in user
+
+	// The API token the request is authenticated with, if it's not
+	// authenticated with a session cookie.
+	token *core.APIToken
 }
 
 func newRequest(r *http.Request, ses *sessions.Session) *request {
@@ -71,6 +96,16 @@ func newRequest(r *http.Request, ses *sessions.Session) *request {
 	return newR
 }
 
+// requireScope returns an error if the request is authenticated with an API
+// token that doesn't have scope. Requests authenticated with a session cookie
+// have all scopes.
+func (r *request) requireScope(scope core.Scope) error {
+	if r.token == nil || r.token.Scopes.Has(scope) {
+		return nil
+	}
+	return errInsufficientScope(scope)
+}
+
 func (r *request) muxVar(name string) string {
 	return mux.Vars(r.req)[name]
 }
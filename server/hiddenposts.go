@@ -0,0 +1,62 @@
+package server
+
+import (
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+type hidePostRequest struct {
+	PostID uid.ID `json:"postId"`
+}
+
+// /api/hidden_posts [GET, POST]
+func (s *Server) handleHiddenPosts(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	if r.req.Method == "POST" {
+		var req hidePostRequest
+		if err := r.unmarshalJSONBody(&req); err != nil {
+			return err
+		}
+		if err := core.HidePost(r.ctx, s.db, *r.viewer, req.PostID); err != nil {
+			return err
+		}
+		return w.writeString(`{"success":true}`)
+	}
+
+	query := r.urlQuery()
+	limit, err := getFeedLimit(query, s.config.PaginationLimit, s.config.PaginationLimitMax)
+	if err != nil {
+		return err
+	}
+	var next *uid.ID
+	if text := query.Get("next"); text != "" {
+		next = new(uid.ID)
+		if err := next.UnmarshalText([]byte(text)); err != nil {
+			return core.ErrInvalidFeedCursor
+		}
+	}
+	set, err := core.GetHiddenPosts(r.ctx, s.db, *r.viewer, limit, next)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(set)
+}
+
+// /api/hidden_posts/{postID} [DELETE]
+func (s *Server) unhidePost(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	postID, err := uid.FromString(r.muxVar("postID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_post_id", "Invalid post ID.")
+	}
+	if err := core.UnhidePost(r.ctx, s.db, *r.viewer, postID); err != nil {
+		return err
+	}
+	return w.writeString(`{"success":true}`)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/i18n"
+)
+
+type localeContextKey struct{}
+
+// withLocale returns a copy of ctx with the locale of the request (see
+// requestLocale).
+func withLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeContextKey{}, locale)
+}
+
+// requestLocale returns the locale the text of the response to r is to be
+// in: that of the request's context (see withLocale), or else the best match
+// of r's Accept-Language header.
+func (s *Server) requestLocale(r *http.Request) string {
+	if locale, ok := r.Context().Value(localeContextKey{}).(string); ok && locale != "" {
+		return locale
+	}
+	return s.translations.Match(r.Header.Get("Accept-Language"))
+}
+
+// translateError translates the message of err (which is to be sent to a
+// client) to locale.
+func (s *Server) translateError(err *httperr.Error, locale string) {
+	args := make([]any, 0, 2*len(err.Details))
+	for name, value := range err.Details {
+		args = append(args, name, value)
+	}
+	err.Message = s.translations.Translate(locale, err.Key(), err.Message, args...)
+	for i := range err.Fields {
+		f := &err.Fields[i]
+		f.Message = s.translations.Translate(locale, f.Key(), f.Message)
+	}
+}
+
+// /api/_admin/translations [GET, POST]
+func (s *Server) handleTranslations(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	if s.translations == nil {
+		return httperr.NewNotFound("translations_off", "No translations folder is configured.")
+	}
+	if r.req.Method == "POST" {
+		if err := s.translations.Reload(); err != nil {
+			return httperr.NewBadRequest("invalid_translations", err.Error())
+		}
+	}
+	return w.writeJSON(struct {
+		DefaultLocale string         `json:"defaultLocale"`
+		Locales       map[string]int `json:"locales"` // The number of messages translated, by locale.
+	}{i18n.DefaultLocale, s.translations.Locales()})
+}
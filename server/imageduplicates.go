@@ -0,0 +1,80 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+func (s *Server) imageDuplicatePolicy() *core.ImageDuplicatePolicy {
+	return &core.ImageDuplicatePolicy{
+		Window:       time.Duration(s.config.DuplicateImageWindow) * time.Minute,
+		MaxDistance:  s.config.DuplicateImageMaxDistance,
+		AccountLimit: s.config.DuplicateImageAccountLimit,
+	}
+}
+
+// checkDuplicateImage flags image, just uploaded by the logged in user, if it's
+// a near-duplicate of images many other users uploaded recently. Uploads are
+// not refused; failures to check are only logged.
+func (s *Server) checkDuplicateImage(r *request, image uid.ID) {
+	if s.config.DuplicateImageWindow == 0 {
+		return
+	}
+	check, err := core.CheckDuplicateImage(r.ctx, s.db, image, *r.viewer, s.imageDuplicatePolicy())
+	if err != nil {
+		logger.ErrorContext(r.ctx, "Failed to check image for duplicates", "err", err, "image", image)
+		return
+	}
+	if check.Flagged() {
+		logger.InfoContext(r.ctx, "Duplicate image flagged", "image", image, "reason", check.Reason, "exact", check.Exact)
+	}
+}
+
+// /api/_admin/spam/image_clusters [GET]
+func (s *Server) getImageClusters(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	query := r.urlQuery()
+	hours, minUsers := 24, 2
+	if v := query.Get("hours"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 24*7 {
+			return httperr.NewBadRequest("invalid_hours", "Hours must be between 1 and 168.")
+		}
+		hours = n
+	}
+	if v := query.Get("minUsers"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return httperr.NewBadRequest("invalid_min_users", "Minimum users must be a positive number.")
+		}
+		minUsers = n
+	}
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	clusters, err := core.GetImageClusters(r.ctx, s.db, since, s.config.DuplicateImageMaxDistance, minUsers)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(clusters)
+}
+
+// /api/communities/{communityID}/flagged_images [GET]
+//
+// The posts and comments of a community with images flagged as duplicates
+// (only accessible to mods and admins).
+func (s *Server) getCommunityFlaggedImages(w *responseWriter, r *request) error {
+	comm, err := s.modOrAdminCommunity(r)
+	if err != nil {
+		return err
+	}
+	items, err := core.GetFlaggedImages(r.ctx, s.db, comm.ID)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(items)
+}
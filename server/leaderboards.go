@@ -0,0 +1,50 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// /api/leaderboards/{kind} [GET]
+// /api/communities/{communityID}/leaderboards/{kind} [GET]
+//
+// The top posts, comments, or users (kind) of a period, site-wide or of a
+// community. Leaderboards are computed periodically (see
+// core.ComputeLeaderboards).
+func (s *Server) getLeaderboard(w *responseWriter, r *request) error {
+	query := r.urlQuery()
+	period := query.Get("period")
+	if period == "" {
+		period = "week"
+	}
+	limit := 25
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			return httperr.NewBadRequest("invalid_limit", "Limit must be between 1 and 100.")
+		}
+		limit = n
+	}
+
+	var community *uid.ID
+	if v := r.muxVar("communityID"); v != "" {
+		cid, err := strToID(v)
+		if err != nil {
+			return err
+		}
+		comm, err := core.GetCommunityByID(r.ctx, s.db, cid, r.viewer)
+		if err != nil {
+			return err
+		}
+		community = &comm.ID
+	}
+
+	lb, err := core.GetLeaderboard(r.ctx, s.db, r.muxVar("kind"), period, community, limit, r.viewer)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(lb)
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// checkLinkDomains returns an error if link cannot be posted in community
+// because of the link domain rules.
+func (s *Server) checkLinkDomains(r *request, community uid.ID, link string) error {
+	shorteners := make([]string, len(s.config.LinkShorteners))
+	for i, v := range s.config.LinkShorteners {
+		shorteners[i] = strings.ToLower(strings.TrimSpace(v))
+	}
+	return core.CheckLinkDomains(r.ctx, s.db, community, link, shorteners)
+}
+
+type linkDomainRuleRequest struct {
+	Pattern string `json:"pattern"`
+	Allow   bool   `json:"allow"`
+}
+
+// /api/communities/{communityID}/link_domains [GET, POST]
+//
+// The link domain rules of a community (only accessible to mods and admins).
+func (s *Server) handleCommunityLinkDomains(w *responseWriter, r *request) error {
+	comm, err := s.modOrAdminCommunity(r)
+	if err != nil {
+		return err
+	}
+	return s.handleLinkDomainRules(w, r, &comm.ID)
+}
+
+// /api/communities/{communityID}/link_domains/{ruleID} [DELETE]
+func (s *Server) deleteCommunityLinkDomainRule(w *responseWriter, r *request) error {
+	comm, err := s.modOrAdminCommunity(r)
+	if err != nil {
+		return err
+	}
+	return s.deleteLinkDomainRule(w, r, &comm.ID)
+}
+
+// /api/_admin/link_domains [GET, POST]
+//
+// The site-wide link domain rules.
+func (s *Server) handleSiteLinkDomains(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	return s.handleLinkDomainRules(w, r, nil)
+}
+
+// /api/_admin/link_domains/{ruleID} [DELETE]
+func (s *Server) deleteSiteLinkDomainRule(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	return s.deleteLinkDomainRule(w, r, nil)
+}
+
+func (s *Server) handleLinkDomainRules(w *responseWriter, r *request, community *uid.ID) error {
+	if r.req.Method == "POST" {
+		req := linkDomainRuleRequest{}
+		if err := r.unmarshalJSONBody(&req); err != nil {
+			return err
+		}
+		rule, err := core.AddLinkDomainRule(r.ctx, s.db, community, req.Pattern, req.Allow, *r.viewer)
+		if err != nil {
+			return err
+		}
+		logger.InfoContext(r.ctx, "Link domain rule added", "community", community, "pattern", rule.Pattern, "allow", rule.Allow)
+		return w.writeJSON(rule)
+	}
+	rules, err := core.GetLinkDomainRules(r.ctx, s.db, community)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(rules)
+}
+
+func (s *Server) deleteLinkDomainRule(w *responseWriter, r *request, community *uid.ID) error {
+	id, err := strconv.Atoi(r.muxVar("ruleID"))
+	if err != nil {
+		return httperr.NewNotFound("link-domain/rule-not-found", "Rule not found.")
+	}
+	if err := core.DeleteLinkDomainRule(r.ctx, s.db, community, id); err != nil {
+		return err
+	}
+	return w.writeString(`{"success":true}`)
+}
+
+// /api/_admin/top_link_domains [GET]
+func (s *Server) getTopLinkDomains(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	query := r.urlQuery()
+	days, limit := 7, 50
+	if v := query.Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 365 {
+			return httperr.NewBadRequest("invalid_days", "Days must be between 1 and 365.")
+		}
+		days = n
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			return httperr.NewBadRequest("invalid_limit", "Limit must be between 1 and 500.")
+		}
+		limit = n
+	}
+	since := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	stats, err := core.GetTopLinkDomains(r.ctx, s.db, since, limit)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(stats)
+}
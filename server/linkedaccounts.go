@@ -0,0 +1,142 @@
+package server
+
+import (
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/httputil"
+)
+
+// /api/_linked_accounts [GET, POST]
+//
+// Lists the accounts linked to the logged in user, or links one (given its
+// username and password) to it.
+func (s *Server) handleLinkedAccounts(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	if r.req.Method == "GET" {
+		accounts, err := core.GetLinkedAccounts(r.ctx, s.db, *r.viewer)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(accounts)
+	}
+
+	if err := s.requireRecentAuth(r); err != nil {
+		return err
+	}
+	values, err := r.unmarshalJSONBodyToStringsMap(true)
+	if err != nil {
+		return err
+	}
+	user, err := core.GetUser(r.ctx, s.db, *r.viewer, nil)
+	if err != nil {
+		return err
+	}
+
+	username, ip := values["username"], httputil.GetIP(r.req)
+	if err := s.throttleLogin(r, username, ip, s.loginDevice(r, ip)); err != nil {
+		return err
+	}
+	other, err := core.MatchLoginCredentials(r.ctx, s.db, username, values["password"])
+	if err != nil {
+		if err == core.ErrWrongPassword {
+			s.recordLoginFailure(r, username, ip)
+		}
+		return err
+	}
+	if other.PasswordLoginDisabled {
+		return errPasswordLoginDisabled
+	}
+	if err := s.requirePasskeyLogin(r, other); err != nil {
+		return err
+	}
+
+	if err := core.LinkAccounts(r.ctx, s.db, user, other, ip); err != nil {
+		return err
+	}
+	accounts, err := core.GetLinkedAccounts(r.ctx, s.db, *r.viewer)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(accounts)
+}
+
+// /api/_linked_accounts/{username} [DELETE]
+func (s *Server) unlinkAccount(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	user, err := core.GetUser(r.ctx, s.db, *r.viewer, nil)
+	if err != nil {
+		return err
+	}
+	other, err := core.GetUserByUsername(r.ctx, s.db, r.muxVar("username"), nil)
+	if err != nil {
+		return err
+	}
+	if err := core.UnlinkAccounts(r.ctx, s.db, user, other, httputil.GetIP(r.req)); err != nil {
+		return err
+	}
+	return w.writeString(`{"success":true}`)
+}
+
+// /api/_switch_account [POST]
+//
+// Switches the session to an account linked to the logged in user. The
+// session is logged out of the one account and into the other, so that
+// nothing of either (like push subscriptions) carries over, and the switch is
+// recorded as an auth event of the account switched to. Sensitive actions in
+// the account switched to need the password to be entered again.
+func (s *Server) switchAccount(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	if r.token != nil {
+		return errSessionOnly
+	}
+	if err := s.rateLimit(r, "switch_account_"+r.viewer.String(), time.Minute, 20); err != nil {
+		return err
+	}
+	values, err := r.unmarshalJSONBodyToStringsMap(true)
+	if err != nil {
+		return err
+	}
+
+	user, err := core.GetUser(r.ctx, s.db, *r.viewer, nil)
+	if err != nil {
+		return err
+	}
+	other, err := core.GetUserByUsername(r.ctx, s.db, values["username"], nil)
+	if err != nil {
+		return err
+	}
+	if linked, err := core.AccountsLinked(r.ctx, s.db, user.ID, other.ID); err != nil {
+		return err
+	} else if !linked {
+		return core.ErrAccountNotLinked
+	}
+	if err := s.requirePasskeyLogin(r, other); err != nil {
+		return err
+	}
+	if other.Banned {
+		return httperr.NewForbidden("account_suspended", "User account suspended.")
+	}
+
+	if err := s.logoutUser(user, r.ses, w, r.req); err != nil {
+		return err
+	}
+	if err := s.loginUser(other, r.ses, w, r.req); err != nil {
+		return err
+	}
+	delete(r.ses.Values, sessionAuthAt) // Not entered for this account.
+	if err := r.ses.Save(w, r.req); err != nil {
+		return err
+	}
+	if err := core.RecordAccountSwitch(r.ctx, s.db, user, other, httputil.GetIP(r.req)); err != nil {
+		logger.ErrorContext(r.ctx, "Failed to record account switch", "err", err, "user", other.ID)
+	}
+	return w.writeJSON(other)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+)
+
+func (s *Server) loginThrottlePolicy() *core.LoginThrottlePolicy {
+	return &core.LoginThrottlePolicy{
+		Window:         time.Duration(s.config.LoginThrottleWindow) * time.Minute,
+		DelayAfter:     s.config.LoginDelayAfter,
+		MaxDelay:       time.Duration(s.config.LoginMaxDelay) * time.Second,
+		AccountLockout: s.config.LoginAccountLockout,
+		IPLockout:      s.config.LoginIPLockout,
+		Lockout:        time.Duration(s.config.LoginLockoutMinutes) * time.Minute,
+	}
+}
+
+// loginDevice returns an identifier of the device r is made from: a hash of
+// its user agent and of the network its IP address ip is part of.
+func (s *Server) loginDevice(r *request, ip string) string {
+	sum := sha256.Sum256([]byte(r.req.UserAgent() + "\n" + s.signupNetwork(r, ip)))
+	return hex.EncodeToString(sum[:])
+}
+
+// throttleLogin returns an error if the attempt to log in to username is to be
+// refused, and otherwise waits as long as the attempt is to be delayed.
+func (s *Server) throttleLogin(r *request, username, ip, device string) error {
+	if s.config.LoginThrottleWindow == 0 {
+		return nil
+	}
+	throttle, err := core.CheckLoginThrottle(r.ctx, s.db, username, ip, device, s.loginThrottlePolicy())
+	if err != nil {
+		return err
+	}
+	if err := throttle.Err(); err != nil {
+		return err
+	}
+	if throttle.Delay > 0 {
+		select {
+		case <-time.After(throttle.Delay):
+		case <-r.ctx.Done():
+			return r.ctx.Err()
+		}
+	}
+	return nil
+}
+
+// recordLoginFailure records the failed attempt to log in to username.
+func (s *Server) recordLoginFailure(r *request, username, ip string) {
+	if s.config.LoginThrottleWindow == 0 {
+		return
+	}
+	locked, err := core.RecordLoginFailure(r.ctx, s.db, username, ip, s.loginThrottlePolicy())
+	if err != nil {
+		logger.ErrorContext(r.ctx, "Failed to record failed login", "err", err)
+	} else if locked {
+		logger.WarnContext(r.ctx, "Logins locked out", "username", username, "ip", ip)
+	}
+}
+
+// recordLogin records the login of user and, if it's from a new device,
+// alerts them by email (if enabled).
+func (s *Server) recordLogin(r *request, user *core.User, ip, device string) {
+	newDevice, err := core.RecordLogin(r.ctx, s.db, user, device, ip, r.req.UserAgent())
+	if err != nil {
+		logger.ErrorContext(r.ctx, "Failed to record login", "err", err, "user", user.ID)
+		return
+	}
+	if !newDevice || !s.config.NewDeviceLoginEmail || s.mailer == nil || !user.Email.Valid {
+		return
+	}
+	locale := user.Locale
+	if locale == "" {
+		locale = s.requestLocale(r.req)
+	}
+	subject := s.translations.Translate(locale, "email.new_device_login.subject", "New login to your {site} account",
+		"site", s.config.SiteName)
+	body := s.translations.Translate(locale, "email.new_device_login.body", "Hi {username},\n\nYour {site} account was just logged in to from a new device:\n\nIP address: {ip}\nBrowser: {browser}\nTime: {time}\n\n"+
+		"If this was you, you can ignore this email. If it wasn't, change your password right away.\n",
+		"username", user.Username, "site", s.config.SiteName, "ip", ip, "browser", r.req.UserAgent(), "time", s.translations.FormatTime(locale, time.Now().In(user.Location())))
+	ctx := context.WithoutCancel(r.ctx)
+	core.RunInBackground(func() {
+		if err := s.mailer.Send(user.Email.String, subject, body); err != nil {
+			logger.ErrorContext(ctx, "Failed to send new device login email", "err", err, "user", user.ID)
+		}
+	})
+}
+
+// /api/_admin/auth_anomalies [GET]
+func (s *Server) getAuthAnomalies(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	query := r.urlQuery()
+	hours, limit := 24, 50
+	if v := query.Get("hours"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 24*30 {
+			return httperr.NewBadRequest("invalid_hours", "Hours must be between 1 and 720.")
+		}
+		hours = n
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			return httperr.NewBadRequest("invalid_limit", "Limit must be between 1 and 500.")
+		}
+		limit = n
+	}
+	since := time.Now().Add(-time.Duration(hours) * time.Hour)
+	anomalies, err := core.GetAuthAnomalies(r.ctx, s.db, since, limit)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(anomalies)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+)
+
+// maintenanceTTL is how long the maintenance mode loaded from the database is
+// cached for (so that changes, including those made with the maintenance
+// command, reach all instances soon).
+const maintenanceTTL = 5 * time.Second
+
+// defaultMaintenanceRetryAfter is the Retry-After of the responses to requests
+// refused in read-only mode, if the end of the maintenance is not known.
+const defaultMaintenanceRetryAfter = time.Minute
+
+// readOnlyExemptRoutes are the routes that accept changes even in read-only
+// mode, so that admins can log in and turn it off.
+var readOnlyExemptRoutes = map[string]bool{
+	"/api/_login":                   true,
+	"/api/_login/passkey/challenge": true,
+	"/api/_login/passkey":           true,
+	"/api/_admin/maintenance":       true,
+}
+
+// maintenanceCache is a cache of the maintenance mode of the site.
+type maintenanceCache struct {
+	load func(context.Context) (*core.Maintenance, error)
+
+	mu       sync.Mutex
+	m        *core.Maintenance
+	loadedAt time.Time
+}
+
+// get returns the maintenance mode, reloading it if the cache is stale. If it
+// fails to load (as it may, if the database is down for maintenance), the last
+// loaded maintenance mode is returned.
+func (mc *maintenanceCache) get(ctx context.Context) *core.Maintenance {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.m == nil || time.Since(mc.loadedAt) > maintenanceTTL {
+		m, err := mc.load(ctx)
+		if err != nil {
+			logger.ErrorContext(ctx, "Error loading maintenance mode", "err", err)
+			if mc.m == nil {
+				return &core.Maintenance{}
+			}
+			m = mc.m
+		}
+		mc.m, mc.loadedAt = m, time.Now()
+	}
+	return mc.m
+}
+
+// invalidate clears the cache.
+func (mc *maintenanceCache) invalidate() {
+	mc.mu.Lock()
+	mc.m = nil
+	mc.mu.Unlock()
+}
+
+// refuseInReadOnlyMode reports whether a request to the route path with method
+// is refused because of m.
+func refuseInReadOnlyMode(m *core.Maintenance, method, path string) bool {
+	if !m.ReadOnly || readOnlyExemptRoutes[unversionedPath(path)] {
+		return false
+	}
+	return method != "GET" && method != "HEAD" && method != "OPTIONS"
+}
+
+// writeMaintenanceError writes the response to a request refused in
+// read-only mode.
+func (s *Server) writeMaintenanceError(w http.ResponseWriter, r *http.Request, m *core.Maintenance) {
+	retryAfter := defaultMaintenanceRetryAfter
+	if m.EndsAt != nil && time.Until(*m.EndsAt) > 0 {
+		retryAfter = time.Until(*m.EndsAt)
+	}
+	message := "The site is down for maintenance and no changes can be made for now. Try again later."
+	if m.Message != "" {
+		message = m.Message
+	}
+	s.writeError(w, r, &httperr.Error{
+		HTTPStatus: http.StatusServiceUnavailable,
+		Code:       "maintenance",
+		Message:    message,
+		RetryAfter: ceilSeconds(retryAfter),
+	})
+}
+
+// /api/_admin/maintenance [GET, PUT]
+func (s *Server) handleMaintenance(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+
+	if r.req.Method == "PUT" {
+		m := &core.Maintenance{}
+		if err := r.unmarshalJSONBody(m); err != nil {
+			return err
+		}
+		if err := core.SaveMaintenance(r.ctx, s.db, m); err != nil {
+			return err
+		}
+		s.maintenance.invalidate()
+		logger.InfoContext(r.ctx, "Maintenance mode changed", "read_only", m.ReadOnly, "message", m.Message)
+	}
+
+	m, err := core.GetMaintenance(r.ctx, s.db)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(m)
+}
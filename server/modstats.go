@@ -0,0 +1,55 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// recordModAction records, for the mod stats, that the viewer took action as a
+// mod (or an admin) in community. Failures are only logged.
+func (s *Server) recordModAction(r *request, community uid.ID, action string) {
+	if err := core.RecordModAction(r.ctx, s.db, community, *r.viewer, action); err != nil {
+		logger.ErrorContext(r.ctx, "Failed to record mod action", "err", err, "action", action)
+	}
+}
+
+// recordModActionOn is recordModAction for an action on target that can be
+// undone (see core.RecordModActionOn).
+func (s *Server) recordModActionOn(r *request, community uid.ID, action string, target uid.ID) {
+	if err := core.RecordModActionOn(r.ctx, s.db, community, *r.viewer, action, target); err != nil {
+		logger.ErrorContext(r.ctx, "Failed to record mod action", "err", err, "action", action)
+	}
+}
+
+// recordBulkModAction is recordModAction for an action on count things at
+// once.
+func (s *Server) recordBulkModAction(r *request, community uid.ID, action string, count int) {
+	if err := core.RecordBulkModAction(r.ctx, s.db, community, *r.viewer, action, count); err != nil {
+		logger.ErrorContext(r.ctx, "Failed to record mod action", "err", err, "action", action, "count", count)
+	}
+}
+
+// /api/communities/{communityID}/mod_stats [GET]
+func (s *Server) getModStats(w *responseWriter, r *request) error {
+	comm, err := s.modOrAdminCommunity(r)
+	if err != nil {
+		return err
+	}
+	days := 30
+	if v := r.urlQuery().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 90 {
+			return httperr.NewBadRequest("invalid_days", "Days must be between 1 and 90.")
+		}
+		days = n
+	}
+	stats, err := core.GetModStats(r.ctx, s.db, comm.ID, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(stats)
+}
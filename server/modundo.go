@@ -0,0 +1,84 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// modUndoWindow returns how long mod actions can be undone for, or zero if
+// they can't be.
+func (s *Server) modUndoWindow() time.Duration {
+	return time.Duration(s.config.ModUndoWindow) * time.Second
+}
+
+// setPendingModAction tells the client the ID of the pending mod action a, if
+// it's not nil, with which to undo it, in the Pending-Mod-Action header.
+func setPendingModAction(w *responseWriter, a *core.PendingModAction) {
+	if a != nil {
+		w.Header().Set("Pending-Mod-Action", strconv.Itoa(a.ID))
+	}
+}
+
+// queueModAction makes action, which the viewer took, in their capacity as g,
+// on target in community, undoable (see core.QueueModAction). Failures are
+// only logged (since the action was taken).
+func (s *Server) queueModAction(w *responseWriter, r *request, community uid.ID, g core.UserGroup, action string, target uid.ID) {
+	pending, err := core.QueueModAction(r.ctx, s.db, community, *r.viewer, g, action, target, s.modUndoWindow())
+	if err != nil {
+		logger.ErrorContext(r.ctx, "Failed to queue mod action", "err", err, "action", action)
+		return
+	}
+	setPendingModAction(w, pending)
+}
+
+// /api/communities/{communityID}/pending_mod_actions [GET]
+func (s *Server) getPendingModActions(w *responseWriter, r *request) error {
+	comm, err := s.modOrAdminCommunity(r)
+	if err != nil {
+		return err
+	}
+	actions, err := core.GetPendingModActions(r.ctx, s.db, comm.ID)
+	if err != nil {
+		return err
+	}
+	if actions == nil {
+		actions = []*core.PendingModAction{}
+	}
+	return w.writeJSON(actions)
+}
+
+// /api/communities/{communityID}/pending_mod_actions/{actionID} [DELETE]
+func (s *Server) undoModAction(w *responseWriter, r *request) error {
+	comm, err := s.modOrAdminCommunity(r)
+	if err != nil {
+		return err
+	}
+	id, err := strconv.Atoi(r.muxVar("actionID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid mod action ID.")
+	}
+	action, err := core.UndoModAction(r.ctx, s.db, comm.ID, id)
+	if err != nil {
+		return err
+	}
+
+	switch action.Action {
+	case core.ModActionRemovePost, core.ModActionLockPost:
+		post, err := core.GetPost(r.ctx, s.db, &action.TargetID, "", nil, true)
+		if err != nil {
+			return err
+		}
+		purgePost(r, post)
+	case core.ModActionRemoveComment:
+		comment, err := core.GetComment(r.ctx, s.db, action.TargetID, nil)
+		if err != nil {
+			return err
+		}
+		purgeComment(r, comment.PostID, comment.AuthorID)
+	}
+	return w.writeJSON(action)
+}
@@ -11,6 +11,16 @@ import (
 )
 
 // /api/mutes [GET, POST, DELETE]
+type mutesResponse struct {
+	CommunityMutes []*core.Mute `json:"communityMutes"`
+	UserMutes      []*core.Mute `json:"userMutes"`
+}
+
+type muteRequest struct {
+	UserID      uid.ID `json:"userId"`
+	CommunityID uid.ID `json:"communityId"`
+}
+
 func (s *Server) handleMutes(w *responseWriter, r *request) error {
 	if !r.loggedIn {
 		return errNotLoggedIn
@@ -33,10 +43,7 @@ func (s *Server) handleMutes(w *responseWriter, r *request) error {
 			userMutes = []*core.Mute{}
 		}
 
-		response := struct {
-			CommunityMutes []*core.Mute `json:"communityMutes"`
-			UserMutes      []*core.Mute `json:"userMutes"`
-		}{commMutes, userMutes}
+		response := mutesResponse{commMutes, userMutes}
 
 		return json.NewEncoder(w).Encode(response)
 	}
@@ -47,10 +54,7 @@ func (s *Server) handleMutes(w *responseWriter, r *request) error {
 			return err
 		}
 	case "POST":
-		request := struct {
-			UserID      uid.ID `json:"userId"`
-			CommunityID uid.ID `json:"communityId"`
-		}{}
+		request := muteRequest{}
 		if err := r.unmarshalJSONBody(&request); err != nil {
 			return err
 		}
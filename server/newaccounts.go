@@ -0,0 +1,60 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+)
+
+// newAccountPolicy returns the restrictions on new accounts, or nil if there
+// are none.
+func (s *Server) newAccountPolicy() *core.NewAccountPolicy {
+	if s.config.NewAccountDays == 0 && s.config.NewAccountPoints == 0 {
+		return nil
+	}
+	communities := make([]string, len(s.config.NewAccountRestrictedCommunities))
+	for i, name := range s.config.NewAccountRestrictedCommunities {
+		communities[i] = strings.TrimSpace(name)
+	}
+	return &core.NewAccountPolicy{
+		MinAge:                time.Duration(s.config.NewAccountDays) * 24 * time.Hour,
+		MinPoints:             s.config.NewAccountPoints,
+		MaxPostsPerHour:       s.config.NewAccountMaxPostsPerHour,
+		MaxCommentsPerHour:    s.config.NewAccountMaxCommentsPerHour,
+		NoLinkPosts:           s.config.NewAccountNoLinkPosts,
+		RestrictedCommunities: communities,
+	}
+}
+
+// checkNewAccountPost returns an error if the logged in user, being a new
+// account, cannot create a post of postType in community.
+func (s *Server) checkNewAccountPost(r *request, community *core.Community, postType core.PostType) error {
+	policy := s.newAccountPolicy()
+	if policy == nil {
+		return nil
+	}
+	user, err := core.GetUser(r.ctx, s.db, *r.viewer, nil)
+	if err != nil {
+		return err
+	}
+	return policy.CheckPost(r.ctx, s.db, user, community, postType)
+}
+
+// checkNewAccountComment returns an error if the logged in user, being a new
+// account, cannot comment on post.
+func (s *Server) checkNewAccountComment(r *request, post *core.Post) error {
+	policy := s.newAccountPolicy()
+	if policy == nil {
+		return nil
+	}
+	user, err := core.GetUser(r.ctx, s.db, *r.viewer, nil)
+	if err != nil {
+		return err
+	}
+	community, err := core.GetCommunityByID(r.ctx, s.db, post.CommunityID, nil)
+	if err != nil {
+		return err
+	}
+	return policy.CheckComment(r.ctx, s.db, user, community)
+}
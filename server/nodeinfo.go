@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/gorilla/mux"
+)
+
+const (
+	nodeInfoSchema = "http://nodeinfo.diaspora.software/ns/schema/2.0"
+
+	// How long the usage statistics in the NodeInfo document are cached for.
+	nodeInfoMaxAge = 30 * time.Minute
+)
+
+// instanceStatsCache caches the (expensive to count) usage statistics of the
+// site.
+type instanceStatsCache struct {
+	mu        sync.Mutex
+	stats     *core.InstanceStats
+	fetchedAt time.Time
+}
+
+// registerNodeInfoRoutes registers the routes of NodeInfo, with which
+// directories of fediverse (and other) servers discover the site, on router.
+func (s *Server) registerNodeInfoRoutes(router *mux.Router) {
+	router.HandleFunc("/.well-known/nodeinfo", s.nodeInfoLinks).Methods("GET")
+	router.HandleFunc("/nodeinfo/2.0", s.nodeInfo).Methods("GET")
+}
+
+// softwareVersion returns the version of the running build: the version of
+// the main module, if it was built from a tagged release, or else the VCS
+// revision it was built from.
+func softwareVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			return "0.0.0-" + setting.Value[:12]
+		}
+	}
+	return "unknown"
+}
+
+func writeNodeInfoJSON(w http.ResponseWriter, v any, contentType string) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "Internal server error.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age=1800")
+	w.Write(data)
+}
+
+// /.well-known/nodeinfo [GET]
+func (s *Server) nodeInfoLinks(w http.ResponseWriter, r *http.Request) {
+	type link struct {
+		Rel  string `json:"rel"`
+		Href string `json:"href"`
+	}
+	writeNodeInfoJSON(w, map[string][]link{
+		"links": {{Rel: nodeInfoSchema, Href: s.absoluteURL(r, "/nodeinfo/2.0")}},
+	}, "application/json")
+}
+
+// /nodeinfo/2.0 [GET]
+func (s *Server) nodeInfo(w http.ResponseWriter, r *http.Request) {
+	s.instanceStats.mu.Lock()
+	if s.instanceStats.stats == nil || time.Since(s.instanceStats.fetchedAt) > nodeInfoMaxAge {
+		stats, err := core.GetInstanceStats(r.Context(), s.db)
+		if err != nil {
+			s.instanceStats.mu.Unlock()
+			logger.ErrorContext(r.Context(), "Failed to get instance stats", "err", err)
+			http.Error(w, "Internal server error.", http.StatusInternalServerError)
+			return
+		}
+		s.instanceStats.stats, s.instanceStats.fetchedAt = stats, time.Now()
+	}
+	stats := *s.instanceStats.stats
+	s.instanceStats.mu.Unlock()
+
+	protocols := []string{}
+	if s.config.Federation {
+		protocols = append(protocols, "activitypub")
+	}
+	doc := map[string]any{
+		"version": "2.0",
+		"software": map[string]string{
+			"name":    "discuit",
+			"version": softwareVersion(),
+		},
+		"protocols": protocols,
+		"services": map[string][]string{
+			"inbound":  {},
+			"outbound": {"atom1.0", "rss2.0"},
+		},
+		"openRegistrations": true,
+		"usage": map[string]any{
+			"users": map[string]int{
+				"total":          stats.Users,
+				"activeMonth":    stats.ActiveMonth,
+				"activeHalfyear": stats.ActiveHalfyear,
+			},
+			"localPosts":    stats.Posts,
+			"localComments": stats.Comments,
+		},
+		"metadata": map[string]string{
+			"nodeName":        s.config.SiteName,
+			"nodeDescription": s.config.SiteDescription,
+		},
+	}
+	writeNodeInfoJSON(w, doc, `application/json; profile="`+nodeInfoSchema+`#"`)
+}
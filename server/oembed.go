@@ -0,0 +1,94 @@
+package server
+
+import (
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/oembed"
+)
+
+const (
+	oEmbedDefaultWidth  = 550
+	oEmbedDefaultHeight = 180
+)
+
+var errOEmbedNotFound = httperr.NewNotFound("oembed_not_found", "No embeddable post at the URL.")
+
+// /api/oembed [GET]
+//
+// The oEmbed provider endpoint, for the posts of the site. The url query
+// parameter is the URL of the post page.
+func (s *Server) getOEmbed(w *responseWriter, r *request) error {
+	query := r.urlQuery()
+	if format := query.Get("format"); format != "" && format != "json" {
+		return &httperr.Error{HTTPStatus: http.StatusNotImplemented, Code: "unsupported_format", Message: "Only the json format is supported."}
+	}
+	u, err := url.Parse(query.Get("url"))
+	if err != nil || u.Path == "" {
+		return errOEmbedNotFound
+	}
+
+	// /{communityName}/post/{postID}, with perhaps a comment ID after.
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 3 || parts[1] != "post" {
+		return errOEmbedNotFound
+	}
+	post, err := core.GetPost(r.ctx, s.db, nil, parts[2], nil, false)
+	if err != nil {
+		if isNotFound(err) {
+			return errOEmbedNotFound
+		}
+		return err
+	}
+	if !strings.EqualFold(post.CommunityName, parts[0]) {
+		return errOEmbedNotFound
+	}
+	comm, err := core.GetCommunityByID(r.ctx, s.db, post.CommunityID, nil)
+	if err != nil {
+		return err
+	}
+	if comm.NSFW {
+		return errOEmbedNotFound
+	}
+
+	width, height := oEmbedDefaultWidth, oEmbedDefaultHeight
+	if n, err := strconv.Atoi(query.Get("maxwidth")); err == nil && n > 0 && n < width {
+		width = n
+	}
+	if n, err := strconv.Atoi(query.Get("maxheight")); err == nil && n > 0 && n < height {
+		height = n
+	}
+
+	postURL := s.absoluteURL(r.req, "/"+post.CommunityName+"/post/"+post.PublicID)
+	authorURL := s.absoluteURL(r.req, "/@"+post.AuthorUsername)
+	res := &oembed.Response{
+		Type:         "rich",
+		Version:      "1.0",
+		Title:        post.Title,
+		AuthorName:   post.AuthorUsername,
+		AuthorURL:    authorURL,
+		ProviderName: s.config.SiteName,
+		ProviderURL:  s.absoluteURL(r.req, "/"),
+		CacheAge:     3600,
+		Width:        oembed.Dimension(width),
+		Height:       oembed.Dimension(height),
+		HTML: `<blockquote class="discuit-embed"><p><a href="` + html.EscapeString(postURL) + `">` + html.EscapeString(post.Title) +
+			`</a></p>&mdash; <a href="` + html.EscapeString(authorURL) + `">@` + html.EscapeString(post.AuthorUsername) +
+			`</a> in <a href="` + html.EscapeString(s.absoluteURL(r.req, "/"+post.CommunityName)) + `">` + html.EscapeString(post.CommunityName) +
+			`</a></blockquote>`,
+	}
+	switch {
+	case post.Image != nil && post.Image.URL != nil:
+		res.ThumbnailURL = s.absoluteURL(r.req, *post.Image.URL)
+	case post.Link != nil && post.Link.Image != nil && post.Link.Image.URL != nil:
+		res.ThumbnailURL = s.absoluteURL(r.req, *post.Link.Image.URL)
+	case post.Link != nil && post.Link.Embed != nil:
+		res.ThumbnailURL = post.Link.Embed.ThumbnailURL
+	}
+	return w.writeJSON(res)
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/openapi"
+	msql "github.com/discuitnet/discuit/internal/sql"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// apiRoute is a route of the API, along with the description of its
+// parameters and responses from which the OpenAPI document of the API is
+// generated (see /api/openapi.json).
+type apiRoute struct {
+	path    string // path template
+	methods []string
+
+	summary     string
+	queryParams []string
+	body        any  // a value of the type of the (JSON) request body
+	response    any  // a value of the type of the (JSON) response
+	streaming   bool // if the response is a stream of server-sent events
+	public      bool // if GET responses to logged out users may be cached
+
+	// The maximum size of the request body (if zero, that of the config), and
+	// whether the body is a file upload (see withBodyLimit).
+	maxBodySize int64
+	upload      bool
+}
+
+// handle registers the API route path, for methods, with the handler h. Use the
+// methods of the returned apiRoute to describe the route.
+func (s *Server) handle(path string, h handler, methods ...string) *apiRoute {
+	route := &apiRoute{path: path, methods: methods}
+	s.router.Handle(path, s.withHandler(h, route)).Methods(methods...)
+	s.apiRoutes = append(s.apiRoutes, route)
+	return route
+}
+
+// doc sets the summary of the route.
+func (route *apiRoute) doc(summary string) *apiRoute {
+	route.summary = summary
+	return route
+}
+
+// query sets the names of the URL query parameters the route accepts.
+func (route *apiRoute) query(names ...string) *apiRoute {
+	route.queryParams = names
+	return route
+}
+
+// accepts sets the type of the request body to that of v. It applies to all
+// methods of the route other than GET.
+func (route *apiRoute) accepts(v any) *apiRoute {
+	route.body = v
+	return route
+}
+
+// returns sets the type of the response to that of v.
+func (route *apiRoute) returns(v any) *apiRoute {
+	route.response = v
+	return route
+}
+
+// streams marks the route as one that responds with a stream of server-sent
+// events.
+func (route *apiRoute) streams() *apiRoute {
+	route.streaming = true
+	return route
+}
+
+// cacheable marks the route as one whose GET responses to logged out users
+// are the same for all of them, and so
+// may be cached by the browser and by a CDN (see writeCacheable).
+func (route *apiRoute) cacheable() *apiRoute {
+	route.public = true
+	return route
+}
+
+// limitBody sets the maximum size of the request body of the route to n bytes,
+// instead of config.MaxRequestBodySize.
+func (route *apiRoute) limitBody(n int) *apiRoute {
+	route.maxBodySize = int64(n)
+	return route
+}
+
+// uploads marks the route as one that accepts file uploads (as
+// multipart/form-data) of at most n bytes.
+func (route *apiRoute) uploads(n int) *apiRoute {
+	route.maxBodySize = int64(n)
+	route.upload = true
+	return route
+}
+
+// operationID returns a name for the operation of the route with method (for
+// example, getPostsPostIDComments for GET /api/posts/{postID}/comments).
+func (route *apiRoute) operationID(method string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.Split(strings.TrimPrefix(route.path, "/api/"), "/") {
+		capitalize := true
+		for _, c := range part {
+			if c == '{' || c == '}' || c == '_' || c == '.' {
+				capitalize = true
+				continue
+			}
+			if capitalize {
+				c = unicode.ToUpper(c)
+				capitalize = false
+			}
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// tag returns the first part of the path of the route (after /api), which is
+// used to group the routes in the OpenAPI document.
+func (route *apiRoute) tag() string {
+	part, _, _ := strings.Cut(strings.TrimPrefix(route.path, "/api/"), "/")
+	return strings.TrimPrefix(part, "_")
+}
+
+// pathParams returns the names of the variables in the path template of the
+// route.
+func (route *apiRoute) pathParams() []string {
+	var names []string
+	for _, part := range strings.Split(route.path, "/") {
+		if name, ok := strings.CutPrefix(part, "{"); ok {
+			name, _, _ = strings.Cut(strings.TrimSuffix(name, "}"), ":")
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// newSchemaGenerator returns an openapi.Generator that knows the JSON encodings
+// of the types of this module that implement json.Marshaler.
+func newSchemaGenerator() *openapi.Generator {
+	g := openapi.NewGenerator()
+	g.Define(msql.NullString{}, &openapi.Schema{Type: "string", Nullable: true})
+	g.Define(msql.NullTime{}, &openapi.Schema{Type: "string", Format: "date-time", Nullable: true})
+	g.Define(msql.NullInt32{}, &openapi.Schema{Type: "integer", Format: "int32", Nullable: true})
+	g.Define(msql.NullFloat64{}, &openapi.Schema{Type: "number", Nullable: true})
+	g.Define(msql.NullBool{}, &openapi.Schema{Type: "boolean", Nullable: true})
+	g.Define(uid.NullID{}, &openapi.Schema{Type: "string", Nullable: true})
+	return g
+}
+
+// openAPIDocument returns the OpenAPI document of the unversioned API, which is
+// generated from s.apiRoutes.
+func (s *Server) openAPIDocument() *openapi.Document {
+	g := newSchemaGenerator()
+	errorResponse := &openapi.Response{
+		Description: "Error",
+		Content:     openapi.JSONContent(g.SchemaOf(httperr.Error{})),
+	}
+
+	doc := &openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title: s.config.SiteName + " API",
+			Description: "The routes are also available under " + apiV1Prefix + ", where responses are " +
+				"wrapped in an object with data, error, and cursor fields.",
+			Version: "1",
+		},
+		Paths: make(map[string]openapi.PathItem),
+		Components: openapi.Components{
+			SecuritySchemes: map[string]*openapi.SecurityScheme{
+				"session": {Type: "apiKey", In: "cookie", Name: s.config.SessionCookieName},
+				"token":   {Type: "http", Scheme: "bearer"},
+			},
+		},
+		Security: []map[string][]string{{"session": {}}, {"token": {}}},
+	}
+
+	for _, route := range s.apiRoutes {
+		item, ok := doc.Paths[route.path]
+		if !ok {
+			item = openapi.PathItem{}
+			doc.Paths[route.path] = item
+		}
+		for _, method := range route.methods {
+			op := &openapi.Operation{
+				OperationID: route.operationID(method),
+				Summary:     route.summary,
+				Tags:        []string{route.tag()},
+				Responses:   map[string]*openapi.Response{"default": errorResponse},
+			}
+			for _, name := range route.pathParams() {
+				op.Parameters = append(op.Parameters, &openapi.Parameter{
+					Name:     name,
+					In:       "path",
+					Required: true,
+					Schema:   &openapi.Schema{Type: "string"},
+				})
+			}
+			for _, name := range route.queryParams {
+				op.Parameters = append(op.Parameters, &openapi.Parameter{
+					Name:   name,
+					In:     "query",
+					Schema: &openapi.Schema{Type: "string"},
+				})
+			}
+			if route.body != nil && method != "GET" {
+				op.RequestBody = &openapi.RequestBody{Content: openapi.JSONContent(g.SchemaOf(route.body))}
+			}
+			res := &openapi.Response{Description: "OK"}
+			if route.streaming {
+				res.Content = map[string]*openapi.MediaType{"text/event-stream": {Schema: &openapi.Schema{Type: "string"}}}
+			} else if route.response != nil {
+				res.Content = openapi.JSONContent(g.SchemaOf(route.response))
+			}
+			op.Responses["200"] = res
+			item[strings.ToLower(method)] = op
+		}
+	}
+
+	doc.Components.Schemas = g.Schemas
+	return doc
+}
+
+// openAPICache holds the OpenAPI document of the API, which is generated on the
+// first request for it.
+type openAPICache struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// /api/openapi.json [GET]
+func (s *Server) getOpenAPIDocument(w *responseWriter, r *request) error {
+	s.openAPI.once.Do(func() {
+		doc := s.openAPIDocument()
+		s.openAPI.data, s.openAPI.err = json.MarshalIndent(doc, "", "  ")
+	})
+	if s.openAPI.err != nil {
+		return s.openAPI.err
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_, err := w.Write(s.openAPI.data)
+	return err
+}
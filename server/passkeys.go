@@ -0,0 +1,322 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/httputil"
+	"github.com/discuitnet/discuit/internal/webauthn"
+)
+
+// Keys of the session values of passkey ceremonies.
+const (
+	sessionPasskeyChallenge   = "passkey_challenge"    // Base64url encoded.
+	sessionPasskeyChallengeAt = "passkey_challenge_at" // Unix time.
+	sessionPasskeyCeremony    = "passkey_ceremony"     // Either "register" or "login".
+)
+
+// passkeyChallengeTTL is how long a passkey challenge may be answered for.
+const passkeyChallengeTTL = 5 * time.Minute
+
+var (
+	errPasskeysDisabled     = httperr.NewForbidden("passkeys/disabled", "Passkeys are not enabled.")
+	errPasskeyChallenge     = httperr.NewBadRequest("passkey/invalid-challenge", "The passkey challenge is invalid or has expired.")
+	errPasskeyVerification  = httperr.NewForbidden("passkey/verification-failed", "Passkey verification failed.")
+	errPasskeyLoginRequired = httperr.NewForbidden("login/passkey-required", "Log in with one of your passkeys.")
+)
+
+// relyingParty returns the WebAuthn relying party of the site, or an error if
+// passkeys are disabled.
+func (s *Server) relyingParty() (*webauthn.RelyingParty, error) {
+	if !s.config.Passkeys {
+		return nil, errPasskeysDisabled
+	}
+	return &webauthn.RelyingParty{
+		ID:               s.config.PasskeysRPID,
+		Name:             s.config.SiteName,
+		Origins:          s.config.PasskeysOrigins,
+		UserVerification: s.config.PasskeysUserVerification,
+	}, nil
+}
+
+// newPasskeyChallenge returns a new challenge for the passkey ceremony (either
+// register or login) of the session of r, replacing any previous one.
+func (s *Server) newPasskeyChallenge(w http.ResponseWriter, r *request, ceremony string) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	r.ses.Values[sessionPasskeyChallenge] = webauthn.EncodeBase64(challenge)
+	r.ses.Values[sessionPasskeyChallengeAt] = time.Now().Unix()
+	r.ses.Values[sessionPasskeyCeremony] = ceremony
+	return challenge, r.ses.Save(w, r.req)
+}
+
+// takePasskeyChallenge returns the challenge of the passkey ceremony of the
+// session of r, and removes it from the session (so that it's answered only
+// once).
+func (s *Server) takePasskeyChallenge(w http.ResponseWriter, r *request, ceremony string) ([]byte, error) {
+	encoded, _ := r.ses.Values[sessionPasskeyChallenge].(string)
+	at, ok := sessionInt64(r.ses, sessionPasskeyChallengeAt)
+	valid := encoded != "" && ok && r.ses.Values[sessionPasskeyCeremony] == ceremony && time.Since(time.Unix(at, 0)) < passkeyChallengeTTL
+
+	delete(r.ses.Values, sessionPasskeyChallenge)
+	delete(r.ses.Values, sessionPasskeyChallengeAt)
+	delete(r.ses.Values, sessionPasskeyCeremony)
+	if err := r.ses.Save(w, r.req); err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, errPasskeyChallenge
+	}
+	return webauthn.DecodeBase64(encoded)
+}
+
+// requirePasskeyLogin returns an error if user may not log in with their
+// password (see config.PasskeysRequiredForAdmins).
+func (s *Server) requirePasskeyLogin(r *request, user *core.User) error {
+	if !s.config.Passkeys || !s.config.PasskeysRequiredForAdmins || !user.Admin {
+		return nil
+	}
+	has, err := core.UserHasPasskeys(r.ctx, s.db, user.ID)
+	if err != nil {
+		return err
+	}
+	if has {
+		return errPasskeyLoginRequired
+	}
+	return nil
+}
+
+func decodeBase64Fields(fields ...*string) ([][]byte, error) {
+	decoded := make([][]byte, len(fields))
+	for i, f := range fields {
+		b, err := webauthn.DecodeBase64(*f)
+		if err != nil {
+			return nil, httperr.NewBadRequest("passkey/invalid-encoding", "Invalid base64url encoding.")
+		}
+		decoded[i] = b
+	}
+	return decoded, nil
+}
+
+// /api/passkeys/challenge [POST]
+func (s *Server) passkeyRegistrationChallenge(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	rp, err := s.relyingParty()
+	if err != nil {
+		return err
+	}
+	if err := s.requireRecentAuth(r); err != nil {
+		return err
+	}
+	user, err := core.GetUser(r.ctx, s.db, *r.viewer, r.viewer)
+	if err != nil {
+		return err
+	}
+	passkeys, err := core.GetPasskeys(r.ctx, s.db, user.ID)
+	if err != nil {
+		return err
+	}
+	var exclude []webauthn.CredentialDescriptor
+	for _, p := range passkeys {
+		exclude = append(exclude, webauthn.CredentialDescriptor{Type: "public-key", ID: webauthn.EncodeBase64(p.CredentialID)})
+	}
+
+	challenge, err := s.newPasskeyChallenge(w, r, "register")
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(rp.CreationOptions(challenge, user.ID.Bytes(), user.Username, exclude))
+}
+
+type registerPasskeyRequest struct {
+	Name              string `json:"name"`
+	ClientDataJSON    string `json:"clientDataJSON"`    // Base64url encoded.
+	AttestationObject string `json:"attestationObject"` // Base64url encoded.
+}
+
+// /api/passkeys [GET, POST]
+func (s *Server) handlePasskeys(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	if r.req.Method == "POST" {
+		rp, err := s.relyingParty()
+		if err != nil {
+			return err
+		}
+		req := registerPasskeyRequest{}
+		if err := r.unmarshalJSONBody(&req); err != nil {
+			return err
+		}
+		fields, err := decodeBase64Fields(&req.ClientDataJSON, &req.AttestationObject)
+		if err != nil {
+			return err
+		}
+		challenge, err := s.takePasskeyChallenge(w, r, "register")
+		if err != nil {
+			return err
+		}
+		cred, err := rp.VerifyRegistration(challenge, fields[0], fields[1])
+		if err != nil {
+			logger.InfoContext(r.ctx, "Passkey registration failed", "err", err, "user", r.viewer)
+			return errPasskeyVerification
+		}
+		passkey, err := core.AddPasskey(r.ctx, s.db, *r.viewer, req.Name, cred, s.config.MaxPasskeysPerUser)
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusCreated)
+		return w.writeJSON(passkey)
+	}
+
+	passkeys, err := core.GetPasskeys(r.ctx, s.db, *r.viewer)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(passkeys)
+}
+
+// /api/passkeys/{passkeyID} [PUT, DELETE]
+func (s *Server) handlePasskey(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	id, err := strconv.Atoi(r.muxVar("passkeyID"))
+	if err != nil {
+		return httperr.NewBadRequest("invalid_id", "Invalid ID.")
+	}
+
+	if r.req.Method == "DELETE" {
+		if err := s.requireRecentAuth(r); err != nil {
+			return err
+		}
+		if err := core.DeletePasskey(r.ctx, s.db, *r.viewer, id); err != nil {
+			return err
+		}
+		return w.writeJSON(anymap{"success": true})
+	}
+
+	passkey, err := core.GetPasskey(r.ctx, s.db, *r.viewer, id)
+	if err != nil {
+		return err
+	}
+	req := struct {
+		Name string `json:"name"`
+	}{}
+	if err := r.unmarshalJSONBody(&req); err != nil {
+		return err
+	}
+	if err := passkey.Rename(r.ctx, s.db, req.Name); err != nil {
+		return err
+	}
+	return w.writeJSON(passkey)
+}
+
+// /api/_login/passkey/challenge [POST]
+func (s *Server) passkeyLoginChallenge(w *responseWriter, r *request) error {
+	if r.loggedIn {
+		return httperr.NewBadRequest("already_logged_in", "You are already logged in")
+	}
+	rp, err := s.relyingParty()
+	if err != nil {
+		return err
+	}
+	req := struct {
+		Username string `json:"username"` // Optional (discoverable passkeys need no username).
+	}{}
+	if err := r.unmarshalJSONBody(&req); err != nil {
+		return err
+	}
+
+	var allow []webauthn.CredentialDescriptor
+	if req.Username != "" {
+		if user, err := core.GetUserByUsername(r.ctx, s.db, req.Username, nil); err == nil {
+			passkeys, err := core.GetPasskeys(r.ctx, s.db, user.ID)
+			if err != nil {
+				return err
+			}
+			for _, p := range passkeys {
+				allow = append(allow, webauthn.CredentialDescriptor{Type: "public-key", ID: webauthn.EncodeBase64(p.CredentialID)})
+			}
+		} else if !httperr.IsNotFound(err) {
+			return err
+		}
+	}
+
+	challenge, err := s.newPasskeyChallenge(w, r, "login")
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(rp.RequestOptions(challenge, allow))
+}
+
+type passkeyLoginRequest struct {
+	CredentialID      string `json:"credentialId"`      // Base64url encoded.
+	ClientDataJSON    string `json:"clientDataJSON"`    // Base64url encoded.
+	AuthenticatorData string `json:"authenticatorData"` // Base64url encoded.
+	Signature         string `json:"signature"`         // Base64url encoded.
+}
+
+// /api/_login/passkey [POST]
+func (s *Server) passkeyLogin(w *responseWriter, r *request) error {
+	if r.loggedIn {
+		return httperr.NewBadRequest("already_logged_in", "You are already logged in")
+	}
+	rp, err := s.relyingParty()
+	if err != nil {
+		return err
+	}
+
+	ip := httputil.GetIP(r.req)
+	if err := s.rateLimit(r, "login_passkey_"+ip, time.Hour, 20); err != nil {
+		return err
+	}
+
+	req := passkeyLoginRequest{}
+	if err := r.unmarshalJSONBody(&req); err != nil {
+		return err
+	}
+	fields, err := decodeBase64Fields(&req.CredentialID, &req.ClientDataJSON, &req.AuthenticatorData, &req.Signature)
+	if err != nil {
+		return err
+	}
+	challenge, err := s.takePasskeyChallenge(w, r, "login")
+	if err != nil {
+		return err
+	}
+
+	passkey, err := core.GetPasskeyByCredentialID(r.ctx, s.db, fields[0])
+	if err != nil {
+		if httperr.IsNotFound(err) {
+			return errPasskeyVerification
+		}
+		return err
+	}
+	signCount, backedUp, err := rp.VerifyAssertion(passkey.Credential(), challenge, fields[1], fields[2], fields[3])
+	if err != nil {
+		logger.InfoContext(r.ctx, "Passkey login failed", "err", err, "user", passkey.UserID, "ip", ip)
+		return errPasskeyVerification
+	}
+	if err := passkey.RecordUse(r.ctx, s.db, signCount, backedUp); err != nil {
+		return err
+	}
+
+	user, err := core.GetUser(r.ctx, s.db, passkey.UserID, nil)
+	if err != nil {
+		return err
+	}
+	if err := s.loginUser(user, r.ses, w, r.req); err != nil {
+		return err
+	}
+	s.recordLogin(r, user, ip, s.loginDevice(r, ip))
+
+	return w.writeJSON(user)
+}
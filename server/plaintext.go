@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/plaintext"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// wantsPlainText reports whether the client asked, either with format=text or
+// by preferring text/plain in the Accept header, for a plain text rendition
+// of the response (for screen readers and low-bandwidth clients). Responses
+// of routes that call it vary by the Accept header.
+func (r *request) wantsPlainText() bool {
+	if format := r.urlQueryValue("format"); format != "" {
+		return format == "text"
+	}
+	accept, _, _ := strings.Cut(r.req.Header.Get("Accept"), ",")
+	mediaType, _, err := mime.ParseMediaType(accept)
+	return err == nil && mediaType == "text/plain"
+}
+
+// writePlainText writes text as a text/plain response.
+func (rw *responseWriter) writePlainText(text string) error {
+	rw.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	return rw.writeString(text)
+}
+
+// postPlainText returns the plain text rendition of post, followed by its
+// comments (those in post.Comments) in thread order.
+func postPlainText(post *core.Post) string {
+	var b strings.Builder
+	b.WriteString(post.Title + "\n")
+	fmt.Fprintf(&b, "Posted by @%s in %s, %s. %s, %s.\n", post.AuthorUsername, post.CommunityName,
+		plainTextTime(post.CreatedAt), plural(post.Upvotes-post.Downvotes, "point"), plural(post.NumComments, "comment"))
+
+	switch {
+	case post.DeletedContent:
+		b.WriteString("\n[deleted]\n")
+	case post.Type == core.PostTypeText:
+		if body := plaintext.FromMarkdown(post.Body.String); body != "" {
+			b.WriteString("\n" + body + "\n")
+		}
+	case post.Type == core.PostTypeLink && post.Link != nil:
+		b.WriteString("\nLink: " + post.Link.URL + "\n")
+	case post.Type == core.PostTypeImage:
+		b.WriteString("\nImage post.\n")
+	case post.Type == core.PostTypeVideo:
+		b.WriteString("\nVideo post.\n")
+	}
+
+	if len(post.Comments) > 0 {
+		b.WriteString("\nComments:\n")
+		byParent := make(map[uid.ID][]*core.Comment)
+		inPage := make(map[uid.ID]bool, len(post.Comments))
+		for _, c := range post.Comments {
+			inPage[c.ID] = true
+		}
+		var roots []*core.Comment
+		for _, c := range post.Comments {
+			if c.ParentID.Valid && inPage[c.ParentID.ID] {
+				byParent[c.ParentID.ID] = append(byParent[c.ParentID.ID], c)
+			} else {
+				roots = append(roots, c)
+			}
+		}
+		var write func(c *core.Comment, parent *core.Comment)
+		write = func(c *core.Comment, parent *core.Comment) {
+			b.WriteString("\n" + commentPlainText(c, parent))
+			for _, reply := range byParent[c.ID] {
+				write(reply, c)
+			}
+		}
+		for _, c := range roots {
+			write(c, nil)
+		}
+	}
+	return b.String()
+}
+
+// commentPlainText returns the plain text rendition of comment c. If parent
+// is not nil, c is said to be a reply to it.
+func commentPlainText(c *core.Comment, parent *core.Comment) string {
+	var b strings.Builder
+	if parent != nil {
+		fmt.Fprintf(&b, "Reply by @%s to @%s", c.AuthorUsername, parent.AuthorUsername)
+	} else {
+		fmt.Fprintf(&b, "Comment by @%s", c.AuthorUsername)
+	}
+	fmt.Fprintf(&b, ", %s. %s.\n", plainTextTime(c.CreatedAt), plural(c.Upvotes-c.Downvotes, "point"))
+	if c.Deleted() {
+		b.WriteString("[deleted]\n")
+	} else {
+		b.WriteString(plaintext.FromMarkdown(c.Body) + "\n")
+		if c.Image != nil {
+			b.WriteString("(With an image.)\n")
+		}
+	}
+	return b.String()
+}
+
+func plainTextTime(t time.Time) string {
+	return t.UTC().Format("2 January 2006, 15:04 UTC")
+}
+
+func plural(n int, noun string) string {
+	if n == 1 || n == -1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"strings"
@@ -8,6 +9,7 @@ import (
 
 	"github.com/discuitnet/discuit/core"
 	"github.com/discuitnet/discuit/internal/httperr"
+	msql "github.com/discuitnet/discuit/internal/sql"
 	"github.com/discuitnet/discuit/internal/uid"
 )
 
@@ -17,13 +19,6 @@ func (s *Server) addPost(w *responseWriter, r *request) error {
 		return errNotLoggedIn
 	}
 
-	if err := s.rateLimit(r, "add_post_1_"+r.viewer.String(), time.Second*10, 1); err != nil {
-		return err
-	}
-	if err := s.rateLimit(r, "add_post_2_"+r.viewer.String(), time.Hour*24, 70); err != nil {
-		return err
-	}
-
 	values, err := r.unmarshalJSONBodyToStringsMap(true)
 	if err != nil {
 		return err
@@ -40,11 +35,19 @@ func (s *Server) addPost(w *responseWriter, r *request) error {
 		// Disallow image post creation.
 		return httperr.NewForbidden("no_image_posts", "Image posts are not allowed")
 	}
+	if !s.config.EnableVideoPosts && postType == core.PostTypeVideo {
+		return httperr.NewForbidden("no_video_posts", "Video posts are not allowed.")
+	}
 
 	title := values["title"] // required
 	body := values["body"]
 	commName := values["community"] // required
 
+	license := core.PostLicense(values["license"])
+	if err := license.Validate(values["licenseText"]); err != nil {
+		return err
+	}
+
 	userGroup := core.UserGroupNormal
 	if text := values["userGroup"]; text != "" {
 		if err := userGroup.UnmarshalText([]byte(text)); err != nil {
@@ -56,21 +59,49 @@ func (s *Server) addPost(w *responseWriter, r *request) error {
 	if err != nil {
 		return err
 	}
+	if err := s.checkNewAccountPost(r, comm, postType); err != nil {
+		return err
+	}
+
+	var media uid.ID // The image or the video of the post.
+	switch postType {
+	case core.PostTypeText:
+	case core.PostTypeImage:
+		if media, err = uid.FromString(values["imageId"]); err != nil {
+			return httperr.NewInvalidField("imageId", "invalid_image_id", "Invalid image ID.")
+		}
+		if err := s.checkMediaStorageQuota(r, comm.ID, media); err != nil {
+			return err
+		}
+	case core.PostTypeLink:
+		if err := s.checkLinkDomains(r, comm.ID, values["url"]); err != nil {
+			return err
+		}
+	case core.PostTypeVideo:
+		if media, err = uid.FromString(values["videoId"]); err != nil {
+			return httperr.NewInvalidField("videoId", "invalid_video_id", "Invalid video ID.")
+		}
+		if err := s.checkMediaStorageQuota(r, comm.ID, media); err != nil {
+			return err
+		}
+	default:
+		return httperr.NewInvalidField("type", "invalid_post_type", "Invalid post type.")
+	}
+
+	if held, err := s.holdQuarantinedPost(w, r, userGroup, postType, comm.ID, title, body, values["url"], media); err != nil || held {
+		return err
+	}
 
 	var post *core.Post
 	switch postType {
 	case core.PostTypeText:
 		post, err = core.CreateTextPost(r.ctx, s.db, *r.viewer, comm.ID, title, body)
 	case core.PostTypeImage:
-		imageID, idErr := uid.FromString(values["imageId"])
-		if idErr != nil {
-			return httperr.NewBadRequest("invalid_image_id", "Invalid image ID.")
-		}
-		post, err = core.CreateImagePost(r.ctx, s.db, *r.viewer, comm.ID, title, imageID)
+		post, err = core.CreateImagePost(r.ctx, s.db, *r.viewer, comm.ID, title, media)
 	case core.PostTypeLink:
 		post, err = core.CreateLinkPost(r.ctx, s.db, *r.viewer, comm.ID, title, values["url"])
-	default:
-		return httperr.NewBadRequest("invalid_post_type", "Invalid post type.")
+	case core.PostTypeVideo:
+		post, err = core.CreateVideoPost(r.ctx, s.db, *r.viewer, comm.ID, title, media)
 	}
 	if err != nil {
 		return err
@@ -81,9 +112,17 @@ func (s *Server) addPost(w *responseWriter, r *request) error {
 			return err
 		}
 	}
+	if license != core.PostLicenseNone {
+		if err := post.SetLicense(r.ctx, *r.viewer, license, values["licenseText"]); err != nil {
+			return err
+		}
+	}
 
 	// +1 your own post.
 	post.Vote(r.ctx, *r.viewer, true)
+
+	s.publishPost(r.ctx, post.ID)
+	s.federatePost(r.ctx, post)
 	return w.writeJSON(post)
 }
 
@@ -95,9 +134,10 @@ func (s *Server) getPost(w *responseWriter, r *request) error {
 		return err
 	}
 
-	if _, err = post.GetComments(r.ctx, r.viewer, nil); err != nil {
+	if _, err = post.GetComments(r.ctx, r.viewer, "", nil); err != nil {
 		return err
 	}
+	s.markNewComments(r, post.ID, post.Comments)
 
 	if fetchCommunity := r.urlQueryValue("fetchCommunity"); fetchCommunity == "" || fetchCommunity == "true" {
 		comm, err := core.GetCommunityByID(r.ctx, s.db, post.CommunityID, r.viewer)
@@ -113,9 +153,40 @@ func (s *Server) getPost(w *responseWriter, r *request) error {
 		post.Community = comm
 	}
 
+	s.analytics.Add(core.AnalyticsPostView, post.CommunityName)
+	s.analytics.AddPost(post.ID, core.PostStatViews, 1)
+	w.addSurrogateKeys(postKey(post.ID), communityKey(post.CommunityID))
+	w.Header().Add("Vary", "Accept")
+	if r.wantsPlainText() {
+		return w.writePlainText(postPlainText(post))
+	}
 	return w.writeJSON(post)
 }
 
+// checkEditPreconditions returns core.ErrEditConflict if the post or comment
+// being edited, currently of version current, was edited since the client
+// loaded it: if the version the client edited (if given) is not current, or if
+// it was edited after the If-Unmodified-Since header of r (if set).
+func checkEditPreconditions(r *request, version *int, current int, editedAt msql.NullTime, createdAt time.Time) error {
+	if version != nil && *version != current {
+		return core.ErrEditConflict
+	}
+	if header := r.req.Header.Get("If-Unmodified-Since"); header != "" {
+		since, err := http.ParseTime(header)
+		if err != nil {
+			return nil // An invalid date is ignored (RFC 9110).
+		}
+		modified := createdAt
+		if editedAt.Valid {
+			modified = editedAt.Time
+		}
+		if modified.Truncate(time.Second).After(since) {
+			return core.ErrEditConflict
+		}
+	}
+	return nil
+}
+
 // /api/posts/:postID [PUT]
 func (s *Server) updatePost(w *responseWriter, r *request) error {
 	postID := r.muxVar("postID") // public post id
@@ -136,10 +207,18 @@ func (s *Server) updatePost(w *responseWriter, r *request) error {
 	action := query.Get("action")
 	if action == "" {
 		// Update post.
-		var tpost core.Post
+		var tpost struct {
+			core.Post
+			Version     *int              `json:"version"`     // The version edited (optional).
+			License     *core.PostLicense `json:"license"`     // Unchanged if omitted.
+			LicenseText string            `json:"licenseText"` // Of custom licenses.
+		}
 		if err = r.unmarshalJSONBody(&tpost); err != nil {
 			return err
 		}
+		if err = checkEditPreconditions(r, tpost.Version, post.Version, post.EditedAt, post.CreatedAt); err != nil {
+			return err
+		}
 		tpost.Title = strings.TrimSpace(tpost.Title)
 		tpost.Body.String = strings.TrimSpace(tpost.Body.String)
 
@@ -161,6 +240,11 @@ func (s *Server) updatePost(w *responseWriter, r *request) error {
 				return err
 			}
 		}
+		if tpost.License != nil && (*tpost.License != post.License || tpost.LicenseText != post.LicenseText.String) {
+			if err = post.SetLicense(r.ctx, *r.viewer, *tpost.License, tpost.LicenseText); err != nil {
+				return err
+			}
+		}
 	} else {
 		switch action {
 		case "lock", "unlock":
@@ -168,32 +252,79 @@ func (s *Server) updatePost(w *responseWriter, r *request) error {
 			if err = as.UnmarshalText([]byte(query.Get("lockAs"))); err != nil {
 				return err
 			}
+			if err = r.requireScope(core.ScopeMod); err != nil {
+				return err
+			}
 			if action == "lock" {
-				err = post.Lock(r.ctx, *r.viewer, as)
+				var until *time.Time
+				if v := query.Get("lockedUntil"); v != "" {
+					t, err := time.Parse(time.RFC3339, v)
+					if err != nil {
+						return httperr.NewBadRequest("invalid_locked_until", "Locked until must be an RFC 3339 time.")
+					}
+					until = &t
+				}
+				err = post.Lock(r.ctx, *r.viewer, as, query.Get("lockReason"), until)
 			} else {
 				err = post.Unlock(r.ctx, *r.viewer)
 			}
 			if err != nil {
 				return err
 			}
+			if action == "lock" && as != core.UserGroupNormal {
+				if s.modUndoWindow() > 0 {
+					s.queueModAction(w, r, post.CommunityID, as, core.ModActionLockPost, post.ID)
+				}
+				s.recordModActionOn(r, post.CommunityID, core.ModActionLockPost, post.ID)
+			} else if action == "unlock" {
+				s.recordModAction(r, post.CommunityID, core.ModActionUnlockPost)
+			}
 		case "changeAsUser":
 			var as core.UserGroup
 			if err = as.UnmarshalText([]byte(query.Get("userGroup"))); err != nil {
 				return err
 			}
+			if err = r.requireModScope(as); err != nil {
+				return err
+			}
 			if err = post.ChangeUserGroup(r.ctx, *r.viewer, as); err != nil {
 				return err
 			}
+		case "distinguish", "undistinguish":
+			as := core.UserGroupNormal
+			if action == "distinguish" {
+				if err = as.UnmarshalText([]byte(query.Get("as"))); err != nil {
+					return err
+				}
+			}
+			if err = r.requireScope(core.ScopeMod); err != nil {
+				return err
+			}
+			if err = post.Distinguish(r.ctx, *r.viewer, as); err != nil {
+				return err
+			}
+			if action == "distinguish" {
+				s.recordModAction(r, post.CommunityID, core.ModActionDistinguish)
+			} else {
+				s.recordModAction(r, post.CommunityID, core.ModActionUndistinguish)
+			}
 		case "pin", "unpin":
+			if err = r.requireScope(core.ScopeMod); err != nil {
+				return err
+			}
 			siteWide := strings.ToLower(query.Get("siteWide")) == "true"
 			if err = post.Pin(r.ctx, *r.viewer, siteWide, action == "unpin"); err != nil {
 				return err
 			}
+			if action == "pin" && !siteWide {
+				s.recordModAction(r, post.CommunityID, core.ModActionPinPost)
+			}
 		default:
 			return httperr.NewBadRequest("invalid_action", "Unsupported action.")
 		}
 	}
 
+	purgePost(r, post)
 	return w.writeJSON(post)
 }
 
@@ -218,6 +349,9 @@ func (s *Server) deletePost(w *responseWriter, r *request) error {
 	if err = as.UnmarshalText([]byte(query.Get("deleteAs"))); err != nil {
 		return err
 	}
+	if err = r.requireModScope(as); err != nil {
+		return err
+	}
 	deleteContent := false
 	if dc := strings.ToLower(query.Get("deleteContent")); dc != "" {
 		if dc == "true" {
@@ -226,27 +360,37 @@ func (s *Server) deletePost(w *responseWriter, r *request) error {
 			return httperr.NewBadRequest("", "deletedContent must be a bool.")
 		}
 	}
-	if err := post.Delete(r.ctx, *r.viewer, as, deleteContent); err != nil {
+	var pending *core.PendingModAction
+	if as != core.UserGroupNormal && !deleteContent && s.modUndoWindow() > 0 {
+		pending, err = post.DeleteUndoable(r.ctx, *r.viewer, as, s.modUndoWindow())
+	} else {
+		err = post.Delete(r.ctx, *r.viewer, as, deleteContent)
+	}
+	if err != nil {
 		return err
 	}
+	setPendingModAction(w, pending)
+	if as != core.UserGroupNormal {
+		s.recordModActionOn(r, post.CommunityID, core.ModActionRemovePost, post.ID)
+	}
+	s.federatePostDeletion(r.ctx, post)
+	purgePost(r, post)
 
 	return w.writeJSON(post)
 }
 
 // /api/_postVote [ POST ]
+type postVoteRequest struct {
+	PostID uid.ID `json:"postId"`
+	Up     bool   `json:"up"`
+}
+
 func (s *Server) postVote(w *responseWriter, r *request) error {
 	if !r.loggedIn {
 		return errNotLoggedIn
 	}
 
-	if err := s.rateLimitVoting(r, *r.viewer); err != nil {
-		return err
-	}
-
-	req := struct {
-		PostID uid.ID `json:"postId"`
-		Up     bool   `json:"up"`
-	}{Up: true}
+	req := postVoteRequest{Up: true}
 	if err := r.unmarshalJSONBody(&req); err != nil {
 		return err
 	}
@@ -256,18 +400,33 @@ func (s *Server) postVote(w *responseWriter, r *request) error {
 		return err
 	}
 
+	// The change in the upvotes and downvotes of the post.
+	var upvotes, downvotes int
+	count := func(up bool, n int) {
+		if up {
+			upvotes += n
+		} else {
+			downvotes += n
+		}
+	}
 	if post.ViewerVoted.Bool {
+		count(post.ViewerVotedUp.Bool, -1)
 		if req.Up == post.ViewerVotedUp.Bool {
 			err = post.DeleteVote(r.ctx, *r.viewer)
 		} else {
 			err = post.ChangeVote(r.ctx, *r.viewer, req.Up)
+			count(req.Up, 1)
 		}
 	} else {
 		err = post.Vote(r.ctx, *r.viewer, req.Up)
+		count(req.Up, 1)
 	}
 	if err != nil {
 		return err
 	}
+	s.analytics.Add(core.AnalyticsVote, "post")
+	s.analytics.AddPost(post.ID, core.PostStatUpvotes, upvotes)
+	s.analytics.AddPost(post.ID, core.PostStatDownvotes, downvotes)
 
 	return w.writeJSON(post)
 }
@@ -288,9 +447,8 @@ func (s *Server) imageUpload(w *responseWriter, r *request) error {
 		return err
 	}
 
-	r.req.Body = http.MaxBytesReader(w, r.req.Body, int64(s.config.MaxImageSize)) // limit max upload size
-	if err := r.req.ParseMultipartForm(int64(s.config.MaxImageSize)); err != nil {
-		return httperr.NewBadRequest("file_size_exceeded", "Max file size exceeded.")
+	if err := r.parseMultipartForm(); err != nil {
+		return err
 	}
 
 	file, _, err := r.req.FormFile("image")
@@ -304,10 +462,79 @@ func (s *Server) imageUpload(w *responseWriter, r *request) error {
 		return err
 	}
 
+	if err := s.checkStorageQuota(r, r.viewer, nil, int64(len(fileData))); err != nil {
+		return err
+	}
+
 	image, err := core.SavePostImage(r.ctx, s.db, *r.viewer, fileData)
 	if err != nil {
 		return err
 	}
+	s.checkDuplicateImage(r, image.ID)
 
 	return w.writeJSON(image.Image())
 }
+
+// /api/_videos [ POST ]
+func (s *Server) videoUpload(w *responseWriter, r *request) error {
+	if !s.config.EnableVideoPosts {
+		return httperr.NewForbidden("no_video_posts", "Video posts are not allowed.")
+	}
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+
+	if err := s.rateLimit(r, "video_uploads_1_"+r.viewer.String(), time.Second*10, 1); err != nil {
+		return err
+	}
+	if err := s.rateLimit(r, "video_uploads_2_"+r.viewer.String(), time.Hour*24, 10); err != nil {
+		return err
+	}
+
+	if err := s.checkStorageQuota(r, r.viewer, nil, max(r.req.ContentLength, 0)); err != nil {
+		return err
+	}
+
+	// Videos are streamed to disk rather than read into memory (the size of
+	// the body is limited by the route).
+	reader, err := r.req.MultipartReader()
+	if err != nil {
+		return httperr.NewBadRequest("invalid_multipart", "Request is not a multipart/form-data request.")
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				return httperr.NewBadRequest("no_video", "No video in request.")
+			}
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return err
+			}
+			return httperr.NewBadRequest("invalid_multipart", "Invalid multipart/form-data request.")
+		}
+		if part.FormName() != "video" {
+			continue
+		}
+		video, err := core.SaveVideo(r.ctx, s.db, *r.viewer, part, time.Duration(s.config.MaxVideoDuration)*time.Second)
+		if err != nil {
+			return err
+		}
+		return w.writeJSON(video)
+	}
+}
+
+// /api/_videos/{videoID} [ GET ]
+//
+// For polling the status of an uploaded video.
+func (s *Server) getVideo(w *responseWriter, r *request) error {
+	videoID, err := uid.FromString(r.muxVar("videoID"))
+	if err != nil {
+		return httperr.NewNotFound("video/not-found", "Video not found.")
+	}
+	video, err := core.GetVideo(r.ctx, s.db, videoID)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(video)
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/httputil"
+	"github.com/discuitnet/discuit/internal/ratelimits"
+)
+
+// Names of the rate limit policies applied to routes (see withRateLimit).
+const (
+	rateLimitLogin         = "login"
+	rateLimitSignup        = "signup"
+	rateLimitPostCreate    = "post_create"
+	rateLimitCommentCreate = "comment_create"
+	rateLimitVote          = "vote"
+	rateLimitFirehose      = "firehose"
+	rateLimitTranslate     = "translate"
+
+	// Applied, in addition to the usual limits, to the reports of unreliable
+	// reporters (see core.ReporterScore).
+	rateLimitUnreliableReport = "unreliable_report"
+)
+
+// defaultRateLimitPolicies are the rate limit policies used unless overridden
+// by the admins (with /api/_admin/rate_limits).
+var defaultRateLimitPolicies = map[string]ratelimits.Policy{
+	rateLimitLogin:         {Rate: 60, Interval: 3600, Burst: 10},
+	rateLimitSignup:        {Rate: 10, Interval: 6 * 3600, Burst: 2},
+	rateLimitPostCreate:    {Rate: 70, Interval: 24 * 3600, Burst: 5},
+	rateLimitCommentCreate: {Rate: 300, Interval: 24 * 3600, Burst: 10},
+	rateLimitVote:          {Rate: 2000, Interval: 24 * 3600, Burst: 60},
+	rateLimitFirehose:      {Rate: 60, Interval: 3600, Burst: 5},
+	rateLimitTranslate:     {Rate: 200, Interval: 24 * 3600, Burst: 20},
+
+	rateLimitUnreliableReport: {Rate: 3, Interval: 24 * 3600, Burst: 1},
+}
+
+// rateLimitPoliciesTTL is how long the policies loaded from the database are
+// cached for (so that changes made on one instance reach the others).
+const rateLimitPoliciesTTL = time.Minute
+
+// rateLimitPolicies is a cache of the rate limit policies in effect.
+type rateLimitPolicies struct {
+	load func(context.Context) (map[string]ratelimits.Policy, error)
+
+	mu       sync.Mutex
+	policies map[string]ratelimits.Policy
+	loadedAt time.Time
+}
+
+// get returns the policy named name, reloading the policies if the cache is
+// stale.
+func (rp *rateLimitPolicies) get(ctx context.Context, name string) (ratelimits.Policy, error) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.policies == nil || time.Since(rp.loadedAt) > rateLimitPoliciesTTL {
+		policies, err := rp.load(ctx)
+		if err != nil {
+			return ratelimits.Policy{}, err
+		}
+		rp.policies, rp.loadedAt = policies, time.Now()
+	}
+	return rp.policies[name], nil
+}
+
+// invalidate clears the cache.
+func (rp *rateLimitPolicies) invalidate() {
+	rp.mu.Lock()
+	rp.policies = nil
+	rp.mu.Unlock()
+}
+
+// loadRateLimitPolicies returns the default rate limit policies merged with
+// those set by the admins.
+func (s *Server) loadRateLimitPolicies(ctx context.Context) (map[string]ratelimits.Policy, error) {
+	overrides, err := core.GetRateLimitPolicies(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	policies := make(map[string]ratelimits.Policy, len(defaultRateLimitPolicies))
+	for name, p := range defaultRateLimitPolicies {
+		if o, ok := overrides[name]; ok && o.Validate() == nil {
+			p = o
+		}
+		policies[name] = p
+	}
+	return policies, nil
+}
+
+// skipRateLimits reports whether rate limits are not to be applied to r.
+func (s *Server) skipRateLimits(r *request) bool {
+	if s.config.DisableRateLimits {
+		return true
+	}
+	return s.config.AdminApiKey != "" && r.urlQueryValue("adminKey") == s.config.AdminApiKey
+}
+
+// withRateLimit returns a handler that applies the rate limit policy named
+// policy before calling h. Requests are limited per user if the user is
+// logged in, and per IP address otherwise. The state of the bucket is reported
+// in the RateLimit-* headers of the response.
+func (s *Server) withRateLimit(policy string, h handler) handler {
+	return func(w *responseWriter, r *request) error {
+		if err := s.takeRateLimit(w, r, policy); err != nil {
+			return err
+		}
+		return h(w, r)
+	}
+}
+
+// takeRateLimit takes a token, for the request r, from the bucket of the rate
+// limit policy named policy, and returns an error if there was none left (see
+// withRateLimit).
+func (s *Server) takeRateLimit(w *responseWriter, r *request, policy string) error {
+	if s.skipRateLimits(r) {
+		return nil
+	}
+
+	p, err := s.rateLimitPolicies.get(r.ctx, policy)
+	if err != nil {
+		return err
+	}
+
+	actor := "ip:" + httputil.GetIP(r.req)
+	if r.loggedIn {
+		actor = "user:" + r.viewer.String()
+	}
+
+	conn, err := s.redisPool.Dial()
+	if err != nil {
+		return err
+	}
+	res, err := ratelimits.Take(conn, policy+"_"+actor, p)
+	conn.Close()
+	if err != nil {
+		return err
+	}
+
+	header := w.Header()
+	header.Set("RateLimit-Policy", strconv.Itoa(p.Burst)+";w="+strconv.Itoa(int(p.Window().Seconds())))
+	header.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	if !res.Allowed {
+		return httperr.NewTooManyRequests("rate_limited", "Too many requests. Try again later.", res.RetryAfter)
+	}
+	return nil
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
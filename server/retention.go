@@ -0,0 +1,41 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+)
+
+type contentPurges struct {
+	// The retention policy, in days (see config.DeletedContentRetention).
+	Retention               int            `json:"retention"`
+	RetentionByJurisdiction map[string]int `json:"retentionByJurisdiction"`
+
+	Runs []*core.ContentPurgeRun `json:"runs"`
+}
+
+// /api/_admin/content_purges [GET]
+func (s *Server) getContentPurgeRuns(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	limit := 50
+	if v := r.urlQuery().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			return httperr.NewBadRequest("invalid_limit", "Limit must be between 1 and 500.")
+		}
+		limit = n
+	}
+
+	res := contentPurges{
+		Retention:               s.config.DeletedContentRetention,
+		RetentionByJurisdiction: s.config.DeletedContentRetentionByJurisdiction,
+	}
+	var err error
+	if res.Runs, err = core.GetContentPurgeRuns(r.ctx, s.db, limit); err != nil {
+		return err
+	}
+	return w.writeJSON(res)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/sharecard"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// /sitemap.xml [GET]
+func (s *Server) serveSitemapIndex(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	if err := core.WriteSitemapIndex(r.Context(), s.db, &b, "https://"+r.Host+"/sitemaps/"); err != nil {
+		s.writeSitemapError(w, r, err)
+		return
+	}
+	writeSitemapXML(w, b.Bytes())
+}
+
+// /sitemaps/{name}.xml [GET]
+func (s *Server) serveSitemap(w http.ResponseWriter, r *http.Request) {
+	sitemap, err := core.GetSitemap(r.Context(), s.db, mux.Vars(r)["name"])
+	if err != nil {
+		s.writeSitemapError(w, r, err)
+		return
+	}
+	var b bytes.Buffer
+	if err := sitemap.WriteXML(&b, "https://"+r.Host); err != nil {
+		s.writeSitemapError(w, r, err)
+		return
+	}
+	writeSitemapXML(w, b.Bytes())
+}
+
+func writeSitemapXML(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", "application/xml; charset=UTF-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(data)
+}
+
+func (s *Server) writeSitemapError(w http.ResponseWriter, r *http.Request, err error) {
+	if httperr.IsNotFound(err) {
+		http.Error(w, "404: Sitemap not found", http.StatusNotFound)
+		return
+	}
+	logger.ErrorContext(r.Context(), "Failed to serve sitemap", "err", err)
+	http.Error(w, "500: Internal server error", http.StatusInternalServerError)
+}
+
+// shareCardsTTL is how long the rendered share cards of posts (the images of
+// their previews on other sites) are kept.
+const shareCardsTTL = 24 * time.Hour
+
+// shareCards returns the cache of the share cards of posts, which are kept in
+// the images folder.
+func shareCards() *sharecard.Cache {
+	return &sharecard.Cache{Dir: filepath.Join(images.RootFolder(), "cards")}
+}
+
+// PruneShareCards deletes the share cards of posts rendered more than
+// shareCardsTTL ago.
+func PruneShareCards() error {
+	return shareCards().Prune(shareCardsTTL)
+}
+
+// /cards/posts/{postID}.png [GET]
+func (s *Server) servePostCard(w http.ResponseWriter, r *http.Request) {
+	post, err := core.GetPost(r.Context(), s.db, nil, mux.Vars(r)["postID"], nil, true)
+	if err == nil && post.Deleted {
+		err = httperr.NewNotFound("post/not-found", "Post not found.")
+	}
+	if err != nil {
+		if httperr.IsNotFound(err) {
+			http.Error(w, "404: Post not found", http.StatusNotFound)
+		} else {
+			logger.ErrorContext(r.Context(), "Failed to get post for share card", "err", err)
+			http.Error(w, "500: Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	card, err := shareCards().Get(post.PublicID, &sharecard.Card{
+		SiteName:  s.config.SiteName,
+		Community: post.CommunityName,
+		Title:     post.Title,
+		Points:    post.Upvotes - post.Downvotes,
+		Comments:  post.NumComments,
+	})
+	if err != nil {
+		logger.ErrorContext(r.Context(), "Failed to render share card", "err", err, "post", post.PublicID)
+		http.Error(w, "500: Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Content-Length", strconv.Itoa(len(card)))
+	w.Write(card)
+}
+
+// postStructuredData returns the structured data (a schema.org
+// DiscussionForumPosting, as JSON-LD) of the page of post, whose URL is
+// postURL. The image, if not empty, is the absolute URL of the image of the
+// post.
+func postStructuredData(post *core.Post, postURL, image string, absoluteURL func(string) string) ([]byte, error) {
+	type counter struct {
+		Type            string `json:"@type"`
+		InteractionType string `json:"interactionType"`
+		Count           int    `json:"userInteractionCount"`
+	}
+	data := map[string]any{
+		"@context":      "https://schema.org",
+		"@type":         "DiscussionForumPosting",
+		"headline":      post.Title,
+		"url":           postURL,
+		"datePublished": post.CreatedAt,
+		"author": map[string]any{
+			"@type": "Person",
+			"name":  post.AuthorUsername,
+			"url":   absoluteURL("/@" + post.AuthorUsername),
+		},
+		"isPartOf": absoluteURL("/" + post.CommunityName),
+		"interactionStatistic": []counter{
+			{"InteractionCounter", "https://schema.org/LikeAction", post.Upvotes},
+			{"InteractionCounter", "https://schema.org/CommentAction", post.NumComments},
+		},
+	}
+	if post.Body.Valid && post.Body.String != "" {
+		data["text"] = post.Body.String
+	}
+	if post.EditedAt.Valid {
+		data["dateModified"] = post.EditedAt.Time
+	}
+	if image != "" {
+		data["image"] = image
+	}
+	if post.Link != nil {
+		data["sharedContent"] = map[string]any{"@type": "WebPage", "url": post.Link.URL}
+	}
+	return json.Marshal(data) // Escapes <, >, and &, so it's safe in a script element.
+}
+
+// appendScriptTag appends a script element, of the type typ and with the
+// content text, to the head of doc.
+func appendScriptTag(doc *html.Node, typ, text string) {
+	head := findNodeElement(doc, "head")
+	script := &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Script,
+		Data:     "script",
+		Attr:     []html.Attribute{{Key: "type", Val: typ}},
+	}
+	script.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+	head.AppendChild(script)
+}
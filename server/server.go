@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
 	"database/sql"
@@ -14,6 +15,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -22,17 +24,36 @@ import (
 	"github.com/discuitnet/discuit/core"
 	"github.com/discuitnet/discuit/internal/httperr"
 	"github.com/discuitnet/discuit/internal/httputil"
+	"github.com/discuitnet/discuit/internal/i18n"
+	"github.com/discuitnet/discuit/internal/idp"
 	"github.com/discuitnet/discuit/internal/images"
+	"github.com/discuitnet/discuit/internal/logging"
+	"github.com/discuitnet/discuit/internal/mail"
+	"github.com/discuitnet/discuit/internal/oembed"
+	"github.com/discuitnet/discuit/internal/payments"
 	"github.com/discuitnet/discuit/internal/ratelimits"
 	"github.com/discuitnet/discuit/internal/sessions"
+	"github.com/discuitnet/discuit/internal/tracing"
+	"github.com/discuitnet/discuit/internal/translate"
 	"github.com/discuitnet/discuit/internal/uid"
-	"github.com/discuitnet/discuit/internal/utils"
+	"github.com/discuitnet/discuit/internal/videos"
+	"github.com/discuitnet/discuit/internal/webauthn"
 	"github.com/gomodule/redigo/redis"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
 )
 
+var (
+	logger = logging.Logger("server")
+	tracer = tracing.Tracer("github.com/discuitnet/discuit/server")
+)
+
 var (
 	errNotLoggedIn = &httperr.Error{
 		HTTPStatus: http.StatusUnauthorized,
@@ -50,7 +71,9 @@ type Server struct {
 	redisPool *redis.Pool
 
 	// for /api routes
-	router *mux.Router
+	router    *mux.Router
+	apiRoutes []*apiRoute // registered with handle
+	openAPI   openAPICache
 
 	// for all other routes
 	staticRouter *mux.Router
@@ -67,12 +90,34 @@ type Server struct {
 	http500LoggerFile *os.File
 
 	webPushVAPIDKeys core.VAPIDKeys
+
+	rateLimitPolicies *rateLimitPolicies
+
+	maintenance *maintenanceCache
+
+	firehose *firehose
+
+	mailer *mail.Mailer // Nil if email is not configured.
+
+	translations *i18n.Catalog // Nil if no translations folder is configured.
+
+	translator translate.Translator // Nil if translation is not configured.
+
+	identityProviders []*idp.Provider // For external logins.
+
+	paymentProviders map[string]payments.Provider // By name.
+
+	analytics     *core.AnalyticsCounter // Nil if analytics are off.
+	analyticsStop chan struct{}
+	analyticsDone chan struct{}
+
+	instanceStats instanceStatsCache // Of the NodeInfo document.
 }
 
 func New(db *sql.DB, conf *config.Config) (*Server, error) {
 	r := mux.NewRouter()
 
-	redisStore, err := sessions.NewRedisStore("tcp", conf.RedisAddress, conf.SessionCookieName)
+	redisStore, err := sessions.NewRedisStore("tcp", conf.RedisAddress, conf.RedisDB, conf.SessionCookieName)
 	if err != nil {
 		return nil, err
 	}
@@ -82,7 +127,9 @@ func New(db *sql.DB, conf *config.Config) (*Server, error) {
 		redisPool: &redis.Pool{
 			MaxIdle:     3,
 			IdleTimeout: 240 * time.Second,
-			Dial:        func() (redis.Conn, error) { return redis.Dial("tcp", conf.RedisAddress) },
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", conf.RedisAddress, redis.DialDatabase(conf.RedisDB))
+			},
 		},
 		router:       r,
 		staticRouter: mux.NewRouter(),
@@ -91,102 +138,671 @@ func New(db *sql.DB, conf *config.Config) (*Server, error) {
 		reactPath:    "./ui/dist/",
 		reactIndex:   "index.html",
 	}
+	redisStore.SameSite, redisStore.Secure = s.cookieSameSite(), s.cookieSecure
+	s.rateLimitPolicies = &rateLimitPolicies{load: s.loadRateLimitPolicies}
+	s.maintenance = &maintenanceCache{load: func(ctx context.Context) (*core.Maintenance, error) { return core.GetMaintenance(ctx, s.db) }}
+	s.firehose = &firehose{redisPool: s.redisPool, channel: firehoseChannel(conf.RedisDB)}
+	if !conf.AnalyticsOff {
+		s.analytics = core.NewAnalyticsCounter()
+		s.analyticsStop, s.analyticsDone = make(chan struct{}), make(chan struct{})
+		go s.flushAnalytics()
+	}
+	if conf.SMTPAddress != "" {
+		s.mailer = &mail.Mailer{
+			Addr:     conf.SMTPAddress,
+			Username: conf.SMTPUsername,
+			Password: conf.SMTPPassword,
+			From:     conf.EmailFrom,
+		}
+	}
+
+	s.paymentProviders = make(map[string]payments.Provider)
+	for _, p := range payments.Providers() {
+		s.paymentProviders[p.Name()] = p
+	}
+	if conf.StripeWebhookSecret != "" {
+		stripe := &payments.Stripe{WebhookSecret: conf.StripeWebhookSecret}
+		s.paymentProviders[stripe.Name()] = stripe
+	}
+
+	if conf.TranslationsFolder != "" {
+		if s.translations, err = i18n.Load(conf.TranslationsFolder); err != nil {
+			return nil, err
+		}
+	}
+
+	s.identityProviders = newIdentityProviders(conf)
+
+	if conf.TranslateURL != "" {
+		s.translator = &translate.HTTPTranslator{URL: conf.TranslateURL, Token: conf.TranslateToken}
+	}
 
 	if keys, err := core.GetApplicationVAPIDKeys(context.Background(), db); err != nil {
-		log.Printf("Error generating vapid keys: %v (you might want to run migrations)\n", err)
+		logger.Error("Failed to generate VAPID keys (you might want to run migrations)", "err", err)
 	} else {
 		s.webPushVAPIDKeys = *keys
-		core.EnablePushNotifications(keys, "discuit@previnder.com")
+		core.EnablePushNotifications(db, keys, "discuit@previnder.com")
 	}
 
 	s.openLoggers()
 
 	// API routes.
-	r.Handle("/api/_initial", s.withHandler(s.initial)).Methods("GET")
-	r.Handle("/api/_login", s.withHandler(s.login)).Methods("POST")
-	r.Handle("/api/_signup", s.withHandler(s.signup)).Methods("POST")
-	r.Handle("/api/_user", s.withHandler(s.getLoggedInUser)).Methods("GET")
-
-	r.Handle("/api/users/{username}", s.withHandler(s.getUser)).Methods("GET")
-	r.Handle("/api/users/{username}/feed", s.withHandler(s.getUsersFeed)).Methods("GET")
-	r.Handle("/api/users/{username}/pro_pic", s.withHandler(s.handleUserProPic)).Methods("POST", "DELETE")
-	r.Handle("/api/users/{username}/badges", s.withHandler(s.addBadge)).Methods("POST")
-	r.Handle("/api/users/{username}/badges/{badgeId}", s.withHandler(s.deleteBadge)).Methods("DELETE")
-
-	r.Handle("/api/mutes", s.withHandler(s.handleMutes)).Methods("GET", "POST", "DELETE")
-	r.Handle("/api/mutes/users/{mutedUserID}", s.withHandler(s.deleteUserMute)).Methods("DELETE")
-	r.Handle("/api/mutes/communities/{mutedCommunityID}", s.withHandler(s.deleteCommunityMute)).Methods("DELETE")
-	r.Handle("/api/mutes/{muteID}", s.withHandler(s.deleteMute)).Methods("DELETE")
-
-	r.Handle("/api/posts", s.withHandler(s.feed)).Methods("GET")
-	r.Handle("/api/posts", s.withHandler(s.addPost)).Methods("POST")
-	r.Handle("/api/posts/{postID}", s.withHandler(s.getPost)).Methods("GET")
-	r.Handle("/api/posts/{postID}", s.withHandler(s.updatePost)).Methods("PUT")
-	r.Handle("/api/posts/{postID}", s.withHandler(s.deletePost)).Methods("DELETE")
-	r.Handle("/api/_postVote", s.withHandler(s.postVote)).Methods("POST")
-	r.Handle("/api/_uploads", s.withHandler(s.imageUpload)).Methods("POST")
-
-	r.Handle("/api/posts/{postID}/comments", s.withHandler(s.getComments)).Methods("GET")
-	r.Handle("/api/posts/{postID}/comments", s.withHandler(s.addComment)).Methods("POST")
-	r.Handle("/api/posts/{postID}/comments/{commentID}", s.withHandler(s.updateComment)).Methods("PUT")
-	r.Handle("/api/posts/{postID}/comments/{commentID}", s.withHandler(s.deleteComment)).Methods("DELETE")
-	r.Handle("/api/comments/{commentID}", s.withHandler(s.getComment)).Methods("GET")
-	r.Handle("/api/_commentVote", s.withHandler(s.commentVote)).Methods("POST")
-
-	r.Handle("/api/communities", s.withHandler(s.getCommunities)).Methods("GET")
-	r.Handle("/api/communities", s.withHandler(s.createCommunity)).Methods("POST")
-	r.Handle("/api/_joinCommunity", s.withHandler(s.joinCommunity)).Methods("POST")
-	r.Handle("/api/communities/{communityID}", s.withHandler(s.getCommunity)).Methods("GET")
-	r.Handle("/api/communities/{communityID}", s.withHandler(s.updateCommunity)).Methods("PUT")
-
-	r.Handle("/api/communities/{communityID}/rules", s.withHandler(s.getCommunityRules)).Methods("GET")
-	r.Handle("/api/communities/{communityID}/rules", s.withHandler(s.addCommunityRule)).Methods("POST")
-	r.Handle("/api/communities/{communityID}/rules/{ruleID}", s.withHandler(s.getCommunityRule)).Methods("GET")
-	r.Handle("/api/communities/{communityID}/rules/{ruleID}", s.withHandler(s.updateCommunityRule)).Methods("PUT")
-	r.Handle("/api/communities/{communityID}/rules/{ruleID}", s.withHandler(s.deleteCommunityRule)).Methods("DELETE")
-
-	r.Handle("/api/communities/{communityID}/mods", s.withHandler(s.getCommunityMods)).Methods("GET")
-	r.Handle("/api/communities/{communityID}/mods", s.withHandler(s.addCommunityMod)).Methods("POST")
-	r.Handle("/api/communities/{communityID}/mods/{mod}", s.withHandler(s.removeCommunityMod)).Methods("DELETE")
-
-	r.Handle("/api/communities/{communityID}/reports", s.withHandler(s.getCommunityReports)).Methods("GET")
-	r.Handle("/api/communities/{communityID}/reports/{reportID}", s.withHandler(s.deleteReport)).Methods("DELETE")
-
-	r.Handle("/api/communities/{communityID}/banned", s.withHandler(s.handleCommunityBanned)).Methods("GET", "POST", "DELETE")
-
-	r.Handle("/api/communities/{communityID}/pro_pic", s.withHandler(s.handleCommunityProPic)).Methods("POST", "DELETE")
-	r.Handle("/api/communities/{communityID}/banner_image", s.withHandler(s.handleCommunityBannerImage)).Methods("POST", "DELETE")
-
-	r.Handle("/api/notifications", s.withHandler(s.getNotifications)).Methods("GET")
-	r.Handle("/api/notifications", s.withHandler(s.updateNotifications)).Methods("POST")
-	r.Handle("/api/notifications/{notificationID}", s.withHandler(s.getNotification)).Methods("GET", "PUT")
-	r.Handle("/api/notifications/{notificationID}", s.withHandler(s.deleteNotification)).Methods("DELETE")
-
-	r.Handle("/api/push_subscriptions", s.withHandler(s.pushSubscriptions)).Methods("POST")
-
-	r.Handle("/api/community_requests", s.withHandler(s.handleCommunityRequests)).Methods("GET", "POST")
-	r.Handle("/api/community_requests/{requestID}", s.withHandler(s.deleteCommunityRequest)).Methods("DELTE")
-
-	r.Handle("/api/_report", s.withHandler(s.report)).Methods("POST")
-
-	r.Handle("/api/_settings", s.withHandler(s.updateUserSettings)).Methods("POST")
-	r.Handle("/api/_settings", s.withHandler(s.deleteUser)).Methods("DELETE")
-
-	r.Handle("/api/_admin", s.withHandler(s.adminActions)).Methods("POST")
-
-	r.Handle("/api/_link_info", s.withHandler(s.getLinkInfo)).Methods("GET")
-
-	r.Handle("/api/analytics", s.withHandler(s.handleAnalytics)).Methods("POST")
+	s.handle("/api/_initial", s.initial, "GET").
+		doc("Get the data the web client needs on startup.").
+		returns(initialData{})
+	s.handle("/api/_login", s.withRateLimit(rateLimitLogin, s.login), "POST").
+		doc("Log in, or log out (with action=logout).").
+		query("action").
+		accepts(map[string]string{}).
+		returns(core.User{})
+	s.handle("/api/_login/passkey/challenge", s.passkeyLoginChallenge, "POST").
+		doc("Start logging in with a passkey. Returns the options for navigator.credentials.get. With a username, only the passkeys of the user are allowed.").
+		accepts(map[string]string{}).
+		returns(webauthn.RequestOptions{})
+	s.handle("/api/_login/passkey", s.withRateLimit(rateLimitLogin, s.passkeyLogin), "POST").
+		doc("Log in with the assertion of a passkey (with its binary fields base64url encoded), in response to the last challenge.").
+		accepts(passkeyLoginRequest{}).
+		returns(core.User{})
+	s.handle("/api/_login/external/{provider}", s.externalLogin, "GET").
+		doc("Redirect to an external identity provider (one of the loginProviders of the initial data) to log in, or, if logged in, to link the account at the provider.")
+	s.handle("/api/_login/external/{provider}/callback", s.externalLoginCallback, "GET").
+		doc("Complete logging in with an external identity provider (the provider redirects here), and redirect to the home page. Errors are passed in the error query parameter of the login page.")
+	s.handle("/api/_signup", s.withRateLimit(rateLimitSignup, s.signup), "POST").
+		doc("Create an account. The honeypot form fields (website, by default) must be left empty.").
+		accepts(map[string]string{}).
+		returns(core.User{})
+	s.handle("/api/_reauth", s.reauth, "POST").
+		doc("Confirm the password of the logged in user, as required (within the last reauthWindow minutes) for sensitive actions like changing the email address.").
+		accepts(map[string]string{})
+	s.handle("/api/_linked_accounts", s.handleLinkedAccounts, "GET", "POST").
+		doc("Get the accounts linked to the logged in user, or link one to it (with its username and password, after confirming the password of the logged in user with /api/_reauth). Linked accounts can be switched between with /api/_switch_account.").
+		accepts(map[string]string{}).
+		returns([]*core.LinkedAccount{})
+	s.handle("/api/_linked_accounts/{username}", s.unlinkAccount, "DELETE").
+		doc("Unlink an account from the logged in user. The unlink is recorded as an auth event (account_unlinked) of both accounts.")
+	s.handle("/api/_switch_account", s.switchAccount, "POST").
+		doc("Switch the session to an account (username) linked to the logged in user. The session gets a new ID and CSRF token, and the switch is recorded as an auth event (account_switched) of the account switched to.").
+		accepts(map[string]string{}).
+		returns(core.User{})
+	s.handle("/api/_user", s.getLoggedInUser, "GET").
+		doc("Get the logged in user.").
+		returns(core.User{})
+
+	s.handle("/api/users/{username}", s.getUser, "GET").
+		cacheable().
+		doc("Get a user. Fails with a 401 if the user's profile is visible only to logged in users (as are their points and feed).").
+		returns(core.User{})
+	s.handle("/api/users/{username}/points", s.getUserPoints, "GET").
+		cacheable().
+		doc("Get the points of a user broken down into those of their posts and of their comments, and by community (the communities in which they have the most points). Only the total is shown to others if the user hides their vote history, and no communities if they hide their community memberships.").
+		returns(core.UserPoints{})
+	s.handle("/api/users/{username}/feed", s.getUsersFeed, "GET").
+		cacheable().
+		doc("Get the posts and comments of a user.").
+		query("limit", "next").
+		returns(core.UserFeedResultSet{})
+	s.handle("/api/users/{username}/pro_pic", s.handleUserProPic, "POST", "DELETE").
+		doc("Upload (as multipart/form-data) or delete the profile picture of a user. The image may be cropped with the form fields cropX, cropY, cropWidth, and cropHeight; it's saved at 512x512.").
+		uploads(s.config.MaxImageSize).
+		returns(core.User{})
+	s.handle("/api/users/{username}/badges", s.addBadge, "POST").
+		doc("Give a user a badge (admins only).").
+		returns(core.Badges{})
+	s.handle("/api/users/{username}/badges/{badgeId}", s.deleteBadge, "DELETE").
+		doc("Take away a badge from a user (admins only).").
+		query("byType")
+
+	s.handle("/api/mutes", s.handleMutes, "GET", "POST", "DELETE").
+		doc("Get, add, or clear (with type) the mutes of the logged in user.").
+		query("type").
+		accepts(muteRequest{}).
+		returns(mutesResponse{})
+	s.handle("/api/mutes/users/{mutedUserID}", s.deleteUserMute, "DELETE").
+		doc("Unmute a user.")
+	s.handle("/api/mutes/communities/{mutedCommunityID}", s.deleteCommunityMute, "DELETE").
+		doc("Unmute a community.")
+	s.handle("/api/hidden_posts", s.handleHiddenPosts, "GET", "POST").
+		doc("Get the posts the logged in user hid from their feeds, the latest posts first, or hide a post. Posts are also hidden on voting on, or reporting, them if the user has the option (autoHideVotedPosts or autoHideReportedPosts) on.").
+		query("limit", "next").
+		accepts(hidePostRequest{}).
+		returns(core.HiddenPostsResultSet{})
+	s.handle("/api/hidden_posts/{postID}", s.unhidePost, "DELETE").
+		doc("Unhide a post.")
+	s.handle("/api/mutes/{muteID}", s.deleteMute, "DELETE").
+		doc("Delete a mute.")
+
+	s.handle("/api/leaderboards/{kind}", s.getLeaderboard, "GET").
+		cacheable().
+		doc("Get the top posts, comments, or users (by the points of their posts and comments) of a period: day, week (the default), month, year, or all. Recomputed periodically; ties are broken by ID, newer first.").
+		query("period", "limit").
+		returns(core.Leaderboard{})
+
+	s.handle("/api/posts", s.feed, "GET").
+		cacheable().
+		doc("Get a feed of posts.").
+		query("feed", "sort", "filter", "communityId", "limit", "next", "page").
+		returns(core.FeedResultSet{})
+	s.handle("/api/posts", s.withRateLimit(rateLimitPostCreate, s.addPost), "POST").
+		doc("Create a post. Its license (license) is either cc-by, cc0, or custom (with the terms in licenseText), if it has one.").
+		accepts(map[string]string{}).
+		returns(core.Post{})
+	s.handle("/api/posts/{postID}", s.getPost, "GET").
+		cacheable().
+		doc("Get a post, with its top comments. With format=text (or Accept: text/plain), a plain text rendition of the post and its comments is returned instead, with Markdown removed and links as footnotes.").
+		query("fetchCommunity", "format").
+		returns(core.Post{})
+	s.handle("/api/posts/{postID}", s.updatePost, "PUT").
+		doc("Edit a post, or lock, unlock, pin, unpin, distinguish, or undistinguish it (with action). Mods and admins distinguish a post (by a mod, or by an admin) as mods or admins with as. The license of the post is changed with license (and licenseText), if given. An edit fails with a 409 (edit_conflict) if the post was edited since the version given (the version field of the body), or since the If-Unmodified-Since header.").
+		query("action", "lockAs", "lockReason", "lockedUntil", "userGroup", "siteWide", "as").
+		accepts(core.Post{}).
+		returns(core.Post{})
+	s.handle("/api/posts/{postID}", s.deletePost, "DELETE").
+		doc("Delete a post.").
+		query("deleteAs", "deleteContent").
+		returns(core.Post{})
+	s.handle("/api/_postVote", s.withRateLimit(rateLimitVote, s.postVote), "POST").
+		doc("Vote on a post (voting the same way again undoes the vote).").
+		accepts(postVoteRequest{}).
+		returns(core.Post{})
+	s.handle("/api/_uploads", s.imageUpload, "POST").
+		doc("Upload an image (as multipart/form-data).").
+		uploads(s.config.MaxImageSize).
+		returns(images.Image{})
+	s.handle("/api/_uploads/comment_image", s.commentImageUpload, "POST").
+		doc("Upload an image or a GIF (as multipart/form-data) to attach to a comment, in communities that allow it.").
+		uploads(s.config.MaxImageSize).
+		returns(images.Image{})
+	s.handle("/api/_videos", s.videoUpload, "POST").
+		doc("Upload a video (as multipart/form-data, in the field video) to be transcoded.").
+		uploads(s.config.MaxVideoSize).
+		returns(core.Video{})
+	s.handle("/api/_videos/{videoID}", s.getVideo, "GET").
+		doc("Get an uploaded video (to poll its status).").
+		returns(core.Video{})
+	s.handle("/api/oembed", s.getOEmbed, "GET").
+		doc("Get the oEmbed response of a post, by the URL of its page.").
+		query("url", "format", "maxwidth", "maxheight").
+		returns(oembed.Response{})
+
+	s.handle("/api/posts/{postID}/stats", s.getPostStats, "GET").
+		doc("Get the views, votes, and comments of a post in each hour (or day) since it was posted. Only for its author (and admins). The interval is hour for posts younger than 3 days, and day otherwise, by default.").
+		query("interval").
+		returns(core.PostStats{})
+	s.handle("/api/posts/{postID}/participation", s.getPostParticipation, "GET").
+		doc("Get a summary of the participation in a post, for the mods of its community (and admins): its unique commenters and how many of them are new accounts, its votes in each of the last 24 hours, and its reports (those of its comments included).").
+		returns(core.PostParticipation{})
+	s.handle("/api/posts/{postID}/comments", s.getComments, "GET").
+		cacheable().
+		doc("Get the comments of a post, or the replies to a comment (with parentId). Sorted by sort (top, new, or controversial), or else by the default comment sort of the community. For logged in users, the comments added since their previous visit to the post (a visit ends after 30 minutes without requests) are marked with isNew. Consecutive replies of authors to their own comments share a chainId (the ID of the first comment of the chain). Comments more than 8 levels deep are left out, behind a continuation on their ancestor at the 8th level (see the thread of a comment).").
+		query("parentId", "next", "sort").
+		returns(commentsPage{})
+	s.handle("/api/posts/{postID}/comments", s.withRateLimit(rateLimitCommentCreate, s.addComment), "POST").
+		doc("Add a comment to a post.").
+		query("userGroup").
+		accepts(addCommentRequest{}).
+		returns(core.Comment{})
+	s.handle("/api/posts/{postID}/comments/{commentID}", s.updateComment, "PUT").
+		doc("Edit a comment, or change who it's posted as, or distinguish or undistinguish it (with action). Mods and admins distinguish a comment (by a mod, or by an admin) as mods or admins with as. An edit fails with a 409 (edit_conflict) if the comment was edited since the version given (the version field of the body), or since the If-Unmodified-Since header.").
+		query("action", "userGroup", "as").
+		accepts(core.Comment{}).
+		returns(core.Comment{})
+	s.handle("/api/posts/{postID}/comments/{commentID}", s.deleteComment, "DELETE").
+		doc("Delete a comment.").
+		query("deleteAs").
+		returns(core.Comment{})
+	s.handle("/api/posts/{postID}/comments/{commentID}/thread", s.getCommentThread, "GET").
+		cacheable().
+		doc("Get a comment, the comments it replies to (ancestors, from the root comment down), and a page of its replies down to 8 levels below it (at most 500 of them, the shallower first; the next page is fetched with the cursor next). Comments whose replies are left out, in the comments of a post or in a thread, have a continuation with the number of comments left out, which are fetched with the thread of the comment.").
+		query("next").
+		returns(core.CommentThread{})
+	s.handle("/api/posts/{postID}/comments/{commentID}/subtree", s.deleteCommentSubtree, "DELETE").
+		doc("Delete (remove) a comment and all the replies under it, those not already deleted, at once, as mods (the default) or admins (with deleteAs). Recorded as a single mod action, with the number of comments deleted.").
+		query("deleteAs").
+		returns(deleteSubtreeResponse{})
+	s.handle("/api/comments/{commentID}", s.getComment, "GET").
+		cacheable().
+		doc("Get a comment. With format=text (or Accept: text/plain), a plain text rendition of the comment is returned instead.").
+		query("format").
+		returns(core.Comment{})
+	s.handle("/api/comments/{commentID}/translation", s.withRateLimit(rateLimitTranslate, s.getCommentTranslation), "GET").
+		cacheable().
+		doc("Get the body of a comment translated to another language (a BCP 47 tag, like fr or pt-BR), as text and as HTML. Returns 404 if translation is not enabled on the site.").
+		query("lang").
+		returns(commentTranslation{})
+	s.handle("/api/_commentVote", s.withRateLimit(rateLimitVote, s.commentVote), "POST").
+		doc("Vote on a comment (voting the same way again undoes the vote).").
+		accepts(commentVoteRequest{}).
+		returns(core.Comment{})
+
+	s.handle("/api/communities", s.getCommunities, "GET").
+		cacheable().
+		doc("Get a list of communities, optionally only those in a category (by its slug). Sort is one of name_asc (the default), name_dsc, new, old, size, and activity (the most posts and comments in the last week first).").
+		query("q", "set", "category", "sort", "limit").
+		returns([]*core.Community{})
+	s.handle("/api/categories", s.getCategories, "GET").
+		cacheable().
+		doc("Get the categories communities are listed under, with the number of communities in each.").
+		returns([]*core.Category{})
+	s.handle("/api/communities", s.createCommunity, "POST").
+		doc("Create a community.").
+		accepts(map[string]string{}).
+		returns(core.Community{})
+	s.handle("/api/_joinCommunity", s.joinCommunity, "POST").
+		doc("Join or leave a community.").
+		accepts(joinCommunityRequest{}).
+		returns(core.Community{})
+	s.handle("/api/communities/{communityID}", s.getCommunity, "GET").
+		cacheable().
+		doc("Get a community (by name with byName=true).").
+		query("byName").
+		returns(core.Community{})
+	s.handle("/api/communities/{communityID}", s.updateCommunity, "PUT").
+		doc("Update a community (and, with categories, the slugs of the categories it's listed under, and with relatedCommunities, the names of the communities related to it).").
+		query("byName").
+		accepts(core.Community{}).
+		returns(core.Community{})
+
+	s.handle("/api/communities/{communityID}/rules", s.getCommunityRules, "GET").
+		cacheable().
+		doc("Get the rules of a community.").
+		returns([]*core.CommunityRule{})
+	s.handle("/api/communities/{communityID}/rules", s.addCommunityRule, "POST").
+		doc("Add a rule to a community.").
+		accepts(core.CommunityRule{}).
+		returns([]*core.CommunityRule{})
+	s.handle("/api/communities/{communityID}/rules/{ruleID}", s.getCommunityRule, "GET").
+		cacheable().
+		doc("Get a rule of a community.").
+		returns(core.CommunityRule{})
+	s.handle("/api/communities/{communityID}/rules/{ruleID}", s.updateCommunityRule, "PUT").
+		doc("Update a rule of a community.").
+		accepts(core.CommunityRule{}).
+		returns(core.CommunityRule{})
+	s.handle("/api/communities/{communityID}/rules/{ruleID}", s.deleteCommunityRule, "DELETE").
+		doc("Delete a rule of a community.").
+		returns(core.CommunityRule{})
+
+	s.handle("/api/communities/{communityID}/blocks", s.getCommunityBlocks, "GET").
+		cacheable().
+		doc("Get the blocks of the sidebar and the about page of a community, in order. Blocks are of the types rules, links, related (communities), and markdown. A community with no blocks has the default ones: its about text and its rules.").
+		returns([]*core.CommunityBlock{})
+	s.handle("/api/communities/{communityID}/blocks", s.addCommunityBlock, "POST").
+		doc("Add a block to a community (mods only), after its other blocks.").
+		accepts(core.CommunityBlock{}).
+		returns(core.CommunityBlock{})
+	s.handle("/api/communities/{communityID}/blocks/{blockID}", s.updateCommunityBlock, "PUT").
+		doc("Update a block of a community (mods only). Blocks are ordered by zIndex.").
+		accepts(core.CommunityBlock{}).
+		returns(core.CommunityBlock{})
+	s.handle("/api/communities/{communityID}/blocks/{blockID}", s.deleteCommunityBlock, "DELETE").
+		doc("Delete a block of a community (mods only).").
+		returns(core.CommunityBlock{})
+
+	s.handle("/api/communities/{communityID}/similar", s.getSimilarCommunities, "GET").
+		cacheable().
+		doc("Get the communities similar to a community: those its mods declared as related (with declared set) first, and then those with the most members in common with it (recomputed nightly).").
+		query("limit").
+		returns([]*core.SimilarCommunity{})
+
+	s.handle("/api/communities/{communityID}/mods", s.getCommunityMods, "GET").
+		cacheable().
+		doc("Get the moderators of a community.").
+		returns([]*core.User{})
+	s.handle("/api/communities/{communityID}/mods", s.addCommunityMod, "POST").
+		doc("Add a moderator to a community.").
+		accepts(map[string]string{}).
+		returns([]*core.User{})
+	s.handle("/api/communities/{communityID}/mods/{mod}", s.removeCommunityMod, "DELETE").
+		doc("Remove a moderator of a community.").
+		returns(core.User{})
+
+	s.handle("/api/communities/{communityID}/reports", s.getCommunityReports, "GET").
+		doc("Get the reports of a community, those of the most reliable reporters first (or, with sort=new, the newest first). Each has the reported post or comment as it is now (target) and as it was when it was reported (snapshot). Reports that went to the admins (see /api/_admin/reports) are left out.").
+		query("page", "filter", "sort").
+		returns(reportsPage{})
+	s.handle("/api/communities/{communityID}/reports/{reportID}", s.deleteReport, "DELETE").
+		doc("Dismiss a report, or, with upheld=true, resolve it as upheld (which counts towards the reliability of the reporter).").
+		query("upheld").
+		returns(core.Report{})
+	s.handle("/api/communities/{communityID}/mod_stats", s.getModStats, "GET").
+		doc("Get the workload of the mods of a community in the last days (30 by default, 90 at most): the actions of each mod, the median time reports took to be resolved, and the daily backlog of open reports.").
+		query("days").
+		returns(core.ModStats{})
+	s.handle("/api/communities/{communityID}/abuse_trends", s.getCommunityAbuseTrends, "GET").
+		doc("Get the reports, and the removals by mods and admins, of a community in the last days (30 by default), by report reason, in total and in each day. A removal counts under the reason the post or comment was most reported for.").
+		query("days").
+		returns(core.AbuseTrends{})
+
+	s.handle("/api/communities/{communityID}/banned", s.handleCommunityBanned, "GET", "POST", "DELETE").
+		doc("Get the users banned from a community, or ban or unban a user.").
+		accepts(map[string]string{})
+
+	s.handle("/api/communities/{communityID}/lists/{list}", s.handleCommunityList, "GET", "POST").
+		doc("Export a list of the users of a community (members, banned, or approved_submitters) as CSV, or import banned users or approved submitters in bulk from a CSV file (the request body) with a username column and, for bans, an optional expires column (RFC 3339 times). Invalid rows are reported and the rest imported; with dryRun=true, nothing is.").
+		query("dryRun").
+		returns(core.CommunityListImport{})
+
+	s.handle("/api/communities/{communityID}/pending_mod_actions", s.getPendingModActions, "GET").
+		doc("Get the removals, bans, and locks of the mods of a community that can still be undone (their notifications and the resolving of reports are held back until executeAt). The ID of such an action is also in the Pending-Mod-Action header of the response to it.").
+		returns([]core.PendingModAction{})
+
+	s.handle("/api/communities/{communityID}/pending_mod_actions/{actionID}", s.undoModAction, "DELETE").
+		doc("Undo a pending mod action, leaving no trace of it in the mod stats. Once executed, it takes the reverse action (an unban, say) to reverse it.").
+		returns(core.PendingModAction{})
+
+	s.handle("/api/communities/{communityID}/approved_submitters", s.handleCommunityApprovedSubmitters, "GET", "POST", "DELETE").
+		doc("Get the approved submitters of a community (whose posts and comments skip the automatic holds and the new-account restrictions in it), or add or remove one.").
+		accepts(map[string]string{})
+
+	s.handle("/api/communities/{communityID}/federation_blocks", s.handleCommunityFederationBlocks, "GET", "POST").
+		doc("Get the remote servers and accounts blocked from a community, or block one.").
+		accepts(federationBlockRequest{}).
+		returns([]*core.ActivityPubBlock{})
+	s.handle("/api/communities/{communityID}/federation_blocks/{blockID}", s.deleteCommunityFederationBlock, "DELETE").
+		doc("Remove a block of a remote server or account from a community.")
+
+	s.handle("/api/communities/{communityID}/link_domains", s.handleCommunityLinkDomains, "GET", "POST").
+		doc("Get the link domain rules of a community, or add one. A rule blocks (or, with allow, allows) links to the domains matching its pattern, in which a * matches any characters.").
+		accepts(linkDomainRuleRequest{}).
+		returns([]*core.LinkDomainRule{})
+	s.handle("/api/communities/{communityID}/link_domains/{ruleID}", s.deleteCommunityLinkDomainRule, "DELETE").
+		doc("Remove a link domain rule of a community.")
+	s.handle("/api/communities/{communityID}/flagged_images", s.getCommunityFlaggedImages, "GET").
+		doc("Get the posts and comments of a community whose images were flagged as near-duplicates of images uploaded by many other accounts.").
+		returns([]*core.FlaggedImage{})
+	s.handle("/api/communities/{communityID}/leaderboards/{kind}", s.getLeaderboard, "GET").
+		cacheable().
+		doc("Get the top posts, comments, or users of a community in a period (see /api/leaderboards/{kind}).").
+		query("period", "limit").
+		returns(core.Leaderboard{})
+	s.handle("/api/communities/{communityID}/cohorts", s.getCommunityCohorts, "GET").
+		doc("Get the daily cohorts of the users who joined a community in the last days (90 by default): how many joined, how many posted or commented in it within 7 days, and how many did 7 and 30 days later. Computed nightly; the counts whose window has not yet closed are null.").
+		query("days").
+		returns([]*core.Cohort{})
+
+	s.handle("/api/communities/{communityID}/pro_pic", s.handleCommunityProPic, "POST", "DELETE").
+		doc("Upload (as multipart/form-data) or delete the profile picture of a community. The image may be cropped with the form fields cropX, cropY, cropWidth, and cropHeight; it's saved at 512x512.").
+		uploads(s.config.MaxImageSize).
+		returns(core.Community{})
+	s.handle("/api/communities/{communityID}/banner_image", s.handleCommunityBannerImage, "POST", "DELETE").
+		doc("Upload (as multipart/form-data) or delete the banner image of a community. The image may be cropped with the form fields cropX, cropY, cropWidth, and cropHeight; it's saved at 1440x480.").
+		uploads(s.config.MaxImageSize).
+		returns(core.Community{})
+
+	s.handle("/api/notifications", s.getNotifications, "GET").
+		doc("Get the notifications of the logged in user.").
+		query("next").
+		returns(notificationsPage{})
+	s.handle("/api/notifications", s.updateNotifications, "POST").
+		doc("Reset the count of new notifications, mark all notifications as seen, or delete them all (with action).").
+		query("action", "type")
+	s.handle("/api/notifications/{notificationID}", s.getNotification, "GET", "PUT").
+		doc("Get a notification, or mark it as seen (with action=markAsSeen).").
+		query("action", "seen", "seenFrom").
+		returns(core.Notification{})
+	s.handle("/api/notifications/{notificationID}", s.deleteNotification, "DELETE").
+		doc("Delete a notification.").
+		returns(core.Notification{})
+
+	s.handle("/api/push_subscriptions", s.pushSubscriptions, "POST").
+		doc("Subscribe to web push notifications.")
+
+	s.handle("/api/community_requests", s.handleCommunityRequests, "GET", "POST").
+		doc("Get the requests for new communities (admins only), or request one.").
+		accepts(map[string]string{}).
+		returns([]*core.CommunityRequest{})
+	s.handle("/api/community_requests/{requestID}", s.deleteCommunityRequest, "DELETE").
+		doc("Delete a community request (admins only).")
+
+	s.handle("/api/_report", s.report, "POST").
+		doc("Report a post or a comment, for one of the report reasons (in the initial payload). The reports of reasons with toAdmins set go to the admins instead of the mods of the community.").
+		returns(core.Report{})
+
+	s.handle("/api/_storage", s.getStorageUsage, "GET").
+		doc("Get the storage used by the images and videos uploaded by the logged in user, and its quota.").
+		returns(core.StorageUsage{})
+	s.handle("/api/_supporter", s.getSupporterEntitlements, "GET").
+		doc("Get the supporter perks the logged in user has got for payments (made by them, or gifted by other users), the latest first.").
+		returns([]core.SupporterEntitlement{})
+	s.handle("/api/_onboarding", s.getOnboarding, "GET").
+		doc("Get the onboarding checklist of the logged in user (confirming their email, joining 3 communities, and making their first post and comment). Completing it earns the onboarded badge.").
+		returns(core.Onboarding{})
+	s.handle("/api/_settings", s.updateUserSettings, "POST").
+		doc("Update the settings of the logged in user, or change their password (with action).").
+		query("action").
+		returns(core.User{})
+	s.handle("/api/_settings", s.deleteUser, "DELETE").
+		doc("Delete the account of the logged in user.")
+	s.handle("/api/_settings/identities", s.getExternalIdentities, "GET").
+		doc("Get the accounts at external identity providers linked to the logged in user.").
+		returns([]*core.ExternalIdentity{})
+	s.handle("/api/_settings/identities/{identityID}", s.unlinkExternalIdentity, "DELETE").
+		doc("Unlink an account at an external identity provider (requires recent authentication). The last way to log in can't be unlinked.")
+	s.handle("/api/_settings/password_login", s.setPasswordLogin, "PUT").
+		doc("Enable (setting a new password) or disable password login for the logged in user (requires recent authentication). Disabling it requires a linked account or a passkey.").
+		accepts(passwordLoginRequest{}).
+		returns(core.User{})
+
+	s.handle("/api/_admin", s.adminActions, "POST").
+		doc("Perform an admin action.").
+		accepts(map[string]string{})
+	s.handle("/api/_admin/federation_blocks", s.handleSiteFederationBlocks, "GET", "POST").
+		doc("Get the remote servers and accounts blocked from the site, or block one.").
+		accepts(federationBlockRequest{}).
+		returns([]*core.ActivityPubBlock{})
+	s.handle("/api/_admin/federation_blocks/{blockID}", s.deleteSiteFederationBlock, "DELETE").
+		doc("Remove a site-wide block of a remote server or account.")
+	s.handle("/api/_admin/link_domains", s.handleSiteLinkDomains, "GET", "POST").
+		doc("Get the site-wide link domain rules, or add one.").
+		accepts(linkDomainRuleRequest{}).
+		returns([]*core.LinkDomainRule{})
+	s.handle("/api/_admin/link_domains/{ruleID}", s.deleteSiteLinkDomainRule, "DELETE").
+		doc("Remove a site-wide link domain rule.")
+	s.handle("/api/_admin/categories", s.createCategory, "POST").
+		doc("Add a category for communities to be listed under.").
+		accepts(core.Category{}).
+		returns(core.Category{})
+	s.handle("/api/_admin/categories/{categoryID}", s.handleCategory, "PUT", "DELETE").
+		doc("Update or delete a category (deleting it takes the communities in it out of it).").
+		accepts(core.Category{}).
+		returns(core.Category{})
+	s.handle("/api/_admin/top_link_domains", s.getTopLinkDomains, "GET").
+		doc("Get the domains most linked to in the last days (7 by default), and the site-wide rules that block them.").
+		query("days", "limit").
+		returns([]*core.LinkDomainStats{})
+	s.handle("/api/_admin/analytics", s.getAnalytics, "GET").
+		doc("Get the number of analytics events (page_view, post_view, vote, and signup) in the last days (7 by default), and, for the event requested, their number in each day or hour (interval), optionally of one dimension only, and the dimensions with the most events.").
+		query("event", "dimension", "days", "interval").
+		returns(analyticsResponse{})
+	s.handle("/api/_admin/search_queries", s.getSearchAnalytics, "GET").
+		doc("Get the number of searches in the last days (7 by default), in total and in each day, the share that found nothing, and the most common queries (and queries that found nothing). Queries are lowercased and stripped of email addresses, URLs, and long numbers; searches answered from the CDN cache are not counted.").
+		query("days", "limit").
+		returns(core.SearchAnalytics{})
+	s.handle("/api/_admin/cohorts", s.getCohorts, "GET").
+		doc("Get the daily signup cohorts of the last days (90 by default): how many users signed up, how many posted or commented within 7 days, and how many visited the site 7 and 30 days later. Computed nightly; the counts whose window has not yet closed are null.").
+		query("days").
+		returns([]*core.Cohort{})
+	s.handle("/api/_admin/abuse_trends", s.getAbuseTrends, "GET").
+		doc("Get the reports, and the removals by mods and admins, of the whole site in the last days (30 by default), by report reason, in total and in each day, and the communities with the most of them.").
+		query("days").
+		returns(core.AbuseTrends{})
+	s.handle("/api/_admin/experiments", s.handleExperiments, "GET", "POST").
+		doc("Get the experiments (feature flags and A/B tests), or create or update one.").
+		accepts(experimentRequest{}).
+		returns([]*core.Experiment{})
+	s.handle("/api/_admin/experiments/{name}", s.handleExperiment, "GET", "DELETE").
+		doc("Get the number of users first exposed to each variant of an experiment in the last days (30 by default), in total and in each day, or delete the experiment.").
+		query("days").
+		returns(core.ExperimentExposures{})
+	s.handle("/api/_admin/auth_anomalies", s.getAuthAnomalies, "GET").
+		doc("Get the accounts and IP addresses with the most failed logins in the last hours (24 by default), and the recent lockouts and logins from new devices.").
+		query("hours", "limit").
+		returns(core.AuthAnomalies{})
+	s.handle("/api/_admin/reports", s.getAdminReports, "GET").
+		doc("Get the reports that went to the admins instead of the mods of the community (those of report reasons with toAdmins set, such as illegal content), of all communities. Filter, sort, and the paging are as with the reports of a community.").
+		query("filter", "sort", "limit", "page").
+		returns(adminReportsPage{})
+	s.handle("/api/_admin/reports/{reportID}", s.resolveAdminReport, "DELETE").
+		doc("Resolve a report (of any community), as upheld with upheld=true, or else as dismissed.").
+		query("upheld").
+		returns(core.Report{})
+	s.handle("/api/_admin/reporters", s.getReporterScores, "GET").
+		doc("Get the reporter score of a user, or the scores of the least reliable reporters.").
+		query("username").
+		returns([]*core.ReporterScore{})
+	s.handle("/api/_admin/migrations", s.getMigrationsStatus, "GET").
+		doc("Get the status of the database migrations.")
+	s.handle("/api/_admin/log_levels", s.handleLogLevels, "GET", "PUT").
+		doc("Get or change the log levels of the modules.").
+		accepts(map[string]string{}).
+		returns(map[string]string{})
+	s.handle("/api/_admin/rate_limits", s.handleRateLimits, "GET", "PUT").
+		doc("Get or change the rate limit policies.").
+		accepts(map[string]*ratelimits.Policy{}).
+		returns(map[string]ratelimits.Policy{})
+	s.handle("/api/_admin/maintenance", s.handleMaintenance, "GET", "PUT").
+		doc("Get or change the maintenance mode of the site. In read-only mode, all requests that make changes (but logins and those to this route) fail with a 503 maintenance error.").
+		accepts(core.Maintenance{}).
+		returns(core.Maintenance{})
+	s.handle("/api/_admin/content_purges", s.getContentPurgeRuns, "GET").
+		doc("Get the last runs of the job that purges the archived titles and bodies of deleted posts and comments (as per deletedContentRetention), the latest first, with the retention policy.").
+		query("limit").
+		returns(contentPurges{})
+	s.handle("/api/_admin/counter_audits", s.getCounterAudits, "GET").
+		doc("Get the counters (like the numbers of comments of users) the nightly recount found off, and fixed, the latest first.").
+		query("limit").
+		returns([]*core.CounterAudit{})
+	s.handle("/api/_admin/directory_syncs", s.getDirectorySyncRuns, "GET").
+		doc("Get the last runs of the directory (LDAP) sync that changed anything or failed, the latest first.").
+		query("limit").
+		returns([]*core.DirectorySyncRun{})
+	s.handle("/api/_admin/translations", s.handleTranslations, "GET", "POST").
+		doc("Get the locales there are translations of (with the number of messages translated to each), or, with POST, reload the translation files first. Returns 404 if no translations folder is configured.")
+	s.handle("/api/_admin/storage", s.getSiteStorageUsage, "GET").
+		doc("Get the storage used by uploaded images and videos, and the users and communities that use the most of it.").
+		returns(siteStorageUsage{})
+	s.handle("/api/_admin/quarantined_images", s.getQuarantinedImages, "GET").
+		doc("Get the images withheld by the image scanners, pending review. Set the query parameter rejected to true to include rejected images.").
+		returns([]*images.QuarantinedImage{})
+	s.handle("/api/_admin/quarantined_images/{imageID}", s.reviewQuarantinedImage, "PUT").
+		doc("Approve (serve) or reject a quarantined image.").
+		accepts(quarantineReviewRequest{})
+	s.handle("/api/_admin/quarantined_images/{imageID}/file", s.getQuarantinedImageFile, "GET").
+		doc("Get the image file of a quarantined image.")
+	s.handle("/api/_admin/spam/comment_clusters", s.getCommentClusters, "GET").
+		doc("Get the clusters of near-identical comments posted or held in the last hours (24 by default) with at least minSize (3 by default) comments.").
+		query("hours", "minSize").
+		returns([]*core.CommentCluster{})
+	s.handle("/api/_admin/spam/image_clusters", s.getImageClusters, "GET").
+		doc("Get the clusters of near-duplicate images uploaded in the last hours (24 by default) by at least minUsers (2 by default) accounts.").
+		query("hours", "minUsers").
+		returns([]*core.ImageCluster{})
+	s.handle("/api/_admin/spam/held_comments", s.getHeldComments, "GET").
+		doc("Get the comments held, as part of floods of near-identical comments or as posted by quarantined accounts, pending review.").
+		returns([]*core.HeldComment{})
+	s.handle("/api/_admin/spam/held_comments/{heldCommentID}", s.reviewHeldComment, "PUT").
+		doc("Approve (and post) or reject a held comment.").
+		accepts(heldCommentReviewRequest{}).
+		returns(core.Comment{})
+	s.handle("/api/_admin/quarantine/users", s.getQuarantinedUsers, "GET").
+		doc("Get the quarantined accounts, whose posts and comments are held for review.").
+		returns([]*core.QuarantinedUser{})
+	s.handle("/api/_admin/quarantine/users/{username}", s.releaseQuarantinedUser, "DELETE").
+		doc("Lift the quarantine of an account.")
+	s.handle("/api/_admin/quarantine/posts", s.getHeldPosts, "GET").
+		doc("Get the posts of quarantined accounts pending review.").
+		returns([]*core.HeldPost{})
+	s.handle("/api/_admin/quarantine/posts/{heldPostID}", s.reviewHeldPost, "PUT").
+		doc("Approve (and post) or reject a held post.").
+		accepts(heldPostReviewRequest{}).
+		returns(core.Post{})
+
+	s.handle("/api/api_tokens", s.handleAPITokens, "GET", "POST").
+		doc("Get the personal access tokens of the logged in user, or create one.").
+		accepts(createAPITokenRequest{}).
+		returns([]*core.APIToken{})
+	s.handle("/api/api_tokens/{tokenID}", s.deleteAPIToken, "DELETE").
+		doc("Revoke an API token.")
+
+	s.handle("/api/passkeys", s.handlePasskeys, "GET", "POST").
+		doc("Get the passkeys of the logged in user, or register one with the response (with its binary fields base64url encoded) to the last challenge.").
+		accepts(registerPasskeyRequest{}).
+		returns([]*core.Passkey{})
+	s.handle("/api/passkeys/challenge", s.passkeyRegistrationChallenge, "POST").
+		doc("Start registering a passkey (requires recent authentication). Returns the options for navigator.credentials.create.").
+		returns(webauthn.CreationOptions{})
+	s.handle("/api/passkeys/{passkeyID}", s.handlePasskey, "PUT", "DELETE").
+		doc("Rename a passkey, or delete it (requires recent authentication).").
+		accepts(map[string]string{}).
+		returns(core.Passkey{})
+
+	s.handle("/api/oauth/clients", s.handleOAuthClients, "GET", "POST").
+		doc("Get the OAuth clients of the logged in user, or register one.").
+		accepts(createOAuthClientRequest{}).
+		returns([]*core.OAuthClient{})
+	s.handle("/api/oauth/clients/{clientID}", s.handleOAuthClient, "GET", "DELETE").
+		doc("Get or delete an OAuth client.").
+		returns(core.OAuthClient{})
+	s.handle("/api/oauth/authorize", s.oauthAuthorize, "POST").
+		doc("Authorize an OAuth client on behalf of the logged in user.").
+		accepts(oauthAuthorizeRequest{})
+	s.handle("/api/oauth/token", s.oauthToken, "POST").
+		doc("Exchange an authorization code, or a refresh token, for an access token (the OAuth2 token endpoint). Access tokens expire in an hour (expires_in seconds), after which they are renewed with the refresh token that comes with them; refresh tokens can be used once, within 90 days.")
+
+	s.handle("/api/_link_info", s.getLinkInfo, "GET").
+		doc("Get the title and the image of a link.").
+		query("url")
+
+	s.handle("/api/analytics", s.handleAnalytics, "POST").
+		doc("Record an analytics event: pwa_use, or page_view (with page, the kind of page viewed: home, all, community, post, user, search, or other).")
+
+	s.handle("/api/firehose", s.withRateLimit(rateLimitFirehose, s.firehoseStream), "GET").
+		doc("Stream (as server-sent events) all new posts and comments.").
+		query("communities", "type").
+		streams()
+
+	s.handle("/api/openapi.json", s.getOpenAPIDocument, "GET").
+		doc("Get this document.")
+
+	s.mountAPIV1(r)
 
 	r.NotFoundHandler = http.HandlerFunc(s.apiNotFoundHandler)
 	r.MethodNotAllowedHandler = http.HandlerFunc(s.apiMethodNotAllowedHandler)
 
 	images.HMACKey = []byte(conf.HMACSecret)
+	if conf.ImageProxy {
+		images.ProxyEnabled = true
+		s.staticRouter.Handle("/images/proxy", &images.Proxy{
+			MaxSize:  conf.ImageProxyMaxSize,
+			CacheTTL: time.Duration(conf.ImageProxyCacheTTL) * time.Hour,
+		})
+	}
 	s.staticRouter.PathPrefix("/images/").Handler(&images.Server{
-		SkipHashCheck: conf.IsDevelopment,
-		DB:            db,
+		SkipHashCheck:   conf.IsDevelopment,
+		DB:              db,
+		SignedURLExpiry: time.Duration(conf.S3SignedURLExpiry) * time.Second,
 	})
+	s.staticRouter.PathPrefix("/img/").Handler(&images.Server{
+		SkipHashCheck:   conf.IsDevelopment,
+		DB:              db,
+		NegotiateFormat: true,
+	})
+	s.staticRouter.PathPrefix("/videos/").Handler(http.StripPrefix("/videos", &videos.Server{}))
+	s.staticRouter.HandleFunc("/sitemap.xml", s.serveSitemapIndex)
+	s.staticRouter.HandleFunc("/cards/posts/{postID}.png", s.servePostCard)
+	s.staticRouter.HandleFunc("/sitemaps/{name}.xml", s.serveSitemap)
+	s.staticRouter.HandleFunc("/webhooks/payments/{provider}", s.paymentWebhook).Methods("POST")
+	if conf.NegotiateImageFormats {
+		images.FullImageURL = func(s string) string {
+			return "/img/" + s
+		}
+	}
 
+	s.registerFeedRoutes(s.staticRouter)
+	s.registerNodeInfoRoutes(s.staticRouter)
+	if conf.Federation {
+		s.registerActivityPubRoutes(s.staticRouter)
+	}
 	s.staticRouter.PathPrefix("/").HandlerFunc(s.serveSPA)
 	return s, nil
 }
@@ -233,6 +849,11 @@ func (s *Server) closeLoggers() {
 
 // Close closes the server.
 func (s *Server) Close() error {
+	if s.analytics != nil {
+		close(s.analyticsStop)
+		<-s.analyticsDone
+	}
+	core.DisablePushNotifications(s.db)
 	s.closeLoggers()
 	return s.sessions.Close()
 }
@@ -269,41 +890,119 @@ func updateUserLastSeen(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	return nil
 }
 
-func (s *Server) withHandler(h handler) http.Handler {
+func (s *Server) withHandler(h handler, route *apiRoute) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.withBodyLimit(w, r, route); err != nil {
+			s.writeError(w, r, err)
+			return
+		}
 		ses, err := s.sessions.Get(r)
 		if err != nil {
 			s.writeError(w, r, err)
 			return
 		}
 
-		s.setInitialCookies(w, r, ses)
+		// Responses to requests without a session (like those of crawlers, or
+		// those forwarded by a CDN that strips cookies) of cacheable routes may
+		// be cached by shared caches, and so they don't set any cookies.
+		cacheable := route.public && r.Method == "GET"
+		shared := cacheable && !ses.CookieSet && r.Header.Get("Authorization") == "" && s.config.PublicCacheMaxAge > 0
+
+		ctx := r.Context()
+		var path string
+		if route := mux.CurrentRoute(r); route != nil {
+			if path, err = route.GetPathTemplate(); err == nil {
+				ctx = logging.WithOperation(ctx, r.Method+" "+path)
+				span := trace.SpanFromContext(ctx)
+				span.SetName(r.Method + " " + path)
+				span.SetAttributes(attribute.String("http.route", path))
+			}
+		}
+		ctx, cancel := s.withDBTimeout(ctx, r.Method, route)
+		defer cancel()
+
+		// Requests with a bearer token are authenticated with the token
+		// instead of the session cookie.
+		var token *core.APIToken
+		if bearer, ok := bearerToken(r); ok {
+			if token, err = s.authenticateToken(ctx, r, route, bearer); err != nil {
+				s.writeError(w, r, err)
+				return
+			}
+			scope, ok := routeScope(r.Method, unversionedPath(path))
+			if !ok {
+				s.writeError(w, r, errSessionOnly)
+				return
+			}
+			if !token.Scopes.Has(scope) {
+				s.writeError(w, r, errInsufficientScope(scope))
+				return
+			}
+			ctx = logging.WithUserID(ctx, token.UserID.String())
+		} else {
+			if _, err := s.expireSession(w, r, ses); err != nil {
+				s.writeError(w, r, err)
+				return
+			}
+			if !shared {
+				s.setInitialCookies(w, r, ses)
+			}
+			if loggedIn, uid := isLoggedIn(ses); loggedIn {
+				ctx = logging.WithUserID(ctx, uid.String())
+			}
+			if locale, _ := ses.Values[sessionLocale].(string); locale != "" {
+				ctx = withLocale(ctx, locale)
+			}
+		}
+		r = r.WithContext(ctx)
 
-		if err := updateUserLastSeen(r.Context(), w, r, s.db, ses); err != nil { // could be changed by a csrf attack request
-			log.Printf("Error updating last seen value: %v\n", err)
+		maintenance := s.maintenance.get(ctx)
+		if refuseInReadOnlyMode(maintenance, r.Method, path) {
+			s.writeMaintenanceError(w, r, maintenance)
+			return
+		}
+
+		if token == nil && !maintenance.ReadOnly {
+			if err := updateUserLastSeen(ctx, w, r, s.db, ses); err != nil { // could be changed by a csrf attack request
+				logger.ErrorContext(ctx, "Failed to update last seen value", "err", err)
+			}
 		}
 
 		adminKey := r.URL.Query().Get("adminKey")
-		skipCsrfCheck := s.config.CSRFOff || adminKey == s.config.AdminApiKey || r.Method == "GET"
+		skipCsrfCheck := s.config.CSRFOff || adminKey == s.config.AdminApiKey || r.Method == "GET" || token != nil || unversionedPath(path) == "/api/oauth/token"
 		if !skipCsrfCheck {
-			csrftoken := r.Header.Get("X-Csrf-Token")
-			valid, _ := utils.ValidMAC(ses.ID, csrftoken, s.config.HMACSecret)
-			if !valid {
+			if err := s.checkOrigin(r); err != nil {
+				s.writeError(w, r, err)
+				return
+			}
+			if !s.validCSRFToken(ses, r, r.Header.Get("X-Csrf-Token")) {
 				s.writeErrorCustom(w, r, http.StatusUnauthorized, "", "")
 				return
 			}
 		}
 
-		if err = h(&responseWriter{w: w}, newRequest(r, ses)); err != nil {
-			s.writeError(w, r, err)
+		req := newRequest(r, ses)
+		if token != nil {
+			req.viewer, req.loggedIn, req.token = &token.UserID, true, token
+		}
+		rw := &responseWriter{w: w}
+		if cacheable && !req.loggedIn {
+			rw.buf = &bytes.Buffer{}
+		}
+		if err = h(rw, req); err != nil {
+			s.writeError(w, r, s.dbTimeoutError(r, route, bodyLimitError(err, route)))
 			return
 		}
+		if rw.buf != nil {
+			s.writeCacheable(w, r, rw, shared)
+		}
 	})
 }
 
 // setCsrfCookie sets the CSRF cookie if the cookie is not present or if the
-// cookie is invalid. It also includes the CSRF token in a "Csrf-Token" HTTP
-// header (this header is sent on every response).
+// cookie is invalid (as it is once the session is regenerated). It also
+// includes the CSRF token in a "Csrf-Token" HTTP header (this header is sent
+// on every response).
 //
 // It is safe to change HMACSecret in config.Config.
 func (s *Server) setCsrfCookie(ses *sessions.Session, w http.ResponseWriter, r *http.Request) {
@@ -317,22 +1016,22 @@ func (s *Server) setCsrfCookie(ses *sessions.Session, w http.ResponseWriter, r *
 			return
 		}
 	} else {
-		valid, err := utils.ValidMAC(ses.ID, cookie.Value, s.config.HMACSecret)
-		if err != nil {
-			return
+		setCookie = !s.validCSRFToken(ses, r, cookie.Value)
+		if !setCookie {
+			w.Header().Set("Csrf-Token", cookie.Value)
 		}
-		setCookie = !valid
-		w.Header().Add("Csrf-Token", cookie.Value)
 	}
 
 	if setCookie {
-		token := utils.NewHMAC(ses.ID, s.config.HMACSecret)
+		token := s.csrfToken(ses, r)
 		http.SetCookie(w, &http.Cookie{
-			Name:  "csrftoken",
-			Value: token,
-			Path:  "/",
+			Name:     "csrftoken",
+			Value:    token,
+			Path:     "/",
+			Secure:   s.cookieSecure(r),
+			SameSite: s.cookieSameSite(),
 		})
-		w.Header().Add("Csrf-Token", token)
+		w.Header().Set("Csrf-Token", token)
 	}
 }
 
@@ -377,6 +1076,19 @@ func constructLogLine(fields []logField, color string) string {
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	beginT := time.Now()
 
+	// Every request is assigned an ID, which is sent back in the X-Request-Id
+	// header and is attached to everything logged while handling the request.
+	requestID := uid.New().String()
+	w.Header().Set("X-Request-Id", requestID)
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("http.method", r.Method),
+		attribute.String("http.target", r.URL.Path),
+		attribute.String("request_id", requestID),
+	))
+	defer span.End()
+	r = r.WithContext(logging.WithRequestID(ctx, requestID))
+
 	if r.URL.Path == "/robots.txt" {
 		http.ServeFile(w, r, "./robots.txt")
 	} else if r.URL.Path == "/manifest.json" {
@@ -401,6 +1113,7 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	logFields := []logField{
 		{name: "took", val: took},
+		{name: "request_id", val: requestID},
 		{name: "url", val: r.URL},
 		{name: "ip", val: httputil.GetIP(r)},
 		{name: "method", val: r.Method},
@@ -427,12 +1140,13 @@ func (s *Server) apiMethodNotAllowedHandler(w http.ResponseWriter, r *http.Reque
 }
 
 func (s *Server) logInternalServerError(r *http.Request, err error) {
-	log.Println("500 Internal server error: ", err)
+	logger.ErrorContext(r.Context(), "500 Internal server error", "err", err)
 
 	stack := debug.Stack()
 	stack64 := base64.StdEncoding.EncodeToString(stack)
 	line := struct {
 		Error         string      `json:"error"`
+		RequestID     string      `json:"requestId"`
 		Method        string      `json:"method"`
 		URL           string      `json:"url"`
 		Proto         string      `json:"proto"`
@@ -443,6 +1157,7 @@ func (s *Server) logInternalServerError(r *http.Request, err error) {
 		Stack         string      `json:"stack"`
 	}{
 		Error:         err.Error(),
+		RequestID:     logging.RequestID(r.Context()),
 		Stack:         stack64,
 		Method:        r.Method,
 		URL:           r.URL.String(),
@@ -462,21 +1177,27 @@ func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
 	var res []byte
 
 	if httpErr, ok := err.(*httperr.Error); ok {
-		if httpErr.Message == "" {
-			httpErr.Message = http.StatusText(httpErr.HTTPStatus) + "."
-		}
+		httpErr = httpErr.Complete()
+		s.translateError(httpErr, s.requestLocale(r))
 		statusCode = httpErr.HTTPStatus
+		if httpErr.RetryAfter > 0 && w.Header().Get("Retry-After") == "" {
+			w.Header().Set("Retry-After", strconv.Itoa(httpErr.RetryAfter))
+		}
 		res, _ = json.Marshal(httpErr)
 	} else {
-		res, _ = json.Marshal(httperr.Error{
+		res, _ = json.Marshal((&httperr.Error{
 			HTTPStatus: statusCode,
 			Message:    http.StatusText(statusCode),
-		})
+		}).Complete())
 	}
 
 	if statusCode == http.StatusInternalServerError {
 		s.logInternalServerError(r, err)
+		span := trace.SpanFromContext(r.Context())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.Int("http.status_code", statusCode))
 	w.WriteHeader(statusCode)
 	w.Write(res)
 }
@@ -582,6 +1303,18 @@ func appendMetaTag(doc *html.Node, attr []html.Attribute) {
 	head.InsertBefore(node, before)
 }
 
+// appendLinkTag appends a link element, with the attributes attr, to the head
+// of doc.
+func appendLinkTag(doc *html.Node, attr []html.Attribute) {
+	head := findNodeElement(doc, "head")
+	head.AppendChild(&html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Link,
+		Data:     "link",
+		Attr:     attr,
+	})
+}
+
 // fixOgImageTag substitues relative og:image url for an absolute one. It does
 // the same for twitter:image meta tag.
 func fixOgImageTag(doc *html.Node, toAbsolute func(string) string) {
@@ -673,6 +1406,15 @@ func (s *Server) insertMetaTags(doc *html.Node, r *http.Request) {
 		})
 	}
 
+	// appendCanonical sets the canonical URL of the page (to which the others,
+	// differing in case or query, say, are folded by search engines).
+	appendCanonical := func(url string) {
+		appendLinkTag(doc, []html.Attribute{
+			{Key: "rel", Val: "canonical"},
+			{Key: "href", Val: url},
+		})
+	}
+
 	description := s.config.SiteDescription
 	appendDescription(description)
 	// The default og:type tag is in index.html file.
@@ -709,12 +1451,14 @@ func (s *Server) insertMetaTags(doc *html.Node, r *http.Request) {
 			if err == nil {
 				appendTitle("@"+user.Username, " on "+s.config.SiteName)
 				appendDescription(username + "'s profile.")
+				appendCanonical(absoluteURL("/@" + user.Username))
 			}
 		} else {
 			// community page
 			community, err := core.GetCommunityByName(ctx, s.db, list[0], nil)
 			if err == nil {
 				appendTitle(community.Name, " - "+s.config.SiteName)
+				appendCanonical(absoluteURL("/" + community.Name))
 				appendDescription(community.About.String)
 				appendMetaTag(doc, []html.Attribute{
 					{Key: "name", Val: "description"},
@@ -760,10 +1504,36 @@ func (s *Server) insertMetaTags(doc *html.Node, r *http.Request) {
 				if post.Link != nil && post.Link.Image != nil {
 					image = absoluteURL(*post.Link.Image.URL)
 				}
+			} else if post.Type == core.PostTypeVideo {
+				if post.Video != nil && post.Video.Poster != nil {
+					image = absoluteURL(*post.Video.Poster.URL)
+				}
+				if post.Video != nil && post.Video.URL != "" {
+					appendMetaTag(doc, []html.Attribute{
+						{Key: "property", Val: "og:video"},
+						{Key: "content", Val: absoluteURL(post.Video.URL)},
+					})
+				}
+			}
+			if image == "" && !post.Deleted {
+				image = absoluteURL("/cards/posts/" + post.PublicID + ".png")
 			}
 			if image != "" {
 				appendOGImage(image)
 			}
+			if !post.Deleted {
+				postURL := absoluteURL("/" + post.CommunityName + "/post/" + post.PublicID)
+				appendLinkTag(doc, []html.Attribute{
+					{Key: "rel", Val: "alternate"},
+					{Key: "type", Val: "application/json+oembed"},
+					{Key: "href", Val: absoluteURL("/api/oembed") + "?url=" + url.QueryEscape(postURL)},
+					{Key: "title", Val: post.Title},
+				})
+				appendCanonical(postURL)
+				if data, err := postStructuredData(post, postURL, image, absoluteURL); err == nil {
+					appendScriptTag(doc, "application/ld+json", string(data))
+				}
+			}
 		}
 	}
 }
@@ -867,6 +1637,12 @@ func (s *Server) loginUser(u *core.User, ses *sessions.Session, w http.ResponseW
 		return httperr.NewForbidden("account_suspended", "User account suspended.")
 	}
 
+	// A new session ID (and CSRF token) for the new privileges.
+	if err := ses.Regenerate(w, r); err != nil {
+		return err
+	}
+	s.setCsrfCookie(ses, w, r)
+
 	conn := s.redisPool.Get()
 	defer conn.Close()
 
@@ -874,7 +1650,11 @@ func (s *Server) loginUser(u *core.User, ses *sessions.Session, w http.ResponseW
 		return err
 	}
 
+	now := time.Now().Unix()
 	ses.Values["uid"] = u.ID.String()
+	ses.Values[sessionCreatedAt] = now
+	ses.Values[sessionAuthAt] = now
+	ses.Values[sessionLocale] = u.Locale
 	return ses.Save(w, r)
 }
 
@@ -883,35 +1663,42 @@ func (s *Server) logoutUser(u *core.User, ses *sessions.Session, w http.Response
 		return err
 	}
 
-	ses.Clear()
+	conn := s.redisPool.Get()
+	defer conn.Close()
 
-	if err := ses.Save(w, r); err != nil {
+	if _, err := conn.Do("SREM", userSessionsSetRedisKey(u.UsernameLowerCase), ses.ID); err != nil {
 		return err
 	}
 
-	conn := s.redisPool.Get()
-	defer conn.Close()
-
-	_, err := conn.Do("SREM", userSessionsSetRedisKey(u.UsernameLowerCase), ses.ID)
-	return err
+	ses.Clear()
+	if err := ses.Regenerate(w, r); err != nil {
+		return err
+	}
+	s.setCsrfCookie(ses, w, r)
+	return nil
 }
 
 func (s *Server) logoutAllSessionsOfUser(u *core.User) error {
 	conn := s.redisPool.Get()
 	defer conn.Close()
+	return logoutAllSessions(conn, s.sessions, u.UsernameLowerCase)
+}
 
-	sessionIDs, err := redis.Strings(conn.Do("SMEMBERS", userSessionsSetRedisKey(u.UsernameLowerCase)))
+// logoutAllSessions deletes all sessions of the user with the (lowercase)
+// username from store, along with the set of their session IDs.
+func logoutAllSessions(conn redis.Conn, store *sessions.RedisStore, username string) error {
+	sessionIDs, err := redis.Strings(conn.Do("SMEMBERS", userSessionsSetRedisKey(username)))
 	if err != nil {
 		return err
 	}
 
 	for _, id := range sessionIDs {
-		if _, err := conn.Do("DEL", s.sessions.RedisKey(id)); err != nil {
+		if _, err := conn.Do("DEL", store.RedisKey(id)); err != nil {
 			return err
 		}
 	}
 
-	_, err = conn.Do("DEL", userSessionsSetRedisKey(u.UsernameLowerCase))
+	_, err = conn.Do("DEL", userSessionsSetRedisKey(username))
 	return err
 }
 
@@ -927,15 +1714,8 @@ func strToID(s string) (id uid.ID, err error) {
 // some other error occurs in the process of checking it. If rateLimit returns
 // a non-nil error, the handler should return immediately.
 func (s *Server) rateLimit(r *request, bucketID string, interval time.Duration, maxTokens int) error {
-	if s.config.DisableRateLimits {
-		return nil // skip rate limits
-	}
-
-	if s.config.AdminApiKey != "" {
-		adminKey := r.urlQueryValue("adminKey")
-		if adminKey == s.config.AdminApiKey {
-			return nil // skip rate limits
-		}
+	if s.skipRateLimits(r) {
+		return nil
 	}
 
 	conn, err := s.redisPool.Dial()
@@ -947,11 +1727,8 @@ func (s *Server) rateLimit(r *request, bucketID string, interval time.Duration,
 	if ok, err := ratelimits.Limit(conn, bucketID, interval, maxTokens); err != nil {
 		return err
 	} else if !ok {
-		return &httperr.Error{
-			HTTPStatus: http.StatusTooManyRequests,
-			Code:       "",
-			Message:    "",
-		}
+		// The bucket is refilled within interval.
+		return httperr.NewTooManyRequests("too_many_requests", "Too many requests. Try again later.", interval)
 	}
 	return nil
 }
@@ -963,13 +1740,6 @@ func (s *Server) rateLimitUpdateContent(r *request, userID uid.ID) error {
 	return s.rateLimit(r, "update_stuff_2_"+userID.String(), time.Hour*24, 2000)
 }
 
-func (s *Server) rateLimitVoting(r *request, userID uid.ID) error {
-	if err := s.rateLimit(r, "voting_1_"+userID.String(), time.Second, 4); err != nil {
-		return err
-	}
-	return s.rateLimit(r, "voting_2_"+userID.String(), time.Hour*24, 2000)
-}
-
 // /api/_get_link_info [GET]
 func (s *Server) getLinkInfo(w *responseWriter, r *request) error {
 	if !r.loggedIn {
@@ -1000,6 +1770,10 @@ func (s *Server) getLinkInfo(w *responseWriter, r *request) error {
 }
 
 func (s *Server) handleAnalytics(w *responseWriter, r *request) error {
+	if s.config.AnalyticsOff {
+		return httperr.NewForbidden("analytics_off", "Analytics are turned off.")
+	}
+
 	ip := httputil.GetIP(r.req)
 	if err := s.rateLimit(r, "analytics_ip_1_"+ip, time.Second*1, 2); err != nil {
 		return err
@@ -1007,6 +1781,7 @@ func (s *Server) handleAnalytics(w *responseWriter, r *request) error {
 
 	body := struct {
 		Event string `json:"event"`
+		Page  string `json:"page"` // For page_view events.
 	}{}
 	if err := r.unmarshalJSONBody(&body); err != nil {
 		return err
@@ -1015,6 +1790,12 @@ func (s *Server) handleAnalytics(w *responseWriter, r *request) error {
 	var payload, uniqueKey string
 
 	switch body.Event {
+	case core.AnalyticsPageView:
+		if !slices.Contains(core.AnalyticsPages, body.Page) {
+			return httperr.NewBadRequest("bad_page", "Bad page.")
+		}
+		s.analytics.Add(core.AnalyticsPageView, body.Page)
+		return w.writeString(`{"success":true}`)
 	case "pwa_use":
 		uniqueKey = "pwa_use_" + r.ses.ID
 		data := make(map[string]any)
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/config"
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/httputil"
+	"github.com/discuitnet/discuit/internal/sessions"
+	"github.com/discuitnet/discuit/internal/utils"
+	"github.com/gomodule/redigo/redis"
+)
+
+// Keys of the session values.
+const (
+	sessionCreatedAt = "created_at" // When the user logged in (Unix time).
+	sessionAuthAt    = "auth_at"    // When the user last entered their password.
+	sessionLocale    = "locale"     // The locale preference of the user (see core.User.Locale).
+)
+
+var errReauthRequired = httperr.NewForbidden("reauth_required", "Please enter your password again to continue.")
+
+// cookieSecure reports whether the cookies set in response to r are to be
+// marked secure (as per config.CookieSecure).
+func (s *Server) cookieSecure(r *http.Request) bool {
+	switch s.config.CookieSecure {
+	case "never":
+		return false
+	case "auto":
+		return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+	}
+	return true
+}
+
+// cookieSameSite returns the SameSite attribute of the cookies set (as per
+// config.CookieSameSite).
+func (s *Server) cookieSameSite() http.SameSite {
+	switch s.config.CookieSameSite {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
+
+// csrfToken returns the CSRF token of the session ses for requests to the host
+// of r. A token is valid only for the session it was issued to (and so it
+// changes whenever the session is regenerated) and only on the same host.
+func (s *Server) csrfToken(ses *sessions.Session, r *http.Request) string {
+	return utils.NewHMAC(ses.ID+"\n"+r.Host, s.config.HMACSecret)
+}
+
+// validCSRFToken reports whether token is the CSRF token of ses for r.
+func (s *Server) validCSRFToken(ses *sessions.Session, r *http.Request, token string) bool {
+	valid, _ := utils.ValidMAC(ses.ID+"\n"+r.Host, token, s.config.HMACSecret)
+	return valid
+}
+
+// checkOrigin returns an error if r, a request that changes state, was made
+// from another site: if its Origin header (or, failing that, its Referer
+// header) is set, it must be the origin of the site itself or one of
+// config.CSRFTrustedOrigins. Requests with neither header (which are not made
+// by browsers) are let through, to be checked for the CSRF token.
+func (s *Server) checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			return nil
+		}
+		u, err := url.Parse(referer)
+		if err != nil {
+			return errCrossOrigin
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return errCrossOrigin
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	for _, trusted := range s.config.CSRFTrustedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(trusted, "/"), origin) {
+			return nil
+		}
+	}
+	return errCrossOrigin
+}
+
+var errCrossOrigin = httperr.NewForbidden("cross_origin", "Cross-origin requests are not allowed.")
+
+// sessionInt64 returns the session value key as an int64 (values are decoded
+// from JSON and so numbers are float64s).
+func sessionInt64(ses *sessions.Session, key string) (int64, bool) {
+	switch v := ses.Values[key].(type) {
+	case float64:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+// expireSession logs out the session ses, if it's logged in, if it was idle
+// for longer than config.SessionIdleTimeout hours or if it's older than
+// config.SessionMaxAge hours. It reports whether the session was logged out.
+func (s *Server) expireSession(w http.ResponseWriter, r *http.Request, ses *sessions.Session) (bool, error) {
+	if loggedIn, _ := isLoggedIn(ses); !loggedIn {
+		return false, nil
+	}
+	if s.config.SessionIdleTimeout == 0 && s.config.SessionMaxAge == 0 {
+		return false, nil
+	}
+
+	now := time.Now()
+	expired := false
+	if s.config.SessionIdleTimeout > 0 {
+		if seen, ok := sessionInt64(ses, "last_seen"); ok {
+			expired = now.Sub(time.Unix(seen, 0)) > time.Duration(s.config.SessionIdleTimeout)*time.Hour
+		}
+	}
+	if s.config.SessionMaxAge > 0 {
+		created, ok := sessionInt64(ses, sessionCreatedAt)
+		if !ok {
+			// Sessions of logins from before session ages were recorded.
+			ses.Values[sessionCreatedAt] = now.Unix()
+			return false, ses.Save(w, r)
+		}
+		expired = expired || now.Sub(time.Unix(created, 0)) > time.Duration(s.config.SessionMaxAge)*time.Hour
+	}
+	if !expired {
+		return false, nil
+	}
+	ses.Clear()
+	return true, ses.Regenerate(w, r)
+}
+
+// requireRecentAuth returns an error if the logged in user has not entered
+// their password (by logging in, or with /api/_reauth) in the last
+// config.ReauthWindow minutes. It guards sensitive actions, like changing the
+// email address, against hijacked sessions.
+func (s *Server) requireRecentAuth(r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	if r.token != nil {
+		return errSessionOnly
+	}
+	if s.config.ReauthWindow == 0 {
+		return nil
+	}
+	at, ok := sessionInt64(r.ses, sessionAuthAt)
+	if !ok || time.Since(time.Unix(at, 0)) > time.Duration(s.config.ReauthWindow)*time.Minute {
+		return errReauthRequired
+	}
+	return nil
+}
+
+// /api/_reauth [POST]
+//
+// Confirms the password of the logged in user, for sensitive actions (see
+// requireRecentAuth).
+func (s *Server) reauth(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	values, err := r.unmarshalJSONBodyToStringsMap(true)
+	if err != nil {
+		return err
+	}
+	user, err := core.GetUser(r.ctx, s.db, *r.viewer, r.viewer)
+	if err != nil {
+		return err
+	}
+
+	ip := httputil.GetIP(r.req)
+	if err := s.throttleLogin(r, user.Username, ip, s.loginDevice(r, ip)); err != nil {
+		return err
+	}
+	if _, err := core.MatchLoginCredentials(r.ctx, s.db, user.Username, values["password"]); err != nil {
+		if err == core.ErrWrongPassword {
+			s.recordLoginFailure(r, user.Username, ip)
+		}
+		return err
+	}
+	if user.PasswordLoginDisabled {
+		return errPasswordLoginDisabled
+	}
+
+	if err := s.rotateSession(user, r.ses, w, r.req); err != nil {
+		return err
+	}
+	r.ses.Values[sessionAuthAt] = time.Now().Unix()
+	if err := r.ses.Save(w, r.req); err != nil {
+		return err
+	}
+	return w.writeString(`{"success":true}`)
+}
+
+// rotateSession regenerates the session ses of the logged in user u (whose
+// privileges changed), along with its CSRF token.
+func (s *Server) rotateSession(u *core.User, ses *sessions.Session, w http.ResponseWriter, r *http.Request) error {
+	conn := s.redisPool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("SREM", userSessionsSetRedisKey(u.UsernameLowerCase), ses.ID); err != nil {
+		return err
+	}
+	if err := ses.Regenerate(w, r); err != nil {
+		return err
+	}
+	if _, err := conn.Do("SADD", userSessionsSetRedisKey(u.UsernameLowerCase), ses.ID); err != nil {
+		return err
+	}
+	s.setCsrfCookie(ses, w, r)
+	return nil
+}
+
+// LogoutAllSessions logs out all sessions of user, from outside of a running
+// server (like from the command line).
+func LogoutAllSessions(conf *config.Config, user *core.User) error {
+	conn, err := redis.Dial("tcp", conf.RedisAddress, redis.DialDatabase(conf.RedisDB))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return logoutAllSessions(conn, &sessions.RedisStore{CookieName: conf.SessionCookieName}, user.UsernameLowerCase)
+}
+
+// PruneSessions removes the IDs of expired sessions from the sets of the
+// session IDs of users (see userSessionsSetRedisKey), which are otherwise
+// removed only on logout, and returns the number of IDs removed.
+func PruneSessions(conf *config.Config) (int, error) {
+	conn, err := redis.Dial("tcp", conf.RedisAddress, redis.DialDatabase(conf.RedisDB))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	store := &sessions.RedisStore{CookieName: conf.SessionCookieName}
+
+	removed, cursor := 0, 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", userSessionsSetRedisKey("*"), "COUNT", 100))
+		if err != nil {
+			return removed, err
+		}
+		var keys []string
+		if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+			return removed, err
+		}
+		for _, key := range keys {
+			ids, err := redis.Strings(conn.Do("SMEMBERS", key))
+			if err != nil {
+				return removed, err
+			}
+			for _, id := range ids {
+				exists, err := redis.Bool(conn.Do("EXISTS", store.RedisKey(id)))
+				if err != nil {
+					return removed, err
+				}
+				if exists {
+					continue
+				}
+				// Redis deletes the set once its last ID is removed.
+				if _, err := conn.Do("SREM", key, id); err != nil {
+					return removed, err
+				}
+				removed++
+			}
+		}
+		if cursor == 0 {
+			return removed, nil
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+var errSignupRejected = httperr.NewForbidden("signup/rejected", "Signup failed.")
+
+// signupNetwork returns the network the IP address ip of r is part of: either
+// the autonomous system number set by the reverse proxy (in the header
+// config.SignupASNHeader) or, failing that, the /24 (or, for IPv6, the /48)
+// prefix of ip.
+func (s *Server) signupNetwork(r *request, ip string) string {
+	if s.config.SignupASNHeader != "" {
+		if asn := strings.TrimSpace(r.req.Header.Get(s.config.SignupASNHeader)); asn != "" {
+			return "asn:" + asn
+		}
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "ip:" + ip
+	}
+	if v4 := addr.To4(); v4 != nil {
+		return "net:" + v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return "net:" + addr.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// isDisposableEmail reports whether email is at one of the disposable email
+// domains (config.DisposableEmailDomains, which are domain patterns as in the
+// link domain rules).
+func (s *Server) isDisposableEmail(email string) bool {
+	return emailAtDomains(email, s.config.DisposableEmailDomains)
+}
+
+// emailAtDomains reports whether email is at one of the domains patterns (as
+// in the link domain rules).
+func emailAtDomains(email string, patterns []string) bool {
+	_, domain, ok := strings.Cut(strings.TrimSpace(email), "@")
+	if !ok || domain == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if core.MatchLinkDomain(strings.ToLower(strings.TrimSpace(pattern)), domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSignup checks the signup request r (with the form values values) for
+// signs of abuse. It returns an error if the signup is to be refused, or the
+// reason for quarantining the new account if it's suspicious (or an empty
+// string if it's not).
+func (s *Server) checkSignup(r *request, values map[string]string, ip, network string) (string, error) {
+	for _, field := range s.config.SignupHoneypotFields {
+		if values[field] != "" {
+			logger.InfoContext(r.ctx, "Signup honeypot field filled", "ip", ip, "field", field)
+			return "", errSignupRejected
+		}
+	}
+
+	var reasons []string
+	if s.isDisposableEmail(values["email"]) {
+		if s.config.DisposableEmailAction != "quarantine" {
+			return "", httperr.NewForbidden("signup/disposable-email", "Disposable email addresses are not allowed.")
+		}
+		reasons = append(reasons, "Disposable email address")
+	}
+
+	if s.config.SignupVelocityWindow > 0 {
+		window := time.Duration(s.config.SignupVelocityWindow) * time.Minute
+		fromIP, fromNetwork, err := core.CountSignups(r.ctx, s.db, ip, network, time.Now().Add(-window))
+		if err != nil {
+			return "", err
+		}
+		if s.config.SignupIPLimit > 0 && fromIP >= s.config.SignupIPLimit {
+			reasons = append(reasons, fmt.Sprintf("%d signups from the same IP address in the last %d minutes", fromIP, s.config.SignupVelocityWindow))
+		}
+		if s.config.SignupNetworkLimit > 0 && fromNetwork >= s.config.SignupNetworkLimit {
+			reasons = append(reasons, fmt.Sprintf("%d signups from the same network in the last %d minutes", fromNetwork, s.config.SignupVelocityWindow))
+		}
+	}
+	return strings.Join(reasons, "; "), nil
+}
+
+// recordSignup records the signup of the new user, from the IP address ip
+// (part of network), and quarantines user if quarantine (the reason, as
+// returned by checkSignup) is not empty.
+func (s *Server) recordSignup(r *request, user *core.User, ip, network, quarantine string) error {
+	if err := core.RecordSignup(r.ctx, s.db, user.ID, ip, network); err != nil {
+		return err
+	}
+	if s.config.JurisdictionHeader != "" {
+		if err := core.SetUserJurisdiction(r.ctx, s.db, user.ID, r.req.Header.Get(s.config.JurisdictionHeader)); err != nil {
+			return err
+		}
+	}
+	s.analytics.Add(core.AnalyticsSignup, "")
+	if quarantine != "" {
+		if err := core.QuarantineUser(r.ctx, s.db, user.ID, quarantine); err != nil {
+			return err
+		}
+		logger.InfoContext(r.ctx, "New user quarantined", "user", user.Username, "reason", quarantine)
+	}
+	return nil
+}
+
+// quarantined reports whether the posts and comments the logged in user makes
+// as g are to be held for review.
+func (s *Server) quarantined(r *request, g core.UserGroup) (bool, error) {
+	if g != core.UserGroupNormal {
+		return false, nil
+	}
+	return core.IsUserQuarantined(r.ctx, s.db, *r.viewer)
+}
+
+// /api/_admin/quarantine/users [GET]
+func (s *Server) getQuarantinedUsers(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	users, err := core.GetQuarantinedUsers(r.ctx, s.db)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(users)
+}
+
+// /api/_admin/quarantine/users/{username} [DELETE]
+//
+// Lifts the quarantine of a user.
+func (s *Server) releaseQuarantinedUser(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	user, err := core.GetUserByUsername(r.ctx, s.db, r.muxVar("username"), nil)
+	if err != nil {
+		return err
+	}
+	if err := core.ReleaseUser(r.ctx, s.db, user.ID); err != nil {
+		return err
+	}
+	logger.InfoContext(r.ctx, "User released from quarantine", "user", user.Username)
+	return w.writeString(`{"success":true}`)
+}
+
+// /api/_admin/quarantine/posts [GET]
+func (s *Server) getHeldPosts(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	posts, err := core.GetHeldPosts(r.ctx, s.db)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(posts)
+}
+
+type heldPostReviewRequest struct {
+	Action string `json:"action"` // Either approve or reject.
+}
+
+// /api/_admin/quarantine/posts/{heldPostID} [PUT]
+func (s *Server) reviewHeldPost(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	id, err := strToID(r.muxVar("heldPostID"))
+	if err != nil {
+		return err
+	}
+	req := heldPostReviewRequest{}
+	if err := r.unmarshalJSONBody(&req); err != nil {
+		return err
+	}
+
+	switch req.Action {
+	case "approve":
+		post, held, err := core.ApproveHeldPost(r.ctx, s.db, id)
+		if err != nil {
+			return err
+		}
+		post.Vote(r.ctx, post.AuthorID, true)
+		s.publishPost(r.ctx, post.ID)
+		s.federatePost(r.ctx, post)
+		if err := core.AddQuarantineApproval(r.ctx, s.db, held.UserID, s.config.QuarantineReleaseAfter); err != nil {
+			return err
+		}
+		logger.InfoContext(r.ctx, "Held post approved", "id", id, "post", post.PublicID)
+		return w.writeJSON(post)
+	case "reject":
+		if err := core.RejectHeldPost(r.ctx, s.db, id); err != nil {
+			return err
+		}
+		logger.InfoContext(r.ctx, "Held post rejected", "id", id)
+		return w.writeString(`{"success":true}`)
+	}
+	return httperr.NewBadRequest("invalid_action", "Action must be either approve or reject.")
+}
+
+// holdQuarantinedPost holds the post, if the logged in user (posting as g) is
+// quarantined and is not an approved submitter of community, and writes the
+// held post to w with the status 202. It returns false if the post was not
+// held.
+func (s *Server) holdQuarantinedPost(w *responseWriter, r *request, g core.UserGroup, postType core.PostType, community uid.ID, title, body, link string, media uid.ID) (bool, error) {
+	if ok, err := s.quarantined(r, g); err != nil || !ok {
+		return false, err
+	}
+	if approved, err := core.IsApprovedSubmitter(r.ctx, s.db, community, *r.viewer); err != nil || approved {
+		return false, err
+	}
+	held, err := core.HoldPost(r.ctx, s.db, postType, community, *r.viewer, title, body, link, media, "Quarantined account")
+	if err != nil {
+		return false, err
+	}
+	w.WriteHeader(http.StatusAccepted)
+	return true, w.writeJSON(held)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/uid"
+)
+
+// checkStorageQuota returns an error if storing size more bytes on behalf of
+// user or community (either of which may be nil) would take it over its
+// storage quota.
+func (s *Server) checkStorageQuota(r *request, user, community *uid.ID, size int64) error {
+	if user != nil && s.config.UserStorageQuota > 0 {
+		quota, err := s.userStorageQuota(r, *user)
+		if err != nil {
+			return err
+		}
+		usage, err := core.GetUserStorageUsage(r.ctx, s.db, *user, quota)
+		if err != nil {
+			return err
+		}
+		if err := usage.CheckQuota(size); err != nil {
+			return err
+		}
+	}
+	if community != nil && s.config.CommunityStorageQuota > 0 {
+		usage, err := core.GetCommunityStorageUsage(r.ctx, s.db, *community, int64(s.config.CommunityStorageQuota)<<20)
+		if err != nil {
+			return err
+		}
+		if err := usage.CheckQuota(size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userStorageQuota returns the storage quota, in bytes, of user (that of all
+// users, and the extra storage of supporters), or zero if there's no quota.
+func (s *Server) userStorageQuota(r *request, user uid.ID) (int64, error) {
+	if s.config.UserStorageQuota == 0 {
+		return 0, nil
+	}
+	extra, err := core.SupporterExtraStorage(r.ctx, s.db, user)
+	if err != nil {
+		return 0, err
+	}
+	return int64(s.config.UserStorageQuota)<<20 + extra, nil
+}
+
+// checkMediaStorageQuota is checkStorageQuota for posting an already uploaded
+// image or video, with id, to community.
+func (s *Server) checkMediaStorageQuota(r *request, community uid.ID, id uid.ID) error {
+	if s.config.CommunityStorageQuota == 0 {
+		return nil
+	}
+	size, err := core.MediaUploadSize(r.ctx, s.db, id)
+	if err != nil {
+		return err
+	}
+	return s.checkStorageQuota(r, nil, &community, size)
+}
+
+// /api/_storage [GET]
+func (s *Server) getStorageUsage(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	quota, err := s.userStorageQuota(r, *r.viewer)
+	if err != nil {
+		return err
+	}
+	usage, err := core.GetUserStorageUsage(r.ctx, s.db, *r.viewer, quota)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(usage)
+}
+
+type siteStorageUsage struct {
+	Total       *core.StorageUsage      `json:"total"`
+	Users       []*core.StorageConsumer `json:"users"`       // The top consumers.
+	Communities []*core.StorageConsumer `json:"communities"` // The top consumers.
+}
+
+// /api/_admin/storage [GET]
+func (s *Server) getSiteStorageUsage(w *responseWriter, r *request) error {
+	if err := s.requireAdmin(r); err != nil {
+		return err
+	}
+	var (
+		res = siteStorageUsage{}
+		err error
+	)
+	if res.Total, err = core.GetTotalStorageUsage(r.ctx, s.db); err != nil {
+		return err
+	}
+	if res.Users, err = core.GetTopStorageUsers(r.ctx, s.db, 50); err != nil {
+		return err
+	}
+	if res.Communities, err = core.GetTopStorageCommunities(r.ctx, s.db, 50); err != nil {
+		return err
+	}
+	return w.writeJSON(res)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/discuitnet/discuit/core"
+	"github.com/discuitnet/discuit/internal/httperr"
+	"github.com/discuitnet/discuit/internal/payments"
+	"github.com/discuitnet/discuit/internal/uid"
+	"github.com/gorilla/mux"
+)
+
+func (s *Server) supporterPerks() *core.SupporterPerks {
+	return &core.SupporterPerks{
+		Badge:        s.config.SupporterBadge,
+		ExtraStorage: int64(s.config.SupporterExtraStorage) << 20,
+		Days:         s.config.SupporterDays,
+	}
+}
+
+// /webhooks/payments/{provider} [POST]
+//
+// Payment providers tell the site of payments for the perks of supporters, and
+// of their refunds, here. Errors that retrying won't fix (like the username of
+// the supporter being wrong) are logged, and the request is acknowledged.
+func (s *Server) paymentWebhook(w http.ResponseWriter, r *http.Request) {
+	provider := s.paymentProviders[mux.Vars(r)["provider"]]
+	if provider == nil {
+		http.Error(w, "404: Payment provider not found", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(s.config.MaxRequestBodySize)))
+	if err != nil {
+		http.Error(w, "400: Bad request", http.StatusBadRequest)
+		return
+	}
+	event, err := provider.ParseWebhook(r.Header, body)
+	if err != nil {
+		if !errors.Is(err, payments.ErrInvalidSignature) {
+			logger.ErrorContext(r.Context(), "Failed to parse payment webhook", "provider", provider.Name(), "err", err)
+		}
+		http.Error(w, "400: Bad request", http.StatusBadRequest)
+		return
+	}
+	if event != nil {
+		if err := s.handlePaymentEvent(r, provider.Name(), event); err != nil {
+			if !httperr.IsNotFound(err) {
+				logger.ErrorContext(r.Context(), "Failed to handle payment event", "provider", provider.Name(), "payment", event.PaymentID, "err", err)
+				http.Error(w, "500: Internal server error", http.StatusInternalServerError)
+				return
+			}
+			logger.ErrorContext(r.Context(), "Payment for a user not found", "provider", provider.Name(), "payment", event.PaymentID, "username", event.Username, "giftedBy", event.GiftedBy)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handlePaymentEvent(r *http.Request, provider string, event *payments.Event) error {
+	ctx := r.Context()
+	switch event.Type {
+	case payments.EventPurchase:
+		user, err := core.GetUserByUsername(ctx, s.db, event.Username, nil)
+		if err != nil {
+			return err
+		}
+		var giftedBy *uid.ID
+		if event.GiftedBy != "" {
+			gifter, err := core.GetUserByUsername(ctx, s.db, event.GiftedBy, nil)
+			if err != nil {
+				return err
+			}
+			giftedBy = &gifter.ID
+		}
+		e, err := core.GrantSupporterEntitlement(ctx, s.db, provider, event.PaymentID, user.ID, giftedBy, s.supporterPerks(), event.Days)
+		if err != nil {
+			return err
+		}
+		logger.InfoContext(ctx, "Supporter perks granted", "user", user.Username, "provider", provider, "payment", event.PaymentID, "expiresAt", e.ExpiresAt.Time)
+	case payments.EventRefund:
+		if err := core.RevokeSupporterEntitlement(ctx, s.db, provider, event.PaymentID); err != nil {
+			return err
+		}
+		logger.InfoContext(ctx, "Supporter perks revoked (payment refunded)", "provider", provider, "payment", event.PaymentID)
+	}
+	return nil
+}
+
+// /api/_supporter [GET]
+func (s *Server) getSupporterEntitlements(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	es, err := core.GetSupporterEntitlements(r.ctx, s.db, *r.viewer)
+	if err != nil {
+		return err
+	}
+	if es == nil {
+		return w.writeString("[]")
+	}
+	return w.writeJSON(es)
+}
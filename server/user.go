@@ -2,6 +2,7 @@ package server
 
 import (
 	"database/sql"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
@@ -13,6 +14,7 @@ import (
 	"github.com/discuitnet/discuit/internal/hcaptcha"
 	"github.com/discuitnet/discuit/internal/httperr"
 	"github.com/discuitnet/discuit/internal/httputil"
+	"github.com/discuitnet/discuit/internal/images"
 	"github.com/discuitnet/discuit/internal/uid"
 	"github.com/gorilla/mux"
 )
@@ -24,34 +26,75 @@ func (s *Server) getUser(w *responseWriter, r *request) error {
 	if err != nil {
 		return err
 	}
+	if err := user.CheckProfileVisible(r.viewer); err != nil {
+		return err
+	}
 
 	if err := user.LoadModdingList(r.ctx); err != nil {
 		return err
 	}
 
+	w.addSurrogateKeys(userKey(user.ID))
 	return w.writeJSON(user)
 }
 
+// /api/users/{username}/points [GET]
+func (s *Server) getUserPoints(w *responseWriter, r *request) error {
+	user, err := core.GetUserByUsername(r.ctx, s.db, r.muxVar("username"), r.viewer)
+	if err != nil {
+		return err
+	}
+	if err := user.CheckProfileVisible(r.viewer); err != nil {
+		return err
+	}
+	points, err := core.GetUserPoints(r.ctx, s.db, user, r.viewer)
+	if err != nil {
+		return err
+	}
+	w.addSurrogateKeys(userKey(user.ID))
+	return w.writeJSON(points)
+}
+
 // /api/_initial [GET]
+// initialData is the data the web client loads on startup.
+type initialData struct {
+	ReportReasons  []core.ReportReason `json:"reportReasons"`
+	User           *core.User          `json:"user"`
+	Communities    []*core.Community   `json:"communities"`
+	NoUsers        int                 `json:"noUsers"`
+	BannedFrom     []uid.ID            `json:"bannedFrom"`
+	VAPIDPublicKey string              `json:"vapidPublicKey"`
+	Mutes          mutesResponse       `json:"mutes"`
+
+	// The restrictions on what the logged in user may post, if it's a new
+	// account.
+	NewAccountRestrictions *core.NewAccountRestrictions `json:"newAccountRestrictions"`
+
+	// The variants, by the names of the experiments, of the experiments the
+	// logged in user is in.
+	Experiments map[string]string `json:"experiments"`
+
+	// The maintenance mode of the site, if it's read-only or has a banner
+	// message to show.
+	Maintenance *core.Maintenance `json:"maintenance"`
+
+	// The external identity providers users may log in with.
+	LoginProviders []loginProvider `json:"loginProviders"`
+}
+
 func (s *Server) initial(w *responseWriter, r *request) error {
 	var err error
-	response := struct {
-		ReportReasons  []core.ReportReason `json:"reportReasons"`
-		User           *core.User          `json:"user"`
-		Communities    []*core.Community   `json:"communities"`
-		NoUsers        int                 `json:"noUsers"`
-		BannedFrom     []uid.ID            `json:"bannedFrom"`
-		VAPIDPublicKey string              `json:"vapidPublicKey"`
-		Mutes          struct {
-			CommunityMutes []*core.Mute `json:"communityMutes"`
-			UserMutes      []*core.Mute `json:"userMutes"`
-		} `json:"mutes"`
-	}{
+	response := initialData{
 		VAPIDPublicKey: s.webPushVAPIDKeys.Public,
+		LoginProviders: s.loginProviders(),
 	}
 
 	response.Mutes.CommunityMutes = []*core.Mute{}
 	response.Mutes.UserMutes = []*core.Mute{}
+	response.Experiments = map[string]string{}
+	if m := s.maintenance.get(r.ctx); m.Active() {
+		response.Maintenance = m
+	}
 
 	if r.loggedIn {
 		if response.User, err = core.GetUser(r.ctx, s.db, *r.viewer, r.viewer); err != nil {
@@ -66,6 +109,7 @@ func (s *Server) initial(w *responseWriter, r *request) error {
 		if response.BannedFrom, err = response.User.GetBannedFromCommunities(r.ctx); err != nil {
 			return err
 		}
+		response.NewAccountRestrictions = s.newAccountPolicy().Restrictions(response.User)
 		if communityMutes, err := core.GetMutedCommunities(r.ctx, s.db, *r.viewer, true); err != nil {
 			return err
 		} else if communityMutes != nil {
@@ -76,6 +120,12 @@ func (s *Server) initial(w *responseWriter, r *request) error {
 		} else if userMutes != nil {
 			response.Mutes.UserMutes = userMutes
 		}
+		if experiments, err := core.AssignExperiments(r.ctx, s.db, *r.viewer); err != nil {
+			// The site works without experiments.
+			logger.ErrorContext(r.ctx, "Error assigning experiments", "err", err)
+		} else {
+			response.Experiments = experiments
+		}
 	}
 
 	if response.ReportReasons, err = core.GetReportReasons(r.ctx, s.db); err != nil && err != sql.ErrNoRows {
@@ -87,7 +137,7 @@ func (s *Server) initial(w *responseWriter, r *request) error {
 		commsSet = core.CommunitiesSetSubscribed
 	}
 
-	if response.Communities, err = core.GetCommunities(r.ctx, s.db, core.CommunitiesSortDefault, commsSet, -1, r.viewer); err != nil && err != sql.ErrNoRows {
+	if response.Communities, err = core.GetCommunities(r.ctx, s.db, core.CommunitiesSortDefault, commsSet, "", -1, r.viewer); err != nil && err != sql.ErrNoRows {
 		return err
 	}
 	if response.NoUsers, err = core.CountAllUsers(r.ctx, s.db); err != nil {
@@ -132,21 +182,33 @@ func (s *Server) login(w *responseWriter, r *request) error {
 	// TODO: Require a captcha if user is suspicious looking.
 
 	ip := httputil.GetIP(r.req)
-	if err := s.rateLimit(r, "login_1_"+ip, time.Second, 10); err != nil {
+	if err := s.rateLimit(r, "login_2_"+ip+username, time.Hour, 20); err != nil {
 		return err
 	}
-	if err := s.rateLimit(r, "login_2_"+ip+username, time.Hour, 20); err != nil {
+
+	device := s.loginDevice(r, ip)
+	if err := s.throttleLogin(r, username, ip, device); err != nil {
 		return err
 	}
 
 	user, err := core.MatchLoginCredentials(r.ctx, s.db, username, password)
 	if err != nil {
+		if err == core.ErrWrongPassword {
+			s.recordLoginFailure(r, username, ip)
+		}
+		return err
+	}
+	if user.PasswordLoginDisabled {
+		return errPasswordLoginDisabled
+	}
+	if err := s.requirePasskeyLogin(r, user); err != nil {
 		return err
 	}
 
 	if err = s.loginUser(user, r.ses, w, r.req); err != nil {
 		return err
 	}
+	s.recordLogin(r, user, ip, device)
 
 	return w.writeJSON(user)
 }
@@ -177,10 +239,9 @@ func (s *Server) signup(w *responseWriter, r *request) error {
 	}
 
 	ip := httputil.GetIP(r.req)
-	if err := s.rateLimit(r, "signup_1_"+ip, time.Minute, 2); err != nil {
-		return err
-	}
-	if err := s.rateLimit(r, "signup_2_"+ip, time.Hour*6, 10); err != nil {
+	network := s.signupNetwork(r, ip)
+	quarantine, err := s.checkSignup(r, values, ip, network)
+	if err != nil {
 		return err
 	}
 
@@ -188,6 +249,9 @@ func (s *Server) signup(w *responseWriter, r *request) error {
 	if err != nil {
 		return err
 	}
+	if err := s.recordSignup(r, user, ip, network, quarantine); err != nil {
+		return err
+	}
 
 	// Try logging in user.
 	s.loginUser(user, r.ses, w, r.req)
@@ -214,6 +278,18 @@ func (s *Server) getLoggedInUser(w *responseWriter, r *request) error {
 	return w.writeJSON(user)
 }
 
+// /api/_onboarding [GET]
+func (s *Server) getOnboarding(w *responseWriter, r *request) error {
+	if !r.loggedIn {
+		return errNotLoggedIn
+	}
+	onboarding, err := core.GetOnboarding(r.ctx, s.db, *r.viewer)
+	if err != nil {
+		return err
+	}
+	return w.writeJSON(onboarding)
+}
+
 // /api/notifications [POST]
 func (s *Server) updateNotifications(w *responseWriter, r *request) error {
 	if !r.loggedIn {
@@ -251,6 +327,14 @@ func (s *Server) updateNotifications(w *responseWriter, r *request) error {
 }
 
 // /api/notifications [GET]
+// notificationsPage is a page of the notifications of a user.
+type notificationsPage struct {
+	Count    int                  `json:"count"`
+	NewCount int                  `json:"newCount"`
+	Items    []*core.Notification `json:"items"`
+	Next     string               `json:"next"`
+}
+
 func (s *Server) getNotifications(w *responseWriter, r *request) error {
 	if !r.loggedIn {
 		return errNotLoggedIn
@@ -261,12 +345,7 @@ func (s *Server) getNotifications(w *responseWriter, r *request) error {
 		return err
 	}
 
-	res := struct {
-		Count    int                  `json:"count"`
-		NewCount int                  `json:"newCount"`
-		Items    []*core.Notification `json:"items"`
-		Next     string               `json:"next"`
-	}{}
+	res := notificationsPage{}
 	if res.Count, err = core.NotificationsCount(r.ctx, s.db, user.ID); err != nil {
 		return err
 	}
@@ -276,6 +355,9 @@ func (s *Server) getNotifications(w *responseWriter, r *request) error {
 	if res.Items, res.Next, err = core.GetNotifications(r.ctx, s.db, user.ID, 10, query.Get("next")); err != nil {
 		return err
 	}
+	if err = core.PopulateNotifications(r.ctx, s.db, res.Items); err != nil {
+		return err
+	}
 
 	return w.writeJSON(res)
 }
@@ -383,13 +465,26 @@ func (s *Server) updateUserSettings(w *responseWriter, r *request) error {
 	query := r.urlQuery()
 	switch query.Get("action") {
 	case "updateProfile":
+		previousEmail := user.Email.String
 		if err = r.unmarshalJSONBody(&user); err != nil {
 			return err
 		}
+		if user.EmailPublic != nil && *user.EmailPublic != previousEmail {
+			if err := s.requireRecentAuth(r); err != nil {
+				return err
+			}
+		}
 
 		if err = user.Update(r.ctx); err != nil {
 			return err
 		}
+		if locale, _ := r.ses.Values[sessionLocale].(string); r.token == nil && locale != user.Locale {
+			r.ses.Values[sessionLocale] = user.Locale
+			if err = r.ses.Save(w, r.req); err != nil {
+				return err
+			}
+		}
+		purgeUser(r, user.ID)
 	case "changePassword":
 		values, err := r.unmarshalJSONBodyToStringsMap(true)
 		if err != nil {
@@ -399,11 +494,18 @@ func (s *Server) updateUserSettings(w *responseWriter, r *request) error {
 		newPassword := values["newPassword"]
 		repeatPassword := values["repeatPassword"]
 		if newPassword != repeatPassword {
-			return httperr.NewBadRequest("password_not_match", "Passwords do not match.")
+			return httperr.NewInvalidField("repeatPassword", "password_not_match", "Passwords do not match.")
 		}
 		if err = user.ChangePassword(r.ctx, password, newPassword); err != nil {
 			return err
 		}
+		if err = s.rotateSession(user, r.ses, w, r.req); err != nil {
+			return err
+		}
+		r.ses.Values[sessionAuthAt] = time.Now().Unix()
+		if err = r.ses.Save(w, r.req); err != nil {
+			return err
+		}
 	default:
 		return httperr.NewBadRequest("invalid_action", "Unsupported action.")
 	}
@@ -438,9 +540,12 @@ func (s *Server) handleUserProPic(w *responseWriter, r *request) error {
 	}
 
 	if r.req.Method == "POST" {
-		r.req.Body = http.MaxBytesReader(w, r.req.Body, int64(s.config.MaxImageSize)) // limit max upload size
-		if err := r.req.ParseMultipartForm(int64(s.config.MaxImageSize)); err != nil {
-			return httperr.NewBadRequest("file_size_exceeded", "Max file size exceeded.")
+		if err := r.parseMultipartForm(); err != nil {
+			return err
+		}
+		crop, err := parseCropRect(r)
+		if err != nil {
+			return err
 		}
 
 		file, _, err := r.req.FormFile("image")
@@ -453,7 +558,10 @@ func (s *Server) handleUserProPic(w *responseWriter, r *request) error {
 		if err != nil {
 			return err
 		}
-		if err := user.UpdateProPic(r.ctx, data); err != nil {
+		if err := s.checkStorageQuota(r, &user.ID, nil, int64(len(data))); err != nil {
+			return err
+		}
+		if err := user.UpdateProPic(r.ctx, data, crop); err != nil {
 			return err
 		}
 	} else if r.req.Method == "DELETE" {
@@ -461,10 +569,39 @@ func (s *Server) handleUserProPic(w *responseWriter, r *request) error {
 			return err
 		}
 	}
+	purgeUser(r, user.ID)
 
 	return w.writeJSON(user)
 }
 
+// parseCropRect returns the crop rectangle of an image upload, given in the
+// form fields cropX, cropY, cropWidth, and cropHeight (in pixels). If none of
+// them are present, it returns nil.
+func parseCropRect(r *request) (*images.CropRect, error) {
+	fields := []string{"cropX", "cropY", "cropWidth", "cropHeight"}
+	var values [4]int
+	var n int
+	for i, field := range fields {
+		v := r.req.FormValue(field)
+		if v == "" {
+			continue
+		}
+		x, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, httperr.NewBadRequest("invalid_crop", fmt.Sprintf("Invalid %s.", field))
+		}
+		values[i] = x
+		n++
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	if n != len(fields) {
+		return nil, httperr.NewBadRequest("invalid_crop", "All of cropX, cropY, cropWidth, and cropHeight are required to crop an image.")
+	}
+	return &images.CropRect{X: values[0], Y: values[1], Width: values[2], Height: values[3]}, nil
+}
+
 // /api/users/{username}/badges/{badgeId}[?byType=false] [DELETE]
 func (s *Server) deleteBadge(w *responseWriter, r *request) error {
 	if !r.loggedIn {
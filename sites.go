@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/discuitnet/discuit/config"
+	"github.com/discuitnet/discuit/server"
+)
+
+// A site is one of the sites served by the process (see config.Sites).
+type site struct {
+	conf   *config.Config
+	db     *sql.DB
+	hosts  []string // The hostnames of the site (none for the site of the process's config).
+	server *server.Server
+}
+
+// loadSites returns the site of conf and db, followed by the other sites of
+// conf, whose config files are parsed and whose databases are opened. The
+// sites must be isolated from each other: each must have a database, and a
+// Redis database, of its own.
+func loadSites(db *sql.DB, conf *config.Config) ([]*site, error) {
+	sites := []*site{{conf: conf, db: db}}
+	byPath := make(map[string]*site)
+	var hosts []string
+	for host := range conf.Sites {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		path := conf.Sites[host]
+		st := byPath[path]
+		if st == nil {
+			c, err := config.ParseSite(path)
+			if err != nil {
+				return nil, fmt.Errorf("site %s: %w", host, err)
+			}
+			// The image proxy is a setting of the process: each site
+			// serves /images/proxy if, and only if, the URLs of images
+			// are rewritten to it (see images.ProxyEnabled).
+			c.ImageProxy = conf.ImageProxy
+			c.ImageProxyMaxSize = conf.ImageProxyMaxSize
+			c.ImageProxyCacheTTL = conf.ImageProxyCacheTTL
+			st = &site{conf: c}
+			byPath[path] = st
+			sites = append(sites, st)
+		}
+		st.hosts = append(st.hosts, strings.ToLower(host))
+	}
+
+	databases, redisDBs := make(map[string]string), make(map[string]string)
+	for _, st := range sites {
+		name := strings.Join(st.hosts, ", ")
+		if name == "" {
+			name = "the default site"
+		}
+		if st.conf.DBDriver != conf.DBDriver {
+			return nil, fmt.Errorf("%s: all sites must use the same dbDriver (%s)", name, conf.DBDriver)
+		}
+		if other, ok := databases[st.conf.DBName]; ok {
+			return nil, fmt.Errorf("%s and %s have the same database", name, other)
+		}
+		databases[st.conf.DBName] = name
+		redisDB := fmt.Sprintf("%s/%d", st.conf.RedisAddress, st.conf.RedisDB)
+		if other, ok := redisDBs[redisDB]; ok {
+			return nil, fmt.Errorf("%s and %s have the same Redis database (set redisDB)", name, other)
+		}
+		redisDBs[redisDB] = name
+	}
+
+	for _, st := range sites[1:] {
+		st.db = openDatabase(st.conf)
+	}
+	return sites, nil
+}
+
+// requestHostname returns the hostname of the Host header host (without the
+// port), in lower case.
+func requestHostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}